@@ -0,0 +1,241 @@
+// Package client is a Go client for the GoFire HTTP server, so programs that control the
+// fireplace don't each have to reimplement the HTTP calls and response parsing.
+//
+//	c := client.New("http://firepi.lan:8600")
+//	if err := c.On(ctx); err == client.ErrBusy {
+//		// another relay operation is in progress; try again shortly
+//	}
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrBusy is returned when the server is already running a relay operation.
+	ErrBusy = errors.New("gofire: busy")
+	// ErrLockout is returned when ignition was refused by a lockout rule.
+	ErrLockout = errors.New("gofire: locked out")
+	// ErrDisabled is returned when the requested feature isn't configured on the server.
+	ErrDisabled = errors.New("gofire: disabled")
+)
+
+// ResponseError reports a response the client did not expect.
+type ResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("gofire: unexpected response %d %q", e.StatusCode, e.Body)
+}
+
+// Client talks to one GoFire server. The zero value is not usable; use New.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Token, if set, is sent as a bearer token, for servers with auth enabled.
+	Token string
+}
+
+// New returns a client for the server at baseURL, e.g. "http://firepi.lan:8600".
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTPClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// On ignites the fire.
+func (c *Client) On(ctx context.Context) error {
+	return c.command(ctx, "on")
+}
+
+// Off turns the fire off.
+func (c *Client) Off(ctx context.Context) error {
+	return c.command(ctx, "off")
+}
+
+// FlameUp raises the flame by one step.
+func (c *Client) FlameUp(ctx context.Context) error {
+	return c.command(ctx, "flameup")
+}
+
+// FlameDown lowers the flame by one step.
+func (c *Client) FlameDown(ctx context.Context) error {
+	return c.command(ctx, "flamedown")
+}
+
+// LightState is the ember light state reported by the server.
+type LightState struct {
+	On bool
+	// Brightness is 0-100; lights that can't dim report 100 when on.
+	Brightness int
+}
+
+// Light returns the current ember light state.
+func (c *Client) Light(ctx context.Context) (LightState, error) {
+	return c.light(ctx, url.Values{})
+}
+
+// SetLight switches the ember light on or off.
+func (c *Client) SetLight(ctx context.Context, on bool) (LightState, error) {
+	state := "off"
+	if on {
+		state = "on"
+	}
+	return c.light(ctx, url.Values{"state": {state}})
+}
+
+// SetLightBrightness dims the ember light to pct (0-100) over fade; a zero fade uses the
+// server's default.
+func (c *Client) SetLightBrightness(ctx context.Context, pct int, fade time.Duration) (LightState, error) {
+	q := url.Values{"brightness": {strconv.Itoa(pct)}}
+	if fade > 0 {
+		q.Set("fade", fade.String())
+	}
+	return c.light(ctx, q)
+}
+
+func (c *Client) light(ctx context.Context, q url.Values) (LightState, error) {
+	body, err := c.get(ctx, "/light?"+q.Encode())
+	if err != nil {
+		return LightState{}, err
+	}
+	switch {
+	case body == "light_disabled":
+		return LightState{}, ErrDisabled
+	case body == "light_off":
+		return LightState{}, nil
+	case body == "light_on":
+		return LightState{On: true, Brightness: 100}, nil
+	case strings.HasPrefix(body, "light_on "):
+		b, err := strconv.Atoi(strings.TrimPrefix(body, "light_on "))
+		if err != nil {
+			return LightState{}, &ResponseError{StatusCode: http.StatusOK, Body: body}
+		}
+		return LightState{On: true, Brightness: b}, nil
+	}
+	return LightState{}, &ResponseError{StatusCode: http.StatusOK, Body: body}
+}
+
+// Status is the fireplace's tracked state.
+type Status struct {
+	Power       string    `json:"power"`                 // on, off, pilot, or empty when unknown
+	FlameLevel  *int      `json:"flame_level,omitempty"` // estimated; nil when unknown
+	Levels      int       `json:"levels,omitempty"`      // flame levels above off
+	LastCommand string    `json:"last_command,omitempty"`
+	Since       time.Time `json:"since"` // when Power last changed
+	Uptime      string    `json:"uptime"`
+}
+
+// Status returns the fireplace's tracked state.
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	body, err := c.get(ctx, "/status")
+	if err != nil {
+		return Status{}, err
+	}
+	var st Status
+	if err := json.Unmarshal([]byte(body), &st); err != nil {
+		return Status{}, fmt.Errorf("gofire: %v", err)
+	}
+	return st, nil
+}
+
+// Backup copies an archive of the server's configuration and state files to w. It needs an
+// admin token on servers with auth enabled.
+func (c *Client) Backup(ctx context.Context, w io.Writer) error {
+	req, err := http.NewRequest(http.MethodGet, c.BaseURL+"/backup", nil)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		body := strings.TrimSpace(string(data))
+		if body == "backup_disabled" {
+			return ErrDisabled
+		}
+		return &ResponseError{StatusCode: resp.StatusCode, Body: body}
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// SensorReading is the latest reading of one sensor.
+type SensorReading struct {
+	Value float64   `json:"value"`
+	Raw   float64   `json:"raw"`
+	Unit  string    `json:"unit"`
+	Role  string    `json:"role,omitempty"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Sensors returns the latest reading of every sensor, keyed by sensor name.
+func (c *Client) Sensors(ctx context.Context) (map[string]SensorReading, error) {
+	body, err := c.get(ctx, "/sensors")
+	if err != nil {
+		return nil, err
+	}
+	var readings map[string]SensorReading
+	if err := json.Unmarshal([]byte(body), &readings); err != nil {
+		return nil, fmt.Errorf("gofire: %v", err)
+	}
+	return readings, nil
+}
+
+// command runs one of the plain-text relay endpoints, which answer "<op>_<result>".
+func (c *Client) command(ctx context.Context, op string) error {
+	body, err := c.get(ctx, "/"+op)
+	if err != nil {
+		return err
+	}
+	switch strings.TrimPrefix(body, op+"_") {
+	case "ok":
+		return nil
+	case "busy":
+		return ErrBusy
+	case "lockout":
+		return ErrLockout
+	}
+	return &ResponseError{StatusCode: http.StatusOK, Body: body}
+}
+
+func (c *Client) get(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	body := strings.TrimSpace(string(data))
+	if resp.StatusCode != http.StatusOK {
+		return "", &ResponseError{StatusCode: resp.StatusCode, Body: body}
+	}
+	return body, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/client"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/mdns"
+)
+
+// Exit codes of the client commands.
+const (
+	exitOK      = 0
+	exitError   = 1 // the server couldn't be reached or gave an unexpected reply
+	exitUsage   = 2
+	exitBusy    = 3
+	exitLockout = 4
+)
+
+// clientCommands are the subcommands that act as a client of a running server.
+var clientCommands = map[string]func(c *client.Client, ctx context.Context) error{
+	"on":        (*client.Client).On,
+	"off":       (*client.Client).Off,
+	"flameup":   (*client.Client).FlameUp,
+	"flamedown": (*client.Client).FlameDown,
+}
+
+// commandReply is what a client command prints.
+type commandReply struct {
+	Op     string `json:"op"`
+	Result string `json:"result"` // ok, busy, lockout or error
+	Error  string `json:"error,omitempty"`
+}
+
+// runClient runs the client command cmd with its arguments, printing JSON to stdout, and
+// returns the exit code.
+func runClient(cmd string, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gofire "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", envOr("GOFIRE_SERVER", "http://localhost:8600"), "GoFire server URL, or auto to find the one on the LAN; default $GOFIRE_SERVER or http://localhost:8600")
+	token := fs.String("token", os.Getenv("GOFIRE_TOKEN"), "Bearer token; default $GOFIRE_TOKEN")
+	timeout := fs.Duration("timeout", 30*time.Second, "How long to wait for the server")
+	var out *string
+	if cmd == "backup" {
+		out = fs.String("o", "", "File to write the archive to; default stdout")
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return exitUsage
+	}
+	enc := json.NewEncoder(stdout)
+	if cmd == "discover" {
+		servers, err := discover()
+		if err != nil {
+			fmt.Fprintf(stderr, "gofire discover: %v\n", err)
+			return exitError
+		}
+		for _, s := range servers {
+			enc.Encode(s)
+		}
+		return exitOK
+	}
+	if *server == "auto" {
+		servers, err := discover()
+		if err == nil && len(servers) != 1 {
+			err = fmt.Errorf("found %d servers on the LAN; pick one with -server", len(servers))
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "gofire %s: %v\n", cmd, err)
+			return exitError
+		}
+		*server = servers[0].URL
+	}
+	c := client.New(*server)
+	c.Token = *token
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if cmd == "backup" {
+		if err := fetchBackup(c, ctx, *out, stdout, *timeout); err != nil {
+			fmt.Fprintf(stderr, "gofire backup: %v\n", err)
+			return exitError
+		}
+		return exitOK
+	}
+	if cmd == "status" {
+		st, err := c.Status(ctx)
+		if err != nil {
+			fmt.Fprintf(stderr, "gofire status: %v\n", err)
+			return exitError
+		}
+		enc.Encode(st)
+		return exitOK
+	}
+	err := clientCommands[cmd](c, ctx)
+	reply, code := commandReply{Op: cmd, Result: "ok"}, exitOK
+	switch {
+	case err == nil:
+	case errors.Is(err, client.ErrBusy):
+		reply.Result, code = "busy", exitBusy
+	case errors.Is(err, client.ErrLockout):
+		reply.Result, code = "lockout", exitLockout
+	default:
+		reply.Result, reply.Error, code = "error", err.Error(), exitError
+	}
+	enc.Encode(reply)
+	return code
+}
+
+// fetchBackup fetches an archive of the server's configuration and state to the file out, or to
+// stdout when out is empty. The file is only replaced once the whole archive has arrived.
+func fetchBackup(c *client.Client, ctx context.Context, out string, stdout io.Writer, timeout time.Duration) error {
+	c.HTTPClient.Timeout = timeout // an archive with a long history takes a while
+	if out == "" {
+		return c.Backup(ctx, stdout)
+	}
+	return fileutil.WriteAtomicFunc(out, 0600, func(w io.Writer) error { return c.Backup(ctx, w) })
+}
+
+// discovered is a server gofire discover found.
+type discovered struct {
+	Name string            `json:"name"`
+	URL  string            `json:"url"`
+	TXT  map[string]string `json:"txt,omitempty"`
+}
+
+// discoverWait is how long gofire discover listens for servers.
+const discoverWait = 2 * time.Second
+
+// discover finds the servers advertising _gofire._tcp on the LAN (see discovery in the
+// configuration).
+func discover() ([]discovered, error) {
+	entries, err := mdns.Browse("_gofire._tcp", discoverWait)
+	if err != nil {
+		return nil, err
+	}
+	var out []discovered
+	for _, e := range entries {
+		u := url.URL{Scheme: "http", Host: strings.TrimSuffix(e.Host, "."), Path: strings.TrimSuffix(e.TXT["path"], "/")}
+		if e.TXT["tls"] == "1" {
+			u.Scheme = "https"
+		}
+		if len(e.Addrs) > 0 && u.Scheme == "http" {
+			u.Host = e.Addrs[0].String() // a certificate names the host rather than an address
+		}
+		u.Host = net.JoinHostPort(u.Host, strconv.Itoa(e.Port))
+		out = append(out, discovered{Name: e.Instance, URL: u.String(), TXT: e.TXT})
+	}
+	return out, nil
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/httpapi"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/systemd"
+	"github.com/barrylb/go-fire/internal/upgrade"
+	"github.com/barrylb/go-fire/internal/wear"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// gpioRetry is how often degraded mode tries the GPIO lines again.
+const gpioRetry = 30 * time.Second
+
+// openGPIO opens the GPIO chip, with the relay boards attached, and the fireplace, whose
+// driver requests the valve's lines.
+func openGPIO(cfg *config.Config, checkIgnition func() error, relayWear *wear.Counter) (*relay.Chip, fireplace.Fireplace, error) {
+	var chip *relay.Chip
+	var err error
+	if cfg.GPIOChip == "mock" {
+		chip = relay.OpenMock(func(format string, args ...interface{}) { logging.Logf(logging.Info, format, args...) })
+	} else if chip, err = relay.OpenChip(cfg.GPIOChip); err != nil {
+		return nil, nil, err
+	}
+	for name, b := range cfg.RelayBoards {
+		var board *relay.Chip
+		if cfg.GPIOChip == "mock" {
+			prefix := "relay board " + name + ": "
+			board = relay.OpenMock(func(format string, args ...interface{}) { logging.Logf(logging.Info, prefix+format, args...) })
+		} else if board, err = relay.OpenExpander(b.Expander, b.Device, b.Address, b.ActiveHigh); err != nil {
+			chip.Close()
+			return nil, nil, fmt.Errorf("relay_boards.%s: %v", name, err)
+		}
+		chip.Attach(name, board)
+	}
+	fire, err := openFireplace(cfg, chip, checkIgnition, relayWear)
+	if err != nil {
+		chip.Close()
+		return nil, nil, err
+	}
+	return chip, fire, nil
+}
+
+// serveDegraded serves httpapi.Degraded on lns after err, trying again every gpioRetry; once
+// retry succeeds GoFire restarts in place, as for an upgrade, passing on state.
+func serveDegraded(cfg *config.Config, lns []net.Listener, state upgrade.State, err error, retry func() error) {
+	logging.Event(logging.Err, "GPIO unavailable, serving diagnostics only", "error", err.Error(),
+		"retry", gpioRetry.String())
+	d := httpapi.NewDegraded(err)
+	d.Retrying(time.Now().Add(gpioRetry))
+	var servers []*http.Server
+	for _, l := range cfg.Listeners {
+		srv, err := serveOn(d.Handler(cfg.HTTP), l)
+		if err != nil {
+			panic(err)
+		}
+		servers = append(servers, srv)
+	}
+	for i, srv := range servers {
+		fmt.Printf("GoFire server (degraded) listening on %v\n", lns[i].Addr())
+		go func(srv *http.Server, ln net.Listener) {
+			if srv.TLSConfig != nil {
+				ln = tls.NewListener(ln, srv.TLSConfig)
+			}
+			srv.Serve(ln)
+		}(srv, lns[i])
+	}
+	ready := "READY=1\nSTATUS=GPIO unavailable: " + err.Error()
+	if upgrade.Inherited() {
+		ready = "MAINPID=" + strconv.Itoa(os.Getpid()) + "\n" + ready
+	}
+	if _, err := systemd.Notify(ready); err != nil {
+		logging.Logf(logging.Warning, "systemd: %v", err)
+	}
+	if i := systemd.WatchdogInterval(); i > 0 {
+		// diagnostics are what's wanted here, not a restart loop
+		fault.Go("watchdog", func() { systemd.Watchdog(i, func() error { return nil }) })
+	}
+	for {
+		time.Sleep(gpioRetry)
+		err := retry()
+		if err != nil {
+			d.Failed(err, time.Now().Add(gpioRetry))
+			logging.Logf(logging.Debug, "GPIO still unavailable: %v", err)
+			continue
+		}
+		logging.Event(logging.Notice, "GPIO available, restarting")
+		named := map[string]net.Listener{}
+		for i, l := range cfg.Listeners {
+			named[l.Name] = lns[i]
+		}
+		h, err := upgrade.Exec(named, 30*time.Second)
+		if err != nil {
+			d.Failed(fmt.Errorf("GPIO available, but restarting failed: %v", err), time.Now().Add(gpioRetry))
+			logging.Logf(logging.Err, "upgrade: %v", err)
+			continue
+		}
+		for _, srv := range servers {
+			srv.Shutdown(context.Background())
+		}
+		if err := h.Finish(state); err != nil {
+			logging.Logf(logging.Err, "upgrade: passing state: %v", err)
+		}
+		return
+	}
+}
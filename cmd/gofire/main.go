@@ -0,0 +1,1228 @@
+package main
+
+/*
+GoFire HTTP server for controlling Mertik Maxitrol GV60 via Raspberry Pi with relay board.
+
+Usage:
+
+	gofire [serve] [flags]                      run the server
+	gofire on|off|flameup|flamedown|status      send a command to a running server
+	gofire discover                             list the servers on the LAN
+	gofire backup -o FILE                       fetch an archive of a server's setup
+
+The server listens on -listen_on (default :8600) and reads the optional YAML configuration
+given with -config; gofire -h lists the other flags, which override the file. With no
+configuration it drives the valve's contacts 1, 2 and 3 from GPIO lines 26, 20 and 21 of
+gpiochip0, the Waveshare RPi Relay Board's channels, and serves the API, from /on, /off,
+/flameup and /flamedown to the web UI at /.
+
+The client commands take -server URL (default $GOFIRE_SERVER, else http://localhost:8600, or
+auto for the one server discover finds) and -token (default $GOFIRE_TOKEN), print the reply as
+JSON and exit 0 on success, 1 when the server can't be reached or gives an unexpected reply, 2
+on a usage error, 3 when busy and 4 when locked out.
+
+docs/manual.md describes every route, configuration setting and integration.
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/accessory"
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/alexa"
+	"github.com/barrylb/go-fire/internal/audit"
+	"github.com/barrylb/go-fire/internal/auth"
+	"github.com/barrylb/go-fire/internal/automation"
+	"github.com/barrylb/go-fire/internal/autooff"
+	"github.com/barrylb/go-fire/internal/backup"
+	"github.com/barrylb/go-fire/internal/bridge"
+	"github.com/barrylb/go-fire/internal/childlock"
+	"github.com/barrylb/go-fire/internal/clock"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/demand"
+	"github.com/barrylb/go-fire/internal/estop"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/frost"
+	"github.com/barrylb/go-fire/internal/gatt"
+	"github.com/barrylb/go-fire/internal/google"
+	"github.com/barrylb/go-fire/internal/heartbeat"
+	"github.com/barrylb/go-fire/internal/history"
+	"github.com/barrylb/go-fire/internal/homeassistant"
+	"github.com/barrylb/go-fire/internal/homekit"
+	"github.com/barrylb/go-fire/internal/hooks"
+	"github.com/barrylb/go-fire/internal/httpapi"
+	"github.com/barrylb/go-fire/internal/hue"
+	"github.com/barrylb/go-fire/internal/ignition"
+	"github.com/barrylb/go-fire/internal/interlock"
+	"github.com/barrylb/go-fire/internal/light"
+	"github.com/barrylb/go-fire/internal/lockout"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/mdns"
+	"github.com/barrylb/go-fire/internal/metrics"
+	"github.com/barrylb/go-fire/internal/modulate"
+	"github.com/barrylb/go-fire/internal/mqtt"
+	"github.com/barrylb/go-fire/internal/notify"
+	"github.com/barrylb/go-fire/internal/offtimer"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/presence"
+	"github.com/barrylb/go-fire/internal/presets"
+	"github.com/barrylb/go-fire/internal/privilege"
+	"github.com/barrylb/go-fire/internal/profiles"
+	"github.com/barrylb/go-fire/internal/proflame"
+	"github.com/barrylb/go-fire/internal/quiet"
+	"github.com/barrylb/go-fire/internal/ramp"
+	"github.com/barrylb/go-fire/internal/remote"
+	"github.com/barrylb/go-fire/internal/resync"
+	"github.com/barrylb/go-fire/internal/rules"
+	"github.com/barrylb/go-fire/internal/safemode"
+	"github.com/barrylb/go-fire/internal/selftest"
+	"github.com/barrylb/go-fire/internal/selfupdate"
+	"github.com/barrylb/go-fire/internal/sensor"
+	"github.com/barrylb/go-fire/internal/systemd"
+	"github.com/barrylb/go-fire/internal/thermostat"
+	"github.com/barrylb/go-fire/internal/tlscert"
+	"github.com/barrylb/go-fire/internal/upgrade"
+	"github.com/barrylb/go-fire/internal/usage"
+	"github.com/barrylb/go-fire/internal/wear"
+	"github.com/barrylb/go-fire/internal/webhooks"
+	"github.com/barrylb/go-fire/internal/zigbee"
+	"github.com/barrylb/go-fire/pkg/gv60"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// version is the release tag, set at build time; self-update installs any other tag.
+var version = "dev"
+
+func main() {
+	if len(os.Args) > 1 {
+		switch cmd := os.Args[1]; {
+		case cmd == "serve":
+			os.Args = append(os.Args[:1:1], os.Args[2:]...)
+		case cmd == "status" || cmd == "discover" || cmd == "backup" || clientCommands[cmd] != nil:
+			os.Exit(runClient(cmd, os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+	var listenAddr, configPath string
+	var lightMode string
+	var lightGPIO, lightPWMChip, lightPWMChannel, lightPWMHz int
+	var lightActiveHigh bool
+	var lightFade time.Duration
+	var gpioChip, valveGPIOs string
+	var valveActiveHigh, dryRun bool
+	var adminToken string
+	var tlsCert, tlsKey string
+	var driver, basePath string
+	var tlsSelfSigned bool
+	var logLevel, logFormat string
+	flag.StringVar(&listenAddr, "listen_on", ":8600", "Comma-separated listen addresses, host:port or unix:path; default :8600")
+	flag.StringVar(&configPath, "config", "", "Optional YAML configuration file")
+	flag.StringVar(&lightMode, "light_mode", "relay", "Light output: relay, softpwm or hwpwm")
+	flag.IntVar(&lightGPIO, "light_gpio", -1, "GPIO line for the optional ember/accent light; default -1 (disabled)")
+	flag.BoolVar(&lightActiveHigh, "light_active_high", false, "Light line is active-high (GPIO driven) rather than an active-low relay")
+	flag.IntVar(&lightPWMChip, "light_pwm_chip", 0, "sysfs pwmchip number for -light_mode=hwpwm")
+	flag.IntVar(&lightPWMChannel, "light_pwm_channel", 0, "sysfs PWM channel for -light_mode=hwpwm")
+	flag.IntVar(&lightPWMHz, "light_pwm_hz", 200, "PWM frequency for dimmable lights")
+	flag.DurationVar(&lightFade, "light_fade", time.Second, "Default fade time for dimmable lights")
+	flag.StringVar(&gpioChip, "gpio_chip", "", "GPIO chip of the relay lines, by name, /dev path or label; overrides gpio_chip")
+	flag.StringVar(&valveGPIOs, "valve_gpios", "", "Comma-separated GPIO lines of contacts 1, 2, 3, ...; overrides valve.gpios")
+	flag.BoolVar(&valveActiveHigh, "valve_active_high", false, "Relay board is active-high; overrides valve.active_high")
+	flag.BoolVar(&dryRun, "dry_run", false, "Log the relay sequences rather than actuate them; overrides valve.dry_run")
+	flag.StringVar(&driver, "driver", "", "relay, simulated or mock (relay sequences on a mock GPIO chip); overrides driver")
+	flag.StringVar(&tlsCert, "tls_cert", "", "PEM certificate to serve -listen_on over HTTPS")
+	flag.StringVar(&tlsKey, "tls_key", "", "PEM private key for -tls_cert")
+	flag.BoolVar(&tlsSelfSigned, "tls_self_signed", false, "Generate a self-signed -tls_cert and -tls_key on first run")
+	flag.StringVar(&logLevel, "log_level", "", "err, warning, notice, info or debug; overrides logging.level")
+	flag.StringVar(&logFormat, "log_format", "", "text or json; overrides logging.format")
+	flag.StringVar(&basePath, "base_path", "", "The prefix a reverse proxy serves the API under, e.g. /fireplace; overrides http.base_path")
+	flag.StringVar(&adminToken, "admin_token", "", "An admin token, added to auth.admin_tokens; GOFIRE_ADMIN_TOKEN keeps it out of ps")
+	flag.Parse()
+	//
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		panic(err)
+	}
+	// wiring flags given on the command line win over the config file, reloaded or not
+	overrides := func(cfg *config.Config) {
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "gpio_chip":
+				cfg.GPIOChip = gpioChip
+			case "valve_active_high":
+				cfg.Valve.ActiveHigh = valveActiveHigh
+			case "dry_run":
+				cfg.Valve.DryRun = dryRun
+			case "valve_gpios":
+				cfg.Valve.GPIOs = nil
+				for _, v := range strings.Split(valveGPIOs, ",") {
+					gpio, err := strconv.Atoi(strings.TrimSpace(v))
+					if err != nil {
+						panic(fmt.Errorf("-valve_gpios: %v", err))
+					}
+					cfg.Valve.GPIOs = append(cfg.Valve.GPIOs, gpio)
+				}
+			case "driver":
+				switch driver {
+				case "mock":
+					cfg.Driver, cfg.GPIOChip = "relay", "mock"
+				case "relay", "simulated":
+					cfg.Driver = driver
+				default:
+					panic(fmt.Errorf("-driver must be relay, simulated or mock, not %q", driver))
+				}
+			case "log_level":
+				cfg.Logging.Level = logLevel
+			case "log_format":
+				cfg.Logging.Format = logFormat
+			case "admin_token":
+				cfg.Auth.AdminTokens = append(cfg.Auth.AdminTokens, adminToken)
+			case "base_path":
+				if basePath != "" && !strings.HasPrefix(basePath, "/") {
+					panic(fmt.Errorf("-base_path must start with /, not %q", basePath))
+				}
+				cfg.HTTP.BasePath = basePath
+			}
+		})
+		if t := os.Getenv("GOFIRE_ADMIN_TOKEN"); t != "" {
+			cfg.Auth.AdminTokens = append(cfg.Auth.AdminTokens, t)
+		}
+		if len(cfg.Listeners) == 0 {
+			if (tlsCert != "" || tlsKey != "" || tlsSelfSigned) && (tlsCert == "" || tlsKey == "") {
+				panic("-tls_cert and -tls_key go together, and -tls_self_signed needs both")
+			}
+			for _, addr := range strings.Split(listenAddr, ",") {
+				l := config.Listener{Address: strings.TrimSpace(addr)}
+				if tlsCert != "" && !strings.HasPrefix(l.Address, "unix:") {
+					l.TLS = &config.ListenerTLS{CertFile: tlsCert, KeyFile: tlsKey, SelfSigned: tlsSelfSigned}
+				}
+				if err := config.CheckListener(&l); err != nil {
+					panic(err)
+				}
+				cfg.Listeners = append(cfg.Listeners, l)
+			}
+		}
+	}
+	overrides(cfg)
+	if err = logging.Setup(cfg.Logging); err != nil {
+		panic(err)
+	}
+	lns, err := listen(cfg.Listeners)
+	if err != nil {
+		panic(err)
+	}
+	// after an upgrade (SIGUSR2) the old process still holds the GPIO lines until it drains
+	state, err := upgrade.Takeover(30 * time.Second)
+	if err != nil {
+		panic(err)
+	}
+	var safe *safemode.Guard
+	if cfg.SafeMode != nil {
+		if safe, err = safemode.Check(*cfg.SafeMode, upgrade.Inherited()); err != nil {
+			panic(err)
+		}
+		// once cleared, restart in place as for an upgrade
+		safe.Restart = func() { syscall.Kill(os.Getpid(), syscall.SIGUSR2) }
+	}
+	sensors := sensor.NewRegistry(cfg.TemperatureUnit)
+	if err = sensor.Setup(sensors, cfg.Sensors); err != nil {
+		panic(err)
+	}
+	fault.Go("sensors", func() { sensors.Poll(cfg.Sensors.PollInterval) })
+	var outdoor, heating func() error
+	if cfg.Lockout.OutdoorMax != nil {
+		outdoor = lockout.Outdoor(sensors, *cfg.Lockout.OutdoorMax)
+	}
+	var il *interlock.Interlock
+	if cfg.Interlock != nil {
+		il = interlock.New(*cfg.Interlock)
+		heating = il.Check
+	}
+	checkIgnition := lockout.All(outdoor, heating)
+	//
+	var relayWear *wear.Counter
+	if cfg.Valve.Wear != nil && cfg.Driver == "relay" {
+		if relayWear, err = wear.Open(*cfg.Valve.Wear); err != nil {
+			panic(err)
+		}
+	}
+	chip, fire, err := openGPIO(cfg, checkIgnition, relayWear)
+	if err != nil {
+		serveDegraded(cfg, lns, state, err, func() error {
+			chip, _, err := openGPIO(cfg, checkIgnition, relayWear)
+			if err == nil {
+				chip.Close()
+			}
+			return err
+		})
+		return
+	}
+	defer chip.Close()
+	if cfg.Valve.DryRun {
+		logging.Event(logging.Warning, "dry run: relay sequences are logged, not actuated")
+	}
+	//
+	var lc *light.Controller
+	lightOut, err := light.Open(chip, lightMode, lightGPIO, lightActiveHigh, lightPWMChip, lightPWMChannel, lightPWMHz)
+	if err != nil {
+		panic(err)
+	}
+	if lightOut != nil {
+		lc = light.New(lightOut, lightFade)
+		if state.Light > 0 {
+			lc.Set("", state.Light, 0)
+		}
+	}
+	var accessories *accessory.Bank
+	if len(cfg.Accessories) > 0 {
+		if accessories, err = accessory.Open(chip, cfg.Accessories, cfg.Valve.ActiveHigh); err != nil {
+			panic(err)
+		}
+		accessories.Restore(state.Accessories)
+	}
+	hold := &automation.Hold{}
+	if state.HoldUntil.After(time.Now()) {
+		hold.Pause(state.HoldUntil, state.HoldReason)
+	}
+	//
+	store, err := history.Start(cfg.History, sensors)
+	if err != nil {
+		panic(err)
+	}
+	var trail *audit.Trail
+	if cfg.Audit != nil {
+		if trail, err = audit.Start(*cfg.Audit); err != nil {
+			panic(err)
+		}
+	}
+	if err = metrics.StartStatsd(cfg.Metrics.Statsd, sensors, lc); err != nil {
+		panic(err)
+	}
+	runner := &actions.Runner{Fire: fire, Light: lc, Timeouts: cfg.Valve.Timeouts}
+	if p := cfg.CommandPriority; p != nil {
+		overrides := map[string]actions.Priority{}
+		for source, name := range p.Sources {
+			if overrides[source], err = actions.ParsePriority(name); err != nil {
+				panic(fmt.Errorf("command_priority: %s: %v", source, err))
+			}
+		}
+		runner.Arbiter = actions.NewArbiter(p.Latch, overrides)
+	}
+	var childLock *childlock.Lock
+	if cfg.Lock != nil {
+		if childLock, err = childlock.New(*cfg.Lock); err != nil {
+			panic(err)
+		}
+		runner.Guards = append(runner.Guards, childLock.Guard)
+	}
+	stops, err := estop.Open(cfg.EStop)
+	if err != nil {
+		panic(err)
+	}
+	if accessories != nil {
+		accessories.Guards = append(accessories.Guards, stops.Guard(""))
+	}
+	var peak *demand.Signal
+	if cfg.DemandResponse != nil {
+		peak = demand.New(*cfg.DemandResponse)
+		runner.Guards = append(runner.Guards, peak.Guard)
+	}
+	var inputs *interlock.Inputs
+	if len(cfg.Interlocks) > 0 {
+		inputs = interlock.NewInputs(cfg.Interlocks)
+		runner.Guards = append(runner.Guards, inputs.Guard)
+	}
+	fireplaces, err := openFireplaces(cfg, chip, checkIgnition, relayWear, runner.Guards, stops)
+	if err != nil {
+		panic(err)
+	}
+	if inputs != nil {
+		runners := []*actions.Runner{runner}
+		for _, f := range fireplaces {
+			runners = append(runners, f.Actions)
+		}
+		if err = inputs.Start(chip, runners...); err != nil {
+			panic(err)
+		}
+	}
+	runner.Guards = append(runner.Guards, stops.Guard(""))
+	if cfg.Valve.Debounce > 0 {
+		// last, so only commands about to run count as the last one
+		runner.Guards = append(runner.Guards, actions.NewDebounce(cfg.Valve.Debounce).Guard)
+	}
+	fireState := power.Start(cfg.Startup, flameLevels(cfg), sensors, power.Inherited{Power: state.Power, Level: state.FlameLevel, Since: state.PowerSince})
+	metrics.RegisterGauge("power.on", func() float64 {
+		if fireState.State().Power == "on" {
+			return 1
+		}
+		return 0
+	})
+	metrics.RegisterGauge("flame.level", func() float64 {
+		if l := fireState.State().FlameLevel; l != nil {
+			return float64(*l)
+		}
+		return 0
+	})
+	metrics.RegisterCounter("power.burn_seconds", func() float64 { return fireState.BurnTime().Seconds() })
+	var meter *usage.Meter
+	if cfg.Usage != nil {
+		if meter, err = usage.Open(*cfg.Usage, fireState); err != nil {
+			panic(err)
+		}
+	}
+	var webHooks *webhooks.Notifier
+	if cfg.Webhooks != nil {
+		if webHooks, err = webhooks.Start(*cfg.Webhooks, fireState); err != nil {
+			panic(err)
+		}
+	}
+	if cfg.Notifications != nil {
+		notifier, err := notify.Start(*cfg.Notifications)
+		if err != nil {
+			panic(err)
+		}
+		// known at start only from startup.state restore; an upgrade is no restart
+		if fireState.State().Power == "on" && !upgrade.Inherited() {
+			notifier.Notify("restarted_lit")
+		}
+	}
+	if cfg.Hooks != nil {
+		if _, err := hooks.Start(*cfg.Hooks); err != nil {
+			panic(err)
+		}
+	}
+	var beats *heartbeat.Monitor
+	if cfg.Heartbeat != nil {
+		beats = heartbeat.Start(*cfg.Heartbeat, fireState, runner)
+	}
+	flameCtl, err := flame.New(cfg.Valve, fire, runner, fireState)
+	if err != nil {
+		panic(err)
+	}
+	var autoOff *autooff.Timer
+	if !cfg.AutoOff.Disabled {
+		autoOff = autooff.Start(cfg.AutoOff, fireState, runner)
+	}
+	var igniter *ignition.Verifier
+	if cfg.Ignition != nil {
+		igniter = ignition.Start(*cfg.Ignition, sensors, runner)
+	}
+	var home *presence.Detector
+	if cfg.Presence != nil {
+		if home, err = presence.Start(*cfg.Presence, cfg.Sensors.BLE.Adapter, fireState, runner); err != nil {
+			panic(err)
+		}
+	}
+	var quietHours *quiet.Hours
+	if cfg.QuietHours != nil {
+		if quietHours, err = quiet.Start(*cfg.QuietHours, cfg.Schedule.Timezone, fireState, runner, flameCtl); err != nil {
+			panic(err)
+		}
+		// first, ahead of the debounce, which must stay last
+		runner.Guards = append([]func(op, source string) error{quietHours.Guard}, runner.Guards...)
+	}
+	ramper := ramp.New(fireState, runner, flameCtl)
+	modulator := modulate.New(cfg.Modulation, fireState, runner, flameCtl)
+	if (cfg.Startup.SendOff || safe.Active()) && !upgrade.Inherited() {
+		logging.Event(logging.Notice, "sending off at start", "result", runner.Run("off", "startup"))
+	}
+	resyncer := resync.New(fireState, runner, flameCtl, cfg.Valve.ResyncAfterOn)
+	if cfg.Startup.Resync && !upgrade.Inherited() {
+		if err := resyncer.Start("startup", false); err != nil {
+			logging.Event(logging.Notice, "not resyncing the flame level at start", "error", err.Error())
+		}
+	}
+	tester := selfTester(cfg, chip, fire, fireState, fireplaces, stops)
+	if cfg.SelfTest.AtStart != "" && !upgrade.Inherited() {
+		if tester == nil {
+			panic(fmt.Errorf("self_test.at_start needs a valve driven by relays"))
+		}
+		fault.Go("selftest", func() {
+			rep, err := tester.Run(context.Background(), cfg.SelfTest.AtStart == "click", "startup")
+			switch {
+			case err != nil:
+				logging.Logf(logging.Warning, "relay self-test at start: %v", err)
+			case rep.OK:
+				logging.Event(logging.Notice, "relay self-test passed", "clicked", strconv.FormatBool(rep.Clicked))
+			default:
+				logging.Event(logging.Err, "relay self-test failed; see /selftest")
+			}
+		})
+	}
+	var scenes *presets.Store
+	if cfg.Presets != nil {
+		if scenes, err = presets.Open(*cfg.Presets, runner, fireState, flameCtl); err != nil {
+			panic(err)
+		}
+	}
+	var broker *mqtt.Client
+	if !safe.Active() {
+		if broker, err = startIntegrations(cfg, chip, runner, il, peak, fireState, lc, accessories, sensors, scenes); err != nil {
+			panic(err)
+		}
+	}
+	//
+	tokens, err := auth.New(cfg.Auth)
+	if err != nil {
+		panic(err)
+	}
+	var ruleClock clock.Clock = clock.Real{}
+	var simClock *clock.Sim
+	if sim := cfg.Simulation; sim != nil {
+		start := time.Now()
+		if sim.Start != "" {
+			start, _ = time.Parse(time.RFC3339, sim.Start)
+		}
+		simClock = clock.NewSim(start)
+		ruleClock = simClock
+	}
+	var ruleEngine *rules.Engine
+	if cfg.RulesFile != "" && !safe.Active() {
+		if ruleEngine, err = rules.Start(cfg.Schedule, cfg.RulesFile, ruleClock, runner, sensors, hold, fireState); err != nil {
+			panic(err)
+		}
+	}
+	var thermo *thermostat.Thermostat
+	if cfg.Thermostat != nil && !safe.Active() {
+		thermo = thermostat.Start(*cfg.Thermostat, state.Setpoint, runner, sensors, hold, fireState, modulator)
+	}
+	var frostGuard *frost.Guard
+	if cfg.FrostProtection != nil && !safe.Active() {
+		frostGuard = frost.Start(*cfg.FrostProtection, runner, sensors, fireState)
+	}
+	var userProfiles *profiles.Store
+	if cfg.Auth.ProfilesFile != "" {
+		if userProfiles, err = profiles.Open(cfg.Auth.ProfilesFile); err != nil {
+			panic(err)
+		}
+	}
+	// everything needing root (GPIO, raw BLE sockets, low ports) is open by now
+	if cfg.RunAs != "" {
+		if err = privilege.Drop(cfg.RunAs); err != nil {
+			panic(err)
+		}
+	} else if os.Geteuid() == 0 {
+		logging.Logf(logging.Notice, "running as root; set run_as to serve as an unprivileged user")
+	}
+	var updater *selfupdate.Updater
+	if cfg.SelfUpdate != nil {
+		// the new binary is started through the zero-downtime upgrade path
+		restart := func() { syscall.Kill(os.Getpid(), syscall.SIGUSR2) }
+		if updater, err = selfupdate.New(*cfg.SelfUpdate, version, restart); err != nil {
+			panic(err)
+		}
+	}
+	api := &httpapi.Server{
+		Fire: fire, Chip: chip, Light: lc, Accessories: accessories, Sensors: sensors, History: store, Auth: tokens, Actions: runner,
+		Profiles: userProfiles, IgnitionPIN: cfg.Lockout.PIN, ConfirmIgnition: cfg.Lockout.Confirm, Busy: cfg.HTTP.Busy,
+		Events: cfg.HTTP.Events, SwaggerUI: cfg.HTTP.SwaggerUI,
+		Power: fireState, Hold: hold, Rules: ruleEngine, Demand: peak, Clock: simClock, Wear: relayWear,
+		SafeMode: safe, Heartbeat: beats, Updater: updater, Thermostat: thermo, Frost: frostGuard, AutoOff: autoOff,
+		Flame: flameCtl, Fireplaces: fireplaces, Interlocks: inputs, Ignition: igniter, Usage: meter, Webhooks: webHooks, Presets: scenes,
+		Presence: home, Quiet: quietHours, Ramp: ramper, Modulate: modulator, Resync: resyncer, Audit: trail, Lock: childLock, EStop: stops, SelfTest: tester,
+		Timer: offtimer.New(runner),
+	}
+	rl := &reloader{path: configPath, overrides: overrides, started: cfg, cfg: cfg, fire: fire, power: fireState,
+		fireplaces: fireplaces, tokens: tokens, rules: ruleEngine, broker: broker}
+	api.Reload = func(ctx context.Context) (httpapi.Reloaded, error) { return rl.reload(ctx, "http") }
+	var restored int32 // once a restore has replaced the state files, they aren't saved over
+	if configPath != "" {
+		api.Backup = backup.New(configPath, cfg, version)
+		api.Backup.Flush = func() {
+			if relayWear != nil {
+				if err := relayWear.Save(); err != nil {
+					logging.Logf(logging.Err, "backup: saving relay wear: %v", err)
+				}
+			}
+			if meter != nil {
+				if err := meter.Save(); err != nil {
+					logging.Logf(logging.Err, "backup: saving usage: %v", err)
+				}
+			}
+		}
+		// restart in place as for an upgrade, so that the restored setup is read afresh
+		api.Backup.Restart = func() {
+			atomic.StoreInt32(&restored, 1)
+			syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+		}
+	}
+	if cfg.Google != nil && !safe.Active() {
+		api.Google = google.New(*cfg.Google, runner, fireState, flameCtl, cfg.Lockout.PIN)
+	}
+	if cfg.Alexa != nil && !safe.Active() {
+		api.Alexa = alexa.New(*cfg.Alexa, runner, fireState, flameCtl)
+	}
+	var servers []*http.Server
+	for _, l := range cfg.Listeners {
+		srv, err := newServer(api, cfg, l)
+		if err != nil {
+			panic(err)
+		}
+		srv.RegisterOnShutdown(api.CloseStreams)
+		servers = append(servers, srv)
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGHUP)
+		for range sig {
+			systemd.Notify("RELOADING=1")
+			rl.reload(context.Background(), "sighup")
+			systemd.Notify("READY=1")
+		}
+	}()
+	handedOver := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGUSR2)
+		for range sig {
+			named := map[string]net.Listener{}
+			for i, l := range cfg.Listeners {
+				named[l.Name] = lns[i]
+			}
+			h, err := upgrade.Exec(named, 30*time.Second)
+			if err != nil {
+				logging.Logf(logging.Err, "upgrade: %v", err)
+				continue
+			}
+			logging.Logf(logging.Notice, "upgrade: new process ready, draining")
+			for _, srv := range servers {
+				srv.Shutdown(context.Background())
+			}
+			for _, f := range allFires(fire, fireplaces) {
+				f.Drain()
+			}
+			if relayWear != nil && atomic.LoadInt32(&restored) == 0 {
+				if err := relayWear.Save(); err != nil {
+					logging.Logf(logging.Err, "upgrade: saving relay wear: %v", err)
+				}
+			}
+			if meter != nil && atomic.LoadInt32(&restored) == 0 {
+				if err := meter.Save(); err != nil {
+					logging.Logf(logging.Err, "upgrade: saving usage: %v", err)
+				}
+			}
+			hs := hold.State()
+			in := fireState.Inherit()
+			state := upgrade.State{HoldUntil: hs.Until, HoldReason: hs.Reason, Power: in.Power, FlameLevel: in.Level, PowerSince: in.Since}
+			if lc != nil {
+				state.Light = lc.Target()
+			}
+			if accessories != nil {
+				state.Accessories = accessories.State()
+			}
+			if thermo != nil {
+				state.Setpoint = thermo.Target()
+			}
+			if err := h.Finish(state); err != nil {
+				logging.Logf(logging.Err, "upgrade: passing state: %v", err)
+			}
+			close(handedOver)
+			return
+		}
+	}()
+	stopped := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		s := <-sig
+		signal.Reset(syscall.SIGINT, syscall.SIGTERM) // a second signal stops at once
+		logging.Logf(logging.Notice, "%v: shutting down", s)
+		systemd.Notify("STOPPING=1")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		for _, srv := range servers {
+			if err := srv.Shutdown(ctx); err != nil {
+				logging.Logf(logging.Warning, "shutdown: %v; closing remaining connections", err)
+				srv.Close()
+			}
+		}
+		drainFires(allFires(fire, fireplaces), shutdownTimeout)
+		if relayWear != nil {
+			if err := relayWear.Save(); err != nil {
+				logging.Logf(logging.Err, "shutdown: saving relay wear: %v", err)
+			}
+		}
+		if meter != nil {
+			if err := meter.Save(); err != nil {
+				logging.Logf(logging.Err, "shutdown: saving usage: %v", err)
+			}
+		}
+		close(stopped)
+	}()
+	if cfg.Discovery != nil {
+		if err := advertise(cfg, lns, fire, fireState.Levels().Max, tokens.Enabled()); err != nil {
+			panic(err)
+		}
+	}
+	errc := make(chan error, len(servers))
+	for i, srv := range servers {
+		fmt.Printf("GoFire server listening on %v\n", lns[i].Addr())
+		go func(srv *http.Server, ln net.Listener) {
+			if srv.TLSConfig != nil {
+				// the raw listener is kept for upgrades; TLS is layered on for serving only
+				ln = tls.NewListener(ln, srv.TLSConfig)
+			}
+			errc <- srv.Serve(ln)
+		}(srv, lns[i])
+	}
+	// with Type=notify, systemd waits for this; the process an upgrade starts becomes the
+	// main one, for which the unit needs NotifyAccess=all
+	ready := "READY=1"
+	if upgrade.Inherited() {
+		ready = "MAINPID=" + strconv.Itoa(os.Getpid()) + "\n" + ready
+	}
+	if _, err := systemd.Notify(ready); err != nil {
+		logging.Logf(logging.Warning, "systemd: %v", err)
+	}
+	if d := systemd.WatchdogInterval(); d > 0 {
+		fault.Go("watchdog", func() { systemd.Watchdog(d, func() error { return api.Healthy(d / 4) }) })
+	}
+	for range servers {
+		if err := <-errc; err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}
+	select {
+	case <-handedOver:
+	case <-stopped:
+		// the deferred chip.Close opens every relay channel before the lines are released
+		logging.Logf(logging.Notice, "shutdown: relays drained, exiting")
+	}
+}
+
+// shutdownTimeout bounds each step of a shutdown on SIGINT or SIGTERM: finishing in-flight
+// requests, then the running relay sequences.
+const shutdownTimeout = 10 * time.Second
+
+// allFires returns the main fireplace's driver and those of the other fireplaces.
+func allFires(fire fireplace.Fireplace, fireplaces map[string]*httpapi.Fireplace) []fireplace.Fireplace {
+	fires := []fireplace.Fireplace{fire}
+	for _, fp := range fireplaces {
+		fires = append(fires, fp.Fire)
+	}
+	return fires
+}
+
+// drainFires waits up to timeout for the running relay sequences to finish, then cuts them
+// short, and leaves the drivers refusing new ones.
+func drainFires(fires []fireplace.Fireplace, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, f := range fires {
+		wg.Add(1)
+		go func(f fireplace.Fireplace) {
+			f.Drain()
+			wg.Done()
+		}(f)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logging.Logf(logging.Warning, "shutdown: relay sequences still running after %v, cancelling them", timeout)
+		for _, f := range fires {
+			f.Cancel()
+		}
+		<-done
+	}
+}
+
+// newServer returns the HTTP server for one listener: the API, with TLS if configured, or
+// a redirect to the first TLS listener.
+func newServer(api *httpapi.Server, cfg *config.Config, l config.Listener) (*http.Server, error) {
+	if l.Redirect {
+		for _, t := range cfg.Listeners {
+			if t.TLS == nil {
+				continue
+			}
+			_, port, err := net.SplitHostPort(t.Address)
+			if err != nil {
+				return nil, err
+			}
+			return &http.Server{Handler: httpapi.RedirectHandler(port, cfg.HTTP.ACMEChallengeDir)}, nil
+		}
+		return nil, fmt.Errorf("listener %s: redirect needs a TLS listener", l.Name)
+	}
+	handler, err := api.Handler(cfg.HTTP, l.Routes)
+	if err != nil {
+		return nil, err
+	}
+	if l.Trusted {
+		handler = httpapi.Trusted(handler)
+	}
+	return serveOn(handler, l)
+}
+
+// serveOn returns the HTTP server of handler for listener l, with TLS if configured.
+func serveOn(handler http.Handler, l config.Listener) (*http.Server, error) {
+	srv := &http.Server{Handler: handler}
+	if l.TLS != nil {
+		cert, err := tlscert.Load(*l.TLS)
+		if err != nil {
+			return nil, err
+		}
+		// h2 is offered for the gRPC service, which needs HTTP/2
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12,
+			NextProtos: []string{"h2", "http/1.1"}}
+	}
+	return srv, nil
+}
+
+// startIntegrations starts the remotes, Hue, MQTT (zigbee2mqtt buttons, sensor feeds, the
+// heating interlock, demand response, the Home Assistant device), BLE GATT and HomeKit
+// integrations, and returns the MQTT client if there is one; none of them run in safe mode.
+func startIntegrations(cfg *config.Config, chip *relay.Chip, runner *actions.Runner, il *interlock.Interlock, peak *demand.Signal,
+	fireState *power.Tracker, lc *light.Controller, accessories *accessory.Bank, sensors *sensor.Registry,
+	scenes *presets.Store) (*mqtt.Client, error) {
+	if err := remote.Start(cfg.Remotes, runner); err != nil {
+		return nil, err
+	}
+	if err := hue.Start(cfg.Hue, runner); err != nil {
+		return nil, err
+	}
+	broker := mqtt.Connect(cfg.MQTT)
+	for _, f := range cfg.Sensors.Feeds {
+		if f.Topic == "" {
+			continue
+		}
+		name := f.Name
+		broker.Subscribe(f.Topic, func(_ string, payload []byte) {
+			v, err := sensor.ParseFeed(payload)
+			if err == nil {
+				err = sensors.Push(name, v)
+			}
+			if err != nil {
+				logging.Logf(logging.Debug, "sensor feed %s: %v", name, err)
+			}
+		})
+	}
+	if err := zigbee.Start(cfg.ZigbeeButtons, broker, runner); err != nil {
+		return nil, err
+	}
+	if il != nil {
+		if err := il.Start(chip, broker, runner); err != nil {
+			return nil, err
+		}
+	}
+	if peak != nil {
+		if err := peak.Start(broker, runner); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.MQTT.Device != nil {
+		homeassistant.Start(*cfg.MQTT.Device, broker, runner, fireState, lc, accessories, sensors, scenes)
+	}
+	if cfg.GATT != nil {
+		if err := gatt.Start(*cfg.GATT, runner); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.HomeKit != nil {
+		if err := homekit.Start(*cfg.HomeKit, version, runner, fireState, accessories, scenes); err != nil {
+			return nil, err
+		}
+	}
+	return broker, nil
+}
+
+// openFireplace starts the configured fireplace driver.
+// relayWear, if not nil, counts the contact relays' actuations.
+func openFireplace(cfg *config.Config, chip *relay.Chip, checkIgnition func() error, relayWear *wear.Counter) (fireplace.Fireplace, error) {
+	var wait time.Duration
+	if m := cfg.HTTP.Busy.Mode; m == "wait" || m == "queue" {
+		wait = cfg.HTTP.Busy.Timeout
+	}
+	if cfg.Valve.DryRun && (cfg.Driver == "bridge" || cfg.Driver == "proflame") {
+		return nil, fmt.Errorf("valve.dry_run needs the relay or simulated driver, not %s", cfg.Driver)
+	}
+	switch cfg.Driver {
+	case "simulated":
+		return &fireplace.Simulated{CheckIgnition: checkIgnition}, nil
+	case "bridge":
+		d, err := bridge.New(*cfg.Bridge)
+		if err != nil {
+			return nil, err
+		}
+		d.CheckIgnition, d.Wait = checkIgnition, wait
+		return d, nil
+	case "proflame":
+		return openProflame(*cfg.Proflame, chip, checkIgnition, wait)
+	}
+	// one relay channel per valve contact; the default lines are those of the Waveshare RPi
+	// Relay Board, https://www.waveshare.com/wiki/RPi_Relay_Board
+	return openRelays(cfg.Valve, chip, "", checkIgnition, relayWear, wait)
+}
+
+// openRelays drives a valve with its profile over valve.GPIOs of valve.Board. Its contacts
+// are named contact1, contact2, ... after prefix, for metrics, logs and relayWear if not nil.
+func openRelays(valve config.Valve, chip *relay.Chip, prefix string, checkIgnition func() error, relayWear *wear.Counter, wait time.Duration) (*fireplace.Relays, error) {
+	board, err := chip.Board(valve.Board)
+	if err != nil {
+		return nil, err
+	}
+	var contacts []gv60.RelayDriver
+	for i, gpio := range valve.GPIOs {
+		l, err := board.Channel(gpio, valve.ActiveHigh)
+		if err != nil {
+			return nil, err
+		}
+		name := prefix + "contact" + strconv.Itoa(i+1)
+		l = metrics.CountErrors(name, l)
+		l = tracedLine{Line: l, name: name, board: valve.Board, gpio: gpio}
+		if relayWear != nil {
+			l = relayWear.Wrap(name, l)
+			metrics.RegisterGauge("relay."+name+".actuations", func() float64 { return float64(relayWear.Count(name)) })
+		}
+		contacts = append(contacts, l)
+		fault.Register(l)
+	}
+	profile, err := valveProfile(valve)
+	if err != nil {
+		return nil, err
+	}
+	c, err := gv60.NewProfile(profile, contacts...)
+	if err != nil {
+		return nil, err
+	}
+	c.CheckIgnition, c.Wait = checkIgnition, wait
+	c.DryRun = valve.DryRun
+	c.Logf = func(format string, args ...interface{}) {
+		if prefix != "" {
+			format = strings.TrimSuffix(prefix, ".") + ": " + format
+		}
+		logging.Logf(logging.Notice, format, args...)
+	}
+	return fireplace.NewRelays(c), nil
+}
+
+// openProflame drives a Proflame receiver through the transmitter on p.GPIO.
+func openProflame(p config.Proflame, chip *relay.Chip, checkIgnition func() error, wait time.Duration) (*proflame.Driver, error) {
+	tx, err := chip.Output(p.GPIO, 0)
+	if err != nil {
+		return nil, err
+	}
+	d, err := proflame.New(tx, p)
+	if err != nil {
+		return nil, err
+	}
+	d.CheckIgnition, d.Wait = checkIgnition, wait
+	return d, nil
+}
+
+// openFireplaces starts the further fireplaces of config fireplaces, each with a runner
+// named after it sharing guards (bar the debounce, which each gets its own of) and a
+// tracker of its own.
+func openFireplaces(cfg *config.Config, chip *relay.Chip, checkIgnition func() error, relayWear *wear.Counter, guards []func(op, source string) error, stops *estop.Latch) (map[string]*httpapi.Fireplace, error) {
+	if len(cfg.Fireplaces) > 0 && cfg.Driver == "bridge" {
+		return nil, fmt.Errorf("fireplaces need the relay, proflame or simulated driver, not %s", cfg.Driver)
+	}
+	var wait time.Duration
+	if m := cfg.HTTP.Busy.Mode; m == "wait" || m == "queue" {
+		wait = cfg.HTTP.Busy.Timeout
+	}
+	out := map[string]*httpapi.Fireplace{}
+	for name, f := range cfg.Fireplaces {
+		valve := cfg.Valve
+		valve.GPIOs, valve.Board, valve.Profile = f.GPIOs, f.Board, f.Profile
+		var fire fireplace.Fireplace = &fireplace.Simulated{CheckIgnition: checkIgnition}
+		levels := power.Levels{Max: valve.Levels, Up: 1, Down: 1}
+		switch {
+		case f.Driver == "proflame":
+			if cfg.Valve.DryRun && cfg.Driver != "simulated" {
+				return nil, fmt.Errorf("fireplaces.%s: valve.dry_run needs the relay driver, not proflame", name)
+			}
+			levels.Max = f.Proflame.Levels
+			if cfg.Driver != "simulated" {
+				d, err := openProflame(*f.Proflame, chip, checkIgnition, wait)
+				if err != nil {
+					return nil, fmt.Errorf("fireplaces.%s: %v", name, err)
+				}
+				fire = d
+			}
+		case cfg.Driver != "simulated":
+			c, err := openRelays(valve, chip, name+".", checkIgnition, relayWear, wait)
+			if err != nil {
+				return nil, fmt.Errorf("fireplaces.%s: %v", name, err)
+			}
+			fire, levels = c, relayLevels(valve)
+		}
+		runner := &actions.Runner{Fire: fire, Name: name, Timeouts: cfg.Valve.Timeouts,
+			Guards: append(guards[:len(guards):len(guards)], stops.Guard(name))}
+		if cfg.Valve.Debounce > 0 {
+			runner.Guards = append(runner.Guards, actions.NewDebounce(cfg.Valve.Debounce).Guard)
+		}
+		out[name] = &httpapi.Fireplace{Fire: fire, Actions: runner, Power: power.StartNamed(name, levels)}
+	}
+	return out, nil
+}
+
+// selfTester returns the self-test of every valve driven by relays, or nil without any.
+func selfTester(cfg *config.Config, chip *relay.Chip, fire fireplace.Fireplace, fireState *power.Tracker, fireplaces map[string]*httpapi.Fireplace, stops *estop.Latch) *selftest.Tester {
+	var valves []selftest.Valve
+	if r, ok := fire.(*fireplace.Relays); ok {
+		valves = append(valves, selftest.Valve{Fire: r.Controller, Board: cfg.Valve.Board, GPIOs: cfg.Valve.GPIOs, Power: fireState,
+			Guard: stops.Guard("")})
+	}
+	var names []string
+	for name := range fireplaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fp := fireplaces[name]
+		if r, ok := fp.Fire.(*fireplace.Relays); ok {
+			f := cfg.Fireplaces[name]
+			valves = append(valves, selftest.Valve{Name: name, Fire: r.Controller, Board: f.Board, GPIOs: f.GPIOs, Power: fp.Power,
+				Guard: stops.Guard(name)})
+		}
+	}
+	if len(valves) == 0 {
+		return nil
+	}
+	return selftest.New(chip, cfg.SelfTest.Pulse, valves...)
+}
+
+// tracedLine logs each write to a contact's line at debug level.
+type tracedLine struct {
+	relay.Line
+	name  string
+	board string // empty for the GPIO chip
+	gpio  int
+}
+
+func (l tracedLine) SetValue(value int) error {
+	err := l.Line.SetValue(value)
+	kv := []string{"line", l.name, "gpio", strconv.Itoa(l.gpio), "value", strconv.Itoa(value)}
+	if l.board != "" {
+		kv = append(kv, "board", l.board)
+	}
+	if err != nil {
+		kv = append(kv, "error", err.Error())
+	}
+	logging.Event(logging.Debug, "gpio write", kv...)
+	return err
+}
+
+// flameLevels says how each driver's flame commands move the estimated flame level: for the
+// relays, by how long the profile holds the flame contacts against the valve's full travel.
+func flameLevels(cfg *config.Config) power.Levels {
+	switch cfg.Driver {
+	case "relay":
+		return relayLevels(cfg.Valve)
+	case "proflame":
+		return power.Levels{Max: cfg.Proflame.Levels, Up: 1, Down: 1}
+	}
+	return power.Levels{Max: cfg.Valve.Levels, Up: 1, Down: 1}
+}
+
+// relayLevels is flameLevels for a valve driven by relays.
+func relayLevels(valve config.Valve) power.Levels {
+	p, err := valveProfile(valve)
+	if err != nil {
+		return power.Levels{Max: valve.Levels, Up: 1, Down: 1}
+	}
+	per := float64(valve.Levels) / float64(valve.Travel)
+	return power.Levels{Max: valve.Levels, Up: float64(p.FlameUp.Hold) * per, Down: float64(p.FlameDown.Hold) * per}
+}
+
+// valveProfile returns the configured valve profile, built in or from the configuration.
+func valveProfile(cfg config.Valve) (gv60.Profile, error) {
+	if p, ok := cfg.Profiles[cfg.Profile]; ok {
+		var base gv60.Profile
+		if p.Base != "" {
+			if base, ok = gv60.Profiles[p.Base]; !ok {
+				return gv60.Profile{}, fmt.Errorf("valve: profile %s: unknown base %q", cfg.Profile, p.Base)
+			}
+		}
+		// what a step leaves out comes from the base's
+		step := func(s config.ValveStep, st gv60.Step) gv60.Step {
+			if len(s.Contacts) > 0 {
+				st.Close = s.Contacts
+			}
+			if s.Hold > 0 {
+				st.Hold = s.Hold
+			}
+			return st
+		}
+		out := gv60.Profile{On: step(p.On, base.On), Off: step(p.Off, base.Off), FlameUp: step(p.FlameUp, base.FlameUp),
+			FlameDown: step(p.FlameDown, base.FlameDown), MinGap: base.MinGap}
+		if p.MinGap > 0 {
+			out.MinGap = p.MinGap
+		}
+		optional := func(s *config.ValveStep, b *gv60.Step) *gv60.Step {
+			if s == nil {
+				return b
+			}
+			var st gv60.Step
+			if b != nil {
+				st = *b
+			}
+			st = step(*s, st)
+			return &st
+		}
+		out.Aux, out.AuxOn, out.AuxOff = optional(p.Aux, base.Aux), optional(p.AuxOn, base.AuxOn), optional(p.AuxOff, base.AuxOff)
+		out.Pilot = optional(p.Pilot, base.Pilot)
+		return out, nil
+	}
+	if p, ok := gv60.Profiles[cfg.Profile]; ok {
+		return p, nil
+	}
+	return gv60.Profile{}, fmt.Errorf("valve: unknown profile %q", cfg.Profile)
+}
+
+// advertise announces the server over mDNS as _gofire._tcp and _http._tcp on the first TCP
+// listener serving every route to the LAN, over HTTPS or, failing that, over plain HTTP, with
+// TXT records of what
+// clients need to know before connecting: its version, base path, whether it wants TLS or a
+// token, the flame levels, what the fireplace can do and the further fireplaces' names.
+func advertise(cfg *config.Config, lns []net.Listener, fire fireplace.Fireplace, levels int, tokens bool) error {
+	pick := -1
+	for i, l := range cfg.Listeners {
+		if l.Redirect || l.Trusted || len(l.Routes) > 0 || strings.HasPrefix(l.Address, "unix:") {
+			continue
+		}
+		if pick < 0 || l.TLS != nil && cfg.Listeners[pick].TLS == nil {
+			pick = i
+		}
+	}
+	if pick < 0 {
+		return fmt.Errorf("discovery: no listener serves every route")
+	}
+	addr, ok := lns[pick].Addr().(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("discovery: listener %s isn't TCP", cfg.Listeners[pick].Name)
+	}
+	if addr.IP.IsLoopback() {
+		logging.Logf(logging.Warning, "discovery: %v is on loopback alone, out of reach of the LAN", addr)
+	}
+	bit := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	var features []string
+	if _, ok := fire.(fireplace.Standby); ok {
+		features = append(features, "pilot")
+	}
+	if _, ok := fire.(fireplace.AuxBurner); ok {
+		features = append(features, "aux_burner")
+	}
+	if _, ok := fire.(fireplace.Fan); ok {
+		features = append(features, "fan")
+	}
+	if _, ok := fire.(fireplace.SplitFlow); ok {
+		features = append(features, "splitflow")
+	}
+	if _, ok := fire.(fireplace.FlameTimer); ok {
+		features = append(features, "flame_timer")
+	}
+	var names []string
+	for name := range cfg.Fireplaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	path := cfg.HTTP.BasePath
+	if path == "" {
+		path = "/"
+	}
+	txt := []string{"txtvers=1", "version=" + version, "path=" + path, "tls=" + bit(cfg.Listeners[pick].TLS != nil),
+		"auth=" + bit(tokens), "levels=" + strconv.Itoa(levels), "features=" + strings.Join(features, ",")}
+	if len(names) > 0 {
+		txt = append(txt, "fireplaces="+strings.Join(names, ","))
+	}
+	host, _ := os.Hostname()
+	host = "gofire-" + strings.ToLower(strings.SplitN(host, ".", 2)[0])
+	records := func() []string { return txt }
+	_, err := mdns.Start("discovery", host,
+		mdns.Service{Instance: cfg.Discovery.Name, Type: "_gofire._tcp", Port: addr.Port, TXT: records},
+		mdns.Service{Instance: cfg.Discovery.Name, Type: "_http._tcp", Port: addr.Port, TXT: records})
+	if err != nil {
+		return fmt.Errorf("discovery: mdns: %v", err)
+	}
+	logging.Event(logging.Info, "advertising over mdns", "name", cfg.Discovery.Name, "host", host+".local", "port", strconv.Itoa(addr.Port))
+	return nil
+}
+
+// listenOn opens a new socket for c: TCP, or for unix:path a Unix socket, in place of any
+// left by an earlier run, with c's permissions. The socket file is kept when the listener is
+// closed, as after an upgrade hands it to the new process.
+func listenOn(c config.Listener) (net.Listener, error) {
+	path := strings.TrimPrefix(c.Address, "unix:")
+	if path == c.Address {
+		return net.Listen("tcp", c.Address)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	mode, _ := strconv.ParseUint(c.SocketMode, 8, 32)
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// listen returns a listening socket for each configured listener: one handed over by an
+// upgrade or passed by systemd socket activation under the listener's name, or else a new
+// one on its address.
+func listen(cfgs []config.Listener) ([]net.Listener, error) {
+	var inherited map[string]net.Listener
+	var err error
+	if upgrade.Inherited() {
+		if inherited, err = upgrade.Listeners(); err != nil {
+			return nil, err
+		}
+	} else {
+		sd, names, err := systemd.Listeners()
+		if err != nil {
+			return nil, err
+		}
+		inherited = map[string]net.Listener{}
+		if len(sd) == 1 && len(cfgs) == 1 {
+			// a single socket unit needn't be named after the listener
+			inherited[cfgs[0].Name] = sd[0]
+		} else {
+			for i, ln := range sd {
+				inherited[names[i]] = ln
+			}
+		}
+	}
+	var lns []net.Listener
+	for _, c := range cfgs {
+		ln, ok := inherited[c.Name]
+		if ok {
+			delete(inherited, c.Name)
+		} else if ln, err = listenOn(c); err != nil {
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	for _, ln := range inherited {
+		ln.Close()
+	}
+	return lns, nil
+}
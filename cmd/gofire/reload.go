@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/barrylb/go-fire/internal/auth"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/httpapi"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/mqtt"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/rules"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// reloader reads the configuration file again on SIGHUP or POST /reload, applying the rules,
+// valve profiles, auth tokens and MQTT broker settings in place, so that neither the GPIO
+// lines nor the listeners are dropped. Other sections changed wait for a restart.
+type reloader struct {
+	path      string
+	overrides func(cfg *config.Config) // the command-line flags, which win over the file
+	started   *config.Config           // as GoFire started; other changes need a restart
+
+	fire       fireplace.Fireplace
+	power      *power.Tracker
+	fireplaces map[string]*httpapi.Fireplace
+	tokens     *auth.Store
+	rules      *rules.Engine // nil without rules_file or in safe mode
+	broker     *mqtt.Client  // nil without mqtt.broker or in safe mode
+
+	mu  sync.Mutex
+	cfg *config.Config // as last applied
+}
+
+// reload reads the file and applies it on behalf of source, changing nothing unless it all
+// loads, and records the outcome.
+func (r *reloader) reload(ctx context.Context, source string) (httpapi.Reloaded, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, err := r.apply()
+	if err != nil {
+		logging.Logf(logging.Err, "reload: %v", err)
+		events.RecordContext(ctx, "reload", "error", source, nil)
+		return res, err
+	}
+	logging.Event(logging.Notice, "configuration reloaded", "applied", strings.Join(res.Applied, ","),
+		"restart", strings.Join(res.Restart, ","))
+	events.RecordContext(ctx, "reload", "ok", source, nil)
+	return res, nil
+}
+
+// profileSwap is a valve profile to replace, with the tracker whose flame steps it moves.
+type profileSwap struct {
+	name  string
+	c     *gv60.Controller
+	p     gv60.Profile
+	power *power.Tracker
+}
+
+func (r *reloader) apply() (httpapi.Reloaded, error) {
+	res := httpapi.Reloaded{Applied: []string{}}
+	cfg, err := config.Load(r.path)
+	if err != nil {
+		return res, err
+	}
+	r.overrides(cfg)
+	res.Restart = restartNeeded(r.started, cfg)
+	// what can fail comes first, so that a bad file changes nothing
+	var swaps []profileSwap
+	if d, ok := r.fire.(*fireplace.Relays); ok {
+		p, err := valveProfile(cfg.Valve)
+		if err == nil {
+			err = p.Check(len(r.started.Valve.GPIOs))
+		}
+		if err != nil {
+			return res, err
+		}
+		swaps = append(swaps, profileSwap{"valve.profile", d.Controller, p, r.power})
+	}
+	for name, f := range cfg.Fireplaces {
+		fp := r.fireplaces[name]
+		if fp == nil {
+			continue // added since; needs a restart
+		}
+		d, ok := fp.Fire.(*fireplace.Relays)
+		if !ok {
+			continue
+		}
+		valve := cfg.Valve
+		valve.GPIOs, valve.Profile = f.GPIOs, f.Profile
+		p, err := valveProfile(valve)
+		if err == nil {
+			err = p.Check(len(r.started.Fireplaces[name].GPIOs))
+		}
+		if err != nil {
+			return res, fmt.Errorf("fireplaces.%s: %v", name, err)
+		}
+		swaps = append(swaps, profileSwap{"fireplaces." + name + ".profile", d.Controller, p, fp.Power})
+	}
+	if r.rules != nil {
+		changed, err := r.rules.Reload()
+		if err != nil {
+			return res, err
+		}
+		if changed {
+			res.Applied = append(res.Applied, "rules")
+		}
+	}
+	// from here on nothing fails
+	for _, s := range swaps {
+		old := s.c.Profile()
+		if reflect.DeepEqual(s.p, old) || s.c.SetProfile(s.p) != nil {
+			continue
+		}
+		// the flame steps move the estimated level as far as their new hold times allow
+		l := s.power.Levels()
+		l.Up *= float64(s.p.FlameUp.Hold) / float64(old.FlameUp.Hold)
+		l.Down *= float64(s.p.FlameDown.Hold) / float64(old.FlameDown.Hold)
+		s.power.SetLevels(l)
+		res.Applied = append(res.Applied, s.name)
+	}
+	if !reflect.DeepEqual(cfg.Auth.AdminTokens, r.cfg.Auth.AdminTokens) || !reflect.DeepEqual(cfg.Auth.Tokens, r.cfg.Auth.Tokens) {
+		if !r.tokens.Enabled() && len(cfg.Auth.AdminTokens)+len(cfg.Auth.Tokens) > 0 {
+			// the auth middleware is only put on every route at start when tokens are set
+			res.Restart = append(res.Restart, "auth")
+		}
+		r.tokens.SetTokens(cfg.Auth)
+		res.Applied = append(res.Applied, "auth.tokens")
+	}
+	if r.broker != nil && cfg.MQTT.Broker != "" && mqttConnection(cfg.MQTT) != mqttConnection(r.cfg.MQTT) {
+		r.broker.Reconnect(cfg.MQTT)
+		res.Applied = append(res.Applied, "mqtt")
+	}
+	r.cfg = cfg
+	return res, nil
+}
+
+// mqttConnection is the part of the MQTT settings a reload can change.
+func mqttConnection(m config.MQTT) config.MQTT {
+	return config.MQTT{Broker: m.Broker, Username: m.Username, Password: m.Password, ClientID: m.ClientID}
+}
+
+// restartNeeded lists the sections of cfg, by name, changed from started other than in what
+// a reload applies.
+func restartNeeded(started, cfg *config.Config) []string {
+	a, b := reloadable(*started), reloadable(*cfg)
+	if started.MQTT.Broker != "" && cfg.MQTT.Broker != "" {
+		a.MQTT.Broker, b.MQTT.Broker = "", ""
+	}
+	var out []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			out = append(out, strings.Split(va.Type().Field(i).Tag.Get("yaml"), ",")[0])
+		}
+	}
+	return out
+}
+
+// reloadable returns cfg without what a reload applies.
+func reloadable(cfg config.Config) config.Config {
+	cfg.Valve.Profile, cfg.Valve.Profiles = "", nil
+	if cfg.Fireplaces != nil {
+		fps := map[string]config.Fireplace{}
+		for name, f := range cfg.Fireplaces {
+			f.Profile = ""
+			fps[name] = f
+		}
+		cfg.Fireplaces = fps
+	}
+	cfg.Auth.AdminTokens, cfg.Auth.Tokens = nil, nil
+	cfg.MQTT.Username, cfg.MQTT.Password, cfg.MQTT.ClientID = "", "", ""
+	return cfg
+}
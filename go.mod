@@ -1,8 +1,18 @@
-module GoFire
+module github.com/barrylb/go-fire
 
-go 1.15
+go 1.19
 
 require (
-	github.com/warthog618/gpiod v0.5.0
-	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/warthog618/go-gpiocdev v0.9.1
+	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.18.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	golang.org/x/net v0.8.0 // indirect
+	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
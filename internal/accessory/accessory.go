@@ -0,0 +1,192 @@
+// Package accessory drives the relay channels of config accessories: a blower fan, accent
+// lighting or anything else switched beside the valve. A latched channel's relay is closed
+// while its accessory is on. A momentary one is closed for its pulse to switch the accessory,
+// as its own push button would, so the state reported is GoFire's count of presses from off
+// at start; a press on the device itself puts them out of step until the next toggle.
+//
+// Accessories are independent of the valve: their commands don't wait for the relays of the
+// fire's sequences, and are recorded as the op accessory with the params name and state.
+// They are refused, as the fire's commands are, by the bank's guards, such as the emergency
+// stop; switching one off never is.
+package accessory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// ErrBadState is returned by Set for a state other than on, off or toggle.
+var ErrBadState = errors.New("accessory: unknown state")
+
+// Channel is one accessory's relay.
+type Channel struct {
+	cfg  config.Accessory
+	line relay.Line
+	bank *Bank
+
+	mu sync.Mutex // held through a momentary pulse, so that presses don't overlap
+	on bool
+}
+
+// Bank is the configured accessories.
+type Bank struct {
+	// Guards are consulted, with the op accessory, before an accessory is switched on; an
+	// error refuses it and is returned by Set.
+	Guards []func(op, source string) error
+
+	channels []*Channel
+	byName   map[string]*Channel
+}
+
+// Open requests the relay channel of each of cfgs on chip, or the relay board attached to it
+// that the accessory names, every accessory starting off; the lines follow activeHigh as the
+// valve's do.
+func Open(chip *relay.Chip, cfgs []config.Accessory, activeHigh bool) (*Bank, error) {
+	b := &Bank{byName: map[string]*Channel{}}
+	for _, c := range cfgs {
+		board, err := chip.Board(c.Board)
+		if err != nil {
+			return nil, fmt.Errorf("accessories.%s: %v", c.Name, err)
+		}
+		l, err := board.Channel(c.GPIO, activeHigh)
+		if err != nil {
+			return nil, fmt.Errorf("accessories.%s: %v", c.Name, err)
+		}
+		ch := &Channel{cfg: c, line: l, bank: b}
+		b.channels = append(b.channels, ch)
+		b.byName[c.Name] = ch
+	}
+	return b, nil
+}
+
+// Names returns the accessories' names in the order they are configured.
+func (b *Bank) Names() []string {
+	var out []string
+	for _, c := range b.channels {
+		out = append(out, c.cfg.Name)
+	}
+	return out
+}
+
+// Get returns the accessory called name, or nil.
+func (b *Bank) Get(name string) *Channel {
+	return b.byName[name]
+}
+
+// State returns each accessory as on or off, by name.
+func (b *Bank) State() map[string]string {
+	out := map[string]string{}
+	for _, c := range b.channels {
+		out[c.cfg.Name] = onOff(c.On())
+	}
+	return out
+}
+
+// Restore takes on the states passed on by an upgrade: latched relays are closed again and
+// momentary accessories, which kept theirs, are taken to be as they were.
+func (b *Bank) Restore(states map[string]string) {
+	for name, st := range states {
+		c := b.byName[name]
+		if c == nil {
+			continue
+		}
+		c.mu.Lock()
+		c.on = st == "on"
+		if c.cfg.Mode == "latched" {
+			c.line.SetValue(relayValue(c.on))
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Name returns the accessory's name.
+func (c *Channel) Name() string {
+	return c.cfg.Name
+}
+
+// Mode returns latched or momentary.
+func (c *Channel) Mode() string {
+	return c.cfg.Mode
+}
+
+// On reports whether the accessory is on.
+func (c *Channel) On() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.on
+}
+
+// Set switches the accessory on, off or over (toggle) on behalf of source, records the
+// command and reports whether the accessory is now on.
+func (c *Channel) Set(state, source string) (bool, error) {
+	return c.SetContext(context.Background(), state, source)
+}
+
+// SetContext is Set for a command recorded with the client ctx carries (see
+// events.WithClient).
+func (c *Channel) SetContext(ctx context.Context, state, source string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	on := c.on
+	switch state {
+	case "on":
+		on = true
+	case "off":
+		on = false
+	case "toggle":
+		on = !c.on
+	default:
+		return c.on, ErrBadState
+	}
+	if on {
+		for _, g := range c.bank.Guards {
+			if err := g("accessory", source); err != nil {
+				return c.on, err
+			}
+		}
+	}
+	var err error
+	switch {
+	case on == c.on && c.cfg.Mode == "momentary":
+		// a press would switch it the other way
+	case c.cfg.Mode == "momentary":
+		if err = c.line.SetValue(0); err == nil {
+			time.Sleep(c.cfg.Pulse)
+			err = c.line.SetValue(1)
+		}
+	default:
+		err = c.line.SetValue(relayValue(on))
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+		logging.Event(logging.Err, "accessory failed", "name", c.cfg.Name, "error", err.Error())
+	} else {
+		c.on = on
+	}
+	events.RecordContext(ctx, "accessory", result, source, map[string]string{"name": c.cfg.Name, "state": onOff(c.on)})
+	return c.on, err
+}
+
+// relayValue is the line value of a latched relay for on, closing the contact while on.
+func relayValue(on bool) int {
+	if on {
+		return 0
+	}
+	return 1
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
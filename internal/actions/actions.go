@@ -0,0 +1,195 @@
+// Package actions runs fireplace actions by name, for integrations (remotes, buttons,
+// switches) that map their own events onto them through configuration.
+package actions
+
+import (
+	"context"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/light"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/metrics"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// Runner performs actions on the fireplace and its light.
+type Runner struct {
+	Fire  fireplace.Fireplace
+	Light *light.Controller // nil when no light is configured
+	// Arbiter, if set, refuses commands from sources outranked by the last one to act.
+	Arbiter *Arbiter
+	// Guards are consulted before each fireplace command; an error refuses it and is
+	// mapped to a result like any other command error.
+	Guards []func(op, source string) error
+	// Name, when set, is the further fireplace (of config fireplaces) the runner drives; its
+	// commands are published and recorded as name/op, so that they aren't taken for the
+	// main fireplace's.
+	Name string
+	// Timeouts bound how long a command run with RunContext or DoContext may take, waiting
+	// for the relays included, by op, with "default" for any other; zero or missing is no
+	// limit. Off is never cut short.
+	Timeouts map[string]time.Duration
+}
+
+// Op returns what op is published and recorded as.
+func (r *Runner) Op(op string) string {
+	if r.Name == "" {
+		return op
+	}
+	return r.Name + "/" + op
+}
+
+// names lists every action with the operation it is recorded as.
+var names = map[string]string{
+	"on":           "on",
+	"off":          "off",
+	"flameup":      "flameup",
+	"flamedown":    "flamedown",
+	"aux":          "aux",
+	"aux_on":       "aux_on",
+	"aux_off":      "aux_off",
+	"pilot":        "pilot",
+	"light_on":     "light",
+	"light_off":    "light",
+	"light_toggle": "light",
+}
+
+// Valid reports whether action is a known action name.
+func Valid(action string) bool {
+	_, ok := names[action]
+	return ok
+}
+
+// Names returns the known action names, sorted.
+func Names() []string {
+	var out []string
+	for n := range names {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Run performs action on behalf of source and records the outcome. It returns the result:
+// ok, busy, lockout, cancelled, timeout, overridden, unsupported, error, disabled (light
+// actions without a light) or unknown.
+func (r *Runner) Run(action, source string) string {
+	return r.RunContext(context.Background(), action, source)
+}
+
+// RunContext is Run for a command that is abandoned once ctx is done, such as one an HTTP
+// client is waiting on, with the result abandoned.
+func (r *Runner) RunContext(ctx context.Context, action, source string) string {
+	op, ok := names[action]
+	if !ok {
+		return "unknown"
+	}
+	var result string
+	switch action {
+	case "on":
+		result = r.DoContext(ctx, op, source, fireplace.Fireplace.On)
+	case "off":
+		result = r.DoContext(ctx, op, source, fireplace.Fireplace.Off)
+	case "flameup":
+		result = r.DoContext(ctx, op, source, fireplace.Fireplace.FlameUp)
+	case "flamedown":
+		result = r.DoContext(ctx, op, source, fireplace.Fireplace.FlameDown)
+	case "aux":
+		result = r.DoContext(ctx, op, source, fireplace.Fireplace.Aux)
+	case "pilot":
+		result = r.DoContext(ctx, op, source, fireplace.ToPilot)
+	case "aux_on", "aux_off":
+		result = r.DoContext(ctx, op, source, func(f fireplace.Fireplace) error { return fireplace.SetAuxBurner(f, action == "aux_on") })
+	default:
+		if r.Light == nil {
+			return "disabled"
+		}
+		state := map[string]string{"light_on": "on", "light_off": "off", "light_toggle": "toggle"}[action]
+		r.Light.Set(state, -1, -1)
+		result = "ok"
+	}
+	events.RecordContext(ctx, r.Op(op), result, source, nil)
+	return result
+}
+
+// DoContext is Do for a command run on the fireplace it is given, which is bound to ctx
+// and to the timeout for op: once either is done, a command waiting for the relays stops
+// waiting and one running is cut short, with every contact opened, with the result
+// abandoned (ctx done) or timeout. Off is neither abandoned nor timed out, so that the fire
+// can always be turned off, though it is still a dry run for a dry-run ctx.
+func (r *Runner) DoContext(ctx context.Context, op, source string, run func(f fireplace.Fireplace) error) string {
+	if op == "off" {
+		dry := gv60.IsDryRun(ctx)
+		ctx = context.Background()
+		if dry {
+			ctx = gv60.DryRun(ctx)
+		}
+	} else if d := r.timeout(op); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	fire := fireplace.WithContext(ctx, r.Fire)
+	result := r.Do(op, source, func() error { return run(fire) })
+	if result == "cancelled" {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			logging.Event(logging.Warning, "command timed out", "op", r.Op(op), "source", source, "timeout", r.timeout(op).String())
+			result = "timeout"
+		case context.Canceled:
+			logging.Event(logging.Notice, "command abandoned", "op", r.Op(op), "source", source)
+			result = "abandoned"
+		}
+	}
+	return result
+}
+
+// timeout returns how long a command for op may take, or 0 for no limit.
+func (r *Runner) timeout(op string) time.Duration {
+	if d, ok := r.Timeouts[op]; ok {
+		return d
+	}
+	return r.Timeouts["default"]
+}
+
+// Do runs a GV60 sequence for op on behalf of source, unless the arbiter or a guard
+// refuses it, and returns the result without recording it; unlike DoContext, it has no
+// timeout. While a fault is latched only off runs, and a panic in run latches one; both give
+// the result "fault".
+func (r *Runner) Do(op, source string, run func() error) (result string) {
+	if f := fault.Latched(); f != nil && op != "off" {
+		logging.Event(logging.Warning, "command refused: fault latched", "op", op, "source", source)
+		return "fault"
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			fault.Trip(op, v, debug.Stack())
+			result = "fault"
+		}
+	}()
+	if r.Arbiter != nil {
+		if ok, holder := r.Arbiter.Allow(op, source); !ok {
+			logging.Event(logging.Notice, "command overridden", "op", op, "source", source, "held_by", holder)
+			return "overridden"
+		}
+	}
+	for _, g := range r.Guards {
+		if err := g(op, source); err != nil {
+			return events.Result(op, err)
+		}
+	}
+	events.PublishStart(r.Op(op), source)
+	start := time.Now()
+	err := run()
+	metrics.ObserveCommand(r.Op(op), time.Since(start))
+	result = events.Result(op, err)
+	if result == "ok" && r.Arbiter != nil {
+		r.Arbiter.Took(op, source)
+	}
+	return result
+}
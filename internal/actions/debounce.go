@@ -0,0 +1,40 @@
+package actions
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// Debounce refuses a command arriving within its interval of the last one let through, from
+// any source, so a flaky automation or a stuck button can't work the valve motor over and
+// over. Off is never refused.
+type Debounce struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// NewDebounce returns a debounce letting one command through per interval.
+func NewDebounce(interval time.Duration) *Debounce {
+	return &Debounce{interval: interval}
+}
+
+// Guard is a Runner guard; a refusal wraps fireplace.ErrBusy.
+func (d *Debounce) Guard(op, source string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if op != "off" {
+		if wait := d.interval - now.Sub(d.last); wait > 0 {
+			logging.Event(logging.Notice, "command debounced", "op", op, "source", source, "wait", wait.Round(time.Millisecond).String())
+			return fmt.Errorf("%w: debounced, %v after the last command", fireplace.ErrBusy, now.Sub(d.last).Round(time.Millisecond))
+		}
+	}
+	d.last = now
+	return nil
+}
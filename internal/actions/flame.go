@@ -0,0 +1,58 @@
+package actions
+
+import (
+	"context"
+	"math"
+
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// FlameBottom sends enough flame down commands on behalf of source to take the flame from
+// the highest level to the lowest, wherever pw estimates it is. It returns the result of
+// the last command sent, or unlit when the fire isn't lit.
+func (r *Runner) FlameBottom(pw *power.Tracker, source string) string {
+	return r.FlameBottomContext(context.Background(), pw, source)
+}
+
+// FlameBottomContext is FlameBottom for commands abandoned once ctx is done.
+func (r *Runner) FlameBottomContext(ctx context.Context, pw *power.Tracker, source string) string {
+	if pw.State().Power != "on" {
+		return "unlit"
+	}
+	levels := pw.Levels()
+	if levels.Down <= 0 {
+		return "ok"
+	}
+	for n := int(math.Ceil(float64(levels.Max) / levels.Down)); n > 0; n-- {
+		if result := r.RunContext(ctx, "flamedown", source); result != "ok" {
+			return result
+		}
+	}
+	return "ok"
+}
+
+// SetFlame steps the flame from the level pw estimates towards level with flame up or down
+// commands on behalf of source, by the levels each one moves it. It returns the result of the
+// last command sent, ok when none was needed, or unlit when the fire isn't lit at a known
+// level.
+func (r *Runner) SetFlame(pw *power.Tracker, level int, source string) string {
+	st := pw.State()
+	if st.Power != "on" || st.FlameLevel == nil {
+		return "unlit"
+	}
+	levels := pw.Levels()
+	diff := float64(level - *st.FlameLevel)
+	action, per := "flameup", levels.Up
+	if diff < 0 {
+		action, per, diff = "flamedown", levels.Down, -diff
+	}
+	if per <= 0 {
+		return "ok"
+	}
+	for n := int(math.Round(diff / per)); n > 0; n-- {
+		if result := r.Run(action, source); result != "ok" {
+			return result
+		}
+	}
+	return "ok"
+}
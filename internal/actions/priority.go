@@ -0,0 +1,118 @@
+package actions
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority ranks command sources; a source can't override the fireplace state set by a
+// source of higher priority until that decision lapses.
+type Priority int
+
+// Priorities from lowest to highest.
+const (
+	PriorityEco Priority = iota + 1
+	PrioritySchedule
+	PriorityAPI
+	PriorityManual
+	PrioritySafety
+)
+
+var priorityNames = map[string]Priority{
+	"eco":      PriorityEco,
+	"schedule": PrioritySchedule,
+	"api":      PriorityAPI,
+	"manual":   PriorityManual,
+	"safety":   PrioritySafety,
+}
+
+// ParsePriority returns the priority called name: safety, manual, api, schedule or eco.
+func ParsePriority(name string) (Priority, error) {
+	p, ok := priorityNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown priority %q", name)
+	}
+	return p, nil
+}
+
+// defaultPriorities ranks the built-in command sources; anything else counts as API.
+var defaultPriorities = map[string]Priority{
+	"safety":     PrioritySafety,
+	"estop":      PrioritySafety,
+	"interlock":  PrioritySafety,
+	"startup":    PrioritySafety,
+	"heartbeat":  PrioritySafety,
+	"autooff":    PrioritySafety,
+	"ignition":   PrioritySafety, // the off after an ignition that didn't take
+	"ble":        PriorityManual,
+	"udp":        PriorityManual,
+	"lora":       PriorityManual,
+	"hue":        PriorityManual,
+	"zigbee":     PriorityManual,
+	"quiet":      PriorityManual, // the quiet hours' turn-down holds against remotes too
+	"http":       PriorityAPI,
+	"signed-url": PriorityAPI,
+	"schedule":   PrioritySchedule,
+	"rules":      PrioritySchedule,
+	"frost":      PrioritySchedule,
+	"thermostat": PriorityEco,
+	"occupancy":  PriorityEco,
+	"presence":   PriorityEco,
+	"eco":        PriorityEco,
+}
+
+// fireOps are the operations the arbiter governs; the light is not arbitrated.
+var fireOps = map[string]bool{"on": true, "off": true, "flameup": true, "flamedown": true}
+
+// Arbiter resolves conflicting commands by source priority, so that for example a schedule
+// can never relight a fire that someone turned off by hand.
+type Arbiter struct {
+	latch     time.Duration
+	overrides map[string]Priority
+
+	mu     sync.Mutex
+	holder string // source of the last fireplace command that ran
+	level  Priority
+	at     time.Time
+}
+
+// NewArbiter returns an arbiter whose decisions lapse after latch; overrides re-rank
+// individual sources.
+func NewArbiter(latch time.Duration, overrides map[string]Priority) *Arbiter {
+	return &Arbiter{latch: latch, overrides: overrides}
+}
+
+// SourcePriority returns the priority of commands from source.
+func (a *Arbiter) SourcePriority(source string) Priority {
+	if p, ok := a.overrides[source]; ok {
+		return p
+	}
+	if p, ok := defaultPriorities[source]; ok {
+		return p
+	}
+	return PriorityAPI
+}
+
+// Allow reports whether source may run op now and, if not, which source holds the fireplace.
+func (a *Arbiter) Allow(op, source string) (bool, string) {
+	if !fireOps[op] {
+		return true, ""
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.holder == "" || time.Since(a.at) > a.latch || a.SourcePriority(source) >= a.level {
+		return true, ""
+	}
+	return false, a.holder
+}
+
+// Took records that source ran op.
+func (a *Arbiter) Took(op, source string) {
+	if !fireOps[op] {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.holder, a.level, a.at = source, a.SourcePriority(source), time.Now()
+}
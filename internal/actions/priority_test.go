@@ -0,0 +1,86 @@
+package actions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/fireplace"
+)
+
+func TestIgnitionOffOverridesManualLatch(t *testing.T) {
+	r := &Runner{Fire: &fireplace.Simulated{}, Arbiter: NewArbiter(4*time.Hour, nil)}
+	if result := r.Run("on", "udp"); result != "ok" {
+		t.Fatalf("on from a remote: %s", result)
+	}
+	if result := r.Run("off", "http"); result != "overridden" {
+		t.Fatalf("off from http during the remote's latch: got %s, want overridden", result)
+	}
+	for _, op := range []string{"off", "on", "off"} {
+		if result := r.Run(op, "ignition"); result != "ok" {
+			t.Errorf("%s from ignition during the remote's latch: got %s, want ok", op, result)
+		}
+	}
+}
+
+func TestPresenceDoesNotOverrideSchedule(t *testing.T) {
+	a := NewArbiter(time.Hour, nil)
+	a.Took("on", "schedule")
+	if ok, holder := a.Allow("off", "presence"); ok || holder != "schedule" {
+		t.Errorf("off from presence after a schedule's on: got %v held by %q, want refused held by schedule", ok, holder)
+	}
+}
+
+func TestQuietTurnDownOverridesRemote(t *testing.T) {
+	a := NewArbiter(time.Hour, nil)
+	a.Took("flameup", "udp")
+	if ok, holder := a.Allow("flamedown", "quiet"); !ok {
+		t.Errorf("flamedown from quiet hours after a remote's flameup: refused, held by %q", holder)
+	}
+}
+
+func TestArbiterAllow(t *testing.T) {
+	tests := []struct {
+		name      string
+		latch     time.Duration
+		overrides map[string]Priority
+		took      [][2]string // the op and source of each command run before
+		op        string
+		source    string
+		want      bool
+		holder    string
+	}{
+		{name: "nothing held", latch: time.Hour, op: "on", source: "schedule", want: true},
+		{name: "lower source refused", latch: time.Hour, took: [][2]string{{"off", "udp"}}, op: "on", source: "schedule",
+			holder: "udp"},
+		{name: "equal source allowed", latch: time.Hour, took: [][2]string{{"on", "http"}}, op: "off", source: "signed-url", want: true},
+		{name: "higher source allowed", latch: time.Hour, took: [][2]string{{"on", "schedule"}}, op: "off", source: "http", want: true},
+		{name: "unknown source counts as api", latch: time.Hour, took: [][2]string{{"on", "http"}}, op: "off", source: "alexa", want: true},
+		{name: "unknown source below manual", latch: time.Hour, took: [][2]string{{"on", "ble"}}, op: "off", source: "alexa",
+			holder: "ble"},
+		{name: "safety overrides manual", latch: time.Hour, took: [][2]string{{"on", "udp"}}, op: "off", source: "estop", want: true},
+		{name: "latest command holds", latch: time.Hour, took: [][2]string{{"on", "udp"}, {"off", "safety"}, {"on", "eco"}}, op: "off",
+			source: "thermostat", want: true},
+		{name: "latch lapsed", latch: time.Nanosecond, took: [][2]string{{"off", "udp"}}, op: "on", source: "eco", want: true},
+		{name: "light not arbitrated", latch: time.Hour, took: [][2]string{{"off", "udp"}}, op: "light", source: "eco", want: true},
+		{name: "light doesn't hold", latch: time.Hour, took: [][2]string{{"light", "safety"}}, op: "on", source: "eco", want: true},
+		{name: "override raises source", latch: time.Hour, overrides: map[string]Priority{"schedule": PriorityManual},
+			took: [][2]string{{"off", "udp"}}, op: "on", source: "schedule", want: true},
+		{name: "override lowers holder", latch: time.Hour, overrides: map[string]Priority{"udp": PriorityEco},
+			took: [][2]string{{"off", "udp"}}, op: "on", source: "thermostat", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewArbiter(tt.latch, tt.overrides)
+			for _, c := range tt.took {
+				a.Took(c[0], c[1])
+			}
+			if tt.latch < time.Millisecond {
+				time.Sleep(time.Millisecond)
+			}
+			ok, holder := a.Allow(tt.op, tt.source)
+			if ok != tt.want || holder != tt.holder {
+				t.Errorf("Allow(%q, %q) = %v, %q; want %v, %q", tt.op, tt.source, ok, holder, tt.want, tt.holder)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fireplace"
+)
+
+// ErrNothingToUndo is returned by Undo when no command since the last undo changed the
+// fireplace.
+var ErrNothingToUndo = errors.New("actions: nothing to undo")
+
+// ErrNotUndoable is returned by Undo when the last command can't be reversed, such as an
+// off whose flame level is no longer known.
+var ErrNotUndoable = errors.New("actions: the last command can't be undone")
+
+// step is one command of an undo.
+type step struct {
+	op     string
+	params map[string]string
+	run    func(f fireplace.Fireplace) error
+}
+
+// Undo reverses the most recent successful command that changed the fireplace, on behalf
+// of source: on with off, flame up with flame down and back, aux with aux, a fan speed or
+// split-flow setting with the one before, and off by relighting and repeating the flame
+// changes made since the previous on. Each reversing command is recorded with an "undo"
+// parameter naming the command it reverses, and an undo can't itself be undone. It returns
+// the command undone and the result of the first reversing command that didn't succeed,
+// or ok.
+func (r *Runner) Undo(source string) (string, string, error) {
+	cmds := events.Recent(100)
+	last := -1
+	for i, c := range cmds {
+		if c.Result == "ok" && changesFire(c.Op) {
+			last = i
+			break
+		}
+	}
+	if last < 0 || cmds[last].Params["undo"] != "" {
+		return "", "", ErrNothingToUndo
+	}
+	c := cmds[last]
+	steps, err := r.reverse(c, cmds[last+1:])
+	if err != nil {
+		return c.Op, "", err
+	}
+	for _, s := range steps {
+		params := map[string]string{"undo": c.Op}
+		for k, v := range s.params {
+			params[k] = v
+		}
+		result := r.DoContext(context.Background(), s.op, source, s.run)
+		events.RecordWith(s.op, result, source, params)
+		if result != "ok" {
+			return c.Op, result, nil
+		}
+	}
+	return c.Op, "ok", nil
+}
+
+func changesFire(op string) bool {
+	switch op {
+	case "on", "off", "pilot", "setflame", "calibrate", "flameup", "flamedown", "aux", "aux_on", "aux_off", "fan", "splitflow":
+		return true
+	}
+	return false
+}
+
+// reverse returns the steps undoing c, given the commands before it, newest first.
+func (r *Runner) reverse(c events.Command, before []events.Command) ([]step, error) {
+	switch c.Op {
+	case "on":
+		return []step{{op: "off", run: fireplace.Fireplace.Off}}, nil
+	case "flameup":
+		return []step{{op: "flamedown", run: fireplace.Fireplace.FlameDown}}, nil
+	case "flamedown":
+		return []step{{op: "flameup", run: fireplace.Fireplace.FlameUp}}, nil
+	case "aux":
+		return []step{{op: "aux", run: fireplace.Fireplace.Aux}}, nil
+	case "aux_on":
+		return []step{{op: "aux_off", run: func(f fireplace.Fireplace) error { return fireplace.SetAuxBurner(f, false) }}}, nil
+	case "aux_off":
+		return []step{{op: "aux_on", run: func(f fireplace.Fireplace) error { return fireplace.SetAuxBurner(f, true) }}}, nil
+	case "off":
+		// relight, then repeat the flame changes made between the previous on and the off
+		var changes []step
+		for _, b := range before {
+			if b.Result != "ok" {
+				continue
+			}
+			switch b.Op {
+			case "on":
+				steps := []step{{op: "on", run: fireplace.Fireplace.On}}
+				for i := len(changes) - 1; i >= 0; i-- {
+					steps = append(steps, changes[i])
+				}
+				return steps, nil
+			case "off":
+				return nil, ErrNotUndoable
+			case "flameup":
+				changes = append(changes, step{op: "flameup", run: fireplace.Fireplace.FlameUp})
+			case "flamedown":
+				changes = append(changes, step{op: "flamedown", run: fireplace.Fireplace.FlameDown})
+			}
+		}
+		return nil, ErrNotUndoable
+	case "fan":
+		fan, ok := r.Fire.(fireplace.Fan)
+		if !ok {
+			return nil, ErrNotUndoable
+		}
+		speed := 0 // the fan is off until first set
+		for _, b := range before {
+			if b.Op == "fan" && b.Result == "ok" {
+				var err error
+				if speed, err = strconv.Atoi(b.Params["speed"]); err != nil {
+					return nil, ErrNotUndoable
+				}
+				break
+			}
+		}
+		return []step{{op: "fan", params: map[string]string{"speed": strconv.Itoa(speed)},
+			run: func(fireplace.Fireplace) error { return fan.SetFan(speed) }}}, nil
+	case "splitflow":
+		sf, ok := r.Fire.(fireplace.SplitFlow)
+		if !ok || (c.Params["state"] != "on" && c.Params["state"] != "off") {
+			return nil, ErrNotUndoable
+		}
+		on := c.Params["state"] == "off"
+		state := map[bool]string{true: "on", false: "off"}[on]
+		return []step{{op: "splitflow", params: map[string]string{"state": state},
+			run: func(fireplace.Fireplace) error { return sf.SetSplitFlow(on) }}}, nil
+	}
+	return nil, ErrNotUndoable
+}
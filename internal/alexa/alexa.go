@@ -0,0 +1,250 @@
+// Package alexa answers Alexa Smart Home skill directives (payload version 3), so the
+// fireplace can be switched and dimmed by voice. A skill's Lambda function only has to
+// forward each directive to POST /alexa, with the account-linked access token as a bearer
+// token, and return the reply; GoFire takes the token to be one of its own, with the alexa
+// scope.
+//
+// The fireplace is one endpoint with Alexa.PowerController, to light it and turn it off, and
+// Alexa.BrightnessController, whose percentage is the flame level.
+package alexa
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// source is what Alexa commands are recorded as.
+const source = "alexa"
+
+// endpointID is the ID of the one endpoint served.
+const endpointID = "fireplace"
+
+// Skill answers Smart Home directives.
+type Skill struct {
+	cfg    config.Alexa
+	runner *actions.Runner
+	power  *power.Tracker
+	flame  *flame.Control // nil unless the driver sets the flame with timed pulses
+}
+
+// New returns the skill handler for the fireplace; fc may be nil.
+func New(cfg config.Alexa, runner *actions.Runner, pw *power.Tracker, fc *flame.Control) *Skill {
+	return &Skill{cfg: cfg, runner: runner, power: pw, flame: fc}
+}
+
+type header struct {
+	Namespace        string `json:"namespace"`
+	Name             string `json:"name"`
+	MessageID        string `json:"messageId"`
+	CorrelationToken string `json:"correlationToken,omitempty"`
+	PayloadVersion   string `json:"payloadVersion"`
+}
+
+type directive struct {
+	Directive struct {
+		Header   header          `json:"header"`
+		Endpoint json.RawMessage `json:"endpoint"`
+		Payload  struct {
+			Brightness      *int `json:"brightness"`
+			BrightnessDelta *int `json:"brightnessDelta"`
+		} `json:"payload"`
+	} `json:"directive"`
+}
+
+type property struct {
+	Namespace                 string      `json:"namespace"`
+	Name                      string      `json:"name"`
+	Value                     interface{} `json:"value"`
+	TimeOfSample              time.Time   `json:"timeOfSample"`
+	UncertaintyInMilliseconds int         `json:"uncertaintyInMilliseconds"`
+}
+
+type event struct {
+	Event struct {
+		Header   header          `json:"header"`
+		Endpoint json.RawMessage `json:"endpoint,omitempty"`
+		Payload  interface{}     `json:"payload"`
+	} `json:"event"`
+	Context *struct {
+		Properties []property `json:"properties"`
+	} `json:"context,omitempty"`
+}
+
+// ServeHTTP answers one directive.
+func (s *Skill) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "alexa_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	var d directive
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&d); err != nil {
+		http.Error(w, "alexa_badrequest", http.StatusBadRequest)
+		return
+	}
+	h := d.Directive.Header
+	var reply event
+	reply.Event.Header = header{Namespace: "Alexa", Name: "Response", MessageID: messageID(),
+		CorrelationToken: h.CorrelationToken, PayloadVersion: "3"}
+	reply.Event.Endpoint = d.Directive.Endpoint
+	reply.Event.Payload = struct{}{}
+	// the state the directive leaves, as the tracker only applies a command in a moment
+	result, on, pct := "ok", s.power.State().Power == "on", s.brightness()
+	switch h.Namespace + "." + h.Name {
+	case "Alexa.Discovery.Discover":
+		reply.Event.Header.Namespace, reply.Event.Header.Name = "Alexa.Discovery", "Discover.Response"
+		reply.Event.Payload = map[string]interface{}{"endpoints": []interface{}{s.endpoint()}}
+		writeJSON(w, reply)
+		return
+	case "Alexa.Authorization.AcceptGrant":
+		reply.Event.Header.Namespace, reply.Event.Header.Name = "Alexa.Authorization", "AcceptGrant.Response"
+		writeJSON(w, reply)
+		return
+	case "Alexa.ReportState":
+		reply.Event.Header.Name = "StateReport"
+	case "Alexa.PowerController.TurnOn":
+		result, on, pct = s.runner.Run("on", source), true, 100
+	case "Alexa.PowerController.TurnOff":
+		result, on, pct = s.runner.Run("off", source), false, 0
+	case "Alexa.BrightnessController.SetBrightness", "Alexa.BrightnessController.AdjustBrightness":
+		if p := d.Directive.Payload.Brightness; p != nil {
+			pct = *p
+		} else if p := d.Directive.Payload.BrightnessDelta; p != nil {
+			pct += *p
+		} else {
+			s.fail(w, reply, "INVALID_VALUE", "no brightness given")
+			return
+		}
+		var level int
+		result, level = s.setLevel(pct)
+		pct = s.percent(level)
+	default:
+		logging.Event(logging.Info, "alexa: unsupported directive", "namespace", h.Namespace, "name", h.Name)
+		s.fail(w, reply, "INVALID_DIRECTIVE", "unsupported directive "+h.Namespace+"."+h.Name)
+		return
+	}
+	if result != "ok" {
+		s.fail(w, reply, errorType(result), "the fireplace replied "+result)
+		return
+	}
+	reply.Context = &struct {
+		Properties []property `json:"properties"`
+	}{properties(on, pct)}
+	writeJSON(w, reply)
+}
+
+// setLevel sets the flame to the level nearest pct percent, at least the lowest, and
+// returns the result and the level.
+func (s *Skill) setLevel(pct int) (string, int) {
+	max := s.power.Levels().Max
+	level := int(math.Round(float64(pct) * float64(max) / 100))
+	if level < 1 {
+		level = 1
+	}
+	if level > max {
+		level = max
+	}
+	if s.flame != nil {
+		return s.flame.Set(level, source), level
+	}
+	return s.runner.SetFlame(s.power, level, source), level
+}
+
+// brightness is the flame level as a percentage of the highest.
+func (s *Skill) brightness() int {
+	st := s.power.State()
+	if st.Power != "on" || st.FlameLevel == nil {
+		return 0
+	}
+	return s.percent(*st.FlameLevel)
+}
+
+func (s *Skill) percent(level int) int {
+	max := s.power.Levels().Max
+	if max == 0 {
+		return 0
+	}
+	return int(math.Round(float64(level) * 100 / float64(max)))
+}
+
+// properties reports the fireplace as on or off with the flame at pct percent.
+func properties(on bool, pct int) []property {
+	now := time.Now()
+	power := "OFF"
+	if on {
+		power = "ON"
+	}
+	return []property{
+		{Namespace: "Alexa.PowerController", Name: "powerState", Value: power, TimeOfSample: now},
+		{Namespace: "Alexa.BrightnessController", Name: "brightness", Value: pct, TimeOfSample: now},
+		{Namespace: "Alexa.EndpointHealth", Name: "connectivity", Value: map[string]string{"value": "OK"}, TimeOfSample: now},
+	}
+}
+
+func (s *Skill) endpoint() map[string]interface{} {
+	capability := func(iface string, props ...string) map[string]interface{} {
+		c := map[string]interface{}{"type": "AlexaInterface", "interface": iface, "version": "3"}
+		if len(props) > 0 {
+			var supported []map[string]string
+			for _, p := range props {
+				supported = append(supported, map[string]string{"name": p})
+			}
+			c["properties"] = map[string]interface{}{"supported": supported, "proactivelyReported": false, "retrievable": true}
+		}
+		return c
+	}
+	return map[string]interface{}{
+		"endpointId":        endpointID,
+		"manufacturerName":  "GoFire",
+		"friendlyName":      s.cfg.Name,
+		"description":       "Gas fireplace",
+		"displayCategories": []string{"SWITCH"},
+		"capabilities": []interface{}{
+			capability("Alexa"),
+			capability("Alexa.PowerController", "powerState"),
+			capability("Alexa.BrightnessController", "brightness"),
+			capability("Alexa.EndpointHealth", "connectivity"),
+		},
+	}
+}
+
+// fail replies with an ErrorResponse of type typ.
+func (s *Skill) fail(w http.ResponseWriter, reply event, typ, message string) {
+	reply.Event.Header.Name = "ErrorResponse"
+	reply.Event.Payload = map[string]string{"type": typ, "message": message}
+	writeJSON(w, reply)
+}
+
+// errorType maps a command result to an Alexa error type.
+func errorType(result string) string {
+	switch result {
+	case "busy", "queue_full":
+		return "ENDPOINT_BUSY"
+	case "unlit":
+		return "NOT_IN_OPERATION"
+	case "lockout", "overridden", "fault":
+		return "NOT_SUPPORTED_IN_CURRENT_MODE"
+	}
+	return "INTERNAL_ERROR"
+}
+
+func messageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
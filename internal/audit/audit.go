@@ -0,0 +1,157 @@
+// Package audit keeps a persistent trail of every command: what it was, when, the source
+// that sent it (http, mqtt, schedule, thermostat, homekit, ...), the client it was sent for
+// where there is one, such as an HTTP client's address, and its result, so that a fire lit
+// unexpectedly can be traced to whatever lit it.
+//
+// The trail is kept as JSON lines in one file per month under one directory,
+//
+//	audit-2006-01.jsonl
+//
+// and whole months past the retention are deleted, so the store only ever sees appends and
+// unlinks.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// Trail is the audit trail on disk.
+type Trail struct {
+	cfg config.Audit
+}
+
+// Filter selects the commands Query returns.
+type Filter struct {
+	Since, Until time.Time
+	Source       string // any when empty
+	Op           string // any when empty
+	Limit        int    // the newest this many; all when zero
+}
+
+// Start records every command published from now on in the trail under cfg.Dir.
+func Start(cfg config.Audit) (*Trail, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	// fail early if the directory isn't writable rather than logging every command
+	if err := ioutil.WriteFile(filepath.Join(cfg.Dir, ".probe"), nil, 0644); err != nil {
+		return nil, err
+	}
+	os.Remove(filepath.Join(cfg.Dir, ".probe"))
+	t := &Trail{cfg: cfg}
+	ch, _ := events.Subscribe()
+	fault.Go("audit", func() { t.record(ch) })
+	fault.Go("audit", t.prune)
+	return t, nil
+}
+
+// record appends each command to the file of its month.
+func (t *Trail) record(ch <-chan events.Command) {
+	for c := range ch {
+		if err := t.append(c); err != nil {
+			logging.Logf(logging.Err, "audit: %v", err)
+		}
+	}
+}
+
+func (t *Trail) append(c events.Command) error {
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(t.path(c.Time), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (t *Trail) path(month time.Time) string {
+	return filepath.Join(t.cfg.Dir, "audit-"+month.Format("2006-01")+".jsonl")
+}
+
+// prune deletes the months past the retention now and then every day.
+func (t *Trail) prune() {
+	for {
+		if err := t.pruneOnce(time.Now()); err != nil {
+			logging.Logf(logging.Err, "audit: %v", err)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+func (t *Trail) pruneOnce(now time.Time) error {
+	paths, err := filepath.Glob(filepath.Join(t.cfg.Dir, "audit-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(p), "audit-"), ".jsonl")
+		month, err := time.ParseInLocation("2006-01", name, time.Local)
+		if err != nil {
+			continue
+		}
+		// a month goes once the last of it is past the retention
+		if now.Sub(month.AddDate(0, 1, 0)) > t.cfg.Retention {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Query returns the commands recorded between f.Since and f.Until that f selects, newest
+// first. Lines that can't be read, such as one cut short by a power cut, are skipped.
+func (t *Trail) Query(f Filter) ([]events.Command, error) {
+	out := []events.Command{}
+	y, m, _ := f.Since.Date()
+	for month := time.Date(y, m, 1, 0, 0, 0, 0, time.Local); !month.After(f.Until); month = month.AddDate(0, 1, 0) {
+		file, err := os.Open(t.path(month))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(file)
+		for sc.Scan() {
+			var c events.Command
+			if json.Unmarshal(sc.Bytes(), &c) != nil {
+				continue
+			}
+			if c.Time.Before(f.Since) || c.Time.After(f.Until) ||
+				(f.Source != "" && c.Source != f.Source) || (f.Op != "" && c.Op != f.Op) {
+				continue
+			}
+			out = append(out, c)
+		}
+		err = sc.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out, nil
+}
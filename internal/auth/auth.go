@@ -0,0 +1,277 @@
+// Package auth checks API tokens: admin tokens from the configuration, and guest tokens
+// minted at runtime with a set of scopes and an expiry, so a visitor can be given use of
+// the fireplace for a weekend without a permanent credential.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fileutil"
+)
+
+// ScopeAdmin grants everything, including managing tokens.
+const ScopeAdmin = "admin"
+
+// ScopeRead grants the readScopes, for dashboards that show the fireplace but can't
+// change it.
+const ScopeRead = "read"
+
+// readScopes are the scopes of routes that only report.
+var readScopes = map[string]bool{
+	"status": true, "sensors": true, "history": true, "commands": true, "relays": true, "metrics": true,
+	"ws": true, "events": true,
+}
+
+// Token is a guest token; the secret itself is only known when it is minted.
+type Token struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Hash    string    `json:"hash,omitempty"` // SHA-256 of the secret, kept in the tokens file only
+}
+
+// Store holds the admin, static and guest tokens.
+type Store struct {
+	urlKey []byte // signs one-time action URLs
+	file   string
+
+	mu       sync.Mutex
+	admin    [][]byte      // SHA-256 of each admin token
+	static   []staticToken // configured tokens with limited scopes
+	tokens   map[string]*Token
+	usedURLs map[string]int64 // nonce of each used action URL -> its expiry (Unix)
+}
+
+// staticToken is a configured token with limited scopes.
+type staticToken struct {
+	name   string
+	hash   []byte // SHA-256 of the token
+	scopes []string
+}
+
+// saved is the layout of the tokens file.
+type saved struct {
+	Tokens   []*Token         `json:"tokens"`
+	UsedURLs map[string]int64 `json:"used_urls"`
+}
+
+// New loads the configured admin tokens and any saved guest tokens.
+func New(cfg config.Auth) (*Store, error) {
+	s := &Store{file: cfg.TokensFile, tokens: map[string]*Token{}, usedURLs: map[string]int64{}}
+	s.SetTokens(cfg)
+	var err error
+	if cfg.URLKey != "" {
+		if s.urlKey, err = hex.DecodeString(cfg.URLKey); err != nil || len(s.urlKey) < 16 {
+			return nil, errors.New("auth: url_key must be at least 16 bytes of hex")
+		}
+	} else if s.urlKey, err = random(32); err != nil {
+		return nil, err
+	}
+	if s.file == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st saved
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("auth: %s: %v", s.file, err)
+	}
+	for _, t := range st.Tokens {
+		s.tokens[t.ID] = t
+	}
+	for n, exp := range st.UsedURLs {
+		s.usedURLs[n] = exp
+	}
+	return s, nil
+}
+
+// SetTokens replaces the admin and static tokens with those of cfg, as when the
+// configuration is reloaded; guest tokens are kept.
+func (s *Store) SetTokens(cfg config.Auth) {
+	var admin [][]byte
+	for _, t := range cfg.AdminTokens {
+		h := sha256.Sum256([]byte(t))
+		admin = append(admin, h[:])
+	}
+	var static []staticToken
+	for _, t := range cfg.Tokens {
+		h := sha256.Sum256([]byte(t.Token))
+		static = append(static, staticToken{t.Name, h[:], t.Scopes})
+	}
+	s.mu.Lock()
+	s.admin, s.static = admin, static
+	s.mu.Unlock()
+}
+
+// Enabled reports whether any admin or static token is configured; without one, nothing
+// is checked.
+func (s *Store) Enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.admin) > 0 || len(s.static) > 0
+}
+
+// Check reports whether secret grants scope, and the name of the token that does.
+func (s *Store) Check(secret, scope string) (string, bool) {
+	name, scopes, ok := s.lookup(secret)
+	if !ok {
+		return "", false
+	}
+	for _, sc := range scopes {
+		if sc == scope || sc == ScopeAdmin || sc == ScopeRead && readScopes[scope] {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Identify returns the name of a valid token whatever its scopes, for per-user settings.
+func (s *Store) Identify(secret string) (string, bool) {
+	name, _, ok := s.lookup(secret)
+	return name, ok
+}
+
+// lookup returns the name and scopes of the token with secret, if it is valid.
+func (s *Store) lookup(secret string) (string, []string, bool) {
+	h := sha256.Sum256([]byte(secret))
+	s.mu.Lock()
+	admin, static := s.admin, s.static
+	s.mu.Unlock()
+	for _, a := range admin {
+		if subtle.ConstantTimeCompare(a, h[:]) == 1 {
+			return "admin", []string{ScopeAdmin}, true
+		}
+	}
+	for _, t := range static {
+		if subtle.ConstantTimeCompare(t.hash, h[:]) == 1 {
+			return t.name, t.scopes, true
+		}
+	}
+	// guest secrets are "id.random"
+	i := strings.IndexByte(secret, '.')
+	if i < 0 {
+		return "", nil, false
+	}
+	s.mu.Lock()
+	t := s.tokens[secret[:i]]
+	s.mu.Unlock()
+	if t == nil || time.Now().After(t.Expires) {
+		return "", nil, false
+	}
+	want, _ := hex.DecodeString(t.Hash)
+	if subtle.ConstantTimeCompare(want, h[:]) != 1 {
+		return "", nil, false
+	}
+	return t.Name, t.Scopes, true
+}
+
+// Mint creates a guest token and returns its secret, which can't be recovered later.
+func (s *Store) Mint(name string, scopes []string, expires time.Time) (string, Token, error) {
+	if !expires.After(time.Now()) {
+		return "", Token{}, errors.New("auth: expiry is in the past")
+	}
+	idBytes, err := random(4)
+	if err != nil {
+		return "", Token{}, err
+	}
+	key, err := random(24)
+	if err != nil {
+		return "", Token{}, err
+	}
+	id := hex.EncodeToString(idBytes)
+	secret := id + "." + base64.RawURLEncoding.EncodeToString(key)
+	h := sha256.Sum256([]byte(secret))
+	t := &Token{ID: id, Name: name, Scopes: scopes, Created: time.Now(), Expires: expires, Hash: hex.EncodeToString(h[:])}
+	s.mu.Lock()
+	s.prune()
+	s.tokens[id] = t
+	err = s.save()
+	s.mu.Unlock()
+	out := *t
+	out.Hash = ""
+	return secret, out, err
+}
+
+// List returns the unexpired guest tokens, soonest expiry first, without their hashes.
+func (s *Store) List() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	var out []Token
+	for _, t := range s.tokens {
+		c := *t
+		c.Hash = ""
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Expires.Before(out[j].Expires) })
+	return out
+}
+
+// Revoke deletes a guest token, reporting whether it existed.
+func (s *Store) Revoke(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[id]; !ok {
+		return false, nil
+	}
+	delete(s.tokens, id)
+	return true, s.save()
+}
+
+// prune drops expired tokens and used URLs; callers must hold mu.
+func (s *Store) prune() {
+	now := time.Now()
+	for id, t := range s.tokens {
+		if now.After(t.Expires) {
+			delete(s.tokens, id)
+		}
+	}
+	for n, exp := range s.usedURLs {
+		if now.Unix() > exp {
+			delete(s.usedURLs, n)
+		}
+	}
+}
+
+// save writes the guest tokens to the tokens file; callers must hold mu.
+func (s *Store) save() error {
+	if s.file == "" {
+		return nil
+	}
+	st := saved{UsedURLs: s.usedURLs}
+	for _, t := range s.tokens {
+		st.Tokens = append(st.Tokens, t)
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(s.file, data, 0600)
+}
+
+func random(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
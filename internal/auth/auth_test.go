@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+)
+
+func TestCheck(t *testing.T) {
+	s, err := New(config.Auth{
+		AdminTokens: []string{"admin-secret"},
+		Tokens: []config.StaticToken{
+			{Name: "wall", Token: "wall-secret", Scopes: []string{ScopeRead}},
+			{Name: "kids", Token: "kids-secret", Scopes: []string{"off", "light"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest, _, err := s.Mint("guest", []string{"on", "off"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, tok, err := s.Mint("old guest", []string{"on"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Revoke(tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		secret, scope string
+		want          string // the token's name; empty when refused
+	}{
+		{"admin-secret", "on", "admin"},
+		{"admin-secret", ScopeAdmin, "admin"},
+		{"wall-secret", "status", "wall"},
+		{"wall-secret", "history", "wall"},
+		{"wall-secret", "on", ""},
+		{"wall-secret", ScopeAdmin, ""},
+		{"kids-secret", "off", "kids"},
+		{"kids-secret", "light", "kids"},
+		{"kids-secret", "on", ""},
+		{"kids-secret", "status", ""},
+		{guest, "on", "guest"},
+		{guest, "off", "guest"},
+		{guest, "flameup", ""},
+		{guest, ScopeAdmin, ""},
+		{guest + "x", "on", ""},
+		{revoked, "on", ""},
+		{"", "on", ""},
+		{"nonsense", "status", ""},
+	}
+	for _, tt := range tests {
+		name, ok := s.Check(tt.secret, tt.scope)
+		if ok != (tt.want != "") || name != tt.want {
+			t.Errorf("Check(%q, %q) = %q, %v; want %q", tt.secret, tt.scope, name, ok, tt.want)
+		}
+	}
+}
+
+func TestMintRefusesPastExpiry(t *testing.T) {
+	s, err := New(config.Auth{AdminTokens: []string{"admin-secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Mint("guest", []string{"on"}, time.Now().Add(-time.Minute)); err == nil {
+		t.Error("minted a token that has already expired")
+	}
+}
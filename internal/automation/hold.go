@@ -0,0 +1,55 @@
+// Package automation holds the state shared by automatic control (schedules, thermostat,
+// occupancy), starting with the hold that pauses all of it.
+package automation
+
+import (
+	"sync"
+	"time"
+)
+
+// Hold pauses automatic control until it expires or is resumed, e.g. "hold for 3 hours"
+// while guests are over. Automations check Paused before acting; manual commands are
+// never held.
+type Hold struct {
+	mu     sync.Mutex
+	until  time.Time
+	reason string
+}
+
+// HoldState describes a hold for clients; Until is zero when automation is running.
+type HoldState struct {
+	Paused bool      `json:"paused"`
+	Until  time.Time `json:"until,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Pause holds automation until until, replacing any current hold.
+func (h *Hold) Pause(until time.Time, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.until, h.reason = until, reason
+}
+
+// Resume ends the hold, reporting whether there was one.
+func (h *Hold) Resume() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	held := time.Now().Before(h.until)
+	h.until, h.reason = time.Time{}, ""
+	return held
+}
+
+// Paused reports whether automation is currently held.
+func (h *Hold) Paused() bool {
+	return h.State().Paused
+}
+
+// State returns the current hold, if it hasn't expired.
+func (h *Hold) State() HoldState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !time.Now().Before(h.until) {
+		return HoldState{}
+	}
+	return HoldState{Paused: true, Until: h.until, Reason: h.reason}
+}
@@ -0,0 +1,111 @@
+// Package autooff is a safety timer: a fire left burning, say by someone who forgot it
+// before going out, is turned off after a set time however its flame is changed meanwhile.
+package autooff
+
+import (
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// source is what the off command is recorded as.
+const source = "autooff"
+
+// checkEvery is how often the burn time is checked.
+const checkEvery = 10 * time.Second
+
+// Timer turns the fire off after a continuous burn.
+type Timer struct {
+	cfg    config.AutoOff
+	power  *power.Tracker
+	runner *actions.Runner
+
+	mu       sync.Mutex
+	override *time.Duration // limit for the current burn, or the next while the fire is off
+	fired    bool           // the fire has been turned off for this burn, and that has been logged
+}
+
+// State is the limit of the current (or next) burn and, while the fire is on, when it ends.
+type State struct {
+	Limit    string     `json:"limit"`              // e.g. 4h0m0s
+	Session  bool       `json:"session"`            // the limit was set for this burn
+	Deadline *time.Time `json:"deadline,omitempty"` // while the fire is on
+}
+
+// Start begins timing burns.
+func Start(cfg config.AutoOff, pw *power.Tracker, runner *actions.Runner) *Timer {
+	t := &Timer{cfg: cfg, power: pw, runner: runner}
+	fault.Go("autooff", t.watch)
+	return t
+}
+
+// Set changes the limit of the current burn, or of the next one while the fire is off. The
+// default applies again once that burn is over.
+func (t *Timer) Set(limit time.Duration) {
+	t.mu.Lock()
+	t.override = &limit
+	t.mu.Unlock()
+}
+
+// State returns the current limit and deadline.
+func (t *Timer) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := State{Limit: t.limit().String(), Session: t.override != nil}
+	if d, ok := t.deadline(); ok {
+		s.Deadline = &d
+	}
+	return s
+}
+
+// limit returns the limit of the current burn; callers must hold mu.
+func (t *Timer) limit() time.Duration {
+	if t.override != nil {
+		return *t.override
+	}
+	return t.cfg.After
+}
+
+// deadline returns when the fire is turned off, counting from ignition; callers must hold mu.
+func (t *Timer) deadline() (time.Time, bool) {
+	ps := t.power.State()
+	if ps.Power != "on" {
+		return time.Time{}, false
+	}
+	return ps.Since.Add(t.limit()), true
+}
+
+func (t *Timer) watch() {
+	wasOn := false
+	for now := range time.Tick(checkEvery) {
+		t.mu.Lock()
+		d, on := t.deadline()
+		if wasOn && !on {
+			// the burn is over: back to the default for the next one
+			t.override, t.fired = nil, false
+		}
+		wasOn = on
+		due := on && now.After(d)
+		limit := t.limit()
+		t.mu.Unlock()
+		if !due {
+			continue
+		}
+		// retried every check until it succeeds, e.g. while the relays are busy
+		result := t.runner.Run("off", source)
+		t.mu.Lock()
+		if !t.fired {
+			logging.Event(logging.Warning, "auto-off: turning the fire off after a continuous burn",
+				"limit", limit.String(), "result", result)
+			t.fired = true
+		} else if result != "ok" {
+			logging.Logf(logging.Warning, "autooff: retrying off: %s", result)
+		}
+		t.mu.Unlock()
+	}
+}
@@ -0,0 +1,312 @@
+// Package backup archives GoFire's configuration with the files it keeps its state in (the
+// rules, the presets, the usage, the sensor history, the tokens and so on) as one gzipped tar,
+// and restores such an archive, so that a setup can be rebuilt on a new SD card in minutes.
+//
+// Each file is named in the archive after its configuration key, e.g. usage.file, the files
+// under a directory after its key and their path in it, e.g. history.dir/2026-10.csv, and the
+// configuration itself config.yaml. A restore puts each file where the running configuration
+// says, then restarts GoFire into the restored one, which must keep its state in the same
+// files and run the same programs (hooks, self_update) as the running one. The safe mode's
+// record of starts isn't archived, nor are TLS certificates, which are made again or reissued.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+const (
+	manifestName = "gofire-backup.json"
+	configName   = "config.yaml"
+)
+
+// maxConfigSize bounds the configuration read from an archive.
+const maxConfigSize = 1 << 20
+
+// ErrBadArchive is returned by Restore for an archive it can't restore; nothing is changed.
+var ErrBadArchive = errors.New("backup: not a GoFire backup")
+
+// Manifest comes first in an archive.
+type Manifest struct {
+	Version string    `json:"version"` // of the GoFire that wrote it
+	Created time.Time `json:"created"`
+	Files   []string  `json:"files"`
+}
+
+// Archiver archives the setup GoFire runs with, and restores one.
+type Archiver struct {
+	configPath string
+	cfg        *config.Config
+	version    string
+
+	// Flush, when not nil, writes the state kept in memory, such as the usage, to its files
+	// before an archive is made.
+	Flush func()
+	// Restart, when not nil, restarts GoFire into a restored setup.
+	Restart func()
+}
+
+// New returns an Archiver of cfg, loaded from configPath, for GoFire version.
+func New(configPath string, cfg *config.Config, version string) *Archiver {
+	return &Archiver{configPath: configPath, cfg: cfg, version: version}
+}
+
+// entry is a file, or a directory of them, archived under name.
+type entry struct {
+	name, path string
+	dir        bool
+}
+
+// entries returns what cfg keeps its state in.
+func entries(cfg *config.Config) []entry {
+	var out []entry
+	file := func(name, path string) {
+		if path != "" {
+			out = append(out, entry{name: name, path: path})
+		}
+	}
+	dir := func(name, path string) {
+		if path != "" {
+			out = append(out, entry{name: name, path: path, dir: true})
+		}
+	}
+	file("rules_file", cfg.RulesFile)
+	file("auth.tokens_file", cfg.Auth.TokensFile)
+	file("auth.profiles_file", cfg.Auth.ProfilesFile)
+	if cfg.HomeKit != nil {
+		file("homekit.state_file", cfg.HomeKit.StateFile)
+	}
+	file("remotes.state_file", cfg.Remotes.StateFile)
+	file("valve.calibration_file", cfg.Valve.CalibrationFile)
+	if cfg.Valve.Wear != nil {
+		file("valve.wear.file", cfg.Valve.Wear.File)
+	}
+	if cfg.Usage != nil {
+		file("usage.file", cfg.Usage.File)
+	}
+	if cfg.Webhooks != nil {
+		file("webhooks.file", cfg.Webhooks.File)
+	}
+	if cfg.Presets != nil {
+		file("presets.file", cfg.Presets.File)
+	}
+	if cfg.Lock != nil {
+		file("lock.file", cfg.Lock.File)
+	}
+	file("estop.file", cfg.EStop.File)
+	file("startup.state_file", cfg.Startup.StateFile)
+	dir("history.dir", cfg.History.Dir)
+	if cfg.Audit != nil {
+		dir("audit.dir", cfg.Audit.Dir)
+	}
+	return out
+}
+
+// Write writes an archive of the configuration and the state files that exist to w.
+func (a *Archiver) Write(w io.Writer) error {
+	if a.Flush != nil {
+		a.Flush()
+	}
+	files := map[string]string{configName: a.configPath}
+	names := []string{configName}
+	for _, e := range entries(a.cfg) {
+		if !e.dir {
+			if _, err := os.Stat(e.path); err == nil {
+				files[e.name] = e.path
+				names = append(names, e.name)
+			}
+			continue
+		}
+		err := filepath.Walk(e.path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.Mode().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(e.path, p)
+			if err != nil {
+				return err
+			}
+			name := e.name + "/" + filepath.ToSlash(rel)
+			files[name] = p
+			names = append(names, name)
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	data, err := json.MarshalIndent(Manifest{Version: a.version, Created: time.Now().UTC(), Files: names}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := add(tw, manifestName, data, time.Now()); err != nil {
+		return err
+	}
+	for _, name := range names {
+		fi, err := os.Stat(files[name])
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(files[name])
+		if err != nil {
+			return err
+		}
+		if err := add(tw, name, data, fi.ModTime()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func add(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// Restore puts the configuration and state files of the archive read from r in place, and
+// returns their names. The configuration must load and agree with the running one (see
+// mismatch); the other files go where the running configuration says, those it has no place
+// for being skipped. Nothing is changed unless the whole archive is read. Restart is then
+// called.
+func (a *Archiver) Restore(r io.Reader) ([]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, ErrBadArchive
+	}
+	tr := tar.NewReader(gz)
+	var m Manifest
+	if hdr, err := tr.Next(); err != nil || hdr.Name != manifestName || json.NewDecoder(tr).Decode(&m) != nil {
+		return nil, ErrBadArchive
+	}
+	var staged []string // each file's path, its new content being at path.restore
+	defer func() {
+		for _, p := range staged {
+			os.Remove(p + ".restore")
+		}
+	}()
+	stage := func(p string, r io.Reader) error {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(p+".restore", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		staged = append(staged, p)
+		_, err = io.Copy(f, r)
+		if err == nil {
+			// synced before any file is renamed into place
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	// the configuration, which says where the rest go, comes next
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != configName {
+		return nil, ErrBadArchive
+	}
+	if err := stage(a.configPath, io.LimitReader(tr, maxConfigSize)); err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load(a.configPath + ".restore")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrBadArchive, configName, err)
+	}
+	if what := a.mismatch(cfg); what != "" {
+		return nil, fmt.Errorf("%w: %s: %s differs from the running configuration", ErrBadArchive, configName, what)
+	}
+	restored := []string{configName}
+	es := entries(a.cfg)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadArchive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		p, ok := place(es, hdr.Name)
+		if !ok {
+			logging.Logf(logging.Warning, "backup: skipping %s, which the configuration has no place for", hdr.Name)
+			continue
+		}
+		if err := stage(p, tr); err != nil {
+			return nil, err
+		}
+		restored = append(restored, hdr.Name)
+	}
+	// the configuration last, so that a restore cut short by a crash can be run again
+	for i := len(staged) - 1; i >= 0; i-- {
+		if err := os.Rename(staged[i]+".restore", staged[i]); err != nil {
+			return nil, err
+		}
+	}
+	staged = nil
+	logging.Event(logging.Notice, "backup restored", "version", m.Version, "created", m.Created.Format(time.RFC3339),
+		"files", strings.Join(restored, ","))
+	if a.Restart != nil {
+		a.Restart()
+	}
+	return restored, nil
+}
+
+// mismatch returns what of cfg, an archive's configuration, a restore won't take from it: the
+// files state is kept in, which are put where the running configuration has them, and the
+// settings that run programs, which an archive mustn't bring in.
+func (a *Archiver) mismatch(cfg *config.Config) string {
+	switch {
+	case !reflect.DeepEqual(entries(cfg), entries(a.cfg)):
+		return "the state files"
+	case !reflect.DeepEqual(cfg.Hooks, a.cfg.Hooks):
+		return "hooks"
+	case !reflect.DeepEqual(cfg.SelfUpdate, a.cfg.SelfUpdate):
+		return "self_update"
+	}
+	return ""
+}
+
+// place returns the path the file name of an archive goes to.
+func place(es []entry, name string) (string, bool) {
+	for _, e := range es {
+		if !e.dir {
+			if name == e.name {
+				return e.path, true
+			}
+			continue
+		}
+		rel := strings.TrimPrefix(name, e.name+"/")
+		if rel == name || rel == "" || path.IsAbs(rel) || path.Clean(rel) != rel || strings.HasPrefix(rel, "../") || rel == ".." {
+			continue
+		}
+		return filepath.Join(e.path, filepath.FromSlash(rel)), true
+	}
+	return "", false
+}
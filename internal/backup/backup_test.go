@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/barrylb/go-fire/internal/config"
+)
+
+func TestPlace(t *testing.T) {
+	es := []entry{
+		{name: "usage.file", path: "/var/lib/gofire/usage.json"},
+		{name: "history.dir", path: "/var/lib/gofire/history", dir: true},
+	}
+	tests := []struct {
+		name string
+		want string // empty when the file has no place
+	}{
+		{"usage.file", "/var/lib/gofire/usage.json"},
+		{"history.dir/2026-10.csv", "/var/lib/gofire/history/2026-10.csv"},
+		{"history.dir/den/2026-10.csv", "/var/lib/gofire/history/den/2026-10.csv"},
+		{"presets.file", ""},
+		{"usage.file/x", ""},
+		{"history.dir", ""},
+		{"history.dir/", ""},
+		{"history.dir/..", ""},
+		{"history.dir/../../../etc/passwd", ""},
+		{"history.dir/den/../../config.yaml", ""},
+		{"history.dir//etc/passwd", ""},
+		{"history.dir/./2026-10.csv", ""},
+		{"history.dir/den/", ""},
+		{"history.dirt/2026-10.csv", ""},
+	}
+	for _, tt := range tests {
+		p, ok := place(es, tt.name)
+		if ok != (tt.want != "") || p != filepath.FromSlash(tt.want) {
+			t.Errorf("place(%q) = %q, %v; want %q", tt.name, p, ok, tt.want)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive string // the archived configuration, %[1]s being the directory
+		wantErr bool
+	}{
+		{"same setup", "usage: {file: %[1]s/usage.json}\n", false},
+		{"state elsewhere", "usage: {file: %[1]s/../usage.json}\n", true},
+		{"more state", "usage: {file: %[1]s/usage.json}\npresets: {file: /etc/cron.d/gofire}\n", true},
+		{"hooks", "usage: {file: %[1]s/usage.json}\nhooks: {commands: {pre_on: touch %[1]s/pwned}}\n", true},
+		{"self update", "usage: {file: %[1]s/usage.json}\nself_update: {repo: someone/else, public_key: AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=}\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			running := fmt.Sprintf("usage: {file: %s/usage.json}\n", dir)
+			write(t, filepath.Join(dir, "config.yaml"), running)
+			write(t, filepath.Join(dir, "src.yaml"), fmt.Sprintf(tt.archive, dir))
+			write(t, filepath.Join(dir, "usage.json"), "archived")
+			var archive bytes.Buffer
+			if err := New(filepath.Join(dir, "src.yaml"), load(t, filepath.Join(dir, "src.yaml")), "test").Write(&archive); err != nil {
+				t.Fatal(err)
+			}
+			write(t, filepath.Join(dir, "usage.json"), "current")
+
+			a := New(filepath.Join(dir, "config.yaml"), load(t, filepath.Join(dir, "config.yaml")), "test")
+			restarted := false
+			a.Restart = func() { restarted = true }
+			_, err := a.Restore(&archive)
+			if tt.wantErr {
+				if !errors.Is(err, ErrBadArchive) {
+					t.Fatalf("got %v, want %v", err, ErrBadArchive)
+				}
+				if restarted || read(t, filepath.Join(dir, "usage.json")) != "current" ||
+					read(t, filepath.Join(dir, "config.yaml")) != running {
+					t.Error("a refused archive changed the setup")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !restarted || read(t, filepath.Join(dir, "usage.json")) != "archived" {
+				t.Error("the archive wasn't restored")
+			}
+		})
+	}
+}
+
+func load(t *testing.T, path string) *config.Config {
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func write(t *testing.T, path, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,104 @@
+// Package bridge drives fireplaces that have their own network module, so installations
+// mixing them with relay-controlled valves get the same API, rules and history.
+//
+// Each supported protocol implements Protocol; the driver serialises operations and maps
+// them onto it. Only Escea's documented LAN protocol is implemented so far.
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"golang.org/x/sync/semaphore"
+)
+
+// Protocol talks to one networked fireplace.
+type Protocol interface {
+	On() error
+	Off() error
+	FlameUp() error
+	FlameDown() error
+	Aux() error
+	SetFan(speed int) error
+}
+
+// protocols builds each supported protocol from the configuration.
+var protocols = map[string]func(cfg config.Bridge) (Protocol, error){
+	"escea": newEscea,
+}
+
+// Driver forwards fireplace operations over the network, one at a time.
+type Driver struct {
+	proto Protocol
+	sem   *semaphore.Weighted
+
+	// CheckIgnition, if set, is consulted before lighting the fire, as for gv60.
+	CheckIgnition func() error
+	// Wait is how long an operation waits for a running one before failing with ErrBusy.
+	Wait time.Duration
+}
+
+var _ fireplace.Fireplace = (*Driver)(nil)
+var _ fireplace.Fan = (*Driver)(nil)
+
+// New returns a driver for the configured fireplace.
+func New(cfg config.Bridge) (*Driver, error) {
+	newProto, ok := protocols[cfg.Protocol]
+	if !ok {
+		return nil, fmt.Errorf("bridge: unknown protocol %q", cfg.Protocol)
+	}
+	p, err := newProto(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Driver{proto: p, sem: semaphore.NewWeighted(1)}, nil
+}
+
+// On lights the fire, unless CheckIgnition refuses.
+func (d *Driver) On() error {
+	if d.CheckIgnition != nil {
+		if err := d.CheckIgnition(); err != nil {
+			return fmt.Errorf("%w: %v", fireplace.ErrLockout, err)
+		}
+	}
+	return d.do(d.proto.On)
+}
+
+func (d *Driver) Off() error       { return d.do(d.proto.Off) }
+func (d *Driver) FlameUp() error   { return d.do(d.proto.FlameUp) }
+func (d *Driver) FlameDown() error { return d.do(d.proto.FlameDown) }
+func (d *Driver) Aux() error       { return d.do(d.proto.Aux) }
+
+// SetFan sets the fan speed, 0 for off.
+func (d *Driver) SetFan(speed int) error {
+	return d.do(func() error { return d.proto.SetFan(speed) })
+}
+
+// Cancel reports false: bridged operations are single requests that can't be cut short.
+func (d *Driver) Cancel() bool {
+	return false
+}
+
+// Drain waits for a running operation and then refuses new ones with ErrBusy.
+func (d *Driver) Drain() {
+	d.sem.Acquire(context.Background(), 1)
+}
+
+func (d *Driver) do(op func() error) error {
+	if d.Wait <= 0 {
+		if !d.sem.TryAcquire(1) {
+			return fireplace.ErrBusy
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), d.Wait)
+		defer cancel()
+		if d.sem.Acquire(ctx, 1) != nil {
+			return fireplace.ErrBusy
+		}
+	}
+	defer d.sem.Release(1)
+	return op()
+}
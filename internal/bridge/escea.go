@@ -0,0 +1,167 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fireplace"
+)
+
+// Escea fireplaces listen on UDP port 3300 for fixed 15-byte messages:
+//
+//	0      start (0x47 'G')
+//	1      command or response id
+//	2      data length
+//	3-12   data, zero padded
+//	13     checksum: sum of bytes 1-12, modulo 256
+//	14     end (0x46 'F')
+const (
+	esceaPort    = 3300
+	esceaLen     = 15
+	esceaStart   = 0x47
+	esceaEnd     = 0x46
+	esceaMinTemp = 3
+	esceaMaxTemp = 31
+)
+
+// Escea commands. Every command is answered, with a status response for a status plea
+// and an acknowledgement for the others.
+const (
+	esceaStatus      = 0x31
+	esceaFanBoostOn  = 0x37
+	esceaFanBoostOff = 0x38
+	esceaPowerOn     = 0x39
+	esceaPowerOff    = 0x3A
+	esceaFlameOff    = 0x55
+	esceaFlameOn     = 0x56
+	esceaSetTemp     = 0x57
+	esceaStatusResp  = 0x80
+
+	// data offsets in a status response
+	esceaStatusOn     = 4
+	esceaStatusFlame  = 6
+	esceaStatusTarget = 7
+)
+
+// escea drives an Escea gas fireplace. It has a thermostat rather than flame steps, so
+// flame up and down move the set temperature by one degree; aux toggles the flame effect.
+type escea struct {
+	addr    string
+	timeout time.Duration
+}
+
+func newEscea(cfg config.Bridge) (Protocol, error) {
+	addr := cfg.Address
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, fmt.Sprint(esceaPort))
+	}
+	return &escea{addr: addr, timeout: cfg.Timeout}, nil
+}
+
+func (e *escea) On() error  { return e.command(esceaPowerOn, nil) }
+func (e *escea) Off() error { return e.command(esceaPowerOff, nil) }
+
+func (e *escea) FlameUp() error   { return e.stepTemp(1) }
+func (e *escea) FlameDown() error { return e.stepTemp(-1) }
+
+func (e *escea) Aux() error {
+	st, err := e.status()
+	if err != nil {
+		return err
+	}
+	if st[esceaStatusFlame] != 0 {
+		return e.command(esceaFlameOff, nil)
+	}
+	return e.command(esceaFlameOn, nil)
+}
+
+// SetFan turns fan boost on for any speed above 0; Escea fans have no other speeds.
+func (e *escea) SetFan(speed int) error {
+	if speed > 0 {
+		return e.command(esceaFanBoostOn, nil)
+	}
+	return e.command(esceaFanBoostOff, nil)
+}
+
+func (e *escea) stepTemp(delta int) error {
+	st, err := e.status()
+	if err != nil {
+		return err
+	}
+	if st[esceaStatusOn] == 0 {
+		return fireplace.ErrUnsupported
+	}
+	t := int(st[esceaStatusTarget]) + delta
+	if t < esceaMinTemp || t > esceaMaxTemp {
+		return nil
+	}
+	return e.command(esceaSetTemp, []byte{byte(t)})
+}
+
+func (e *escea) status() ([]byte, error) {
+	resp, err := e.exchange(esceaStatus, nil, func(id byte) bool { return id == esceaStatusResp })
+	if err != nil {
+		return nil, err
+	}
+	return resp[3:13], nil
+}
+
+// command sends cmd and waits for its acknowledgement, which is any response other than
+// a status response (the fireplace may send those unprompted).
+func (e *escea) command(cmd byte, data []byte) error {
+	_, err := e.exchange(cmd, data, func(id byte) bool { return id != esceaStatusResp })
+	return err
+}
+
+// exchange sends one message and waits for a response whose id is wanted, trying three
+// times since UDP messages can be lost.
+func (e *escea) exchange(cmd byte, data []byte, want func(id byte) bool) ([]byte, error) {
+	conn, err := net.Dial("udp", e.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	msg := esceaMessage(cmd, data)
+	buf := make([]byte, 64)
+	for try := 0; try < 3; try++ {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(e.timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break // timed out; send again
+			}
+			resp := buf[:n]
+			if validEscea(resp) && want(resp[1]) {
+				return append([]byte(nil), resp...), nil
+			}
+		}
+	}
+	return nil, errors.New("escea: no response from " + e.addr)
+}
+
+func esceaMessage(cmd byte, data []byte) []byte {
+	m := make([]byte, esceaLen)
+	m[0], m[1], m[2] = esceaStart, cmd, byte(len(data))
+	copy(m[3:13], data)
+	m[13] = esceaChecksum(m)
+	m[14] = esceaEnd
+	return m
+}
+
+func esceaChecksum(m []byte) byte {
+	var sum byte
+	for _, b := range m[1:13] {
+		sum += b
+	}
+	return sum
+}
+
+func validEscea(m []byte) bool {
+	return len(m) == esceaLen && m[0] == esceaStart && m[14] == esceaEnd && m[13] == esceaChecksum(m)
+}
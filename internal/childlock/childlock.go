@@ -0,0 +1,132 @@
+// Package childlock is the child (or maintenance) lock: while it is locked, every command
+// but off is refused whichever interface or automation sends it, for cleaning the glass or
+// when a child has the dashboard. It is locked and unlocked with a PIN, and a run of wrong
+// PINs holds off further attempts for a while, so a four-digit PIN can't simply be guessed.
+package childlock
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+var (
+	// ErrPIN is returned for a wrong PIN.
+	ErrPIN = errors.New("childlock: wrong PIN")
+	// ErrWait is returned while attempts are held off after wrong PINs.
+	ErrWait = errors.New("childlock: too many wrong PINs; try again later")
+)
+
+const (
+	// maxTries wrong PINs in a row hold off further attempts for holdOff.
+	maxTries = 5
+	holdOff  = time.Minute
+)
+
+// Lock is the child lock.
+type Lock struct {
+	cfg config.Lock
+
+	mu      sync.Mutex
+	state   State
+	tries   int       // wrong PINs in a row
+	heldOff time.Time // no attempts until then
+}
+
+// State is whether the lock is locked, since when and by whom.
+type State struct {
+	Locked bool       `json:"locked"`
+	Since  *time.Time `json:"since,omitempty"`
+	By     string     `json:"by,omitempty"` // the client that locked it
+}
+
+// New returns the lock of cfg, locked if it was when last kept in cfg.File.
+func New(cfg config.Lock) (*Lock, error) {
+	l := &Lock{cfg: cfg}
+	if cfg.File == "" {
+		return l, nil
+	}
+	data, err := ioutil.ReadFile(cfg.File)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &l.state); err != nil {
+		return nil, fmt.Errorf("lock: %s: %v", cfg.File, err)
+	}
+	if l.state.Locked {
+		logging.Event(logging.Notice, "child lock still locked", "by", l.state.By)
+	}
+	return l, nil
+}
+
+// Set locks (locked) or unlocks the lock on behalf of by, given pin.
+func (l *Lock) Set(locked bool, pin, by string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Before(l.heldOff) {
+		return ErrWait
+	}
+	if subtle.ConstantTimeCompare([]byte(pin), []byte(l.cfg.PIN)) != 1 {
+		if l.tries++; l.tries >= maxTries {
+			l.tries, l.heldOff = 0, now.Add(holdOff)
+			logging.Event(logging.Warning, "child lock: too many wrong PINs", "from", by)
+		}
+		return ErrPIN
+	}
+	l.tries = 0
+	if locked == l.state.Locked {
+		return nil
+	}
+	l.state = State{Locked: locked}
+	if locked {
+		l.state.Since, l.state.By = &now, by
+	}
+	return l.save()
+}
+
+// State returns the lock's state.
+func (l *Lock) State() State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+// Guard refuses every command but off while the lock is locked.
+func (l *Lock) Guard(op, source string) error {
+	if op == "off" {
+		return nil
+	}
+	if !l.State().Locked {
+		return nil
+	}
+	logging.Event(logging.Notice, "command blocked by the child lock", "op", op, "source", source)
+	events.Publish(events.Command{Op: "child_lock", Result: "blocked", Source: source, Params: map[string]string{"op": op}})
+	return fmt.Errorf("%w: the fireplace is locked", fireplace.ErrLockout)
+}
+
+// save writes the state to the file, if any; callers must hold mu.
+func (l *Lock) save() error {
+	if l.cfg.File == "" {
+		return nil
+	}
+	data, err := json.Marshal(l.state)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(l.cfg.File, data, 0600)
+}
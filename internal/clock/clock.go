@@ -0,0 +1,70 @@
+// Package clock lets the scheduler run against either the real time or a simulated clock
+// that can be fast-forwarded, to check a week's program in minutes.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the system clock.
+type Real struct{}
+
+// Now returns the current time.
+func (Real) Now() time.Time { return time.Now() }
+
+// Sim is a simulated clock. It stands still except when advanced, and tells its listeners
+// about every minute it passes through so that nothing scheduled is skipped.
+type Sim struct {
+	mu        sync.Mutex // held while advancing, so advances don't interleave
+	now       time.Time
+	listeners []func(now time.Time)
+}
+
+// NewSim returns a simulated clock showing start.
+func NewSim(start time.Time) *Sim {
+	return &Sim{now: start}
+}
+
+// Now returns the simulated time.
+func (s *Sim) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now
+}
+
+// OnAdvance registers f to be called with each minute the clock passes through.
+func (s *Sim) OnAdvance(f func(now time.Time)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, f)
+}
+
+// Advance moves the clock forward by d a minute at a time, returning once every listener
+// has handled every minute.
+func (s *Sim) Advance(d time.Duration) time.Time {
+	s.mu.Lock()
+	end := s.now.Add(d)
+	listeners := s.listeners
+	s.mu.Unlock()
+	for {
+		s.mu.Lock()
+		step := s.now.Add(time.Minute)
+		if step.After(end) {
+			step = end
+		}
+		s.now = step
+		s.mu.Unlock()
+		for _, f := range listeners {
+			f(step)
+		}
+		if !step.Before(end) {
+			return step
+		}
+	}
+}
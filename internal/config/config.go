@@ -0,0 +1,1851 @@
+// Package config loads the optional YAML configuration file given with -config.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the whole configuration file.
+type Config struct {
+	// TemperatureUnit is "C" (default) or "F"; all temperatures in the config use it.
+	TemperatureUnit string  `yaml:"temperature_unit"`
+	Sensors         Sensors `yaml:"sensors"`
+	Lockout         Lockout `yaml:"lockout"`
+	History         History `yaml:"history"`
+	Metrics         Metrics `yaml:"metrics"`
+	Logging         Logging `yaml:"logging"`
+	HTTP            HTTP    `yaml:"http"`
+	Auth            Auth    `yaml:"auth"`
+	// GATT serves a BLE control service from the Pi's radio when set.
+	GATT *GATT `yaml:"gatt"`
+	// HomeKit serves the fireplace as a HomeKit accessory when set.
+	HomeKit *HomeKit `yaml:"homekit"`
+	// Discovery advertises the server on the LAN over mDNS when set.
+	Discovery *Discovery `yaml:"discovery"`
+	// Google serves Google Home smart home fulfillment at /google when set.
+	Google *Google `yaml:"google"`
+	// Alexa answers Alexa Smart Home skill directives at /alexa when set.
+	Alexa   *Alexa  `yaml:"alexa"`
+	Remotes Remotes `yaml:"remotes"`
+	Hue     Hue     `yaml:"hue"`
+	MQTT    MQTT    `yaml:"mqtt"`
+	// GPIOChip is the chip the relay and input lines are on, by name, /dev path or label;
+	// default gpiochip0. A Pi 5's header is gpiochip4 or gpiochip0 depending on the kernel,
+	// so is best given by its label, pinctrl-rp1. mock drives no hardware and logs the relay
+	// transitions.
+	GPIOChip string `yaml:"gpio_chip"`
+	// RunAs is the user GoFire switches to once the hardware is open, when started as root.
+	RunAs string `yaml:"run_as"`
+	// Driver runs the fireplace: relay (the valve's wall-switch contacts, default), proflame,
+	// bridge, simulated (no hardware, for trying out schedules) or mock (relay on a mock
+	// GPIO chip, for development and CI).
+	Driver string `yaml:"driver"`
+	Valve  Valve  `yaml:"valve"`
+	// Fireplaces are further fireplaces on relays of the same GPIO chip, served by name
+	// under /fireplaces/; each has its own contacts, valve sequences and state.
+	Fireplaces map[string]Fireplace `yaml:"fireplaces"`
+	// RelayBoards are relay boards behind an I2C port expander rather than on GPIO lines, by
+	// name, for the valve, a further fireplace or an accessory to pick with board.
+	RelayBoards map[string]RelayBoard `yaml:"relay_boards"`
+	Proflame    *Proflame             `yaml:"proflame"`
+	Bridge      *Bridge               `yaml:"bridge"`
+	// Interlock coordinates the fireplace with the central heating when set.
+	Interlock *Interlock `yaml:"interlock"`
+	// Interlocks are GPIO inputs that must be closed for the fire to be lit or turned up.
+	Interlocks []InterlockInput `yaml:"interlocks"`
+	// Accessories are relay channels for a blower fan, accent lighting and the like, served
+	// by name under /accessory/.
+	Accessories []Accessory `yaml:"accessories"`
+	// DemandResponse follows a utility peak-price signal when set.
+	DemandResponse *DemandResponse `yaml:"demand_response"`
+	// SelfUpdate installs signed releases when set.
+	SelfUpdate *SelfUpdate `yaml:"self_update"`
+	// Thermostat holds the room at a target temperature when set.
+	Thermostat *Thermostat `yaml:"thermostat"`
+	// FrostProtection lights the fire at its lowest flame when it gets too cold, when set.
+	FrostProtection *FrostProtection `yaml:"frost_protection"`
+	// AutoOff turns the fire off after a continuous burn.
+	AutoOff AutoOff `yaml:"auto_off"`
+	// Usage keeps the burn time per day, for /usage, when set.
+	Usage *Usage `yaml:"usage"`
+	// Webhooks calls URLs on the fireplace's events, when set.
+	Webhooks *Webhooks `yaml:"webhooks"`
+	// Presets are named settings of the fire, its fan and its light, applied in one command,
+	// when set.
+	Presets *Presets `yaml:"presets"`
+	// Notifications sends safety events to phones and inboxes, when set.
+	Notifications *Notifications `yaml:"notifications"`
+	// Hooks run shell commands on the fireplace's commands, when set.
+	Hooks *Hooks `yaml:"hooks"`
+	// Ignition checks that the fire lights after on, and retries, when set.
+	Ignition *Ignition `yaml:"ignition"`
+	// Heartbeat requires heartbeats from a supervisor while the fire burns when set.
+	Heartbeat *Heartbeat `yaml:"heartbeat"`
+	// Presence turns the fire down or off once nobody is home, when set.
+	Presence *Presence `yaml:"presence"`
+	// QuietHours refuses ignition, or caps the flame, overnight or at other set hours, when
+	// set.
+	QuietHours *QuietHours `yaml:"quiet_hours"`
+	// Audit keeps a persistent trail of every command, when set.
+	Audit *Audit `yaml:"audit"`
+	// Lock enables the child (or maintenance) lock, when set.
+	Lock *Lock `yaml:"lock"`
+	// EStop configures the emergency stop, which is always available.
+	EStop EStop `yaml:"estop"`
+	// Modulation configures /modulate, which cycles the burner for less heat than the lowest
+	// flame gives; it is always available.
+	Modulation Modulation `yaml:"modulation"`
+	// SafeMode latches a safe mode after a crash loop when set.
+	SafeMode *SafeMode `yaml:"safe_mode"`
+	// Startup sets what the fireplace is taken to be at start.
+	Startup Startup `yaml:"startup"`
+	// SelfTest sets up the relay self-test.
+	SelfTest SelfTest `yaml:"self_test"`
+	// RulesFile keeps the automation rules; empty disables them.
+	RulesFile string   `yaml:"rules_file"`
+	Schedule  Schedule `yaml:"schedule"`
+	// Simulation runs the rules against a simulated clock when set.
+	Simulation *Simulation `yaml:"simulation"`
+	// CommandPriority arbitrates between command sources when set.
+	CommandPriority *CommandPriority `yaml:"command_priority"`
+	// ZigbeeButtons maps zigbee2mqtt button events to actions.
+	ZigbeeButtons []ZigbeeButton `yaml:"zigbee_buttons"`
+	// Listeners replaces -listen_on when set.
+	Listeners []Listener `yaml:"listeners"`
+}
+
+// Listener is one address the API is served on, e.g. a loopback-only admin listener needing
+// no token beside a LAN listener that does:
+//
+//   - address: 127.0.0.1:8601
+//     trusted: true
+//   - address: "[::]:8600"
+//   - address: unix:/run/gofire/gofire.sock
+//     routes: [/sensors, /history]
+type Listener struct {
+	// Name matches a systemd FileDescriptorName= when socket activated; defaults to Address.
+	Name string `yaml:"name"`
+	// Address is host:port, where [::]:8600 is dual-stack and [fe80::1%eth0]:8600 link-local,
+	// or unix:path for a Unix domain socket, created with SocketMode (octal, default 0660).
+	Address    string   `yaml:"address"`
+	SocketMode string   `yaml:"socket_mode"`
+	Routes     []string `yaml:"routes"` // routes served on this listener; all when empty
+	// Trusted serves requests without a token even with auth on; it needs a Unix socket or
+	// a loopback address.
+	Trusted bool `yaml:"trusted"`
+	// TLS serves HTTPS on this listener.
+	TLS *ListenerTLS `yaml:"tls"`
+	// Redirect makes this a plain-HTTP listener that only redirects to the first TLS
+	// listener (and serves ACME HTTP-01 challenges from http.acme_challenge_dir).
+	Redirect bool `yaml:"redirect"`
+}
+
+// ListenerTLS is a listener's certificate and key, PEM encoded. With SelfSigned, a
+// self-signed certificate is generated and saved to them on first run.
+type ListenerTLS struct {
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	SelfSigned bool   `yaml:"self_signed"`
+}
+
+// Auth protects the routes wrapped in the auth middleware with bearer tokens.
+type Auth struct {
+	// AdminTokens grant every scope, including minting guest tokens. -admin_token and the
+	// GOFIRE_ADMIN_TOKEN environment variable add one more. Without any admin token or
+	// Tokens, auth is off.
+	AdminTokens []string `yaml:"admin_tokens"`
+	// Tokens are fixed tokens with limited scopes, e.g. a wall display's read-only token.
+	Tokens []StaticToken `yaml:"tokens"`
+	// TokensFile keeps guest tokens and used action URLs across restarts.
+	TokensFile string `yaml:"tokens_file"`
+	// URLKey (hex) signs one-time action URLs; without it a random key is used and links
+	// stop working on restart.
+	URLKey string `yaml:"url_key"`
+	// ProfilesFile keeps each user's preferences; empty disables /profile.
+	ProfilesFile string `yaml:"profiles_file"`
+}
+
+// StaticToken is a token from the configuration granting Scopes: route names such as on and
+// off, read for the read-only routes, or admin.
+type StaticToken struct {
+	Name   string   `yaml:"name"`
+	Token  string   `yaml:"token"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// HTTP configures the middleware wrapped around the API's routes, e.g.
+//
+//	middleware: [log, metrics]
+//	routes:
+//	  /on: [ratelimit]
+type HTTP struct {
+	// Middleware is applied to every route, outermost first.
+	Middleware []string `yaml:"middleware"`
+	// Routes adds middleware to individual routes, inside the global chain.
+	Routes    map[string][]string `yaml:"routes"`
+	CORS      CORS                `yaml:"cors"`
+	RateLimit RateLimit           `yaml:"rate_limit"`
+	// CommandRateLimit is the commandlimit middleware's allowance: one bucket per client
+	// address shared by every command route; default 20 a minute with a burst of 1, one
+	// command every 3s.
+	CommandRateLimit RateLimit `yaml:"command_rate_limit"`
+	// ACMEChallengeDir holds HTTP-01 challenge tokens served by redirect listeners.
+	ACMEChallengeDir string `yaml:"acme_challenge_dir"`
+	// TrustedProxies are the CIDRs whose X-Forwarded-For and X-Real-IP headers are believed
+	// when working out a client's address for logging and rate limiting.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// BasePath is the prefix the API is served under, e.g. /fireplace behind nginx.
+	BasePath string `yaml:"base_path"`
+	Busy     Busy   `yaml:"busy"`
+	Events   Events `yaml:"events"`
+	// DisableLegacyText stops serving the plain-text command routes (/on, /off, ...), leaving
+	// the JSON ones under /api/v1.
+	DisableLegacyText bool `yaml:"disable_legacy_text"`
+	// SwaggerUI is where /docs loads Swagger UI from to explore /openapi.json: the URL of a
+	// swagger-ui-dist release, e.g. https://unpkg.com/swagger-ui-dist@5, or a copy served on
+	// the LAN. Empty disables /docs.
+	SwaggerUI string `yaml:"swagger_ui"`
+}
+
+// Busy selects what a fireplace command does while another relay sequence is running:
+//   - busy (the default) replies op_busy at once
+//   - wait holds the request up to Timeout for the relays, then replies op_busy
+//   - queue replies 202 op_queued and runs queued commands in order, each waiting up to Timeout
+//     for the relays; one still queued after QueueTimeout is dropped
+//   - reject replies op_busy with 503 and a Retry-After header
+type Busy struct {
+	Mode         string        `yaml:"mode"`
+	Timeout      time.Duration `yaml:"timeout"`       // wait and queue; default 10s
+	QueueSize    int           `yaml:"queue_size"`    // queue; default 8, a full queue is rejected
+	QueueTimeout time.Duration `yaml:"queue_timeout"` // queue; default 1m
+	RetryAfter   time.Duration `yaml:"retry_after"`   // reject and a full queue; default 2s
+}
+
+// Events configures the /events server-sent event stream.
+type Events struct {
+	Retry     time.Duration `yaml:"retry"`      // how long clients wait to reconnect; default 3s
+	KeepAlive time.Duration `yaml:"keep_alive"` // comment sent after this long idle; default 15s
+}
+
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowed_origins"` // "*" allows any origin
+}
+
+// RateLimit allows each client address a burst of requests, refilled at PerMinute.
+type RateLimit struct {
+	PerMinute float64 `yaml:"per_minute"`
+	Burst     int     `yaml:"burst"`
+}
+
+type Logging struct {
+	// Level is the least severe level logged: err, warning, notice, info (default) or debug.
+	Level string `yaml:"level"`
+	// Format is how stderr logs are written: text (default) or json, one object per line.
+	Format   string `yaml:"format"`
+	Journald bool   `yaml:"journald"` // log natively to journald instead of stderr
+	Syslog   Syslog `yaml:"syslog"`
+}
+
+// Syslog ships logs to a remote RFC 5424 syslog server over TCP, optionally with TLS.
+type Syslog struct {
+	Address  string `yaml:"address"` // host:port; empty disables remote syslog
+	TLS      bool   `yaml:"tls"`
+	CAFile   string `yaml:"ca_file"` // CA bundle for the server certificate; system roots if empty
+	Facility string `yaml:"facility"`
+	AppName  string `yaml:"app_name"`
+}
+
+type Metrics struct {
+	Statsd Statsd `yaml:"statsd"`
+}
+
+// Statsd pushes metrics to a statsd or Telegraf statsd listener over UDP.
+type Statsd struct {
+	Address  string        `yaml:"address"` // host:port; empty disables pushing
+	Prefix   string        `yaml:"prefix"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// History enables the on-disk sensor history and sets its retention policy.
+type History struct {
+	Dir             string        `yaml:"dir"`              // empty disables history
+	Interval        time.Duration `yaml:"interval"`         // how often readings are recorded
+	RawRetention    time.Duration `yaml:"raw_retention"`    // raw samples kept this long, then downsampled
+	HourlyRetention time.Duration `yaml:"hourly_retention"` // hourly aggregates kept this long
+}
+
+// GATT exposes power, flame level and status as a BLE GATT service.
+type GATT struct {
+	Adapter int    `yaml:"adapter"` // hciN
+	Name    string `yaml:"name"`    // advertised local name
+	// RequireEncryption makes centrals pair (Just Works) before they can use the service.
+	RequireEncryption bool `yaml:"require_encryption"`
+}
+
+// HomeKit serves the fireplace as an Apple HomeKit accessory, a light whose brightness is
+// the flame level. Pairing needs the setup code PIN; StateFile keeps the accessory's keys
+// and paired controllers.
+type HomeKit struct {
+	Name      string `yaml:"name"`    // default Fireplace
+	PIN       string `yaml:"pin"`     // setup code, e.g. 031-45-154
+	Address   string `yaml:"address"` // default :51828
+	StateFile string `yaml:"state_file"`
+}
+
+// Discovery advertises the server over mDNS as _gofire._tcp and _http._tcp, with TXT records
+// of its version, base path and features, so that apps and gofire discover find it without
+// its address. Name is the instance name shown for it, default "GoFire on <hostname>".
+type Discovery struct {
+	Name string `yaml:"name"`
+}
+
+// Google answers Google Home's smart home intents for one fireplace device with an on/off
+// trait and a flame mode, whose settings are the flame levels.
+type Google struct {
+	Name        string `yaml:"name"`          // default Fireplace
+	AgentUserID string `yaml:"agent_user_id"` // default gofire
+}
+
+// Alexa answers an Alexa Smart Home skill's directives, forwarded by its Lambda function,
+// for one endpoint that switches on and off and dims, its brightness being the flame level.
+type Alexa struct {
+	Name string `yaml:"name"` // default Fireplace
+}
+
+// Remotes registers the remotes allowed to send authenticated single-packet commands and
+// the transports they can use.
+type Remotes struct {
+	UDPAddress string         `yaml:"udp_address"` // host:port; empty disables UDP
+	LoRa       *LoRa          `yaml:"lora"`
+	StateFile  string         `yaml:"state_file"` // last accepted counter of each remote
+	Devices    []RemoteDevice `yaml:"devices"`
+}
+
+type RemoteDevice struct {
+	ID   uint16 `yaml:"id"`
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"` // hex HMAC key, at least 16 bytes
+}
+
+// LoRa receives remote commands through an SX127x module on SPI.
+type LoRa struct {
+	Device          string `yaml:"device"`           // e.g. /dev/spidev0.1
+	Frequency       int    `yaml:"frequency"`        // Hz, e.g. 868100000 or 915000000
+	SpreadingFactor int    `yaml:"spreading_factor"` // 6-12
+	Bandwidth       int    `yaml:"bandwidth"`        // Hz
+	CodingRate      int    `yaml:"coding_rate"`      // denominator of 4/5 to 4/8
+	SyncWord        int    `yaml:"sync_word"`        // 0x12 private networks, 0x34 is LoRaWAN
+	TxPower         int    `yaml:"tx_power"`         // dBm for ACKs, 2-17
+}
+
+// MQTT is the broker shared by the MQTT integrations.
+type MQTT struct {
+	Broker   string `yaml:"broker"` // e.g. tcp://192.168.1.5:1883; empty disables MQTT
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	ClientID string `yaml:"client_id"`
+	// Device publishes the fireplace's state and takes commands over MQTT when set.
+	Device *MQTTDevice `yaml:"device"`
+}
+
+// MQTTDevice publishes state under TopicPrefix and takes commands from its /set topics, and
+// announces the fireplace to Home Assistant through MQTT Discovery under DiscoveryPrefix.
+type MQTTDevice struct {
+	TopicPrefix     string `yaml:"topic_prefix"`     // default gofire
+	DiscoveryPrefix string `yaml:"discovery_prefix"` // default homeassistant; "-" disables discovery
+	Name            string `yaml:"name"`             // default Fireplace
+}
+
+// ZigbeeButton maps the events of one zigbee2mqtt device to actions, e.g.
+//
+//   - topic: zigbee2mqtt/Lounge button
+//     actions: {single: on, double: off, hold: light_toggle}
+type ZigbeeButton struct {
+	Topic       string            `yaml:"topic"`
+	ActionField string            `yaml:"action_field"` // payload field holding the event; default "action"
+	Actions     map[string]string `yaml:"actions"`      // event -> action
+}
+
+// Hue maps the buttons of a Hue dimmer or Tap switch to actions, e.g.
+//
+//	bridge: 192.168.1.20
+//	application_key: ...
+//	switch: Lounge dimmer
+//	buttons:
+//	  - {button: 1, action: on}
+//	  - {button: 4, action: off}
+//	  - {button: 4, event: long_press, action: light_toggle}
+type Hue struct {
+	Bridge         string      `yaml:"bridge"` // address; empty disables
+	ApplicationKey string      `yaml:"application_key"`
+	Switch         string      `yaml:"switch"` // device name as shown in the Hue app
+	Buttons        []HueButton `yaml:"buttons"`
+}
+
+type HueButton struct {
+	Button int    `yaml:"button"` // 1 is the top button
+	Event  string `yaml:"event"`  // initial_press, short_release, long_press, long_release, ...
+	Action string `yaml:"action"`
+}
+
+// Valve selects the valve's contact sequences and the GPIO lines driving its contacts.
+type Valve struct {
+	// Profile names a built-in profile (gv60, or gv60_dual for a second burner on contact 4)
+	// or one under Profiles; default gv60.
+	Profile  string                  `yaml:"profile"`
+	Profiles map[string]ValveProfile `yaml:"profiles"`
+	// GPIOs drive contacts 1, 2, 3, ... in order; default 26, 20, 21 (Waveshare RPi Relay Board).
+	GPIOs []int `yaml:"gpios"`
+	// Board names the relay board under relay_boards the contacts are on, GPIOs then being
+	// its pins; empty for the GPIO chip.
+	Board string `yaml:"board"`
+	// ActiveHigh is for relay boards energised by a high line; the Waveshare board is active-low.
+	// A board under relay_boards has its own.
+	ActiveHigh bool `yaml:"active_high"`
+	// Wear counts relay actuations when set.
+	Wear *RelayWear `yaml:"wear"`
+	// Levels and Travel estimate the flame level for /status: Travel is how long a flame
+	// contact must be held to take the flame from lowest to highest, divided into Levels.
+	Levels int           `yaml:"levels"` // default 6
+	Travel time.Duration `yaml:"travel"` // default 12s
+	// CalibrationFile keeps the travel time measured with /calibrate, which then replaces
+	// Travel; without it a calibration lasts until restart.
+	CalibrationFile string `yaml:"calibration_file"`
+	// ResyncAfterOn drives the flame right down a few seconds after every ignition, as
+	// /resync does, so that each fire starts at a level known to be the lowest.
+	ResyncAfterOn bool `yaml:"resync_after_on"`
+	// Debounce is the least time between fireplace commands from any source; one arriving
+	// sooner is refused as busy. Off is never held back. Zero (the default) disables it.
+	Debounce time.Duration `yaml:"debounce"`
+	// Timeouts bound how long a command may take, waiting for the relays included, by op
+	// (on, pilot, setflame, ...), with "default" for any other; one running longer is cut
+	// short with every contact opened. Zero or missing (the default) is no limit; off is
+	// never cut short. A setflame timeout needs to allow for valve.travel.
+	Timeouts map[string]time.Duration `yaml:"timeouts"`
+	// DryRun runs every command through to the end, timing, state and events included, but
+	// leaves the relays alone, logging the contacts each would have closed: for trying out
+	// automations or a new profile against a fireplace that must not fire.
+	DryRun bool `yaml:"dry_run"`
+}
+
+// Fireplace is a further fireplace's wiring: relays on the relay board of valve.gpios (so it
+// follows valve.active_high) or the one named by Board, or an RF transmitter on the same GPIO
+// chip.
+type Fireplace struct {
+	Driver  string `yaml:"driver"`  // relay (the default) or proflame
+	GPIOs   []int  `yaml:"gpios"`   // contacts 1, 2, 3, ... for relay
+	Board   string `yaml:"board"`   // under relay_boards, for relay; empty for the GPIO chip
+	Profile string `yaml:"profile"` // default valve.profile
+	// Proflame is the transmitter and captured frames for proflame.
+	Proflame *Proflame `yaml:"proflame"`
+}
+
+// Accessory is a relay channel for something beside the valve, such as a blower fan or accent
+// lighting, on the relay board of valve.gpios (so it follows valve.active_high) or the one
+// named by Board.
+type Accessory struct {
+	Name  string `yaml:"name"` // as fireplaces are named, being part of its routes and topics
+	GPIO  int    `yaml:"gpio"`
+	Board string `yaml:"board"` // under relay_boards; empty for the GPIO chip
+	// Mode is latched (the default), with the relay closed while the accessory is on, or
+	// momentary, with the relay closed for Pulse to switch it on or off, as for a device
+	// with a push button of its own.
+	Mode  string        `yaml:"mode"`
+	Pulse time.Duration `yaml:"pulse"` // default 500ms
+}
+
+// RelayBoard is an 8 or 16 channel relay board behind an I2C port expander, e.g.
+//
+//	relay_boards:
+//	  hat16:
+//	    expander: mcp23017
+//	    device: /dev/i2c-1
+//	    address: 0x20
+//	    active_high: true
+//
+// Its channels are the expander's pins, 0 to 15 (GPA0 to GPA7, then GPB0 to GPB7) on an
+// MCP23017 and 0 to 7 on a PCF8574.
+type RelayBoard struct {
+	Expander   string `yaml:"expander"`    // mcp23017 or pcf8574
+	Device     string `yaml:"device"`      // default /dev/i2c-1
+	Address    int    `yaml:"address"`     // default 0x20
+	ActiveHigh bool   `yaml:"active_high"` // relays energised by a high pin
+}
+
+// expanderPins is how many pins each I2C port expander of a relay board has.
+var expanderPins = map[string]int{"mcp23017": 16, "pcf8574": 8}
+
+// fireplaceName is what a further fireplace may be called, being part of its routes.
+var fireplaceName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// timedOps are the keys of valve.timeouts.
+var timedOps = map[string]bool{
+	"default": true, "on": true, "flameup": true, "flamedown": true, "aux": true, "aux_on": true,
+	"aux_off": true, "pilot": true, "setflame": true, "fan": true, "splitflow": true,
+}
+
+// RelayWear keeps a count of each contact relay's actuations in File and warns once a relay
+// has used WarnAt of its RatedCycles, so the board can be replaced before it fails.
+type RelayWear struct {
+	File        string  `yaml:"file"`
+	RatedCycles int64   `yaml:"rated_cycles"` // default 100000, a typical mechanical relay
+	WarnAt      float64 `yaml:"warn_at"`      // fraction of RatedCycles; default 0.8
+}
+
+// ValveProfile describes the sequences of a valve model or wiring not built in. With Base, a
+// built-in profile, it need only give what differs: steps, and a step's contacts or hold,
+// left out are the base's.
+type ValveProfile struct {
+	Base      string        `yaml:"base"`
+	On        ValveStep     `yaml:"on"`
+	Off       ValveStep     `yaml:"off"`
+	FlameUp   ValveStep     `yaml:"flameup"`
+	FlameDown ValveStep     `yaml:"flamedown"`
+	Aux       *ValveStep    `yaml:"aux"`
+	AuxOn     *ValveStep    `yaml:"aux_on"`  // second burner; leave unset for one burner
+	AuxOff    *ValveStep    `yaml:"aux_off"` // second burner
+	Pilot     *ValveStep    `yaml:"pilot"`   // down to the pilot flame
+	MinGap    time.Duration `yaml:"min_gap"` // least time between sequences
+}
+
+// ValveStep closes Contacts (numbered from 1) for Hold.
+type ValveStep struct {
+	Contacts []int         `yaml:"contacts"`
+	Hold     time.Duration `yaml:"hold"`
+}
+
+// Proflame drives a SIT Proflame 2 receiver by replaying frames captured from its remote
+// through a 315 MHz OOK transmitter module.
+type Proflame struct {
+	GPIO    int           `yaml:"gpio"`    // transmitter data pin
+	Symbol  time.Duration `yaml:"symbol"`  // length of one captured symbol
+	Repeats int           `yaml:"repeats"` // times each frame is sent; default 5
+	Gap     time.Duration `yaml:"gap"`     // between repeats; default 10ms
+	Levels  int           `yaml:"levels"`  // flame levels; default 6
+	// Frames are the captured frames by name (on, off, flame_1, fan_0, ...) as 0/1 symbols.
+	Frames map[string]string `yaml:"frames"`
+}
+
+// Bridge forwards commands to a fireplace with its own network module.
+type Bridge struct {
+	Protocol string        `yaml:"protocol"` // escea
+	Address  string        `yaml:"address"`  // host, or host:port
+	Timeout  time.Duration `yaml:"timeout"`  // per attempt; default 2s
+}
+
+// Interlock keeps the fireplace and the room's central heating from running together.
+type Interlock struct {
+	// Policy is fire_yields (no fire while the heating heats) or heating_yields (the fire's
+	// state is published for the heating controller); default fire_yields.
+	Policy string `yaml:"policy"`
+	// Topic carries the heating's state; a payload in ActiveValues means it is heating.
+	Topic        string   `yaml:"topic"`
+	ActiveValues []string `yaml:"active_values"` // default ON, heating, 1, true
+	// GPIO reads the heating's call-for-heat signal instead, active high unless ActiveLow.
+	GPIO      *int `yaml:"gpio"`
+	ActiveLow bool `yaml:"active_low"`
+	// OutputTopic (retained ON/OFF) and OutputGPIO report the fire under heating_yields.
+	OutputTopic string `yaml:"output_topic"`
+	OutputGPIO  *int   `yaml:"output_gpio"`
+}
+
+// InterlockInput is a GPIO input, such as a door or window contact, an external thermostat's
+// contact or a flame-proving sensor, that must be closed for the fire to be lit or turned up.
+// The line has its pull-up enabled, so a contact to ground reads closed with ActiveLow.
+type InterlockInput struct {
+	Name      string `yaml:"name"`
+	GPIO      int    `yaml:"gpio"`
+	ActiveLow bool   `yaml:"active_low"`
+	// ForceOff turns a burning fire off when the input opens.
+	ForceOff bool `yaml:"force_off"`
+}
+
+// DemandResponse holds the fireplace back during peak-price events signalled over MQTT
+// (Topic) or HTTP (POST /demand).
+type DemandResponse struct {
+	// Policy is cap (flame up refused) or defer (ignition deferred as well); default cap.
+	Policy       string   `yaml:"policy"`
+	Topic        string   `yaml:"topic"`
+	ActiveValues []string `yaml:"active_values"` // default ON, peak, 1, true
+}
+
+// Schedule controls when time-triggered rules run. A Pi has no real-time clock, so after
+// boot they wait until the clock is synchronised or TimeSyncTimeout passes; CatchUp then
+// decides what happens to those that fell due meanwhile: none (skipped, the default) or
+// latest (each rule's latest missed run within CatchUpWindow is run late).
+type Schedule struct {
+	// Timezone is the IANA zone time triggers are in, e.g. Europe/London; default the OS zone.
+	Timezone        string        `yaml:"timezone"`
+	TimeSyncTimeout time.Duration `yaml:"time_sync_timeout"` // default 5m
+	CatchUp         string        `yaml:"catch_up"`
+	CatchUpWindow   time.Duration `yaml:"catch_up_window"` // default 1h
+}
+
+// SelfUpdate installs the latest release of Repo on GitHub, when signed with PublicKey,
+// on request (POST /update) or every CheckEvery.
+type SelfUpdate struct {
+	Repo       string        `yaml:"repo"`        // owner/name; default barrylb/go-fire
+	PublicKey  string        `yaml:"public_key"`  // base64 Ed25519 public key
+	CheckEvery time.Duration `yaml:"check_every"` // 0 updates only on request
+}
+
+// AutoOff turns the fire off once it has burned for After without a break, counting from
+// ignition whatever flame changes are made; /autooff changes the limit for one burn.
+type AutoOff struct {
+	After    time.Duration `yaml:"after"` // default 4h
+	Disabled bool          `yaml:"disabled"`
+}
+
+// Ignition proves the flame after each on with the sensor of role Role (a thermocouple
+// amplifier on a GPIO or ADC, a temperature sensor, or a reading fed over MQTT or HTTP): it
+// must read above Above, or rise by Rise from its reading at ignition, within Timeout. If it
+// doesn't, the fire is turned off and lit again after Backoff (doubled for each retry), up to
+// Attempts in all.
+type Ignition struct {
+	Role     string        `yaml:"role"` // default flame
+	Above    *float64      `yaml:"above"`
+	Rise     float64       `yaml:"rise"`
+	Timeout  time.Duration `yaml:"timeout"`  // default 60s
+	Attempts int           `yaml:"attempts"` // default 3
+	Backoff  time.Duration `yaml:"backoff"`  // default 30s
+}
+
+// Usage keeps the main fireplace's burn time per day in File. With CostPerHour, what an hour
+// of burning costs (the burner's gas rate times the gas price), usage is also given as a cost.
+type Usage struct {
+	File        string  `yaml:"file"`
+	CostPerHour float64 `yaml:"cost_per_hour"`
+}
+
+// Webhooks are called with each event of the main fireplace they ask for (see package
+// webhooks). Those registered with /webhooks are kept in File; without it they last until
+// restart.
+type Webhooks struct {
+	File  string    `yaml:"file"`
+	Hooks []Webhook `yaml:"hooks"`
+}
+
+// Presets are named settings of the main fireplace applied in one command, such as
+// /preset/cosy (see package presets). Those saved with /presets are kept in File; without it
+// they last until restart. E.g.
+//
+//	presets:
+//	  file: /var/lib/gofire/presets.json
+//	  presets:
+//	    - {name: cosy, level: 3, fan: 1}
+//	    - {name: max-heat, level: 10}
+type Presets struct {
+	File    string   `yaml:"file"`
+	Presets []Preset `yaml:"presets"`
+}
+
+// Preset lights the fire, if it isn't lit, at Level, and sets the fan and the light when
+// given.
+type Preset struct {
+	Name  string `yaml:"name"` // as fireplaces are named, being part of its route and topics
+	Level int    `yaml:"level"`
+	Fan   *int   `yaml:"fan"`   // speed, 0 for off; needs a driver with a fan
+	Light *int   `yaml:"light"` // brightness, 0 to 100; needs a light
+}
+
+// Webhook is a URL called on Events, with a JSON body, the message alone (text, e.g. for
+// ntfy) or a form (e.g. for Pushover, whose token and user go in Fields).
+type Webhook struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Events  []string          `yaml:"events"`  // default every event
+	Format  string            `yaml:"format"`  // json (the default), text or form
+	Headers map[string]string `yaml:"headers"` // e.g. Title or Authorization
+	Fields  map[string]string `yaml:"fields"`  // further form fields
+}
+
+// Hooks run shell commands on the main fireplace's commands (see package hooks): Commands
+// maps pre_<op>, post_<op> and on_failure to a command run with sh -c, each bounded by Timeout
+// (default 30s), e.g.
+//
+//	hooks:
+//	  commands:
+//	    pre_on: curl -s "http://fan-plug/relay/0?turn=on"
+//	    post_off: curl -s "http://fan-plug/relay/0?turn=off"
+type Hooks struct {
+	Commands map[string]string `yaml:"commands"`
+	Timeout  time.Duration     `yaml:"timeout"`
+}
+
+// Notifications sends the main fireplace's safety events (see package notify) through each
+// backend set, e.g.
+//
+//	notifications:
+//	  ntfy: {url: https://ntfy.sh/my-fireplace}
+//	  telegram: {token: "123456:ABC...", chat_id: "987654"}
+type Notifications struct {
+	Events   []string  `yaml:"events"` // default every event
+	Ntfy     *Ntfy     `yaml:"ntfy"`
+	Pushover *Pushover `yaml:"pushover"`
+	Telegram *Telegram `yaml:"telegram"`
+	Email    *Email    `yaml:"email"`
+}
+
+// Ntfy publishes to the topic at URL, e.g. https://ntfy.sh/my-fireplace, with Token for a
+// protected topic.
+type Ntfy struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+}
+
+// Pushover sends through the application Token to the user (or group) key User.
+type Pushover struct {
+	Token string `yaml:"token"`
+	User  string `yaml:"user"`
+}
+
+// Telegram sends through the bot of Token to the chat ChatID.
+type Telegram struct {
+	Token  string `yaml:"token"`
+	ChatID string `yaml:"chat_id"`
+}
+
+// Email sends through the SMTP server at SMTP (host:port; STARTTLS is used when offered, and
+// port 465 is TLS from the start), logging in with Username and Password when set.
+type Email struct {
+	SMTP     string   `yaml:"smtp"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// Presence looks for the household's phones on the network and BLE beacons (key tags, or a
+// phone app advertising a fixed address) on the adapter of sensors.ble, every Interval. Once
+// none has been seen for Away while the fire burns, Action ("pilot" or "off") is run.
+type Presence struct {
+	Devices  []PresenceDevice `yaml:"devices"`
+	Interval time.Duration    `yaml:"interval"` // default 30s
+	Away     time.Duration    `yaml:"away"`     // default 30m
+	Action   string           `yaml:"action"`   // default off
+}
+
+// PresenceDevice is a phone pinged at Host (an IP address or hostname on the LAN), or a
+// beacon advertising from Beacon.
+type PresenceDevice struct {
+	Name   string `yaml:"name"`
+	Host   string `yaml:"host"`
+	Beacon string `yaml:"beacon"` // e.g. A4:C1:38:12:34:56
+}
+
+// QuietHours holds the fireplace to household rules from Start to End each day (HH:MM in
+// schedule.timezone; past midnight when End is before Start): with Policy refuse, ignition
+// is refused, and with cap the flame is kept at or below MaxLevel.
+type QuietHours struct {
+	Start    string `yaml:"start"`     // e.g. 23:00
+	End      string `yaml:"end"`       // e.g. 07:00
+	Policy   string `yaml:"policy"`    // default refuse
+	MaxLevel int    `yaml:"max_level"` // for cap; default 1
+}
+
+// Audit keeps every command, with its source and client, in monthly files under Dir.
+type Audit struct {
+	Dir       string        `yaml:"dir"`
+	Retention time.Duration `yaml:"retention"` // default a year
+}
+
+// Lock is the child or maintenance lock, locked and unlocked with PIN: while it is locked,
+// every command but off is refused, whoever sends it.
+type Lock struct {
+	PIN  string `yaml:"pin"`
+	File string `yaml:"file"` // keeps the lock across restarts; empty forgets it
+}
+
+// EStop is the emergency stop (/estop): once engaged, every command but off is refused until
+// it is cleared.
+type EStop struct {
+	File string `yaml:"file"` // keeps it engaged across restarts; empty forgets it
+}
+
+// Modulation cycles the burner on a duty cycle (/modulate): lit at its lowest flame for part
+// of each period and put out, to Low, for the rest, or between two flame levels. Each burn
+// lasts at least MinOn and each gap at least MinOff, the period being stretched to keep the
+// duty cycle, so that the valve and igniter aren't worked harder than the fire needs.
+type Modulation struct {
+	MinOn  time.Duration `yaml:"min_on"`  // default 5m
+	MinOff time.Duration `yaml:"min_off"` // default 5m
+	Low    string        `yaml:"low"`     // off (the default) or pilot: the burner between burns
+}
+
+// Heartbeat is a dead-man switch: while the fire is on, a heartbeat (POST /heartbeat) must
+// arrive at least every Interval, counting from ignition, or the fire is turned off.
+type Heartbeat struct {
+	Interval time.Duration `yaml:"interval"` // default 10m
+}
+
+// Thermostat lights, steps and turns off the fire to hold the sensor with role Role at a
+// target (set with /settemp; Target is the setpoint at start, none when unset). The fire is
+// lit below target-Hysteresis and turned off above target+Hysteresis; while it burns, the
+// flame is stepped up below target-StepBand and down above the target, at most once per
+// Interval.
+type Thermostat struct {
+	Target     *float64      `yaml:"target"`
+	Role       string        `yaml:"role"`       // default room
+	Hysteresis float64       `yaml:"hysteresis"` // default 0.5
+	StepBand   float64       `yaml:"step_band"`  // default 1
+	Interval   time.Duration `yaml:"interval"`   // default 2m
+	// MaxTarget is the highest target /settemp accepts; default 28 (82 in Fahrenheit).
+	MaxTarget float64 `yaml:"max_target"`
+	// MaxAge is how old the room reading may be before the thermostat stops acting; default 10m.
+	MaxAge time.Duration `yaml:"max_age"`
+	// Outdoor keeps the flame up on a freezing night.
+	Outdoor *OutdoorBoost `yaml:"outdoor"`
+	// Modulate cycles the burner when the room is above the target at the lowest flame.
+	Modulate *ThermostatModulation `yaml:"modulate"`
+}
+
+// ThermostatModulation has the thermostat modulate the burner (see Modulation), burning for
+// Duty percent of each Period, once the room is above the target with the flame at its lowest,
+// rather than leaving it burning until the room is warm enough to turn the fire off. Its own
+// rules end the modulation: the room falling well below the target steps the flame up, and
+// the room rising above the band turns the fire off.
+type ThermostatModulation struct {
+	Duty   int           `yaml:"duty"`   // percent, default 50
+	Period time.Duration `yaml:"period"` // default 15m
+}
+
+// OutdoorBoost keeps the flame at MinLevel or above, rather than stepping it down to the
+// lowest, while the sensor with role Role reads below Below, so that a low flame isn't left
+// to fall behind a room losing heat fast.
+type OutdoorBoost struct {
+	Role     string   `yaml:"role"`      // default outdoor
+	Below    *float64 `yaml:"below"`     // default freezing: 0, or 32 in Fahrenheit
+	MinLevel int      `yaml:"min_level"` // from 2
+}
+
+// FrostProtection lights the fire, at its lowest flame, once the sensor with role Role reads
+// below Below, as in an empty house in winter, and turns it off again once it reads above
+// Above; it is checked every Interval.
+type FrostProtection struct {
+	Role     string        `yaml:"role"`     // default room
+	Below    *float64      `yaml:"below"`    // default 5 (41 in Fahrenheit)
+	Above    *float64      `yaml:"above"`    // default 3 degrees above Below
+	Interval time.Duration `yaml:"interval"` // default 1m
+	// MaxAge is how old the reading may be before frost protection stops acting; default 10m.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// SafeMode records starts in File; Restarts starts within Window mean a crash loop, and
+// GoFire then comes up serving only off and diagnostics until an admin clears it.
+type SafeMode struct {
+	File     string        `yaml:"file"`
+	Restarts int           `yaml:"restarts"` // default 5
+	Window   time.Duration `yaml:"window"`   // default 10m
+}
+
+// Startup decides what the fireplace is taken to be when GoFire starts: assume_off (the
+// default), restore (the state saved in StateFile after each on or off) or probe (on if the
+// sensor with role ProbeRole reads above ProbeAbove, e.g. a thermopile). With SendOff an off
+// sequence is sent at start, so a fire left burning by a crash is put out; with Resync a
+// fire taken to be lit has its flame driven right down, so the estimated level is right
+// again. StateFile also keeps the total burn time, whatever State is.
+type Startup struct {
+	State      string  `yaml:"state"`
+	StateFile  string  `yaml:"state_file"`
+	ProbeRole  string  `yaml:"probe_role"` // default flame
+	ProbeAbove float64 `yaml:"probe_above"`
+	SendOff    bool    `yaml:"send_off"`
+	Resync     bool    `yaml:"resync"`
+}
+
+// SelfTest is the relay self-test of /selftest, which checks each contact's line is still
+// held as an output and can click each relay in turn, closing it for Pulse (default 250ms).
+// AtStart runs one at start: lines checks the lines, click clicks the relays too.
+type SelfTest struct {
+	AtStart string        `yaml:"at_start"`
+	Pulse   time.Duration `yaml:"pulse"`
+}
+
+// Simulation runs time triggers against a simulated clock, starting at Start (RFC 3339,
+// default the real time) and standing still until fast-forwarded through /clock.
+type Simulation struct {
+	Start string `yaml:"start"`
+}
+
+// CommandPriority stops a source overriding the last fireplace command of a higher-priority
+// source (safety > manual > api > schedule > eco) until Latch has passed.
+type CommandPriority struct {
+	Latch time.Duration `yaml:"latch"` // default 4h
+	// Sources re-ranks command sources (http, ble, udp, lora, hue, zigbee, ...) by priority name.
+	Sources map[string]string `yaml:"sources"`
+}
+
+// Lockout holds rules that refuse ignition regardless of who asks for it.
+type Lockout struct {
+	// OutdoorMax refuses ignition while the sensor with role "outdoor" reads above it.
+	OutdoorMax *float64 `yaml:"outdoor_max"`
+	// PIN must be given as ?pin= to light the fire over HTTP, in addition to any token.
+	PIN string `yaml:"pin"`
+	// Confirm makes lighting the fire over HTTP two-step when set, e.g. 30s: /on replies a
+	// one-time token, and the fire is lit only once /confirm?code= follows within Confirm.
+	Confirm time.Duration `yaml:"confirm"`
+}
+
+type Sensors struct {
+	PollInterval time.Duration   `yaml:"poll_interval"`
+	Analog       []AnalogSensor  `yaml:"analog"`
+	OneWire      []OneWireSensor `yaml:"onewire"`
+	BLE          BLE             `yaml:"ble"`
+	Weather      *Weather        `yaml:"weather"`
+	DHT          []DHTSensor     `yaml:"dht"`
+	Feeds        []Feed          `yaml:"feeds"`
+	// Calibration is keyed by sensor name and applies to any kind of sensor.
+	Calibration map[string]Calibration `yaml:"calibration"`
+}
+
+type Calibration struct {
+	Offset    float64 `yaml:"offset"`    // added to every raw reading
+	Smoothing string  `yaml:"smoothing"` // "", "average" or "ema"
+	Window    int     `yaml:"window"`    // samples averaged, or the EMA span
+}
+
+// DHTSensor is a DHT11/DHT22 read through the kernel's dht11 IIO driver
+// (dtoverlay=dht11,gpiopin=N), which handles the sensor's timing-critical protocol.
+type DHTSensor struct {
+	Device string `yaml:"device"` // IIO device, e.g. iio:device0
+	Name   string `yaml:"name"`
+	Role   string `yaml:"role"`
+}
+
+// Feed is a sensor whose readings are pushed to GoFire, with POST /sensors/feed or on an MQTT
+// topic (a bare number, or JSON with a temperature field as zigbee2mqtt publishes).
+type Feed struct {
+	Name   string        `yaml:"name"`
+	Role   string        `yaml:"role"`
+	Unit   string        `yaml:"unit"`    // default C
+	Topic  string        `yaml:"topic"`   // optional
+	MaxAge time.Duration `yaml:"max_age"` // readings older than this are reported as errors; default 10m
+}
+
+// Weather adds the current outdoor temperature from a weather API as a sensor: Open-Meteo
+// (the default, no key needed) or OpenWeatherMap with APIKey. With ForecastHours it adds a
+// second, NAME_forecast with role ForecastRole, reading the highest temperature forecast for
+// the next that many hours, so that a rule can skip a burn on a day forecast to be warm.
+type Weather struct {
+	Name          string        `yaml:"name"`
+	Role          string        `yaml:"role"`
+	Provider      string        `yaml:"provider"` // open-meteo or openweathermap
+	APIKey        string        `yaml:"api_key"`  // openweathermap
+	Latitude      float64       `yaml:"latitude"`
+	Longitude     float64       `yaml:"longitude"`
+	Refresh       time.Duration `yaml:"refresh"`
+	ForecastHours int           `yaml:"forecast_hours"` // up to 48
+	ForecastRole  string        `yaml:"forecast_role"`  // default outdoor_forecast
+}
+
+// BLE lists the broadcast thermometers to pick up with passive BLE scanning.
+type BLE struct {
+	Adapter int           `yaml:"adapter"` // hciN
+	MaxAge  time.Duration `yaml:"max_age"` // readings older than this are reported as errors
+	Devices []BLEDevice   `yaml:"devices"`
+}
+
+type BLEDevice struct {
+	Address string `yaml:"address"` // e.g. A4:C1:38:12:34:56
+	Name    string `yaml:"name"`
+	Role    string `yaml:"role"`
+}
+
+// OneWireSensor names a DS18B20 by its bus id. Devices found on the bus
+// without an entry are still polled, named after their id.
+type OneWireSensor struct {
+	ID   string `yaml:"id"` // e.g. 28-0316a2791aff
+	Name string `yaml:"name"`
+	Role string `yaml:"role"` // room, flue, outdoor, ...
+}
+
+// AnalogSensor describes one ADC input channel, e.g.
+//
+//   - name: thermopile
+//     adc: mcp3008
+//     device: /dev/spidev0.0
+//     channel: 0
+//     scale: 1000
+//     unit: mV
+type AnalogSensor struct {
+	Name      string  `yaml:"name"`
+	Role      string  `yaml:"role"`
+	ADC       string  `yaml:"adc"`        // mcp3008 or ads1115
+	Device    string  `yaml:"device"`     // /dev/spidevB.C or /dev/i2c-N
+	Address   int     `yaml:"address"`    // I2C address (ads1115 only)
+	Channel   int     `yaml:"channel"`    // single-ended input channel
+	VRef      float64 `yaml:"vref"`       // reference voltage (mcp3008 only)
+	FullScale float64 `yaml:"full_scale"` // PGA full-scale volts (ads1115 only)
+	Scale     float64 `yaml:"scale"`      // reading = volts*scale + offset
+	Offset    float64 `yaml:"offset"`
+	Unit      string  `yaml:"unit"`
+}
+
+// Load reads the configuration file, filling in defaults for anything left out.
+// An empty path yields the defaults.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, err
+		}
+	}
+	switch cfg.TemperatureUnit {
+	case "":
+		cfg.TemperatureUnit = "C"
+	case "C", "F":
+	default:
+		return nil, fmt.Errorf("temperature_unit must be C or F, not %q", cfg.TemperatureUnit)
+	}
+	if cfg.Logging.Level == "" {
+		cfg.Logging.Level = "info"
+	}
+	if cfg.Logging.Format == "" {
+		cfg.Logging.Format = "text"
+	}
+	if cfg.Logging.Syslog.Facility == "" {
+		cfg.Logging.Syslog.Facility = "daemon"
+	}
+	if cfg.Logging.Syslog.AppName == "" {
+		cfg.Logging.Syslog.AppName = "gofire"
+	}
+	if d := cfg.MQTT.Device; d != nil {
+		if cfg.MQTT.Broker == "" {
+			return nil, fmt.Errorf("mqtt.device needs mqtt.broker")
+		}
+		if d.TopicPrefix == "" {
+			d.TopicPrefix = "gofire"
+		}
+		if d.DiscoveryPrefix == "" {
+			d.DiscoveryPrefix = "homeassistant"
+		}
+		if d.Name == "" {
+			d.Name = "Fireplace"
+		}
+	}
+	if cfg.Metrics.Statsd.Prefix == "" {
+		cfg.Metrics.Statsd.Prefix = "gofire"
+	}
+	if cfg.Metrics.Statsd.Interval == 0 {
+		cfg.Metrics.Statsd.Interval = 10 * time.Second
+	}
+	if cfg.GATT != nil && cfg.GATT.Name == "" {
+		cfg.GATT.Name = "GoFire"
+	}
+	if l := cfg.Remotes.LoRa; l != nil {
+		if l.Frequency == 0 {
+			l.Frequency = 868100000
+		}
+		if l.SpreadingFactor == 0 {
+			l.SpreadingFactor = 7
+		}
+		if l.Bandwidth == 0 {
+			l.Bandwidth = 125000
+		}
+		if l.CodingRate == 0 {
+			l.CodingRate = 5
+		}
+		if l.SyncWord == 0 {
+			l.SyncWord = 0x12
+		}
+		if l.TxPower == 0 {
+			l.TxPower = 14
+		}
+	}
+	if cfg.GPIOChip == "" {
+		cfg.GPIOChip = "gpiochip0"
+	}
+	switch cfg.Driver {
+	case "":
+		cfg.Driver = "relay"
+	case "mock":
+		cfg.Driver, cfg.GPIOChip = "relay", "mock"
+	case "relay", "simulated":
+	case "proflame":
+		if cfg.Proflame == nil || cfg.Proflame.Symbol <= 0 {
+			return nil, fmt.Errorf("driver proflame needs proflame.gpio, proflame.symbol and proflame.frames")
+		}
+	case "bridge":
+		if cfg.Bridge == nil || cfg.Bridge.Address == "" {
+			return nil, fmt.Errorf("driver bridge needs bridge.protocol and bridge.address")
+		}
+		if cfg.Bridge.Timeout == 0 {
+			cfg.Bridge.Timeout = 2 * time.Second
+		}
+	default:
+		return nil, fmt.Errorf("driver must be relay, proflame, bridge, simulated or mock, not %q", cfg.Driver)
+	}
+	if cfg.Proflame != nil {
+		proflameDefaults(cfg.Proflame)
+	}
+	if cfg.Valve.Profile == "" {
+		cfg.Valve.Profile = "gv60"
+	}
+	if len(cfg.Valve.GPIOs) == 0 {
+		cfg.Valve.GPIOs = []int{26, 20, 21}
+	}
+	if cfg.Valve.Levels == 0 {
+		cfg.Valve.Levels = 6
+	}
+	if cfg.Valve.Travel == 0 {
+		cfg.Valve.Travel = 12 * time.Second
+	}
+	if cfg.Valve.Debounce < 0 {
+		return nil, fmt.Errorf("valve.debounce must not be negative, not %v", cfg.Valve.Debounce)
+	}
+	for op, d := range cfg.Valve.Timeouts {
+		if !timedOps[op] {
+			return nil, fmt.Errorf("valve.timeouts keys must be default or a command other than off, not %q", op)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("valve.timeouts.%s must not be negative, not %v", op, d)
+		}
+	}
+	for name, b := range cfg.RelayBoards {
+		if !fireplaceName.MatchString(name) {
+			return nil, fmt.Errorf("relay_boards: name %q must be lower-case letters, digits, - and _", name)
+		}
+		if expanderPins[b.Expander] == 0 {
+			return nil, fmt.Errorf("relay_boards.%s: expander must be mcp23017 or pcf8574, not %q", name, b.Expander)
+		}
+		if b.Device == "" {
+			b.Device = "/dev/i2c-1"
+		}
+		if b.Address == 0 {
+			b.Address = 0x20
+		}
+		if b.Address < 0x03 || b.Address > 0x77 {
+			return nil, fmt.Errorf("relay_boards.%s: address must be 0x03 to 0x77, not %#x", name, b.Address)
+		}
+		cfg.RelayBoards[name] = b
+	}
+	// a relay or input line, on the GPIO chip or a relay board's pin
+	type line struct {
+		board string
+		gpio  int
+	}
+	lineName := func(l line) string {
+		if l.board == "" {
+			return "gpio " + strconv.Itoa(l.gpio)
+		}
+		return fmt.Sprintf("pin %d of relay board %s", l.gpio, l.board)
+	}
+	// checkBoard checks that board, if not empty, is configured and has pins gpios
+	checkBoard := func(board string, gpios ...int) error {
+		if board == "" {
+			return nil
+		}
+		b, ok := cfg.RelayBoards[board]
+		if !ok {
+			return fmt.Errorf("board %q isn't under relay_boards", board)
+		}
+		for _, gpio := range gpios {
+			if pins := expanderPins[b.Expander]; gpio < 0 || gpio >= pins {
+				return fmt.Errorf("relay board %s has pins 0 to %d, not %d", board, pins-1, gpio)
+			}
+		}
+		return nil
+	}
+	if err := checkBoard(cfg.Valve.Board, cfg.Valve.GPIOs...); err != nil {
+		return nil, fmt.Errorf("valve: %v", err)
+	}
+	used := map[line]string{}
+	for _, gpio := range cfg.Valve.GPIOs {
+		used[line{cfg.Valve.Board, gpio}] = "valve"
+	}
+	if cfg.Driver == "proflame" {
+		used[line{"", cfg.Proflame.GPIO}] = "proflame"
+	}
+	for name, f := range cfg.Fireplaces {
+		if !fireplaceName.MatchString(name) {
+			return nil, fmt.Errorf("fireplaces: name %q must be lower-case letters, digits, - and _", name)
+		}
+		gpios := f.GPIOs
+		switch f.Driver {
+		case "":
+			f.Driver = "relay"
+			fallthrough
+		case "relay":
+			if len(f.GPIOs) == 0 {
+				return nil, fmt.Errorf("fireplaces.%s needs gpios", name)
+			}
+			if err := checkBoard(f.Board, f.GPIOs...); err != nil {
+				return nil, fmt.Errorf("fireplaces.%s: %v", name, err)
+			}
+		case "proflame":
+			if f.Board != "" {
+				return nil, fmt.Errorf("fireplaces.%s: driver proflame needs a GPIO line, not a relay board", name)
+			}
+			if f.Proflame == nil || f.Proflame.Symbol <= 0 {
+				return nil, fmt.Errorf("fireplaces.%s: driver proflame needs proflame.gpio, proflame.symbol and proflame.frames", name)
+			}
+			proflameDefaults(f.Proflame)
+			gpios = []int{f.Proflame.GPIO}
+		default:
+			return nil, fmt.Errorf("fireplaces.%s: driver must be relay or proflame, not %q", name, f.Driver)
+		}
+		for _, gpio := range gpios {
+			l := line{f.Board, gpio}
+			if other, ok := used[l]; ok {
+				return nil, fmt.Errorf("fireplaces.%s: %s is already used by %s", name, lineName(l), other)
+			}
+			used[l] = "fireplaces." + name
+		}
+		if f.Profile == "" {
+			f.Profile = cfg.Valve.Profile
+		}
+		cfg.Fireplaces[name] = f
+	}
+	seen := map[string]bool{}
+	for i := range cfg.Accessories {
+		a := &cfg.Accessories[i]
+		if !fireplaceName.MatchString(a.Name) {
+			return nil, fmt.Errorf("accessories: name %q must be lower-case letters, digits, - and _", a.Name)
+		}
+		if seen[a.Name] {
+			return nil, fmt.Errorf("accessories: %q is listed twice", a.Name)
+		}
+		seen[a.Name] = true
+		switch a.Mode {
+		case "":
+			a.Mode = "latched"
+		case "latched", "momentary":
+		default:
+			return nil, fmt.Errorf("accessories.%s: mode must be latched or momentary, not %q", a.Name, a.Mode)
+		}
+		if a.Pulse == 0 {
+			a.Pulse = 500 * time.Millisecond
+		}
+		if a.Pulse < 0 || a.Pulse > 10*time.Second {
+			return nil, fmt.Errorf("accessories.%s: pulse must be between 0 and 10s, not %v", a.Name, a.Pulse)
+		}
+		if err := checkBoard(a.Board, a.GPIO); err != nil {
+			return nil, fmt.Errorf("accessories.%s: %v", a.Name, err)
+		}
+		l := line{a.Board, a.GPIO}
+		if other, ok := used[l]; ok {
+			return nil, fmt.Errorf("accessories.%s: %s is already used by %s", a.Name, lineName(l), other)
+		}
+		used[l] = "accessories." + a.Name
+	}
+	if w := cfg.Valve.Wear; w != nil {
+		if w.File == "" {
+			return nil, fmt.Errorf("valve.wear needs a file")
+		}
+		if w.RatedCycles == 0 {
+			w.RatedCycles = 100000
+		}
+		if w.WarnAt == 0 {
+			w.WarnAt = 0.8
+		}
+		if w.RatedCycles < 0 || w.WarnAt < 0 || w.WarnAt > 1 {
+			return nil, fmt.Errorf("valve.wear: rated_cycles must be positive and warn_at between 0 and 1")
+		}
+	}
+	if il := cfg.Interlock; il != nil {
+		switch il.Policy {
+		case "":
+			il.Policy = "fire_yields"
+		case "fire_yields", "heating_yields":
+		default:
+			return nil, fmt.Errorf("interlock.policy must be fire_yields or heating_yields, not %q", il.Policy)
+		}
+		if len(il.ActiveValues) == 0 {
+			il.ActiveValues = []string{"ON", "heating", "1", "true"}
+		}
+	}
+	names := map[string]bool{}
+	for i, in := range cfg.Interlocks {
+		if in.Name == "" {
+			return nil, fmt.Errorf("interlocks[%d] needs a name", i)
+		}
+		if names[in.Name] {
+			return nil, fmt.Errorf("interlocks: %q is listed twice", in.Name)
+		}
+		names[in.Name] = true
+		if other, ok := used[line{"", in.GPIO}]; ok {
+			return nil, fmt.Errorf("interlocks.%s: gpio %d is already used by %s", in.Name, in.GPIO, other)
+		}
+	}
+	if d := cfg.DemandResponse; d != nil {
+		switch d.Policy {
+		case "":
+			d.Policy = "cap"
+		case "cap", "defer":
+		default:
+			return nil, fmt.Errorf("demand_response.policy must be cap or defer, not %q", d.Policy)
+		}
+		if len(d.ActiveValues) == 0 {
+			d.ActiveValues = []string{"ON", "peak", "1", "true"}
+		}
+	}
+	for i, t := range cfg.Auth.Tokens {
+		if t.Name == "" || t.Token == "" || len(t.Scopes) == 0 {
+			return nil, fmt.Errorf("auth.tokens[%d] needs a name, a token and scopes", i)
+		}
+	}
+	if cfg.Schedule.TimeSyncTimeout == 0 {
+		cfg.Schedule.TimeSyncTimeout = 5 * time.Minute
+	}
+	if sim := cfg.Simulation; sim != nil && sim.Start != "" {
+		if _, err := time.Parse(time.RFC3339, sim.Start); err != nil {
+			return nil, fmt.Errorf("simulation.start: %v", err)
+		}
+	}
+	if su := cfg.SelfUpdate; su != nil {
+		if su.PublicKey == "" {
+			return nil, fmt.Errorf("self_update needs a public_key")
+		}
+		if su.Repo == "" {
+			su.Repo = "barrylb/go-fire"
+		}
+	}
+	if t := cfg.Thermostat; t != nil {
+		if t.Role == "" {
+			t.Role = "room"
+		}
+		if t.Hysteresis == 0 {
+			t.Hysteresis = 0.5
+		}
+		if t.StepBand == 0 {
+			t.StepBand = 1
+		}
+		if t.Interval == 0 {
+			t.Interval = 2 * time.Minute
+		}
+		if t.MaxAge == 0 {
+			t.MaxAge = 10 * time.Minute
+		}
+		if t.MaxTarget == 0 {
+			t.MaxTarget = 28
+			if cfg.TemperatureUnit == "F" {
+				t.MaxTarget = 82
+			}
+		}
+		if t.Target != nil && *t.Target > t.MaxTarget {
+			return nil, fmt.Errorf("thermostat: target is above max_target")
+		}
+		if t.Hysteresis < 0 || t.StepBand < 0 {
+			return nil, fmt.Errorf("thermostat: hysteresis and step_band must be positive")
+		}
+		if o := t.Outdoor; o != nil {
+			if o.Role == "" {
+				o.Role = "outdoor"
+			}
+			if o.Below == nil {
+				freezing := 0.0
+				if cfg.TemperatureUnit == "F" {
+					freezing = 32
+				}
+				o.Below = &freezing
+			}
+			if o.MinLevel < 2 {
+				return nil, fmt.Errorf("thermostat.outdoor.min_level must be 2 or more, not %d", o.MinLevel)
+			}
+		}
+		if m := t.Modulate; m != nil {
+			if m.Duty == 0 {
+				m.Duty = 50
+			}
+			if m.Period == 0 {
+				m.Period = 15 * time.Minute
+			}
+			if m.Duty < 1 || m.Duty > 99 {
+				return nil, fmt.Errorf("thermostat.modulate.duty must be 1 to 99, not %d", m.Duty)
+			}
+			if m.Period < 0 {
+				return nil, fmt.Errorf("thermostat.modulate.period must be positive")
+			}
+		}
+	}
+	if cfg.Modulation.MinOn == 0 {
+		cfg.Modulation.MinOn = 5 * time.Minute
+	}
+	if cfg.Modulation.MinOff == 0 {
+		cfg.Modulation.MinOff = 5 * time.Minute
+	}
+	if cfg.Modulation.MinOn < 0 || cfg.Modulation.MinOff < 0 {
+		return nil, fmt.Errorf("modulation: min_on and min_off must be positive")
+	}
+	switch cfg.Modulation.Low {
+	case "":
+		cfg.Modulation.Low = "off"
+	case "off", "pilot":
+	default:
+		return nil, fmt.Errorf("modulation.low must be off or pilot, not %q", cfg.Modulation.Low)
+	}
+	if c := cfg.Lockout.Confirm; c < 0 || c > 10*time.Minute {
+		return nil, fmt.Errorf("lockout.confirm must be between 0 and 10m, not %v", c)
+	}
+	if f := cfg.FrostProtection; f != nil {
+		if f.Role == "" {
+			f.Role = "room"
+		}
+		if f.Below == nil {
+			below := 5.0
+			if cfg.TemperatureUnit == "F" {
+				below = 41
+			}
+			f.Below = &below
+		}
+		if f.Above == nil {
+			above := *f.Below + 3
+			if cfg.TemperatureUnit == "F" {
+				above = *f.Below + 5
+			}
+			f.Above = &above
+		}
+		if *f.Above <= *f.Below {
+			return nil, fmt.Errorf("frost_protection: above must be higher than below")
+		}
+		if f.Interval == 0 {
+			f.Interval = time.Minute
+		}
+		if f.MaxAge == 0 {
+			f.MaxAge = 10 * time.Minute
+		}
+		if f.Interval < 0 || f.MaxAge < 0 {
+			return nil, fmt.Errorf("frost_protection: interval and max_age must be positive")
+		}
+	}
+	if hk := cfg.HomeKit; hk != nil {
+		if hk.StateFile == "" {
+			return nil, fmt.Errorf("homekit needs a state_file")
+		}
+		if err := checkSetupCode(hk.PIN); err != nil {
+			return nil, err
+		}
+		if hk.Name == "" {
+			hk.Name = "Fireplace"
+		}
+		if hk.Address == "" {
+			hk.Address = ":51828"
+		}
+	}
+	if a := cfg.Alexa; a != nil && a.Name == "" {
+		a.Name = "Fireplace"
+	}
+	if d := cfg.Discovery; d != nil && d.Name == "" {
+		d.Name = "GoFire"
+		if host, _ := os.Hostname(); host != "" {
+			d.Name += " on " + host
+		}
+	}
+	if g := cfg.Google; g != nil {
+		if g.Name == "" {
+			g.Name = "Fireplace"
+		}
+		if g.AgentUserID == "" {
+			g.AgentUserID = "gofire"
+		}
+	}
+	if ig := cfg.Ignition; ig != nil {
+		if ig.Role == "" {
+			ig.Role = "flame"
+		}
+		if (ig.Above == nil) == (ig.Rise <= 0) {
+			return nil, fmt.Errorf("ignition needs one of above and rise")
+		}
+		if ig.Timeout == 0 {
+			ig.Timeout = time.Minute
+		}
+		if ig.Attempts == 0 {
+			ig.Attempts = 3
+		}
+		if ig.Backoff == 0 {
+			ig.Backoff = 30 * time.Second
+		}
+		if ig.Timeout < 0 || ig.Attempts < 1 || ig.Backoff < 0 {
+			return nil, fmt.Errorf("ignition: timeout, attempts and backoff must be positive")
+		}
+	}
+	if cfg.AutoOff.After == 0 {
+		cfg.AutoOff.After = 4 * time.Hour
+	}
+	if u := cfg.Usage; u != nil {
+		if u.File == "" {
+			return nil, fmt.Errorf("usage needs a file")
+		}
+		if u.CostPerHour < 0 {
+			return nil, fmt.Errorf("usage: cost_per_hour must not be negative")
+		}
+	}
+	if hb := cfg.Heartbeat; hb != nil && hb.Interval == 0 {
+		hb.Interval = 10 * time.Minute
+	}
+	if p := cfg.Presence; p != nil {
+		if len(p.Devices) == 0 {
+			return nil, fmt.Errorf("presence needs devices")
+		}
+		names := map[string]bool{}
+		for i := range p.Devices {
+			d := &p.Devices[i]
+			if d.Name == "" || names[d.Name] {
+				return nil, fmt.Errorf("presence.devices[%d] needs a unique name", i)
+			}
+			names[d.Name] = true
+			if (d.Host == "") == (d.Beacon == "") {
+				return nil, fmt.Errorf("presence.devices.%s needs one of host and beacon", d.Name)
+			}
+			d.Beacon = strings.ToUpper(d.Beacon)
+		}
+		if p.Interval == 0 {
+			p.Interval = 30 * time.Second
+		}
+		if p.Away == 0 {
+			p.Away = 30 * time.Minute
+		}
+		if p.Interval < 0 || p.Away < 0 {
+			return nil, fmt.Errorf("presence: interval and away must be positive")
+		}
+		if p.Action == "" {
+			p.Action = "off"
+		}
+		if p.Action != "off" && p.Action != "pilot" {
+			return nil, fmt.Errorf("presence.action must be off or pilot, not %q", p.Action)
+		}
+	}
+	if q := cfg.QuietHours; q != nil {
+		start, err1 := time.Parse("15:04", q.Start)
+		end, err2 := time.Parse("15:04", q.End)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("quiet_hours.start and end must be HH:MM, not %q and %q", q.Start, q.End)
+		}
+		if start.Equal(end) {
+			return nil, fmt.Errorf("quiet_hours.start and end must differ, not both %q", q.Start)
+		}
+		switch q.Policy {
+		case "":
+			q.Policy = "refuse"
+		case "refuse", "cap":
+		default:
+			return nil, fmt.Errorf("quiet_hours.policy must be refuse or cap, not %q", q.Policy)
+		}
+		if q.MaxLevel == 0 {
+			q.MaxLevel = 1
+		}
+		if q.MaxLevel < 1 {
+			return nil, fmt.Errorf("quiet_hours.max_level must be at least 1, not %d", q.MaxLevel)
+		}
+	}
+	if n := cfg.Notifications; n != nil {
+		if n.Ntfy == nil && n.Pushover == nil && n.Telegram == nil && n.Email == nil {
+			return nil, fmt.Errorf("notifications needs ntfy, pushover, telegram or email")
+		}
+		if b := n.Ntfy; b != nil {
+			if u, err := url.Parse(b.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || strings.Trim(u.Path, "/") == "" {
+				return nil, fmt.Errorf("notifications.ntfy.url must be the topic's http or https URL, not %q", b.URL)
+			}
+		}
+		if b := n.Pushover; b != nil && (b.Token == "" || b.User == "") {
+			return nil, fmt.Errorf("notifications.pushover needs a token and a user")
+		}
+		if b := n.Telegram; b != nil && (b.Token == "" || b.ChatID == "") {
+			return nil, fmt.Errorf("notifications.telegram needs a token and a chat_id")
+		}
+		if b := n.Email; b != nil {
+			if _, _, err := net.SplitHostPort(b.SMTP); err != nil {
+				return nil, fmt.Errorf("notifications.email.smtp must be host:port, not %q", b.SMTP)
+			}
+			if b.From == "" || len(b.To) == 0 {
+				return nil, fmt.Errorf("notifications.email needs from and to")
+			}
+		}
+	}
+	if h := cfg.Hooks; h != nil {
+		if len(h.Commands) == 0 {
+			return nil, fmt.Errorf("hooks needs commands")
+		}
+		if h.Timeout == 0 {
+			h.Timeout = 30 * time.Second
+		}
+		if h.Timeout < 0 || h.Timeout > 10*time.Minute {
+			return nil, fmt.Errorf("hooks.timeout must be from 0 to 10m, not %v", h.Timeout)
+		}
+	}
+	if l := cfg.Lock; l != nil && l.PIN == "" {
+		return nil, fmt.Errorf("lock needs a pin")
+	}
+	if a := cfg.Audit; a != nil {
+		if a.Dir == "" {
+			return nil, fmt.Errorf("audit needs a dir")
+		}
+		if a.Retention == 0 {
+			a.Retention = 365 * 24 * time.Hour
+		}
+		if a.Retention < 0 {
+			return nil, fmt.Errorf("audit.retention must not be negative, not %v", a.Retention)
+		}
+	}
+	if sm := cfg.SafeMode; sm != nil {
+		if sm.File == "" {
+			return nil, fmt.Errorf("safe_mode needs a file")
+		}
+		if sm.Restarts == 0 {
+			sm.Restarts = 5
+		}
+		if sm.Window == 0 {
+			sm.Window = 10 * time.Minute
+		}
+	}
+	switch cfg.Startup.State {
+	case "":
+		cfg.Startup.State = "assume_off"
+	case "assume_off", "probe":
+	case "restore":
+		if cfg.Startup.StateFile == "" {
+			return nil, fmt.Errorf("startup.state restore needs startup.state_file")
+		}
+	default:
+		return nil, fmt.Errorf("startup.state must be assume_off, restore or probe, not %q", cfg.Startup.State)
+	}
+	if cfg.Startup.ProbeRole == "" {
+		cfg.Startup.ProbeRole = "flame"
+	}
+	switch cfg.SelfTest.AtStart {
+	case "", "lines", "click":
+	default:
+		return nil, fmt.Errorf("self_test.at_start must be lines or click, not %q", cfg.SelfTest.AtStart)
+	}
+	if cfg.SelfTest.Pulse == 0 {
+		cfg.SelfTest.Pulse = 250 * time.Millisecond
+	}
+	if cfg.SelfTest.Pulse < 50*time.Millisecond || cfg.SelfTest.Pulse > 2*time.Second {
+		return nil, fmt.Errorf("self_test.pulse must be between 50ms and 2s, not %v", cfg.SelfTest.Pulse)
+	}
+	switch cfg.Schedule.CatchUp {
+	case "":
+		cfg.Schedule.CatchUp = "none"
+	case "none", "latest":
+	default:
+		return nil, fmt.Errorf("schedule.catch_up must be none or latest, not %q", cfg.Schedule.CatchUp)
+	}
+	if cfg.Schedule.CatchUpWindow == 0 {
+		cfg.Schedule.CatchUpWindow = time.Hour
+	}
+	if p := cfg.CommandPriority; p != nil && p.Latch == 0 {
+		p.Latch = 4 * time.Hour
+	}
+	if cfg.MQTT.ClientID == "" {
+		cfg.MQTT.ClientID = "gofire"
+	}
+	for i := range cfg.ZigbeeButtons {
+		if cfg.ZigbeeButtons[i].ActionField == "" {
+			cfg.ZigbeeButtons[i].ActionField = "action"
+		}
+	}
+	for i := range cfg.Hue.Buttons {
+		if cfg.Hue.Buttons[i].Event == "" {
+			cfg.Hue.Buttons[i].Event = "short_release"
+		}
+	}
+	for i := range cfg.Listeners {
+		if err := CheckListener(&cfg.Listeners[i]); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.HTTP.RateLimit.PerMinute == 0 {
+		cfg.HTTP.RateLimit.PerMinute = 60
+	}
+	if cfg.HTTP.RateLimit.Burst == 0 {
+		cfg.HTTP.RateLimit.Burst = 10
+	}
+	if cfg.HTTP.CommandRateLimit.PerMinute == 0 {
+		cfg.HTTP.CommandRateLimit.PerMinute = 20
+	}
+	if cfg.HTTP.CommandRateLimit.Burst == 0 {
+		cfg.HTTP.CommandRateLimit.Burst = 1
+	}
+	if b := cfg.HTTP.BasePath; b != "" && !strings.HasPrefix(b, "/") {
+		return nil, fmt.Errorf("http.base_path must start with /, not %q", b)
+	}
+	switch cfg.HTTP.Busy.Mode {
+	case "":
+		cfg.HTTP.Busy.Mode = "busy"
+	case "busy", "wait", "queue", "reject":
+	default:
+		return nil, fmt.Errorf("http.busy.mode must be busy, wait, queue or reject, not %q", cfg.HTTP.Busy.Mode)
+	}
+	if cfg.HTTP.Busy.Timeout == 0 {
+		cfg.HTTP.Busy.Timeout = 10 * time.Second
+	}
+	if cfg.HTTP.Busy.QueueSize == 0 {
+		cfg.HTTP.Busy.QueueSize = 8
+	}
+	if cfg.HTTP.Busy.QueueTimeout == 0 {
+		cfg.HTTP.Busy.QueueTimeout = time.Minute
+	}
+	if cfg.HTTP.Busy.RetryAfter == 0 {
+		cfg.HTTP.Busy.RetryAfter = 2 * time.Second
+	}
+	if cfg.HTTP.Events.Retry == 0 {
+		cfg.HTTP.Events.Retry = 3 * time.Second
+	}
+	if cfg.HTTP.Events.KeepAlive == 0 {
+		cfg.HTTP.Events.KeepAlive = 15 * time.Second
+	}
+	if cfg.History.Interval == 0 {
+		cfg.History.Interval = time.Minute
+	}
+	if cfg.History.RawRetention == 0 {
+		cfg.History.RawRetention = 30 * 24 * time.Hour
+	}
+	if cfg.History.HourlyRetention == 0 {
+		cfg.History.HourlyRetention = 2 * 365 * 24 * time.Hour
+	}
+	if cfg.Sensors.PollInterval == 0 {
+		cfg.Sensors.PollInterval = 10 * time.Second
+	}
+	if cfg.Sensors.BLE.MaxAge == 0 {
+		cfg.Sensors.BLE.MaxAge = 10 * time.Minute
+	}
+	if w := cfg.Sensors.Weather; w != nil {
+		if w.Name == "" {
+			w.Name = "weather"
+		}
+		if w.Role == "" {
+			w.Role = "outdoor"
+		}
+		if w.Refresh == 0 {
+			w.Refresh = 15 * time.Minute
+		}
+		switch w.Provider {
+		case "":
+			w.Provider = "open-meteo"
+		case "open-meteo":
+		case "openweathermap":
+			if w.APIKey == "" {
+				return nil, fmt.Errorf("sensors.weather: openweathermap needs an api_key")
+			}
+		default:
+			return nil, fmt.Errorf("sensors.weather.provider must be open-meteo or openweathermap, not %q", w.Provider)
+		}
+		if w.ForecastHours < 0 || w.ForecastHours > 48 {
+			return nil, fmt.Errorf("sensors.weather.forecast_hours must be from 0 to 48, not %d", w.ForecastHours)
+		}
+		if w.ForecastRole == "" {
+			w.ForecastRole = "outdoor_forecast"
+		}
+	}
+	for i := range cfg.Sensors.Feeds {
+		f := &cfg.Sensors.Feeds[i]
+		if f.Name == "" {
+			return nil, fmt.Errorf("sensors.feeds: a feed needs a name")
+		}
+		if f.Unit == "" {
+			f.Unit = "C"
+		}
+		if f.MaxAge == 0 {
+			f.MaxAge = 10 * time.Minute
+		}
+	}
+	for name, c := range cfg.Sensors.Calibration {
+		if c.Window < 1 {
+			c.Window = 5
+			cfg.Sensors.Calibration[name] = c
+		}
+	}
+	for i := range cfg.Sensors.OneWire {
+		if cfg.Sensors.OneWire[i].Name == "" {
+			cfg.Sensors.OneWire[i].Name = cfg.Sensors.OneWire[i].ID
+		}
+	}
+	for i := range cfg.Sensors.Analog {
+		a := &cfg.Sensors.Analog[i]
+		if a.VRef == 0 {
+			a.VRef = 3.3
+		}
+		if a.FullScale == 0 {
+			a.FullScale = 4.096
+		}
+		if a.Address == 0 {
+			a.Address = 0x48
+		}
+		if a.Scale == 0 {
+			a.Scale = 1
+		}
+		if a.Unit == "" {
+			a.Unit = "V"
+		}
+	}
+	return cfg, nil
+}
+
+// CheckListener checks l, filling in its defaults; Load checks those of the file, and this
+// those made from -listen_on.
+func CheckListener(l *Listener) error {
+	if l.Name == "" {
+		l.Name = l.Address
+	}
+	if t := l.TLS; t != nil && (t.CertFile == "" || t.KeyFile == "") {
+		return fmt.Errorf("listener %s: tls needs cert_file and key_file", l.Name)
+	}
+	if path := strings.TrimPrefix(l.Address, "unix:"); path != l.Address {
+		if path == "" {
+			return fmt.Errorf("listener %s: unix: needs a socket path", l.Name)
+		}
+		if l.SocketMode == "" {
+			l.SocketMode = "0660"
+		}
+		if m, err := strconv.ParseUint(l.SocketMode, 8, 32); err != nil || m > 0777 {
+			return fmt.Errorf("listener %s: socket_mode must be octal permissions, e.g. 0660, not %q", l.Name, l.SocketMode)
+		}
+		return nil
+	}
+	host, _, err := net.SplitHostPort(l.Address)
+	if err != nil {
+		return fmt.Errorf("listener %s: address must be host:port or unix:path, not %q", l.Name, l.Address)
+	}
+	if l.SocketMode != "" {
+		return fmt.Errorf("listener %s: socket_mode is for unix: addresses", l.Name)
+	}
+	if ip := net.ParseIP(strings.SplitN(host, "%", 2)[0]); l.Trusted && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("listener %s: trusted needs a unix: or loopback address", l.Name)
+	}
+	return nil
+}
+
+// checkSetupCode checks a HomeKit setup code: eight digits as XXX-XX-XXX, and not one of
+// the trivial codes HomeKit refuses.
+func checkSetupCode(pin string) error {
+	if len(pin) != 10 || pin[3] != '-' || pin[6] != '-' {
+		return fmt.Errorf("homekit.pin must be eight digits as XXX-XX-XXX, not %q", pin)
+	}
+	digits := pin[:3] + pin[4:6] + pin[7:]
+	for _, d := range digits {
+		if d < '0' || d > '9' {
+			return fmt.Errorf("homekit.pin must be eight digits as XXX-XX-XXX, not %q", pin)
+		}
+	}
+	if digits == "12345678" || digits == "87654321" || strings.Count(digits, digits[:1]) == 8 {
+		return fmt.Errorf("homekit.pin %s is too easily guessed for HomeKit", pin)
+	}
+	return nil
+}
+
+// proflameDefaults fills in the proflame settings left out.
+func proflameDefaults(p *Proflame) {
+	if p.Repeats == 0 {
+		p.Repeats = 5
+	}
+	if p.Gap == 0 {
+		p.Gap = 10 * time.Millisecond
+	}
+	if p.Levels == 0 {
+		p.Levels = 6
+	}
+}
@@ -0,0 +1,137 @@
+// Package demand follows a utility demand-response signal (a simple flag over MQTT or
+// HTTP) and holds the fireplace back during peak-price events: flame up is refused, and
+// with policy defer ignition is refused too and carried out once the event ends.
+package demand
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/mqtt"
+)
+
+// source is what deferred ignitions are recorded as.
+const source = "demand"
+
+// Signal is the current demand-response event, if any.
+type Signal struct {
+	cfg    config.DemandResponse
+	runner *actions.Runner
+
+	mu       sync.Mutex
+	active   bool
+	until    time.Time // zero: until the signal clears
+	override bool      // the user chose to ignore the current event
+	deferred bool      // ignition was refused and runs when the event ends
+}
+
+// State describes the signal for clients.
+type State struct {
+	Active   bool      `json:"active"`
+	Until    time.Time `json:"until,omitempty"`
+	Override bool      `json:"override"`
+	Deferred bool      `json:"deferred_ignition"`
+}
+
+// New returns a signal with no event active.
+func New(cfg config.DemandResponse) *Signal {
+	return &Signal{cfg: cfg}
+}
+
+// Start follows the MQTT signal topic, if configured, and ends timed events. Deferred
+// ignitions are run with runner.
+func (s *Signal) Start(client *mqtt.Client, runner *actions.Runner) error {
+	s.runner = runner
+	if s.cfg.Topic != "" {
+		if client == nil {
+			return fmt.Errorf("demand_response.topic needs mqtt.broker")
+		}
+		client.Subscribe(s.cfg.Topic, func(_ string, payload []byte) {
+			p := strings.TrimSpace(string(payload))
+			active := false
+			for _, v := range s.cfg.ActiveValues {
+				if strings.EqualFold(v, p) {
+					active = true
+				}
+			}
+			s.Set(active, time.Time{})
+		})
+	}
+	go func() {
+		for range time.Tick(time.Second) {
+			s.mu.Lock()
+			expired := s.active && !s.until.IsZero() && time.Now().After(s.until)
+			s.mu.Unlock()
+			if expired {
+				s.Set(false, time.Time{})
+			}
+		}
+	}()
+	return nil
+}
+
+// Set starts an event, lasting until until if that isn't zero, or ends the current one.
+func (s *Signal) Set(active bool, until time.Time) {
+	s.mu.Lock()
+	was := s.active
+	s.active, s.until = active, until
+	runDeferred := was && !active && s.deferred && !s.override
+	if !active {
+		s.override, s.deferred = false, false
+	}
+	s.mu.Unlock()
+	if was != active {
+		logging.Event(logging.Notice, "demand response", "active", fmt.Sprint(active))
+	}
+	if runDeferred && s.runner != nil {
+		logging.Event(logging.Notice, "demand response over, running deferred ignition")
+		go s.runner.Run("on", source)
+	}
+}
+
+// Override ignores the current event until it ends, or stops ignoring it.
+func (s *Signal) Override(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.override = on && s.active
+	if s.override {
+		s.deferred = false
+	}
+}
+
+// State returns the current event.
+func (s *Signal) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return State{Active: s.active, Until: s.until, Override: s.override, Deferred: s.deferred}
+}
+
+// Guard refuses flame up during an event and, with policy defer, ignition too, which is
+// then remembered and run when the event ends. Commands from the signal's own deferred
+// ignition pass.
+func (s *Signal) Guard(op, from string) error {
+	if from == source {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.active || s.override {
+		return nil
+	}
+	switch {
+	case op == "flameup":
+		return fmt.Errorf("%w: flame capped during a demand-response event", fireplace.ErrLockout)
+	case op == "on" && s.cfg.Policy == "defer":
+		s.deferred = true
+		return fmt.Errorf("%w: ignition deferred until the demand-response event ends", fireplace.ErrLockout)
+	case op == "off":
+		s.deferred = false
+	}
+	return nil
+}
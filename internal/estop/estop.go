@@ -0,0 +1,136 @@
+// Package estop is the emergency stop: a latch, per fireplace, that once engaged refuses
+// every command but off, whichever interface or automation sends it, until it is cleared
+// explicitly. Engaging it is left to the caller, which cuts short what is running and turns
+// the fire off; the latch only keeps it that way. With estop.file set it survives restarts.
+package estop
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// Latch is the emergency stop of each fireplace, by name: "" for the main one and the names
+// of config fireplaces for the others.
+type Latch struct {
+	cfg config.EStop
+
+	mu      sync.Mutex
+	engaged map[string]State
+}
+
+// State is whether a fireplace's emergency stop is engaged, since when and by whom.
+type State struct {
+	Engaged bool       `json:"engaged"`
+	Since   *time.Time `json:"since,omitempty"`
+	By      string     `json:"by,omitempty"` // the client or source that engaged it
+}
+
+// Open returns the latch of cfg, engaged as it was when last kept in cfg.File.
+func Open(cfg config.EStop) (*Latch, error) {
+	l := &Latch{cfg: cfg, engaged: map[string]State{}}
+	if cfg.File == "" {
+		return l, nil
+	}
+	data, err := ioutil.ReadFile(cfg.File)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &l.engaged); err != nil {
+		return nil, fmt.Errorf("estop: %s: %v", cfg.File, err)
+	}
+	for name, st := range l.engaged {
+		logging.Event(logging.Warning, "emergency stop still engaged", "fireplace", label(name), "by", st.By)
+	}
+	return l, nil
+}
+
+// Engage engages the emergency stop of the fireplace called name on behalf of by, reporting
+// whether it wasn't already.
+func (l *Latch) Engage(name, by string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.engaged[name].Engaged {
+		return false, nil
+	}
+	now := time.Now()
+	l.engaged[name] = State{Engaged: true, Since: &now, By: by}
+	logging.Event(logging.Err, "emergency stop engaged", "fireplace", label(name), "by", by)
+	events.Publish(events.Command{Op: op(name), Result: "engaged", Source: by})
+	return true, l.save()
+}
+
+// Clear releases the emergency stop of the fireplace called name on behalf of by, reporting
+// whether it was engaged.
+func (l *Latch) Clear(name, by string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.engaged[name].Engaged {
+		return false, nil
+	}
+	delete(l.engaged, name)
+	logging.Event(logging.Notice, "emergency stop cleared", "fireplace", label(name), "by", by)
+	events.Publish(events.Command{Op: op(name), Result: "cleared", Source: by})
+	return true, l.save()
+}
+
+// State returns the state of the emergency stop of the fireplace called name.
+func (l *Latch) State(name string) State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.engaged[name]
+}
+
+// Guard returns the runner guard of the fireplace called name, which refuses every command
+// but off while its emergency stop is engaged.
+func (l *Latch) Guard(name string) func(op, source string) error {
+	return func(cmd, source string) error {
+		if cmd == "off" || !l.State(name).Engaged {
+			return nil
+		}
+		logging.Event(logging.Notice, "command blocked by the emergency stop", "fireplace", label(name), "op", cmd,
+			"source", source)
+		events.Publish(events.Command{Op: op(name), Result: "blocked", Source: source, Params: map[string]string{"op": cmd}})
+		return fmt.Errorf("%w: the emergency stop is engaged", fireplace.ErrLockout)
+	}
+}
+
+// save writes the latch to the file, if any; callers must hold mu.
+func (l *Latch) save() error {
+	if l.cfg.File == "" {
+		return nil
+	}
+	data, err := json.Marshal(l.engaged)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(l.cfg.File, data, 0600)
+}
+
+// op is what the emergency stop of the fireplace called name is published as: estop, or
+// name/estop for a further fireplace, as its runner names its commands.
+func op(name string) string {
+	if name == "" {
+		return "estop"
+	}
+	return name + "/estop"
+}
+
+func label(name string) string {
+	if name == "" {
+		return "main"
+	}
+	return name
+}
@@ -0,0 +1,82 @@
+package estop
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fireplace"
+)
+
+func TestGuard(t *testing.T) {
+	tests := []struct {
+		name    string
+		engaged []string // the fireplaces whose emergency stop is engaged
+		guard   string
+		op      string
+		refused bool
+	}{
+		{name: "clear", guard: "", op: "on"},
+		{name: "on refused", engaged: []string{""}, guard: "", op: "on", refused: true},
+		{name: "flameup refused", engaged: []string{""}, guard: "", op: "flameup", refused: true},
+		{name: "light refused", engaged: []string{""}, guard: "", op: "light", refused: true},
+		{name: "off allowed", engaged: []string{""}, guard: "", op: "off"},
+		{name: "other fireplace unaffected", engaged: []string{"den"}, guard: "", op: "on"},
+		{name: "fireplace refused", engaged: []string{"den"}, guard: "den", op: "on", refused: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := Open(config.EStop{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.engaged {
+				if _, err := l.Engage(name, "test"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = l.Guard(tt.guard)(tt.op, "http")
+			if refused := err != nil; refused != tt.refused {
+				t.Fatalf("guard of %q for %s: got %v, want refused %v", tt.guard, tt.op, err, tt.refused)
+			}
+			if err != nil && !errors.Is(err, fireplace.ErrLockout) {
+				t.Errorf("refusal %v isn't a lockout", err)
+			}
+		})
+	}
+}
+
+func TestLatchSurvivesRestart(t *testing.T) {
+	cfg := config.EStop{File: filepath.Join(t.TempDir(), "estop.json")}
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if engaged, err := l.Engage("", "panel"); err != nil || !engaged {
+		t.Fatalf("engage: %v, %v", engaged, err)
+	}
+	if engaged, _ := l.Engage("", "panel"); engaged {
+		t.Error("engaged twice")
+	}
+	l, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := l.State(""); !st.Engaged || st.By != "panel" {
+		t.Fatalf("after reopening: %+v", st)
+	}
+	if cleared, err := l.Clear("", "admin"); err != nil || !cleared {
+		t.Fatalf("clear: %v, %v", cleared, err)
+	}
+	if err := l.Guard("")("on", "http"); err != nil {
+		t.Errorf("on after clearing: %v", err)
+	}
+	l, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.State("").Engaged {
+		t.Error("still engaged after clearing and reopening")
+	}
+}
@@ -0,0 +1,97 @@
+// Package events broadcasts command outcomes to the subsystems that report state to
+// clients, whichever interface the command came in on.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Command is the outcome of one command.
+type Command struct {
+	Op     string    `json:"op"`     // on, off, flameup, flamedown, light, ...
+	Result string    `json:"result"` // ok, busy, lockout, error, ...
+	Source string    `json:"source"` // http, ble, ...
+	Time   time.Time `json:"time"`
+	// Params are the command's arguments, e.g. a fan speed; nil for most commands.
+	Params map[string]string `json:"params,omitempty"`
+	// Client is who the source ran the command for, e.g. an HTTP client's address; empty
+	// when the source is all there is to know.
+	Client string `json:"client,omitempty"`
+}
+
+var mu sync.Mutex
+var subscribers = map[chan Command]struct{}{}
+
+// Publish sends c to every subscriber. A subscriber that has fallen behind misses it
+// rather than holding up the command.
+func Publish(c Command) {
+	if c.Time.IsZero() {
+		c.Time = time.Now()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	remember(c)
+	for ch := range subscribers {
+		select {
+		case ch <- c:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every published command, and a function that
+// unsubscribes and closes it.
+func Subscribe() (<-chan Command, func()) {
+	ch := make(chan Command, 16)
+	mu.Lock()
+	subscribers[ch] = struct{}{}
+	mu.Unlock()
+	return ch, func() {
+		mu.Lock()
+		if _, ok := subscribers[ch]; ok {
+			delete(subscribers, ch)
+			close(ch)
+		}
+		mu.Unlock()
+	}
+}
+
+// Start is a command about to run its relay sequence.
+type Start struct {
+	Op     string    `json:"op"`
+	Source string    `json:"source"`
+	Time   time.Time `json:"time"`
+}
+
+var starts = map[chan Start]struct{}{}
+
+// PublishStart tells the SubscribeStarts subscribers that op is about to run on behalf of
+// source. Like Publish, it never waits for a subscriber.
+func PublishStart(op, source string) {
+	s := Start{Op: op, Source: source, Time: time.Now()}
+	mu.Lock()
+	defer mu.Unlock()
+	for ch := range starts {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+}
+
+// SubscribeStarts is Subscribe for commands starting.
+func SubscribeStarts() (<-chan Start, func()) {
+	ch := make(chan Start, 16)
+	mu.Lock()
+	starts[ch] = struct{}{}
+	mu.Unlock()
+	return ch, func() {
+		mu.Lock()
+		if _, ok := starts[ch]; ok {
+			delete(starts, ch)
+			close(ch)
+		}
+		mu.Unlock()
+	}
+}
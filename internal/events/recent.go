@@ -0,0 +1,32 @@
+package events
+
+// maxRecent is how many commands Recent can return.
+const maxRecent = 100
+
+// recent is a ring of the last maxRecent commands published; next is where the next goes.
+var recent [maxRecent]Command
+var next, stored int
+
+// remember keeps c for Recent; callers must hold mu.
+func remember(c Command) {
+	recent[next] = c
+	next = (next + 1) % maxRecent
+	if stored < maxRecent {
+		stored++
+	}
+}
+
+// Recent returns up to the last n commands published, newest first. They are kept in
+// memory only, apart from the history store, so that the last change is cheap to show.
+func Recent(n int) []Command {
+	mu.Lock()
+	defer mu.Unlock()
+	if n > stored {
+		n = stored
+	}
+	out := make([]Command, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, recent[(next-i+maxRecent)%maxRecent])
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/metrics"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// Result maps the error returned by a GV60 sequence to a command result: ok, busy,
+// lockout, cancelled, unsupported or error. Refusals and failures are logged.
+func Result(op string, err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, gv60.ErrBusy):
+		return "busy"
+	case errors.Is(err, gv60.ErrLockout):
+		logging.Event(logging.Notice, "ignition refused", "op", op, "reason", err.Error())
+		return "lockout"
+	case errors.Is(err, gv60.ErrCancelled):
+		return "cancelled"
+	case errors.Is(err, gv60.ErrUnsupported):
+		return "unsupported"
+	}
+	logging.Logf(logging.Err, "%s: %v", op, err)
+	return "error"
+}
+
+// Record counts, logs and publishes the outcome of a command from source.
+func Record(op, result, source string) {
+	RecordWith(op, result, source, nil)
+}
+
+// RecordWith is Record for a command with arguments, such as a fan speed.
+func RecordWith(op, result, source string, params map[string]string) {
+	RecordContext(context.Background(), op, result, source, params)
+}
+
+// RecordContext is RecordWith for a command run with ctx, recording the client it carries
+// (see WithClient).
+func RecordContext(ctx context.Context, op, result, source string, params map[string]string) {
+	if gv60.IsDryRun(ctx) {
+		dry := map[string]string{"dry_run": "true"}
+		for k, v := range params {
+			dry[k] = v
+		}
+		params = dry
+	}
+	metrics.CountCommand(op, result)
+	client := Client(ctx)
+	kv := []string{"op", op, "result", result, "source", source}
+	if client != "" {
+		kv = append(kv, "client", client)
+	}
+	var keys []string
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		kv = append(kv, k, params[k])
+	}
+	logging.Event(logging.Info, "command", kv...)
+	Publish(Command{Op: op, Result: result, Source: source, Params: params, Client: client})
+}
+
+type clientKey struct{}
+
+// WithClient returns ctx for commands run on behalf of client, such as the address of the
+// HTTP client that sent them, so that they are recorded with it.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// Client returns the client ctx carries, or "".
+func Client(ctx context.Context) string {
+	c, _ := ctx.Value(clientKey{}).(string)
+	return c
+}
@@ -0,0 +1,85 @@
+// Package fault turns a panic anywhere in GoFire into a latched fault rather than a crash
+// with relay contacts possibly left closed: every registered contact is opened, the stack is
+// logged, and the fault stays latched until an admin clears it.
+package fault
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+var (
+	mu       sync.Mutex
+	contacts []relay.Line
+	latched  *State
+)
+
+// State describes a latched fault.
+type State struct {
+	Where string    `json:"where"` // the handler or worker that panicked
+	Panic string    `json:"panic"`
+	Time  time.Time `json:"time"`
+}
+
+// Register adds relay lines to open (set to 1) on a fault.
+func Register(lines ...relay.Line) {
+	mu.Lock()
+	contacts = append(contacts, lines...)
+	mu.Unlock()
+}
+
+// Recover, deferred, latches a fault if the caller panics, and stops the panic.
+func Recover(where string) {
+	if v := recover(); v != nil {
+		Trip(where, v, debug.Stack())
+	}
+}
+
+// Go runs f in a goroutine that latches a fault rather than crash GoFire if it panics.
+func Go(where string, f func()) {
+	go func() {
+		defer Recover(where)
+		f()
+	}()
+}
+
+// Trip opens every registered contact, logs the panic with its stack and latches a fault.
+func Trip(where string, v interface{}, stack []byte) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, l := range contacts {
+		if err := l.SetValue(1); err != nil {
+			logging.Logf(logging.Err, "fault: opening contact: %v", err)
+		}
+	}
+	if latched == nil {
+		latched = &State{Where: where, Panic: fmt.Sprint(v), Time: time.Now()}
+	}
+	logging.Event(logging.Err, "panic: contacts opened and fault latched", "where", where,
+		"panic", fmt.Sprint(v), "stack", string(stack))
+}
+
+// Latched returns the latched fault, or nil.
+func Latched() *State {
+	mu.Lock()
+	defer mu.Unlock()
+	if latched == nil {
+		return nil
+	}
+	s := *latched
+	return &s
+}
+
+// Clear unlatches the fault, reporting whether one was latched.
+func Clear() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	was := latched != nil
+	latched = nil
+	return was
+}
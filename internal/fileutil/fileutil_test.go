@@ -0,0 +1,55 @@
+package fileutil
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	for _, data := range []string{`{"v":1}`, `{"v":2}`} {
+		if err := WriteAtomic(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ioutil.ReadFile(path); err != nil || string(got) != data {
+			t.Fatalf("read back %q, %v; want %q", got, err, data)
+		}
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("mode %v, %v; want 0600", fi.Mode().Perm(), err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("%s.tmp left behind: %v", path, err)
+	}
+}
+
+func TestWriteAtomicFuncFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := WriteAtomic(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cut := errors.New("connection reset")
+	err := WriteAtomicFunc(path, 0600, func(w io.Writer) error {
+		io.WriteString(w, "half of the new")
+		return cut
+	})
+	if err != cut {
+		t.Fatalf("got %v, want %v", err, cut)
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != "old" {
+		t.Errorf("a failed write replaced the file with %q", got)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("%s.tmp left behind: %v", path, err)
+	}
+}
+
+func TestWriteAtomicMissingDir(t *testing.T) {
+	if err := WriteAtomic(filepath.Join(t.TempDir(), "nope", "state.json"), nil, 0600); err == nil {
+		t.Error("wrote into a directory that doesn't exist")
+	}
+}
@@ -0,0 +1,117 @@
+// Package fireplace defines what the API and integrations control, whichever driver runs
+// the fireplace: relays on the valve's wall-switch contacts (package gv60) or an RF
+// transmitter speaking the fireplace's remote protocol.
+package fireplace
+
+import (
+	"context"
+	"time"
+
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// Errors every driver returns, so command results mean the same whatever the driver.
+var (
+	ErrBusy        = gv60.ErrBusy
+	ErrLockout     = gv60.ErrLockout
+	ErrCancelled   = gv60.ErrCancelled
+	ErrUnsupported = gv60.ErrUnsupported
+)
+
+// Fireplace is a fireplace driver. Operations return ErrBusy while another is running.
+type Fireplace interface {
+	On() error
+	Off() error
+	FlameUp() error
+	FlameDown() error
+	Aux() error
+	// Cancel cuts short the running operation, reporting whether there was one.
+	Cancel() bool
+	// Drain waits for the running operation and refuses any more, before the hardware is
+	// handed to another process.
+	Drain()
+}
+
+// Fan is implemented by drivers that control a blower fan.
+type Fan interface {
+	// SetFan sets the fan speed, 0 for off.
+	SetFan(speed int) error
+}
+
+// AuxBurner is implemented by drivers that can light and put out a second burner.
+type AuxBurner interface {
+	AuxOn() error
+	AuxOff() error
+}
+
+// SetAuxBurner lights or puts out f's second burner, or returns ErrUnsupported if it has
+// none.
+func SetAuxBurner(f Fireplace, on bool) error {
+	a, ok := f.(AuxBurner)
+	if !ok {
+		return ErrUnsupported
+	}
+	if on {
+		return a.AuxOn()
+	}
+	return a.AuxOff()
+}
+
+// FlameTimer is implemented by drivers that move the flame for as long as a contact is
+// held, so it can be set to a level with one timed pulse.
+type FlameTimer interface {
+	FlameFor(up bool, d time.Duration) error
+}
+
+// Standby is implemented by drivers that can turn the fire down to its pilot flame.
+type Standby interface {
+	Pilot() error
+}
+
+// ToPilot turns f down to its pilot flame, or returns ErrUnsupported if it can't.
+func ToPilot(f Fireplace) error {
+	s, ok := f.(Standby)
+	if !ok {
+		return ErrUnsupported
+	}
+	return s.Pilot()
+}
+
+// WithContext returns f with its operations bound to ctx, so that they are cut short (every
+// contact opened) once ctx is done, failing with ErrCancelled, and run as dry runs for a
+// ctx from gv60.DryRun. Only the relay driver can be bound; other drivers are returned as
+// they are and run their operations to the end, except that those driving hardware fail
+// every dry run with ErrUnsupported rather than carry it out.
+func WithContext(ctx context.Context, f Fireplace) Fireplace {
+	if r, ok := f.(*Relays); ok {
+		cp := *r
+		cp.ctx = ctx
+		return &cp
+	}
+	if _, ok := f.(*Simulated); !ok && gv60.IsDryRun(ctx) {
+		return noDryRun{}
+	}
+	return f
+}
+
+// noDryRun is a driver that can't dry-run.
+type noDryRun struct{}
+
+func (noDryRun) On() error        { return ErrUnsupported }
+func (noDryRun) Off() error       { return ErrUnsupported }
+func (noDryRun) FlameUp() error   { return ErrUnsupported }
+func (noDryRun) FlameDown() error { return ErrUnsupported }
+func (noDryRun) Aux() error       { return ErrUnsupported }
+func (noDryRun) Cancel() bool     { return false }
+func (noDryRun) Drain()           {}
+
+// Checker is implemented by drivers that can tell when they are wedged, so that a command
+// would never get to run.
+type Checker interface {
+	Check() error
+}
+
+// SplitFlow is implemented by drivers that control a split-flow (rear burner) valve.
+type SplitFlow interface {
+	SetSplitFlow(on bool) error
+}
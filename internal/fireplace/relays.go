@@ -0,0 +1,46 @@
+package fireplace
+
+import (
+	"context"
+	"time"
+
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// Relays is the relay driver: a gv60.Controller, its commands run with the context
+// WithContext bound them to.
+type Relays struct {
+	*gv60.Controller
+	ctx context.Context // nil unless returned by WithContext
+}
+
+// NewRelays returns the driver of c.
+func NewRelays(c *gv60.Controller) *Relays {
+	return &Relays{Controller: c}
+}
+
+var _ Fireplace = (*Relays)(nil)
+var _ AuxBurner = (*Relays)(nil)
+var _ Standby = (*Relays)(nil)
+var _ FlameTimer = (*Relays)(nil)
+var _ Checker = (*Relays)(nil)
+
+func (r *Relays) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+func (r *Relays) On() error        { return r.Controller.On(r.context()) }
+func (r *Relays) Off() error       { return r.Controller.Off(r.context()) }
+func (r *Relays) FlameUp() error   { return r.Controller.FlameUp(r.context(), 1) }
+func (r *Relays) FlameDown() error { return r.Controller.FlameDown(r.context(), 1) }
+func (r *Relays) Aux() error       { return r.Controller.Aux(r.context()) }
+func (r *Relays) AuxOn() error     { return r.Controller.AuxOn(r.context()) }
+func (r *Relays) AuxOff() error    { return r.Controller.AuxOff(r.context()) }
+func (r *Relays) Pilot() error     { return r.Controller.Pilot(r.context()) }
+
+func (r *Relays) FlameFor(up bool, d time.Duration) error {
+	return r.Controller.FlameFor(r.context(), up, d)
+}
@@ -0,0 +1,84 @@
+package fireplace
+
+import (
+	"sync"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// Simulated is a fireplace without hardware, for trying out schedules and integrations.
+// Operations complete at once and are logged.
+type Simulated struct {
+	// CheckIgnition, if set, is consulted before ignition, as for gv60.
+	CheckIgnition func() error
+
+	mu        sync.Mutex
+	on        bool
+	level     int
+	fan       int
+	splitFlow bool
+	aux       bool
+}
+
+// SimulatedLevels is the number of flame levels of the simulated fireplace.
+const SimulatedLevels = 6
+
+var _ Fireplace = (*Simulated)(nil)
+var _ Fan = (*Simulated)(nil)
+var _ SplitFlow = (*Simulated)(nil)
+
+func (s *Simulated) On() error {
+	if s.CheckIgnition != nil {
+		if err := s.CheckIgnition(); err != nil {
+			return err
+		}
+	}
+	return s.change("on", func() { s.on, s.level = true, SimulatedLevels })
+}
+
+func (s *Simulated) Off() error {
+	return s.change("off", func() { s.on, s.level = false, 0 })
+}
+
+func (s *Simulated) FlameUp() error {
+	return s.change("flameup", func() {
+		if s.on && s.level < SimulatedLevels {
+			s.level++
+		}
+	})
+}
+
+func (s *Simulated) FlameDown() error {
+	return s.change("flamedown", func() {
+		if s.on && s.level > 1 {
+			s.level--
+		}
+	})
+}
+
+func (s *Simulated) Aux() error {
+	return s.change("aux", func() { s.aux = !s.aux })
+}
+
+func (s *Simulated) SetFan(speed int) error {
+	return s.change("fan", func() { s.fan = speed })
+}
+
+func (s *Simulated) SetSplitFlow(on bool) error {
+	return s.change("splitflow", func() { s.splitFlow = on })
+}
+
+// Cancel reports false: simulated operations finish at once.
+func (s *Simulated) Cancel() bool { return false }
+
+// Drain has nothing to wait for.
+func (s *Simulated) Drain() {}
+
+func (s *Simulated) change(op string, apply func()) error {
+	s.mu.Lock()
+	apply()
+	on, level, fan := s.on, s.level, s.fan
+	s.mu.Unlock()
+	logging.Logf(logging.Info, "simulated fireplace: %s (on %v, level %d, fan %d)", op, on, level, fan)
+	return nil
+}
@@ -0,0 +1,286 @@
+// Package flame sets the flame to a level with one timed pulse rather than a run of flame up
+// or down steps: the valve's motor moves the flame for as long as a contact is held, taking
+// the travel time from lowest to highest, so the pulse lasts the share of the travel time
+// that the change of level is of the whole range.
+//
+// The travel time is valve.travel until it is calibrated: the flame is driven right down,
+// then held going up until the user, watching it, says it has reached full, and the time
+// that took is kept in valve.calibration_file.
+package flame
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// maxTravel bounds a calibration: a valve slower than this is taken to have stalled.
+const maxTravel = time.Minute
+
+var (
+	// ErrNotCalibrating is returned by Finish when no calibration is waiting for it.
+	ErrNotCalibrating = errors.New("flame: not calibrating")
+	// ErrCalibrating is returned by Calibrate while a calibration is running.
+	ErrCalibrating = errors.New("flame: already calibrating")
+)
+
+// Control sets the flame level with timed pulses.
+type Control struct {
+	runner *actions.Runner
+	timer  fireplace.FlameTimer
+	fire   fireplace.Fireplace
+	power  *power.Tracker
+	file   string
+
+	mu     sync.Mutex
+	travel time.Duration
+	cal    *calibration // nil unless calibrating
+}
+
+// calibration is a calibration in progress.
+type calibration struct {
+	phase  string    // down, then up
+	upFrom time.Time // when the flame started going up
+	done   bool      // Finish has been called
+	result chan error
+}
+
+// State is the travel time and any calibration in progress.
+type State struct {
+	Travel      string `json:"travel"`                // e.g. 12s
+	Calibrating string `json:"calibrating,omitempty"` // down or up while calibrating
+}
+
+// saved is the layout of the calibration file.
+type saved struct {
+	Travel string `json:"travel"`
+}
+
+// New returns a Control for fire, or nil if its driver can't hold a flame contact for a
+// given time. A travel time saved by an earlier calibration replaces cfg.Travel.
+func New(cfg config.Valve, fire fireplace.Fireplace, runner *actions.Runner, pw *power.Tracker) (*Control, error) {
+	timer, ok := fire.(fireplace.FlameTimer)
+	if !ok {
+		return nil, nil
+	}
+	c := &Control{runner: runner, timer: timer, fire: fire, power: pw, file: cfg.CalibrationFile, travel: cfg.Travel}
+	if c.file == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(c.file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s saved
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	travel, err := time.ParseDuration(s.Travel)
+	if err != nil || travel <= 0 {
+		return nil, errors.New("flame: bad travel in " + c.file)
+	}
+	c.setTravel(travel)
+	return c, nil
+}
+
+// State returns the travel time and any calibration in progress.
+func (c *Control) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := State{Travel: c.travel.String()}
+	if c.cal != nil {
+		s.Calibrating = c.cal.phase
+	}
+	return s
+}
+
+// Set moves the flame to level, from 1 (lowest) to the valve's levels, with one pulse on
+// behalf of source; level 0 turns the fire down to its pilot. A flame at an unknown level
+// is first driven right down. It returns the result, or unlit when the fire isn't lit.
+func (c *Control) Set(level int, source string) string {
+	return c.SetContext(context.Background(), level, source)
+}
+
+// SetContext is Set for a request that is abandoned once ctx is done, cutting the pulse
+// short.
+func (c *Control) SetContext(ctx context.Context, level int, source string) string {
+	if level == 0 {
+		return c.runner.RunContext(ctx, "pilot", source)
+	}
+	st := c.power.State()
+	if st.Power != "on" {
+		return "unlit"
+	}
+	c.mu.Lock()
+	travel := c.travel
+	c.mu.Unlock()
+	max := c.power.Levels().Max
+	params := map[string]string{"level": strconv.Itoa(level)}
+	from := 1
+	if st.FlameLevel != nil {
+		from = *st.FlameLevel
+	} else if result := c.runner.DoContext(ctx, "setflame", source, flameFor(false, travel)); result != "ok" {
+		// the whole travel time down always reaches the lowest level
+		events.RecordContext(ctx, "setflame", result, source, params)
+		return result
+	}
+	if level == from {
+		events.RecordContext(ctx, "setflame", "ok", source, params)
+		return "ok"
+	}
+	up, diff := level > from, level-from
+	if diff < 0 {
+		diff = -diff
+	}
+	d := time.Duration(float64(travel) * float64(diff) / float64(max))
+	result := c.runner.DoContext(ctx, "setflame", source, flameFor(up, d))
+	events.RecordContext(ctx, "setflame", result, source, params)
+	return result
+}
+
+// Resync drives the flame right down on behalf of source, so that its estimated level is
+// the lowest whatever it was taken to be. It returns the result, or unlit when the fire
+// isn't lit.
+func (c *Control) Resync(source string) string {
+	return c.ResyncContext(context.Background(), source)
+}
+
+// ResyncContext is Resync cut short once ctx is done.
+func (c *Control) ResyncContext(ctx context.Context, source string) string {
+	if c.power.State().Power != "on" {
+		return "unlit"
+	}
+	c.mu.Lock()
+	travel := c.travel
+	c.mu.Unlock()
+	result := c.runner.DoContext(ctx, "setflame", source, flameFor(false, travel))
+	events.RecordContext(ctx, "setflame", result, source, map[string]string{"level": "1"})
+	return result
+}
+
+// flameFor returns the command holding the flame up (up) or flame down contact of the
+// fireplace it is run on for d.
+func flameFor(up bool, d time.Duration) func(f fireplace.Fireplace) error {
+	return func(f fireplace.Fireplace) error { return f.(fireplace.FlameTimer).FlameFor(up, d) }
+}
+
+// Calibrate starts a calibration on behalf of source: the flame is driven right down and
+// then up, until Finish is called once it reaches full. The fire must be lit.
+func (c *Control) Calibrate(source string) error {
+	if c.power.State().Power != "on" {
+		return errors.New("flame: the fire isn't lit")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cal != nil {
+		return ErrCalibrating
+	}
+	cal := &calibration{phase: "down", result: make(chan error, 1)}
+	c.cal = cal
+	fault.Go("calibrate", func() { c.calibrate(cal, source) })
+	return nil
+}
+
+func (c *Control) calibrate(cal *calibration, source string) {
+	err := c.runCalibration(cal, source)
+	c.mu.Lock()
+	c.cal = nil
+	c.mu.Unlock()
+	if err != nil {
+		logging.Event(logging.Warning, "flame calibration failed", "error", err.Error())
+	}
+	cal.result <- err
+}
+
+func (c *Control) runCalibration(cal *calibration, source string) error {
+	c.mu.Lock()
+	down := c.travel * 3 / 2
+	c.mu.Unlock()
+	if result := c.runner.Do("calibrate", source, func() error { return c.timer.FlameFor(false, down) }); result != "ok" {
+		return errors.New("driving the flame down: " + result)
+	}
+	result := c.runner.Do("calibrate", source, func() error {
+		c.mu.Lock()
+		cal.phase, cal.upFrom = "up", time.Now()
+		c.mu.Unlock()
+		return c.timer.FlameFor(true, maxTravel)
+	})
+	c.mu.Lock()
+	travel, done := time.Since(cal.upFrom), cal.done
+	c.mu.Unlock()
+	switch {
+	case result == "ok":
+		return errors.New("not finished within " + maxTravel.String())
+	case result != "cancelled" || !done:
+		return errors.New("driving the flame up: " + result)
+	}
+	if err := c.save(travel); err != nil {
+		logging.Logf(logging.Warning, "flame: saving calibration: %v", err)
+	}
+	c.setTravel(travel)
+	events.RecordWith("calibrate", "ok", source, map[string]string{"level": strconv.Itoa(c.power.Levels().Max), "travel": travel.Round(10 * time.Millisecond).String()})
+	logging.Event(logging.Notice, "flame travel calibrated", "travel", travel.String())
+	return nil
+}
+
+// Finish ends the calibration once the flame has reached full, and returns the measured
+// travel time.
+func (c *Control) Finish() (time.Duration, error) {
+	c.mu.Lock()
+	cal := c.cal
+	if cal == nil || cal.phase != "up" || cal.done {
+		c.mu.Unlock()
+		return 0, ErrNotCalibrating
+	}
+	cal.done = true
+	c.mu.Unlock()
+	c.fire.Cancel()
+	if err := <-cal.result; err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.travel, nil
+}
+
+// setTravel changes the travel time, rescaling how far the flame up and down steps are
+// estimated to move the flame.
+func (c *Control) setTravel(travel time.Duration) {
+	c.mu.Lock()
+	old := c.travel
+	c.travel = travel
+	c.mu.Unlock()
+	l := c.power.Levels()
+	l.Up *= float64(old) / float64(travel)
+	l.Down *= float64(old) / float64(travel)
+	c.power.SetLevels(l)
+}
+
+// save writes the travel time to the calibration file, if there is one.
+func (c *Control) save(travel time.Duration) error {
+	if c.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(saved{Travel: travel.String()})
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(c.file, data, 0600)
+}
@@ -0,0 +1,162 @@
+// Package frost keeps an empty house from freezing: once the room sensor reads below a
+// threshold it lights the fire and takes the flame to its lowest level, and once it reads
+// above a second, higher threshold it turns off the fire it lit. Its commands go through the
+// runner like any other, so quiet hours, interlocks and the rest can refuse them, and it
+// tries again on its next check; a fire someone else lit, or turned off meanwhile, is left
+// alone.
+package frost
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/sensor"
+)
+
+// source is what frost protection's commands are recorded as.
+const source = "frost"
+
+// settle bounds how long lighting the fire waits for the power tracker to see it lit before
+// turning the flame down.
+const settle = 2 * time.Second
+
+// Guard runs the frost-protection checks.
+type Guard struct {
+	cfg     config.FrostProtection
+	runner  *actions.Runner
+	sensors *sensor.Registry
+	power   *power.Tracker
+
+	mu      sync.Mutex
+	litAt   *time.Time // when the fire frost protection lit was lit, while it burns
+	refused string     // the result of the last refused ignition, to log it once
+	stale   bool       // the reading is missing or too old, logged once
+	last    *Action
+}
+
+// Action is a command frost protection sent.
+type Action struct {
+	Command     string    `json:"command"`
+	Result      string    `json:"result"`
+	Temperature float64   `json:"temperature"`
+	Time        time.Time `json:"time"`
+}
+
+// State is whether frost protection has the fire lit, with its thresholds and the reading.
+type State struct {
+	Active      bool       `json:"active"` // the fire is burning because it was too cold
+	Since       *time.Time `json:"since,omitempty"`
+	Role        string     `json:"role"`
+	Below       float64    `json:"below"`
+	Above       float64    `json:"above"`
+	Temperature *float64   `json:"temperature,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	LastAction  *Action    `json:"last_action,omitempty"`
+}
+
+// Start begins checking the sensor with role cfg.Role, lighting and turning off the fireplace
+// pw tracks with runner.
+func Start(cfg config.FrostProtection, runner *actions.Runner, sensors *sensor.Registry, pw *power.Tracker) *Guard {
+	g := &Guard{cfg: cfg, runner: runner, sensors: sensors, power: pw}
+	fault.Go("frost", g.loop)
+	return g
+}
+
+// State returns the current state.
+func (g *Guard) State() State {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := State{Active: g.litAt != nil, Since: g.litAt, Role: g.cfg.Role, Below: *g.cfg.Below, Above: *g.cfg.Above,
+		LastAction: g.last}
+	if r, ok := g.sensors.ForRole(g.cfg.Role); ok {
+		v := r.Value
+		s.Temperature, s.Unit = &v, r.Unit
+	}
+	return s
+}
+
+func (g *Guard) loop() {
+	for {
+		g.check()
+		time.Sleep(g.cfg.Interval)
+	}
+}
+
+// check lights or turns off the fire, if the reading calls for it.
+func (g *Guard) check() {
+	r, ok := g.sensors.ForRole(g.cfg.Role)
+	fresh := ok && time.Since(r.Time) <= g.cfg.MaxAge
+	g.mu.Lock()
+	if !fresh && !g.stale {
+		logging.Logf(logging.Warning, "frost protection: no recent reading from a %s sensor", g.cfg.Role)
+	}
+	g.stale = !fresh
+	ps := g.power.State()
+	if g.litAt != nil && (ps.Power != "on" || !ps.Since.Equal(*g.litAt)) {
+		// turned off, or off and lit again, by something else since
+		logging.Event(logging.Info, "frost protection: the fire was changed by hand, leaving it", "power", ps.Power)
+		g.litAt = nil
+	}
+	active := g.litAt != nil
+	g.mu.Unlock()
+	switch {
+	case !fresh:
+	case !active && r.Value < *g.cfg.Below && ps.Power != "on":
+		g.light(r.Value)
+	case active && r.Value > *g.cfg.Above:
+		result := g.send("off", r.Value)
+		logging.Event(logging.Notice, "frost protection: warm again, fire off", "result", result,
+			"temperature", fmt.Sprint(r.Value), "above", fmt.Sprint(*g.cfg.Above))
+		if result == "ok" {
+			g.mu.Lock()
+			g.litAt = nil
+			g.mu.Unlock()
+		}
+	}
+}
+
+// light lights the fire and turns it right down.
+func (g *Guard) light(temp float64) {
+	result := g.send("on", temp)
+	if result != "ok" {
+		g.mu.Lock()
+		if result != g.refused {
+			logging.Event(logging.Warning, "frost protection: ignition refused, retrying", "result", result,
+				"temperature", fmt.Sprint(temp))
+		}
+		g.refused = result
+		g.mu.Unlock()
+		return
+	}
+	// the tracker follows the command it is subscribed to in the background
+	var ps power.State
+	for deadline := time.Now().Add(settle); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if ps = g.power.State(); ps.Power == "on" {
+			break
+		}
+	}
+	g.mu.Lock()
+	since := ps.Since
+	g.litAt, g.refused = &since, ""
+	g.mu.Unlock()
+	logging.Event(logging.Notice, "frost protection: too cold, fire lit", "temperature", fmt.Sprint(temp),
+		"below", fmt.Sprint(*g.cfg.Below))
+	if result := g.runner.SetFlame(g.power, 1, source); result != "ok" {
+		logging.Logf(logging.Warning, "frost protection: turning the flame down: %s", result)
+	}
+}
+
+// send runs command and keeps it as the last action.
+func (g *Guard) send(command string, temp float64) string {
+	result := g.runner.Run(command, source)
+	g.mu.Lock()
+	g.last = &Action{Command: command, Result: result, Temperature: temp, Time: time.Now()}
+	g.mu.Unlock()
+	return result
+}
@@ -0,0 +1,337 @@
+package gatt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	attCID           = 4 // fixed L2CAP channel of the attribute protocol
+	bdaddrLEPublic   = 1
+	solBluetooth     = 274
+	btSecurity       = 4
+	btSecurityMedium = 2
+
+	defaultMTU = 23
+	serverMTU  = 185
+)
+
+// ATT opcodes
+const (
+	attErrorRsp       = 0x01
+	attMTUReq         = 0x02
+	attMTURsp         = 0x03
+	attFindInfoReq    = 0x04
+	attFindInfoRsp    = 0x05
+	attFindByTypeReq  = 0x06
+	attFindByTypeRsp  = 0x07
+	attReadByTypeReq  = 0x08
+	attReadByTypeRsp  = 0x09
+	attReadReq        = 0x0A
+	attReadRsp        = 0x0B
+	attReadBlobReq    = 0x0C
+	attReadBlobRsp    = 0x0D
+	attReadByGroupReq = 0x10
+	attReadByGroupRsp = 0x11
+	attWriteReq       = 0x12
+	attWriteRsp       = 0x13
+	attNotification   = 0x1B
+	attWriteCmd       = 0x52
+)
+
+// ATT error codes
+const (
+	errInvalidHandle       = 0x01
+	errReadNotPermitted    = 0x02
+	errWriteNotPermitted   = 0x03
+	errInvalidPDU          = 0x04
+	errRequestNotSupported = 0x06
+	errInvalidOffset       = 0x07
+	errAttrNotFound        = 0x0A
+	errInvalidLength       = 0x0D
+	errUnlikely            = 0x0E
+	errBusy                = 0x80 // application errors
+	errLockout             = 0x81
+)
+
+// conn is the ATT bearer to one connected central.
+type conn struct {
+	fd  int
+	mtu int
+
+	mu        sync.Mutex // guards writes to fd and notifying
+	notifying map[uint16]bool
+}
+
+func (c *conn) send(pdu []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := unix.Write(c.fd, pdu); err != nil {
+		logging.Logf(logging.Warning, "gatt: write: %v", err)
+	}
+}
+
+// notify sends a value notification if the central has enabled them for handle.
+func (c *conn) notify(handle uint16, v []byte) {
+	c.mu.Lock()
+	on := c.notifying[handle]
+	mtu := c.mtu
+	c.mu.Unlock()
+	if !on {
+		return
+	}
+	if len(v) > mtu-3 {
+		v = v[:mtu-3]
+	}
+	pdu := []byte{attNotification, 0, 0}
+	binary.LittleEndian.PutUint16(pdu[1:], handle)
+	c.send(append(pdu, v...))
+}
+
+func errorRsp(req byte, handle uint16, code byte) []byte {
+	return []byte{attErrorRsp, req, byte(handle), byte(handle >> 8), code}
+}
+
+// serve handles requests until the central disconnects.
+func (s *Server) serve(c *conn) {
+	buf := make([]byte, serverMTU)
+	for {
+		n, err := unix.Read(c.fd, buf)
+		if err != nil || n == 0 {
+			return
+		}
+		if rsp := s.handle(c, buf[:n]); rsp != nil {
+			c.send(rsp)
+		}
+	}
+}
+
+// handle processes one request PDU and returns the response, or nil for commands.
+func (s *Server) handle(c *conn, req []byte) []byte {
+	op := req[0]
+	switch op {
+	case attMTUReq:
+		if len(req) != 3 {
+			return errorRsp(op, 0, errInvalidPDU)
+		}
+		mtu := int(binary.LittleEndian.Uint16(req[1:]))
+		if mtu > serverMTU {
+			mtu = serverMTU
+		}
+		if mtu < defaultMTU {
+			mtu = defaultMTU
+		}
+		c.mu.Lock()
+		c.mtu = mtu
+		c.mu.Unlock()
+		return []byte{attMTURsp, byte(serverMTU), byte(serverMTU >> 8)}
+
+	case attFindInfoReq:
+		start, end, ok := handleRange(req)
+		if !ok {
+			return errorRsp(op, start, errInvalidHandle)
+		}
+		rsp := []byte{attFindInfoRsp, 0}
+		for _, a := range s.attrs {
+			if a.handle < start || a.handle > end {
+				continue
+			}
+			format := byte(1)
+			if len(a.typ) == 16 {
+				format = 2
+			}
+			if rsp[1] == 0 {
+				rsp[1] = format
+			}
+			if format != rsp[1] || len(rsp)+2+len(a.typ) > c.mtu {
+				break
+			}
+			rsp = append(rsp, byte(a.handle), byte(a.handle>>8))
+			rsp = append(rsp, a.typ...)
+		}
+		if rsp[1] == 0 {
+			return errorRsp(op, start, errAttrNotFound)
+		}
+		return rsp
+
+	case attFindByTypeReq:
+		start, end, ok := handleRange(req)
+		if !ok || len(req) < 7 {
+			return errorRsp(op, start, errInvalidHandle)
+		}
+		typ, value := req[5:7], req[7:]
+		rsp := []byte{attFindByTypeRsp}
+		for _, a := range s.attrs {
+			if a.handle < start || a.handle > end || !bytes.Equal(a.typ, typ) || a.groupEnd == 0 {
+				continue
+			}
+			if !bytes.Equal(a.value, value) {
+				continue
+			}
+			if len(rsp)+4 > c.mtu {
+				break
+			}
+			rsp = append(rsp, byte(a.handle), byte(a.handle>>8), byte(a.groupEnd), byte(a.groupEnd>>8))
+		}
+		if len(rsp) == 1 {
+			return errorRsp(op, start, errAttrNotFound)
+		}
+		return rsp
+
+	case attReadByTypeReq, attReadByGroupReq:
+		start, end, ok := handleRange(req)
+		if !ok || (len(req) != 7 && len(req) != 21) {
+			return errorRsp(op, start, errInvalidHandle)
+		}
+		typ := req[5:]
+		group := op == attReadByGroupReq
+		if group && !bytes.Equal(typ, uuidPrimaryService) {
+			return errorRsp(op, start, errRequestNotSupported)
+		}
+		rspOp := byte(attReadByTypeRsp)
+		header := 2
+		if group {
+			rspOp, header = attReadByGroupRsp, 4
+		}
+		rsp := []byte{rspOp, 0}
+		for _, a := range s.attrs {
+			if a.handle < start || a.handle > end || !bytes.Equal(a.typ, typ) {
+				continue
+			}
+			v := s.readValue(c, a)
+			if v == nil {
+				if len(rsp) == 2 {
+					return errorRsp(op, a.handle, errReadNotPermitted)
+				}
+				break
+			}
+			if max := c.mtu - 2 - header; len(v) > max {
+				v = v[:max]
+			}
+			entry := header + len(v)
+			if rsp[1] == 0 {
+				rsp[1] = byte(entry)
+			}
+			// every entry of a response must be the same length
+			if int(rsp[1]) != entry || len(rsp)+entry > c.mtu {
+				break
+			}
+			rsp = append(rsp, byte(a.handle), byte(a.handle>>8))
+			if group {
+				rsp = append(rsp, byte(a.groupEnd), byte(a.groupEnd>>8))
+			}
+			rsp = append(rsp, v...)
+		}
+		if rsp[1] == 0 {
+			return errorRsp(op, start, errAttrNotFound)
+		}
+		return rsp
+
+	case attReadReq, attReadBlobReq:
+		if (op == attReadReq && len(req) != 3) || (op == attReadBlobReq && len(req) != 5) {
+			return errorRsp(op, 0, errInvalidPDU)
+		}
+		handle := binary.LittleEndian.Uint16(req[1:])
+		a := s.attr(handle)
+		if a == nil {
+			return errorRsp(op, handle, errInvalidHandle)
+		}
+		v := s.readValue(c, a)
+		if v == nil {
+			return errorRsp(op, handle, errReadNotPermitted)
+		}
+		rspOp := byte(attReadRsp)
+		if op == attReadBlobReq {
+			offset := int(binary.LittleEndian.Uint16(req[3:]))
+			if offset > len(v) {
+				return errorRsp(op, handle, errInvalidOffset)
+			}
+			rspOp, v = attReadBlobRsp, v[offset:]
+		}
+		if len(v) > c.mtu-1 {
+			v = v[:c.mtu-1]
+		}
+		return append([]byte{rspOp}, v...)
+
+	case attWriteReq, attWriteCmd:
+		if len(req) < 3 {
+			if op == attWriteCmd {
+				return nil
+			}
+			return errorRsp(op, 0, errInvalidPDU)
+		}
+		handle := binary.LittleEndian.Uint16(req[1:])
+		code := s.writeValue(c, handle, req[3:])
+		if op == attWriteCmd {
+			return nil
+		}
+		if code != 0 {
+			return errorRsp(op, handle, code)
+		}
+		return []byte{attWriteRsp}
+	}
+	if op&0x40 != 0 {
+		// commands never get a response, not even an error
+		return nil
+	}
+	return errorRsp(op, 0, errRequestNotSupported)
+}
+
+// handleRange parses the starting and ending handles of a request.
+func handleRange(req []byte) (start, end uint16, ok bool) {
+	if len(req) < 5 {
+		return 0, 0, false
+	}
+	start = binary.LittleEndian.Uint16(req[1:])
+	end = binary.LittleEndian.Uint16(req[3:])
+	return start, end, start != 0 && start <= end
+}
+
+func (s *Server) attr(handle uint16) *attribute {
+	if handle == 0 || int(handle) > len(s.attrs) {
+		return nil
+	}
+	return s.attrs[handle-1]
+}
+
+// readValue returns an attribute's value, or nil if it can't be read.
+func (s *Server) readValue(c *conn, a *attribute) []byte {
+	switch {
+	case a.cccdFor != 0:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.notifying[a.cccdFor] {
+			return []byte{0x01, 0x00}
+		}
+		return []byte{0x00, 0x00}
+	case a.read != nil:
+		return a.read()
+	case a.write != nil:
+		return nil // write-only characteristic
+	}
+	return a.value
+}
+
+func (s *Server) writeValue(c *conn, handle uint16, v []byte) byte {
+	a := s.attr(handle)
+	if a == nil {
+		return errInvalidHandle
+	}
+	if a.cccdFor != 0 {
+		if len(v) != 2 {
+			return errInvalidLength
+		}
+		c.mu.Lock()
+		c.notifying[a.cccdFor] = v[0]&0x01 != 0
+		c.mu.Unlock()
+		return 0
+	}
+	if a.write == nil {
+		return errWriteNotPermitted
+	}
+	return a.write(v)
+}
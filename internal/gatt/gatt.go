@@ -0,0 +1,300 @@
+// Package gatt exposes the fireplace as a BLE GATT service from the Pi's own radio, so a
+// phone app or an ESP32 wall panel can control it even when Wi-Fi is down.
+//
+// The GoFire service (6f3c0001-7a1e-4b8e-9c53-0e5f1a4e2b60) has three characteristics:
+//   - power (...0002), read/write/notify: 0 off, 1 on, 0xFF not yet known; writing 1 lights
+//     the fire and 0 turns it off
+//   - level (...0003), write: a signed byte of flame steps, positive for flame up and
+//     negative for flame down (at most 6 either way)
+//   - status (...0004), read/notify: the outcome of the latest command from any source,
+//     e.g. "on_ok" or "flameup_busy"
+//
+// Refused writes fail with ATT application error 0x80 (busy) or 0x81 (ignition lockout).
+//
+// ATT is served on an L2CAP LE socket and advertising is driven through raw HCI, so no
+// BlueZ daemon is needed; if bluetoothd is running it must not serve GATT on the adapter.
+package gatt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/hci"
+	"github.com/barrylb/go-fire/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	serviceUUID = "6f3c0001-7a1e-4b8e-9c53-0e5f1a4e2b60"
+	powerUUID   = "6f3c0002-7a1e-4b8e-9c53-0e5f1a4e2b60"
+	levelUUID   = "6f3c0003-7a1e-4b8e-9c53-0e5f1a4e2b60"
+	statusUUID  = "6f3c0004-7a1e-4b8e-9c53-0e5f1a4e2b60"
+
+	powerUnknown = 0xFF
+	maxSteps     = 6 // a full min-to-max sweep; keeps a write well inside the 30s ATT timeout
+)
+
+// Standard GATT attribute types.
+var (
+	uuidPrimaryService = uuid16(0x2800)
+	uuidCharacteristic = uuid16(0x2803)
+	uuidCCCD           = uuid16(0x2902)
+	uuidGAPService     = uuid16(0x1800)
+	uuidDeviceName     = uuid16(0x2A00)
+)
+
+// Characteristic properties.
+const (
+	propRead   = 0x02
+	propWrite  = 0x08
+	propNotify = 0x10
+)
+
+// attribute is one entry of the GATT database.
+type attribute struct {
+	handle   uint16
+	typ      []byte // UUID, little-endian as sent on the air
+	value    []byte // static value, unless read is set
+	read     func() []byte
+	write    func(v []byte) byte // returns an ATT error code, 0 on success
+	groupEnd uint16              // last handle of a service declaration's group
+	cccdFor  uint16              // value handle whose notifications this CCCD controls
+}
+
+// Server is the GATT service and its advertising.
+type Server struct {
+	actions *actions.Runner
+	cfg     config.GATT
+	attrs   []*attribute
+
+	mu     sync.Mutex
+	power  byte
+	status string
+	conn   *conn // the connected central, if any
+
+	powerHandle, statusHandle uint16
+}
+
+// Start begins advertising the service on the configured adapter and serving connections.
+func Start(cfg config.GATT, runner *actions.Runner) error {
+	s := &Server{actions: runner, cfg: cfg, power: powerUnknown}
+	s.build()
+	hciFD, err := hci.Open(cfg.Adapter)
+	if err != nil {
+		return fmt.Errorf("gatt: hci%d: %v", cfg.Adapter, err)
+	}
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, unix.BTPROTO_L2CAP)
+	if err != nil {
+		return fmt.Errorf("gatt: %v", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrL2{CID: attCID, AddrType: bdaddrLEPublic}); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("gatt: binding ATT channel (is bluetoothd serving GATT?): %v", err)
+	}
+	if cfg.RequireEncryption {
+		// struct bt_security { level; key_size }; the kernel pairs (Just Works) before ATT traffic
+		if err := unix.SetsockoptString(fd, solBluetooth, btSecurity, string([]byte{btSecurityMedium, 0})); err != nil {
+			unix.Close(fd)
+			return fmt.Errorf("gatt: %v", err)
+		}
+	}
+	if err := unix.Listen(fd, 1); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("gatt: %v", err)
+	}
+	if err := s.advertise(hciFD); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("gatt: advertising: %v", err)
+	}
+	fault.Go("gatt", s.watchEvents)
+	fault.Go("gatt", func() { s.accept(fd, hciFD) })
+	return nil
+}
+
+func (s *Server) build() {
+	var h uint16
+	add := func(a *attribute) *attribute {
+		h++
+		a.handle = h
+		s.attrs = append(s.attrs, a)
+		return a
+	}
+	characteristic := func(props byte, uuid []byte, a *attribute) *attribute {
+		decl := make([]byte, 3, 3+len(uuid))
+		decl[0] = props
+		binary.LittleEndian.PutUint16(decl[1:], h+2)
+		add(&attribute{typ: uuidCharacteristic, value: append(decl, uuid...)})
+		a.typ = uuid
+		return add(a)
+	}
+
+	gap := add(&attribute{typ: uuidPrimaryService, value: uuidGAPService})
+	characteristic(propRead, uuidDeviceName, &attribute{value: []byte(s.cfg.Name)})
+	gap.groupEnd = h
+
+	svc := add(&attribute{typ: uuidPrimaryService, value: uuid128(serviceUUID)})
+	power := characteristic(propRead|propWrite|propNotify, uuid128(powerUUID), &attribute{
+		read:  func() []byte { s.mu.Lock(); defer s.mu.Unlock(); return []byte{s.power} },
+		write: s.writePower,
+	})
+	add(&attribute{typ: uuidCCCD, cccdFor: power.handle})
+	characteristic(propWrite, uuid128(levelUUID), &attribute{write: s.writeLevel})
+	status := characteristic(propRead|propNotify, uuid128(statusUUID), &attribute{
+		read: func() []byte { s.mu.Lock(); defer s.mu.Unlock(); return []byte(s.status) },
+	})
+	add(&attribute{typ: uuidCCCD, cccdFor: status.handle})
+	svc.groupEnd = h
+
+	s.powerHandle, s.statusHandle = power.handle, status.handle
+}
+
+func (s *Server) writePower(v []byte) byte {
+	if len(v) != 1 || v[0] > 1 {
+		return errInvalidLength
+	}
+	if v[0] == 1 {
+		return s.run("on")
+	}
+	return s.run("off")
+}
+
+func (s *Server) writeLevel(v []byte) byte {
+	if len(v) != 1 {
+		return errInvalidLength
+	}
+	steps := int(int8(v[0]))
+	action := "flameup"
+	if steps < 0 {
+		steps, action = -steps, "flamedown"
+	}
+	if steps > maxSteps {
+		steps = maxSteps
+	}
+	for i := 0; i < steps; i++ {
+		if code := s.run(action); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// run performs an action and maps failures to an ATT error code.
+func (s *Server) run(action string) byte {
+	switch s.actions.Run(action, "ble") {
+	case "ok":
+		return 0
+	case "busy":
+		return errBusy
+	case "lockout":
+		return errLockout
+	}
+	return errUnlikely
+}
+
+// watchEvents tracks power and status from commands arriving on any interface and
+// notifies the connected central.
+func (s *Server) watchEvents() {
+	ch, _ := events.Subscribe()
+	for e := range ch {
+		s.mu.Lock()
+		s.status = e.Op + "_" + e.Result
+		powerChanged := false
+		if e.Result == "ok" && (e.Op == "on" || e.Op == "off") {
+			p := byte(0)
+			if e.Op == "on" {
+				p = 1
+			}
+			powerChanged, s.power = p != s.power, p
+		}
+		c, power, status := s.conn, s.power, s.status
+		s.mu.Unlock()
+		if c == nil {
+			continue
+		}
+		if powerChanged {
+			c.notify(s.powerHandle, []byte{power})
+		}
+		c.notify(s.statusHandle, []byte(status))
+	}
+}
+
+// accept serves one central at a time, advertising again once it disconnects.
+func (s *Server) accept(fd, hciFD int) {
+	for {
+		nfd, _, err := unix.Accept(fd)
+		if err != nil {
+			logging.Logf(logging.Err, "gatt: accept: %v", err)
+			return
+		}
+		c := &conn{fd: nfd, mtu: defaultMTU, notifying: map[uint16]bool{}}
+		s.mu.Lock()
+		s.conn = c
+		s.mu.Unlock()
+		logging.Logf(logging.Info, "gatt: central connected")
+		s.serve(c)
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+		unix.Close(nfd)
+		logging.Logf(logging.Info, "gatt: central disconnected")
+		// the controller stops advertising when a connection is made
+		if err := hci.Command(hciFD, hci.OGFLE, 0x000A, []byte{0x01}); err != nil {
+			logging.Logf(logging.Err, "gatt: re-enabling advertising: %v", err)
+		}
+	}
+}
+
+// advertise sets the advertising and scan response data and enables connectable advertising.
+func (s *Server) advertise(fd int) error {
+	// LE Set Advertising Parameters: 100ms interval, ADV_IND, public address, all channels
+	params := []byte{0xA0, 0x00, 0xA0, 0x00, 0x00, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0x07, 0x00}
+	if err := hci.Command(fd, hci.OGFLE, 0x0006, params); err != nil {
+		return err
+	}
+	// flags (LE general discoverable, BR/EDR not supported) and the complete 128-bit service list
+	adv := append([]byte{0x02, 0x01, 0x06, 0x11, 0x07}, uuid128(serviceUUID)...)
+	if err := hci.Command(fd, hci.OGFLE, 0x0008, padAdvData(adv)); err != nil {
+		return err
+	}
+	name := s.cfg.Name
+	if len(name) > 29 {
+		name = name[:29]
+	}
+	scanRsp := append([]byte{byte(len(name) + 1), 0x09}, name...)
+	if err := hci.Command(fd, hci.OGFLE, 0x0009, padAdvData(scanRsp)); err != nil {
+		return err
+	}
+	return hci.Command(fd, hci.OGFLE, 0x000A, []byte{0x01})
+}
+
+// padAdvData formats advertising or scan response data as the command parameters: a
+// length byte followed by 31 data bytes.
+func padAdvData(d []byte) []byte {
+	p := make([]byte, 32)
+	p[0] = byte(len(d))
+	copy(p[1:], d)
+	return p
+}
+
+func uuid16(u uint16) []byte {
+	return []byte{byte(u), byte(u >> 8)}
+}
+
+// uuid128 converts a UUID string to its little-endian on-air form.
+func uuid128(s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil || len(b) != 16 {
+		panic("gatt: bad uuid " + s)
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
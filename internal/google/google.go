@@ -0,0 +1,233 @@
+// Package google answers Google Home's smart home fulfillment, so "Hey Google, turn on the
+// fireplace" works. The fireplace is one device of type FIREPLACE with the OnOff trait and
+// the Modes trait: its one mode, flame, has a setting for each flame level (1 to the
+// valve's levels, with low and high as synonyms for the ends).
+//
+// Google posts the SYNC, QUERY, EXECUTE and DISCONNECT intents to the fulfillment URL of a
+// smart home Action with the access token from account linking as a bearer token; GoFire
+// takes that to be one of its own tokens, with the google scope. Bridges that speak the
+// same intents can use it too. With lockout.pin set, turning the fire on asks for the PIN.
+package google
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// source is what Google commands are recorded as.
+const source = "google"
+
+// deviceID is the ID of the one device served.
+const deviceID = "fireplace"
+
+// Fulfillment answers smart home intents.
+type Fulfillment struct {
+	cfg    config.Google
+	runner *actions.Runner
+	power  *power.Tracker
+	flame  *flame.Control // nil unless the driver sets the flame with timed pulses
+	pin    string         // ignition PIN; empty when none is needed
+}
+
+// New returns the fulfillment for the fireplace. fc may be nil; pin is lockout.pin.
+func New(cfg config.Google, runner *actions.Runner, pw *power.Tracker, fc *flame.Control, pin string) *Fulfillment {
+	return &Fulfillment{cfg: cfg, runner: runner, power: pw, flame: fc, pin: pin}
+}
+
+type request struct {
+	RequestID string `json:"requestId"`
+	Inputs    []struct {
+		Intent  string `json:"intent"`
+		Payload struct {
+			Commands []struct {
+				Devices []struct {
+					ID string `json:"id"`
+				} `json:"devices"`
+				Execution []execution `json:"execution"`
+			} `json:"commands"`
+		} `json:"payload"`
+	} `json:"inputs"`
+}
+
+type execution struct {
+	Command string `json:"command"`
+	Params  struct {
+		On                 *bool             `json:"on"`
+		UpdateModeSettings map[string]string `json:"updateModeSettings"`
+	} `json:"params"`
+	Challenge struct {
+		PIN string `json:"pin"`
+	} `json:"challenge"`
+}
+
+// commandResult is one entry of an EXECUTE response.
+type commandResult struct {
+	IDs             []string               `json:"ids"`
+	Status          string                 `json:"status"` // SUCCESS or ERROR
+	States          map[string]interface{} `json:"states,omitempty"`
+	ErrorCode       string                 `json:"errorCode,omitempty"`
+	ChallengeNeeded map[string]string      `json:"challengeNeeded,omitempty"`
+}
+
+// ServeHTTP answers one fulfillment request.
+func (f *Fulfillment) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "google_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil || len(req.Inputs) == 0 {
+		http.Error(w, "google_badrequest", http.StatusBadRequest)
+		return
+	}
+	in := req.Inputs[0]
+	var payload interface{}
+	switch in.Intent {
+	case "action.devices.SYNC":
+		payload = f.sync()
+	case "action.devices.QUERY":
+		payload = map[string]interface{}{"devices": map[string]interface{}{deviceID: f.query()}}
+	case "action.devices.EXECUTE":
+		var results []commandResult
+		for _, c := range in.Payload.Commands {
+			for _, d := range c.Devices {
+				if d.ID != deviceID {
+					results = append(results, commandResult{IDs: []string{d.ID}, Status: "ERROR", ErrorCode: "deviceNotFound"})
+				}
+			}
+			for _, e := range c.Execution {
+				results = append(results, f.execute(e))
+			}
+		}
+		payload = map[string]interface{}{"commands": results}
+	case "action.devices.DISCONNECT":
+		logging.Event(logging.Notice, "google: account unlinked")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	default:
+		payload = map[string]string{"errorCode": "notSupported"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"requestId": req.RequestID, "payload": payload})
+}
+
+func (f *Fulfillment) sync() map[string]interface{} {
+	var settings []map[string]interface{}
+	max := f.power.Levels().Max
+	for l := 1; l <= max; l++ {
+		synonyms := []string{strconv.Itoa(l), "level " + strconv.Itoa(l)}
+		switch l {
+		case 1:
+			synonyms = append(synonyms, "low")
+		case max:
+			synonyms = append(synonyms, "high")
+		}
+		settings = append(settings, map[string]interface{}{
+			"setting_name":   strconv.Itoa(l),
+			"setting_values": []map[string]interface{}{{"setting_synonym": synonyms, "lang": "en"}},
+		})
+	}
+	return map[string]interface{}{
+		"agentUserId": f.cfg.AgentUserID,
+		"devices": []map[string]interface{}{{
+			"id":              deviceID,
+			"type":            "action.devices.types.FIREPLACE",
+			"traits":          []string{"action.devices.traits.OnOff", "action.devices.traits.Modes"},
+			"name":            map[string]interface{}{"name": f.cfg.Name},
+			"willReportState": false,
+			"attributes": map[string]interface{}{
+				"availableModes": []map[string]interface{}{{
+					"name":        "flame",
+					"name_values": []map[string]interface{}{{"name_synonym": []string{"flame", "flame level", "flame height"}, "lang": "en"}},
+					"settings":    settings,
+					"ordered":     true,
+				}},
+			},
+			"deviceInfo": map[string]string{"manufacturer": "GoFire", "model": "GV60"},
+		}},
+	}
+}
+
+// query returns the device's state.
+func (f *Fulfillment) query() map[string]interface{} {
+	st := f.power.State()
+	q := map[string]interface{}{"online": true, "status": "SUCCESS", "on": st.Power == "on"}
+	if st.Power == "on" && st.FlameLevel != nil && *st.FlameLevel > 0 {
+		q["currentModeSettings"] = map[string]string{"flame": strconv.Itoa(*st.FlameLevel)}
+	}
+	return q
+}
+
+func (f *Fulfillment) execute(e execution) commandResult {
+	res := commandResult{IDs: []string{deviceID}}
+	var result string
+	// the states the command leaves, as the tracker only applies it in a moment
+	states := map[string]interface{}{"online": true}
+	switch {
+	case e.Command == "action.devices.commands.OnOff" && e.Params.On != nil:
+		op := "off"
+		if *e.Params.On {
+			op = "on"
+			if f.pin != "" && e.Challenge.PIN == "" {
+				res.Status, res.ErrorCode = "ERROR", "challengeNeeded"
+				res.ChallengeNeeded = map[string]string{"type": "pinNeeded"}
+				return res
+			}
+			if f.pin != "" && subtle.ConstantTimeCompare([]byte(e.Challenge.PIN), []byte(f.pin)) != 1 {
+				logging.Event(logging.Warning, "ignition refused: wrong pin", "from", source)
+				res.Status, res.ErrorCode = "ERROR", "challengeNeeded"
+				res.ChallengeNeeded = map[string]string{"type": "challengeFailedPinNeeded"}
+				return res
+			}
+		}
+		result = f.runner.Run(op, source)
+		states["on"] = op == "on"
+	case e.Command == "action.devices.commands.SetModes" && e.Params.UpdateModeSettings["flame"] != "":
+		level, err := strconv.Atoi(e.Params.UpdateModeSettings["flame"])
+		if err != nil || level < 1 || level > f.power.Levels().Max {
+			res.Status, res.ErrorCode = "ERROR", "valueOutOfRange"
+			return res
+		}
+		if f.flame != nil {
+			result = f.flame.Set(level, source)
+		} else {
+			result = f.runner.SetFlame(f.power, level, source)
+		}
+		states["on"] = true
+		states["currentModeSettings"] = map[string]string{"flame": strconv.Itoa(level)}
+	default:
+		res.Status, res.ErrorCode = "ERROR", "functionNotSupported"
+		return res
+	}
+	if result != "ok" {
+		res.Status, res.ErrorCode = "ERROR", errorCode(result)
+		return res
+	}
+	res.Status, res.States = "SUCCESS", states
+	return res
+}
+
+// errorCode maps a command result to a Google error code.
+func errorCode(result string) string {
+	switch result {
+	case "busy", "queue_full":
+		return "deviceBusy"
+	case "unlit":
+		return "deviceTurnedOff"
+	case "lockout", "overridden", "fault":
+		return "actionNotAvailable"
+	case "unsupported":
+		return "functionNotSupported"
+	}
+	return "hardError"
+}
@@ -0,0 +1,167 @@
+// Package grpcwire reads and writes gRPC messages and the protocol buffer encoding of the
+// few simple messages GoFire's gRPC service uses, so that it can be served by net/http
+// without the grpc and protobuf modules. Only what the service needs is supported:
+// varint, string and embedded message fields, uncompressed frames.
+package grpcwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Status codes, from google.golang.org/grpc/codes.
+const (
+	OK                 = 0
+	InvalidArgument    = 3
+	NotFound           = 5
+	PermissionDenied   = 7
+	FailedPrecondition = 9
+	Unimplemented      = 12
+	Internal           = 13
+	Unauthenticated    = 16
+)
+
+// ErrTooLarge is returned for a message longer than the limit given to ReadMessage.
+var ErrTooLarge = errors.New("grpc: message too large")
+
+// ReadMessage reads one length-prefixed message of at most max bytes.
+func ReadMessage(r io.Reader, max int) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("grpc: compressed messages aren't supported")
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > uint32(max) {
+		return nil, ErrTooLarge
+	}
+	msg := make([]byte, n)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+// WriteMessage writes msg, length-prefixed and uncompressed.
+func WriteMessage(w io.Writer, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	_, err := w.Write(append(frame, msg...))
+	return err
+}
+
+// SetStatus sets the grpc-status and grpc-message trailers of a response whose header
+// announced them with Trailer: Grpc-Status, Grpc-Message.
+func SetStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(message))
+	}
+}
+
+// Wire types.
+const (
+	varint  = 0
+	fixed64 = 1
+	bytes   = 2
+	fixed32 = 5
+)
+
+// AppendVarint appends field number field with the value v.
+func AppendVarint(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3|varint)
+	return appendUvarint(b, v)
+}
+
+// AppendInt appends an int32 or int64 field; zero, the default, is left out.
+func AppendInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return AppendVarint(b, field, uint64(v))
+}
+
+// AppendBool appends a bool field; false, the default, is left out.
+func AppendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return AppendVarint(b, field, 1)
+}
+
+// AppendBytes appends a string, bytes or embedded message field, even when empty.
+func AppendBytes(b []byte, field int, v []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|bytes)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendString appends a string field; the empty string, the default, is left out.
+func AppendString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return AppendBytes(b, field, []byte(v))
+}
+
+// Field is one field of a decoded message: Varint holds varint fields, Bytes length-delimited
+// ones. Fixed-width fields are skipped.
+type Field struct {
+	Number int
+	Varint uint64
+	Bytes  []byte
+}
+
+// Decode calls f with each varint and length-delimited field of msg, in order.
+func Decode(msg []byte, f func(Field) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("grpc: bad field key")
+		}
+		msg = msg[n:]
+		fd := Field{Number: int(key >> 3)}
+		switch key & 7 {
+		case varint:
+			if fd.Varint, n = binary.Uvarint(msg); n <= 0 {
+				return errors.New("grpc: bad varint")
+			}
+			msg = msg[n:]
+		case bytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || l > uint64(len(msg)-n) {
+				return errors.New("grpc: bad length")
+			}
+			fd.Bytes, msg = msg[n:n+int(l)], msg[n+int(l):]
+		case fixed64, fixed32:
+			size := 8
+			if key&7 == fixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return errors.New("grpc: truncated field")
+			}
+			msg = msg[size:]
+			continue
+		default:
+			return fmt.Errorf("grpc: unsupported wire type %d", key&7)
+		}
+		if err := f(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
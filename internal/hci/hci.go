@@ -0,0 +1,36 @@
+// Package hci talks to a Bluetooth controller through a raw HCI socket, for the BLE
+// scanner and GATT service that run without the BlueZ daemon.
+package hci
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const (
+	CommandPkt = 0x01
+	EventPkt   = 0x04
+
+	// OGFLE is the opcode group of the LE controller commands.
+	OGFLE = 0x08
+)
+
+// Open returns a raw HCI socket bound to hciN.
+func Open(dev int) (int, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.BTPROTO_HCI)
+	if err != nil {
+		return -1, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrHCI{Dev: uint16(dev), Channel: unix.HCI_CHANNEL_RAW}); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// Command sends an HCI command without waiting for its completion event.
+func Command(fd int, ogf, ocf uint16, params []byte) error {
+	opcode := ogf<<10 | ocf
+	pkt := append([]byte{CommandPkt, byte(opcode), byte(opcode >> 8), byte(len(params))}, params...)
+	_, err := unix.Write(fd, pkt)
+	return err
+}
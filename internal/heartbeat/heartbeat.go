@@ -0,0 +1,102 @@
+// Package heartbeat is a dead-man switch: while the fire burns, an external supervisor must
+// keep sending heartbeats, and if they stop the fire is turned off.
+package heartbeat
+
+import (
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// source is what the off command is recorded as.
+const source = "heartbeat"
+
+// checkEvery is how often the deadline is checked.
+const checkEvery = 10 * time.Second
+
+// Monitor turns the fire off when heartbeats stop.
+type Monitor struct {
+	cfg    config.Heartbeat
+	power  *power.Tracker
+	runner *actions.Runner
+
+	mu      sync.Mutex
+	last    time.Time
+	expired bool // heartbeats have stopped, and that has been logged
+}
+
+// State is the heartbeat state.
+type State struct {
+	Last     time.Time  `json:"last"`               // zero before the first heartbeat
+	Deadline *time.Time `json:"deadline,omitempty"` // while the fire is on
+}
+
+// Start begins watching for missed heartbeats.
+func Start(cfg config.Heartbeat, pw *power.Tracker, runner *actions.Runner) *Monitor {
+	m := &Monitor{cfg: cfg, power: pw, runner: runner}
+	fault.Go("heartbeat", m.watch)
+	return m
+}
+
+// Beat records a heartbeat.
+func (m *Monitor) Beat() {
+	m.mu.Lock()
+	m.last, m.expired = time.Now(), false
+	m.mu.Unlock()
+}
+
+// State returns the last heartbeat and, while the fire is on, when the next is due.
+func (m *Monitor) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := State{Last: m.last}
+	if d, ok := m.deadline(); ok {
+		s.Deadline = &d
+	}
+	return s
+}
+
+// deadline returns when the fire is turned off unless a heartbeat arrives, counting from
+// the later of the last heartbeat and the fire being lit; callers must hold mu.
+func (m *Monitor) deadline() (time.Time, bool) {
+	ps := m.power.State()
+	if ps.Power != "on" {
+		return time.Time{}, false
+	}
+	from := m.last
+	if ps.Since.After(from) {
+		from = ps.Since
+	}
+	return from.Add(m.cfg.Interval), true
+}
+
+func (m *Monitor) watch() {
+	for now := range time.Tick(checkEvery) {
+		m.mu.Lock()
+		d, on := m.deadline()
+		due := on && now.After(d)
+		if !on {
+			m.expired = false
+		}
+		m.mu.Unlock()
+		if !due {
+			continue
+		}
+		// retried every check until it succeeds, e.g. while the relays are busy
+		result := m.runner.Run("off", source)
+		m.mu.Lock()
+		if !m.expired {
+			logging.Event(logging.Err, "heartbeats stopped: turning the fire off",
+				"last", m.last.Format(time.RFC3339), "interval", m.cfg.Interval.String(), "result", result)
+			m.expired = true
+		} else if result != "ok" {
+			logging.Logf(logging.Warning, "heartbeat: retrying off: %s", result)
+		}
+		m.mu.Unlock()
+	}
+}
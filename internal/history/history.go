@@ -0,0 +1,239 @@
+// Package history records sensor readings on disk with a bounded retention policy.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/sensor"
+)
+
+// The sensor history store keeps append-only JSON lines files under one directory:
+//
+//	raw-2006-01-02.jsonl     every recorded reading for that day
+//	hourly-2006-01.jsonl     min/max/avg per sensor per hour for that month
+//
+// A background compaction job folds raw days older than the raw retention into hourly
+// aggregates and deletes whole files past their retention, so the store stays bounded and
+// the SD card only ever sees appends and unlinks.
+
+// Sample is one recorded reading.
+type Sample struct {
+	Time   time.Time `json:"t"`
+	Sensor string    `json:"sensor"`
+	Value  float64   `json:"v"`
+}
+
+// Aggregate summarises one sensor over one hour.
+type Aggregate struct {
+	Time   time.Time `json:"t"` // start of the hour
+	Sensor string    `json:"sensor"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Avg    float64   `json:"avg"`
+	Count  int       `json:"n"`
+}
+
+// Store is the on-disk history of one sensor registry.
+type Store struct {
+	cfg     config.History
+	sensors *sensor.Registry
+}
+
+// record appends the current sensor readings to the store once per interval.
+func (s *Store) record() {
+	for range time.Tick(s.cfg.Interval) {
+		var samples []interface{}
+		for name, r := range s.sensors.Readings() {
+			if r.Error == "" {
+				samples = append(samples, Sample{Time: r.Time, Sensor: name, Value: r.Value})
+			}
+		}
+		if err := appendJSONLines(s.rawPath(time.Now()), samples); err != nil {
+			logging.Logf(logging.Err, "history: %v", err)
+		}
+	}
+}
+
+// compact runs the retention policy now and then every hour.
+func (s *Store) compact() {
+	for {
+		if err := s.compactOnce(time.Now()); err != nil {
+			logging.Logf(logging.Err, "history compaction: %v", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func (s *Store) compactOnce(now time.Time) error {
+	rawCutoff := now.Add(-s.cfg.RawRetention)
+	hourlyCutoff := now.Add(-s.cfg.HourlyRetention)
+	files, err := filepath.Glob(filepath.Join(s.cfg.Dir, "raw-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		day, err := time.ParseInLocation("2006-01-02", strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "raw-"), ".jsonl"), time.Local)
+		if err != nil || !day.AddDate(0, 0, 1).Before(rawCutoff) {
+			continue
+		}
+		if day.AddDate(0, 0, 1).After(hourlyCutoff) {
+			if err := s.downsample(f, day); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	files, err = filepath.Glob(filepath.Join(s.cfg.Dir, "hourly-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		month, err := time.ParseInLocation("2006-01", strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "hourly-"), ".jsonl"), time.Local)
+		if err != nil || !month.AddDate(0, 1, 0).Before(hourlyCutoff) {
+			continue
+		}
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downsample folds one raw day file into hourly aggregates.
+func (s *Store) downsample(path string, day time.Time) error {
+	type key struct {
+		hour   time.Time
+		sensor string
+	}
+	aggs := map[key]*Aggregate{}
+	err := readJSONLines(path, func(dec *json.Decoder) error {
+		var sample Sample
+		if err := dec.Decode(&sample); err != nil {
+			return err
+		}
+		k := key{sample.Time.Truncate(time.Hour), sample.Sensor}
+		a := aggs[k]
+		if a == nil {
+			a = &Aggregate{Time: k.hour, Sensor: sample.Sensor, Min: sample.Value, Max: sample.Value}
+			aggs[k] = a
+		}
+		if sample.Value < a.Min {
+			a.Min = sample.Value
+		}
+		if sample.Value > a.Max {
+			a.Max = sample.Value
+		}
+		a.Avg += sample.Value // summed here, divided below
+		a.Count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var sorted []*Aggregate
+	for _, a := range aggs {
+		a.Avg /= float64(a.Count)
+		sorted = append(sorted, a)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	out := make([]interface{}, len(sorted))
+	for i, a := range sorted {
+		out[i] = a
+	}
+	return appendJSONLines(filepath.Join(s.cfg.Dir, "hourly-"+day.Format("2006-01")+".jsonl"), out)
+}
+
+func (s *Store) rawPath(t time.Time) string {
+	return filepath.Join(s.cfg.Dir, "raw-"+t.Format("2006-01-02")+".jsonl")
+}
+
+func appendJSONLines(path string, values []interface{}) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, v := range values {
+		enc.Encode(v)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readJSONLines(path string, decode func(*json.Decoder) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		if err := decode(dec); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// Query returns the raw samples recorded since from, for one sensor or all when sensor is "".
+func (s *Store) Query(sensor string, from time.Time) ([]Sample, error) {
+	samples := []Sample{}
+	y, m, d := from.Date()
+	for day := time.Date(y, m, d, 0, 0, 0, 0, time.Local); !day.After(time.Now()); day = day.AddDate(0, 0, 1) {
+		path := s.rawPath(day)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		err := readJSONLines(path, func(dec *json.Decoder) error {
+			var sample Sample
+			if err := dec.Decode(&sample); err != nil {
+				return err
+			}
+			if (sensor == "" || sample.Sensor == sensor) && sample.Time.After(from) {
+				samples = append(samples, sample)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return samples, nil
+}
+
+// Start begins recording and compacting the registry's readings. It returns nil if no
+// history directory is configured.
+func Start(cfg config.History, sensors *sensor.Registry) (*Store, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	// fail early if the directory isn't writable rather than logging every interval
+	if err := ioutil.WriteFile(filepath.Join(cfg.Dir, ".probe"), nil, 0644); err != nil {
+		return nil, err
+	}
+	os.Remove(filepath.Join(cfg.Dir, ".probe"))
+	s := &Store{cfg: cfg, sensors: sensors}
+	fault.Go("history", s.record)
+	fault.Go("history", s.compact)
+	return s, nil
+}
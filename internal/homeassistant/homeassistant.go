@@ -0,0 +1,297 @@
+// Package homeassistant publishes the fireplace over MQTT, with Home Assistant MQTT
+// Discovery so that it shows up there without any YAML. Under the topic prefix (gofire):
+//
+//	gofire/availability        online, or offline (the broker's last will)
+//	gofire/state               {"power": "on", "flame_level": 4, "light": 80, "accessories": {"fan": "on"}, ...}
+//	gofire/sensor/NAME         each sensor's latest value
+//	gofire/power/set           ON or OFF
+//	gofire/flame/set           a flame level, reached with flame up or down steps
+//	gofire/light/set           ON or OFF
+//	gofire/accessory/NAME/set  ON, OFF or TOGGLE
+//	gofire/preset/set          a preset's name, applying it
+//	gofire/command             any action name (flameup, flamedown, aux, light_toggle, ...)
+//
+// Discovery announces a switch for power, a number for the flame level, buttons for flame up
+// and down, a light when one is configured, a switch for each accessory, a scene for each
+// preset (announced again as presets are saved and removed) and a sensor for each sensor
+// reading.
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/accessory"
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/light"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/mqtt"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/presets"
+	"github.com/barrylb/go-fire/internal/sensor"
+)
+
+// source is what MQTT commands are recorded as.
+const source = "mqtt"
+
+// publishEvery is how often state and sensor readings are published besides on each command.
+const publishEvery = time.Minute
+
+// Device is the fireplace on MQTT.
+type Device struct {
+	cfg     config.MQTTDevice
+	client  *mqtt.Client
+	runner  *actions.Runner
+	power   *power.Tracker
+	light   *light.Controller
+	acc     *accessory.Bank
+	sensors *sensor.Registry
+	presets *presets.Store
+	node    string // Home Assistant node ID
+
+	mu     sync.Mutex
+	scenes map[string]bool // the presets announced, so that removed ones can be withdrawn
+}
+
+// state is what is published on the state topic.
+type state struct {
+	power.State
+	Light       *int              `json:"light,omitempty"`
+	Accessories map[string]string `json:"accessories,omitempty"`
+}
+
+// Start subscribes to the command topics and starts publishing state. lc, acc and ps may be
+// nil.
+func Start(cfg config.MQTTDevice, client *mqtt.Client, runner *actions.Runner, pw *power.Tracker, lc *light.Controller,
+	acc *accessory.Bank, sensors *sensor.Registry, ps *presets.Store) {
+	d := &Device{cfg: cfg, client: client, runner: runner, power: pw, light: lc, acc: acc, sensors: sensors,
+		presets: ps, node: strings.NewReplacer("/", "_", " ", "_", "#", "_", "+", "_").Replace(cfg.TopicPrefix),
+		scenes: map[string]bool{}}
+	p := cfg.TopicPrefix
+	client.Subscribe(p+"/power/set", func(_ string, payload []byte) {
+		switch strings.ToUpper(strings.TrimSpace(string(payload))) {
+		case "ON":
+			runner.Run("on", source)
+		case "OFF":
+			runner.Run("off", source)
+		}
+	})
+	client.Subscribe(p+"/flame/set", func(_ string, payload []byte) {
+		level, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+		if err != nil {
+			logging.Logf(logging.Debug, "homeassistant: flame/set: %v", err)
+			return
+		}
+		if d.runner.SetFlame(d.power, int(math.Round(level)), source) == "unlit" {
+			logging.Logf(logging.Info, "homeassistant: flame level ignored while the fire isn't lit at a known level")
+		}
+	})
+	client.Subscribe(p+"/light/set", func(_ string, payload []byte) {
+		switch strings.ToUpper(strings.TrimSpace(string(payload))) {
+		case "ON":
+			runner.Run("light_on", source)
+		case "OFF":
+			runner.Run("light_off", source)
+		}
+	})
+	if acc != nil {
+		for _, name := range acc.Names() {
+			c := acc.Get(name)
+			client.Subscribe(p+"/accessory/"+name+"/set", func(_ string, payload []byte) {
+				state := strings.ToLower(strings.TrimSpace(string(payload)))
+				if _, err := c.Set(state, source); err != nil {
+					logging.Logf(logging.Debug, "homeassistant: accessory/%s/set: %v", c.Name(), err)
+				}
+			})
+		}
+	}
+	if ps != nil {
+		client.Subscribe(p+"/preset/set", func(_ string, payload []byte) {
+			name := strings.TrimSpace(string(payload))
+			if _, err := ps.Apply(context.Background(), name, source); err != nil {
+				logging.Logf(logging.Debug, "homeassistant: preset/set: %v: %q", err, name)
+			}
+		})
+	}
+	client.Subscribe(p+"/command", func(_ string, payload []byte) {
+		action := strings.TrimSpace(string(payload))
+		if !actions.Valid(action) {
+			logging.Logf(logging.Debug, "homeassistant: unknown action %q", action)
+			return
+		}
+		runner.Run(action, source)
+	})
+	if cfg.DiscoveryPrefix != "-" && ps != nil {
+		ps.OnChange(d.discoverScenes)
+	}
+	if cfg.DiscoveryPrefix != "-" {
+		// Home Assistant announces itself online after a restart; discovery must be resent
+		client.Subscribe(cfg.DiscoveryPrefix+"/status", func(_ string, payload []byte) {
+			if string(payload) == "online" {
+				d.discover()
+			}
+		})
+	}
+	client.OnConnect(func() {
+		client.Publish(mqtt.AvailabilityTopic(cfg), true, []byte("online"))
+		if cfg.DiscoveryPrefix != "-" {
+			d.discover()
+		}
+		d.publish()
+	})
+	ch, _ := events.Subscribe()
+	fault.Go("homeassistant", func() {
+		tick := time.NewTicker(publishEvery)
+		for {
+			select {
+			case <-ch:
+				// give the tracker, subscribed alongside, a moment to apply the command
+				time.Sleep(100 * time.Millisecond)
+			case <-tick.C:
+			}
+			d.publish()
+		}
+	})
+}
+
+// publish sends the state and the sensor readings.
+func (d *Device) publish() {
+	st := state{State: d.power.State()}
+	if d.light != nil {
+		l := d.light.Level()
+		st.Light = &l
+	}
+	if d.acc != nil {
+		st.Accessories = d.acc.State()
+	}
+	data, _ := json.Marshal(st)
+	d.client.Publish(d.cfg.TopicPrefix+"/state", true, data)
+	for name, r := range d.sensors.Readings() {
+		if r.Error == "" {
+			d.client.Publish(d.cfg.TopicPrefix+"/sensor/"+name, false, []byte(strconv.FormatFloat(r.Value, 'f', -1, 64)))
+		}
+	}
+}
+
+// discover sends the retained Home Assistant discovery configuration of every entity.
+func (d *Device) discover() {
+	p := d.cfg.TopicPrefix
+	common := d.common
+	sw := common("Power", "power")
+	sw["command_topic"] = p + "/power/set"
+	sw["state_topic"] = p + "/state"
+	sw["value_template"] = "{{ 'ON' if value_json.power == 'on' else 'OFF' }}"
+	sw["icon"] = "mdi:fireplace"
+	d.announce("switch", "power", sw)
+
+	num := common("Flame level", "flame")
+	num["command_topic"] = p + "/flame/set"
+	num["state_topic"] = p + "/state"
+	num["value_template"] = "{{ value_json.flame_level | default(0) }}"
+	num["min"], num["max"], num["step"] = 1, d.power.Levels().Max, 1
+	num["icon"] = "mdi:fire"
+	d.announce("number", "flame", num)
+
+	for _, b := range []struct{ id, name string }{{"flameup", "Flame up"}, {"flamedown", "Flame down"}} {
+		btn := common(b.name, b.id)
+		btn["command_topic"] = p + "/command"
+		btn["payload_press"] = b.id
+		d.announce("button", b.id, btn)
+	}
+	if d.light != nil {
+		l := common("Ember light", "light")
+		l["command_topic"] = p + "/light/set"
+		l["state_topic"] = p + "/state"
+		l["state_value_template"] = "{{ 'ON' if value_json.light | default(0) > 0 else 'OFF' }}"
+		d.announce("light", "light", l)
+	}
+	if d.acc != nil {
+		for _, name := range d.acc.Names() {
+			id := "accessory_" + name
+			a := common(name, id)
+			a["command_topic"] = p + "/accessory/" + name + "/set"
+			a["state_topic"] = p + "/state"
+			a["value_template"] = "{{ 'ON' if value_json.accessories['" + name + "'] == 'on' else 'OFF' }}"
+			d.announce("switch", id, a)
+		}
+	}
+	for name, r := range d.sensors.Readings() {
+		id := "sensor_" + strings.NewReplacer(" ", "_", "/", "_").Replace(name)
+		s := common(name, id)
+		s["state_topic"] = p + "/sensor/" + name
+		s["unit_of_measurement"] = r.Unit
+		if r.Unit == "C" || r.Unit == "F" {
+			s["unit_of_measurement"] = "°" + r.Unit
+			s["device_class"] = "temperature"
+		}
+		s["state_class"] = "measurement"
+		d.announce("sensor", id, s)
+	}
+	d.discoverScenes()
+}
+
+// discoverScenes announces a scene for each preset, and withdraws those of presets removed
+// since they were announced.
+func (d *Device) discoverScenes() {
+	if d.presets == nil {
+		return
+	}
+	p := d.cfg.TopicPrefix
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	announced := d.scenes
+	d.scenes = map[string]bool{}
+	for _, preset := range d.presets.List() {
+		id := "preset_" + preset.Name
+		sc := d.common(preset.Name, id)
+		sc["command_topic"] = p + "/preset/set"
+		sc["payload_on"] = preset.Name
+		sc["icon"] = "mdi:fireplace"
+		d.announce("scene", id, sc)
+		d.scenes[id] = true
+		delete(announced, id)
+	}
+	for id := range announced {
+		d.client.Publish(d.topic("scene", id), true, nil)
+	}
+}
+
+// common returns the discovery configuration every entity has, for the entity id called name.
+func (d *Device) common(name, id string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":               name,
+		"unique_id":          d.node + "_" + id,
+		"object_id":          d.node + "_" + id,
+		"availability_topic": d.cfg.TopicPrefix + "/availability",
+		"device": map[string]interface{}{
+			"identifiers":  []string{d.node},
+			"name":         d.cfg.Name,
+			"manufacturer": "Mertik Maxitrol",
+			"model":        "GV60 (GoFire)",
+		},
+	}
+}
+
+func (d *Device) announce(component, id string, cfg map[string]interface{}) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		logging.Logf(logging.Warning, "homeassistant: %v", err)
+		return
+	}
+	d.client.Publish(d.topic(component, id), true, data)
+}
+
+// topic is the discovery topic of the entity id; an empty retained message on it removes the
+// entity.
+func (d *Device) topic(component, id string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", d.cfg.DiscoveryPrefix, component, d.node, id)
+}
@@ -0,0 +1,226 @@
+package homekit
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"math/bits"
+)
+
+// HAP's session and pairing crypto is ChaCha20-Poly1305 (RFC 8439), X25519 (RFC 7748) and
+// HKDF-SHA-512. None of them is in the standard library this module builds against, so they
+// are implemented here; speed doesn't matter for a handful of short messages a minute.
+
+var errAuth = errors.New("homekit: message authentication failed")
+
+// chachaBlock returns the ChaCha20 keystream block for counter and nonce.
+func chachaBlock(key []byte, counter uint32, nonce []byte) [64]byte {
+	var s, x [16]uint32
+	s[0], s[1], s[2], s[3] = 0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	for i := 0; i < 8; i++ {
+		s[4+i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	s[12] = counter
+	for i := 0; i < 3; i++ {
+		s[13+i] = binary.LittleEndian.Uint32(nonce[4*i:])
+	}
+	x = s
+	qr := func(a, b, c, d int) {
+		x[a] += x[b]
+		x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+		x[c] += x[d]
+		x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+		x[a] += x[b]
+		x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+		x[c] += x[d]
+		x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+	}
+	for i := 0; i < 10; i++ {
+		qr(0, 4, 8, 12)
+		qr(1, 5, 9, 13)
+		qr(2, 6, 10, 14)
+		qr(3, 7, 11, 15)
+		qr(0, 5, 10, 15)
+		qr(1, 6, 11, 12)
+		qr(2, 7, 8, 13)
+		qr(3, 4, 9, 14)
+	}
+	var out [64]byte
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[4*i:], x[i]+s[i])
+	}
+	return out
+}
+
+// chachaXOR encrypts or decrypts src with the keystream starting at block counter 1.
+func chachaXOR(key, nonce, src []byte) []byte {
+	dst := make([]byte, len(src))
+	for i := 0; i < len(src); i += 64 {
+		block := chachaBlock(key, uint32(i/64+1), nonce)
+		for j := i; j < len(src) && j < i+64; j++ {
+			dst[j] = src[j] ^ block[j-i]
+		}
+	}
+	return dst
+}
+
+var poly1305P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 130), big.NewInt(5))
+
+// leInt reads a little-endian integer.
+func leInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// putLE writes n as a little-endian integer into b, truncating it to len(b) bytes.
+func putLE(b []byte, n *big.Int) {
+	be := n.Bytes()
+	for i := range b {
+		b[i] = 0
+		if i < len(be) {
+			b[i] = be[len(be)-1-i]
+		}
+	}
+}
+
+// poly1305 returns the one-time authenticator of msg under key.
+func poly1305(key, msg []byte) []byte {
+	r := make([]byte, 16)
+	copy(r, key[:16])
+	r[3] &= 15
+	r[7] &= 15
+	r[11] &= 15
+	r[15] &= 15
+	r[4] &= 252
+	r[8] &= 252
+	r[12] &= 252
+	rn, acc := leInt(r), new(big.Int)
+	for i := 0; i < len(msg); i += 16 {
+		end := i + 16
+		if end > len(msg) {
+			end = len(msg)
+		}
+		block := append(append([]byte{}, msg[i:end]...), 1)
+		acc.Add(acc, leInt(block))
+		acc.Mul(acc, rn)
+		acc.Mod(acc, poly1305P)
+	}
+	acc.Add(acc, leInt(key[16:32]))
+	tag := make([]byte, 16)
+	putLE(tag, acc)
+	return tag
+}
+
+// macData lays out the AEAD construction's Poly1305 input.
+func macData(aad, ciphertext []byte) []byte {
+	pad := func(b []byte) []byte { return append(b, make([]byte, (16-len(b)%16)%16)...) }
+	m := pad(append([]byte{}, aad...))
+	m = pad(append(m, ciphertext...))
+	var lens [16]byte
+	binary.LittleEndian.PutUint64(lens[:8], uint64(len(aad)))
+	binary.LittleEndian.PutUint64(lens[8:], uint64(len(ciphertext)))
+	return append(m, lens[:]...)
+}
+
+// seal encrypts and authenticates plaintext with ChaCha20-Poly1305, appending the tag.
+func seal(key, nonce, plaintext, aad []byte) []byte {
+	otk := chachaBlock(key, 0, nonce)
+	ct := chachaXOR(key, nonce, plaintext)
+	return append(ct, poly1305(otk[:32], macData(aad, ct))...)
+}
+
+// open authenticates and decrypts the output of seal.
+func open(key, nonce, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < 16 {
+		return nil, errAuth
+	}
+	ct, tag := sealed[:len(sealed)-16], sealed[len(sealed)-16:]
+	otk := chachaBlock(key, 0, nonce)
+	if subtle.ConstantTimeCompare(tag, poly1305(otk[:32], macData(aad, ct))) != 1 {
+		return nil, errAuth
+	}
+	return chachaXOR(key, nonce, ct), nil
+}
+
+// msgNonce is the nonce of a pairing message, e.g. "PS-Msg05".
+func msgNonce(label string) []byte {
+	return append(make([]byte, 4), label...)
+}
+
+// counterNonce is the nonce of the n'th frame of a session.
+func counterNonce(n uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.LittleEndian.PutUint64(nonce[4:], n)
+	return nonce
+}
+
+// hkdf derives a 32-byte key with HKDF-SHA-512.
+func hkdf(secret []byte, salt, info string) []byte {
+	extract := hmac.New(sha512.New, []byte(salt))
+	extract.Write(secret)
+	expand := hmac.New(sha512.New, extract.Sum(nil))
+	expand.Write([]byte(info))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:32]
+}
+
+var (
+	x25519P   = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	x25519A24 = big.NewInt(121665)
+)
+
+// x25519 multiplies the curve point u by scalar, both 32 bytes little-endian.
+func x25519(scalar, u []byte) []byte {
+	k := append([]byte{}, scalar...)
+	k[0] &= 248
+	k[31] &= 127
+	k[31] |= 64
+	ub := append([]byte{}, u...)
+	ub[31] &= 127
+	p := x25519P
+	mod := func(n *big.Int) *big.Int { return n.Mod(n, p) }
+	x1 := leInt(ub)
+	x2, z2 := big.NewInt(1), big.NewInt(0)
+	x3, z3 := new(big.Int).Set(x1), big.NewInt(1)
+	swap := uint(0)
+	for t := 254; t >= 0; t-- {
+		bit := uint(k[t/8]>>(uint(t)%8)) & 1
+		if swap^bit == 1 {
+			x2, x3 = x3, x2
+			z2, z3 = z3, z2
+		}
+		swap = bit
+		a := mod(new(big.Int).Add(x2, z2))
+		aa := mod(new(big.Int).Mul(a, a))
+		b := mod(new(big.Int).Sub(x2, z2))
+		bb := mod(new(big.Int).Mul(b, b))
+		e := mod(new(big.Int).Sub(aa, bb))
+		c := mod(new(big.Int).Add(x3, z3))
+		d := mod(new(big.Int).Sub(x3, z3))
+		da := mod(new(big.Int).Mul(d, a))
+		cb := mod(new(big.Int).Mul(c, b))
+		x3 = mod(new(big.Int).Add(da, cb))
+		x3 = mod(x3.Mul(x3, x3))
+		z3 = mod(new(big.Int).Sub(da, cb))
+		z3 = mod(z3.Mul(z3, z3))
+		z3 = mod(z3.Mul(z3, x1))
+		x2 = mod(new(big.Int).Mul(aa, bb))
+		z2 = mod(new(big.Int).Mul(e, new(big.Int).Add(aa, mod(new(big.Int).Mul(x25519A24, e)))))
+	}
+	if swap == 1 {
+		x2, z2 = x3, z3
+	}
+	inv := new(big.Int).Exp(z2, new(big.Int).Sub(p, big.NewInt(2)), p)
+	out := make([]byte, 32)
+	putLE(out, mod(x2.Mul(x2, inv)))
+	return out
+}
+
+// x25519Base is the curve's base point, u = 9.
+var x25519Base = append([]byte{9}, make([]byte, 31)...)
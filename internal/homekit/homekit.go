@@ -0,0 +1,585 @@
+// Package homekit serves the fireplace as an Apple HomeKit accessory, so it shows up in the
+// Home app and Siri without a bridge such as Homebridge. The accessory is a light: on and
+// off light and turn off the fire, and its brightness is the flame level, reached with
+// flame up and down steps. Each of config accessories is a switch of the same accessory, as
+// is each preset at start, a momentary switch that applies it and turns itself back off.
+//
+// HAP (the HomeKit Accessory Protocol) is implemented here over IP: pair-setup with the
+// setup code over SRP, pair-verify and the encrypted sessions that follow, the accessory
+// and characteristic resources with events, and the accessory is announced as _hap._tcp
+// over mDNS (package mdns). The accessory's keys and paired controllers are kept in the
+// state file; deleting it unpairs the accessory.
+package homekit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/accessory"
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/mdns"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/presets"
+)
+
+// source is what HomeKit commands are recorded as.
+const source = "homekit"
+
+const (
+	contentJSON = "application/hap+json"
+	contentTLV  = "application/pairing+tlv8"
+
+	statusConnectionAuthRequired = 470
+)
+
+// HAP status codes of characteristic reads and writes.
+const (
+	hapOK                     = 0
+	hapInsufficientPrivileges = -70401
+	hapCommunicationFailure   = -70402
+	hapReadOnly               = -70404
+	hapWriteOnly              = -70405
+	hapNoNotification         = -70406
+	hapNotFound               = -70409
+	hapInvalidValue           = -70410
+)
+
+// aid is the ID of the one accessory served.
+const aid = 1
+
+// Instance IDs of the accessory's services and characteristics.
+const (
+	iidInfo = iota + 1
+	iidIdentify
+	iidManufacturer
+	iidModel
+	iidName
+	iidSerial
+	iidFirmware
+	iidProtocol
+	iidVersion
+	iidLight
+	iidOn
+	iidBrightness
+	iidLightName
+
+	// iidAccessories is the first of the accessories' switches, each with iidStride IDs:
+	// the service's, then its on and name characteristics'.
+	iidAccessories = 100
+	iidStride      = 10
+	// iidPresets is the first of the presets' switches, laid out as the accessories' are.
+	iidPresets = 500
+)
+
+// characteristic is one HAP characteristic; value is nil for write-only ones and set is nil
+// for read-only ones.
+type characteristic struct {
+	iid    int
+	typ    string // short form of an Apple-defined UUID
+	format string
+	meta   map[string]interface{} // unit, minValue, maxValue, minStep
+	value  func() interface{}
+	set    func(v json.RawMessage) int
+	events bool
+}
+
+type service struct {
+	iid     int
+	typ     string
+	primary bool
+	chars   []*characteristic
+}
+
+// Server is the HomeKit accessory.
+type Server struct {
+	cfg      config.HomeKit
+	store    *store
+	runner   *actions.Runner
+	power    *power.Tracker
+	acc      *accessory.Bank // nil without accessories
+	presets  *presets.Store  // nil without presets
+	services []*service
+	chars    map[int]*characteristic
+	watched  []*characteristic // those whose changes are sent as events
+	mdns     *mdns.Responder
+
+	mu         sync.Mutex
+	sessions   map[*session]bool
+	setup      *srpServer
+	setupBy    *session
+	setupTries int
+	notified   map[int]interface{} // last value sent in events
+}
+
+// Start serves the accessory on cfg.Address and announces it over mDNS. acc and ps may be nil.
+func Start(cfg config.HomeKit, version string, runner *actions.Runner, pw *power.Tracker, acc *accessory.Bank,
+	ps *presets.Store) error {
+	st, err := openStore(cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return fmt.Errorf("homekit: %v", err)
+	}
+	srv := &Server{cfg: cfg, store: st, runner: runner, power: pw, acc: acc, presets: ps,
+		sessions: map[*session]bool{}, notified: map[int]interface{}{}}
+	srv.build(version)
+	port := ln.Addr().(*net.TCPAddr).Port
+	host := "GoFire-" + strings.Replace(st.ID[9:], ":", "", -1)
+	if srv.mdns, err = mdns.Start("homekit", host, mdns.Service{Instance: cfg.Name, Type: "_hap._tcp", Port: port, TXT: srv.txt}); err != nil {
+		ln.Close()
+		return fmt.Errorf("homekit: mdns: %v", err)
+	}
+	if !st.paired() {
+		logging.Event(logging.Notice, "homekit: waiting to be paired", "name", cfg.Name, "id", st.ID)
+	}
+	fault.Go("homekit", srv.watchEvents)
+	fault.Go("homekit", func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				logging.Logf(logging.Err, "homekit: %v", err)
+				return
+			}
+			go srv.serve(conn)
+		}
+	})
+	return nil
+}
+
+// build lays out the accessory: information, protocol version, the fireplace as a light, a
+// switch for each of the accessories and one for each preset.
+func (srv *Server) build(version string) {
+	str := func(iid int, typ, v string) *characteristic {
+		return &characteristic{iid: iid, typ: typ, format: "string", value: func() interface{} { return v }}
+	}
+	// firmware revisions must be x[.y[.z]]; development builds report 0.0.1
+	firmware := strings.TrimPrefix(version, "v")
+	for _, part := range strings.Split(firmware, ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			firmware = "0.0.1"
+			break
+		}
+	}
+	srv.services = []*service{
+		{iid: iidInfo, typ: "3E", chars: []*characteristic{
+			{iid: iidIdentify, typ: "14", format: "bool", set: srv.identify},
+			str(iidManufacturer, "20", "GoFire"),
+			str(iidModel, "21", "GoFire"),
+			str(iidName, "23", srv.cfg.Name),
+			str(iidSerial, "30", srv.store.ID),
+			str(iidFirmware, "52", firmware),
+		}},
+		{iid: iidProtocol, typ: "A2", chars: []*characteristic{str(iidVersion, "37", "1.1.0")}},
+		{iid: iidLight, typ: "43", primary: true, chars: []*characteristic{
+			{iid: iidOn, typ: "25", format: "bool", value: srv.on, set: srv.setOn, events: true},
+			{iid: iidBrightness, typ: "8", format: "int", value: srv.brightness, set: srv.setBrightness, events: true,
+				meta: map[string]interface{}{"unit": "percentage", "minValue": 0, "maxValue": 100, "minStep": 1}},
+			str(iidLightName, "23", srv.cfg.Name),
+		}},
+	}
+	var switches []*characteristic
+	if srv.acc != nil {
+		for i, name := range srv.acc.Names() {
+			c, iid := srv.acc.Get(name), iidAccessories+i*iidStride
+			on := &characteristic{iid: iid + 1, typ: "25", format: "bool", events: true,
+				value: func() interface{} { return c.On() }, set: srv.setAccessory(c)}
+			srv.services = append(srv.services, &service{iid: iid, typ: "49", chars: []*characteristic{on, str(iid+2, "23", name)}})
+			switches = append(switches, on)
+		}
+	}
+	if srv.presets != nil {
+		for i, p := range srv.presets.List() {
+			iid := iidPresets + i*iidStride
+			on := &characteristic{iid: iid + 1, typ: "25", format: "bool",
+				value: func() interface{} { return false }, set: srv.setPreset(p.Name)}
+			srv.services = append(srv.services, &service{iid: iid, typ: "49", chars: []*characteristic{on, str(iid+2, "23", p.Name)}})
+		}
+	}
+	srv.chars = map[int]*characteristic{}
+	for _, s := range srv.services {
+		for _, c := range s.chars {
+			srv.chars[c.iid] = c
+		}
+	}
+	srv.watched = append([]*characteristic{srv.chars[iidOn], srv.chars[iidBrightness]}, switches...)
+}
+
+// txt returns the _hap._tcp TXT record.
+func (srv *Server) txt() []string {
+	sf := "1" // discoverable for pairing
+	if srv.store.paired() {
+		sf = "0"
+	}
+	return []string{"c#=1", "ff=0", "id=" + srv.store.ID, "md=" + srv.cfg.Name, "pv=1.1", "s#=1", "sf=" + sf, "ci=5"}
+}
+
+// pairingsChanged re-announces the TXT record, whose status flag follows whether the
+// accessory is paired; callers hold mu.
+func (srv *Server) pairingsChanged() {
+	go srv.mdns.Announce()
+}
+
+// disconnect closes the connections of removed controllers, c's once its reply is sent.
+func (srv *Server) disconnect(c *session, removed []string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for s := range srv.sessions {
+		for _, id := range removed {
+			if s.controller != id {
+				continue
+			}
+			if s == c {
+				s.closing = true
+			} else {
+				s.conn.Close()
+			}
+		}
+	}
+}
+
+func (srv *Server) serve(conn net.Conn) {
+	c := newSession(conn)
+	srv.mu.Lock()
+	srv.sessions[c] = true
+	srv.mu.Unlock()
+	defer func() {
+		conn.Close()
+		srv.mu.Lock()
+		delete(srv.sessions, c)
+		if srv.setupBy == c {
+			srv.setup, srv.setupBy = nil, nil
+		}
+		srv.mu.Unlock()
+	}()
+	for {
+		req, err := http.ReadRequest(c.br)
+		if err != nil {
+			return
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return
+		}
+		status, contentType, out := srv.handle(c, req, body)
+		if err := c.reply(status, contentType, out); err != nil || c.closing {
+			return
+		}
+	}
+}
+
+// handle serves one request. Only pairing is available before pair-verify.
+func (srv *Server) handle(c *session, req *http.Request, body []byte) (int, string, []byte) {
+	route := req.Method + " " + req.URL.Path
+	switch route {
+	case "POST /pair-setup", "POST /pair-verify":
+		msg, err := decodeTLV(body)
+		if err != nil {
+			return http.StatusBadRequest, "", nil
+		}
+		if route == "POST /pair-setup" {
+			return http.StatusOK, contentTLV, srv.pairSetup(c, msg)
+		}
+		return http.StatusOK, contentTLV, srv.pairVerify(c, msg)
+	case "POST /identify":
+		if srv.store.paired() {
+			return http.StatusBadRequest, contentJSON, []byte(fmt.Sprintf(`{"status":%d}`, hapInsufficientPrivileges))
+		}
+		srv.identify(nil)
+		return http.StatusNoContent, "", nil
+	}
+	if c.keys == nil {
+		return statusConnectionAuthRequired, "", nil
+	}
+	switch route {
+	case "POST /pairings":
+		msg, err := decodeTLV(body)
+		if err != nil {
+			return http.StatusBadRequest, "", nil
+		}
+		return http.StatusOK, contentTLV, srv.pairings(c, msg)
+	case "GET /accessories":
+		return http.StatusOK, contentJSON, srv.accessories()
+	case "GET /characteristics":
+		return srv.readCharacteristics(req.URL.Query().Get("id"))
+	case "PUT /characteristics":
+		return srv.writeCharacteristics(c, body)
+	}
+	return http.StatusNotFound, "", nil
+}
+
+// accessories is the accessory database.
+func (srv *Server) accessories() []byte {
+	var services []map[string]interface{}
+	for _, s := range srv.services {
+		var chars []map[string]interface{}
+		for _, c := range s.chars {
+			ch := map[string]interface{}{"iid": c.iid, "type": c.typ, "format": c.format, "perms": c.perms()}
+			for k, v := range c.meta {
+				ch[k] = v
+			}
+			if c.value != nil {
+				ch["value"] = c.value()
+			}
+			chars = append(chars, ch)
+		}
+		services = append(services, map[string]interface{}{"iid": s.iid, "type": s.typ, "primary": s.primary, "characteristics": chars})
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"accessories": []map[string]interface{}{{"aid": aid, "services": services}},
+	})
+	return data
+}
+
+func (c *characteristic) perms() []string {
+	var p []string
+	if c.value != nil {
+		p = append(p, "pr")
+	}
+	if c.set != nil {
+		p = append(p, "pw")
+	}
+	if c.events {
+		p = append(p, "ev")
+	}
+	return p
+}
+
+// readCharacteristics serves GET /characteristics?id=1.11,1.12.
+func (srv *Server) readCharacteristics(ids string) (int, string, []byte) {
+	var out []map[string]interface{}
+	failed := false
+	for _, id := range strings.Split(ids, ",") {
+		var a, iid int
+		fmt.Sscanf(id, "%d.%d", &a, &iid)
+		r := map[string]interface{}{"aid": a, "iid": iid}
+		c := srv.chars[iid]
+		switch {
+		case a != aid || c == nil:
+			r["status"], failed = hapNotFound, true
+		case c.value == nil:
+			r["status"], failed = hapWriteOnly, true
+		default:
+			r["value"] = c.value()
+		}
+		out = append(out, r)
+	}
+	status := http.StatusOK
+	if failed {
+		status = http.StatusMultiStatus
+		for _, r := range out {
+			if _, ok := r["status"]; !ok {
+				r["status"] = hapOK
+			}
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"characteristics": out})
+	return status, contentJSON, data
+}
+
+// writeCharacteristics serves PUT /characteristics, which sets values and subscribes to events.
+func (srv *Server) writeCharacteristics(c *session, body []byte) (int, string, []byte) {
+	var req struct {
+		Characteristics []struct {
+			AID   int             `json:"aid"`
+			IID   int             `json:"iid"`
+			Value json.RawMessage `json:"value"`
+			Ev    *bool           `json:"ev"`
+		} `json:"characteristics"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return http.StatusBadRequest, "", nil
+	}
+	// power before brightness, so that "on at 40%" lights the fire before stepping its flame
+	sort.SliceStable(req.Characteristics, func(i, j int) bool { return req.Characteristics[i].IID < req.Characteristics[j].IID })
+	var out []map[string]interface{}
+	failed := false
+	for _, w := range req.Characteristics {
+		status := hapOK
+		ch := srv.chars[w.IID]
+		switch {
+		case w.AID != aid || ch == nil:
+			status = hapNotFound
+		case w.Ev != nil && !ch.events:
+			status = hapNoNotification
+		case w.Ev != nil:
+			c.subscribe(w.IID, *w.Ev)
+		}
+		if status == hapOK && w.Value != nil {
+			if ch.set == nil {
+				status = hapReadOnly
+			} else {
+				status = ch.set(w.Value)
+			}
+		}
+		failed = failed || status != hapOK
+		out = append(out, map[string]interface{}{"aid": w.AID, "iid": w.IID, "status": status})
+	}
+	if !failed {
+		return http.StatusNoContent, "", nil
+	}
+	data, _ := json.Marshal(map[string]interface{}{"characteristics": out})
+	return http.StatusMultiStatus, contentJSON, data
+}
+
+func (srv *Server) identify(json.RawMessage) int {
+	logging.Event(logging.Notice, "homekit: identify", "name", srv.cfg.Name)
+	return hapOK
+}
+
+func (srv *Server) on() interface{} {
+	return srv.power.State().Power == "on"
+}
+
+// brightness is the estimated flame level as a percentage of the highest.
+func (srv *Server) brightness() interface{} {
+	st, max := srv.power.State(), srv.power.Levels().Max
+	if st.Power != "on" || st.FlameLevel == nil || max == 0 {
+		return 0
+	}
+	return int(math.Round(float64(*st.FlameLevel) * 100 / float64(max)))
+}
+
+func (srv *Server) setOn(v json.RawMessage) int {
+	var on bool
+	if err := json.Unmarshal(v, &on); err != nil {
+		var n int
+		if err := json.Unmarshal(v, &n); err != nil {
+			return hapInvalidValue
+		}
+		on = n != 0
+	}
+	if on == srv.on().(bool) {
+		return hapOK
+	}
+	action := "off"
+	if on {
+		action = "on"
+	}
+	if result := srv.runner.Run(action, source); result != "ok" {
+		logging.Logf(logging.Info, "homekit: %s: %s", action, result)
+		return hapCommunicationFailure
+	}
+	// a brightness in the same write needs the tracker, subscribed to commands, to have caught up
+	for i := 0; i < 50 && srv.on() != on; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return hapOK
+}
+
+func (srv *Server) setBrightness(v json.RawMessage) int {
+	var pct float64
+	if err := json.Unmarshal(v, &pct); err != nil || pct < 0 || pct > 100 {
+		return hapInvalidValue
+	}
+	if pct == 0 {
+		return hapOK // the Home app turns the light off separately
+	}
+	level := int(math.Round(pct * float64(srv.power.Levels().Max) / 100))
+	if level < 1 {
+		level = 1
+	}
+	if result := srv.runner.SetFlame(srv.power, level, source); result != "ok" {
+		logging.Logf(logging.Info, "homekit: flame level %d: %s", level, result)
+		return hapCommunicationFailure
+	}
+	return hapOK
+}
+
+// setAccessory returns the setter of c's on characteristic.
+func (srv *Server) setAccessory(c *accessory.Channel) func(v json.RawMessage) int {
+	return func(v json.RawMessage) int {
+		var on bool
+		if err := json.Unmarshal(v, &on); err != nil {
+			var n int
+			if err := json.Unmarshal(v, &n); err != nil {
+				return hapInvalidValue
+			}
+			on = n != 0
+		}
+		state := "off"
+		if on {
+			state = "on"
+		}
+		if _, err := c.Set(state, source); err != nil {
+			logging.Logf(logging.Info, "homekit: accessory %s: %v", c.Name(), err)
+			return hapCommunicationFailure
+		}
+		return hapOK
+	}
+}
+
+// setPreset returns the setter of the on characteristic of the preset called name, which
+// applies it in the background, as lighting the fire outlasts a HAP write.
+func (srv *Server) setPreset(name string) func(v json.RawMessage) int {
+	return func(v json.RawMessage) int {
+		var on bool
+		if err := json.Unmarshal(v, &on); err != nil {
+			var n int
+			if err := json.Unmarshal(v, &n); err != nil {
+				return hapInvalidValue
+			}
+			on = n != 0
+		}
+		if !on {
+			return hapOK
+		}
+		fault.Go("homekit", func() {
+			if result, err := srv.presets.Apply(context.Background(), name, source); err != nil {
+				logging.Logf(logging.Info, "homekit: preset %s: %v", name, err)
+			} else if result != "ok" {
+				logging.Logf(logging.Info, "homekit: preset %s: %s", name, result)
+			}
+		})
+		return hapOK
+	}
+}
+
+// watchEvents sends changes of power, flame level and the accessories, whatever the command's
+// source, to the controllers subscribed to them.
+func (srv *Server) watchEvents() {
+	ch, _ := events.Subscribe()
+	for range ch {
+		// give the tracker, subscribed alongside, a moment to apply the command
+		time.Sleep(100 * time.Millisecond)
+		srv.mu.Lock()
+		changed := map[int]interface{}{}
+		for _, c := range srv.watched {
+			if v := c.value(); v != srv.notified[c.iid] {
+				srv.notified[c.iid], changed[c.iid] = v, v
+			}
+		}
+		sessions := make([]*session, 0, len(srv.sessions))
+		for s := range srv.sessions {
+			sessions = append(sessions, s)
+		}
+		srv.mu.Unlock()
+		for _, s := range sessions {
+			var out []map[string]interface{}
+			for iid, v := range changed {
+				if s.subscribed(iid) {
+					out = append(out, map[string]interface{}{"aid": aid, "iid": iid, "value": v})
+				}
+			}
+			if len(out) > 0 {
+				data, _ := json.Marshal(map[string]interface{}{"characteristics": out})
+				s.event(data)
+			}
+		}
+	}
+}
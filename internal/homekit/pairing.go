@@ -0,0 +1,326 @@
+package homekit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// maxPairings is how many controllers the accessory can be paired with.
+const maxPairings = 16
+
+// maxSetupTries is how many wrong setup codes are accepted before pair-setup is refused
+// until restart.
+const maxSetupTries = 100
+
+// pairing is a paired controller.
+type pairing struct {
+	PublicKey []byte `json:"public_key"` // Ed25519
+	Admin     bool   `json:"admin"`
+}
+
+// store is the accessory's identity and its pairings, kept in the state file.
+type store struct {
+	file string
+
+	mu       sync.Mutex
+	ID       string             `json:"id"`   // device ID, like a MAC address
+	Seed     []byte             `json:"seed"` // Ed25519 long-term key
+	Pairings map[string]pairing `json:"pairings"`
+}
+
+// openStore loads the state file, creating the accessory's identity on first use.
+func openStore(file string) (*store, error) {
+	s := &store{file: file, Pairings: map[string]pairing{}}
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("homekit: %s: %v", file, err)
+		}
+		if s.Pairings == nil {
+			s.Pairings = map[string]pairing{}
+		}
+		return s, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	id := make([]byte, 6)
+	s.Seed = make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(s.Seed); err != nil {
+		return nil, err
+	}
+	s.ID = fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", id[0], id[1], id[2], id[3], id[4], id[5])
+	return s, s.save()
+}
+
+// save writes the state file; callers other than openStore must hold mu.
+func (s *store) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(s.file, data, 0600)
+}
+
+func (s *store) key() ed25519.PrivateKey { return ed25519.NewKeyFromSeed(s.Seed) }
+
+// paired reports whether any controller is paired.
+func (s *store) paired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Pairings) > 0
+}
+
+func (s *store) get(id string) (pairing, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Pairings[id]
+	return p, ok
+}
+
+// add records a pairing; a controller already paired keeps its key and only changes permission.
+func (s *store) add(id string, publicKey []byte, admin bool) byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.Pairings[id]; ok {
+		if !bytes.Equal(p.PublicKey, publicKey) {
+			return errCodeUnknown
+		}
+	} else if len(s.Pairings) >= maxPairings {
+		return errCodeMaxPeers
+	}
+	s.Pairings[id] = pairing{PublicKey: publicKey, Admin: admin}
+	if err := s.save(); err != nil {
+		logging.Logf(logging.Err, "homekit: saving pairings: %v", err)
+		return errCodeUnknown
+	}
+	return 0
+}
+
+// remove deletes a pairing, and every pairing once no admin is left, as the accessory is
+// then unpaired. It returns the removed controllers.
+func (s *store) remove(id string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Pairings[id]; !ok {
+		return nil
+	}
+	delete(s.Pairings, id)
+	removed := []string{id}
+	admin := false
+	for _, p := range s.Pairings {
+		admin = admin || p.Admin
+	}
+	if !admin {
+		for other := range s.Pairings {
+			removed = append(removed, other)
+		}
+		s.Pairings = map[string]pairing{}
+	}
+	if err := s.save(); err != nil {
+		logging.Logf(logging.Err, "homekit: saving pairings: %v", err)
+	}
+	return removed
+}
+
+// list returns the pairings as a pairings-list TLV8 response.
+func (s *store) list() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := []tlvItem{tlvByte(tlvState, 2)}
+	first := true
+	for id, p := range s.Pairings {
+		if !first {
+			items = append(items, tlvItem{tlvSeparator, nil})
+		}
+		first = false
+		perm := byte(0)
+		if p.Admin {
+			perm = 1
+		}
+		items = append(items, tlvItem{tlvIdentifier, []byte(id)}, tlvItem{tlvPublicKey, p.PublicKey}, tlvByte(tlvPermissions, perm))
+	}
+	return encodeTLV(items...)
+}
+
+func errorTLV(state, code byte) []byte {
+	return encodeTLV(tlvByte(tlvState, state), tlvByte(tlvError, code))
+}
+
+// pairSetup handles one message of pair-setup, exchanging the setup code over SRP and then
+// the long-term keys of the controller and the accessory.
+func (srv *Server) pairSetup(c *session, req tlvs) []byte {
+	state := req[tlvState]
+	if len(state) != 1 {
+		return errorTLV(2, errCodeUnknown)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	switch state[0] {
+	case 1:
+		switch {
+		case srv.store.paired():
+			return errorTLV(2, errCodeUnavailable)
+		case srv.setupTries >= maxSetupTries:
+			return errorTLV(2, errCodeMaxTries)
+		case srv.setupBy != nil && srv.setupBy != c:
+			return errorTLV(2, errCodeBusy)
+		}
+		s, err := newSRPServer(srv.cfg.PIN)
+		if err != nil {
+			return errorTLV(2, errCodeUnknown)
+		}
+		srv.setup, srv.setupBy = s, c
+		return encodeTLV(tlvByte(tlvState, 2), tlvItem{tlvSalt, s.salt}, tlvItem{tlvPublicKey, pad(s.B)})
+	case 3:
+		if srv.setupBy != c {
+			return errorTLV(4, errCodeUnknown)
+		}
+		proof, err := srv.setup.verify(req[tlvPublicKey], req[tlvProof])
+		if err != nil {
+			srv.setupTries++
+			srv.setup, srv.setupBy = nil, nil
+			logging.Event(logging.Warning, "homekit: wrong setup code", "from", c.addr)
+			return errorTLV(4, errCodeAuthentication)
+		}
+		return encodeTLV(tlvByte(tlvState, 4), tlvItem{tlvProof, proof})
+	case 5:
+		if srv.setupBy != c || srv.setup.K == nil {
+			return errorTLV(6, errCodeUnknown)
+		}
+		K := srv.setup.K
+		srv.setup, srv.setupBy = nil, nil
+		key := hkdf(K, "Pair-Setup-Encrypt-Salt", "Pair-Setup-Encrypt-Info")
+		plain, err := open(key, msgNonce("PS-Msg05"), req[tlvEncryptedData], nil)
+		if err != nil {
+			return errorTLV(6, errCodeAuthentication)
+		}
+		sub, err := decodeTLV(plain)
+		if err != nil {
+			return errorTLV(6, errCodeUnknown)
+		}
+		id, ltpk := sub[tlvIdentifier], sub[tlvPublicKey]
+		info := append(append(hkdf(K, "Pair-Setup-Controller-Sign-Salt", "Pair-Setup-Controller-Sign-Info"), id...), ltpk...)
+		if len(ltpk) != ed25519.PublicKeySize || !ed25519.Verify(ltpk, info, sub[tlvSignature]) {
+			return errorTLV(6, errCodeAuthentication)
+		}
+		if code := srv.store.add(string(id), ltpk, true); code != 0 {
+			return errorTLV(6, code)
+		}
+		logging.Event(logging.Notice, "homekit: paired", "controller", string(id), "from", c.addr)
+		priv := srv.store.key()
+		pub := priv.Public().(ed25519.PublicKey)
+		info = append(append(hkdf(K, "Pair-Setup-Accessory-Sign-Salt", "Pair-Setup-Accessory-Sign-Info"), srv.store.ID...), pub...)
+		reply := encodeTLV(tlvItem{tlvIdentifier, []byte(srv.store.ID)}, tlvItem{tlvPublicKey, pub},
+			tlvItem{tlvSignature, ed25519.Sign(priv, info)})
+		srv.pairingsChanged()
+		return encodeTLV(tlvByte(tlvState, 6), tlvItem{tlvEncryptedData, seal(key, msgNonce("PS-Msg06"), reply, nil)})
+	}
+	return errorTLV(state[0]+1, errCodeUnknown)
+}
+
+// pairVerify handles one message of pair-verify, which agrees the session keys of a
+// connection from a paired controller.
+func (srv *Server) pairVerify(c *session, req tlvs) []byte {
+	state := req[tlvState]
+	if len(state) != 1 {
+		return errorTLV(2, errCodeUnknown)
+	}
+	switch state[0] {
+	case 1:
+		theirs := req[tlvPublicKey]
+		if len(theirs) != 32 {
+			return errorTLV(2, errCodeUnknown)
+		}
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return errorTLV(2, errCodeUnknown)
+		}
+		v := &verification{theirs: theirs, ours: x25519(secret, x25519Base)}
+		v.shared = x25519(secret, theirs)
+		v.key = hkdf(v.shared, "Pair-Verify-Encrypt-Salt", "Pair-Verify-Encrypt-Info")
+		info := append(append(append([]byte{}, v.ours...), srv.store.ID...), theirs...)
+		sub := encodeTLV(tlvItem{tlvIdentifier, []byte(srv.store.ID)}, tlvItem{tlvSignature, ed25519.Sign(srv.store.key(), info)})
+		c.verify = v
+		return encodeTLV(tlvByte(tlvState, 2), tlvItem{tlvPublicKey, v.ours},
+			tlvItem{tlvEncryptedData, seal(v.key, msgNonce("PV-Msg02"), sub, nil)})
+	case 3:
+		v := c.verify
+		c.verify = nil
+		if v == nil {
+			return errorTLV(4, errCodeUnknown)
+		}
+		plain, err := open(v.key, msgNonce("PV-Msg03"), req[tlvEncryptedData], nil)
+		if err != nil {
+			return errorTLV(4, errCodeAuthentication)
+		}
+		sub, err := decodeTLV(plain)
+		if err != nil {
+			return errorTLV(4, errCodeUnknown)
+		}
+		id := string(sub[tlvIdentifier])
+		p, ok := srv.store.get(id)
+		info := append(append(append([]byte{}, v.theirs...), id...), v.ours...)
+		if !ok || !ed25519.Verify(p.PublicKey, info, sub[tlvSignature]) {
+			logging.Event(logging.Warning, "homekit: unknown controller", "controller", id, "from", c.addr)
+			return errorTLV(4, errCodeAuthentication)
+		}
+		// the reply still goes out in the clear; everything after it is encrypted
+		c.upgrade = &sessionKeys{
+			read:  hkdf(v.shared, "Control-Salt", "Control-Write-Encryption-Key"),
+			write: hkdf(v.shared, "Control-Salt", "Control-Read-Encryption-Key"),
+		}
+		c.controller = id
+		return encodeTLV(tlvByte(tlvState, 4))
+	}
+	return errorTLV(state[0]+1, errCodeUnknown)
+}
+
+// pairings handles adding, removing and listing pairings, which only admins may do.
+func (srv *Server) pairings(c *session, req tlvs) []byte {
+	if p, ok := srv.store.get(c.controller); !ok || !p.Admin {
+		return errorTLV(2, errCodeAuthentication)
+	}
+	method := req[tlvMethod]
+	if len(method) != 1 {
+		return errorTLV(2, errCodeUnknown)
+	}
+	switch method[0] {
+	case 3: // add
+		pk := req[tlvPublicKey]
+		if len(pk) != ed25519.PublicKeySize {
+			return errorTLV(2, errCodeUnknown)
+		}
+		perm := req[tlvPermissions]
+		if code := srv.store.add(string(req[tlvIdentifier]), pk, len(perm) == 1 && perm[0] == 1); code != 0 {
+			return errorTLV(2, code)
+		}
+		logging.Event(logging.Notice, "homekit: pairing added", "controller", string(req[tlvIdentifier]), "by", c.controller)
+	case 4: // remove
+		removed := srv.store.remove(string(req[tlvIdentifier]))
+		if len(removed) > 0 {
+			logging.Event(logging.Notice, "homekit: pairing removed", "controller", string(req[tlvIdentifier]), "by", c.controller)
+			srv.mu.Lock()
+			srv.pairingsChanged()
+			srv.mu.Unlock()
+			srv.disconnect(c, removed)
+		}
+	case 5: // list
+		return srv.store.list()
+	default:
+		return errorTLV(2, errCodeUnknown)
+	}
+	return encodeTLV(tlvByte(tlvState, 2))
+}
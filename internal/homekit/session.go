@@ -0,0 +1,146 @@
+package homekit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// maxFrame is the most plaintext an encrypted frame carries.
+const maxFrame = 1024
+
+// verification is a pair-verify in progress on a connection.
+type verification struct {
+	ours, theirs []byte // X25519 public keys
+	shared       []byte
+	key          []byte
+}
+
+// sessionKeys are a verified connection's keys: read decrypts what the controller sends.
+type sessionKeys struct {
+	read, write []byte
+}
+
+// session is one controller connection. It starts in the clear for pairing; after
+// pair-verify every byte each way is in ChaCha20-Poly1305 frames with counter nonces.
+type session struct {
+	conn net.Conn
+	addr string
+	br   *bufio.Reader
+
+	verify     *verification
+	upgrade    *sessionKeys // keys to switch to once the current reply is sent
+	controller string       // paired controller ID, once verified
+	closing    bool         // close once the current reply is sent
+
+	keys          *sessionKeys
+	readN, writeN uint64
+	pending       []byte // decrypted but not yet read
+
+	mu     sync.Mutex // serialises writes: replies and events
+	events map[int]bool
+}
+
+func newSession(conn net.Conn) *session {
+	c := &session{conn: conn, addr: conn.RemoteAddr().String(), events: map[int]bool{}}
+	c.br = bufio.NewReader(c)
+	return c
+}
+
+// Read reads plaintext from the connection, decrypting frames once verified.
+func (c *session) Read(p []byte) (int, error) {
+	if c.keys == nil {
+		return c.conn.Read(p)
+	}
+	if len(c.pending) == 0 {
+		var length [2]byte
+		if _, err := io.ReadFull(c.conn, length[:]); err != nil {
+			return 0, err
+		}
+		n := binary.LittleEndian.Uint16(length[:])
+		if n > maxFrame {
+			return 0, fmt.Errorf("homekit: %d byte frame", n)
+		}
+		sealed := make([]byte, int(n)+16)
+		if _, err := io.ReadFull(c.conn, sealed); err != nil {
+			return 0, err
+		}
+		plain, err := open(c.keys.read, counterNonce(c.readN), sealed, length[:])
+		if err != nil {
+			return 0, err
+		}
+		c.readN++
+		c.pending = plain
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// write sends b, encrypted once verified; callers must hold mu.
+func (c *session) write(b []byte) error {
+	if c.keys == nil {
+		_, err := c.conn.Write(b)
+		return err
+	}
+	var out []byte
+	for len(b) > 0 {
+		n := len(b)
+		if n > maxFrame {
+			n = maxFrame
+		}
+		var length [2]byte
+		binary.LittleEndian.PutUint16(length[:], uint16(n))
+		out = append(out, length[:]...)
+		out = append(out, seal(c.keys.write, counterNonce(c.writeN), b[:n], length[:])...)
+		c.writeN++
+		b = b[n:]
+	}
+	_, err := c.conn.Write(out)
+	return err
+}
+
+// reply sends an HTTP response, then switches to the keys agreed by pair-verify if any.
+func (c *session) reply(status int, contentType string, body []byte) error {
+	text := http.StatusText(status)
+	if status == statusConnectionAuthRequired {
+		text = "Connection Authorization Required"
+	}
+	head := fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, text)
+	if len(body) > 0 {
+		head += "Content-Type: " + contentType + "\r\n"
+	}
+	head += fmt.Sprintf("Content-Length: %d\r\n\r\n", len(body))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.write(append([]byte(head), body...))
+	if c.upgrade != nil {
+		c.keys, c.upgrade = c.upgrade, nil
+	}
+	return err
+}
+
+// event sends an unsolicited characteristic change.
+func (c *session) event(body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head := fmt.Sprintf("EVENT/1.0 200 OK\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", contentJSON, len(body))
+	c.write(append([]byte(head), body...))
+}
+
+// subscribed reports whether the controller asked for events of characteristic iid.
+func (c *session) subscribed(iid int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.events[iid]
+}
+
+func (c *session) subscribe(iid int, on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events[iid] = on
+}
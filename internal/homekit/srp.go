@@ -0,0 +1,98 @@
+package homekit
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"math/big"
+)
+
+// SRP-6a with SHA-512 and the 3072-bit group of RFC 5054, as pair-setup uses it; the
+// username is "Pair-Setup" and the password the setup code.
+
+// srpN is the RFC 5054 3072-bit prime (the same as RFC 3526's group 15); the generator is 5.
+var srpN, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05"+
+		"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB"+
+		"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"+
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718"+
+		"3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33"+
+		"A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7"+
+		"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864"+
+		"D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2"+
+		"08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF", 16)
+
+var srpG = big.NewInt(5)
+
+const srpUser = "Pair-Setup"
+
+var errBadProof = errors.New("homekit: bad SRP proof")
+
+func sha512Of(parts ...[]byte) []byte {
+	h := sha512.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// pad left-pads n to the length of srpN.
+func pad(n *big.Int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, 384-len(b)), b...)
+}
+
+// srpServer is the accessory's side of one SRP exchange.
+type srpServer struct {
+	salt []byte
+	v, b *big.Int
+	B    *big.Int
+	K    []byte // session key, once the client's public key is known
+	A    *big.Int
+}
+
+// newSRPServer starts an exchange for the setup code: the salt and B go to the client.
+func newSRPServer(code string) (*srpServer, error) {
+	s := &srpServer{salt: make([]byte, 16)}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(s.salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	x := new(big.Int).SetBytes(sha512Of(s.salt, sha512Of([]byte(srpUser+":"+code))))
+	s.v = new(big.Int).Exp(srpG, x, srpN)
+	s.b = new(big.Int).SetBytes(secret)
+	k := new(big.Int).SetBytes(sha512Of(srpN.Bytes(), pad(srpG)))
+	s.B = new(big.Int).Mul(k, s.v)
+	s.B.Add(s.B, new(big.Int).Exp(srpG, s.b, srpN))
+	s.B.Mod(s.B, srpN)
+	return s, nil
+}
+
+// verify takes the client's public key A and proof M1, and returns the server proof M2.
+func (s *srpServer) verify(a, m1 []byte) ([]byte, error) {
+	s.A = new(big.Int).SetBytes(a)
+	if new(big.Int).Mod(s.A, srpN).Sign() == 0 {
+		return nil, errBadProof
+	}
+	u := new(big.Int).SetBytes(sha512Of(pad(s.A), pad(s.B)))
+	S := new(big.Int).Exp(s.v, u, srpN)
+	S.Mul(S, s.A)
+	S.Exp(S, s.b, srpN)
+	s.K = sha512Of(S.Bytes())
+	hn, hg := sha512Of(srpN.Bytes()), sha512Of(srpG.Bytes())
+	for i := range hn {
+		hn[i] ^= hg[i]
+	}
+	want := sha512Of(hn, sha512Of([]byte(srpUser)), s.salt, s.A.Bytes(), s.B.Bytes(), s.K)
+	if subtle.ConstantTimeCompare(want, m1) != 1 {
+		return nil, errBadProof
+	}
+	return sha512Of(s.A.Bytes(), m1, s.K), nil
+}
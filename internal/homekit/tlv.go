@@ -0,0 +1,82 @@
+package homekit
+
+import "errors"
+
+// TLV8 item types used by pairing.
+const (
+	tlvMethod        = 0x00
+	tlvIdentifier    = 0x01
+	tlvSalt          = 0x02
+	tlvPublicKey     = 0x03
+	tlvProof         = 0x04
+	tlvEncryptedData = 0x05
+	tlvState         = 0x06
+	tlvError         = 0x07
+	tlvSignature     = 0x0A
+	tlvPermissions   = 0x0B
+	tlvSeparator     = 0xFF
+)
+
+// TLV8 error codes.
+const (
+	errCodeUnknown        = 0x01
+	errCodeAuthentication = 0x02
+	errCodeMaxPeers       = 0x04
+	errCodeMaxTries       = 0x05
+	errCodeUnavailable    = 0x06
+	errCodeBusy           = 0x07
+)
+
+// tlvItem is one TLV8 item; items longer than 255 bytes are split into fragments.
+type tlvItem struct {
+	typ   byte
+	value []byte
+}
+
+// tlvs maps item types to values, which is all pairing requests need.
+type tlvs map[byte][]byte
+
+var errBadTLV = errors.New("homekit: malformed TLV8")
+
+// decodeTLV reads a TLV8 message, joining fragments of the same type.
+func decodeTLV(b []byte) (tlvs, error) {
+	out := tlvs{}
+	last := -1
+	for len(b) > 0 {
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return nil, errBadTLV
+		}
+		typ, value := b[0], b[2:2+int(b[1])]
+		if int(typ) == last {
+			out[typ] = append(out[typ], value...)
+		} else {
+			out[typ] = append([]byte{}, value...)
+		}
+		last = int(typ)
+		b = b[2+int(b[1]):]
+	}
+	return out, nil
+}
+
+// encodeTLV writes items in order, fragmenting long values.
+func encodeTLV(items ...tlvItem) []byte {
+	var out []byte
+	for _, it := range items {
+		v := it.value
+		for {
+			n := len(v)
+			if n > 255 {
+				n = 255
+			}
+			out = append(out, it.typ, byte(n))
+			out = append(out, v[:n]...)
+			v = v[n:]
+			if len(v) == 0 {
+				break
+			}
+		}
+	}
+	return out
+}
+
+func tlvByte(typ, v byte) tlvItem { return tlvItem{typ, []byte{v}} }
@@ -0,0 +1,181 @@
+// Package hooks runs shell commands on the main fireplace's commands, for devices GoFire
+// doesn't drive itself, such as a smart plug powering the fan in step with the fire:
+//
+//   - pre_<op>, e.g. pre_on: as the command starts, before the outcome is known
+//   - post_<op>, e.g. post_off: once the command has succeeded
+//   - on_failure: once any command has failed (any result but ok or queued)
+//
+// Each is run with sh -c, with the command in its environment: GOFIRE_HOOK (e.g. post_on),
+// GOFIRE_OP, GOFIRE_SOURCE, GOFIRE_TIME (RFC 3339) and, but for pre hooks, GOFIRE_RESULT,
+// GOFIRE_CLIENT when known and GOFIRE_PARAM_<NAME> for each parameter, e.g. GOFIRE_PARAM_LEVEL;
+// a dry run's carry GOFIRE_PARAM_DRY_RUN=true. Hooks run one at a time in the order their
+// commands ran, and never hold a command back or refuse it: one that fails or runs past the
+// timeout, when it is killed, is logged and left.
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// Ops are the commands that can have pre_ and post_ hooks.
+var Ops = []string{"on", "off", "pilot", "flameup", "flamedown", "setflame", "aux", "aux_on", "aux_off", "fan", "splitflow"}
+
+// maxOutput bounds how much of a hook's output is kept for the log.
+const maxOutput = 4 << 10
+
+// queueLen is how many hooks can wait to run before further ones are dropped.
+const queueLen = 64
+
+// Runner runs the configured hooks.
+type Runner struct {
+	commands map[string]string
+	timeout  time.Duration
+	queue    chan run
+}
+
+// run is a hook waiting to run.
+type run struct {
+	hook string
+	env  []string
+}
+
+// Start runs the hooks of cfg as the commands they are for run.
+func Start(cfg config.Hooks) (*Runner, error) {
+	known := map[string]bool{"on_failure": true}
+	for _, op := range Ops {
+		known["pre_"+op], known["post_"+op] = true, true
+	}
+	for hook, command := range cfg.Commands {
+		if !known[hook] {
+			return nil, fmt.Errorf("hooks: unknown hook %q", hook)
+		}
+		if strings.TrimSpace(command) == "" {
+			return nil, fmt.Errorf("hooks: %s has no command", hook)
+		}
+	}
+	h := &Runner{commands: cfg.Commands, timeout: cfg.Timeout, queue: make(chan run, queueLen)}
+	starts, _ := events.SubscribeStarts()
+	done, _ := events.Subscribe()
+	fault.Go("hooks", func() { h.watch(starts, done) })
+	fault.Go("hooks", h.worker)
+	return h, nil
+}
+
+// watch queues the hooks of each command starting or done. Commands of further fireplaces,
+// recorded as name/op, have none.
+func (h *Runner) watch(starts <-chan events.Start, done <-chan events.Command) {
+	for {
+		select {
+		case s := <-starts:
+			if strings.Contains(s.Op, "/") {
+				continue
+			}
+			h.enqueue("pre_"+s.Op, []string{"GOFIRE_OP=" + s.Op, "GOFIRE_SOURCE=" + s.Source,
+				"GOFIRE_TIME=" + s.Time.Format(time.RFC3339)})
+		case c := <-done:
+			if strings.Contains(c.Op, "/") {
+				continue
+			}
+			env := []string{"GOFIRE_OP=" + c.Op, "GOFIRE_SOURCE=" + c.Source, "GOFIRE_TIME=" + c.Time.Format(time.RFC3339),
+				"GOFIRE_RESULT=" + c.Result}
+			if c.Client != "" {
+				env = append(env, "GOFIRE_CLIENT="+c.Client)
+			}
+			var keys []string
+			for k := range c.Params {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				env = append(env, "GOFIRE_PARAM_"+strings.ToUpper(k)+"="+c.Params[k])
+			}
+			switch c.Result {
+			case "ok":
+				h.enqueue("post_"+c.Op, env)
+			case "queued":
+			default:
+				h.enqueue("on_failure", env)
+			}
+		}
+	}
+}
+
+// enqueue queues hook, if it is set, with env.
+func (h *Runner) enqueue(hook string, env []string) {
+	if h.commands[hook] == "" {
+		return
+	}
+	select {
+	case h.queue <- run{hook: hook, env: append(env, "GOFIRE_HOOK="+hook)}:
+	default:
+		logging.Event(logging.Warning, "hook dropped: too many waiting", "hook", hook)
+	}
+}
+
+func (h *Runner) worker() {
+	for r := range h.queue {
+		h.run(r)
+	}
+}
+
+// run runs r, killing it, and anything it started, once it has run for the timeout.
+func (h *Runner) run(r run) {
+	cmd := exec.Command("sh", "-c", h.commands[r.hook])
+	cmd.Env = append(os.Environ(), r.env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	out := &limitedBuffer{max: maxOutput}
+	cmd.Stdout, cmd.Stderr = out, out
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		logging.Event(logging.Warning, "hook failed", "hook", r.hook, "error", err.Error())
+		return
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	timer := time.NewTimer(h.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-done:
+	case <-timer.C:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		err = fmt.Errorf("killed after %v", h.timeout)
+	}
+	took := time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		logging.Event(logging.Warning, "hook failed", "hook", r.hook, "took", took, "error", err.Error(),
+			"output", strings.TrimSpace(out.String()))
+		return
+	}
+	logging.Event(logging.Debug, "hook ran", "hook", r.hook, "took", took)
+}
+
+// limitedBuffer keeps the first max bytes written to it, discarding the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/barrylb/go-fire/internal/fireplace"
+)
+
+// accessoriesHandler lists the accessories of config accessories as on or off:
+//
+//	GET /accessory    {"fan": "on", "lights": "off"}
+func (s *Server) accessoriesHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Accessories == nil {
+		http.Error(w, "accessory_disabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Accessories.State())
+}
+
+// accessoryHandler switches an accessory, replying with the state it is left in:
+//
+//	POST /accessory/fan/on        accessory_on   (also off, and toggle)
+//	GET  /accessory/fan/status    accessory_off
+//
+// Switching one on while the emergency stop is engaged replies accessory_lockout (409), and
+// a relay that can't be driven accessory_failed (500).
+func (s *Server) accessoryHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/accessory/"), "/")
+	if len(parts) == 1 && parts[0] == "" {
+		s.accessoriesHandler(w, r)
+		return
+	}
+	if s.Accessories == nil {
+		http.Error(w, "accessory_disabled", http.StatusNotFound)
+		return
+	}
+	c := s.Accessories.Get(parts[0])
+	if c == nil || len(parts) != 2 {
+		http.Error(w, "accessory_unknown", http.StatusNotFound)
+		return
+	}
+	on := c.On()
+	switch op := parts[1]; op {
+	case "status":
+	case "on", "off", "toggle":
+		var err error
+		on, err = c.SetContext(r.Context(), op, "http")
+		if errors.Is(err, fireplace.ErrLockout) {
+			http.Error(w, "accessory_lockout", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "accessory_failed", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "accessory_badop", http.StatusNotFound)
+		return
+	}
+	if on {
+		fmt.Fprintf(w, "accessory_on")
+	} else {
+		fmt.Fprintf(w, "accessory_off")
+	}
+}
@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/audit"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// maxAudit bounds how many commands one /audit reply lists.
+const maxAudit = 10000
+
+// auditHandler queries the audit trail, newest first:
+//
+//	GET /audit?since=24h                   [{"op": "on", "result": "ok", "source": "http", "client": "192.168.1.20", "time": "..."}, ...]
+//	GET /audit?since=2024-12-01T18:00:00Z&until=2024-12-01T20:00:00Z
+//	GET /audit?since=168h&op=on&source=mqtt&n=50
+//
+// since and until are a time (RFC 3339) or how long ago (a duration); since defaults to 24h
+// and until to now, and n (default 1000) keeps the newest.
+func (s *Server) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Audit == nil {
+		http.Error(w, "audit_disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now()
+	f := audit.Filter{Since: now.Add(-24 * time.Hour), Until: now, Source: q.Get("source"), Op: q.Get("op"), Limit: 1000}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			*p.t = t
+		} else if d, err := time.ParseDuration(v); err == nil {
+			*p.t = now.Add(-d)
+		} else {
+			http.Error(w, "audit_bad"+p.name, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("n"); v != "" {
+		var err error
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 || f.Limit > maxAudit {
+			http.Error(w, "audit_badn", http.StatusBadRequest)
+			return
+		}
+	}
+	cmds, err := s.Audit.Query(f)
+	if err != nil {
+		logging.Logf(logging.Err, "audit: %v", err)
+		http.Error(w, "audit_error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cmds)
+}
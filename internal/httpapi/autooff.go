@@ -0,0 +1,42 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// maxAutoOff caps the limit one burn can be given.
+const maxAutoOff = 24 * time.Hour
+
+// autoOffHandler reports and changes the auto-off safety timer:
+//
+//	GET  /autooff              {"limit": "4h0m0s", "session": false, "deadline": "..."} (deadline only while the fire is on)
+//	POST /autooff?hours=2      turn this burn (or the next, while the fire is off) off after 2 hours
+func (s *Server) autoOffHandler(w http.ResponseWriter, r *http.Request) {
+	if s.AutoOff == nil {
+		http.Error(w, "autooff_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		hours, err := strconv.ParseFloat(r.URL.Query().Get("hours"), 64)
+		limit := time.Duration(hours * float64(time.Hour))
+		if err != nil || limit <= 0 || limit > maxAutoOff {
+			http.Error(w, "autooff_badhours", http.StatusBadRequest)
+			return
+		}
+		s.AutoOff.Set(limit)
+		logging.Event(logging.Notice, "auto-off limit set for this burn", "limit", limit.String(), "from", ClientAddr(r))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "autooff_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.AutoOff.State())
+}
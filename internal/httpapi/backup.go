@@ -0,0 +1,101 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/auth"
+	"github.com/barrylb/go-fire/internal/backup"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// maxRestoreSize bounds an archive given to /restore.
+const maxRestoreSize = 512 << 20
+
+// restoreReply is what /restore put back.
+type restoreReply struct {
+	Restored []string `json:"restored"` // config.yaml, usage.file, history.dir/2026-10.csv, ...
+}
+
+// backupHandler archives the configuration and the files GoFire keeps its state in:
+//
+//	GET /backup    a gzipped tar of config.yaml, rules_file, presets.file, usage.file, history.dir/..., ...
+//
+// gofire backup -o FILE fetches it from the command line.
+func (s *Server) backupHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Backup == nil {
+		http.Error(w, "backup_disabled", http.StatusNotFound)
+		return
+	}
+	if !s.adminToken(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "backup_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	logging.Event(logging.Notice, "backup requested", "from", ClientAddr(r))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="gofire-backup-%s.tar.gz"`, time.Now().Format("20060102-150405")))
+	if err := s.Backup.Write(w); err != nil {
+		// the reply has begun: the archive is left cut short
+		logging.Logf(logging.Err, "backup: %v", err)
+	}
+}
+
+// restoreHandler puts back an archive from /backup and restarts into it:
+//
+//	POST /restore    the archive in the body; replies {"restored": ["config.yaml", "usage.file", ...]}
+//
+// The files go where the running configuration says. An archive that isn't from /backup, or
+// whose configuration doesn't load or keeps its state elsewhere or runs other programs than
+// the running one, changes nothing and replies restore_badarchive with status 400.
+func (s *Server) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Backup == nil {
+		http.Error(w, "backup_disabled", http.StatusNotFound)
+		return
+	}
+	if !s.adminToken(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "restore_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	logging.Event(logging.Notice, "restore requested", "from", ClientAddr(r))
+	restored, err := s.Backup.Restore(http.MaxBytesReader(w, r.Body, maxRestoreSize))
+	switch {
+	case errors.Is(err, backup.ErrBadArchive):
+		logging.Logf(logging.Warning, "%v", err)
+		http.Error(w, "restore_badarchive", http.StatusBadRequest)
+		return
+	case err != nil:
+		logging.Logf(logging.Err, "backup: %v", err)
+		http.Error(w, "restore_error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restoreReply{Restored: restored})
+}
+
+// adminToken reports whether r presents an admin token, replying otherwise. An archive holds
+// every token and key, and a restore replaces them, so neither is served without one: not on a
+// trusted listener, nor with no tokens set up (backup_notoken, status 403).
+func (s *Server) adminToken(w http.ResponseWriter, r *http.Request) bool {
+	if s.Auth == nil || !s.Auth.Enabled() {
+		http.Error(w, "backup_notoken", http.StatusForbidden)
+		return false
+	}
+	if _, ok := s.Auth.Check(requestToken(r), auth.ScopeAdmin); !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gofire"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
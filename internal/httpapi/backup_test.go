@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/barrylb/go-fire/internal/auth"
+	"github.com/barrylb/go-fire/internal/backup"
+	"github.com/barrylb/go-fire/internal/config"
+)
+
+func TestBackupNeedsAdminToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := config.Auth{AdminTokens: []string{"admin-secret"},
+		Tokens: []config.StaticToken{{Name: "panel", Token: "panel-secret", Scopes: []string{"on", "off", "status"}}}}
+	tests := []struct {
+		name    string
+		auth    *config.Auth // nil for auth off
+		token   string
+		trusted bool
+		want    int
+	}{
+		{"auth off", nil, "", false, http.StatusForbidden},
+		{"auth off, trusted", nil, "", true, http.StatusForbidden},
+		{"no token", &tokens, "", false, http.StatusUnauthorized},
+		{"trusted listener", &tokens, "", true, http.StatusUnauthorized},
+		{"not an admin", &tokens, "panel-secret", false, http.StatusUnauthorized},
+		{"admin", &tokens, "admin-secret", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Backup: backup.New(path, cfg, "test")}
+			if tt.auth != nil {
+				if s.Auth, err = auth.New(*tt.auth); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := httptest.NewRequest(http.MethodGet, "/backup", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.trusted {
+				r = r.WithContext(context.WithValue(r.Context(), trustedKey{}, true))
+			}
+			w := httptest.NewRecorder()
+			s.backupHandler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("GET /backup: status %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK {
+				return
+			}
+			r.Method = http.MethodPost
+			w = httptest.NewRecorder()
+			s.restoreHandler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("POST /restore: status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// queuedCommand is a command accepted in queue mode and not yet run.
+type queuedCommand struct {
+	op     string
+	run    func(f fireplace.Fireplace) error
+	from   string
+	queued time.Time
+	dryRun bool
+}
+
+// ctx is the context the queued command runs with: not bound to its request, whose client
+// has had its reply, but recorded as sent by the same client, and still a dry run if it was
+// asked for one.
+func (c queuedCommand) ctx() context.Context {
+	ctx := events.WithClient(context.Background(), c.from)
+	if c.dryRun {
+		ctx = gv60.DryRun(ctx)
+	}
+	return ctx
+}
+
+// replyBusy answers a command refused while the relays were busy, as http.busy.mode asks.
+func (s *Server) replyBusy(w http.ResponseWriter, r *http.Request, op string) {
+	if s.Busy.Mode == "reject" {
+		s.retryLater(w)
+	}
+	reply(w, r, op, "busy")
+}
+
+// retryLater sets a 503 status with a Retry-After header.
+func (s *Server) retryLater(w http.ResponseWriter) {
+	s.retryAfter(w)
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+// retryAfter sets the Retry-After header.
+func (s *Server) retryAfter(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.Busy.RetryAfter.Seconds()))))
+}
+
+// enqueue accepts a command for the queue worker, replying op_queued, or op_busy with a
+// 503 when the queue is full, and reports whether it was queued. The outcome is recorded
+// and published when it runs.
+func (s *Server) enqueue(w http.ResponseWriter, r *http.Request, op string, run func(f fireplace.Fireplace) error) bool {
+	if !s.tryEnqueue(r, op, run) {
+		s.retryLater(w)
+		reply(w, r, op, "busy")
+		return false
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "%s_queued", op)
+	return true
+}
+
+// tryEnqueue queues a command, reporting false if the queue is full.
+func (s *Server) tryEnqueue(r *http.Request, op string, run func(f fireplace.Fireplace) error) bool {
+	s.queueOnce.Do(s.startQueue)
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	if len(s.queue) >= s.Busy.QueueSize {
+		logging.Event(logging.Warning, "command queue full", "op", op, "from", ClientAddr(r))
+		return false
+	}
+	s.queue = append(s.queue, queuedCommand{op, run, ClientAddr(r), time.Now(), gv60.IsDryRun(r.Context())})
+	select {
+	case s.queueWake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (s *Server) startQueue() {
+	s.queueWake = make(chan struct{}, 1)
+	fault.Go("queue", s.runQueue)
+}
+
+// runQueue runs queued commands one at a time, in the order they were accepted. A command
+// still queued after http.busy.queue_timeout is dropped and recorded as expired, so a burst
+// of taps doesn't keep the flame changing long after the user has stopped.
+func (s *Server) runQueue() {
+	for range s.queueWake {
+		for {
+			s.queueMu.Lock()
+			if len(s.queue) == 0 {
+				s.queueMu.Unlock()
+				break
+			}
+			c := s.queue[0]
+			s.queue = s.queue[1:]
+			s.queueMu.Unlock()
+			if time.Since(c.queued) > s.Busy.QueueTimeout {
+				logging.Event(logging.Warning, "queued command expired", "op", c.op, "from", c.from)
+				events.RecordContext(c.ctx(), c.op, "expired", "http", nil)
+				continue
+			}
+			result := s.Actions.DoContext(c.ctx(), c.op, "http", c.run)
+			events.RecordContext(c.ctx(), c.op, result, "http", nil)
+		}
+	}
+}
+
+// dropQueue discards the commands waiting in the queue, recording each as cancelled, and
+// returns how many there were.
+func (s *Server) dropQueue() int {
+	s.queueMu.Lock()
+	dropped := s.queue
+	s.queue = nil
+	s.queueMu.Unlock()
+	for _, c := range dropped {
+		events.RecordContext(c.ctx(), c.op, "cancelled", "http", nil)
+	}
+	return len(dropped)
+}
+
+// queuedJSON is one command waiting in the queue, as GET /queue reports it.
+type queuedJSON struct {
+	Op     string    `json:"op"`
+	From   string    `json:"from"`
+	Queued time.Time `json:"queued"`
+}
+
+// queueState is the reply to GET /queue.
+type queueState struct {
+	Size    int          `json:"size"`
+	Timeout string       `json:"timeout"`
+	Pending []queuedJSON `json:"pending"`
+}
+
+// queueHandler shows or clears the commands waiting in the queue (http.busy.mode: queue):
+//
+//	GET    /queue   the queue's depth, timeout and waiting commands, oldest first
+//	DELETE /queue   drop the waiting commands, each recorded as cancelled; replies
+//	                queue_cleared with how many, e.g. queue_cleared 3
+func (s *Server) queueHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Busy.Mode != "queue" {
+		http.Error(w, "queue_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.queueMu.Lock()
+		pending := make([]queuedJSON, 0, len(s.queue))
+		for _, c := range s.queue {
+			pending = append(pending, queuedJSON{c.op, c.from, c.queued})
+		}
+		s.queueMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(queueState{Size: s.Busy.QueueSize, Timeout: s.Busy.QueueTimeout.String(), Pending: pending})
+	case http.MethodDelete:
+		n := s.dropQueue()
+		if n > 0 {
+			logging.Event(logging.Notice, "queue cleared", "dropped", strconv.Itoa(n), "from", ClientAddr(r))
+		}
+		fmt.Fprintf(w, "queue_cleared %d", n)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "queue_badmethod", http.StatusMethodNotAllowed)
+	}
+}
+
+// cancelHandler aborts the running contact sequence, leaving every contact open, and
+// drops any queued commands and stops any ramp or modulation. It replies cancel_ok, or cancel_idle if
+// nothing was running.
+func (s *Server) cancelHandler(w http.ResponseWriter, r *http.Request) {
+	dropped := s.dropQueue()
+	running := s.Ramp != nil && s.Ramp.Stop("cancelled")
+	running = s.Modulate != nil && s.Modulate.Stop("cancelled") || running
+	running = s.Resync != nil && s.Resync.Stop() || running
+	running = s.Fire.Cancel() || running
+	if !running && dropped == 0 {
+		fmt.Fprintf(w, "cancel_idle")
+		return
+	}
+	logging.Event(logging.Notice, "cancelled", "running", strconv.FormatBool(running),
+		"queued", strconv.Itoa(dropped), "from", ClientAddr(r))
+	fmt.Fprintf(w, "cancel_ok")
+}
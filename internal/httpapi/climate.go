@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// climateState is the thermostat in the terms of a Home Assistant climate entity.
+type climateState struct {
+	CurrentTemperature *float64 `json:"current_temperature"`
+	TargetTemperature  *float64 `json:"target_temperature"`
+	HVACMode           string   `json:"hvac_mode"`   // heat, or off while the thermostat is off
+	HVACAction         string   `json:"hvac_action"` // heating, idle or off
+	HVACModes          []string `json:"hvac_modes"`
+	MaxTemp            float64  `json:"max_temp"`
+	TemperatureUnit    string   `json:"temperature_unit,omitempty"` // °C or °F
+}
+
+// climateRequest is a change to the thermostat, as JSON or query parameters.
+type climateRequest struct {
+	TargetTemperature *float64 `json:"target_temperature,omitempty"`
+	HVACMode          string   `json:"hvac_mode,omitempty"`
+}
+
+// climateHandler is the thermostat for Home Assistant's rest sensors and rest_command, so a
+// template climate entity can drive it without MQTT:
+//
+//	GET  /climate                                  {"current_temperature": 19.6, "target_temperature": 21, "hvac_mode": "heat", "hvac_action": "heating", ...}
+//	POST /climate    {"target_temperature": 21}    set the target, turning the thermostat on as /settemp does
+//	POST /climate    {"hvac_mode": "off"}          stop the thermostat and turn the fire off
+//	POST /climate    {"hvac_mode": "heat"}         turn the thermostat back on at its last target
+//
+// POST takes target_temperature and hvac_mode as query parameters too, and replies with the
+// state as GET does. Heat with no target to go back to replies climate_notarget (409), and an
+// off command the fire refuses climate_off_ followed by its result (409).
+func (s *Server) climateHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Thermostat == nil {
+		http.Error(w, "climate_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req climateRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+				http.Error(w, "climate_badrequest", http.StatusBadRequest)
+				return
+			}
+		}
+		q := r.URL.Query()
+		if v := q.Get("target_temperature"); v != "" {
+			t, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				http.Error(w, "climate_badtarget", http.StatusBadRequest)
+				return
+			}
+			req.TargetTemperature = &t
+		}
+		if v := q.Get("hvac_mode"); v != "" {
+			req.HVACMode = v
+		}
+		if t := req.TargetTemperature; t != nil && (math.IsNaN(*t) || *t < 0 || *t > s.Thermostat.MaxTarget()) {
+			http.Error(w, "climate_badtarget", http.StatusBadRequest)
+			return
+		}
+		if req.HVACMode != "" && req.HVACMode != "heat" && req.HVACMode != "off" {
+			http.Error(w, "climate_badmode", http.StatusBadRequest)
+			return
+		}
+		if t := req.TargetTemperature; t != nil {
+			s.Thermostat.Set(t)
+			logging.Event(logging.Notice, "thermostat target set", "target", strconv.FormatFloat(*t, 'f', -1, 64),
+				"from", ClientAddr(r))
+		}
+		switch req.HVACMode {
+		case "heat":
+			if s.Thermostat.Resume() == nil {
+				http.Error(w, "climate_notarget", http.StatusConflict)
+				return
+			}
+			logging.Event(logging.Notice, "thermostat on", "from", ClientAddr(r))
+		case "off":
+			s.Thermostat.Set(nil)
+			logging.Event(logging.Notice, "thermostat off", "from", ClientAddr(r))
+			if s.Power.State().Power != "off" {
+				if result := s.Actions.RunContext(r.Context(), "off", "http"); result != "ok" {
+					http.Error(w, "climate_off_"+result, http.StatusConflict)
+					return
+				}
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "climate_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.climate())
+}
+
+// climate returns the thermostat's state as a climate entity sees it.
+func (s *Server) climate() climateState {
+	t := s.Thermostat.State()
+	c := climateState{CurrentTemperature: t.Temperature, TargetTemperature: t.Target, HVACMode: "off",
+		HVACAction: "off", HVACModes: []string{"heat", "off"}, MaxTemp: s.Thermostat.MaxTarget()}
+	if t.Unit == "C" || t.Unit == "F" {
+		c.TemperatureUnit = "°" + t.Unit
+	}
+	if t.Target != nil {
+		c.HVACMode, c.HVACAction = "heat", "idle"
+		if s.Power.State().Power == "on" {
+			c.HVACAction = "heating"
+		}
+	}
+	return c
+}
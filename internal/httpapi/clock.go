@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// maxAdvance bounds one fast-forward of the simulated clock.
+const maxAdvance = 31 * 24 * time.Hour
+
+// clockHandler shows and fast-forwards the simulated clock the rules run against:
+//
+//	GET  /clock                  {"now": "..."}
+//	POST /clock?advance=168h     run a week's time triggers, replying once they have all run
+func (s *Server) clockHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Clock == nil {
+		http.Error(w, "clock_notsimulated", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		d, err := time.ParseDuration(r.URL.Query().Get("advance"))
+		if err != nil || d <= 0 || d > maxAdvance {
+			http.Error(w, "clock_badadvance", http.StatusBadRequest)
+			return
+		}
+		start := s.Clock.Now()
+		s.Clock.Advance(d)
+		logging.Event(logging.Notice, "simulated clock advanced", "start", start.Format(time.RFC3339),
+			"by", d.String(), "from", ClientAddr(r))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "clock_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clockState{s.Clock.Now()})
+}
+
+// clockState is the reply of /clock.
+type clockState struct {
+	Now time.Time `json:"now"`
+}
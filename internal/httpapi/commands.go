@@ -0,0 +1,26 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/barrylb/go-fire/internal/events"
+)
+
+// commandsHandler lists the latest commands from every source, newest first, so a UI can
+// show what last changed the fire and where from:
+//
+//	GET /commands?n=20    [{"op": "on", "result": "ok", "source": "rules", "time": "...", "params": {...}}, ...]
+func (s *Server) commandsHandler(w http.ResponseWriter, r *http.Request) {
+	n := 20
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			http.Error(w, "commands_badn", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events.Recent(n))
+}
@@ -0,0 +1,113 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// confirmedKey marks the context of an ignition request replayed by /confirm.
+type confirmedKey struct{}
+
+// heldIgnition is an ignition request waiting for /confirm.
+type heldIgnition struct {
+	req     *http.Request
+	next    http.HandlerFunc
+	dryRun  bool
+	expires time.Time
+}
+
+// confirmations are the ignition requests waiting for /confirm, by token.
+type confirmations struct {
+	mu   sync.Mutex
+	held map[string]*heldIgnition
+}
+
+// requireConfirm holds ignition, with lockout.confirm set, until /confirm?code= follows with
+// the one-time token it replies: on_confirm CODE (202), or for the v1 API a v1Response with
+// the result confirm and the token as confirm. The held request, PIN, for= and dryrun=
+// included, then runs as if just made, so a retried /on, as from a flaky automation, can't
+// light the fire on its own.
+func (s *Server) requireConfirm(v1 bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.ConfirmIgnition <= 0 || r.Context().Value(confirmedKey{}) != nil {
+			next(w, r)
+			return
+		}
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			logging.Logf(logging.Err, "confirm: %v", err)
+			http.Error(w, "on_error", http.StatusInternalServerError)
+			return
+		}
+		token := base64.RawURLEncoding.EncodeToString(b)
+		now := time.Now()
+		s.confirms.mu.Lock()
+		if s.confirms.held == nil {
+			s.confirms.held = map[string]*heldIgnition{}
+		}
+		for t, h := range s.confirms.held {
+			if now.After(h.expires) {
+				delete(s.confirms.held, t)
+			}
+		}
+		s.confirms.held[token] = &heldIgnition{req: r.Clone(context.Background()), next: next,
+			dryRun: gv60.IsDryRun(r.Context()), expires: now.Add(s.ConfirmIgnition)}
+		s.confirms.mu.Unlock()
+		logging.Event(logging.Info, "ignition awaiting confirmation", "from", ClientAddr(r),
+			"within", s.ConfirmIgnition.String())
+		if v1 {
+			writeV1(w, v1Response{Command: "on", Result: "confirm", Confirm: token})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "on_confirm %s", token)
+	}
+}
+
+// confirmHandler lights the fire for an ignition request held by requireConfirm, replying as
+// that request would have been replied to, e.g. on_ok. The token works once, within
+// lockout.confirm, and only with the credential the ignition was asked for with:
+//
+//	POST /confirm?code=...    on_ok
+//
+// It replies confirm_badtoken (404) for a token that is unknown, used or someone else's, and
+// confirm_expired (410) once it has run out.
+func (s *Server) confirmHandler(w http.ResponseWriter, r *http.Request) {
+	if s.ConfirmIgnition <= 0 {
+		http.Error(w, "confirm_disabled", http.StatusNotFound)
+		return
+	}
+	token := r.URL.Query().Get("code")
+	s.confirms.mu.Lock()
+	h := s.confirms.held[token]
+	if h != nil && subtle.ConstantTimeCompare([]byte(requestToken(h.req)), []byte(requestToken(r))) != 1 {
+		h = nil
+	}
+	if h != nil {
+		delete(s.confirms.held, token)
+	}
+	s.confirms.mu.Unlock()
+	switch {
+	case h == nil:
+		logging.Event(logging.Warning, "ignition confirmation refused: bad token", "from", ClientAddr(r))
+		http.Error(w, "confirm_badtoken", http.StatusNotFound)
+		return
+	case time.Now().After(h.expires):
+		http.Error(w, "confirm_expired", http.StatusGone)
+		return
+	}
+	ctx := context.WithValue(r.Context(), confirmedKey{}, true)
+	if h.dryRun {
+		ctx = gv60.DryRun(ctx)
+	}
+	h.next(w, h.req.WithContext(ctx))
+}
@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+)
+
+// Degraded is the API GoFire serves when it can't get at the GPIO chip or the valve's lines
+// at start, so that a headless Pi says why rather than leaving nothing listening:
+//
+//	GET /status     {"degraded": true, "gpio_error": "gpiochip0: no such file or directory", ...}
+//	GET /healthz    {"status": "failing", "checks": {"gpio": "gpiochip0: no such file or directory"}}
+//
+// /readyz is /healthz, and every other route replies 503 with gpio_unavailable and the error.
+// These need no token, as the token store isn't open yet; they control nothing.
+type Degraded struct {
+	mu       sync.Mutex
+	err      error
+	since    time.Time
+	attempts int
+	next     time.Time
+}
+
+// degradedStatus is the reply of /status in degraded mode.
+type degradedStatus struct {
+	Degraded    bool      `json:"degraded"`
+	GPIOError   string    `json:"gpio_error"`
+	Since       time.Time `json:"since"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// NewDegraded returns the degraded API for err, the first attempt at the GPIO lines.
+func NewDegraded(err error) *Degraded {
+	return &Degraded{err: err, since: time.Now(), attempts: 1}
+}
+
+// Failed records another failed attempt, and when the next is due.
+func (d *Degraded) Failed(err error, next time.Time) {
+	d.mu.Lock()
+	d.err, d.next = err, next
+	d.attempts++
+	d.mu.Unlock()
+}
+
+// Retrying records when the next attempt is due.
+func (d *Degraded) Retrying(next time.Time) {
+	d.mu.Lock()
+	d.next = next
+	d.mu.Unlock()
+}
+
+// Handler returns the degraded API under cfg.BasePath.
+func (d *Degraded) Handler(cfg config.HTTP) http.Handler {
+	var handler http.Handler = http.HandlerFunc(d.serveHTTP)
+	if base := strings.TrimSuffix(cfg.BasePath, "/"); base != "" {
+		handler = http.StripPrefix(base, handler)
+	}
+	return handler
+}
+
+func (d *Degraded) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	st := degradedStatus{Degraded: true, GPIOError: d.err.Error(), Since: d.since, Attempts: d.attempts,
+		NextAttempt: d.next}
+	d.mu.Unlock()
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.URL.Path {
+	case "/status":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	case "/healthz", "/readyz":
+		probe(w, map[string]error{"gpio": d.err})
+	default:
+		http.Error(w, "gpio_unavailable: "+st.GPIOError, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,55 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// demandHandler reports and sets the demand-response signal:
+//
+//	GET  /demand                          {"active": true, "until": "...", "override": false, ...}
+//	POST /demand?active=true&for=2h       start an event (for is optional), or active=false to end it
+//	POST /demand?override=true            ignore the current event until it ends
+func (s *Server) demandHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Demand == nil {
+		http.Error(w, "demand_disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if v := q.Get("override"); v != "" {
+			on, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "demand_badoverride", http.StatusBadRequest)
+				return
+			}
+			s.Demand.Override(on)
+			break
+		}
+		active, err := strconv.ParseBool(q.Get("active"))
+		if err != nil {
+			http.Error(w, "demand_badactive", http.StatusBadRequest)
+			return
+		}
+		var until time.Time
+		if v := q.Get("for"); v != "" && active {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				http.Error(w, "demand_badfor", http.StatusBadRequest)
+				return
+			}
+			until = time.Now().Add(d)
+		}
+		s.Demand.Set(active, until)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "demand_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Demand.State())
+}
@@ -0,0 +1,91 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// ensureHandler returns the handler of /ensure_on (on) or /ensure_off, which run the command
+// only when the fire isn't tracked as in its state already:
+//
+//	POST /ensure_on?pin=1234     as /on, or already_on when the fire is tracked as lit
+//	POST /ensure_off             as /off, or already_off when it is tracked as off
+//
+// The valve has no feedback, so this is the state GoFire believes; force=1 sends the command
+// anyway, as after the fire was lit or put out by hand. A pilot flame or an unknown state is
+// neither on nor off. for= on /ensure_on sets the off timer even when the fire is lit already.
+func (s *Server) ensureHandler(on bool) http.HandlerFunc {
+	op, run := "off", fireplace.Fireplace.Off
+	if on {
+		op, run = "on", fireplace.Fireplace.On
+	}
+	command := s.commandHandler(op, run)
+	return func(w http.ResponseWriter, r *http.Request) {
+		force, ok := forced(r)
+		if !ok {
+			http.Error(w, "ensure_"+op+"_badforce", http.StatusBadRequest)
+			return
+		}
+		if !force && s.Power.State().Power == op {
+			d, ok := s.offAfter(w, r, op)
+			if !ok {
+				return
+			}
+			s.startTimer(r, d, "ok")
+			logging.Event(logging.Info, "ensure: already "+op, "from", ClientAddr(r))
+			fmt.Fprintf(w, "already_%s", op)
+			return
+		}
+		command(w, r)
+	}
+}
+
+// ensureLevelHandler sets the flame to a level unless the fire is tracked as lit at it
+// already:
+//
+//	POST /ensure_level?level=4   as /setflame, or already_level when lit at level 4
+//
+// Level 0 is the pilot flame, as for /setflame, and force=1 sets the flame anyway; for= sets
+// the off timer whether or not the flame is changed.
+func (s *Server) ensureLevelHandler(w http.ResponseWriter, r *http.Request) {
+	force, ok := forced(r)
+	if !ok {
+		http.Error(w, "ensure_level_badforce", http.StatusBadRequest)
+		return
+	}
+	level, err := strconv.Atoi(r.URL.Query().Get("level"))
+	if err != nil {
+		http.Error(w, "setflame_badlevel", http.StatusBadRequest)
+		return
+	}
+	st := s.Power.State()
+	already := st.Power == "pilot" && level == 0 ||
+		st.Power == "on" && level > 0 && st.FlameLevel != nil && *st.FlameLevel == level
+	if !force && already {
+		d, ok := s.offAfter(w, r, "setflame")
+		if !ok {
+			return
+		}
+		s.startTimer(r, d, "ok")
+		logging.Event(logging.Info, "ensure: already at level", "level", strconv.Itoa(level), "from", ClientAddr(r))
+		fmt.Fprintf(w, "already_level")
+		return
+	}
+	s.setFlameHandler(w, r)
+}
+
+// forced reports whether the request has force=1, and false for ok if force is neither
+// on nor off.
+func forced(r *http.Request) (force, ok bool) {
+	switch r.URL.Query().Get("force") {
+	case "", "0", "false":
+		return false, true
+	case "1", "true":
+		return true, true
+	}
+	return false, false
+}
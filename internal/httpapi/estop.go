@@ -0,0 +1,174 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/estop"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// estopSource is what the emergency stop's off commands are recorded as.
+const estopSource = "estop"
+
+// estopWait bounds how long the emergency stop's off waits for a sequence it cut short to
+// let go of the relays.
+const estopWait = 2 * time.Second
+
+// estopHandler is the emergency stop of every fireplace, the main one and the further ones:
+//
+//	GET  /estop    {"main": {"engaged": true, "since": "...", "by": "192.168.1.20"}, "den": {"engaged": false}}
+//	POST /estop    estop_ok
+//
+// POST latches each emergency stop, drops the queue, stops a ramp, modulation or resync and
+// cuts short the running sequence, then turns each fire off; estop_offfailed (500) means an
+// off failed, though the stops are engaged all the same. While engaged, every command but off is refused
+// as lockout, whatever sends it, until /estop/clear. /fireplaces/den/estop and
+// /fireplaces/den/estop_clear do the same for a single further fireplace.
+func (s *Server) estopHandler(w http.ResponseWriter, r *http.Request) {
+	if s.EStop == nil {
+		http.Error(w, "estop_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		out := map[string]estop.State{"main": s.EStop.State("")}
+		for name := range s.Fireplaces {
+			out[name] = s.EStop.State(name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	case http.MethodPost:
+		// every stop is latched before any fire is turned off, and the fires go off together
+		names := []string{""}
+		for name := range s.Fireplaces {
+			names = append(names, name)
+		}
+		for _, name := range names {
+			s.engageStop(name, ClientAddr(r))
+		}
+		results := make([]string, len(names))
+		var wg sync.WaitGroup
+		for i, name := range names {
+			wg.Add(1)
+			go func(i int, name string) {
+				defer wg.Done()
+				results[i] = s.stopFire(name)
+			}(i, name)
+		}
+		wg.Wait()
+		ok := true
+		for _, result := range results {
+			ok = ok && result == "ok"
+		}
+		if !ok {
+			http.Error(w, "estop_offfailed", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "estop_ok")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "estop_badmethod", http.StatusMethodNotAllowed)
+	}
+}
+
+// estopClearHandler releases the emergency stop of every fireplace:
+//
+//	POST /estop/clear    estop_cleared, or estop_notengaged
+func (s *Server) estopClearHandler(w http.ResponseWriter, r *http.Request) {
+	if s.EStop == nil {
+		http.Error(w, "estop_disabled", http.StatusNotFound)
+		return
+	}
+	cleared := s.clearStop("", ClientAddr(r))
+	for name := range s.Fireplaces {
+		cleared = s.clearStop(name, ClientAddr(r)) || cleared
+	}
+	if !cleared {
+		fmt.Fprintf(w, "estop_notengaged")
+		return
+	}
+	fmt.Fprintf(w, "estop_cleared")
+}
+
+// engageStop latches the emergency stop of the fireplace called name on behalf of by.
+func (s *Server) engageStop(name, by string) {
+	if _, err := s.EStop.Engage(name, by); err != nil {
+		logging.Logf(logging.Err, "estop: %v", err)
+	}
+}
+
+// stopFire cuts short what the fireplace called name, "" for the main one, is doing and
+// turns it off, returning the off's result.
+func (s *Server) stopFire(name string) string {
+	var fire fireplace.Fireplace
+	var runner *actions.Runner
+	if name == "" {
+		s.dropQueue()
+		if s.Ramp != nil {
+			s.Ramp.Stop("estop")
+		}
+		if s.Modulate != nil {
+			s.Modulate.Stop("estop")
+		}
+		if s.Resync != nil {
+			s.Resync.Stop()
+		}
+		fire, runner = s.Fire, s.Actions
+	} else {
+		f := s.Fireplaces[name]
+		fire, runner = f.Fire, f.Actions
+	}
+	fire.Cancel()
+	var result string
+	for deadline := time.Now().Add(estopWait); ; time.Sleep(50 * time.Millisecond) {
+		// not the request's context: the fire goes off even if the client has gone
+		result = runner.DoContext(context.Background(), "off", estopSource, fireplace.Fireplace.Off)
+		if result != "busy" || time.Now().After(deadline) {
+			break
+		}
+	}
+	events.Record(runner.Op("off"), result, estopSource)
+	return result
+}
+
+// clearStop releases the emergency stop of the fireplace called name on behalf of by,
+// reporting whether it was engaged.
+func (s *Server) clearStop(name, by string) bool {
+	cleared, err := s.EStop.Clear(name, by)
+	if err != nil {
+		logging.Logf(logging.Err, "estop: %v", err)
+	}
+	return cleared
+}
+
+// fireplaceStopHandler engages (op estop) or releases (estop_clear) the emergency stop of the
+// further fireplace called name alone.
+func (s *Server) fireplaceStopHandler(w http.ResponseWriter, r *http.Request, name, op string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "estop_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	if op == "estop" {
+		s.engageStop(name, ClientAddr(r))
+		if s.stopFire(name) != "ok" {
+			http.Error(w, "estop_offfailed", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "estop_ok")
+		return
+	}
+	if !s.clearStop(name, ClientAddr(r)) {
+		fmt.Fprintf(w, "estop_notengaged")
+		return
+	}
+	fmt.Fprintf(w, "estop_cleared")
+}
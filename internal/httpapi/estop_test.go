@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/accessory"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/estop"
+	"github.com/barrylb/go-fire/internal/light"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/selftest"
+	"github.com/barrylb/go-fire/pkg/gv60"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// lamp is a dimmable light output that remembers its brightness.
+type lamp struct{ pct int }
+
+func (l *lamp) SetBrightness(pct int) error { l.pct = pct; return nil }
+func (l *lamp) Dimmable() bool              { return true }
+
+func TestEStopRefusesAccessoriesLightAndClicks(t *testing.T) {
+	chip := relay.OpenMock(func(string, ...interface{}) {})
+	stops, err := estop.Open(config.EStop{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err := accessory.Open(chip, []config.Accessory{{Name: "fan", GPIO: 5, Mode: "latched"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.Guards = append(acc.Guards, stops.Guard(""))
+	var lines []gv60.RelayDriver
+	for _, gpio := range []int{26, 20, 21} {
+		l, err := chip.Channel(gpio, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, l)
+	}
+	valve, err := gv60.NewProfile(gv60.Profiles["gv60"], lines...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tester := selftest.New(chip, time.Millisecond, selftest.Valve{Fire: valve, GPIOs: []int{26, 20, 21},
+		Power: power.StartNamed("test", power.Levels{}), Guard: stops.Guard("")})
+	out := &lamp{}
+	s := &Server{EStop: stops, Light: light.New(out, 0), Accessories: acc, SelfTest: tester}
+	if _, err := stops.Engage("", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, target string
+		handler        http.HandlerFunc
+		want           int
+		reply          string
+	}{
+		{http.MethodPost, "/light?state=on", s.lightHandler, http.StatusConflict, "light_lockout\n"},
+		{http.MethodPost, "/light?brightness=40", s.lightHandler, http.StatusConflict, "light_lockout\n"},
+		{http.MethodPost, "/light?state=off", s.lightHandler, http.StatusOK, "light_off"},
+		{http.MethodPost, "/accessory/fan/on", s.accessoryHandler, http.StatusConflict, "accessory_lockout\n"},
+		{http.MethodPost, "/accessory/fan/toggle", s.accessoryHandler, http.StatusConflict, "accessory_lockout\n"},
+		{http.MethodPost, "/accessory/fan/off", s.accessoryHandler, http.StatusOK, "accessory_off"},
+		{http.MethodPost, "/selftest?click=1", s.selfTestHandler, http.StatusConflict, "selftest_lockout\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.handler(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.want || w.Body.String() != tt.reply {
+			t.Errorf("%s %s: %d %q, want %d %q", tt.method, tt.target, w.Code, w.Body.String(), tt.want, tt.reply)
+		}
+	}
+	if out.pct != 0 || acc.Get("fan").On() {
+		t.Error("the light or the fan was switched on")
+	}
+}
@@ -0,0 +1,46 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/barrylb/go-fire/internal/fireplace"
+)
+
+// fanHandler sets the blower speed: /fan?speed=0 (off) to the fireplace's highest speed.
+func (s *Server) fanHandler(w http.ResponseWriter, r *http.Request) {
+	fan, ok := s.Fire.(fireplace.Fan)
+	if !ok {
+		http.Error(w, "fan_unsupported", http.StatusNotFound)
+		return
+	}
+	speed, err := strconv.Atoi(r.URL.Query().Get("speed"))
+	if err != nil || speed < 0 {
+		http.Error(w, "fan_badspeed", http.StatusBadRequest)
+		return
+	}
+	result := s.Actions.DoContext(r.Context(), "fan", "http", func(fireplace.Fireplace) error { return fan.SetFan(speed) })
+	replyWith(w, r, "fan", result, map[string]string{"speed": strconv.Itoa(speed)})
+}
+
+// auxBurner returns the command lighting (on) or putting out the second burner of a
+// dual-burner valve; it fails as unsupported when the valve has none.
+func auxBurner(on bool) func(f fireplace.Fireplace) error {
+	return func(f fireplace.Fireplace) error { return fireplace.SetAuxBurner(f, on) }
+}
+
+// splitFlowHandler opens or closes the split-flow valve: /splitflow?state=on|off.
+func (s *Server) splitFlowHandler(w http.ResponseWriter, r *http.Request) {
+	sf, ok := s.Fire.(fireplace.SplitFlow)
+	if !ok {
+		http.Error(w, "splitflow_unsupported", http.StatusNotFound)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	if state != "on" && state != "off" {
+		http.Error(w, "splitflow_badstate", http.StatusBadRequest)
+		return
+	}
+	result := s.Actions.DoContext(r.Context(), "splitflow", "http", func(fireplace.Fireplace) error { return sf.SetSplitFlow(state == "on") })
+	replyWith(w, r, "splitflow", result, map[string]string{"state": state})
+}
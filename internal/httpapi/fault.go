@@ -0,0 +1,45 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// faultHandler shows and clears the fault latched by a panic:
+//
+//	GET    /fault    {"fault": {"where": "/on", "panic": "...", "time": "..."}}, or null
+//	DELETE /fault    clear it once the cause is understood
+func (s *Server) faultHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		if fault.Clear() {
+			logging.Event(logging.Notice, "fault cleared", "from", ClientAddr(r))
+		}
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "fault_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(faultState{fault.Latched()})
+}
+
+// faultState is the reply of /fault.
+type faultState struct {
+	Fault *fault.State `json:"fault"`
+}
+
+// refuseOnFault refuses next's requests while a fault is latched.
+func refuseOnFault(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if fault.Latched() != nil {
+			http.Error(w, "fault", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
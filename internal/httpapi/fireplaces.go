@@ -0,0 +1,109 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/estop"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// Fireplace is one of the further fireplaces of config fireplaces.
+type Fireplace struct {
+	Fire    fireplace.Fireplace
+	Actions *actions.Runner // named after the fireplace
+	Power   *power.Tracker
+}
+
+// fireplaceOps are the commands served for each further fireplace.
+var fireplaceOps = map[string]func(f fireplace.Fireplace) error{
+	"on":        fireplace.Fireplace.On,
+	"off":       fireplace.Fireplace.Off,
+	"flameup":   fireplace.Fireplace.FlameUp,
+	"flamedown": fireplace.Fireplace.FlameDown,
+	"aux":       fireplace.Fireplace.Aux,
+	"pilot":     fireplace.ToPilot,
+	"aux_on":    auxBurner(true),
+	"aux_off":   auxBurner(false),
+}
+
+// fireplaceStatus is a further fireplace's entry in /fireplaces.
+type fireplaceStatus struct {
+	power.State
+	Levels int          `json:"levels,omitempty"`
+	EStop  *estop.State `json:"estop,omitempty"` // while engaged
+}
+
+// fireplaceState returns the further fireplace called name's entry in /fireplaces.
+func (s *Server) fireplaceState(name string, f *Fireplace) fireplaceStatus {
+	st := fireplaceStatus{State: f.Power.State(), Levels: f.Power.Levels().Max}
+	if s.EStop != nil {
+		if e := s.EStop.State(name); e.Engaged {
+			st.EStop = &e
+		}
+	}
+	return st
+}
+
+// fireplacesHandler lists the further fireplaces with their tracked state:
+//
+//	GET /fireplaces    {"den": {"power": "off", "flame_level": 0, ...}, "patio": {...}}
+func (s *Server) fireplacesHandler(w http.ResponseWriter, r *http.Request) {
+	out := map[string]fireplaceStatus{}
+	for name, f := range s.Fireplaces {
+		out[name] = s.fireplaceState(name, f)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// fireplaceHandler runs a command on a further fireplace, each with its own relays and
+// state, replying like the plain-text routes; its status is as in /fireplaces. In safe mode
+// only off, status and the emergency stop are served:
+//
+//	POST /fireplaces/den/on             on_ok   (also off, flameup, flamedown, aux, pilot, aux_on, aux_off)
+//	GET  /fireplaces/den/status         {"power": "on", "flame_level": 6, ...}
+//	POST /fireplaces/den/estop          estop_ok, as /estop for this fireplace alone
+//	POST /fireplaces/den/estop_clear    estop_cleared, or estop_notengaged
+func (s *Server) fireplaceHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/fireplaces/"), "/")
+	if len(parts) == 1 && parts[0] == "" {
+		s.fireplacesHandler(w, r)
+		return
+	}
+	f, ok := s.Fireplaces[parts[0]]
+	if !ok || len(parts) != 2 {
+		http.Error(w, "fireplace_unknown", http.StatusNotFound)
+		return
+	}
+	op := parts[1]
+	if op == "status" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.fireplaceState(parts[0], f))
+		return
+	}
+	if s.EStop != nil && (op == "estop" || op == "estop_clear") {
+		s.fireplaceStopHandler(w, r, parts[0], op)
+		return
+	}
+	run, ok := fireplaceOps[op]
+	if !ok {
+		http.Error(w, "fireplace_badop", http.StatusNotFound)
+		return
+	}
+	if op != "off" && s.SafeMode.Active() {
+		refuseInSafeMode(w, r)
+		return
+	}
+	result := f.Actions.DoContext(r.Context(), op, "http", run)
+	if result == "busy" && s.Busy.Mode == "reject" {
+		s.retryLater(w)
+	}
+	events.RecordContext(r.Context(), f.Actions.Op(op), result, "http", nil)
+	fmt.Fprintf(w, "%s_%s", op, result)
+}
@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// setFlameHandler sets the flame to a level, from 1 (lowest) to the valve's levels, or 0 for
+// the pilot flame: /setflame?level=4 replies setflame_ok, or setflame_unlit when the fire
+// isn't lit. Relay valves get there with one timed pulse, other drivers in flame steps.
+// for= sets the off timer, as for /on.
+func (s *Server) setFlameHandler(w http.ResponseWriter, r *http.Request) {
+	level, err := strconv.Atoi(r.URL.Query().Get("level"))
+	if err != nil || level < 0 || level > s.Power.Levels().Max {
+		http.Error(w, "setflame_badlevel", http.StatusBadRequest)
+		return
+	}
+	d, ok := s.offAfter(w, r, "setflame")
+	if !ok {
+		return
+	}
+	logging.Event(logging.Info, "set flame", "level", strconv.Itoa(level), "from", ClientAddr(r))
+	var result string
+	if s.Flame != nil {
+		result = s.Flame.SetContext(r.Context(), level, "http")
+	} else if gv60.IsDryRun(r.Context()) {
+		// flame steps on other drivers aren't bound to the request
+		http.Error(w, "dryrun_unsupported", http.StatusBadRequest)
+		return
+	} else {
+		result = s.Actions.SetFlame(s.Power, level, "http")
+	}
+	s.startTimer(r, d, result)
+	fmt.Fprintf(w, "setflame_%s", result)
+}
+
+// calibrateHandler measures the valve's travel time, with the fire lit and someone watching
+// it:
+//
+//	GET  /calibrate          {"travel": "12s", "calibrating": "up"} (calibrating only while it is)
+//	POST /calibrate          drive the flame right down and then up; replies calibrate_started
+//	POST /calibrate?done=1   the flame has reached full; replies calibrate_ok with the travel
+//	                         time, e.g. calibrate_ok 11.4s
+func (s *Server) calibrateHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Flame == nil {
+		http.Error(w, "calibrate_unsupported", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Flame.State())
+	case http.MethodPost:
+		if r.URL.Query().Get("done") != "" {
+			travel, err := s.Flame.Finish()
+			switch {
+			case err == flame.ErrNotCalibrating:
+				http.Error(w, "calibrate_idle", http.StatusConflict)
+			case err != nil:
+				http.Error(w, "calibrate_failed "+err.Error(), http.StatusInternalServerError)
+			default:
+				fmt.Fprintf(w, "calibrate_ok %v", travel.Round(10*time.Millisecond))
+			}
+			return
+		}
+		switch err := s.Flame.Calibrate("http"); err {
+		case nil:
+			logging.Event(logging.Notice, "flame calibration started", "from", ClientAddr(r))
+			fmt.Fprintf(w, "calibrate_started")
+		case flame.ErrCalibrating:
+			http.Error(w, "calibrate_busy", http.StatusConflict)
+		default:
+			http.Error(w, "calibrate_unlit", http.StatusConflict)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "calibrate_badmethod", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,174 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/grpcwire"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// grpcService is the path prefix of the gofire.v1.Fireplace methods; see proto/gofire.proto.
+const grpcService = "/gofire.v1.Fireplace/"
+
+// grpcSource is what gRPC commands are recorded as.
+const grpcSource = "grpc"
+
+// grpcHandler serves the gofire.v1.Fireplace gRPC service of proto/gofire.proto. gRPC needs
+// HTTP/2, which is served on the TLS listeners. The token is checked here rather than by the
+// auth middleware, as each method needs its own scope: Command the op's, Status status and
+// Subscribe events. In safe mode only Status, Subscribe and the off command are served:
+//
+//	POST /gofire.v1.Fireplace/Command      CommandRequest{op: "on"} -> CommandReply{op: "on", result: "ok"}
+//	POST /gofire.v1.Fireplace/Status       StatusRequest{} -> Status{power: "on", flame_level: 6, ...}
+//	POST /gofire.v1.Fireplace/Subscribe    SubscribeRequest{} -> stream Event{type: "state", ...}
+func (s *Server) grpcHandler(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "grpc_needs_http2", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	code, message := s.grpcCall(w, r, strings.TrimPrefix(r.URL.Path, grpcService))
+	grpcwire.SetStatus(w, code, message)
+}
+
+// grpcCall runs one method, writing its replies, and returns the gRPC status.
+func (s *Server) grpcCall(w http.ResponseWriter, r *http.Request, method string) (int, string) {
+	req, err := grpcwire.ReadMessage(r.Body, 4<<10)
+	if err != nil {
+		return grpcwire.InvalidArgument, "bad request: " + err.Error()
+	}
+	var scope string
+	var cmd struct {
+		op, pin string
+		level   int
+	}
+	switch method {
+	case "Command":
+		err = grpcwire.Decode(req, func(f grpcwire.Field) error {
+			switch f.Number {
+			case 1:
+				cmd.op = string(f.Bytes)
+			case 2:
+				cmd.pin = string(f.Bytes)
+			case 3:
+				cmd.level = int(int32(f.Varint))
+			}
+			return nil
+		})
+		if err != nil {
+			return grpcwire.InvalidArgument, err.Error()
+		}
+		if cmd.op != "setflame" && !actions.Valid(cmd.op) || strings.HasPrefix(cmd.op, "light") {
+			return grpcwire.InvalidArgument, "unknown op " + strconv.Quote(cmd.op)
+		}
+		scope = cmd.op
+	case "Status":
+		scope = "status"
+	case "Subscribe":
+		scope = "events"
+	default:
+		return grpcwire.Unimplemented, "unknown method " + method
+	}
+	if s.needsToken(r) {
+		if _, ok := s.Auth.Check(requestToken(r), scope); !ok {
+			return grpcwire.Unauthenticated, "unauthorized"
+		}
+	}
+	if s.SafeMode.Active() && method == "Command" && cmd.op != "off" {
+		return grpcwire.FailedPrecondition, "safe mode"
+	}
+	switch method {
+	case "Command":
+		result := s.grpcCommand(r, cmd.op, cmd.pin, cmd.level)
+		var reply []byte
+		reply = grpcwire.AppendString(reply, 1, cmd.op)
+		reply = grpcwire.AppendString(reply, 2, result)
+		if err := grpcwire.WriteMessage(w, reply); err != nil {
+			return grpcwire.Internal, err.Error()
+		}
+	case "Status":
+		if s.Power == nil {
+			return grpcwire.FailedPrecondition, "no state tracked"
+		}
+		if err := grpcwire.WriteMessage(w, grpcStatus(s.Power.State(), s.Power.Levels().Max)); err != nil {
+			return grpcwire.Internal, err.Error()
+		}
+	case "Subscribe":
+		f, ok := w.(http.Flusher)
+		if !ok {
+			return grpcwire.Internal, "streaming unsupported"
+		}
+		f.Flush()
+		logging.Event(logging.Info, "grpc stream opened", "from", ClientAddr(r))
+		send := func(e streamEvent) error {
+			if err := grpcwire.WriteMessage(w, s.grpcEvent(e)); err != nil {
+				return err
+			}
+			f.Flush()
+			return nil
+		}
+		// HTTP/2 has pings of its own
+		keepAlive := func() error { return nil }
+		s.streamEvents(send, keepAlive, s.Events.KeepAlive, r.Context().Done())
+		logging.Event(logging.Info, "grpc stream closed", "from", ClientAddr(r))
+	}
+	return grpcwire.OK, ""
+}
+
+// grpcCommand runs op, checking the PIN for on, and records it; it returns the result.
+func (s *Server) grpcCommand(r *http.Request, op, pin string, level int) string {
+	if op == "on" && s.IgnitionPIN != "" && subtle.ConstantTimeCompare([]byte(pin), []byte(s.IgnitionPIN)) != 1 {
+		logging.Event(logging.Warning, "ignition refused: wrong or missing pin", "from", ClientAddr(r))
+		events.RecordContext(r.Context(), "on", "badpin", grpcSource, nil)
+		return "badpin"
+	}
+	if op != "setflame" {
+		return s.Actions.RunContext(r.Context(), op, grpcSource)
+	}
+	if s.Power == nil || level < 0 || level > s.Power.Levels().Max {
+		return "badlevel"
+	}
+	if s.Flame != nil {
+		return s.Flame.SetContext(r.Context(), level, grpcSource)
+	}
+	return s.Actions.SetFlame(s.Power, level, grpcSource)
+}
+
+// grpcStatus encodes a Status message.
+func grpcStatus(st power.State, levels int) []byte {
+	var b []byte
+	b = grpcwire.AppendString(b, 1, st.Power)
+	if st.FlameLevel != nil {
+		b = grpcwire.AppendVarint(b, 2, uint64(*st.FlameLevel))
+	}
+	b = grpcwire.AppendString(b, 3, st.LastCommand)
+	if !st.Since.IsZero() {
+		b = grpcwire.AppendInt(b, 4, st.Since.UnixNano()/int64(time.Millisecond))
+	}
+	return grpcwire.AppendInt(b, 5, int64(levels))
+}
+
+// grpcEvent encodes an Event message.
+func (s *Server) grpcEvent(e streamEvent) []byte {
+	var b []byte
+	b = grpcwire.AppendString(b, 1, e.Type)
+	b = grpcwire.AppendInt(b, 2, e.Time.UnixNano()/int64(time.Millisecond))
+	b = grpcwire.AppendString(b, 3, e.Op)
+	b = grpcwire.AppendString(b, 4, e.Result)
+	b = grpcwire.AppendString(b, 5, e.Source)
+	if e.State != nil {
+		b = grpcwire.AppendBytes(b, 6, grpcStatus(*e.State, s.Power.Levels().Max))
+	}
+	if e.Level != nil {
+		b = grpcwire.AppendVarint(b, 7, uint64(*e.Level))
+	}
+	return b
+}
@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fireplace"
+)
+
+// probeTimeout bounds how long a probe waits for /status to be put together.
+const probeTimeout = 2 * time.Second
+
+// healthzHandler is a liveness probe for a supervisor, failing when only a restart will help:
+//
+//	GET /healthz    {"status": "ok", "checks": {"engine": "ok", "gpio": "ok", "status": "ok"}}
+//
+// gpio fails when the GPIO chip can't be queried or a relay line is no longer requested as an
+// output; engine (and engine.NAME for each further fireplace) when a contact sequence has
+// wedged the relays; and status when /status can't be put together, as when a lock it needs
+// is stuck. A failing probe replies "failing" with status 503 and the reason for each check.
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	probe(w, s.liveness())
+}
+
+// readyzHandler is a readiness probe: the checks of /healthz, and fault and safe_mode, which
+// fail while a latched fault or safe mode refuses every command but off.
+//
+//	GET /readyz    {"status": "ok", "checks": {"engine": "ok", "fault": "ok", ...}}
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := s.liveness()
+	checks["fault"] = nil
+	if f := fault.Latched(); f != nil {
+		checks["fault"] = errors.New("a fault is latched in " + f.Where)
+	}
+	if s.SafeMode != nil {
+		checks["safe_mode"] = nil
+		if s.SafeMode.Active() {
+			checks["safe_mode"] = errors.New("safe mode is active")
+		}
+	}
+	probe(w, checks)
+}
+
+// liveness runs the checks of /healthz.
+func (s *Server) liveness() map[string]error {
+	checks := map[string]error{"status": s.Healthy(probeTimeout)}
+	if s.Chip != nil {
+		checks["gpio"] = s.Chip.Check()
+	}
+	fires := map[string]fireplace.Fireplace{"engine": s.Fire}
+	for name, fp := range s.Fireplaces {
+		fires["engine."+name] = fp.Fire
+	}
+	for name, f := range fires {
+		if c, ok := f.(fireplace.Checker); ok {
+			checks[name] = c.Check()
+		}
+	}
+	return checks
+}
+
+// probeReply is the reply of /healthz and /readyz.
+type probeReply struct {
+	Status string            `json:"status"` // ok or failing
+	Checks map[string]string `json:"checks"` // ok, or why the check failed
+}
+
+// probe replies with the outcome of checks, with status 503 if any failed.
+func probe(w http.ResponseWriter, checks map[string]error) {
+	reply := probeReply{Status: "ok", Checks: map[string]string{}}
+	for name, err := range checks {
+		reply.Checks[name] = "ok"
+		if err != nil {
+			reply.Status, reply.Checks[name] = "failing", err.Error()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if reply.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(reply)
+}
@@ -0,0 +1,28 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// heartbeatHandler takes the supervisor's heartbeats:
+//
+//	GET  /heartbeat    {"last": "...", "deadline": "..."} (deadline only while the fire is on)
+//	POST /heartbeat    record a heartbeat
+func (s *Server) heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Heartbeat == nil {
+		http.Error(w, "heartbeat_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		s.Heartbeat.Beat()
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "heartbeat_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Heartbeat.State())
+}
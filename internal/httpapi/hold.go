@@ -0,0 +1,54 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// maxHold caps a hold so a typo can't switch automation off for good.
+const maxHold = 30 * 24 * time.Hour
+
+// holdHandler pauses and resumes automation:
+//
+//	GET    /hold                           {"paused": true, "until": "...", "reason": "guests"}
+//	POST   /hold?for=3h&reason=guests      pause for a duration (or until=2006-01-02T15:04:05Z07:00)
+//	DELETE /hold                           resume now
+func (s *Server) holdHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var until time.Time
+		if v := q.Get("until"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil || !t.After(time.Now()) || time.Until(t) > maxHold {
+				http.Error(w, "hold_baduntil", http.StatusBadRequest)
+				return
+			}
+			until = t
+		} else {
+			d, err := time.ParseDuration(q.Get("for"))
+			if err != nil || d <= 0 || d > maxHold {
+				http.Error(w, "hold_badfor", http.StatusBadRequest)
+				return
+			}
+			until = time.Now().Add(d)
+		}
+		s.Hold.Pause(until, q.Get("reason"))
+		logging.Event(logging.Notice, "automation paused", "until", until.Format(time.RFC3339),
+			"reason", q.Get("reason"), "from", ClientAddr(r))
+	case http.MethodDelete:
+		if s.Hold.Resume() {
+			logging.Event(logging.Notice, "automation resumed", "from", ClientAddr(r))
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "hold_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Hold.State())
+}
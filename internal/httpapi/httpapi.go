@@ -0,0 +1,527 @@
+// Package httpapi serves GoFire's plain-text control endpoints and JSON sensor endpoints.
+package httpapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/accessory"
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/alexa"
+	"github.com/barrylb/go-fire/internal/audit"
+	"github.com/barrylb/go-fire/internal/auth"
+	"github.com/barrylb/go-fire/internal/automation"
+	"github.com/barrylb/go-fire/internal/autooff"
+	"github.com/barrylb/go-fire/internal/backup"
+	"github.com/barrylb/go-fire/internal/childlock"
+	"github.com/barrylb/go-fire/internal/clock"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/demand"
+	"github.com/barrylb/go-fire/internal/estop"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/frost"
+	"github.com/barrylb/go-fire/internal/google"
+	"github.com/barrylb/go-fire/internal/heartbeat"
+	"github.com/barrylb/go-fire/internal/history"
+	"github.com/barrylb/go-fire/internal/ignition"
+	"github.com/barrylb/go-fire/internal/interlock"
+	"github.com/barrylb/go-fire/internal/light"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/metrics"
+	"github.com/barrylb/go-fire/internal/modulate"
+	"github.com/barrylb/go-fire/internal/offtimer"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/presence"
+	"github.com/barrylb/go-fire/internal/presets"
+	"github.com/barrylb/go-fire/internal/profiles"
+	"github.com/barrylb/go-fire/internal/quiet"
+	"github.com/barrylb/go-fire/internal/ramp"
+	"github.com/barrylb/go-fire/internal/resync"
+	"github.com/barrylb/go-fire/internal/rules"
+	"github.com/barrylb/go-fire/internal/safemode"
+	"github.com/barrylb/go-fire/internal/selftest"
+	"github.com/barrylb/go-fire/internal/selfupdate"
+	"github.com/barrylb/go-fire/internal/sensor"
+	"github.com/barrylb/go-fire/internal/thermostat"
+	"github.com/barrylb/go-fire/internal/usage"
+	"github.com/barrylb/go-fire/internal/wear"
+	"github.com/barrylb/go-fire/internal/webhooks"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// Server holds what the handlers control; Light and History are nil when not configured.
+type Server struct {
+	Fire  fireplace.Fireplace
+	Light *light.Controller
+	// Accessories is nil unless accessories are set.
+	Accessories *accessory.Bank
+	Sensors     *sensor.Registry
+	History     *history.Store
+	Auth        *auth.Store
+	Actions     *actions.Runner
+	// Profiles is nil unless auth.profiles_file is set.
+	Profiles *profiles.Store
+	// IgnitionPIN, when set, must accompany every /on request.
+	IgnitionPIN string
+	// ConfirmIgnition, when set, holds ignition until /confirm follows within it.
+	ConfirmIgnition time.Duration
+	// Rules is nil unless rules_file is set.
+	Rules *rules.Engine
+	// Demand is nil unless demand_response is configured.
+	Demand *demand.Signal
+	// Power tracks the fireplace's state for /status.
+	Power *power.Tracker
+	// Thermostat is nil unless thermostat is set.
+	Thermostat *thermostat.Thermostat
+	// Frost is nil unless frost_protection is set.
+	Frost *frost.Guard
+	// Hold pauses automation.
+	Hold *automation.Hold
+	// Updater is nil unless self_update is set.
+	Updater *selfupdate.Updater
+	// Flame is nil unless the driver can set the flame with timed pulses.
+	Flame *flame.Control
+	// AutoOff is nil when auto_off is disabled.
+	AutoOff *autooff.Timer
+	// Google is nil unless google is set.
+	Google *google.Fulfillment
+	// Alexa is nil unless alexa is set.
+	Alexa *alexa.Skill
+	// Ignition is nil unless ignition is set.
+	Ignition *ignition.Verifier
+	// Interlocks is nil unless interlocks are set.
+	Interlocks *interlock.Inputs
+	// Fireplaces are the further fireplaces, by name.
+	Fireplaces map[string]*Fireplace
+	// Presence is nil unless presence is set.
+	Presence *presence.Detector
+	// Quiet is nil unless quiet_hours is set.
+	Quiet *quiet.Hours
+	// Webhooks is nil unless webhooks is set.
+	Webhooks *webhooks.Notifier
+	// Presets is nil unless presets is set.
+	Presets *presets.Store
+	// Ramp moves the flame gradually; nil disables /ramp.
+	Ramp *ramp.Ramper
+	// Modulate cycles the burner on a duty cycle; nil disables /modulate.
+	Modulate *modulate.Modulator
+	// Resync drives the flame right down in the background; nil disables /resync.
+	Resync *resync.Resyncer
+	// Audit is nil unless audit is set.
+	Audit *audit.Trail
+	// Lock is nil unless lock is set.
+	Lock *childlock.Lock
+	// EStop is the emergency stop of every fireplace.
+	EStop *estop.Latch
+	// Reload reads the configuration file again (see /reload); nil unless there is one.
+	Reload func(ctx context.Context) (Reloaded, error)
+	// Backup archives and restores the configuration and state (see /backup); nil unless
+	// there is a configuration file.
+	Backup *backup.Archiver
+	// SelfTest is nil unless the valve is driven by relays.
+	SelfTest *selftest.Tester
+	// Timer turns the fire off after for= on a command; nil refuses for=.
+	Timer *offtimer.Timer
+	// Heartbeat is nil unless heartbeat is set.
+	Heartbeat *heartbeat.Monitor
+	// SafeMode is nil unless safe_mode is set; while it is active only safeRoutes are served.
+	SafeMode *safemode.Guard
+	// Chip is the GPIO chip of the relay lines, checked by /healthz; nil skips the check.
+	Chip *relay.Chip
+	// Wear is nil unless valve.wear is set.
+	Wear *wear.Counter
+	// Usage is nil unless usage is set.
+	Usage *usage.Meter
+	// Clock is nil unless the rules run against a simulated clock.
+	Clock *clock.Sim
+	// Busy is what commands do while the relays are busy.
+	Busy config.Busy
+	// Events configures the /events stream.
+	Events config.Events
+	// SwaggerUI is where /docs loads Swagger UI from; empty disables /docs.
+	SwaggerUI string
+
+	confirms confirmations
+
+	queueOnce sync.Once
+	queueWake chan struct{}
+	queueMu   sync.Mutex
+	queue     []queuedCommand
+
+	streams streams
+}
+
+// routes returns every route pattern with its handler.
+func (s *Server) routes() map[string]http.HandlerFunc {
+	routes := map[string]http.HandlerFunc{
+		"/":             s.homeHandler,
+		"/status":       s.statusHandler,
+		"/healthz":      s.healthzHandler,
+		"/readyz":       s.readyzHandler,
+		"/off":          s.commandHandler("off", fireplace.Fireplace.Off),
+		"/on":           s.requirePIN(s.requireConfirm(false, s.commandHandler("on", fireplace.Fireplace.On))),
+		"/confirm":      s.confirmHandler,
+		"/flameup":      s.commandHandler("flameup", fireplace.Fireplace.FlameUp),
+		"/flamedown":    s.commandHandler("flamedown", fireplace.Fireplace.FlameDown),
+		"/aux":          s.commandHandler("aux", fireplace.Fireplace.Aux),
+		"/setflame":     s.setFlameHandler,
+		"/ensure_on":    s.requirePIN(s.requireConfirm(false, s.ensureHandler(true))),
+		"/ensure_off":   s.ensureHandler(false),
+		"/ensure_level": s.ensureLevelHandler,
+		"/ramp":         s.rampHandler,
+		"/modulate":     s.modulateHandler,
+		"/calibrate":    s.calibrateHandler,
+		"/resync":       s.resyncHandler,
+		"/pilot":        s.commandHandler("pilot", fireplace.ToPilot),
+		"/aux_on":       s.commandHandler("aux_on", auxBurner(true)),
+		"/aux_off":      s.commandHandler("aux_off", auxBurner(false)),
+		"/fan":          s.fanHandler,
+		"/splitflow":    s.splitFlowHandler,
+		"/cancel":       s.cancelHandler,
+		"/estop":        s.estopHandler,
+		"/estop/clear":  s.estopClearHandler,
+		"/cancel_timer": s.cancelTimerHandler,
+		"/lock":         s.lockHandler(true),
+		"/unlock":       s.lockHandler(false),
+		"/reload":       s.reloadHandler,
+		"/undo":         s.undoHandler,
+		"/hold":         s.holdHandler,
+		"/settemp":      s.setTempHandler,
+		"/climate":      s.climateHandler,
+		"/demand":       s.demandHandler,
+		"/rules":        s.rulesHandler,
+		"/queue":        s.queueHandler,
+		"/rules/hook":   s.ruleHookHandler,
+		"/clock":        s.clockHandler,
+		"/relays":       s.relaysHandler,
+		"/selftest":     s.selfTestHandler,
+		"/light":        s.lightHandler,
+		"/accessory":    s.accessoriesHandler,
+		"/accessory/":   s.accessoryHandler,
+		"/sensors":      s.sensorsHandler,
+		"/sensors/feed": s.feedHandler,
+		"/history":      s.historyHandler,
+		"/metrics":      s.metricsHandler,
+		"/ws":           s.wsHandler,
+		"/events":       s.eventsHandler,
+		"/commands":     s.commandsHandler,
+		"/audit":        s.auditHandler,
+		"/tokens":       s.tokensHandler,
+		"/sign":         s.signHandler,
+		"/action":       s.actionHandler,
+		"/profile":      s.profileHandler,
+		"/safemode":     s.safeModeHandler,
+		"/fault":        s.faultHandler,
+		"/heartbeat":    s.heartbeatHandler,
+		"/autooff":      s.autoOffHandler,
+		"/update":       s.updateHandler,
+		"/backup":       s.backupHandler,
+		"/restore":      s.restoreHandler,
+		"/google":       s.googleHandler,
+		"/alexa":        s.alexaHandler,
+		"/fireplaces":   s.fireplacesHandler,
+		"/fireplaces/":  s.fireplaceHandler,
+		"/usage":        s.usageHandler,
+		"/presence":     s.presenceHandler,
+		"/quiet":        s.quietHandler,
+		"/webhooks":     s.webhooksHandler,
+		"/presets":      s.presetsHandler,
+		"/preset/":      s.requirePIN(s.requireConfirm(false, s.presetHandler)),
+		"/openapi.json": s.openAPIHandler,
+		"/docs":         s.docsHandler,
+		grpcService:     s.grpcHandler,
+	}
+	for route, h := range s.v1Routes() {
+		routes[route] = h
+	}
+	return routes
+}
+
+// legacyRoutes are the plain-text command routes, not served with
+// http.disable_legacy_text; the v1 API replaces them.
+var legacyRoutes = []string{"/on", "/off", "/flameup", "/flamedown", "/pilot", "/aux", "/aux_on", "/aux_off", "/fan", "/splitflow", "/undo"}
+
+// safeRoutes are those served in safe mode or with a fault latched: turning the fire off
+// and diagnostics.
+var safeRoutes = map[string]bool{
+	"/":            true,
+	"/status":      true,
+	"/healthz":     true,
+	"/readyz":      true,
+	"/off":         true,
+	"/cancel":      true,
+	"/estop":       true,
+	"/estop/clear": true,
+	"/ensure_off":  true,
+	"/lock":        true,
+	"/unlock":      true,
+	"/sensors":     true,
+	"/history":     true,
+	"/audit":       true,
+	"/metrics":     true,
+	"/ws":          true,
+	"/events":      true,
+	"/relays":      true,
+	"/safemode":    true,
+	"/fault":       true,
+	"/heartbeat":   true,
+	// fireplaceHandler serves only off and status in safe mode
+	"/fireplaces":   true,
+	"/fireplaces/":  true,
+	grpcService:     true,
+	"/openapi.json": true,
+	"/docs":         true,
+
+	"/api/v1/command/off": true,
+	"/api/v1/status":      true,
+	"/api/v1/commands":    true,
+}
+
+// Handler returns a router serving the enabled routes (all when enabled is empty), each
+// wrapped in the configured global middleware followed by any configured for that route.
+func (s *Server) Handler(cfg config.HTTP, enabled []string) (http.Handler, error) {
+	routes := s.routes()
+	if cfg.DisableLegacyText {
+		for _, route := range legacyRoutes {
+			delete(routes, route)
+		}
+	}
+	if len(enabled) > 0 {
+		all := routes
+		routes = map[string]http.HandlerFunc{}
+		for _, route := range enabled {
+			if all[route] == nil {
+				return nil, fmt.Errorf("http: unknown route %q", route)
+			}
+			routes[route] = all[route]
+		}
+	}
+	if routes["/openapi.json"] != nil {
+		routes["/openapi.json"] = openAPIFor(routes, cfg.BasePath)
+	}
+	mw := cfg.Middleware
+	if s.Auth != nil && s.Auth.Enabled() && !usesMiddleware(cfg, "auth") {
+		// tokens are set, so leaving the routes open can't be what was meant
+		logging.Logf(logging.Notice, "auth: tokens are set but no route uses the auth middleware; applying it to every route")
+		mw = append(mw[:len(mw):len(mw)], "auth")
+	}
+	global, err := s.chain(mw, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for route := range cfg.Routes {
+		if s.routes()[route] == nil {
+			return nil, fmt.Errorf("http: middleware configured for unknown route %q", route)
+		}
+	}
+	mux := http.NewServeMux()
+	for route, h := range routes {
+		local, err := s.chain(cfg.Routes[route], cfg)
+		if err != nil {
+			return nil, err
+		}
+		h = dryRun(route, h)
+		if !safeRoutes[route] {
+			if s.SafeMode.Active() {
+				h = refuseInSafeMode
+			} else {
+				h = refuseOnFault(h)
+			}
+			h = s.refuseWhileLocked(h)
+		}
+		ms := append(append([]Middleware{}, global...), local...)
+		if s.Auth != nil && routeScope(route) == auth.ScopeAdmin && !contains(mw, "auth") && !contains(cfg.Routes[route], "auth") {
+			// admin routes need an admin token whatever the middleware says
+			ms = append([]Middleware{s.requireToken}, ms...)
+		}
+		mux.Handle(route, wrap(route, h, ms))
+	}
+	var handler http.Handler = mux
+	if base := strings.TrimSuffix(cfg.BasePath, "/"); base != "" {
+		handler = http.StripPrefix(base, handler)
+	}
+	p, err := newProxyAware(cfg.TrustedProxies, handler)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// reply writes the plain-text "op_result" response, and counts, logs and publishes the
+// command outcome.
+func reply(w http.ResponseWriter, r *http.Request, op, result string) {
+	replyWith(w, r, op, result, nil)
+}
+
+// replyWith is reply for a command with arguments, which are recorded with it.
+func replyWith(w http.ResponseWriter, r *http.Request, op, result string, params map[string]string) {
+	events.RecordContext(r.Context(), op, result, "http", params)
+	fmt.Fprintf(w, "%s_%s", op, result)
+}
+
+// commandHandler runs one GV60 contact sequence and replies op_ok, op_busy or op_lockout,
+// or op_queued in queue mode. The sequence is cut short, and recorded as abandoned, if the
+// client goes away before it ends. on, flameup and flamedown take for= (see timedOps).
+func (s *Server) commandHandler(op string, run func(f fireplace.Fireplace) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := s.offAfter(w, r, op)
+		if !ok {
+			return
+		}
+		if s.Busy.Mode == "queue" {
+			if s.enqueue(w, r, op, run) {
+				s.startTimer(r, d, "queued")
+			}
+			return
+		}
+		result := s.Actions.DoContext(r.Context(), op, "http", run)
+		if result == "busy" {
+			s.replyBusy(w, r, op)
+			return
+		}
+		s.startTimer(r, d, result)
+		reply(w, r, op, result)
+	}
+}
+
+// requirePIN refuses ignition with on_badpin unless ?pin= matches the configured PIN, a
+// human-presence check so that a misfiring automation holding a valid token can't light
+// the fire on its own.
+func (s *Server) requirePIN(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.IgnitionPIN != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("pin")), []byte(s.IgnitionPIN)) != 1 {
+			logging.Event(logging.Warning, "ignition refused: wrong or missing pin", "from", ClientAddr(r))
+			events.RecordContext(r.Context(), "on", "badpin", "http", nil)
+			http.Error(w, "on_badpin", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) lightHandler(w http.ResponseWriter, r *http.Request) {
+	// LIGHT: independent of the GV60 contacts, so it does not take the relay semaphore
+	if s.Light == nil {
+		fmt.Fprintf(w, "light_disabled")
+		return
+	}
+	q := r.URL.Query()
+	brightness := -1
+	if v := q.Get("brightness"); v != "" {
+		b, err := strconv.Atoi(v)
+		if err != nil || b < 0 || b > 100 {
+			http.Error(w, "light_badbrightness", http.StatusBadRequest)
+			return
+		}
+		brightness = b
+	}
+	fade := time.Duration(-1)
+	if v := q.Get("fade"); v != "" {
+		var err error
+		if fade, err = time.ParseDuration(v); err != nil || fade < 0 {
+			http.Error(w, "light_badfade", http.StatusBadRequest)
+			return
+		}
+	}
+	// the emergency stop leaves the light to be switched off only, as the runner's light_off
+	if off := brightness == 0 || q.Get("state") == "off" && brightness < 0; !off && s.EStop != nil {
+		if err := s.EStop.Guard("")("light", "http"); err != nil {
+			http.Error(w, "light_lockout", http.StatusConflict)
+			return
+		}
+	}
+	target, err := s.Light.Set(q.Get("state"), brightness, fade)
+	if err != nil {
+		http.Error(w, "light_badstate", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case target == 0:
+		fmt.Fprintf(w, "light_off")
+	case s.Light.Dimmable():
+		fmt.Fprintf(w, "light_on %d", target)
+	default:
+		fmt.Fprintf(w, "light_on")
+	}
+}
+
+func (s *Server) sensorsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Sensors.Readings())
+}
+
+// metricsHandler serves every metric in the Prometheus text format, for scraping.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.WritePrometheus(w, s.Sensors, s.Light)
+}
+
+// historyHandler returns recorded raw samples for ?sensor=name over the last ?since=24h.
+func (s *Server) historyHandler(w http.ResponseWriter, r *http.Request) {
+	if s.History == nil {
+		fmt.Fprintf(w, "history_disabled")
+		return
+	}
+	since := 24 * time.Hour
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = time.ParseDuration(v); err != nil {
+			http.Error(w, "history_badsince", http.StatusBadRequest)
+			return
+		}
+	}
+	samples, err := s.History.Query(r.URL.Query().Get("sensor"), time.Now().Add(-since))
+	if err != nil {
+		logging.Logf(logging.Err, "history: %v", err)
+		http.Error(w, "history_error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(samples)
+}
+
+// usesMiddleware reports whether name is configured globally or for any route.
+func usesMiddleware(cfg config.HTTP, name string) bool {
+	for _, names := range append([][]string{cfg.Middleware}, routeLists(cfg)...) {
+		if contains(names, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether names lists name.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func routeLists(cfg config.HTTP) [][]string {
+	var out [][]string
+	for _, names := range cfg.Routes {
+		out = append(out, names)
+	}
+	return out
+}
+
+// homeHandler serves the web UI to browsers and lists the routes to anything else.
+func (s *Server) homeHandler(w http.ResponseWriter, r *http.Request) {
+	if wantsUI(r) {
+		serveUI(w)
+		return
+	}
+	fmt.Fprintf(w, "Welcome to GoFire server. Supported handlers: /status /healthz /readyz /off /on /confirm /flameup /flamedown /pilot /setflame /ensure_on /ensure_off /ensure_level /ramp /modulate /calibrate /resync /aux /aux_on /aux_off /fan /splitflow /cancel /cancel_timer /estop /estop/clear /lock /unlock /reload /queue /undo /hold /settemp /climate /demand /rules /clock /relays /selftest /light /accessory /sensors /sensors/feed /history /metrics /ws /events /commands /audit /tokens /sign /action /profile /safemode /fault /heartbeat /autooff /presence /quiet /usage /webhooks /presets /preset /update /backup /restore /google /alexa /fireplaces /openapi.json /docs")
+}
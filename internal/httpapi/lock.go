@@ -0,0 +1,75 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/childlock"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// lockHandler returns the handler of /lock (locked) or /unlock, for the child lock:
+//
+//	GET  /lock              {"locked": true, "since": "...", "by": "192.168.1.20"}
+//	POST /lock?pin=1234     lock it; replies lock_ok
+//	POST /unlock?pin=1234   unlock it; replies unlock_ok
+//
+// A wrong PIN replies lock_badpin (or unlock_badpin) with status 403, and after five in a
+// row lock_wait with status 429 for a minute. While it is locked, commands but off reply
+// lockout whoever sends them, and routes other than off, cancel, lock, unlock and the
+// diagnostics reply locked with status 423.
+func (s *Server) lockHandler(locked bool) http.HandlerFunc {
+	op := "unlock"
+	if locked {
+		op = "lock"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Lock == nil {
+			http.Error(w, op+"_disabled", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.Lock.State())
+			return
+		case http.MethodPost:
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, op+"_badmethod", http.StatusMethodNotAllowed)
+			return
+		}
+		switch err := s.Lock.Set(locked, r.URL.Query().Get("pin"), ClientAddr(r)); err {
+		case nil:
+		case childlock.ErrPIN:
+			events.RecordContext(r.Context(), op, "badpin", "http", nil)
+			http.Error(w, op+"_badpin", http.StatusForbidden)
+			return
+		case childlock.ErrWait:
+			http.Error(w, op+"_wait", http.StatusTooManyRequests)
+			return
+		default:
+			logging.Logf(logging.Err, "lock: %v", err)
+			http.Error(w, op+"_error", http.StatusInternalServerError)
+			return
+		}
+		events.RecordContext(r.Context(), op, "ok", "http", nil)
+		fmt.Fprintf(w, "%s_ok", op)
+	}
+}
+
+// refuseWhileLocked refuses next's requests while the child lock is locked.
+func (s *Server) refuseWhileLocked(next http.HandlerFunc) http.HandlerFunc {
+	if s.Lock == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Lock.State().Locked {
+			http.Error(w, "locked", http.StatusLocked)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,432 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/auth"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/metrics"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// Middleware wraps the handler for route (the registered pattern, e.g. "/on").
+type Middleware func(route string, next http.Handler) http.Handler
+
+// middlewares builds each named middleware from the configuration. Every use in the config
+// gets its own instance, so a per-route rate limit is independent of the global one.
+var middlewares = map[string]func(s *Server, cfg config.HTTP) Middleware{
+	"log":       func(*Server, config.HTTP) Middleware { return accessLog },
+	"metrics":   func(*Server, config.HTTP) Middleware { return countRequests },
+	"cors":      func(_ *Server, cfg config.HTTP) Middleware { return corsHeaders(cfg.CORS) },
+	"ratelimit": func(_ *Server, cfg config.HTTP) Middleware { return newRateLimiter(cfg.RateLimit).wrap },
+	"commandlimit": func(_ *Server, cfg config.HTTP) Middleware {
+		return newRateLimiter(cfg.CommandRateLimit).commands
+	},
+	"auth": func(s *Server, _ config.HTTP) Middleware { return s.requireToken },
+}
+
+// chain builds the named middleware, outermost first.
+func (s *Server) chain(names []string, cfg config.HTTP) ([]Middleware, error) {
+	var out []Middleware
+	for _, n := range names {
+		m, ok := middlewares[n]
+		if !ok {
+			return nil, fmt.Errorf("http: unknown middleware %q", n)
+		}
+		out = append(out, m(s, cfg))
+	}
+	return out, nil
+}
+
+// wrap applies ms to h so that ms[0] runs first.
+func wrap(route string, h http.Handler, ms []Middleware) http.Handler {
+	for i := len(ms) - 1; i >= 0; i-- {
+		h = ms[i](route, h)
+	}
+	return recoverPanics(route, h)
+}
+
+// recoverPanics latches a fault, opening the contacts, if the handler panics, and replies
+// with a 500 so the server carries on serving.
+func recoverPanics(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				fault.Trip(route, v, debug.Stack())
+				http.Error(w, "fault", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush sends /events as they happen.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over to /ws.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func record(w http.ResponseWriter, next http.Handler, r *http.Request) int {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(rec, r)
+	return rec.status
+}
+
+func accessLog(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		status := record(w, next, r)
+		logging.Event(logging.Info, "request", "method", r.Method, "path", r.URL.Path,
+			"status", fmt.Sprint(status), "remote", ClientAddr(r), "duration", time.Since(start).String())
+	})
+}
+
+func countRequests(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.CountRequest(route, record(w, next, r))
+	})
+}
+
+// corsHeaders lets browser dashboards on the allowed origins call the API.
+func corsHeaders(cfg config.CORS) Middleware {
+	return func(route string, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && originAllowed(cfg.AllowedOrigins, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func originAllowed(allowed []string, origin string) bool {
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimiter is a token bucket per client address.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(cfg config.RateLimit) *rateLimiter {
+	return &rateLimiter{rate: cfg.PerMinute / 60, burst: float64(cfg.Burst), buckets: map[string]*bucket{}}
+}
+
+func (l *rateLimiter) allow(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b := l.buckets[client]
+	if b == nil {
+		// a few hundred LAN clients at most, but don't let the map grow without bound
+		if len(l.buckets) > 1000 {
+			l.buckets = map[string]*bucket{}
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *rateLimiter) wrap(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(ClientAddr(r)) {
+			http.Error(w, "rate_limited", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// commandRoutes are those limited by commandlimit: every route that works the valve, bar
+// turning the fire off, which is never held back. The voice assistants' routes also carry
+// state queries, so they are left to valve.debounce.
+var commandRoutes = map[string]bool{
+	"/on": true, "/flameup": true, "/flamedown": true, "/aux": true, "/setflame": true, "/ramp": true, "/modulate": true,
+	"/pilot": true, "/aux_on": true, "/aux_off": true, "/fan": true, "/splitflow": true,
+	"/undo": true, "/action": true, "/confirm": true, "/ensure_on": true, "/ensure_level": true, "/resync": true,
+	"/preset/": true,
+
+	"/api/v1/command/on": true, "/api/v1/command/flameup": true, "/api/v1/command/flamedown": true,
+	"/api/v1/command/aux": true, "/api/v1/command/pilot": true, "/api/v1/command/aux_on": true,
+	"/api/v1/command/aux_off": true, "/api/v1/command/fan": true, "/api/v1/command/splitflow": true,
+	"/api/v1/undo": true,
+}
+
+// dryRunRoutes are the command routes that can be dry runs: those whose sequences are bound
+// to their request. fan and splitflow are left out, as only drivers that can't dry-run have
+// them.
+var dryRunRoutes = map[string]bool{
+	"/on": true, "/off": true, "/flameup": true, "/flamedown": true, "/aux": true, "/setflame": true,
+	"/pilot": true, "/aux_on": true, "/aux_off": true, "/fireplaces/": true,
+	"/ensure_on": true, "/ensure_off": true, "/ensure_level": true, "/resync": true,
+
+	"/api/v1/command/on": true, "/api/v1/command/off": true, "/api/v1/command/flameup": true,
+	"/api/v1/command/flamedown": true, "/api/v1/command/aux": true, "/api/v1/command/pilot": true,
+	"/api/v1/command/aux_on": true, "/api/v1/command/aux_off": true,
+}
+
+// dryRun makes a request with ?dryrun=1 a dry run (see gv60.DryRun), going through
+// everything a command does but the relays themselves. Other routes refuse it with
+// dryrun_unsupported, and drivers that can't dry-run fail it as unsupported, rather than
+// carry the command out.
+func dryRun(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("dryrun") {
+		case "", "0", "false":
+		case "1", "true":
+			if !dryRunRoutes[route] {
+				http.Error(w, "dryrun_unsupported", http.StatusBadRequest)
+				return
+			}
+			r = r.WithContext(gv60.DryRun(r.Context()))
+		default:
+			http.Error(w, "dryrun_bad", http.StatusBadRequest)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// commands limits the command routes, leaving the others alone; one limiter serves every
+// route it wraps, so a client's commands share a bucket whichever route they use.
+func (l *rateLimiter) commands(route string, next http.Handler) http.Handler {
+	if !commandRoutes[route] {
+		return next
+	}
+	return l.wrap(route, next)
+}
+
+// routeScopes overrides the token scope needed for a route, which is otherwise the route
+// name without its slash ("/on" needs "on"). An empty scope is public.
+var routeScopes = map[string]string{
+	"/":             "",
+	"/tokens":       auth.ScopeAdmin,
+	"/sign":         auth.ScopeAdmin,
+	"/action":       "", // the signature is the credential
+	"/profile":      "", // any valid token, checked by the handler
+	"/rules/hook":   "rules_hook",
+	"/sensors/feed": "feed",
+	"/ensure_on":    "on",
+	"/confirm":      "on",
+	"/estop/clear":  "estop",
+	"/ensure_off":   "off",
+	"/ensure_level": "setflame",
+	"/climate":      "settemp",
+	"/clock":        auth.ScopeAdmin,
+	"/safemode":     auth.ScopeAdmin,
+	"/reload":       auth.ScopeAdmin,
+	"/selftest":     auth.ScopeAdmin,
+	"/fault":        auth.ScopeAdmin,
+	"/update":       auth.ScopeAdmin,
+	"/backup":       auth.ScopeAdmin, // the archive holds the tokens and HomeKit keys
+	"/restore":      auth.ScopeAdmin,
+	"/webhooks":     auth.ScopeAdmin, // hook URLs and headers carry credentials
+	"/fireplaces/":  "fireplaces",
+	"/accessory/":   "accessory",
+	"/preset/":      "preset",
+	"/openapi.json": "",
+	"/healthz":      "", // probes carry no token
+	"/readyz":       "",
+	"/docs":         "",
+	grpcService:     "", // checked by the handler, per method
+}
+
+func routeScope(route string) string {
+	if sc, ok := routeScopes[route]; ok {
+		return sc
+	}
+	// v1 routes need the scope of their plain-text counterparts
+	for _, prefix := range []string{"/api/v1/command/", "/api/v1/"} {
+		if strings.HasPrefix(route, prefix) {
+			return routeScope("/" + strings.TrimPrefix(route, prefix))
+		}
+	}
+	return strings.TrimPrefix(route, "/")
+}
+
+// requestToken returns the bearer token, or the token query parameter for clients that
+// can only fetch a URL.
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// trustedKey marks the context of a request to a trusted listener.
+type trustedKey struct{}
+
+// Trusted serves next as a trusted listener's handler: its requests need no token, as on a
+// loopback or Unix socket admin listener beside a LAN listener that needs them.
+func Trusted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), trustedKey{}, true)))
+	})
+}
+
+// needsToken reports whether r must carry a token: auth is on and r didn't come through a
+// trusted listener.
+func (s *Server) needsToken(r *http.Request) bool {
+	return s.Auth != nil && s.Auth.Enabled() && r.Context().Value(trustedKey{}) == nil
+}
+
+// requireToken rejects requests without a token granting the route's scope.
+func (s *Server) requireToken(route string, next http.Handler) http.Handler {
+	scope := routeScope(route)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.needsToken(r) || scope == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := s.Auth.Check(requestToken(r), scope); !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gofire"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientAddr is the address of the client that made the request: the remote IP, or the
+// address forwarded by a trusted proxy. Commands run with the request's context are recorded
+// with it.
+func ClientAddr(r *http.Request) string {
+	if a := events.Client(r.Context()); a != "" {
+		return a
+	}
+	return remoteIP(r)
+}
+
+// remoteIP is the request's remote IP without the port, or "unix" over a Unix socket.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+			return "unix"
+		}
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// proxyAware records each request's client address, taken from X-Forwarded-For or
+// X-Real-IP when the request comes from a trusted proxy.
+type proxyAware struct {
+	trusted []*net.IPNet
+	next    http.Handler
+}
+
+func newProxyAware(cidrs []string, next http.Handler) (*proxyAware, error) {
+	p := &proxyAware{next: next}
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			if strings.Contains(c, ":") {
+				c += "/128"
+			} else {
+				c += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("http: trusted_proxies: %v", err)
+		}
+		p.trusted = append(p.trusted, n)
+	}
+	return p, nil
+}
+
+func (p *proxyAware) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *proxyAware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := remoteIP(r)
+	if p.isTrusted(client) {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			// each proxy appends the address it received from; the rightmost address not
+			// belonging to a trusted proxy is the client, anything left of it could be forged
+			hops := strings.Split(xff, ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				client = strings.TrimSpace(hops[i])
+				if !p.isTrusted(client) {
+					break
+				}
+			}
+		} else if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); real != "" {
+			client = real
+		}
+	}
+	p.next.ServeHTTP(w, r.WithContext(events.WithClient(r.Context(), client)))
+}
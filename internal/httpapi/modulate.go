@@ -0,0 +1,66 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/modulate"
+)
+
+// modulateHandler cycles the burner on a duty cycle, in the background, for less heat than
+// the lowest flame gives:
+//
+//	GET    /modulate?duty=40&period=15m     burn at the lowest flame 40% of every 15 minutes; replies modulate_started
+//	GET    /modulate?duty=40&level=2        or cycle the flame between levels 2 and 3 (period defaults to 15m)
+//	GET    /modulate                        {"duty": 40, "period": "15m0s", "cycle": "15m0s", "phase": "high", ...}, or null
+//	DELETE /modulate                        stop modulating, leaving the fire as it is; replies modulate_stopped, or modulate_idle
+//
+// duty is 1 to 99. Each phase lasts at least modulation.min_on or min_off, the cycle being
+// stretched to keep the duty. It replies modulate_unlit when the fire isn't lit. /cancel stops
+// it too, as does any other command changing the fire.
+func (s *Server) modulateHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Modulate == nil {
+		http.Error(w, "modulate_disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodDelete:
+		if !s.Modulate.Stop("stopped from " + ClientAddr(r)) {
+			fmt.Fprintf(w, "modulate_idle")
+			return
+		}
+		fmt.Fprintf(w, "modulate_stopped")
+	case q.Get("duty") == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Modulate.State())
+	default:
+		duty, err := strconv.Atoi(q.Get("duty"))
+		if err != nil || duty < 1 || duty > 99 {
+			http.Error(w, "modulate_badduty", http.StatusBadRequest)
+			return
+		}
+		period := 15 * time.Minute
+		if v := q.Get("period"); v != "" {
+			if period, err = time.ParseDuration(v); err != nil || period <= 0 || period > 24*time.Hour {
+				http.Error(w, "modulate_badperiod", http.StatusBadRequest)
+				return
+			}
+		}
+		level := 0
+		if v := q.Get("level"); v != "" {
+			if level, err = strconv.Atoi(v); err != nil || level < 1 || level >= s.Power.Levels().Max {
+				http.Error(w, "modulate_badlevel", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := s.Modulate.Start(duty, period, level, "http"); err == modulate.ErrUnlit {
+			fmt.Fprintf(w, "modulate_unlit")
+			return
+		}
+		fmt.Fprintf(w, "modulate_started")
+	}
+}
@@ -0,0 +1,624 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/auth"
+	"github.com/barrylb/go-fire/internal/automation"
+	"github.com/barrylb/go-fire/internal/autooff"
+	"github.com/barrylb/go-fire/internal/childlock"
+	"github.com/barrylb/go-fire/internal/demand"
+	"github.com/barrylb/go-fire/internal/estop"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/heartbeat"
+	"github.com/barrylb/go-fire/internal/history"
+	"github.com/barrylb/go-fire/internal/modulate"
+	"github.com/barrylb/go-fire/internal/presence"
+	"github.com/barrylb/go-fire/internal/presets"
+	"github.com/barrylb/go-fire/internal/profiles"
+	"github.com/barrylb/go-fire/internal/quiet"
+	"github.com/barrylb/go-fire/internal/ramp"
+	"github.com/barrylb/go-fire/internal/resync"
+	"github.com/barrylb/go-fire/internal/rules"
+	"github.com/barrylb/go-fire/internal/selftest"
+	"github.com/barrylb/go-fire/internal/selfupdate"
+	"github.com/barrylb/go-fire/internal/sensor"
+	"github.com/barrylb/go-fire/internal/thermostat"
+	"github.com/barrylb/go-fire/internal/usage"
+	"github.com/barrylb/go-fire/internal/wear"
+	"github.com/barrylb/go-fire/internal/webhooks"
+)
+
+// apiOp documents one method of a route for /openapi.json. The schemas of body and reply
+// are made from their Go types, so they follow the handlers' JSON.
+type apiOp struct {
+	method  string
+	summary string
+	params  []apiParam
+	body    interface{} // JSON request body
+	reply   interface{} // JSON reply; nil for a plain-text one such as on_ok
+	stream  string      // media type of a streamed reply, e.g. text/event-stream
+	upload  string      // media type of a request body that isn't JSON, e.g. application/gzip
+}
+
+// apiParam is a query parameter.
+type apiParam struct {
+	name, typ, desc string
+	required        bool
+}
+
+func param(name, typ, desc string) apiParam    { return apiParam{name, typ, desc, false} }
+func required(name, typ, desc string) apiParam { return apiParam{name, typ, desc, true} }
+
+// command documents a plain-text command route, which replies op_result.
+func command(summary string, params ...apiParam) []apiOp {
+	return []apiOp{{method: "post", summary: summary, params: params}}
+}
+
+// v1Command documents a v1 command route.
+func v1Command(summary string, params ...apiParam) []apiOp {
+	return []apiOp{{method: "post", summary: summary, params: params, reply: v1Response{}}}
+}
+
+var (
+	waitParam   = param("wait", "string", "With If-None-Match, how long to wait for the state to change, e.g. 30s; at most 2m")
+	pinParam    = param("pin", "string", "The ignition PIN, when lockout.pin is set")
+	dryRunParam = param("dryrun", "string", "1 to go through the command without actuating the relays")
+	forceParam  = param("force", "string", "1 to send the command whatever the tracked state")
+	forParam    = param("for", "string", "Turn the fire off this long after, e.g. 90m")
+)
+
+// apiDocs documents each route; one missing here is listed with a bare GET.
+var apiDocs = map[string][]apiOp{
+	"/": {{method: "get", summary: "The web UI to browsers, or the list of routes"}},
+	"/status": {{method: "get", summary: "The tracked state of the fireplace and its automation; 304 while it still has the If-None-Match ETag",
+		reply: status{}, params: []apiParam{waitParam}}},
+	"/off":       command("Turn the fire off", dryRunParam),
+	"/on":        command("Light the fire; with lockout.confirm set, replies on_confirm CODE for /confirm", pinParam, forParam, dryRunParam),
+	"/confirm":   command("Confirm an ignition held by lockout.confirm, replying as it would have", required("code", "string", "The code /on replied")),
+	"/flameup":   command("Turn the flame up a step", forParam, dryRunParam),
+	"/flamedown": command("Turn the flame down a step", forParam, dryRunParam),
+	"/aux":       command("Pulse the auxiliary contact", dryRunParam),
+	"/pilot":     command("Put the fire down to its pilot flame", dryRunParam),
+	"/aux_on":    command("Light the second burner of a dual-burner valve", dryRunParam),
+	"/aux_off":   command("Put out the second burner of a dual-burner valve", dryRunParam),
+	"/setflame": command("Set the flame to a level; 0 is the pilot",
+		required("level", "integer", "From 0 to the valve's levels"), forParam, dryRunParam),
+	"/ensure_on":  command("Light the fire unless it is tracked as lit; replies already_on if it is", pinParam, forceParam, forParam, dryRunParam),
+	"/ensure_off": command("Turn the fire off unless it is tracked as off; replies already_off if it is", forceParam, dryRunParam),
+	"/ensure_level": command("Set the flame to a level unless it is tracked as lit at it; replies already_level if it is",
+		required("level", "integer", "From 0 to the valve's levels"), forceParam, forParam, dryRunParam),
+	"/calibrate": {
+		{method: "get", summary: "The valve's travel time, and whether it is being calibrated", reply: flame.State{}},
+		{method: "post", summary: "Start calibrating, or with done=1 finish once the flame is full",
+			params: []apiParam{param("done", "string", "Set once the flame has reached full")}},
+	},
+	"/fan": command("Set the blower speed",
+		required("speed", "integer", "0 (off) to the fireplace's highest speed")),
+	"/splitflow": command("Open or close the split-flow valve",
+		required("state", "string", "on or off")),
+	"/ramp": {
+		{method: "get", summary: "Ramp the flame to a level over a period, or (without to) the ramp in progress",
+			reply: ramp.State{}, params: []apiParam{
+				param("to", "string", "min, max or a level from 1"),
+				param("over", "string", "How long, e.g. 10m; needed with to")}},
+		{method: "delete", summary: "Stop the ramp in progress"},
+	},
+	"/modulate": {
+		{method: "get", summary: "Cycle the burner on a duty cycle, or (without duty) the modulation in progress",
+			reply: modulate.State{}, params: []apiParam{
+				param("duty", "integer", "Percent of each period burning, 1 to 99"),
+				param("period", "string", "How long a cycle lasts, e.g. 15m; default 15m"),
+				param("level", "integer", "Cycle the flame between this level and the next, rather than the burner")}},
+		{method: "delete", summary: "Stop the modulation in progress"},
+	},
+	"/resync": {
+		{method: "get", summary: "The resync in progress, or else the last", reply: resync.State{}},
+		{method: "post", summary: "Drive the flame right down in the background, so its level is known again",
+			params: []apiParam{dryRunParam}},
+		{method: "delete", summary: "Cut the resync in progress short"},
+	},
+	"/lock": {
+		{method: "get", summary: "The child lock", reply: childlock.State{}},
+		{method: "post", summary: "Lock the child lock, refusing every command but off",
+			params: []apiParam{required("pin", "string", "The lock's PIN")}},
+	},
+	"/reload": {{method: "post", summary: "Read the configuration file again", reply: Reloaded{}}},
+	"/selftest": {
+		{method: "get", summary: "Check each relay contact's GPIO line", reply: selftest.Report{}},
+		{method: "post", summary: "Check the relay lines, and with click=1 click each relay while the fire is off",
+			reply: selftest.Report{}, params: []apiParam{param("click", "string", "1 to click each relay in turn")}},
+	},
+	"/unlock": command("Unlock the child lock", required("pin", "string", "The lock's PIN")),
+	"/cancel": command("Abort the running contact sequence, drop queued commands and stop any ramp"),
+	"/estop": {
+		{method: "get", summary: "The emergency stop of each fireplace", reply: map[string]estop.State{}},
+		{method: "post", summary: "Emergency stop: cut short everything, turn every fire off and refuse all but off until cleared"},
+	},
+	"/estop/clear":  command("Release the emergency stop of every fireplace; replies estop_cleared or estop_notengaged"),
+	"/cancel_timer": command("Clear the off timer set with for=, leaving the fire as it is"),
+	"/undo":         command("Reverse the last command that changed the fireplace"),
+	"/queue": {
+		{method: "get", summary: "The commands waiting in the queue", reply: queueState{}},
+		{method: "delete", summary: "Drop the waiting commands"},
+	},
+	"/hold": {
+		{method: "get", summary: "Whether automation is paused", reply: automation.HoldState{}},
+		{method: "post", summary: "Pause automation", reply: automation.HoldState{}, params: []apiParam{
+			param("for", "string", "How long, e.g. 3h"),
+			param("until", "string", "Until when, RFC 3339"),
+			param("reason", "string", "Why, shown in /status")}},
+		{method: "delete", summary: "Resume automation", reply: automation.HoldState{}},
+	},
+	"/settemp": {{method: "get", summary: "The thermostat, setting its target with target",
+		reply: thermostat.State{}, params: []apiParam{
+			param("target", "string", "The temperature to hold the room at, or off")}}},
+	"/climate": {
+		{method: "get", summary: "The thermostat as a Home Assistant climate entity", reply: climateState{}},
+		{method: "post", summary: "Set the thermostat's target or hvac_mode (heat or off, which turns the fire off)",
+			body: climateRequest{}, reply: climateState{}, params: []apiParam{
+				param("target_temperature", "number", "The temperature to hold the room at"),
+				param("hvac_mode", "string", "heat or off")}},
+	},
+	"/demand": {
+		{method: "get", summary: "The demand-response signal", reply: demand.State{}},
+		{method: "post", summary: "Start or end a demand-response event, or override it",
+			reply: demand.State{}, params: []apiParam{
+				param("active", "boolean", "Start (true) or end (false) an event"),
+				param("for", "string", "How long the event lasts, e.g. 2h"),
+				param("override", "boolean", "Ignore the current event until it ends")}},
+	},
+	"/rules": {
+		{method: "get", summary: "Every automation rule", reply: []rules.Rule{}},
+		{method: "put", summary: "Add a rule, or replace the one with the body's id", body: rules.Rule{}, reply: rules.Rule{}},
+		{method: "delete", summary: "Remove a rule", params: []apiParam{required("id", "string", "The rule's id")}},
+	},
+	"/webhooks": {
+		{method: "get", summary: "Every webhook", reply: []webhooks.Hook{}},
+		{method: "put", summary: "Register a webhook, or replace the one of its name", body: webhooks.Hook{}},
+		{method: "delete", summary: "Remove a registered webhook", params: []apiParam{required("name", "string", "The hook's name")}},
+		{method: "post", summary: "Call a webhook with a test event", params: []apiParam{required("test", "string", "The hook's name")}},
+	},
+	"/presets": {
+		{method: "get", summary: "Every preset", reply: []presets.Preset{}},
+		{method: "put", summary: "Save a preset, or replace the one of its name", body: presets.Preset{}},
+		{method: "delete", summary: "Remove a saved preset", params: []apiParam{required("name", "string", "The preset's name")}},
+	},
+	"/preset/{name}": command("Apply a preset, lighting the fire if it isn't lit; replies preset_ok", pinParam),
+	"/rules/hook":    command("Fire a webhook-triggered rule", required("id", "string", "The rule's id")),
+	"/clock": {
+		{method: "get", summary: "The simulated clock the rules run against", reply: clockState{}},
+		{method: "post", summary: "Fast-forward the simulated clock", reply: clockState{},
+			params: []apiParam{required("advance", "string", "How far, e.g. 168h")}},
+	},
+	"/healthz": {{method: "get", summary: "Liveness: the GPIO chip, the relays and the server; 503 when failing",
+		reply: probeReply{}}},
+	"/readyz": {{method: "get", summary: "Readiness: the checks of /healthz, no latched fault and no safe mode; 503 when failing",
+		reply: probeReply{}}},
+	"/relays": {{method: "get", summary: "How worn the valve relays are", reply: []wear.Relay{}}},
+	"/light": command("Set the light",
+		param("state", "string", "on or off"),
+		param("brightness", "integer", "0 to 100"),
+		param("fade", "string", "How long to fade over, e.g. 2s")),
+	"/sensors": {{method: "get", summary: "The latest reading of every sensor", reply: map[string]sensor.Reading{}}},
+	"/sensors/feed": command("Push a reading for a sensor under sensors.feeds",
+		required("name", "string", "The sensor"), required("value", "number", "The reading")),
+	"/history": {{method: "get", summary: "Recorded samples of a sensor", reply: []history.Sample{},
+		params: []apiParam{
+			param("sensor", "string", "The sensor; all when empty"),
+			param("since", "string", "How far back, e.g. 24h (the default)")}}},
+	"/metrics": {{method: "get", summary: "Every metric in the Prometheus text format"}},
+	"/ws": {{method: "get", summary: "Events over a WebSocket", stream: "application/json",
+		reply: streamEvent{}}},
+	"/events": {{method: "get", summary: "Events as server-sent events", stream: "text/event-stream",
+		reply: streamEvent{}}},
+	"/commands": {{method: "get", summary: "The latest commands from every source, newest first",
+		reply: []events.Command{}, params: []apiParam{param("n", "integer", "How many; default 20")}}},
+	"/audit": {{method: "get", summary: "Commands from the audit trail, newest first",
+		reply: []events.Command{}, params: []apiParam{
+			param("since", "string", "A time (RFC 3339) or how long ago, e.g. 168h; default 24h"),
+			param("until", "string", "A time (RFC 3339) or how long ago; default now"),
+			param("source", "string", "Only commands from this source, e.g. mqtt"),
+			param("op", "string", "Only this command, e.g. on"),
+			param("n", "integer", "The newest this many; default 1000")}}},
+	"/tokens": {
+		{method: "get", summary: "The unexpired guest tokens", reply: []auth.Token{}},
+		{method: "post", summary: "Mint a guest token", reply: mintedToken{}, params: []apiParam{
+			param("name", "string", "Who it is for"),
+			required("scopes", "string", "Comma-separated scopes, e.g. on,off"),
+			param("expires", "string", "How long it lasts, e.g. 48h"),
+			param("until", "string", "When it expires, RFC 3339")}},
+		{method: "delete", summary: "Revoke a token", params: []apiParam{required("id", "string", "The token's id")}},
+	},
+	"/sign": {{method: "post", summary: "Mint a one-time action URL", reply: signedURL{},
+		params: []apiParam{
+			required("action", "string", "The command it runs, e.g. off"),
+			param("expires", "string", "How long it lasts, e.g. 1h")}}},
+	"/action": {{method: "get", summary: "Run the action of a signed URL once", params: []apiParam{
+		required("action", "string", ""), required("exp", "integer", ""),
+		required("n", "string", ""), required("sig", "string", "")}}},
+	"/profile": {
+		{method: "get", summary: "The calling user's preferences", reply: profiles.Profile{}},
+		{method: "put", summary: "Replace the calling user's preferences", body: profiles.Profile{}},
+		{method: "delete", summary: "Forget the calling user's preferences"},
+	},
+	"/safemode": {
+		{method: "get", summary: "Whether safe mode is active", reply: safeModeState{}},
+		{method: "delete", summary: "Clear safe mode and restart"},
+	},
+	"/fault": {
+		{method: "get", summary: "The fault latched by a panic", reply: faultState{}},
+		{method: "delete", summary: "Clear the fault", reply: faultState{}},
+	},
+	"/heartbeat": {
+		{method: "get", summary: "The last heartbeat and the deadline for the next", reply: heartbeat.State{}},
+		{method: "post", summary: "Record a heartbeat"},
+	},
+	"/autooff": {
+		{method: "get", summary: "The auto-off limit", reply: autooff.State{}},
+		{method: "post", summary: "Change the limit of this burn", reply: autooff.State{},
+			params: []apiParam{required("hours", "number", "The new limit, up to 24")}},
+	},
+	"/presence": {
+		{method: "get", summary: "Whether anybody is home", reply: presence.State{}},
+		{method: "post", summary: "Override what the devices show", reply: presence.State{}, params: []apiParam{
+			required("override", "string", "home or away"),
+			param("hours", "number", "How long; until cleared without it")}},
+		{method: "delete", summary: "Go by the devices again", reply: presence.State{}},
+	},
+	"/quiet": {
+		{method: "get", summary: "The quiet-hours policy, and whether it applies now", reply: quiet.State{}},
+		{method: "post", summary: "Lift the quiet-hours policy, or apply it again", reply: quiet.State{}, params: []apiParam{
+			required("override", "boolean", "true to lift the policy, false to apply it again"),
+			param("confirm", "string", "yes; needed to lift the policy"),
+			param("hours", "number", "How long to lift it; until the quiet hours end without it")}},
+	},
+	"/usage": {
+		{method: "get", summary: "The burn time by day, week or month; CSV with format=csv", reply: usageReply{},
+			params: []apiParam{
+				param("period", "string", "day (the default), week or month"),
+				param("since", "string", "The first day, e.g. 2026-01-01"),
+				param("format", "string", "csv for CSV")}},
+		{method: "post", summary: "Reset a usage counter", reply: []usage.Counter{},
+			params: []apiParam{required("counter", "string", "The counter")}},
+		{method: "delete", summary: "Remove a usage counter", reply: []usage.Counter{},
+			params: []apiParam{required("counter", "string", "The counter")}},
+	},
+	"/update": {
+		{method: "get", summary: "The latest signed release", reply: selfupdate.Release{}},
+		{method: "post", summary: "Install the latest release and restart into it"},
+	},
+	"/backup": {{method: "get", summary: "A gzipped tar of the configuration and the state files",
+		stream: "application/gzip", reply: ""}},
+	"/restore": {{method: "post", summary: "Put back an archive from /backup and restart into it",
+		upload: "application/gzip", reply: restoreReply{}}},
+	"/google": {{method: "post", summary: "Google Home smart home fulfillment", body: map[string]interface{}{},
+		reply: map[string]interface{}{}}},
+	"/alexa": {{method: "post", summary: "Alexa Smart Home directives", body: map[string]interface{}{},
+		reply: map[string]interface{}{}}},
+	"/fireplaces": {{method: "get", summary: "The further fireplaces with their tracked state",
+		reply: map[string]fireplaceStatus{}}},
+	"/fireplaces/{name}/{op}": command("Run a command on a further fireplace, or estop or estop_clear its emergency stop", dryRunParam),
+	"/fireplaces/{name}/status": {{method: "get", summary: "The tracked state of a further fireplace",
+		reply: fireplaceStatus{}}},
+	"/accessory":               {{method: "get", summary: "The accessories, each on or off", reply: map[string]string{}}},
+	"/accessory/{name}/{op}":   command("Switch an accessory on, off or over (toggle); replies accessory_on or accessory_off"),
+	"/accessory/{name}/status": {{method: "get", summary: "Whether an accessory is on; replies accessory_on or accessory_off"}},
+	"/openapi.json":            {{method: "get", summary: "This document", reply: map[string]interface{}{}}},
+	"/docs":                    {{method: "get", summary: "An API explorer for this document"}},
+
+	"/api/v1/command/on":        v1Command("Light the fire", pinParam, forParam, dryRunParam),
+	"/api/v1/command/off":       v1Command("Turn the fire off", dryRunParam),
+	"/api/v1/command/flameup":   v1Command("Turn the flame up a step", forParam, dryRunParam),
+	"/api/v1/command/flamedown": v1Command("Turn the flame down a step", forParam, dryRunParam),
+	"/api/v1/command/aux":       v1Command("Pulse the auxiliary contact", dryRunParam),
+	"/api/v1/command/pilot":     v1Command("Put the fire down to its pilot flame", dryRunParam),
+	"/api/v1/command/aux_on":    v1Command("Light the second burner of a dual-burner valve", dryRunParam),
+	"/api/v1/command/aux_off":   v1Command("Put out the second burner of a dual-burner valve", dryRunParam),
+	"/api/v1/command/fan": v1Command("Set the blower speed",
+		required("speed", "integer", "0 (off) to the fireplace's highest speed")),
+	"/api/v1/command/splitflow": v1Command("Open or close the split-flow valve",
+		required("state", "string", "on or off")),
+	"/api/v1/undo":     v1Command("Reverse the last command that changed the fireplace"),
+	"/api/v1/status":   {{method: "get", summary: "As /status", reply: status{}, params: []apiParam{waitParam}}},
+	"/api/v1/commands": {{method: "get", summary: "As /commands", reply: []events.Command{}, params: []apiParam{param("n", "integer", "How many; default 20")}}},
+}
+
+// openAPIHandler serves an OpenAPI 3 document describing every route, so that typed clients
+// can be generated; Handler replaces it with one describing the routes it serves:
+//
+//	GET /openapi.json    {"openapi": "3.0.3", "info": {...}, "paths": {"/on": {"post": {...}}, ...}}
+func (s *Server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIFor(s.routes(), "")(w, r)
+}
+
+// openAPIFor returns an openAPIHandler describing routes, as served under base.
+func openAPIFor(routes map[string]http.HandlerFunc, base string) http.HandlerFunc {
+	doc, err := json.Marshal(openAPI(routes, base))
+	if err != nil {
+		panic(err) // the document is made of maps, strings and numbers
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
+
+// openAPI returns the document describing routes.
+func openAPI(routes map[string]http.HandlerFunc, base string) map[string]interface{} {
+	sc := schemas{defs: map[string]interface{}{}, names: map[reflect.Type]string{}}
+	paths := map[string]interface{}{}
+	// in order, so that the schemas are named the same each time
+	var names []string
+	for route := range routes {
+		names = append(names, route)
+	}
+	sort.Strings(names)
+	for _, route := range names {
+		switch route {
+		case grpcService:
+			continue // not JSON over HTTP; see proto/gofire.proto
+		case "/fireplaces/":
+			for _, p := range []string{"/fireplaces/{name}/{op}", "/fireplaces/{name}/status"} {
+				paths[p] = sc.path(p, routeScope(route), apiDocs[p])
+			}
+			continue
+		case "/preset/":
+			paths["/preset/{name}"] = sc.path("/preset/{name}", routeScope(route), apiDocs["/preset/{name}"])
+			continue
+		case "/accessory/":
+			for _, p := range []string{"/accessory/{name}/{op}", "/accessory/{name}/status"} {
+				paths[p] = sc.path(p, routeScope(route), apiDocs[p])
+			}
+			continue
+		}
+		ops, ok := apiDocs[route]
+		if !ok {
+			ops = []apiOp{{method: "get"}}
+		}
+		paths[route] = sc.path(route, routeScope(route), ops)
+	}
+	server := strings.TrimSuffix(base, "/")
+	if server == "" {
+		server = "/"
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "GoFire",
+			"description": "Control of a gas fireplace's valve. Commands reply op_result in plain text, e.g. on_ok or on_busy; the /api/v1 routes reply JSON.",
+			"version":     "1",
+		},
+		"servers": []interface{}{map[string]string{"url": server}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": sc.defs,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]string{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// path describes the methods of route, which needs a token with scope unless it is empty.
+func (sc schemas) path(route, scope string, ops []apiOp) map[string]interface{} {
+	item := map[string]interface{}{}
+	for _, op := range ops {
+		o := map[string]interface{}{
+			"operationId": operationID(op.method, route),
+		}
+		if op.summary != "" {
+			o["summary"] = op.summary
+		}
+		var params []interface{}
+		for _, p := range op.params {
+			params = append(params, map[string]interface{}{
+				"name": p.name, "in": "query", "required": p.required, "description": p.desc,
+				"schema": map[string]string{"type": p.typ},
+			})
+		}
+		if strings.Contains(route, "{name}") {
+			params = append(params, map[string]interface{}{
+				"name": "name", "in": "path", "required": true, "schema": map[string]string{"type": "string"}})
+		}
+		if strings.Contains(route, "{op}") {
+			params = append(params, map[string]interface{}{
+				"name": "op", "in": "path", "required": true, "schema": map[string]string{"type": "string"},
+				"description": "on, off, flameup, flamedown, aux, pilot, aux_on or aux_off"})
+		}
+		if params != nil {
+			o["parameters"] = params
+		}
+		switch {
+		case op.upload != "":
+			o["requestBody"] = map[string]interface{}{"required": true, "content": map[string]interface{}{
+				op.upload: map[string]interface{}{"schema": map[string]string{"type": "string", "format": "binary"}},
+			}}
+		case op.body != nil:
+			o["requestBody"] = map[string]interface{}{"required": true, "content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": sc.of(reflect.TypeOf(op.body))},
+			}}
+		}
+		var content map[string]interface{}
+		switch {
+		case op.stream != "":
+			content = map[string]interface{}{op.stream: map[string]interface{}{"schema": sc.of(reflect.TypeOf(op.reply))}}
+		case op.reply != nil:
+			content = map[string]interface{}{"application/json": map[string]interface{}{"schema": sc.of(reflect.TypeOf(op.reply))}}
+		default:
+			content = map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]string{"type": "string"}}}
+		}
+		o["responses"] = map[string]interface{}{
+			"200": map[string]interface{}{"description": "OK", "content": content},
+		}
+		if scope != "" {
+			o["security"] = []interface{}{map[string][]string{"bearer": {}}}
+			o["description"] = fmt.Sprintf("Needs a token with scope %s when tokens are set.", scope)
+			o["responses"].(map[string]interface{})["401"] = map[string]string{"description": "Missing or insufficient token"}
+		}
+		item[op.method] = o
+	}
+	return item
+}
+
+// operationID names a method of a route for generated clients, e.g. post_flameup or
+// get_api_v1_status.
+func operationID(method, route string) string {
+	id := strings.NewReplacer("/", "_", ".", "_", "{", "", "}", "").Replace(strings.Trim(route, "/"))
+	if id == "" {
+		id = "home"
+	}
+	return method + "_" + id
+}
+
+// schemas holds the named schemas of the document's Go types.
+type schemas struct {
+	defs  map[string]interface{}
+	names map[reflect.Type]string
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// of returns the JSON schema of t, as encoding/json writes it, adding named struct types to
+// sc and referring to them.
+func (sc schemas) of(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawType:
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return sc.of(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": sc.of(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": sc.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return sc.object(t)
+		}
+		name, ok := sc.names[t]
+		if !ok {
+			// fault.State and httpapi's faultState are both FaultState
+			name = schemaName(t)
+			for n := 2; sc.defs[name] != nil; n++ {
+				name = fmt.Sprintf("%s%d", schemaName(t), n)
+			}
+			sc.names[t] = name
+			sc.defs[name] = map[string]interface{}{} // refers to itself while being made
+			sc.defs[name] = sc.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// object returns the schema of struct type t, its embedded structs' fields inlined.
+func (sc schemas) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var req []string
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts := tag, ""
+			if i := strings.Index(tag, ","); i >= 0 {
+				name, opts = tag[:i], tag[i:]
+			}
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				add(f.Type)
+				continue
+			}
+			if f.PkgPath != "" {
+				continue // unexported
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = sc.of(f.Type)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Ptr {
+				req = append(req, name)
+			}
+		}
+	}
+	add(t)
+	o := map[string]interface{}{"type": "object", "properties": props}
+	if len(req) > 0 {
+		sort.Strings(req)
+		o["required"] = req
+	}
+	return o
+}
+
+// schemaName names a Go type's schema: status is Status, usage.Period UsagePeriod.
+func schemaName(t reflect.Type) string {
+	pkg := path.Base(t.PkgPath())
+	if pkg == "httpapi" || strings.HasPrefix(strings.ToLower(t.Name()), pkg) {
+		return upperFirst(t.Name())
+	}
+	return upperFirst(pkg) + upperFirst(t.Name())
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// docsHandler serves Swagger UI, loaded from http.swagger_ui, exploring /openapi.json.
+func (s *Server) docsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.SwaggerUI == "" {
+		http.Error(w, "docs_disabled", http.StatusNotFound)
+		return
+	}
+	dist := html.EscapeString(strings.TrimSuffix(s.SwaggerUI, "/"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>GoFire API</title>
+<link rel="stylesheet" href="%[1]s/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="%[1]s/swagger-ui-bundle.js"></script>
+<script>
+SwaggerUIBundle({url: new URL("openapi.json", location.href).href, dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`, dist)
+}
@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// presenceHandler reports whether anybody is home and overrides what the devices show:
+//
+//	GET    /presence                          {"state": "home", "last_seen": "...", "devices": {"phone": "..."}}
+//	POST   /presence?override=home&hours=3    take everybody to be home for 3 hours (without hours, until cleared)
+//	POST   /presence?override=away            take everybody to be away, so the fire is turned down now
+//	DELETE /presence                          go by the devices again
+func (s *Server) presenceHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Presence == nil {
+		http.Error(w, "presence_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		state := r.URL.Query().Get("override")
+		if state != "home" && state != "away" {
+			http.Error(w, "presence_badoverride", http.StatusBadRequest)
+			return
+		}
+		var dur time.Duration
+		if v := r.URL.Query().Get("hours"); v != "" {
+			hours, err := strconv.ParseFloat(v, 64)
+			if dur = time.Duration(hours * float64(time.Hour)); err != nil || dur <= 0 {
+				http.Error(w, "presence_badhours", http.StatusBadRequest)
+				return
+			}
+		}
+		s.Presence.Override(state, dur)
+		logging.Event(logging.Notice, "presence overridden", "state", state, "for", dur.String(), "from", ClientAddr(r))
+	case http.MethodDelete:
+		s.Presence.Override("", 0)
+		logging.Event(logging.Notice, "presence override cleared", "from", ClientAddr(r))
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "presence_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Presence.State())
+}
@@ -0,0 +1,105 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/presets"
+)
+
+// maxPresetSize bounds a preset's JSON.
+const maxPresetSize = 4 << 10
+
+// presetsHandler lists, saves and removes presets:
+//
+//	GET    /presets              [{"name": "cosy", "level": 3, "fan": 1}, ...]
+//	PUT    /presets              save the preset in the body, or replace the one of its name
+//	DELETE /presets?name=cosy    remove a saved preset
+//
+// Presets set in the configuration are listed with "configured": true and can't be replaced
+// or removed here.
+func (s *Server) presetsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Presets == nil {
+		http.Error(w, "presets_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Presets.List())
+	case http.MethodPut:
+		var p presets.Preset
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxPresetSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			http.Error(w, "presets_badjson", http.StatusBadRequest)
+			return
+		}
+		if err := s.Presets.Validate(p); err != nil {
+			http.Error(w, "presets_invalid "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch err := s.Presets.Put(p); err {
+		case nil:
+		case presets.ErrConfigured:
+			http.Error(w, "presets_configured", http.StatusConflict)
+			return
+		default:
+			logging.Logf(logging.Err, "presets: %v", err)
+			http.Error(w, "presets_error", http.StatusInternalServerError)
+			return
+		}
+		logging.Event(logging.Notice, "preset saved", "name", p.Name, "from", ClientAddr(r))
+		fmt.Fprintf(w, "presets_saved")
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		switch err := s.Presets.Delete(name); err {
+		case nil:
+		case presets.ErrNotFound:
+			http.Error(w, "presets_notfound", http.StatusNotFound)
+			return
+		case presets.ErrConfigured:
+			http.Error(w, "presets_configured", http.StatusConflict)
+			return
+		default:
+			logging.Logf(logging.Err, "presets: %v", err)
+			http.Error(w, "presets_error", http.StatusInternalServerError)
+			return
+		}
+		logging.Event(logging.Notice, "preset removed", "name", name, "from", ClientAddr(r))
+		fmt.Fprintf(w, "presets_deleted")
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "presets_badmethod", http.StatusMethodNotAllowed)
+	}
+}
+
+// presetHandler applies a preset, lighting the fire if it isn't lit, so it needs the PIN and
+// confirmation that /on does:
+//
+//	POST /preset/cosy    preset_ok, or preset_ followed by the first result that wasn't ok
+//
+// An unknown preset replies preset_notfound (404).
+func (s *Server) presetHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Presets == nil {
+		http.Error(w, "presets_disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "preset_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/preset/")
+	logging.Event(logging.Info, "apply preset", "name", name, "from", ClientAddr(r))
+	result, err := s.Presets.Apply(r.Context(), name, "http")
+	if err == presets.ErrNotFound {
+		http.Error(w, "preset_notfound", http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "preset_%s", result)
+}
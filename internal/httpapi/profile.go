@@ -0,0 +1,66 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/profiles"
+)
+
+// maxProfileSize bounds a PUT /profile body; UI settings are small.
+const maxProfileSize = 64 << 10
+
+// profileHandler reads and replaces the calling user's preferences, the user being the
+// name of their token:
+//
+//	GET    /profile    the profile as JSON, {} if none is saved
+//	PUT    /profile    replace it with the JSON body
+//	DELETE /profile    forget it
+func (s *Server) profileHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Profiles == nil || s.Auth == nil || !s.Auth.Enabled() {
+		http.Error(w, "profile_disabled", http.StatusNotFound)
+		return
+	}
+	user, ok := s.Auth.Identify(requestToken(r))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gofire"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Profiles.Get(user))
+	case http.MethodPut:
+		var p profiles.Profile
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxProfileSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			http.Error(w, "profile_badjson", http.StatusBadRequest)
+			return
+		}
+		if err := p.Validate(); err != nil {
+			http.Error(w, "profile_invalid "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Profiles.Set(user, p); err != nil {
+			logging.Logf(logging.Err, "profiles: %v", err)
+			http.Error(w, "profile_error", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "profile_saved")
+	case http.MethodDelete:
+		if err := s.Profiles.Delete(user); err != nil {
+			logging.Logf(logging.Err, "profiles: %v", err)
+			http.Error(w, "profile_error", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "profile_deleted")
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "profile_badmethod", http.StatusMethodNotAllowed)
+	}
+}
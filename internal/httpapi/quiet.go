@@ -0,0 +1,59 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// quietHandler reports the quiet-hours policy and overrides it:
+//
+//	GET  /quiet                                   {"quiet": true, "start": "23:00", "end": "07:00", "policy": "refuse", ...}
+//	POST /quiet?override=true&confirm=yes         lift the policy until the quiet hours end
+//	POST /quiet?override=true&confirm=yes&hours=2 lift it for 2 hours
+//	POST /quiet?override=false                    apply it again
+//
+// Lifting the policy needs confirm=yes, so that it is never done by a stray request; without
+// it the reply is quiet_confirm with status 428.
+func (s *Server) quietHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Quiet == nil {
+		http.Error(w, "quiet_disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		on, err := strconv.ParseBool(q.Get("override"))
+		if err != nil {
+			http.Error(w, "quiet_badoverride", http.StatusBadRequest)
+			return
+		}
+		var dur time.Duration
+		if on {
+			if q.Get("confirm") != "yes" {
+				http.Error(w, "quiet_confirm", http.StatusPreconditionRequired)
+				return
+			}
+			if v := q.Get("hours"); v != "" {
+				hours, err := strconv.ParseFloat(v, 64)
+				if dur = time.Duration(hours * float64(time.Hour)); err != nil || dur <= 0 {
+					http.Error(w, "quiet_badhours", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		s.Quiet.Override(on, dur)
+		logging.Event(logging.Notice, "quiet hours overridden", "override", strconv.FormatBool(on),
+			"for", dur.String(), "from", ClientAddr(r))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "quiet_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Quiet.State())
+}
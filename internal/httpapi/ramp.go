@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/ramp"
+)
+
+// rampHandler moves the flame to a level gradually, in the background:
+//
+//	GET    /ramp?to=max&over=10m    ramp up to the highest level over 10 minutes; replies ramp_started
+//	GET    /ramp?to=2&over=1h       or down to level 2 (to is min, max or a level from 1)
+//	GET    /ramp                    {"from": 1, "to": 6, "level": 3, "started": "...", "ends": "..."}, or null
+//	DELETE /ramp                    stop the ramp; replies ramp_stopped, or ramp_idle
+//
+// It replies ramp_unlit when the fire isn't lit at a known level. /cancel stops a ramp too,
+// as does any other command changing the flame.
+func (s *Server) rampHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Ramp == nil {
+		http.Error(w, "ramp_disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodDelete:
+		if !s.Ramp.Stop("stopped from " + ClientAddr(r)) {
+			fmt.Fprintf(w, "ramp_idle")
+			return
+		}
+		fmt.Fprintf(w, "ramp_stopped")
+	case q.Get("to") == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Ramp.State())
+	default:
+		max := s.Power.Levels().Max
+		to, err := strconv.Atoi(q.Get("to"))
+		switch q.Get("to") {
+		case "min":
+			to, err = 1, nil
+		case "max":
+			to, err = max, nil
+		}
+		if err != nil || to < 1 || to > max {
+			http.Error(w, "ramp_badto", http.StatusBadRequest)
+			return
+		}
+		over, err := time.ParseDuration(q.Get("over"))
+		if err != nil || over <= 0 || over > 24*time.Hour {
+			http.Error(w, "ramp_badover", http.StatusBadRequest)
+			return
+		}
+		if err := s.Ramp.Start(to, over, "http"); err == ramp.ErrUnlit {
+			fmt.Fprintf(w, "ramp_unlit")
+			return
+		}
+		fmt.Fprintf(w, "ramp_started")
+	}
+}
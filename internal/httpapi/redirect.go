@@ -0,0 +1,40 @@
+package httpapi
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// acmeChallengePath is where ACME HTTP-01 challenge tokens are fetched from.
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// RedirectHandler answers plain-HTTP requests with a permanent redirect to the same path on
+// the HTTPS listener at httpsPort, so old bookmarks and integrations keep working. If
+// acmeDir is set, ACME HTTP-01 challenge tokens written there (e.g. by certbot --webroot)
+// are served instead of redirected.
+func RedirectHandler(httpsPort, acmeDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if acmeDir != "" && strings.HasPrefix(r.URL.Path, acmeChallengePath) {
+			token := strings.TrimPrefix(r.URL.Path, acmeChallengePath)
+			if token == "" || strings.ContainsAny(token, "/\\") {
+				http.NotFound(w, r)
+				return
+			}
+			http.ServeFile(w, r, filepath.Join(acmeDir, token))
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if httpsPort != "443" {
+			host += ":" + httpsPort
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,18 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// relaysHandler reports how worn the valve relays are:
+//
+//	GET /relays    [{"name": "contact1", "actuations": 1234, "rated": 100000, "used": 0.01234}, ...]
+func (s *Server) relaysHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Wear == nil {
+		http.Error(w, "relays_notcounted", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Wear.Counts())
+}
@@ -0,0 +1,39 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Reloaded is what a configuration reload changed.
+type Reloaded struct {
+	Applied []string `json:"applied"`           // settings changed in place, e.g. valve.profile
+	Restart []string `json:"restart,omitempty"` // sections changed that need a restart
+}
+
+// reloadHandler reads the configuration file again, as SIGHUP does:
+//
+//	POST /reload    {"applied": ["auth.tokens", "rules"], "restart": ["http"]}
+//
+// The rules (schedules), the valve profiles (pulse timings), the auth tokens and the MQTT
+// broker settings change in place, keeping the GPIO lines and the listeners; other sections
+// changed are listed under restart and take effect when GoFire is next restarted. A file that
+// doesn't load changes nothing and replies reload_badconfig with status 400.
+func (s *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Reload == nil {
+		http.Error(w, "reload_disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "reload_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	res, err := s.Reload(r.Context())
+	if err != nil {
+		http.Error(w, "reload_badconfig", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,53 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/resync"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// resyncHandler drives a lit fire's flame right down, in the background, so that its tracked
+// level is known again:
+//
+//	POST   /resync    start; replies resync_started, or resync_running while one is
+//	GET    /resync    {"running": false, "source": "http", "result": "ok", ...}, or null
+//	DELETE /resync    cut it short; replies resync_stopped, or resync_idle
+//
+// It replies resync_unlit (409) when the fire isn't lit. A resync takes the travel time, and
+// /cancel cuts it short too.
+func (s *Server) resyncHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Resync == nil {
+		http.Error(w, "resync_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Resync.State())
+	case http.MethodPost:
+		switch err := s.Resync.Start("http", gv60.IsDryRun(r.Context())); err {
+		case nil:
+			logging.Event(logging.Info, "resync requested", "from", ClientAddr(r))
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, "resync_started")
+		case resync.ErrRunning:
+			http.Error(w, "resync_running", http.StatusConflict)
+		default:
+			http.Error(w, "resync_unlit", http.StatusConflict)
+		}
+	case http.MethodDelete:
+		if !s.Resync.Stop() {
+			fmt.Fprintf(w, "resync_idle")
+			return
+		}
+		logging.Event(logging.Notice, "resync stopped", "from", ClientAddr(r))
+		fmt.Fprintf(w, "resync_stopped")
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "resync_badmethod", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,83 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/rules"
+)
+
+// maxRuleSize bounds a PUT /rules body.
+const maxRuleSize = 64 << 10
+
+// rulesHandler manages automation rules (see package rules for their format):
+//
+//	GET    /rules            every rule
+//	PUT    /rules            add a rule, or replace the one with the body's id; replies with it
+//	DELETE /rules?id=...     remove a rule
+func (s *Server) rulesHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Rules == nil {
+		http.Error(w, "rules_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Rules.List())
+	case http.MethodPut:
+		var rule rules.Rule
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxRuleSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rule); err != nil {
+			http.Error(w, "rules_badjson", http.StatusBadRequest)
+			return
+		}
+		if err := rule.Validate(); err != nil {
+			http.Error(w, "rules_invalid "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule, err := s.Rules.Put(rule)
+		if err != nil {
+			logging.Logf(logging.Err, "rules: %v", err)
+			http.Error(w, "rules_error", http.StatusInternalServerError)
+			return
+		}
+		logging.Event(logging.Notice, "rule saved", "id", rule.ID, "name", rule.Name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rule)
+	case http.MethodDelete:
+		switch err := s.Rules.Delete(r.URL.Query().Get("id")); err {
+		case nil:
+			fmt.Fprintf(w, "rules_deleted")
+		case rules.ErrNotFound:
+			http.Error(w, "rules_notfound", http.StatusNotFound)
+		default:
+			logging.Logf(logging.Err, "rules: %v", err)
+			http.Error(w, "rules_error", http.StatusInternalServerError)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "rules_badmethod", http.StatusMethodNotAllowed)
+	}
+}
+
+// ruleHookHandler fires a webhook-triggered rule: POST /rules/hook?id=...
+func (s *Server) ruleHookHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Rules == nil {
+		http.Error(w, "hook_disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "hook_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.Rules.Hook(r.URL.Query().Get("id")); err != nil {
+		http.Error(w, "hook_notfound", http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "hook_ok")
+}
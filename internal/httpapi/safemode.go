@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// safeModeHandler shows and clears safe mode:
+//
+//	GET    /safemode    {"active": true}
+//	DELETE /safemode    clear it; GoFire restarts in place and comes up normally
+func (s *Server) safeModeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		if !s.SafeMode.Active() {
+			http.Error(w, "safemode_inactive", http.StatusConflict)
+			return
+		}
+		logging.Event(logging.Notice, "clearing safe mode", "from", ClientAddr(r))
+		if err := s.SafeMode.Clear(); err != nil {
+			logging.Logf(logging.Err, "safemode: %v", err)
+			http.Error(w, "safemode_error", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("safemode_cleared"))
+		return
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "safemode_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(safeModeState{s.SafeMode.Active()})
+}
+
+// safeModeState is the reply of GET /safemode.
+type safeModeState struct {
+	Active bool `json:"active"`
+}
+
+// refuseInSafeMode replaces the routes not served in safe mode.
+func refuseInSafeMode(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "safemode", http.StatusServiceUnavailable)
+}
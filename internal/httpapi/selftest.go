@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/selftest"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// selfTestHandler runs the relay self-test:
+//
+//	GET  /selftest            check each contact's GPIO line is still held as an output
+//	POST /selftest?click=1    click each relay in turn as well
+//
+// Both reply {"ok": true, "clicked": false, "contacts": [{"name": "contact1", "gpio": 26,
+// "requested": true}, ...]}, with each failing contact's error; ok is false if any failed.
+// A click closes each contact on its own for self_test.pulse, which may nudge the flame, so
+// it replies selftest_notoff (409) unless the fire is off, and selftest_busy (409) while a
+// contact sequence is running, and selftest_lockout (409) while the emergency stop is engaged.
+func (s *Server) selfTestHandler(w http.ResponseWriter, r *http.Request) {
+	if s.SelfTest == nil {
+		http.Error(w, "selftest_disabled", http.StatusNotFound)
+		return
+	}
+	click := false
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		switch v := r.URL.Query().Get("click"); v {
+		case "", "0", "false":
+		case "1", "true":
+			click = true
+		default:
+			http.Error(w, "selftest_badclick", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "selftest_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	rep, err := s.SelfTest.Run(r.Context(), click, "http")
+	if errors.Is(err, fireplace.ErrLockout) {
+		http.Error(w, "selftest_lockout", http.StatusConflict)
+		return
+	}
+	switch err {
+	case nil:
+	case selftest.ErrRunning:
+		http.Error(w, "selftest_running", http.StatusConflict)
+		return
+	case selftest.ErrNotOff:
+		http.Error(w, "selftest_notoff", http.StatusConflict)
+		return
+	case gv60.ErrBusy:
+		http.Error(w, "selftest_busy", http.StatusConflict)
+		return
+	case gv60.ErrCancelled:
+		http.Error(w, "selftest_cancelled", http.StatusConflict)
+		return
+	default:
+		logging.Logf(logging.Err, "selftest: %v", err)
+		http.Error(w, "selftest_error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
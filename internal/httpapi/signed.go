@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/auth"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// maxSignedExpiry caps how long a signed action URL stays valid.
+const maxSignedExpiry = 7 * 24 * time.Hour
+
+// signHandler mints a one-time action URL for notifications:
+//
+//	POST /sign?action=off&expires=1h    {"url": "https://host/action?action=off&exp=...&n=...&sig=..."}
+func (s *Server) signHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Auth == nil {
+		http.Error(w, "sign_disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "sign_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	action := q.Get("action")
+	if !actions.Valid(action) {
+		http.Error(w, "sign_badaction", http.StatusBadRequest)
+		return
+	}
+	expires := time.Hour
+	if v := q.Get("expires"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxSignedExpiry {
+			http.Error(w, "sign_badexpires", http.StatusBadRequest)
+			return
+		}
+		expires = d
+	}
+	query, err := s.Auth.SignAction(action, time.Now().Add(expires))
+	if err != nil {
+		logging.Logf(logging.Err, "sign: %v", err)
+		http.Error(w, "sign_error", http.StatusInternalServerError)
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	// RequestURI still carries any base path that was stripped before routing
+	prefix := strings.TrimSuffix(strings.SplitN(r.RequestURI, "?", 2)[0], "/sign")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signedURL{fmt.Sprintf("%s://%s%s/action?%s", scheme, r.Host, prefix, query)})
+}
+
+// signedURL is the reply of /sign.
+type signedURL struct {
+	URL string `json:"url"`
+}
+
+// actionHandler runs the action of a signed URL once; later uses reply action_used.
+func (s *Server) actionHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Auth == nil || s.Actions == nil {
+		http.Error(w, "action_disabled", http.StatusNotFound)
+		return
+	}
+	action, err := s.Auth.UseActionURL(r.URL.Query())
+	switch err {
+	case nil:
+	case auth.ErrExpired:
+		http.Error(w, "action_expired", http.StatusGone)
+		return
+	case auth.ErrUsed:
+		http.Error(w, "action_used", http.StatusGone)
+		return
+	case auth.ErrBadSignature:
+		logging.Event(logging.Warning, "signed url: bad signature", "from", ClientAddr(r))
+		http.Error(w, "action_badsignature", http.StatusForbidden)
+		return
+	default:
+		// the URL was valid and is now spent even if it couldn't be persisted
+		logging.Logf(logging.Err, "signed url: %v", err)
+	}
+	fmt.Fprintf(w, "%s_%s", action, s.Actions.RunContext(r.Context(), action, "signed-url"))
+}
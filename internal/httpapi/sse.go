@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// eventsHandler streams the /ws events as server-sent events, for clients such as curl
+// that can't easily speak WebSocket. Each event is named by its type; a comment is sent
+// when the stream has been idle for http.events.keep_alive, and clients are asked to wait
+// http.events.retry before reconnecting:
+//
+//	GET /events    retry: 3000
+//	               event: state
+//	               data: {"type": "state", "time": "...", "state": {"power": "off", ...}}
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "events_unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // don't let nginx hold events back
+	fmt.Fprintf(w, "retry: %d\n\n", s.Events.Retry.Milliseconds())
+	f.Flush()
+	logging.Event(logging.Info, "event stream opened", "from", ClientAddr(r))
+	send := func(e streamEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+			return err
+		}
+		f.Flush()
+		return nil
+	}
+	keepAlive := func() error {
+		if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+			return err
+		}
+		f.Flush()
+		return nil
+	}
+	s.streamEvents(send, keepAlive, s.Events.KeepAlive, r.Context().Done())
+	logging.Event(logging.Info, "event stream closed", "from", ClientAddr(r))
+}
@@ -0,0 +1,261 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/automation"
+	"github.com/barrylb/go-fire/internal/autooff"
+	"github.com/barrylb/go-fire/internal/childlock"
+	"github.com/barrylb/go-fire/internal/demand"
+	"github.com/barrylb/go-fire/internal/estop"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/frost"
+	"github.com/barrylb/go-fire/internal/ignition"
+	"github.com/barrylb/go-fire/internal/modulate"
+	"github.com/barrylb/go-fire/internal/offtimer"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/presence"
+	"github.com/barrylb/go-fire/internal/quiet"
+	"github.com/barrylb/go-fire/internal/ramp"
+	"github.com/barrylb/go-fire/internal/resync"
+	"github.com/barrylb/go-fire/internal/selftest"
+	"github.com/barrylb/go-fire/internal/thermostat"
+)
+
+// started is when the process started, for the uptime in /status.
+var started = time.Now()
+
+const (
+	// statusMaxWait bounds /status?wait=, below the idle timeouts of the proxies in front.
+	statusMaxWait = 2 * time.Minute
+	// statusRecheck is how often a waiting /status looks again for changes no command
+	// announces, such as a sensor reading moving the thermostat.
+	statusRecheck = time.Second
+)
+
+// status is the /status reply.
+type status struct {
+	power.State
+	Levels      int                  `json:"levels,omitempty"` // flame levels above off
+	Uptime      string               `json:"uptime"`
+	Light       *int                 `json:"light,omitempty"`       // brightness; absent without a light
+	Accessories map[string]string    `json:"accessories,omitempty"` // on or off, by name
+	Hold        automation.HoldState `json:"hold"`
+	Demand      *demand.State        `json:"demand,omitempty"`
+	Thermostat  *thermostat.State    `json:"thermostat,omitempty"`
+	Frost       *frost.State         `json:"frost_protection,omitempty"` // active while it has the fire lit
+	AutoOff     *autooff.State       `json:"auto_off,omitempty"`
+	Interlocks  map[string]string    `json:"interlocks,omitempty"` // closed or open, by name
+	Ignition    *ignition.State      `json:"ignition,omitempty"`   // the last ignition's outcome
+	Presence    *presence.State      `json:"presence,omitempty"`
+	Quiet       *quiet.State         `json:"quiet_hours,omitempty"`
+	Ramp        *ramp.State          `json:"ramp,omitempty"`
+	Modulation  *modulate.State      `json:"modulation,omitempty"`
+	Resync      *resync.State        `json:"resync,omitempty"` // running, or the last to finish
+	Lock        *childlock.State     `json:"lock,omitempty"`
+	EStop       *estop.State         `json:"estop,omitempty"` // while engaged
+	SafeMode    bool                 `json:"safe_mode,omitempty"`
+	Fault       *fault.State         `json:"fault,omitempty"`
+	SelfTest    *selftest.Report     `json:"self_test,omitempty"` // the last relay self-test
+	Timer       *offtimer.State      `json:"timer,omitempty"`     // the off timer, while set
+}
+
+// statusHandler reports the tracked state of the fireplace (its flame level is estimated
+// from how long the flame contacts have been held), when auto-off will turn it off, and the
+// state of automation:
+//
+//	GET /status    {"power": "on", "flame_level": 4, "last_command": "flameup", "uptime": "3h0m0s", ...}
+//
+// The reply carries an ETag that follows the state but not the uptime or the off timer's
+// countdown, and a request whose If-None-Match has it replies 304 Not Modified. With
+// ?wait=30s (at most 2m) as well, the request waits for the state to change instead,
+// replying it at once when it does and 304 once the wait is over, so that a display or a
+// microcontroller can follow the fire without a WebSocket or a poll every few seconds.
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		var err error
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 || wait > statusMaxWait {
+			http.Error(w, "status_badwait", http.StatusBadRequest)
+			return
+		}
+	}
+	st := s.status()
+	tag := st.etag()
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatch(inm, tag) {
+		if wait > 0 {
+			st, tag = s.waitStatus(r, tag, wait)
+		}
+		if etagMatch(inm, tag) {
+			w.Header().Set("ETag", tag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// waitStatus waits up to wait, or until the client goes, for the status to lose the ETag tag,
+// returning it as it then is.
+func (s *Server) waitStatus(r *http.Request, tag string, wait time.Duration) (status, string) {
+	ch, cancel := events.Subscribe()
+	defer cancel()
+	timeout := time.After(wait)
+	tick := time.NewTicker(statusRecheck)
+	defer tick.Stop()
+	for {
+		over := false
+		select {
+		case <-ch:
+			// give the tracker, subscribed alongside, a moment to apply the command
+			time.Sleep(100 * time.Millisecond)
+		case <-tick.C:
+		case <-timeout:
+			over = true
+		case <-r.Context().Done():
+			over = true
+		}
+		st := s.status()
+		if now := st.etag(); now != tag || over {
+			return st, now
+		}
+	}
+}
+
+// status puts together the /status reply.
+func (s *Server) status() status {
+	st := status{Uptime: time.Since(started).Round(time.Second).String(), SafeMode: s.SafeMode.Active(),
+		Fault: fault.Latched()}
+	if s.Power != nil {
+		st.State = s.Power.State()
+		st.Levels = s.Power.Levels().Max
+	}
+	if s.Light != nil {
+		l := s.Light.Level()
+		st.Light = &l
+	}
+	if s.Accessories != nil {
+		st.Accessories = s.Accessories.State()
+	}
+	if s.Hold != nil {
+		st.Hold = s.Hold.State()
+	}
+	if s.Demand != nil {
+		d := s.Demand.State()
+		st.Demand = &d
+	}
+	if s.AutoOff != nil {
+		a := s.AutoOff.State()
+		st.AutoOff = &a
+	}
+	if s.Interlocks != nil {
+		st.Interlocks = s.Interlocks.State()
+	}
+	if s.Ignition != nil {
+		if ig := s.Ignition.State(); ig.State != "" {
+			st.Ignition = &ig
+		}
+	}
+	if s.Presence != nil {
+		p := s.Presence.State()
+		st.Presence = &p
+	}
+	if s.Lock != nil {
+		l := s.Lock.State()
+		st.Lock = &l
+	}
+	if s.EStop != nil {
+		if e := s.EStop.State(""); e.Engaged {
+			st.EStop = &e
+		}
+	}
+	if s.Ramp != nil {
+		st.Ramp = s.Ramp.State()
+	}
+	if s.Modulate != nil {
+		st.Modulation = s.Modulate.State()
+	}
+	if s.Resync != nil {
+		st.Resync = s.Resync.State()
+	}
+	if s.Quiet != nil {
+		q := s.Quiet.State()
+		st.Quiet = &q
+	}
+	if s.Thermostat != nil {
+		t := s.Thermostat.State()
+		st.Thermostat = &t
+	}
+	if s.Frost != nil {
+		f := s.Frost.State()
+		st.Frost = &f
+	}
+	if s.Timer != nil {
+		st.Timer = s.Timer.State()
+	}
+	if s.SelfTest != nil {
+		st.SelfTest = s.SelfTest.Last()
+	}
+	return st
+}
+
+// etag returns the weak ETag of st, leaving out what changes with the clock alone.
+func (st status) etag() string {
+	st.Uptime = ""
+	if st.Timer != nil {
+		t := *st.Timer
+		t.Remaining = ""
+		st.Timer = &t
+	}
+	data, _ := json.Marshal(st)
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf(`W/"%016x"`, h.Sum64())
+}
+
+// etagMatch reports whether the If-None-Match header inm names tag, by weak comparison.
+func etagMatch(inm, tag string) bool {
+	for _, t := range strings.Split(inm, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Healthy reports an error when /status can't be put together within timeout, as when a
+// lock it needs is stuck; the systemd watchdog is fed only while the server is healthy.
+func (s *Server) Healthy(timeout time.Duration) error {
+	r, err := http.NewRequest(http.MethodGet, "/status", nil)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		s.statusHandler(discardWriter{http.Header{}}, r)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("status took over %v", timeout)
+	}
+}
+
+// discardWriter is a ResponseWriter that throws the response away.
+type discardWriter struct{ h http.Header }
+
+func (w discardWriter) Header() http.Header         { return w.h }
+func (w discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardWriter) WriteHeader(int)             {}
@@ -0,0 +1,138 @@
+package httpapi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// streamStateCheck is how often a stream compares the tracked state with the last it sent,
+// for changes not made by a command, such as a probe at start.
+const streamStateCheck = time.Second
+
+// streamEvent is one event on /ws and /events.
+type streamEvent struct {
+	// Type is command_started, command_finished, state (power changed, and on connecting),
+	// flame_level or auto_off.
+	Type   string            `json:"type"`
+	Time   time.Time         `json:"time"`
+	Op     string            `json:"op,omitempty"`
+	Result string            `json:"result,omitempty"`
+	Source string            `json:"source,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+	State  *power.State      `json:"state,omitempty"`
+	Level  *int              `json:"level,omitempty"` // flame_level; absent when unknown
+}
+
+// streams ends the streams when CloseStreams is called.
+type streams struct {
+	mu   sync.Mutex
+	done chan struct{} // closed by CloseStreams
+}
+
+func (st *streams) closed() <-chan struct{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.done == nil {
+		st.done = make(chan struct{})
+	}
+	return st.done
+}
+
+// CloseStreams ends every /ws and /events stream, so that a server shutting down (for a
+// stop or an upgrade) needn't wait for their clients; register it with RegisterOnShutdown.
+func (s *Server) CloseStreams() {
+	done := s.streams.closed()
+	s.streams.mu.Lock()
+	defer s.streams.mu.Unlock()
+	select {
+	case <-done:
+	default:
+		close(s.streams.done)
+	}
+}
+
+// streamEvents calls send with the state and then each event as it happens, and keepAlive
+// whenever the stream has been idle for every, until gone is closed, the streams are
+// closed, or either call fails.
+func (s *Server) streamEvents(send func(streamEvent) error, keepAlive func() error, every time.Duration, gone <-chan struct{}) {
+	cmds, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+	starts, unsubscribeStarts := events.SubscribeStarts()
+	defer unsubscribeStarts()
+	idle := time.NewTimer(every)
+	defer idle.Stop()
+	write := send
+	send = func(e streamEvent) error {
+		err := write(e)
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(every)
+		return err
+	}
+
+	var last power.State
+	sendState := func(initial bool) error {
+		if s.Power == nil {
+			return nil
+		}
+		st := s.Power.State()
+		now := time.Now()
+		var err error
+		if initial || st.Power != last.Power || !st.Since.Equal(last.Since) {
+			err = send(streamEvent{Type: "state", Time: now, State: &st})
+		} else if !sameLevel(st.FlameLevel, last.FlameLevel) {
+			err = send(streamEvent{Type: "flame_level", Time: now, Level: st.FlameLevel})
+		}
+		last = st
+		return err
+	}
+	if sendState(true) != nil {
+		return
+	}
+	check := time.NewTicker(streamStateCheck)
+	defer check.Stop()
+	var recheck <-chan time.Time
+	for {
+		var err error
+		select {
+		case <-gone:
+			return
+		case <-s.streams.closed():
+			return
+		case st := <-starts:
+			err = send(streamEvent{Type: "command_started", Time: st.Time, Op: st.Op, Source: st.Source})
+		case cmd := <-cmds:
+			err = send(streamEvent{Type: "command_finished", Time: cmd.Time, Op: cmd.Op, Result: cmd.Result,
+				Source: cmd.Source, Params: cmd.Params})
+			if err == nil && cmd.Op == "off" && cmd.Source == "autooff" && cmd.Result == "ok" {
+				err = send(streamEvent{Type: "auto_off", Time: cmd.Time})
+			}
+			// the power tracker, subscribed alongside, applies the command in a moment
+			recheck = time.After(100 * time.Millisecond)
+		case <-recheck:
+			err = sendState(false)
+		case <-check.C:
+			err = sendState(false)
+		case <-idle.C:
+			idle.Reset(every)
+			err = keepAlive()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func sameLevel(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
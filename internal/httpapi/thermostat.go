@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// setTempHandler reports and sets the thermostat's target, in the configured temperature unit:
+//
+//	GET /settemp                  {"target": 21, "temperature": 19.6, "unit": "C", "role": "room", ...}
+//	GET /settemp?target=21        hold the room at 21 (POST works too)
+//	GET /settemp?target=off       stop the thermostat, leaving the fire as it is
+func (s *Server) setTempHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Thermostat == nil {
+		http.Error(w, "settemp_disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "settemp_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	if v := r.URL.Query().Get("target"); v == "off" {
+		s.Thermostat.Set(nil)
+		logging.Event(logging.Notice, "thermostat off", "from", ClientAddr(r))
+	} else if v != "" {
+		target, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(target) || target < 0 || target > s.Thermostat.MaxTarget() {
+			http.Error(w, "settemp_badtarget", http.StatusBadRequest)
+			return
+		}
+		s.Thermostat.Set(&target)
+		logging.Event(logging.Notice, "thermostat target set", "target", v, "from", ClientAddr(r))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Thermostat.State())
+}
+
+// feedHandler takes a reading pushed for a sensor listed under sensors.feeds:
+//
+//	POST /sensors/feed?name=lounge&value=20.5    feed_ok
+func (s *Server) feedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "feed_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	v, err := strconv.ParseFloat(q.Get("value"), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		http.Error(w, "feed_badvalue", http.StatusBadRequest)
+		return
+	}
+	if err := s.Sensors.Push(q.Get("name"), v); err != nil {
+		http.Error(w, "feed_unknown", http.StatusNotFound)
+		return
+	}
+	w.Write([]byte("feed_ok"))
+}
@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// maxTimer caps how long the off timer can be set for.
+const maxTimer = 24 * time.Hour
+
+// timedOps are the commands that take for=, setting the off timer once they succeed or are
+// queued: /on?for=90m lights the fire and turns it off 90 minutes later.
+var timedOps = map[string]bool{"on": true, "flameup": true, "flamedown": true, "setflame": true}
+
+// offAfter returns the duration of the request's for=, 0 without one. It replies
+// op_badfor, or timer_disabled without an off timer, and returns false when the request
+// can't be served.
+func (s *Server) offAfter(w http.ResponseWriter, r *http.Request, op string) (time.Duration, bool) {
+	v := r.URL.Query().Get("for")
+	if v == "" || !timedOps[op] {
+		return 0, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 || d > maxTimer {
+		http.Error(w, op+"_badfor", http.StatusBadRequest)
+		return 0, false
+	}
+	if s.Timer == nil {
+		http.Error(w, "timer_disabled", http.StatusNotFound)
+		return 0, false
+	}
+	return d, true
+}
+
+// startTimer sets the off timer for d after a command that succeeded or was queued; d is 0
+// without for=.
+func (s *Server) startTimer(r *http.Request, d time.Duration, result string) {
+	if d > 0 && (result == "ok" || result == "queued") {
+		s.Timer.Start(d, "http", gv60.IsDryRun(r.Context()))
+	}
+}
+
+// cancelTimerHandler clears the off timer set with for=, leaving the fire as it is:
+//
+//	POST /cancel_timer    replies cancel_timer_ok, or cancel_timer_idle when it isn't set
+//
+// The timer's countdown is in /status.
+func (s *Server) cancelTimerHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Timer == nil {
+		http.Error(w, "timer_disabled", http.StatusNotFound)
+		return
+	}
+	if !s.Timer.Stop("cancelled from " + ClientAddr(r)) {
+		fmt.Fprintf(w, "cancel_timer_idle")
+		return
+	}
+	fmt.Fprintf(w, "cancel_timer_ok")
+}
@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/auth"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// tokensHandler manages guest tokens:
+//
+//	GET    /tokens                                           list unexpired tokens
+//	POST   /tokens?name=guest&scopes=on,off&expires=48h      mint (or until=2006-01-02T15:04:05Z07:00)
+//	DELETE /tokens?id=1a2b3c4d                               revoke
+func (s *Server) tokensHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Auth == nil || !s.Auth.Enabled() {
+		http.Error(w, "tokens_disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Auth.List())
+	case http.MethodPost:
+		var expires time.Time
+		if v := q.Get("until"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "tokens_baduntil", http.StatusBadRequest)
+				return
+			}
+			expires = t
+		} else {
+			d, err := time.ParseDuration(q.Get("expires"))
+			if err != nil || d <= 0 {
+				http.Error(w, "tokens_badexpires", http.StatusBadRequest)
+				return
+			}
+			expires = time.Now().Add(d)
+		}
+		var scopes []string
+		for _, sc := range strings.Split(q.Get("scopes"), ",") {
+			if !s.validScope(sc) {
+				http.Error(w, "tokens_badscope "+sc, http.StatusBadRequest)
+				return
+			}
+			scopes = append(scopes, sc)
+		}
+		secret, t, err := s.Auth.Mint(q.Get("name"), scopes, expires)
+		if err != nil {
+			logging.Logf(logging.Err, "tokens: %v", err)
+			http.Error(w, "tokens_error", http.StatusInternalServerError)
+			return
+		}
+		logging.Event(logging.Notice, "token minted", "id", t.ID, "name", t.Name,
+			"scopes", strings.Join(t.Scopes, ","), "expires", t.Expires.Format(time.RFC3339))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mintedToken{t, secret})
+	case http.MethodDelete:
+		ok, err := s.Auth.Revoke(q.Get("id"))
+		switch {
+		case err != nil:
+			logging.Logf(logging.Err, "tokens: %v", err)
+			http.Error(w, "tokens_error", http.StatusInternalServerError)
+		case !ok:
+			http.Error(w, "tokens_notfound", http.StatusNotFound)
+		default:
+			logging.Event(logging.Notice, "token revoked", "id", q.Get("id"))
+			fmt.Fprintf(w, "tokens_revoked")
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "tokens_badmethod", http.StatusMethodNotAllowed)
+	}
+}
+
+// validScope reports whether sc is a scope some route requires.
+func (s *Server) validScope(sc string) bool {
+	if sc == auth.ScopeAdmin || sc == auth.ScopeRead {
+		return true
+	}
+	for route := range s.routes() {
+		if sc != "" && routeScope(route) == sc {
+			return true
+		}
+	}
+	return false
+}
+
+// mintedToken is the reply of POST /tokens: the token and its secret, shown only then.
+type mintedToken struct {
+	auth.Token
+	Secret string `json:"token"`
+}
@@ -0,0 +1,25 @@
+package httpapi
+
+import (
+	_ "embed" // for the web UI
+	"net/http"
+	"strings"
+)
+
+// uiPage is the web UI: buttons for the fire and its flame, a flame level slider, the state
+// and burn time, and the child lock, kept current from /events.
+//
+//go:embed ui/index.html
+var uiPage []byte
+
+// wantsUI reports whether a request for / comes from a browser, which is given the web UI
+// rather than the list of routes.
+func wantsUI(r *http.Request) bool {
+	return r.URL.Path == "/" && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func serveUI(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(uiPage)
+}
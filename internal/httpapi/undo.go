@@ -0,0 +1,29 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/actions"
+)
+
+// undoHandler reverses the last command that changed the fireplace (see actions.Runner.Undo):
+//
+//	POST /undo    undo_ok flameup, or undo_busy flameup etc. if a reversing command failed;
+//	              undo_nothing or undo_impossible off (409) when there is nothing it can undo
+func (s *Server) undoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "undo_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	op, result, err := s.Actions.Undo("http")
+	switch err {
+	case nil:
+		fmt.Fprintf(w, "undo_%s %s", result, op)
+	case actions.ErrNothingToUndo:
+		http.Error(w, "undo_nothing", http.StatusConflict)
+	default:
+		http.Error(w, "undo_impossible "+op, http.StatusConflict)
+	}
+}
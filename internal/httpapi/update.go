@@ -0,0 +1,49 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/selfupdate"
+)
+
+// updateHandler checks for and installs signed releases:
+//
+//	GET  /update    {"tag": "v1.4.0", "current": "v1.3.2", "newer": true}
+//	POST /update    install the latest release and restart into it: update_ok or update_current
+func (s *Server) updateHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Updater == nil {
+		http.Error(w, "update_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		rel, err := s.Updater.Latest()
+		if err != nil {
+			logging.Logf(logging.Warning, "selfupdate: %v", err)
+			http.Error(w, "update_error", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rel)
+	case http.MethodPost:
+		logging.Event(logging.Notice, "self-update requested", "from", ClientAddr(r))
+		rel, err := s.Updater.Update()
+		switch {
+		case err == selfupdate.ErrBadSignature:
+			logging.Event(logging.Err, "self-update refused: bad signature", "tag", rel.Tag)
+			http.Error(w, "update_badsignature", http.StatusBadGateway)
+		case err != nil:
+			logging.Logf(logging.Warning, "selfupdate: %v", err)
+			http.Error(w, "update_error", http.StatusBadGateway)
+		case !rel.Newer:
+			w.Write([]byte("update_current"))
+		default:
+			w.Write([]byte("update_ok"))
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "update_badmethod", http.StatusMethodNotAllowed)
+	}
+}
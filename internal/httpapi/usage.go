@@ -0,0 +1,100 @@
+package httpapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/usage"
+)
+
+// counterName is what a usage counter may be called.
+var counterName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// usageReply is the JSON of GET /usage.
+type usageReply struct {
+	Period      string          `json:"period"`
+	CostPerHour float64         `json:"cost_per_hour,omitempty"`
+	History     []usage.Period  `json:"history"`
+	Counters    []usage.Counter `json:"counters"`
+}
+
+// usageHandler reports the burn time by day, week or month, as JSON or (with format=csv or
+// Accept: text/csv) CSV, and resets the counters:
+//
+//	GET    /usage?period=week&since=2026-01-01    {"period": "week", "cost_per_hour": 1.2, "history": [{"start": "2025-12-29", "hours": 4.5, "cost": 5.4}, ...], "counters": [...]}
+//	GET    /usage?period=month&format=csv         start,hours,cost
+//	POST   /usage?counter=tank                    reset the counter tank, starting it if new
+//	DELETE /usage?counter=tank                    remove the counter tank
+func (s *Server) usageHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Usage == nil {
+		http.Error(w, "usage_disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		name := q.Get("counter")
+		if !counterName.MatchString(name) {
+			http.Error(w, "usage_badcounter", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			if !s.Usage.Remove(name) {
+				http.Error(w, "usage_nocounter", http.StatusNotFound)
+				return
+			}
+			logging.Event(logging.Notice, "usage counter removed", "counter", name, "from", ClientAddr(r))
+		} else {
+			s.Usage.Reset(name)
+			logging.Event(logging.Notice, "usage counter reset", "counter", name, "from", ClientAddr(r))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Usage.Counters())
+		return
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "usage_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	period := q.Get("period")
+	if period == "" {
+		period = "day"
+	}
+	if !usage.Periods[period] {
+		http.Error(w, "usage_badperiod", http.StatusBadRequest)
+		return
+	}
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		var err error
+		if since, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			http.Error(w, "usage_badsince", http.StatusBadRequest)
+			return
+		}
+	}
+	history := s.Usage.History(period, since)
+	if q.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"start", "hours", "cost"})
+		for _, p := range history {
+			cost := ""
+			if p.Cost != nil {
+				cost = strconv.FormatFloat(*p.Cost, 'f', 2, 64)
+			}
+			cw.Write([]string{p.Start, strconv.FormatFloat(p.Hours, 'f', -1, 64), cost})
+		}
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usageReply{Period: period, CostPerHour: s.Usage.CostPerHour(),
+		History: history, Counters: s.Usage.Counters()})
+}
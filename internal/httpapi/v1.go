@@ -0,0 +1,210 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// The v1 JSON API answers every command with a v1Response instead of the plain-text
+// op_result, for clients that want a status code and an error to switch on:
+//
+//	POST /api/v1/command/on?pin=1234          {"command": "on", "result": "ok", "time": "..."}
+//	POST /api/v1/command/flameup              {"command": "flameup", "result": "busy",
+//	                                           "error": {"code": "busy", "message": "..."}, "time": "..."}
+//	POST /api/v1/command/on                   {"command": "on", "result": "confirm", "confirm": "...", ...}
+//	                                          with lockout.confirm set, then POST /confirm?code=...
+//	POST /api/v1/command/fan?speed=2
+//	POST /api/v1/command/splitflow?state=on
+//	POST /api/v1/undo                         {"command": "undo", "result": "ok", "undone": "flameup", ...}
+//	GET  /api/v1/status, /api/v1/commands     as /status and /commands
+//
+// Each command route needs the same token scope as its plain-text route.
+
+// v1Response is the reply to a v1 command.
+type v1Response struct {
+	Command string            `json:"command"`
+	Params  map[string]string `json:"params,omitempty"`
+	Result  string            `json:"result"`            // ok, queued, confirm, or the failure's code
+	Undone  string            `json:"undone,omitempty"`  // undo: the command reversed
+	Confirm string            `json:"confirm,omitempty"` // confirm: the token for /confirm
+	Error   *v1Error          `json:"error,omitempty"`
+	Time    time.Time         `json:"time"`
+}
+
+type v1Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// v1Errors describes each failure result, with its HTTP status.
+var v1Errors = map[string]struct {
+	status  int
+	message string
+}{
+	"busy":         {http.StatusServiceUnavailable, "another relay sequence is running"},
+	"queue_full":   {http.StatusServiceUnavailable, "the command queue is full"},
+	"lockout":      {http.StatusConflict, "ignition is locked out"},
+	"overridden":   {http.StatusConflict, "a higher-priority source holds the fireplace"},
+	"cancelled":    {http.StatusConflict, "the command was cancelled"},
+	"timeout":      {http.StatusGatewayTimeout, "the command ran past its timeout and was cut short"},
+	"abandoned":    {http.StatusRequestTimeout, "the request was abandoned before the command ended"},
+	"fault":        {http.StatusServiceUnavailable, "a fault is latched; only off is accepted"},
+	"unsupported":  {http.StatusNotImplemented, "the fireplace doesn't support this command"},
+	"bad_pin":      {http.StatusForbidden, "wrong or missing ignition pin"},
+	"bad_request":  {http.StatusBadRequest, "missing or invalid parameter"},
+	"nothing":      {http.StatusConflict, "there is no command to undo"},
+	"not_undoable": {http.StatusConflict, "the last command can't be undone"},
+	"error":        {http.StatusInternalServerError, "the command failed"},
+}
+
+// v1Routes returns the v1 JSON API routes.
+func (s *Server) v1Routes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/api/v1/command/on":        s.requireConfirm(true, s.v1Command("on", fireplace.Fireplace.On)),
+		"/api/v1/command/off":       s.v1Command("off", fireplace.Fireplace.Off),
+		"/api/v1/command/flameup":   s.v1Command("flameup", fireplace.Fireplace.FlameUp),
+		"/api/v1/command/flamedown": s.v1Command("flamedown", fireplace.Fireplace.FlameDown),
+		"/api/v1/command/aux":       s.v1Command("aux", fireplace.Fireplace.Aux),
+		"/api/v1/command/pilot":     s.v1Command("pilot", fireplace.ToPilot),
+		"/api/v1/command/aux_on":    s.v1Command("aux_on", auxBurner(true)),
+		"/api/v1/command/aux_off":   s.v1Command("aux_off", auxBurner(false)),
+		"/api/v1/command/fan":       s.v1Command("fan", nil),
+		"/api/v1/command/splitflow": s.v1Command("splitflow", nil),
+		"/api/v1/undo":              s.v1Undo,
+		"/api/v1/status":            s.statusHandler,
+		"/api/v1/commands":          s.commandsHandler,
+	}
+}
+
+// writeV1 sends resp with the status its result calls for.
+func writeV1(w http.ResponseWriter, resp v1Response) {
+	resp.Time = time.Now()
+	status := http.StatusOK
+	if resp.Result == "queued" || resp.Result == "confirm" {
+		status = http.StatusAccepted
+	} else if resp.Result != "ok" {
+		e, ok := v1Errors[resp.Result]
+		if !ok {
+			e = v1Errors["error"]
+		}
+		resp.Error = &v1Error{Code: resp.Result, Message: e.message}
+		status = e.status
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// v1Command runs a fireplace command; run is nil for fan and splitflow, which take a
+// parameter.
+func (s *Server) v1Command(op string, run func(f fireplace.Fireplace) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, op+"_badmethod", http.StatusMethodNotAllowed)
+			return
+		}
+		resp := v1Response{Command: op}
+		q := r.URL.Query()
+		var d time.Duration // for=, setting the off timer
+		if v := q.Get("for"); v != "" && timedOps[op] {
+			var err error
+			d, err = time.ParseDuration(v)
+			switch {
+			case err != nil || d <= 0 || d > maxTimer:
+				resp.Result = "bad_request"
+			case s.Timer == nil:
+				resp.Result = "unsupported"
+			}
+			if resp.Result != "" {
+				writeV1(w, resp)
+				return
+			}
+		}
+		switch op {
+		case "on":
+			if s.IgnitionPIN != "" && subtle.ConstantTimeCompare([]byte(q.Get("pin")), []byte(s.IgnitionPIN)) != 1 {
+				logging.Event(logging.Warning, "ignition refused: wrong or missing pin", "from", ClientAddr(r))
+				events.RecordContext(r.Context(), "on", "badpin", "http", nil)
+				resp.Result = "bad_pin"
+				writeV1(w, resp)
+				return
+			}
+		case "fan":
+			fan, ok := s.Fire.(fireplace.Fan)
+			speed, err := strconv.Atoi(q.Get("speed"))
+			switch {
+			case !ok:
+				resp.Result = "unsupported"
+			case err != nil || speed < 0:
+				resp.Result = "bad_request"
+			}
+			if resp.Result != "" {
+				writeV1(w, resp)
+				return
+			}
+			resp.Params = map[string]string{"speed": strconv.Itoa(speed)}
+			run = func(fireplace.Fireplace) error { return fan.SetFan(speed) }
+		case "splitflow":
+			sf, ok := s.Fire.(fireplace.SplitFlow)
+			state := q.Get("state")
+			switch {
+			case !ok:
+				resp.Result = "unsupported"
+			case state != "on" && state != "off":
+				resp.Result = "bad_request"
+			}
+			if resp.Result != "" {
+				writeV1(w, resp)
+				return
+			}
+			resp.Params = map[string]string{"state": state}
+			run = func(fireplace.Fireplace) error { return sf.SetSplitFlow(state == "on") }
+		}
+		if s.Busy.Mode == "queue" {
+			resp.Result = "queued"
+			if !s.tryEnqueue(r, op, run) {
+				events.RecordContext(r.Context(), op, "busy", "http", resp.Params)
+				s.retryAfter(w)
+				resp.Result = "queue_full"
+			}
+			s.startTimer(r, d, resp.Result)
+			writeV1(w, resp)
+			return
+		}
+		resp.Result = s.Actions.DoContext(r.Context(), op, "http", run)
+		events.RecordContext(r.Context(), op, resp.Result, "http", resp.Params)
+		s.startTimer(r, d, resp.Result)
+		if resp.Result == "busy" && s.Busy.Mode == "reject" {
+			s.retryAfter(w)
+		}
+		writeV1(w, resp)
+	}
+}
+
+// v1Undo is POST /undo for the v1 API.
+func (s *Server) v1Undo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "undo_badmethod", http.StatusMethodNotAllowed)
+		return
+	}
+	op, result, err := s.Actions.Undo("http")
+	resp := v1Response{Command: "undo", Result: result, Undone: op}
+	switch err {
+	case nil:
+	case actions.ErrNothingToUndo:
+		resp.Result = "nothing"
+	default:
+		resp.Result = "not_undoable"
+	}
+	writeV1(w, resp)
+}
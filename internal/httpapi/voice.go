@@ -0,0 +1,33 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// googleHandler is the fulfillment URL of a Google Home smart home Action, given a token with
+// the google scope through account linking:
+//
+//	POST /google    {"requestId": "...", "inputs": [{"intent": "action.devices.SYNC"}]}
+func (s *Server) googleHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Google == nil {
+		http.Error(w, "google_disabled", http.StatusNotFound)
+		return
+	}
+	logging.Event(logging.Debug, "google fulfillment", "from", ClientAddr(r))
+	s.Google.ServeHTTP(w, r)
+}
+
+// alexaHandler takes Alexa Smart Home directives forwarded by a skill's Lambda function, with
+// a token with the alexa scope:
+//
+//	POST /alexa    {"directive": {"header": {"namespace": "Alexa.PowerController", "name": "TurnOn", ...}, ...}}
+func (s *Server) alexaHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Alexa == nil {
+		http.Error(w, "alexa_disabled", http.StatusNotFound)
+		return
+	}
+	logging.Event(logging.Debug, "alexa directive", "from", ClientAddr(r))
+	s.Alexa.ServeHTTP(w, r)
+}
@@ -0,0 +1,88 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/webhooks"
+)
+
+// maxHookSize bounds a webhook's JSON.
+const maxHookSize = 16 << 10
+
+// webhooksHandler lists, registers and removes webhooks:
+//
+//	GET    /webhooks               [{"name": "phone", "url": "https://ntfy.sh/...", "events": ["on", "off"], ...}, ...]
+//	PUT    /webhooks               register the hook in the body, or replace the one of its name
+//	DELETE /webhooks?name=phone    remove a registered hook
+//	POST   /webhooks?test=phone    call a hook with a test event; replies webhooks_sent
+//
+// Hooks set in the configuration are listed with "configured": true and can't be replaced
+// or removed here.
+func (s *Server) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Webhooks == nil {
+		http.Error(w, "webhooks_disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Webhooks.List())
+	case http.MethodPut:
+		var h webhooks.Hook
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxHookSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&h); err != nil {
+			http.Error(w, "webhooks_badjson", http.StatusBadRequest)
+			return
+		}
+		if err := h.Validate(); err != nil {
+			http.Error(w, "webhooks_invalid "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch err := s.Webhooks.Put(h); err {
+		case nil:
+		case webhooks.ErrConfigured:
+			http.Error(w, "webhooks_configured", http.StatusConflict)
+			return
+		default:
+			logging.Logf(logging.Err, "webhooks: %v", err)
+			http.Error(w, "webhooks_error", http.StatusInternalServerError)
+			return
+		}
+		logging.Event(logging.Notice, "webhook registered", "name", h.Name, "from", ClientAddr(r))
+		fmt.Fprintf(w, "webhooks_saved")
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		switch err := s.Webhooks.Delete(name); err {
+		case nil:
+		case webhooks.ErrNotFound:
+			http.Error(w, "webhooks_notfound", http.StatusNotFound)
+			return
+		case webhooks.ErrConfigured:
+			http.Error(w, "webhooks_configured", http.StatusConflict)
+			return
+		default:
+			logging.Logf(logging.Err, "webhooks: %v", err)
+			http.Error(w, "webhooks_error", http.StatusInternalServerError)
+			return
+		}
+		logging.Event(logging.Notice, "webhook removed", "name", name, "from", ClientAddr(r))
+		fmt.Fprintf(w, "webhooks_deleted")
+	case http.MethodPost:
+		switch err := s.Webhooks.Test(r.URL.Query().Get("test")); err {
+		case nil:
+			fmt.Fprintf(w, "webhooks_sent")
+		case webhooks.ErrNotFound:
+			http.Error(w, "webhooks_notfound", http.StatusNotFound)
+		default:
+			http.Error(w, "webhooks_failed "+err.Error(), http.StatusBadGateway)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE, POST")
+		http.Error(w, "webhooks_badmethod", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,199 @@
+package httpapi
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+const (
+	// wsGUID is appended to the client's key to form the handshake's accept key (RFC 6455).
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// wsWriteTimeout drops a client that stops reading rather than letting it back up.
+	wsWriteTimeout = 10 * time.Second
+	// wsPing is how long an idle connection waits to be pinged, so proxies and NAT keep it open.
+	wsPing = 30 * time.Second
+	// wsMaxFrame bounds a client's frame; clients have nothing to say beyond pings.
+	wsMaxFrame = 4096
+)
+
+// WebSocket opcodes.
+const (
+	wsText   = 0x1
+	wsClose  = 0x8
+	wsPingOp = 0x9
+	wsPong   = 0xA
+)
+
+// wsHandler pushes events to a WebSocket client as they happen, so a UI needn't poll
+// /status: commands starting and finishing, the power state (on connecting and whenever it
+// changes), the estimated flame level, and auto-off turning the fire off. Clients send
+// nothing but pings and a close:
+//
+//	GET /ws (Upgrade: websocket)    {"type": "command_finished", "time": "...", "op": "on", "result": "ok", "source": "http"}
+//	                                {"type": "state", "time": "...", "state": {"power": "on", "flame_level": 6, ...}}
+//	                                {"type": "flame_level", "time": "...", "level": 6}
+func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" || !headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "ws_notwebsocket", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "ws_badversion", http.StatusUpgradeRequired)
+		return
+	}
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "ws_unsupported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		http.Error(w, "ws_unsupported", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	logging.Event(logging.Info, "websocket client connected", "from", ClientAddr(r))
+	c := &wsConn{conn: conn}
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		c.readLoop(rw.Reader)
+	}()
+	s.streamEvents(c.send, func() error { return c.write(wsPingOp, nil) }, wsPing, gone)
+	logging.Event(logging.Info, "websocket client disconnected", "from", ClientAddr(r))
+}
+
+// headerHas reports whether the comma-separated header name lists token.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn writes WebSocket frames; the reader and the event loop both write.
+type wsConn struct {
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+func (c *wsConn) send(e streamEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return c.write(wsText, data)
+}
+
+// write sends one unmasked frame, as a server does.
+func (c *wsConn) write(opcode byte, payload []byte) error {
+	hdr := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, 126, byte(n>>8), byte(n))
+	default:
+		hdr = append(hdr, 127)
+		hdr = append(hdr, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(append(hdr, payload...))
+	return err
+}
+
+// readLoop answers the client's pings and returns once it closes the connection or sends
+// something it shouldn't.
+func (c *wsConn) readLoop(in *bufio.Reader) {
+	for {
+		opcode, payload, err := readFrame(in)
+		if err != nil {
+			if err != io.EOF {
+				c.write(wsClose, []byte{0x03, 0xEA}) // 1002, protocol error
+			}
+			return
+		}
+		switch opcode {
+		case wsClose:
+			c.write(wsClose, nil)
+			return
+		case wsPingOp:
+			if c.write(wsPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+var errBadFrame = errors.New("bad websocket frame")
+
+// readFrame reads one masked client frame, returning its opcode and unmasked payload.
+// Fragments are returned as they come; the only messages expected are control frames.
+func readFrame(in *bufio.Reader) (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(in, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := hdr[0] & 0x0F
+	if hdr[1]&0x80 == 0 {
+		return 0, nil, errBadFrame // clients must mask
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(in, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(in, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxFrame {
+		return 0, nil, errBadFrame
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(in, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(in, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
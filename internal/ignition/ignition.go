@@ -0,0 +1,170 @@
+// Package ignition proves the flame with a sensor after the fire is lit, and retries an
+// ignition that didn't take: the fire is turned off and lit again after a backoff, until it
+// lights or the attempts run out, when the on is recorded as ignition_failed and the fire is
+// left off.
+package ignition
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/sensor"
+)
+
+// source is what retries and the off after a failed ignition are recorded as.
+const source = "ignition"
+
+// pollEvery is how often the sensor is read while proving the flame.
+const pollEvery = time.Second
+
+// Verifier proves each ignition of the main fireplace.
+type Verifier struct {
+	cfg     config.Ignition
+	sensors *sensor.Registry
+	runner  *actions.Runner
+
+	mu    sync.Mutex
+	gen   int // bumped by every on, off or pilot not our own, ending a check in progress
+	state State
+}
+
+// State is the outcome of the last ignition.
+type State struct {
+	State   string    `json:"state"`   // verifying, lit, retrying or failed
+	Attempt int       `json:"attempt"` // 1 for the first ignition
+	Time    time.Time `json:"time"`    // when State began
+}
+
+// Start begins proving ignitions, and retries them with runner.
+func Start(cfg config.Ignition, sensors *sensor.Registry, runner *actions.Runner) *Verifier {
+	v := &Verifier{cfg: cfg, sensors: sensors, runner: runner}
+	ch, _ := events.Subscribe()
+	fault.Go("ignition", func() { v.watch(ch) })
+	return v
+}
+
+// State returns the outcome of the last ignition; State is empty before the first.
+func (v *Verifier) State() State {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.state
+}
+
+func (v *Verifier) watch(ch <-chan events.Command) {
+	for c := range ch {
+		if c.Source == source || c.Result != "ok" || (c.Op != "on" && c.Op != "off" && c.Op != "pilot") {
+			continue
+		}
+		v.mu.Lock()
+		v.gen++
+		gen := v.gen
+		v.mu.Unlock()
+		if c.Op == "on" {
+			fault.Go("ignition", func() { v.verify(gen) })
+		}
+	}
+}
+
+// verify proves the ignition begun as generation gen, retrying while attempts remain.
+func (v *Verifier) verify(gen int) {
+	for attempt := 1; ; attempt++ {
+		if !v.set(gen, "verifying", attempt) {
+			return
+		}
+		lit, current := v.proven(gen)
+		if !current {
+			return
+		}
+		if lit {
+			v.set(gen, "lit", attempt)
+			logging.Event(logging.Info, "ignition proven", "attempt", strconv.Itoa(attempt), "sensor", v.cfg.Role)
+			return
+		}
+		if attempt >= v.cfg.Attempts {
+			v.set(gen, "failed", attempt)
+			logging.Event(logging.Err, "ignition failed", "attempts", strconv.Itoa(attempt), "sensor", v.cfg.Role)
+			events.Record("on", "ignition_failed", source)
+			v.runner.Run("off", source)
+			return
+		}
+		v.set(gen, "retrying", attempt)
+		backoff := v.cfg.Backoff << uint(attempt-1)
+		logging.Event(logging.Warning, "ignition not proven; retrying", "attempt", strconv.Itoa(attempt), "in", backoff.String())
+		v.runner.Run("off", source)
+		if !v.wait(gen, backoff) {
+			return
+		}
+		if result := v.runner.Run("on", source); result != "ok" {
+			v.set(gen, "failed", attempt+1)
+			logging.Event(logging.Err, "ignition retry refused", "result", result)
+			return
+		}
+	}
+}
+
+// proven watches the sensor for up to the timeout and reports whether it proved the flame,
+// and whether gen is still current.
+func (v *Verifier) proven(gen int) (lit, current bool) {
+	start := time.Now()
+	base, haveBase := v.sensors.ForRole(v.cfg.Role)
+	for time.Since(start) < v.cfg.Timeout {
+		if !v.wait(gen, pollEvery) {
+			return false, false
+		}
+		r, ok := v.sensors.ForRole(v.cfg.Role)
+		if !ok || !r.Time.After(start) {
+			continue
+		}
+		if v.cfg.Above != nil && r.Value > *v.cfg.Above {
+			return true, true
+		}
+		if v.cfg.Rise > 0 {
+			if !haveBase {
+				base, haveBase = r, true
+			}
+			if r.Value-base.Value >= v.cfg.Rise {
+				return true, true
+			}
+		}
+	}
+	return false, v.current(gen)
+}
+
+// wait sleeps for d, reporting false as soon as gen is no longer current.
+func (v *Verifier) wait(gen int, d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if !v.current(gen) {
+			return false
+		}
+		step := time.Until(deadline)
+		if step > pollEvery {
+			step = pollEvery
+		}
+		time.Sleep(step)
+	}
+	return v.current(gen)
+}
+
+func (v *Verifier) current(gen int) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return gen == v.gen
+}
+
+// set records the state of generation gen, reporting false if gen is no longer current.
+func (v *Verifier) set(gen int, state string, attempt int) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if gen != v.gen {
+		return false
+	}
+	v.state = State{State: state, Attempt: attempt, Time: time.Now()}
+	return true
+}
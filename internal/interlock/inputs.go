@@ -0,0 +1,99 @@
+package interlock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// Inputs follows the GPIO inputs of config interlocks, each of which must be closed for the
+// fire to be lit or turned up. An input that can't be read counts as open.
+type Inputs struct {
+	cfgs []config.InterlockInput
+
+	mu     sync.Mutex
+	closed map[string]bool
+}
+
+// NewInputs returns the inputs, all open until Start reads them.
+func NewInputs(cfgs []config.InterlockInput) *Inputs {
+	return &Inputs{cfgs: cfgs, closed: map[string]bool{}}
+}
+
+// Guard is a Runner guard refusing on and flame up while an input is open; a refusal wraps
+// fireplace.ErrLockout.
+func (in *Inputs) Guard(op, source string) error {
+	if op != "on" && op != "flameup" {
+		return nil
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for _, c := range in.cfgs {
+		if !in.closed[c.Name] {
+			return fmt.Errorf("%w: interlock %s is open", fireplace.ErrLockout, c.Name)
+		}
+	}
+	return nil
+}
+
+// State returns each input as closed or open, by name.
+func (in *Inputs) State() map[string]string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	out := map[string]string{}
+	for _, c := range in.cfgs {
+		out[c.Name] = map[bool]string{true: "closed", false: "open"}[in.closed[c.Name]]
+	}
+	return out
+}
+
+// Start reads the inputs, once before returning and then every second; an input with
+// force_off that opens turns off the fireplaces of runners.
+func (in *Inputs) Start(chip *relay.Chip, runners ...*actions.Runner) error {
+	lines := make([]relay.InputLine, len(in.cfgs))
+	for i, c := range in.cfgs {
+		l, err := chip.Input(c.GPIO, c.ActiveLow)
+		if err != nil {
+			return fmt.Errorf("interlocks.%s: %v", c.Name, err)
+		}
+		lines[i] = l
+	}
+	poll := func() {
+		for i, c := range in.cfgs {
+			v, err := lines[i].Value()
+			if err != nil {
+				logging.Logf(logging.Err, "interlock %s: gpio: %v", c.Name, err)
+			}
+			in.set(c, err == nil && v == 1, runners)
+		}
+	}
+	poll()
+	go func() {
+		for range time.Tick(pollEvery) {
+			poll()
+		}
+	}()
+	return nil
+}
+
+func (in *Inputs) set(c config.InterlockInput, closed bool, runners []*actions.Runner) {
+	in.mu.Lock()
+	was, known := in.closed[c.Name]
+	in.closed[c.Name] = closed
+	in.mu.Unlock()
+	if known && was == closed {
+		return
+	}
+	logging.Event(logging.Info, "interlock input", "name", c.Name, "state", map[bool]string{true: "closed", false: "open"}[closed])
+	if !closed && known && c.ForceOff {
+		for _, r := range runners {
+			r.Run("off", source)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package interlock keeps the fireplace and the central heating of the same room from
+// running at once. The heating's state comes from an MQTT topic (e.g. a thermostat's
+// zone "heating" state) or a GPIO input wired to its call-for-heat signal.
+//
+// With policy fire_yields, ignition is refused while the heating is heating and a burning
+// fire is turned off when it starts. With heating_yields, the fireplace's own state is
+// published (MQTT and/or a GPIO output) for the heating controller to hold off on.
+//
+// Inputs are further interlocks on GPIO inputs, such as door contacts, that must be closed
+// for the fire to be lit or turned up.
+package interlock
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/mqtt"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// source is what the interlock's own commands are recorded as; it ranks as safety.
+const source = "interlock"
+
+// pollEvery is how often a GPIO input is read.
+const pollEvery = time.Second
+
+// Interlock tracks whether the central heating is heating.
+type Interlock struct {
+	cfg config.Interlock
+
+	mu      sync.Mutex
+	heating bool
+}
+
+// New returns an interlock that considers the heating idle until Start learns otherwise.
+func New(cfg config.Interlock) *Interlock {
+	return &Interlock{cfg: cfg}
+}
+
+// Check refuses ignition while the heating is heating, under policy fire_yields.
+func (il *Interlock) Check() error {
+	if il.cfg.Policy != "fire_yields" {
+		return nil
+	}
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	if il.heating {
+		return errors.New("central heating is heating")
+	}
+	return nil
+}
+
+// Start follows the heating's state and, under heating_yields, publishes the fireplace's.
+func (il *Interlock) Start(chip *relay.Chip, client *mqtt.Client, runner *actions.Runner) error {
+	if (il.cfg.Topic != "" || il.cfg.OutputTopic != "") && client == nil {
+		return errors.New("interlock: MQTT topics need mqtt.broker")
+	}
+	if il.cfg.Topic != "" {
+		client.Subscribe(il.cfg.Topic, func(_ string, payload []byte) {
+			il.set(il.active(string(payload)), runner)
+		})
+	}
+	if il.cfg.GPIO != nil {
+		in, err := chip.Input(*il.cfg.GPIO, il.cfg.ActiveLow)
+		if err != nil {
+			return err
+		}
+		go func() {
+			for range time.Tick(pollEvery) {
+				v, err := in.Value()
+				if err != nil {
+					logging.Logf(logging.Err, "interlock: gpio: %v", err)
+					continue
+				}
+				il.set(v == 1, runner)
+			}
+		}()
+	}
+	if il.cfg.Policy == "heating_yields" {
+		var out relay.Line
+		if il.cfg.OutputGPIO != nil {
+			l, err := chip.Output(*il.cfg.OutputGPIO, 0)
+			if err != nil {
+				return err
+			}
+			out = l
+		}
+		go il.publishFire(client, out)
+	}
+	return nil
+}
+
+func (il *Interlock) active(payload string) bool {
+	payload = strings.TrimSpace(payload)
+	for _, v := range il.cfg.ActiveValues {
+		if strings.EqualFold(v, payload) {
+			return true
+		}
+	}
+	return false
+}
+
+func (il *Interlock) set(heating bool, runner *actions.Runner) {
+	il.mu.Lock()
+	changed := heating != il.heating
+	il.heating = heating
+	il.mu.Unlock()
+	if !changed {
+		return
+	}
+	logging.Event(logging.Info, "interlock: central heating", "heating", map[bool]string{true: "on", false: "off"}[heating])
+	if heating && il.cfg.Policy == "fire_yields" {
+		runner.Run("off", source)
+	}
+}
+
+// publishFire reports the fireplace as on or off after each successful on or off command.
+func (il *Interlock) publishFire(client *mqtt.Client, out relay.Line) {
+	ch, _ := events.Subscribe()
+	for c := range ch {
+		if c.Result != "ok" || (c.Op != "on" && c.Op != "off") {
+			continue
+		}
+		if client != nil && il.cfg.OutputTopic != "" {
+			client.Publish(il.cfg.OutputTopic, true, []byte(strings.ToUpper(c.Op)))
+		}
+		if out != nil {
+			v := 0
+			if c.Op == "on" {
+				v = 1
+			}
+			out.SetValue(v)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package light drives the fireplace's ember/accent lighting: a plain relay or GPIO line, or
+// an LED driver dimmed with software or hardware PWM.
+package light
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// Output drives the light at a brightness from 0 (off) to 100 (full).
+type Output interface {
+	SetBrightness(pct int) error
+	// Dimmable reports whether brightness values between 0 and 100 are meaningful.
+	Dimmable() bool
+}
+
+// ErrBadState is returned by Set for an unknown state.
+var ErrBadState = errors.New("light: unknown state")
+
+// Controller tracks the light's brightness and runs fades.
+type Controller struct {
+	mu          sync.Mutex
+	out         Output
+	level       int           // brightness currently applied to the output
+	target      int           // brightness the light is at or fading towards
+	lastOn      int           // brightness restored by state "on"
+	defaultFade time.Duration // used when Set is given a negative fade
+	fadeStop    chan struct{}
+}
+
+// New returns a controller for out, which starts switched off.
+func New(out Output, defaultFade time.Duration) *Controller {
+	return &Controller{out: out, lastOn: 100, defaultFade: defaultFade}
+}
+
+// Dimmable reports whether the output supports brightness levels.
+func (c *Controller) Dimmable() bool {
+	return c.out.Dimmable()
+}
+
+// Level returns the brightness currently applied to the output.
+func (c *Controller) Level() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.level
+}
+
+// Target returns the brightness the light is at or fading towards.
+func (c *Controller) Target() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.target
+}
+
+// Set changes the light: state is "", "on", "off" or "toggle", brightness (0-100) overrides
+// the state when not negative, and a negative fade uses the default. It returns the new
+// target brightness.
+func (c *Controller) Set(state string, brightness int, fade time.Duration) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	target := c.target
+	switch state {
+	case "":
+	case "on":
+		target = c.lastOn
+	case "off":
+		target = 0
+	case "toggle":
+		if c.target > 0 {
+			target = 0
+		} else {
+			target = c.lastOn
+		}
+	default:
+		return c.target, ErrBadState
+	}
+	if brightness >= 0 {
+		target = brightness
+	}
+	if fade < 0 {
+		fade = c.defaultFade
+	}
+	if !c.out.Dimmable() && target > 0 {
+		target = 100
+	}
+	if target != c.target {
+		c.fade(target, fade)
+	}
+	return c.target, nil
+}
+
+// fade moves the light towards target over d; callers must hold mu.
+// Any fade already in progress is abandoned at its current brightness.
+func (c *Controller) fade(target int, d time.Duration) {
+	if c.fadeStop != nil {
+		close(c.fadeStop)
+		c.fadeStop = nil
+	}
+	c.target = target
+	if target > 0 {
+		c.lastOn = target
+	}
+	if d <= 0 || !c.out.Dimmable() {
+		c.setLevel(target)
+		return
+	}
+	stop := make(chan struct{})
+	c.fadeStop = stop
+	go func(from int) {
+		const step = 20 * time.Millisecond
+		steps := int(d / step)
+		if steps < 1 {
+			steps = 1
+		}
+		ticker := time.NewTicker(step)
+		defer ticker.Stop()
+		for i := 1; i <= steps; i++ {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			c.mu.Lock()
+			select {
+			case <-stop:
+				c.mu.Unlock()
+				return
+			default:
+			}
+			c.setLevel(from + (target-from)*i/steps)
+			c.mu.Unlock()
+		}
+	}(c.level)
+}
+
+// setLevel applies a brightness immediately; callers must hold mu.
+func (c *Controller) setLevel(pct int) {
+	if err := c.out.SetBrightness(pct); err != nil {
+		logging.Logf(logging.Err, "light: %v", err)
+		return
+	}
+	c.level = pct
+}
+
+// Relay switches a relay or GPIO line; any non-zero brightness is full on.
+type Relay struct {
+	line       relay.Line
+	activeHigh bool
+}
+
+// NewRelay returns an on/off output on line.
+func NewRelay(line relay.Line, activeHigh bool) *Relay {
+	return &Relay{line: line, activeHigh: activeHigh}
+}
+
+func (l *Relay) SetBrightness(pct int) error {
+	return l.line.SetValue(LineValue(pct > 0, l.activeHigh))
+}
+
+func (l *Relay) Dimmable() bool {
+	return false
+}
+
+// SoftPWM dims an LED driver on a plain GPIO line by toggling it from a goroutine.
+type SoftPWM struct {
+	line       relay.Line
+	activeHigh bool
+	period     time.Duration
+	duty       chan int
+}
+
+// NewSoftPWM starts software PWM on line at hz.
+func NewSoftPWM(line relay.Line, activeHigh bool, hz int) *SoftPWM {
+	l := &SoftPWM{line: line, activeHigh: activeHigh, period: time.Second / time.Duration(hz), duty: make(chan int)}
+	go l.run()
+	return l
+}
+
+func (l *SoftPWM) run() {
+	duty := 0
+	for {
+		if duty <= 0 || duty >= 100 {
+			// constant output; nothing to toggle until the duty changes
+			l.line.SetValue(LineValue(duty >= 100, l.activeHigh))
+			duty = <-l.duty
+			continue
+		}
+		select {
+		case duty = <-l.duty:
+			continue
+		default:
+		}
+		high := l.period * time.Duration(duty) / 100
+		l.line.SetValue(LineValue(true, l.activeHigh))
+		time.Sleep(high)
+		l.line.SetValue(LineValue(false, l.activeHigh))
+		time.Sleep(l.period - high)
+	}
+}
+
+func (l *SoftPWM) SetBrightness(pct int) error {
+	l.duty <- pct
+	return nil
+}
+
+func (l *SoftPWM) Dimmable() bool {
+	return true
+}
+
+// SysfsPWM uses a hardware PWM channel exported through /sys/class/pwm
+// (e.g. GPIO18 with dtoverlay=pwm on a Raspberry Pi).
+type SysfsPWM struct {
+	dir        string
+	period     time.Duration
+	activeHigh bool
+}
+
+// NewSysfsPWM exports and enables pwmchipN/pwmM at hz, initially off.
+func NewSysfsPWM(chip, channel int, activeHigh bool, hz int) (*SysfsPWM, error) {
+	base := fmt.Sprintf("/sys/class/pwm/pwmchip%d", chip)
+	l := &SysfsPWM{
+		dir:        fmt.Sprintf("%s/pwm%d", base, channel),
+		period:     time.Second / time.Duration(hz),
+		activeHigh: activeHigh,
+	}
+	if _, err := os.Stat(l.dir); os.IsNotExist(err) {
+		if err := writeSysfs(base+"/export", channel); err != nil {
+			return nil, err
+		}
+	}
+	// udev may take a moment to fix up permissions on a freshly exported channel
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = writeSysfs(l.dir+"/duty_cycle", 0); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSysfs(l.dir+"/period", l.period.Nanoseconds()); err != nil {
+		return nil, err
+	}
+	if err := l.SetBrightness(0); err != nil {
+		return nil, err
+	}
+	return l, writeSysfs(l.dir+"/enable", 1)
+}
+
+func (l *SysfsPWM) SetBrightness(pct int) error {
+	if !l.activeHigh {
+		pct = 100 - pct
+	}
+	return writeSysfs(l.dir+"/duty_cycle", l.period.Nanoseconds()*int64(pct)/100)
+}
+
+func (l *SysfsPWM) Dimmable() bool {
+	return true
+}
+
+func writeSysfs(path string, v interface{}) error {
+	return ioutil.WriteFile(path, []byte(fmt.Sprint(v)), 0644)
+}
+
+// LineValue maps a desired output state to a line value, honouring the polarity.
+func LineValue(on bool, activeHigh bool) int {
+	if on == activeHigh {
+		return 1
+	}
+	return 0
+}
+
+// Open creates the light output for mode ("relay", "softpwm" or "hwpwm"). It returns nil
+// when no light is configured (gpio < 0 outside hwpwm mode).
+func Open(chip *relay.Chip, mode string, gpio int, activeHigh bool, pwmChip, pwmChannel, pwmHz int) (Output, error) {
+	if mode == "hwpwm" {
+		l, err := NewSysfsPWM(pwmChip, pwmChannel, activeHigh, pwmHz)
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+	if gpio < 0 {
+		return nil, nil
+	}
+	line, err := chip.Output(gpio, LineValue(false, activeHigh))
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case "relay":
+		return NewRelay(line, activeHigh), nil
+	case "softpwm":
+		return NewSoftPWM(line, activeHigh, pwmHz), nil
+	}
+	return nil, fmt.Errorf("unknown light mode %q", mode)
+}
@@ -0,0 +1,46 @@
+// Package lockout holds rules that refuse ignition regardless of who asks for it.
+package lockout
+
+import (
+	"fmt"
+
+	"github.com/barrylb/go-fire/internal/sensor"
+)
+
+// Outdoor returns an ignition check that refuses ignition while the sensor with role
+// "outdoor" reads above max, so automations can't light the fire on a warm afternoon.
+// With no outdoor reading available the lockout stays open rather than stranding the user.
+func Outdoor(sensors *sensor.Registry, max float64) func() error {
+	return func() error {
+		r, ok := sensors.ForRole("outdoor")
+		if !ok {
+			return nil
+		}
+		if r.Value > max {
+			return fmt.Errorf("outdoor temperature %.1f%s above %.1f", r.Value, r.Unit, max)
+		}
+		return nil
+	}
+}
+
+// All combines ignition checks, refusing ignition if any of them does. Nil checks are
+// skipped, and with none left it returns nil.
+func All(checks ...func() error) func() error {
+	var list []func() error
+	for _, c := range checks {
+		if c != nil {
+			list = append(list, c)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return func() error {
+		for _, c := range list {
+			if err := c(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,265 @@
+// Package logging sends log events with a syslog severity and structured fields to stderr,
+// as text or JSON lines, and any configured sinks (journald, remote syslog).
+package logging
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+)
+
+// Severity is a syslog severity level, shared by every log sink.
+type Severity int
+
+const (
+	Err     Severity = 3
+	Warning Severity = 4
+	Notice  Severity = 5
+	Info    Severity = 6
+	Debug   Severity = 7
+)
+
+// Sink receives every log event with its severity and structured key/value fields.
+type Sink interface {
+	Write(sev Severity, msg string, fields []string)
+}
+
+// severities names each severity for logging.level and JSON output.
+var severities = map[string]Severity{
+	"err": Err, "warning": Warning, "notice": Notice, "info": Info, "debug": Debug,
+}
+
+func (s Severity) String() string {
+	for name, sev := range severities {
+		if sev == s {
+			return name
+		}
+	}
+	return fmt.Sprint(int(s))
+}
+
+var sinks []Sink
+var toStderr = true
+var jsonStderr bool
+var level = Debug // the least severe logged; everything until Setup
+
+// Logf logs a formatted message at the given severity.
+func Logf(sev Severity, format string, args ...interface{}) {
+	Event(sev, fmt.Sprintf(format, args...))
+}
+
+// Event logs a message with structured fields given as alternating keys and values,
+// e.g. Event(Info, "command", "op", "on", "result", "ok").
+func Event(sev Severity, msg string, kv ...string) {
+	if sev > level {
+		return
+	}
+	if toStderr && jsonStderr {
+		writeJSON(sev, msg, kv)
+	} else if toStderr {
+		var b strings.Builder
+		b.WriteString(msg)
+		for i := 0; i+1 < len(kv); i += 2 {
+			fmt.Fprintf(&b, " %s=%s", kv[i], kv[i+1])
+		}
+		log.Print(b.String())
+	}
+	for _, s := range sinks {
+		s.Write(sev, msg, kv)
+	}
+}
+
+// writeJSON writes an event to stderr as one JSON object, its fields alongside time, level
+// and msg, for log shippers such as Promtail. A field with one of those names, such as a
+// flame level, gets a trailing underscore.
+func writeJSON(sev Severity, msg string, kv []string) {
+	m := make(map[string]string, len(kv)/2+3)
+	for i := 0; i+1 < len(kv); i += 2 {
+		k := kv[i]
+		if k == "time" || k == "level" || k == "msg" {
+			k += "_"
+		}
+		m[k] = kv[i+1]
+	}
+	m["time"] = time.Now().Format(time.RFC3339Nano)
+	m["level"] = sev.String()
+	m["msg"] = msg
+	line, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	os.Stderr.Write(append(line, '\n'))
+}
+
+// journaldSink writes entries with the journal's native protocol, so priorities and fields
+// survive as first-class journal fields rather than being parsed out of stderr text.
+type journaldSink struct {
+	conn *net.UnixConn
+}
+
+func newJournaldSink() (*journaldSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: "/run/systemd/journal/socket", Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldSink{conn: conn}, nil
+}
+
+func (s *journaldSink) Write(sev Severity, msg string, kv []string) {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", msg)
+	journalField(&b, "PRIORITY", fmt.Sprint(int(sev)))
+	journalField(&b, "SYSLOG_IDENTIFIER", "gofire")
+	for i := 0; i+1 < len(kv); i += 2 {
+		journalField(&b, "GOFIRE_"+strings.ToUpper(kv[i]), kv[i+1])
+	}
+	s.conn.Write(b.Bytes())
+}
+
+// journalField appends one field, using the length-prefixed form for values containing newlines.
+func journalField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", key, value)
+		return
+	}
+	b.WriteString(key)
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// syslogSink ships RFC 5424 messages to a remote syslog server over TCP or TLS using
+// octet-counted framing (RFC 6587). Messages are queued and sent from a goroutine so a
+// slow or unreachable log server never delays a relay operation; when the queue is full
+// messages are dropped.
+type syslogSink struct {
+	cfg      config.Syslog
+	tls      *tls.Config
+	hostname string
+	queue    chan []byte
+}
+
+// syslogFacilities maps facility names to their RFC 5424 codes.
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3, "local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+func newSyslogSink(cfg config.Syslog) (*syslogSink, error) {
+	if _, ok := syslogFacilities[cfg.Facility]; !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", cfg.Facility)
+	}
+	s := &syslogSink{cfg: cfg, queue: make(chan []byte, 256)}
+	s.hostname, _ = os.Hostname()
+	if cfg.TLS {
+		s.tls = &tls.Config{}
+		if cfg.CAFile != "" {
+			pem, err := ioutil.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			s.tls.RootCAs = x509.NewCertPool()
+			if !s.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no certificates found", cfg.CAFile)
+			}
+		}
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *syslogSink) Write(sev Severity, msg string, kv []string) {
+	pri := syslogFacilities[s.cfg.Facility]*8 + int(sev)
+	sd := "-"
+	if len(kv) >= 2 {
+		var b strings.Builder
+		b.WriteString("[gofire@32473")
+		for i := 0; i+1 < len(kv); i += 2 {
+			// PARAM-VALUE escaping: '"', '\' and ']'
+			v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(kv[i+1])
+			fmt.Fprintf(&b, ` %s="%s"`, kv[i], v)
+		}
+		b.WriteString("]")
+		sd = b.String()
+	}
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - %s %s", pri, time.Now().Format(time.RFC3339Nano), s.hostname, s.cfg.AppName, os.Getpid(), sd, msg)
+	select {
+	case s.queue <- []byte(fmt.Sprintf("%d %s", len(line), line)):
+	default:
+	}
+}
+
+func (s *syslogSink) run() {
+	var conn net.Conn
+	for frame := range s.queue {
+		for attempt := 0; attempt < 2; attempt++ {
+			if conn == nil {
+				var err error
+				if conn, err = s.dial(); err != nil {
+					log.Printf("syslog: %v", err)
+					time.Sleep(5 * time.Second)
+					break
+				}
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err := conn.Write(frame); err == nil {
+				break
+			}
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	if s.tls != nil {
+		return tls.DialWithDialer(d, "tcp", s.cfg.Address, s.tls)
+	}
+	return d.Dial("tcp", s.cfg.Address)
+}
+
+// Setup adds the configured log sinks. Logging natively to journald replaces stderr,
+// which systemd would otherwise also capture into the journal.
+func Setup(cfg config.Logging) error {
+	sev, ok := severities[cfg.Level]
+	if !ok {
+		return fmt.Errorf("logging.level must be err, warning, notice, info or debug, not %q", cfg.Level)
+	}
+	switch cfg.Format {
+	case "text":
+	case "json":
+		jsonStderr = true
+	default:
+		return fmt.Errorf("logging.format must be text or json, not %q", cfg.Format)
+	}
+	level = sev
+	if cfg.Journald {
+		s, err := newJournaldSink()
+		if err != nil {
+			return fmt.Errorf("journald: %v", err)
+		}
+		sinks = append(sinks, s)
+		toStderr = false
+	}
+	if cfg.Syslog.Address != "" {
+		s, err := newSyslogSink(cfg.Syslog)
+		if err != nil {
+			return fmt.Errorf("syslog: %v", err)
+		}
+		sinks = append(sinks, s)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is a service instance found by Browse.
+type Entry struct {
+	Instance string            `json:"instance"` // e.g. Fireplace
+	Host     string            `json:"host"`     // e.g. GoFire-A1B2C3.local.
+	Port     int               `json:"port"`
+	Addrs    []net.IP          `json:"addrs"`
+	TXT      map[string]string `json:"txt,omitempty"`
+}
+
+// Browse asks the LAN for instances of the service type typ, e.g. _gofire._tcp, and returns
+// those that answer within wait, sorted by instance. The query is a one-shot legacy unicast
+// one (RFC 6762 section 6.7), answered straight back to it, so it needs neither port 5353
+// nor a responder running alongside.
+func Browse(typ string, wait time.Duration) ([]Entry, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	full := strings.ToLower(typ + ".local.")
+	query := make([]byte, 12)
+	binary.BigEndian.PutUint16(query[4:], 1) // one question
+	query = append(query, encodeName(full)...)
+	query = append(query, 0, dnsPTR, 0, classIN)
+	if _, err := conn.WriteToUDP(query, mdnsGroup); err != nil {
+		return nil, err
+	}
+	found := map[string]*Entry{} // by instance name in full
+	hosts := map[string][]net.IP{}
+	conn.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // the deadline
+		}
+		rrs, err := parseResponse(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, rr := range rrs {
+			name := strings.ToLower(rr.name)
+			switch {
+			case rr.rtype == dnsPTR && name == full:
+				if _, ok := found[strings.ToLower(rr.target)]; !ok {
+					found[strings.ToLower(rr.target)] = &Entry{Instance: strings.TrimSuffix(rr.target, "."+typ+".local.")}
+				}
+			case rr.rtype == dnsA:
+				hosts[name] = appendIP(hosts[name], rr.ip)
+			}
+		}
+		for _, rr := range rrs {
+			e := found[strings.ToLower(rr.name)]
+			if e == nil {
+				continue
+			}
+			switch rr.rtype {
+			case dnsSRV:
+				e.Host, e.Port = rr.target, rr.port
+			case dnsTXT:
+				e.TXT = rr.txt
+			}
+		}
+	}
+	var out []Entry
+	for _, e := range found {
+		if e.Host == "" {
+			continue // no SRV record, so nowhere to connect to
+		}
+		e.Addrs = hosts[strings.ToLower(e.Host)]
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Instance < out[j].Instance })
+	return out, nil
+}
+
+func appendIP(ips []net.IP, ip net.IP) []net.IP {
+	for _, have := range ips {
+		if have.Equal(ip) {
+			return ips
+		}
+	}
+	return append(ips, ip)
+}
+
+// answer is a resource record of a response, with the data of the types Browse reads.
+type answer struct {
+	name   string
+	rtype  uint16
+	target string            // PTR and SRV
+	port   int               // SRV
+	txt    map[string]string // TXT
+	ip     net.IP            // A
+}
+
+// parseResponse returns the records of a response's answer, authority and additional
+// sections.
+func parseResponse(msg []byte) ([]answer, error) {
+	if len(msg) < 12 || msg[2]&0x80 == 0 {
+		return nil, errBadDNS
+	}
+	off := 12
+	for i := int(binary.BigEndian.Uint16(msg[4:])); i > 0; i-- {
+		_, next, err := decodeName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+	count := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	var out []answer
+	for ; count > 0; count-- {
+		name, next, err := decodeName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return nil, errBadDNS
+		}
+		rr := answer{name: name, rtype: binary.BigEndian.Uint16(msg[next:])}
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+length > len(msg) {
+			return nil, errBadDNS
+		}
+		switch rr.rtype {
+		case dnsPTR:
+			if rr.target, _, err = decodeName(msg, data); err != nil {
+				return nil, err
+			}
+		case dnsSRV:
+			if length < 7 {
+				return nil, errBadDNS
+			}
+			rr.port = int(binary.BigEndian.Uint16(msg[data+4:]))
+			if rr.target, _, err = decodeName(msg, data+6); err != nil {
+				return nil, err
+			}
+		case dnsTXT:
+			rr.txt = map[string]string{}
+			for p, end := data, data+length; p < end; {
+				n := int(msg[p])
+				if p+1+n > end {
+					return nil, errBadDNS
+				}
+				if kv := string(msg[p+1 : p+1+n]); kv != "" {
+					if i := strings.IndexByte(kv, '='); i >= 0 {
+						rr.txt[kv[:i]] = kv[i+1:]
+					} else {
+						rr.txt[kv] = ""
+					}
+				}
+				p += 1 + n
+			}
+		case dnsA:
+			if length == 4 {
+				rr.ip = net.IP(append([]byte(nil), msg[data:data+4]...))
+			}
+		}
+		out = append(out, rr)
+		off = data + length
+	}
+	return out, nil
+}
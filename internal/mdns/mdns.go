@@ -0,0 +1,303 @@
+// Package mdns is a minimal mDNS (RFC 6762) responder and browser for DNS-SD services, so
+// that GoFire doesn't depend on Avahi; it shares port 5353 with one if it is running.
+//
+// A Responder answers queries for its services' types and instances and its own host name,
+// and announces its records at start and whenever Announce is called, e.g. after a TXT record
+// changes. Name conflicts are not probed for: host names are made unique by their callers.
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	dnsA   = 1
+	dnsPTR = 12
+	dnsTXT = 16
+	dnsSRV = 33
+	dnsANY = 255
+
+	classIN    = 1
+	cacheFlush = 0x8000 // set on records this host alone owns
+
+	servicesPTR = "_services._dns-sd._udp.local."
+)
+
+// Service is a DNS-SD service instance to advertise.
+type Service struct {
+	Instance string // e.g. Fireplace; dots are replaced with spaces
+	Type     string // e.g. _hap._tcp
+	Port     int
+	// TXT returns the TXT record's strings, e.g. "id=...", each at most 255 bytes; nil
+	// for an empty record.
+	TXT func() []string
+}
+
+// service is a Service with its names in full.
+type service struct {
+	Service
+	typ      string // _hap._tcp.local.
+	instance string // Fireplace._hap._tcp.local.
+}
+
+// Responder answers for the services of one host.
+type Responder struct {
+	conn     *net.UDPConn
+	name     string // the host, e.g. GoFire-A1B2C3.local.
+	services []service
+}
+
+// Start answers for services on host, e.g. GoFire-A1B2C3 (without .local), and announces
+// them; name is what errors and log lines are prefixed with, e.g. homekit.
+func Start(name, host string, services ...Service) (*Responder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	r := &Responder{conn: conn, name: host + ".local."}
+	for _, s := range services {
+		typ := s.Type + ".local."
+		r.services = append(r.services, service{s, typ, strings.Replace(s.Instance, ".", " ", -1) + "." + typ})
+	}
+	fault.Go(name, func() { r.serve(name) })
+	go r.Announce()
+	return r, nil
+}
+
+// Announce sends every record unsolicited, twice a second apart as RFC 6762 asks.
+func (r *Responder) Announce() {
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		msg := response(0, r.all(), nil)
+		if _, err := r.conn.WriteToUDP(msg, mdnsGroup); err != nil {
+			logging.Logf(logging.Warning, "mdns: %v", err)
+		}
+	}
+}
+
+func (r *Responder) serve(name string) {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			logging.Logf(logging.Err, "%s: mdns: %v", name, err)
+			return
+		}
+		id, questions, err := parseQuery(buf[:n])
+		if err != nil {
+			continue
+		}
+		var answers, extra []record
+		for _, q := range questions {
+			a, x := r.answer(q)
+			answers, extra = append(answers, a...), append(extra, x...)
+		}
+		if len(answers) == 0 {
+			continue
+		}
+		to := mdnsGroup
+		if from.Port != mdnsGroup.Port {
+			to = from // a legacy unicast query, e.g. from dig
+		} else {
+			id = 0
+		}
+		r.conn.WriteToUDP(response(id, answers, extra), to)
+	}
+}
+
+type question struct {
+	name  string
+	qtype uint16
+}
+
+// record is a resource record ready to be written, apart from its name.
+type record struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	data  []byte
+}
+
+// answer returns the answers to q and the additional records that go with them.
+func (r *Responder) answer(q question) (answers, extra []record) {
+	is := func(t uint16) bool { return q.qtype == t || q.qtype == dnsANY }
+	name := strings.ToLower(q.name)
+	if name == servicesPTR && is(dnsPTR) {
+		seen := map[string]bool{}
+		for _, s := range r.services {
+			if !seen[s.typ] {
+				seen[s.typ] = true
+				answers = append(answers, record{servicesPTR, dnsPTR, classIN, 4500, encodeName(s.typ)})
+			}
+		}
+		return answers, nil
+	}
+	if name == strings.ToLower(r.name) {
+		if is(dnsA) {
+			answers = r.addresses()
+		}
+		return answers, nil
+	}
+	for _, s := range r.services {
+		switch name {
+		case strings.ToLower(s.typ):
+			if is(dnsPTR) {
+				answers = append(answers, s.ptr())
+				extra = append(extra, r.srv(s), s.txtRecord())
+			}
+		case strings.ToLower(s.instance):
+			if is(dnsSRV) {
+				answers = append(answers, r.srv(s))
+			}
+			if is(dnsTXT) {
+				answers = append(answers, s.txtRecord())
+			}
+		}
+	}
+	if len(answers) > 0 {
+		extra = append(extra, r.addresses()...)
+	}
+	return answers, extra
+}
+
+func (r *Responder) all() []record {
+	var out []record
+	for _, s := range r.services {
+		out = append(out, s.ptr(), r.srv(s), s.txtRecord())
+	}
+	return append(out, r.addresses()...)
+}
+
+func (s service) ptr() record {
+	return record{s.typ, dnsPTR, classIN, 4500, encodeName(s.instance)}
+}
+
+func (r *Responder) srv(s service) record {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[4:], uint16(s.Port)) // priority and weight 0
+	return record{s.instance, dnsSRV, classIN | cacheFlush, 120, append(data, encodeName(r.name)...)}
+}
+
+func (s service) txtRecord() record {
+	var data []byte
+	if s.TXT != nil {
+		for _, t := range s.TXT() {
+			data = append(append(data, byte(len(t))), t...)
+		}
+	}
+	if len(data) == 0 {
+		data = []byte{0} // an empty record is one empty string
+	}
+	return record{s.instance, dnsTXT, classIN | cacheFlush, 4500, data}
+}
+
+// addresses returns an A record for each of the host's IPv4 addresses.
+func (r *Responder) addresses() []record {
+	addrs, _ := net.InterfaceAddrs()
+	var out []record
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		out = append(out, record{r.name, dnsA, classIN | cacheFlush, 120, ipnet.IP.To4()})
+	}
+	return out
+}
+
+// response builds an authoritative response message.
+func response(id uint16, answers, extra []record) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400) // response, authoritative
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(msg[10:], uint16(len(extra)))
+	for _, rr := range append(answers, extra...) {
+		msg = append(msg, encodeName(rr.name)...)
+		var fixed [10]byte
+		binary.BigEndian.PutUint16(fixed[0:], rr.rtype)
+		binary.BigEndian.PutUint16(fixed[2:], rr.class)
+		binary.BigEndian.PutUint32(fixed[4:], rr.ttl)
+		binary.BigEndian.PutUint16(fixed[8:], uint16(len(rr.data)))
+		msg = append(append(msg, fixed[:]...), rr.data...)
+	}
+	return msg
+}
+
+// encodeName writes a domain name without compression.
+func encodeName(name string) []byte {
+	var out []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		out = append(append(out, byte(len(label))), label...)
+	}
+	return append(out, 0)
+}
+
+var errBadDNS = errors.New("mdns: malformed DNS message")
+
+// parseQuery returns a query's ID and questions; responses are ignored.
+func parseQuery(msg []byte) (uint16, []question, error) {
+	if len(msg) < 12 || msg[2]&0x80 != 0 {
+		return 0, nil, errBadDNS
+	}
+	id := binary.BigEndian.Uint16(msg)
+	count := int(binary.BigEndian.Uint16(msg[4:]))
+	off := 12
+	var qs []question
+	for i := 0; i < count; i++ {
+		name, next, err := decodeName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return 0, nil, errBadDNS
+		}
+		qs = append(qs, question{name, binary.BigEndian.Uint16(msg[next:])})
+		off = next + 4
+	}
+	return id, qs, nil
+}
+
+// decodeName reads a possibly compressed name at off, returning it and the offset after it.
+func decodeName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; jumps < 16; {
+		if off >= len(msg) {
+			return "", 0, errBadDNS
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errBadDNS
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errBadDNS
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+	return "", 0, errBadDNS
+}
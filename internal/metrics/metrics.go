@@ -0,0 +1,153 @@
+// Package metrics counts command outcomes and pushes them, with sensor and light gauges,
+// to a statsd/Telegraf listener, or writes them for a Prometheus scrape.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/light"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/sensor"
+)
+
+var mu sync.Mutex
+var counters = map[string]int64{} // "command.op.result" or "http.route.status" -> total since start
+
+var gauges = map[string]func() float64{} // pushed as is on every push
+
+// RegisterGauge adds a gauge, read with f on every push.
+func RegisterGauge(name string, f func() float64) {
+	mu.Lock()
+	gauges[name] = f
+	mu.Unlock()
+}
+
+// CountCommand records one outcome (ok, busy, lockout, ...) of a command.
+func CountCommand(op, result string) {
+	mu.Lock()
+	counters["command."+op+"."+result]++
+	mu.Unlock()
+}
+
+// CountRequest records the response status of one HTTP request to route.
+func CountRequest(route string, status int) {
+	mu.Lock()
+	counters[fmt.Sprintf("http.%s.%d", statsdName(route), status)]++
+	mu.Unlock()
+}
+
+// CommandCounts returns a copy of the command outcome totals keyed by "op.result".
+func CommandCounts() map[string]int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	out := map[string]int64{}
+	for k, v := range counters {
+		if strings.HasPrefix(k, "command.") {
+			out[strings.TrimPrefix(k, "command.")] = v
+		}
+	}
+	return out
+}
+
+// Counters returns a copy of every counter.
+func Counters() map[string]int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	out := make(map[string]int64, len(counters))
+	for k, v := range counters {
+		out[k] = v
+	}
+	return out
+}
+
+// statsdPusher sends command counters and current gauges to a statsd/Telegraf UDP listener,
+// for monitoring stacks that can't scrape a device on the house VLAN.
+type statsdPusher struct {
+	conn    net.Conn
+	prefix  string
+	sensors *sensor.Registry
+	light   *light.Controller
+	sent    map[string]int64 // counter values already pushed, so each push sends the delta
+}
+
+func (p *statsdPusher) push() error {
+	var lines []string
+	for k, v := range Counters() {
+		if d := v - p.sent[k]; d != 0 {
+			lines = append(lines, fmt.Sprintf("%s.%s:%d|c", p.prefix, k, d))
+			p.sent[k] = v
+		}
+	}
+	for name, r := range p.sensors.Readings() {
+		if r.Error == "" {
+			lines = append(lines, fmt.Sprintf("%s.sensor.%s:%g|g", p.prefix, statsdName(name), r.Value))
+		}
+	}
+	if p.light != nil {
+		lines = append(lines, fmt.Sprintf("%s.light.brightness:%d|g", p.prefix, p.light.Level()))
+	}
+	mu.Lock()
+	fs := make(map[string]func() float64, len(gauges)+len(counterFuncs))
+	for name, f := range gauges {
+		fs[name] = f
+	}
+	for name, f := range counterFuncs {
+		fs[name] = f // pushed as the running total
+	}
+	mu.Unlock()
+	for name, f := range fs {
+		lines = append(lines, fmt.Sprintf("%s.%s:%g|g", p.prefix, name, f()))
+	}
+	// newline separated metrics, packed into datagrams that fit a typical MTU
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if buf.Len() > 0 && buf.Len()+len(l)+1 > 1400 {
+			if _, err := p.conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		buf.WriteString(l)
+		buf.WriteByte('\n')
+	}
+	if buf.Len() > 0 {
+		_, err := p.conn.Write(buf.Bytes())
+		return err
+	}
+	return nil
+}
+
+// statsdName makes a sensor name or route safe to use as a statsd metric path segment.
+func statsdName(s string) string {
+	s = strings.Trim(s, "/")
+	if s == "" {
+		s = "root"
+	}
+	return strings.NewReplacer(".", "_", ":", "_", "|", "_", " ", "_", "/", "_").Replace(s)
+}
+
+// StartStatsd starts pushing metrics if a statsd address is configured; lc may be nil.
+func StartStatsd(cfg config.Statsd, sensors *sensor.Registry, lc *light.Controller) error {
+	if cfg.Address == "" {
+		return nil
+	}
+	conn, err := net.Dial("udp", cfg.Address)
+	if err != nil {
+		return err
+	}
+	p := &statsdPusher{conn: conn, prefix: cfg.Prefix, sensors: sensors, light: lc, sent: map[string]int64{}}
+	go func() {
+		for range time.Tick(cfg.Interval) {
+			if err := p.push(); err != nil {
+				logging.Logf(logging.Warning, "statsd: %v", err)
+			}
+		}
+	}()
+	return nil
+}
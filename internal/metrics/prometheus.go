@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/light"
+	"github.com/barrylb/go-fire/internal/sensor"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the command duration histogram: a
+// contact sequence takes from a fraction of a second to ignition's several seconds, more
+// when it waits for the relays.
+var durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30}
+
+// histogram counts observations into durationBuckets.
+type histogram struct {
+	counts []int64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	n      int64
+}
+
+var durations = map[string]*histogram{} // by op
+
+var counterFuncs = map[string]func() float64{} // totals read on demand
+
+// ObserveCommand records how long a command took to run.
+func ObserveCommand(op string, d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	h := durations[op]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(durationBuckets)+1)}
+		durations[op] = h
+	}
+	s := d.Seconds()
+	i := sort.SearchFloat64s(durationBuckets, s)
+	h.counts[i]++
+	h.sum += s
+	h.n++
+}
+
+// RegisterCounter adds a total that only goes up, such as a running time, read with f when
+// pushed or scraped.
+func RegisterCounter(name string, f func() float64) {
+	mu.Lock()
+	counterFuncs[name] = f
+	mu.Unlock()
+}
+
+// CountErrors returns l counting the errors setting it, as gpio.name.errors.
+func CountErrors(name string, l relay.Line) relay.Line {
+	return &errorLine{Line: l, key: "gpio." + name + ".errors"}
+}
+
+type errorLine struct {
+	relay.Line
+	key string
+}
+
+func (l *errorLine) SetValue(value int) error {
+	err := l.Line.SetValue(value)
+	if err != nil {
+		mu.Lock()
+		counters[l.key]++
+		mu.Unlock()
+	}
+	return err
+}
+
+// WritePrometheus writes every metric in the Prometheus text format: the counters,
+// command duration histograms, registered gauges and totals, sensor readings and, if lc
+// isn't nil, the light's brightness.
+func WritePrometheus(w io.Writer, sensors *sensor.Registry, lc *light.Controller) {
+	mu.Lock()
+	var commands, requests, gpio []string
+	for k, v := range counters {
+		parts := strings.Split(k, ".")
+		switch {
+		case parts[0] == "command" && len(parts) == 3:
+			commands = append(commands, fmt.Sprintf("gofire_commands_total{op=%q,result=%q} %d", parts[1], parts[2], v))
+		case parts[0] == "http" && len(parts) == 3:
+			requests = append(requests, fmt.Sprintf("gofire_http_requests_total{route=%q,status=%q} %d", parts[1], parts[2], v))
+		case parts[0] == "gpio" && len(parts) == 3:
+			gpio = append(gpio, fmt.Sprintf("gofire_gpio_errors_total{line=%q} %d", parts[1], v))
+		}
+	}
+	sort.Strings(commands)
+	sort.Strings(requests)
+	sort.Strings(gpio)
+	ops := make([]string, 0, len(durations))
+	for op := range durations {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	var hist []string
+	for _, op := range ops {
+		h := durations[op]
+		var cum int64
+		for i, b := range durationBuckets {
+			cum += h.counts[i]
+			hist = append(hist, fmt.Sprintf("gofire_command_duration_seconds_bucket{op=%q,le=%q} %d", op, formatFloat(b), cum))
+		}
+		hist = append(hist,
+			fmt.Sprintf("gofire_command_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d", op, h.n),
+			fmt.Sprintf("gofire_command_duration_seconds_sum{op=%q} %s", op, formatFloat(h.sum)),
+			fmt.Sprintf("gofire_command_duration_seconds_count{op=%q} %d", op, h.n))
+	}
+	gs := make(map[string]func() float64, len(gauges))
+	for name, f := range gauges {
+		gs[name] = f
+	}
+	cs := make(map[string]func() float64, len(counterFuncs))
+	for name, f := range counterFuncs {
+		cs[name] = f
+	}
+	mu.Unlock()
+
+	family(w, "gofire_commands_total", "counter", "Commands run, by op and result.", commands)
+	family(w, "gofire_http_requests_total", "counter", "HTTP requests, by route and status.", requests)
+	family(w, "gofire_gpio_errors_total", "counter", "Errors setting a relay GPIO line.", gpio)
+	family(w, "gofire_command_duration_seconds", "histogram", "Time taken to run a command.", hist)
+	for _, name := range sortedKeys(cs) {
+		m := promName(name) + "_total"
+		family(w, m, "counter", "", []string{m + " " + formatFloat(cs[name]())})
+	}
+	for _, name := range sortedKeys(gs) {
+		m := promName(name)
+		family(w, m, "gauge", "", []string{m + " " + formatFloat(gs[name]())})
+	}
+	var readings []string
+	for name, r := range sensors.Readings() {
+		if r.Error == "" {
+			readings = append(readings, fmt.Sprintf("gofire_sensor_value{sensor=%q} %s", name, formatFloat(r.Value)))
+		}
+	}
+	sort.Strings(readings)
+	family(w, "gofire_sensor_value", "gauge", "Latest sensor reading.", readings)
+	if lc != nil {
+		family(w, "gofire_light_brightness", "gauge", "Light brightness, 0 to 100.",
+			[]string{"gofire_light_brightness " + strconv.Itoa(lc.Level())})
+	}
+}
+
+// family writes one metric family's samples under its HELP and TYPE lines.
+func family(w io.Writer, name, typ, help string, samples []string) {
+	if len(samples) == 0 {
+		return
+	}
+	if help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	for _, s := range samples {
+		fmt.Fprintln(w, s)
+	}
+}
+
+// promName turns a registered name such as relay.contact1.actuations into a Prometheus
+// metric name, gofire_relay_contact1_actuations.
+func promName(name string) string {
+	return "gofire_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]func() float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,257 @@
+// Package modulate cycles the burner on a duty cycle, for less heat on average than the
+// lowest flame gives: lit at its lowest flame for part of each period and put out (or down
+// to its pilot) for the rest. Given a level, it cycles the flame between that level and the
+// next instead, for a heat between the two. Each phase lasts at least the configured minimum,
+// the period being stretched to keep the duty cycle, so that the valve and the igniter aren't
+// worked harder than the fire needs.
+//
+// A modulation runs in the background until it is stopped, when one of its commands fails,
+// or when another source changes the fire.
+package modulate
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// source is what the modulation's commands are recorded as.
+const source = "modulate"
+
+// settle bounds how long relighting waits for the power tracker to see the fire lit before
+// setting the flame.
+const settle = 2 * time.Second
+
+// busyWait bounds how long a phase waits for the relays.
+const busyWait = 30 * time.Second
+
+// ErrUnlit is returned by Start when the fire isn't lit.
+var ErrUnlit = errors.New("modulate: the fire isn't lit")
+
+// Modulator runs one modulation at a time.
+type Modulator struct {
+	cfg    config.Modulation
+	power  *power.Tracker
+	runner *actions.Runner
+	flame  *flame.Control // nil unless the driver sets the flame with one pulse
+
+	mu  sync.Mutex
+	job *job // nil unless modulating
+}
+
+// job is a modulation in progress.
+type job struct {
+	state     State
+	high, low time.Duration
+	stop      chan struct{}
+}
+
+// State is the modulation in progress.
+type State struct {
+	Duty   int    `json:"duty"`   // percent of each period in the high phase
+	Period string `json:"period"` // as asked for
+	// Cycle is the period stretched so that each phase lasts at least its minimum.
+	Cycle string `json:"cycle"`
+	// Level, when set, is the low phase's flame level, the high phase's being the next; the
+	// burner is cycled otherwise.
+	Level     int       `json:"level,omitempty"`
+	Phase     string    `json:"phase"`      // high or low
+	PhaseEnds time.Time `json:"phase_ends"` // zero while the fire is being put in the phase
+	Source    string    `json:"source"`
+	Started   time.Time `json:"started"`
+}
+
+// New returns a Modulator for the fireplace pw tracks, which runner drives, setting the flame
+// with fc when not nil.
+func New(cfg config.Modulation, pw *power.Tracker, runner *actions.Runner, fc *flame.Control) *Modulator {
+	m := &Modulator{cfg: cfg, power: pw, runner: runner, flame: fc}
+	ch, _ := events.Subscribe()
+	fault.Go("modulate", func() { m.watch(ch) })
+	return m
+}
+
+// Start modulates the fire, which must be lit, in the high phase for duty percent (1 to 99) of
+// each period on behalf of from, in place of any modulation already running. With level 0
+// the burner is cycled, between its lowest flame and the configured low; from 1 the flame is
+// cycled between level and level+1.
+func (m *Modulator) Start(duty int, period time.Duration, level int, from string) error {
+	if m.power.State().Power != "on" {
+		return ErrUnlit
+	}
+	high, low := m.phases(duty, period)
+	j := &job{state: State{Duty: duty, Period: period.String(), Cycle: (high + low).String(), Level: level,
+		Source: from, Started: time.Now()}, high: high, low: low, stop: make(chan struct{})}
+	m.mu.Lock()
+	if m.job != nil {
+		m.stop("replaced")
+	}
+	m.job = j
+	m.mu.Unlock()
+	logging.Event(logging.Info, "modulation started", "duty", strconv.Itoa(duty), "period", period.String(),
+		"cycle", j.state.Cycle, "level", strconv.Itoa(level), "source", from)
+	fault.Go("modulate", func() { m.run(j) })
+	return nil
+}
+
+// phases returns how long the high and low phases of duty over period last, stretched
+// together so that neither is shorter than its minimum.
+func (m *Modulator) phases(duty int, period time.Duration) (time.Duration, time.Duration) {
+	high := float64(period) * float64(duty) / 100
+	low := float64(period) - high
+	stretch := 1.0
+	if s := float64(m.cfg.MinOn) / high; s > stretch {
+		stretch = s
+	}
+	if s := float64(m.cfg.MinOff) / low; s > stretch {
+		stretch = s
+	}
+	return time.Duration(high * stretch).Round(time.Second), time.Duration(low * stretch).Round(time.Second)
+}
+
+// Stop ends the modulation in progress, if any, for reason, and reports whether there was
+// one. The fire is left as the last phase left it.
+func (m *Modulator) Stop(reason string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.job == nil {
+		return false
+	}
+	m.stop(reason)
+	return true
+}
+
+// stop ends the modulation in progress; callers must hold mu.
+func (m *Modulator) stop(reason string) {
+	close(m.job.stop)
+	logging.Event(logging.Info, "modulation stopped", "phase", m.job.state.Phase, "reason", reason)
+	m.job = nil
+}
+
+// Active reports whether a modulation is in progress.
+func (m *Modulator) Active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.job != nil
+}
+
+// State returns the modulation in progress, or nil.
+func (m *Modulator) State() *State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.job == nil {
+		return nil
+	}
+	st := m.job.state
+	return &st
+}
+
+// run alternates the phases, starting with the high one, until the job is stopped.
+func (m *Modulator) run(j *job) {
+	for {
+		for _, phase := range []string{"high", "low"} {
+			d := j.high
+			if phase == "low" {
+				d = j.low
+			}
+			m.mu.Lock()
+			j.state.Phase, j.state.PhaseEnds = phase, time.Time{} // until the fire is in it
+			m.mu.Unlock()
+			result := m.enter(j, phase)
+			m.mu.Lock()
+			if m.job != j {
+				m.mu.Unlock()
+				return
+			}
+			if result != "ok" {
+				m.stop(phase + " " + result)
+				m.mu.Unlock()
+				return
+			}
+			j.state.Phase, j.state.PhaseEnds = phase, time.Now().Add(d)
+			m.mu.Unlock()
+			t := time.NewTimer(d)
+			select {
+			case <-j.stop:
+				t.Stop()
+				return
+			case <-t.C:
+			}
+		}
+	}
+}
+
+// enter puts the fire in phase, returning the result of the first command that wasn't ok.
+// A command finding the relays busy, as with one of a modulation this one replaced still
+// running, is tried again for up to busyWait.
+func (m *Modulator) enter(j *job, phase string) string {
+	result := m.phase(j.state.Level, phase)
+	for deadline := time.Now().Add(busyWait); result == "busy" && time.Now().Before(deadline); {
+		select {
+		case <-j.stop:
+			return result
+		case <-time.After(500 * time.Millisecond):
+		}
+		result = m.phase(j.state.Level, phase)
+	}
+	return result
+}
+
+// phase sends the commands that put the fire in phase.
+func (m *Modulator) phase(level int, phase string) string {
+	if level > 0 {
+		if phase == "high" {
+			level++
+		}
+		return m.setFlame(level)
+	}
+	if phase == "low" {
+		return m.runner.Run(m.cfg.Low, source)
+	}
+	if m.power.State().Power != "on" {
+		if result := m.runner.Run("on", source); result != "ok" {
+			return result
+		}
+		// the tracker follows the command it is subscribed to in the background
+		for deadline := time.Now().Add(settle); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if m.power.State().Power == "on" {
+				break
+			}
+		}
+	}
+	return m.setFlame(1)
+}
+
+func (m *Modulator) setFlame(level int) string {
+	if m.flame != nil {
+		return m.flame.Set(level, source)
+	}
+	return m.runner.SetFlame(m.power, level, source)
+}
+
+// watch stops the modulation when another source changes the fire after it started; the
+// command that left the flame at its lowest, after which the thermostat modulates, may still
+// be on its way.
+func (m *Modulator) watch(ch <-chan events.Command) {
+	for c := range ch {
+		if c.Source == source || c.Result != "ok" {
+			continue
+		}
+		switch c.Op {
+		case "on", "off", "pilot", "flameup", "flamedown", "setflame", "calibrate":
+			m.mu.Lock()
+			if m.job != nil && c.Time.After(m.job.state.Started) {
+				m.stop(c.Op + " from " + c.Source)
+			}
+			m.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package mqtt connects to the MQTT broker shared by the MQTT-based integrations.
+package mqtt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/logging"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Client is a connection to the broker that resubscribes after reconnecting.
+type Client struct {
+	mu        sync.Mutex
+	c         paho.Client
+	subs      []subscription
+	connected []func()
+}
+
+type subscription struct {
+	topic   string
+	handler func(topic string, payload []byte)
+}
+
+// Connect starts connecting to the configured broker, or returns nil if none is
+// configured. Connecting is retried in the background, so a broker that is down at boot
+// doesn't stop the fireplace from starting.
+func Connect(cfg config.MQTT) *Client {
+	if cfg.Broker == "" {
+		return nil
+	}
+	c := &Client{}
+	c.c = paho.NewClient(c.options(cfg))
+	c.c.Connect()
+	return c
+}
+
+// Reconnect closes the connection and connects with cfg's broker settings instead, as when
+// the configuration is reloaded, keeping the subscriptions and OnConnect functions.
+func (c *Client) Reconnect(cfg config.MQTT) {
+	c.mu.Lock()
+	old := c.c
+	c.c = paho.NewClient(c.options(cfg))
+	c.mu.Unlock()
+	old.Disconnect(250)
+	logging.Logf(logging.Info, "mqtt: reconnecting to %s", cfg.Broker)
+	c.client().Connect()
+}
+
+// client returns the connection.
+func (c *Client) client() paho.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.c
+}
+
+// options are the connection options for cfg.
+func (c *Client) options(cfg config.MQTT) *paho.ClientOptions {
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logging.Logf(logging.Warning, "mqtt: connection lost: %v", err)
+		})
+	if d := cfg.Device; d != nil {
+		// the broker marks the device unavailable if GoFire drops off
+		opts.SetWill(AvailabilityTopic(*d), "offline", 1, true)
+	}
+	return opts
+}
+
+// Subscribe calls handler for every message on topic, renewing the subscription after
+// each reconnect.
+func (c *Client) Subscribe(topic string, handler func(topic string, payload []byte)) {
+	c.mu.Lock()
+	c.subs = append(c.subs, subscription{topic, handler})
+	c.mu.Unlock()
+	if c.client().IsConnectionOpen() {
+		c.subscribe(subscription{topic, handler})
+	}
+}
+
+func (c *Client) subscribe(s subscription) {
+	c.client().Subscribe(s.topic, 1, func(_ paho.Client, m paho.Message) {
+		s.handler(m.Topic(), m.Payload())
+	})
+}
+
+// OnConnect calls f after every connect, e.g. to announce retained state again.
+func (c *Client) OnConnect(f func()) {
+	c.mu.Lock()
+	c.connected = append(c.connected, f)
+	c.mu.Unlock()
+	if c.client().IsConnectionOpen() {
+		f()
+	}
+}
+
+func (c *Client) onConnect(paho.Client) {
+	logging.Logf(logging.Info, "mqtt: connected")
+	c.mu.Lock()
+	subs, fs := c.subs, c.connected
+	c.mu.Unlock()
+	for _, s := range subs {
+		c.subscribe(s)
+	}
+	for _, f := range fs {
+		f()
+	}
+}
+
+// AvailabilityTopic is where the device publishes online, and the broker offline for it.
+func AvailabilityTopic(d config.MQTTDevice) string {
+	return d.TopicPrefix + "/availability"
+}
+
+// Publish sends payload to topic without waiting for the broker to acknowledge it.
+func (c *Client) Publish(topic string, retained bool, payload []byte) {
+	c.client().Publish(topic, 1, retained, payload)
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+)
+
+// ntfy publishes to an ntfy topic.
+type ntfy struct {
+	cfg    config.Ntfy
+	client *http.Client
+}
+
+func (b *ntfy) name() string { return "ntfy" }
+
+func (b *ntfy) send(title, message string) error {
+	req, err := http.NewRequest(http.MethodPost, b.cfg.URL, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Priority", "high")
+	req.Header.Set("Tags", "fire")
+	if b.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.cfg.Token)
+	}
+	return do(b.client, req)
+}
+
+// pushover sends through the Pushover API.
+type pushover struct {
+	cfg    config.Pushover
+	client *http.Client
+}
+
+func (b *pushover) name() string { return "pushover" }
+
+func (b *pushover) send(title, message string) error {
+	form := url.Values{"token": {b.cfg.Token}, "user": {b.cfg.User}, "title": {title}, "message": {message},
+		"priority": {"1"}}
+	req, err := http.NewRequest(http.MethodPost, "https://api.pushover.net/1/messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return do(b.client, req)
+}
+
+// telegram sends through a Telegram bot.
+type telegram struct {
+	cfg    config.Telegram
+	client *http.Client
+}
+
+func (b *telegram) name() string { return "telegram" }
+
+func (b *telegram) send(title, message string) error {
+	body, _ := json.Marshal(map[string]string{"chat_id": b.cfg.ChatID, "text": title + ": " + message})
+	req, err := http.NewRequest(http.MethodPost, "https://api.telegram.org/bot"+b.cfg.Token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return errors.New("bad bot token") // the error would carry the URL, and so the token
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(b.client, req)
+}
+
+// do makes req, failing on a status other than 2xx. Errors leave out the URL, which may
+// carry a token.
+func do(client *http.Client, req *http.Request) error {
+	req.Header.Set("User-Agent", "gofire")
+	resp, err := client.Do(req)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return errors.New(strings.TrimSpace(resp.Status))
+	}
+	return nil
+}
+
+// email sends through an SMTP server.
+type email struct {
+	cfg config.Email
+}
+
+func (b *email) name() string { return "email" }
+
+func (b *email) send(title, message string) error {
+	host, port, _ := net.SplitHostPort(b.cfg.SMTP)
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", b.cfg.From,
+		strings.Join(b.cfg.To, ", "), title+": "+message, time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", message)
+	var auth smtp.Auth
+	if b.cfg.Username != "" {
+		auth = smtp.PlainAuth("", b.cfg.Username, b.cfg.Password, host)
+	}
+	if port != "465" {
+		// STARTTLS whenever the server offers it
+		return smtp.SendMail(b.cfg.SMTP, auth, b.cfg.From, b.cfg.To, msg.Bytes())
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", b.cfg.SMTP, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(b.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range b.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
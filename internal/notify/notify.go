@@ -0,0 +1,134 @@
+// Package notify sends the main fireplace's safety events to phones and inboxes through
+// ntfy, Pushover, a Telegram bot or email:
+//
+//   - auto_off: the auto-off timer turned the fire off
+//   - estop: an emergency stop was engaged, for any fireplace
+//   - frost_protection: frost protection lit the fire, as it had got too cold
+//   - ignition_failed: no flame was proven after ignition, so the fire was turned off
+//   - interlock_tripped: an interlock turned the fire off
+//   - restarted_lit: GoFire started while the fire was believed to be lit, as after a crash
+//     or a power cut
+//
+// Unlike webhooks, which can carry every event to any URL, these are only the events
+// someone should hear about wherever they are. Each is sent in the background, and retried
+// a few times if it fails.
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// Events are the events that can be sent, with their messages.
+var Events = map[string]string{
+	"auto_off":          "The fire was turned off by the auto-off timer",
+	"estop":             "An emergency stop was engaged: the fire is off until it is cleared",
+	"frost_protection":  "Frost protection lit the fire: the room fell below its threshold",
+	"ignition_failed":   "Ignition failed: no flame was proven, so the fire was turned off",
+	"interlock_tripped": "An interlock turned the fire off",
+	"restarted_lit":     "GoFire restarted while the fire was believed to be lit",
+}
+
+// title heads every notification.
+const title = "GoFire"
+
+// attempts is how many times a notification is sent before it is given up.
+const attempts = 3
+
+// backend delivers notifications through one service.
+type backend interface {
+	name() string
+	send(title, message string) error
+}
+
+// Notifier sends events through the configured backends.
+type Notifier struct {
+	events   map[string]bool // nil for every event
+	backends []backend
+}
+
+// Start sends the events of cfg as they happen; restarted_lit is sent with Notify.
+func Start(cfg config.Notifications) (*Notifier, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	n := &Notifier{}
+	if len(cfg.Events) > 0 {
+		n.events = map[string]bool{}
+		for _, e := range cfg.Events {
+			if _, ok := Events[e]; !ok {
+				return nil, fmt.Errorf("notifications: unknown event %q", e)
+			}
+			n.events[e] = true
+		}
+	}
+	if cfg.Ntfy != nil {
+		n.backends = append(n.backends, &ntfy{*cfg.Ntfy, client})
+	}
+	if cfg.Pushover != nil {
+		n.backends = append(n.backends, &pushover{*cfg.Pushover, client})
+	}
+	if cfg.Telegram != nil {
+		n.backends = append(n.backends, &telegram{*cfg.Telegram, client})
+	}
+	if cfg.Email != nil {
+		n.backends = append(n.backends, &email{*cfg.Email})
+	}
+	ch, _ := events.Subscribe()
+	fault.Go("notify", func() { n.watch(ch) })
+	return n, nil
+}
+
+func (n *Notifier) watch(ch <-chan events.Command) {
+	for c := range ch {
+		if event := safetyEvent(c); event != "" {
+			n.Notify(event)
+		}
+	}
+}
+
+// safetyEvent returns the event c is, if any.
+func safetyEvent(c events.Command) string {
+	switch {
+	case (c.Op == "estop" || strings.HasSuffix(c.Op, "/estop")) && c.Result == "engaged":
+		return "estop"
+	case c.Op == "on" && c.Result == "ignition_failed":
+		return "ignition_failed"
+	case c.Op == "on" && c.Result == "ok" && c.Source == "frost":
+		return "frost_protection"
+	case c.Op != "off" || c.Result != "ok":
+		return ""
+	case c.Source == "autooff":
+		return "auto_off"
+	case c.Source == "interlock":
+		return "interlock_tripped"
+	}
+	return ""
+}
+
+// Notify sends event through every backend in the background, unless it isn't wanted.
+func (n *Notifier) Notify(event string) {
+	if n.events != nil && !n.events[event] {
+		return
+	}
+	message := Events[event]
+	for _, b := range n.backends {
+		b := b
+		fault.Go("notify", func() {
+			var err error
+			for attempt := 1; attempt <= attempts; attempt++ {
+				if err = b.send(title, message); err == nil {
+					logging.Event(logging.Debug, "notification sent", "backend", b.name(), "event", event)
+					return
+				}
+				time.Sleep(time.Duration(attempt) * 5 * time.Second)
+			}
+			logging.Event(logging.Warning, "notification failed", "backend", b.name(), "event", event, "error", err.Error())
+		})
+	}
+}
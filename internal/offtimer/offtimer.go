@@ -0,0 +1,146 @@
+// Package offtimer turns the fire off a set time after a command asked for it, as with
+// /on?for=90m to warm the room while watching a film, without a schedule entry. There is one
+// timer; setting it again replaces it, and it is dropped once the fire is turned off or put
+// down to its pilot by anything else.
+package offtimer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// source is what the off command is recorded as.
+const source = "timer"
+
+// retryEvery is how often an off that didn't succeed, e.g. while the relays were busy, is
+// sent again.
+const retryEvery = 10 * time.Second
+
+// Timer is the off timer.
+type Timer struct {
+	runner *actions.Runner
+
+	mu  sync.Mutex
+	job *job // nil unless set
+}
+
+// job is a timer that is set.
+type job struct {
+	state  State
+	dryRun bool
+	stop   chan struct{}
+}
+
+// State is the timer while it is set.
+type State struct {
+	Off       time.Time `json:"off"`       // when the fire is turned off
+	Remaining string    `json:"remaining"` // until then, e.g. 1h29m0s
+	Source    string    `json:"source"`
+	Set       time.Time `json:"set"`
+}
+
+// New returns the off timer of the fireplace runner drives.
+func New(runner *actions.Runner) *Timer {
+	t := &Timer{runner: runner}
+	ch, _ := events.Subscribe()
+	fault.Go("offtimer", func() { t.watch(ch) })
+	return t
+}
+
+// Start sets the timer to turn the fire off after d, on behalf of from, in place of any timer
+// set already. With dryRun the off is a dry run too (see gv60.DryRun).
+func (t *Timer) Start(d time.Duration, from string, dryRun bool) {
+	now := time.Now()
+	j := &job{state: State{Off: now.Add(d), Source: from, Set: now}, dryRun: dryRun, stop: make(chan struct{})}
+	t.mu.Lock()
+	if t.job != nil {
+		t.stop("replaced")
+	}
+	t.job = j
+	t.mu.Unlock()
+	logging.Event(logging.Info, "off timer set", "after", d.String(), "source", from)
+	fault.Go("offtimer", func() { t.run(j) })
+}
+
+// Stop clears the timer, if set, for reason, and reports whether it was.
+func (t *Timer) Stop(reason string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.job == nil {
+		return false
+	}
+	t.stop(reason)
+	return true
+}
+
+// stop clears the timer; callers must hold mu.
+func (t *Timer) stop(reason string) {
+	close(t.job.stop)
+	logging.Event(logging.Info, "off timer cleared", "reason", reason)
+	t.job = nil
+}
+
+// State returns the timer, or nil when it isn't set.
+func (t *Timer) State() *State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.job == nil {
+		return nil
+	}
+	st := t.job.state
+	st.Remaining = time.Until(st.Off).Round(time.Second).String()
+	return &st
+}
+
+// run waits for j to go off and turns the fire off, retrying until it succeeds.
+func (t *Timer) run(j *job) {
+	ctx := context.Background()
+	if j.dryRun {
+		ctx = gv60.DryRun(ctx)
+	}
+	wait := time.Until(j.state.Off)
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-j.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		result := t.runner.RunContext(ctx, "off", source)
+		t.mu.Lock()
+		if t.job != j {
+			t.mu.Unlock()
+			return
+		}
+		if result == "ok" {
+			logging.Event(logging.Notice, "off timer: the fire is off", "source", j.state.Source)
+			t.stop("done")
+			t.mu.Unlock()
+			return
+		}
+		logging.Logf(logging.Warning, "offtimer: retrying off: %s", result)
+		t.mu.Unlock()
+		wait = retryEvery
+	}
+}
+
+// watch clears the timer once another source turns the fire off or down to its pilot.
+func (t *Timer) watch(ch <-chan events.Command) {
+	for c := range ch {
+		if c.Source == source || c.Result != "ok" {
+			continue
+		}
+		switch c.Op {
+		case "off", "pilot":
+			t.Stop(c.Op + " from " + c.Source)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package power tracks the state of the fireplace, which GoFire can't read back: whether it
+// is lit, from the on and off commands that succeed, and an estimate of its flame level, from
+// how long the flame contacts have been held. It also decides what the state is taken to be
+// when GoFire starts.
+package power
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/sensor"
+)
+
+// probeTimeout is how long a probe waits for the flame sensor's first reading.
+const probeTimeout = time.Minute
+
+// Levels describes how commands move the estimated flame level.
+type Levels struct {
+	Max      int     // levels above off; ignition lights at Max
+	Up, Down float64 // levels moved by one flameup or flamedown
+}
+
+// Tracker follows the fireplace's power, on, off, pilot (standby) or unknown (""), and
+// flame level.
+type Tracker struct {
+	file   string
+	levels Levels
+
+	mu    sync.Mutex
+	state string
+	level float64 // 0 when off; meaningless while unknown
+	known bool    // level is known: after an on or off, or restored
+	last  string  // op of the last successful command
+	since time.Time
+
+	burned time.Duration // burn time of the fires put out, kept in file
+}
+
+// State is the tracked state.
+type State struct {
+	Power       string    `json:"power"`                 // on, off, pilot, or empty when unknown
+	FlameLevel  *int      `json:"flame_level,omitempty"` // estimated, 0 to Levels.Max; absent when unknown
+	LastCommand string    `json:"last_command,omitempty"`
+	Since       time.Time `json:"since"` // when Power last changed
+}
+
+// saved is the state file, and the state passed on by an upgrade.
+type saved struct {
+	Power  string    `json:"power"`
+	Level  *float64  `json:"level,omitempty"`
+	Since  time.Time `json:"since"`
+	Burned string    `json:"burned,omitempty"` // total burn time of the fires put out, e.g. 41h3m0s
+}
+
+// Inherited is the state passed on by an in-place upgrade.
+type Inherited struct {
+	Power string
+	Level *float64
+	Since time.Time
+}
+
+// Start begins tracking. The state at start is inherited (from the process replaced by an
+// upgrade) when in.Power isn't empty, and otherwise follows cfg.State. The total burn time
+// is always restored from the state file.
+func Start(cfg config.Startup, levels Levels, sensors *sensor.Registry, in Inherited) *Tracker {
+	t := &Tracker{file: cfg.StateFile, levels: levels, since: time.Now()}
+	s := t.load()
+	if d, err := time.ParseDuration(s.Burned); err == nil {
+		t.burned = d
+	}
+	switch {
+	case in.Power != "":
+		t.state = in.Power
+		if in.Level != nil {
+			t.level, t.known = *in.Level, true
+		}
+		if !in.Since.IsZero() {
+			t.since = in.Since
+		}
+	case cfg.State == "restore":
+		t.restore(s)
+	case cfg.State == "probe":
+		go t.probe(cfg, sensors)
+	default:
+		t.state, t.known = "off", true
+	}
+	logging.Event(logging.Info, "fireplace state at start", "mode", cfg.State, "power", t.State().Power)
+	t.follow("")
+	return t
+}
+
+// StartNamed begins tracking the further fireplace called name, whose commands are recorded
+// as name/op. It is taken to be off at start, and its burn time isn't kept.
+func StartNamed(name string, levels Levels) *Tracker {
+	t := &Tracker{levels: levels, since: time.Now(), state: "off", known: true}
+	t.follow(name)
+	return t
+}
+
+// follow applies the successful commands of the fireplace called name, or of the main
+// fireplace when name is empty.
+func (t *Tracker) follow(name string) {
+	ch, _ := events.Subscribe()
+	go func() {
+		for c := range ch {
+			op := c.Op
+			if name != "" {
+				if !strings.HasPrefix(op, name+"/") {
+					continue
+				}
+				op = strings.TrimPrefix(op, name+"/")
+			}
+			if c.Result == "ok" {
+				t.apply(op, c.Params)
+			}
+		}
+	}()
+}
+
+// State returns the tracked state.
+func (t *Tracker) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := State{Power: t.state, LastCommand: t.last, Since: t.since}
+	if t.known {
+		l := int(math.Round(t.level))
+		s.FlameLevel = &l
+	}
+	return s
+}
+
+// BurnTime returns how long the fire has burned, including the current burn: in all, with
+// a state file, and otherwise since GoFire started.
+func (t *Tracker) BurnTime() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.burned
+	if t.state == "on" {
+		d += time.Since(t.since)
+	}
+	return d
+}
+
+// Levels returns how commands move the flame level.
+func (t *Tracker) Levels() Levels {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.levels
+}
+
+// Inherit returns the state to pass on in an upgrade.
+func (t *Tracker) Inherit() Inherited {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	in := Inherited{Power: t.state, Since: t.since}
+	if t.known {
+		l := t.level
+		in.Level = &l
+	}
+	return in
+}
+
+// SetLevels changes how commands move the flame level, e.g. once the valve's travel time
+// has been calibrated.
+func (t *Tracker) SetLevels(l Levels) {
+	t.mu.Lock()
+	t.levels = l
+	t.mu.Unlock()
+}
+
+// apply updates the state for a successful command. A setflame or calibrate command
+// leaves the flame at the level in its params.
+func (t *Tracker) apply(op string, params map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if (op == "flameup" || op == "flamedown") && t.state == "off" {
+		return // the valve ignores flame commands while off
+	}
+	if op == "flamedown" && t.state == "pilot" {
+		return // already as low as it goes
+	}
+	switch op {
+	case "on":
+		t.level, t.known = float64(t.levels.Max), true
+	case "off", "pilot":
+		t.level, t.known = 0, true
+	case "setflame", "calibrate":
+		if t.state != "on" {
+			return
+		}
+		l, err := strconv.Atoi(params["level"])
+		if err != nil {
+			return
+		}
+		t.level, t.known = float64(l), true
+	case "flameup":
+		if t.state == "pilot" {
+			// flame up from the pilot lights the burner again
+			t.level = 0
+			t.state, t.since = "on", time.Now()
+		}
+		t.level = math.Min(t.level+t.levels.Up, float64(t.levels.Max))
+	case "flamedown":
+		// the valve keeps a pilot, so flame down never puts the fire out
+		t.level = math.Max(t.level-t.levels.Down, math.Min(1, float64(t.levels.Max)))
+	default:
+		return
+	}
+	t.last = op
+	if (op == "on" || op == "off" || op == "pilot") && t.state != op {
+		if t.state == "on" {
+			t.burned += time.Since(t.since)
+		}
+		t.state, t.since = op, time.Now()
+	}
+	if err := t.save(); err != nil {
+		logging.Logf(logging.Warning, "power: saving state: %v", err)
+	}
+}
+
+// probe reads the flame sensor, unless a command has settled the state first.
+func (t *Tracker) probe(cfg config.Startup, sensors *sensor.Registry) {
+	deadline := time.Now().Add(probeTimeout)
+	for time.Now().Before(deadline) {
+		if r, ok := sensors.ForRole(cfg.ProbeRole); ok {
+			state := "off"
+			if r.Value > cfg.ProbeAbove {
+				state = "on"
+			}
+			t.mu.Lock()
+			if t.state == "" {
+				t.state, t.since = state, time.Now()
+				if state == "off" {
+					t.level, t.known = 0, true
+				}
+				logging.Event(logging.Info, "fireplace state probed", "power", state, "sensor", cfg.ProbeRole)
+			}
+			t.mu.Unlock()
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	logging.Event(logging.Warning, "fireplace state unknown: no flame sensor reading", "role", cfg.ProbeRole)
+}
+
+// load reads the state file; it is empty if there is none or it can't be read.
+func (t *Tracker) load() saved {
+	var s saved
+	if t.file == "" {
+		return s
+	}
+	data, err := ioutil.ReadFile(t.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logging.Logf(logging.Warning, "power: %v", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		logging.Logf(logging.Warning, "power: %s: %v", t.file, err)
+		return saved{}
+	}
+	return s
+}
+
+// restore takes up the state saved in the state file.
+func (t *Tracker) restore(s saved) {
+	if s.Power == "" {
+		return
+	}
+	t.state = s.Power
+	if s.Level != nil {
+		t.level, t.known = *s.Level, true
+	}
+	// keeps burn time counting, e.g. for auto-off, across a restart
+	if !s.Since.IsZero() {
+		t.since = s.Since
+	}
+}
+
+// save writes the state file, if any, replacing it atomically; callers must hold mu.
+func (t *Tracker) save() error {
+	if t.file == "" {
+		return nil
+	}
+	s := saved{Power: t.state, Since: t.since, Burned: t.burned.String()}
+	if t.known {
+		s.Level = &t.level
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(t.file, data, 0600)
+}
@@ -0,0 +1,112 @@
+package presence
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+// pingTimeout is how long a phone has to answer.
+const pingTimeout = time.Second
+
+var pingSeq uint32
+
+// pingUnavailable logs, once, that ICMP can't be used.
+var pingUnavailable sync.Once
+
+// reachable reports whether host answers a ping or, as phones asleep often ignore pings, it
+// answered the ARP request the ping made (its entry is complete).
+func reachable(host string) bool {
+	addr, err := net.ResolveIPAddr("ip4", host)
+	if err != nil {
+		return false
+	}
+	ok, err := ping(addr.IP)
+	if err != nil {
+		pingUnavailable.Do(func() {
+			logging.Logf(logging.Warning, "presence: ping: %v; going by ARP alone (see net.ipv4.ping_group_range)", err)
+		})
+		// any packet makes the kernel resolve the address
+		if c, err := net.Dial("udp4", net.JoinHostPort(addr.IP.String(), "9")); err == nil {
+			c.Write([]byte{0})
+			c.Close()
+			time.Sleep(pingTimeout)
+		}
+	}
+	return ok || arpComplete(addr.IP)
+}
+
+// ping sends an ICMP echo to ip over an unprivileged ICMP socket, and reports whether it was
+// answered. It fails if the socket can't be had.
+func ping(ip net.IP) (bool, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMP)
+	if err != nil {
+		return false, err
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	c, err := net.FilePacketConn(f)
+	f.Close()
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+	// echo request: type 8, code 0, checksum, id (set by the kernel), sequence, data
+	seq := uint16(atomic.AddUint32(&pingSeq, 1))
+	msg := []byte{8, 0, 0, 0, 0, 0, byte(seq >> 8), byte(seq), 'g', 'o', 'f', 'i', 'r', 'e'}
+	sum := checksum(msg)
+	msg[2], msg[3] = byte(sum>>8), byte(sum)
+	if _, err := c.WriteTo(msg, &net.UDPAddr{IP: ip}); err != nil {
+		return false, nil
+	}
+	c.SetReadDeadline(time.Now().Add(pingTimeout))
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			return false, nil
+		}
+		if n >= 8 && buf[0] == 0 && uint16(buf[6])<<8|uint16(buf[7]) == seq {
+			return true, nil
+		}
+	}
+}
+
+// checksum is the Internet checksum of b.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// arpComplete reports whether the kernel's ARP table holds a resolved entry for ip.
+func arpComplete(ip net.IP) bool {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// IP address, HW type, flags, HW address, mask, device
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 4 && fields[0] == ip.String() {
+			return fields[2] != "0x0" && fields[3] != "00:00:00:00:00:00"
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+// Package presence turns the fire down to its pilot, or off, once nobody is home: once none
+// of the household's phones has answered on the network, and none of their BLE beacons has
+// been heard, for a while.
+package presence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/sensor"
+)
+
+// source is what the action is recorded as.
+const source = "presence"
+
+// Detector follows whether anybody is home.
+type Detector struct {
+	cfg    config.Presence
+	power  *power.Tracker
+	runner *actions.Runner
+
+	mu            sync.Mutex
+	seen          map[string]time.Time // by device name
+	started       time.Time            // everybody is taken to be home at start
+	override      string               // home or away, set by hand
+	overrideUntil time.Time            // zero until cleared
+	away          bool
+	acted         bool // the action has been run and logged for this absence
+}
+
+// State is whether anybody is home, and when each device was last seen.
+type State struct {
+	State         string                `json:"state"` // home or away
+	LastSeen      *time.Time            `json:"last_seen,omitempty"`
+	Override      string                `json:"override,omitempty"`
+	OverrideUntil *time.Time            `json:"override_until,omitempty"`
+	Devices       map[string]*time.Time `json:"devices"` // null until seen
+}
+
+// Start begins looking for the devices, listening for beacons on hci adapter, and runs the
+// action with runner once nobody is home while the fire burns.
+func Start(cfg config.Presence, adapter int, pw *power.Tracker, runner *actions.Runner) (*Detector, error) {
+	for _, dev := range cfg.Devices {
+		if dev.Beacon != "" {
+			if err := sensor.StartBLEScan(adapter); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	d := &Detector{cfg: cfg, power: pw, runner: runner, seen: map[string]time.Time{}, started: time.Now()}
+	fault.Go("presence", d.watch)
+	return d, nil
+}
+
+// Override takes everybody to be home (state "home") or away ("away") for d, or until
+// cleared when d is 0, whatever the devices show; an empty state clears the override.
+func (d *Detector) Override(state string, dur time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.override, d.overrideUntil = state, time.Time{}
+	if state != "" && dur > 0 {
+		d.overrideUntil = time.Now().Add(dur)
+	}
+}
+
+// State returns whether anybody is home.
+func (d *Detector) State() State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	st := State{State: "home", Devices: map[string]*time.Time{}}
+	if d.isAway(now) {
+		st.State = "away"
+	}
+	if last := d.lastSeen(); !last.Equal(d.started) {
+		st.LastSeen = &last
+	}
+	if d.overriding(now) {
+		st.Override = d.override
+		if !d.overrideUntil.IsZero() {
+			until := d.overrideUntil
+			st.OverrideUntil = &until
+		}
+	}
+	for _, dev := range d.cfg.Devices {
+		st.Devices[dev.Name] = nil
+		if t, ok := d.seen[dev.Name]; ok {
+			st.Devices[dev.Name] = &t
+		}
+	}
+	return st
+}
+
+// overriding reports whether an override is in force, clearing one that has run out; callers
+// must hold mu.
+func (d *Detector) overriding(now time.Time) bool {
+	if d.override != "" && !d.overrideUntil.IsZero() && now.After(d.overrideUntil) {
+		d.override, d.overrideUntil = "", time.Time{}
+	}
+	return d.override != ""
+}
+
+// lastSeen returns when any device was last seen, or the start if later; callers must hold mu.
+func (d *Detector) lastSeen() time.Time {
+	last := d.started
+	for _, t := range d.seen {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// isAway reports whether nobody is home; callers must hold mu.
+func (d *Detector) isAway(now time.Time) bool {
+	if d.overriding(now) {
+		return d.override == "away"
+	}
+	return now.Sub(d.lastSeen()) >= d.cfg.Away
+}
+
+func (d *Detector) watch() {
+	for {
+		d.look()
+		d.check(time.Now())
+		time.Sleep(d.cfg.Interval)
+	}
+}
+
+// look pings the phones and checks when the beacons were last heard.
+func (d *Detector) look() {
+	var wg sync.WaitGroup
+	for _, dev := range d.cfg.Devices {
+		dev := dev
+		if dev.Beacon != "" {
+			if t := sensor.BLESeen(dev.Beacon); !t.IsZero() {
+				d.saw(dev.Name, t)
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reachable(dev.Host) {
+				d.saw(dev.Name, time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (d *Detector) saw(name string, t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t.After(d.seen[name]) {
+		d.seen[name] = t
+	}
+}
+
+// check logs arrivals and departures and, once nobody has been home for presence.away while
+// the fire burns (counting from ignition if it was lit while away), runs the action.
+func (d *Detector) check(now time.Time) {
+	d.mu.Lock()
+	away, forced := d.isAway(now), d.overriding(now)
+	absent := d.lastSeen()
+	if away != d.away {
+		state := map[bool]string{true: "away", false: "home"}[away]
+		logging.Event(logging.Info, "presence changed", "state", state)
+		d.away, d.acted = away, false
+	}
+	d.mu.Unlock()
+	ps := d.power.State()
+	if !away || ps.Power != "on" {
+		return
+	}
+	if ps.Since.After(absent) {
+		absent = ps.Since
+	}
+	if !forced && now.Sub(absent) < d.cfg.Away {
+		return
+	}
+	// retried every check until it succeeds, e.g. while the relays are busy
+	result := d.runner.Run(d.cfg.Action, source)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.acted {
+		logging.Event(logging.Notice, "presence: nobody home, turning the fire down",
+			"action", d.cfg.Action, "away", d.cfg.Away.String(), "result", result)
+		d.acted = true
+	} else if result != "ok" {
+		logging.Logf(logging.Warning, "presence: retrying %s: %s", d.cfg.Action, result)
+	}
+}
@@ -0,0 +1,261 @@
+// Package presets keeps named settings of the main fireplace, such as "cosy" (lit at level
+// 3 with the fan low) or "max-heat" (lit at the highest level), and applies them in one
+// command: lighting the fire if it isn't lit, setting the flame, then the fan and the light
+// when the preset gives them.
+//
+// Presets come from the configuration, which the API can't change, and from /presets, kept in
+// presets.file. Applying one is recorded as the op preset with the param name, beside the
+// commands it sends.
+package presets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+var (
+	// ErrNotFound is returned for a preset that doesn't exist.
+	ErrNotFound = errors.New("presets: no such preset")
+	// ErrConfigured is returned for a change to a preset from the configuration.
+	ErrConfigured = errors.New("presets: the preset is set in the configuration")
+)
+
+// settle bounds how long applying a preset waits for the power tracker to see the fire lit
+// before setting the flame.
+const settle = 2 * time.Second
+
+var presetName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// Preset is a setting of the fire: lit at Level, with the fan and the light set when given.
+type Preset struct {
+	Name  string `json:"name"`
+	Level int    `json:"level"`
+	Fan   *int   `json:"fan,omitempty"`   // speed, 0 for off
+	Light *int   `json:"light,omitempty"` // brightness, 0 to 100
+	// Configured presets are from the configuration, and can't be changed with the API.
+	Configured bool `json:"configured,omitempty"`
+}
+
+// Store holds the presets and applies them to the main fireplace.
+type Store struct {
+	file   string
+	runner *actions.Runner
+	power  *power.Tracker
+	flame  *flame.Control // nil for drivers stepped with flame up and down
+
+	mu       sync.Mutex
+	presets  map[string]Preset
+	watchers []func()
+}
+
+// Open returns the presets of cfg, and those saved before in cfg.File, applied with runner
+// to the fireplace pw tracks; flameCtl, if not nil, sets the flame in one move.
+func Open(cfg config.Presets, runner *actions.Runner, pw *power.Tracker, flameCtl *flame.Control) (*Store, error) {
+	s := &Store{file: cfg.File, runner: runner, power: pw, flame: flameCtl, presets: map[string]Preset{}}
+	if s.file != "" {
+		data, err := ioutil.ReadFile(s.file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			var saved []Preset
+			if err := json.Unmarshal(data, &saved); err != nil {
+				return nil, fmt.Errorf("presets: %s: %v", s.file, err)
+			}
+			for _, p := range saved {
+				s.presets[p.Name] = p
+			}
+		}
+	}
+	for _, c := range cfg.Presets {
+		p := Preset{Name: c.Name, Level: c.Level, Fan: c.Fan, Light: c.Light, Configured: true}
+		if err := s.Validate(p); err != nil {
+			return nil, fmt.Errorf("presets.presets: %v", err)
+		}
+		if s.presets[p.Name].Configured {
+			return nil, fmt.Errorf("presets.presets: %s is named twice", p.Name)
+		}
+		s.presets[p.Name] = p
+	}
+	return s, nil
+}
+
+// Validate checks p against the fireplace: a level it has, and a fan and a light only if it
+// has them.
+func (s *Store) Validate(p Preset) error {
+	if !presetName.MatchString(p.Name) {
+		return fmt.Errorf("name must be 1 to 32 of a-z, 0-9, _ and -, not %q", p.Name)
+	}
+	if max := s.power.Levels().Max; p.Level < 1 || p.Level > max {
+		return fmt.Errorf("%s: level must be 1 to %d, not %d", p.Name, max, p.Level)
+	}
+	if p.Fan != nil {
+		if _, ok := s.runner.Fire.(fireplace.Fan); !ok {
+			return fmt.Errorf("%s: the fireplace has no fan", p.Name)
+		}
+		if *p.Fan < 0 {
+			return fmt.Errorf("%s: fan must be 0 or more, not %d", p.Name, *p.Fan)
+		}
+	}
+	if p.Light != nil {
+		if s.runner.Light == nil {
+			return fmt.Errorf("%s: there is no light", p.Name)
+		}
+		if *p.Light < 0 || *p.Light > 100 {
+			return fmt.Errorf("%s: light must be 0 to 100, not %d", p.Name, *p.Light)
+		}
+	}
+	return nil
+}
+
+// List returns the presets, ordered by name.
+func (s *Store) List() []Preset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Preset, 0, len(s.presets))
+	for _, p := range s.presets {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Put saves p, or replaces the saved preset of its name.
+func (s *Store) Put(p Preset) error {
+	p.Configured = false
+	if err := s.Validate(p); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.presets[p.Name].Configured {
+		s.mu.Unlock()
+		return ErrConfigured
+	}
+	s.presets[p.Name] = p
+	err := s.save()
+	s.mu.Unlock()
+	s.changed()
+	return err
+}
+
+// Delete removes the saved preset name.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	p, ok := s.presets[name]
+	switch {
+	case !ok:
+		s.mu.Unlock()
+		return ErrNotFound
+	case p.Configured:
+		s.mu.Unlock()
+		return ErrConfigured
+	}
+	delete(s.presets, name)
+	err := s.save()
+	s.mu.Unlock()
+	s.changed()
+	return err
+}
+
+// OnChange calls f, in the caller of Put or Delete, whenever a preset is saved or removed,
+// e.g. to announce the presets again.
+func (s *Store) OnChange(f func()) {
+	s.mu.Lock()
+	s.watchers = append(s.watchers, f)
+	s.mu.Unlock()
+}
+
+func (s *Store) changed() {
+	s.mu.Lock()
+	watchers := s.watchers
+	s.mu.Unlock()
+	for _, f := range watchers {
+		f()
+	}
+}
+
+// Apply applies the preset name on behalf of source, and returns the result of the first
+// command that wasn't ok, or ok. Commands are abandoned once ctx is done.
+func (s *Store) Apply(ctx context.Context, name, source string) (string, error) {
+	s.mu.Lock()
+	p, ok := s.presets[name]
+	s.mu.Unlock()
+	if !ok {
+		return "", ErrNotFound
+	}
+	result := s.apply(ctx, p, source)
+	events.RecordContext(ctx, "preset", result, source, map[string]string{"name": name})
+	return result, nil
+}
+
+func (s *Store) apply(ctx context.Context, p Preset, source string) string {
+	if s.power.State().Power != "on" {
+		if result := s.runner.RunContext(ctx, "on", source); result != "ok" {
+			return result
+		}
+		// the tracker follows the command it is subscribed to in the background
+		for deadline := time.Now().Add(settle); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if s.power.State().Power == "on" {
+				break
+			}
+		}
+	}
+	var result string
+	if s.flame != nil {
+		result = s.flame.SetContext(ctx, p.Level, source)
+	} else {
+		result = s.runner.SetFlame(s.power, p.Level, source)
+	}
+	if result != "ok" {
+		return result
+	}
+	if p.Fan != nil {
+		speed := *p.Fan
+		fan := s.runner.Fire.(fireplace.Fan) // checked by Validate
+		result = s.runner.DoContext(ctx, "fan", source, func(fireplace.Fireplace) error { return fan.SetFan(speed) })
+		events.RecordContext(ctx, s.runner.Op("fan"), result, source, map[string]string{"speed": strconv.Itoa(speed)})
+		if result != "ok" {
+			return result
+		}
+	}
+	if p.Light != nil && s.runner.Light != nil {
+		brightness, _ := s.runner.Light.Set("", *p.Light, -1)
+		events.RecordContext(ctx, s.runner.Op("light"), "ok", source, map[string]string{"brightness": strconv.Itoa(brightness)})
+	}
+	return "ok"
+}
+
+// save writes the saved presets to the file, if any; callers must hold mu.
+func (s *Store) save() error {
+	if s.file == "" {
+		return nil
+	}
+	saved := []Preset{}
+	for _, p := range s.presets {
+		if !p.Configured {
+			saved = append(saved, p)
+		}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(s.file, data, 0600)
+}
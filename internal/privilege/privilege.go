@@ -0,0 +1,54 @@
+// Package privilege lets GoFire be started as root, open its hardware and then carry on as
+// an ordinary user, rather than serving HTTP as root.
+package privilege
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Drop switches the process to the named user, with its primary and supplementary groups,
+// giving up root and with it any capabilities. It does nothing when not running as root.
+func Drop(name string) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return fmt.Errorf("run_as: %v", err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("run_as: uid %s: %v", u.Uid, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("run_as: gid %s: %v", u.Gid, err)
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return fmt.Errorf("run_as: groups of %s: %v", name, err)
+	}
+	var groups []int
+	for _, id := range ids {
+		if g, err := strconv.Atoi(id); err == nil {
+			groups = append(groups, g)
+		}
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("run_as: setgroups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("run_as: setgid: %v", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("run_as: setuid: %v", err)
+	}
+	if uid != 0 && syscall.Setuid(0) == nil {
+		return fmt.Errorf("run_as: root could be regained after switching to %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Package profiles keeps per-user preferences server-side, keyed by the name of the token
+// a user authenticates with, so they follow the user from one client to another.
+package profiles
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/barrylb/go-fire/internal/fileutil"
+)
+
+// MaxFlameLevel is the highest default flame level, in steps above the lowest flame.
+const MaxFlameLevel = 6
+
+// Profile is one user's preferences. UI is opaque to the server and kept for the clients.
+type Profile struct {
+	FavoriteScenes    []string        `json:"favorite_scenes,omitempty"`
+	DefaultFlameLevel *int            `json:"default_flame_level,omitempty"`
+	Notifications     []string        `json:"notifications,omitempty"`
+	UI                json.RawMessage `json:"ui,omitempty"`
+}
+
+// Validate checks the fields the server acts on.
+func (p Profile) Validate() error {
+	if l := p.DefaultFlameLevel; l != nil && (*l < 0 || *l > MaxFlameLevel) {
+		return fmt.Errorf("default_flame_level must be 0-%d", MaxFlameLevel)
+	}
+	for _, n := range p.Notifications {
+		if n == "" {
+			return errors.New("empty notification subscription")
+		}
+	}
+	return nil
+}
+
+// Store holds every user's profile, persisted to one JSON file.
+type Store struct {
+	file string
+
+	mu       sync.Mutex
+	profiles map[string]Profile
+}
+
+// Open loads the profiles saved in file, which is created on the first change.
+func Open(file string) (*Store, error) {
+	s := &Store{file: file, profiles: map[string]Profile{}}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.profiles); err != nil {
+		return nil, fmt.Errorf("profiles: %s: %v", file, err)
+	}
+	return s, nil
+}
+
+// Get returns user's profile, empty if they haven't saved one.
+func (s *Store) Get(user string) Profile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.profiles[user]
+}
+
+// Set replaces user's profile.
+func (s *Store) Set(user string, p Profile) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles[user] = p
+	return s.save()
+}
+
+// Delete removes user's profile.
+func (s *Store) Delete(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.profiles, user)
+	return s.save()
+}
+
+// save writes the profiles, replacing the file atomically; callers must hold mu.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(s.file, data, 0600)
+}
@@ -0,0 +1,225 @@
+// Package proflame drives fireplaces with a SIT Proflame 2 receiver through a 315 MHz OOK
+// transmitter module on a GPIO line, for owners whose fireplace has no wall-switch
+// contacts to wire relays to.
+//
+// Proflame 2 frames carry the whole requested state (flame level, fan speed, light, split
+// flow, aux) and the remote's serial number, without a rolling code. Rather than encode
+// frames itself, the driver replays frames captured from the fireplace's own remote (e.g.
+// with rtl_433 -R 0 -X, as strings of 0 and 1 symbols), one per state it can select:
+//
+//	on, off, flame_1 ... flame_N, fan_0 ... fan_N, split_flow_on, split_flow_off, aux_on, aux_off
+//
+// Capture each frame with the other settings as they should normally be; an operation
+// whose frame is missing returns ErrUnsupported. Frames are repeated like the remote does.
+// Other fixed-code OOK remotes, on 315 or 433 MHz with a transmitter module for that band,
+// are driven the same way from their own captures.
+package proflame
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/pkg/relay"
+	"golang.org/x/sync/semaphore"
+)
+
+// Driver controls a Proflame 2 fireplace through captured frames.
+type Driver struct {
+	tx     relay.Line // transmitter data pin, high for carrier
+	cfg    config.Proflame
+	frames map[string][]bool
+	sem    *semaphore.Weighted
+
+	mu        sync.Mutex
+	cancelled bool
+	sending   bool
+	level     int // flame level last selected, 0 when off
+	aux       bool
+
+	// CheckIgnition, if set, is consulted before lighting the fire, as for gv60.
+	CheckIgnition func() error
+	// Wait is how long an operation waits for a running one before failing with ErrBusy.
+	Wait time.Duration
+}
+
+var _ fireplace.Fireplace = (*Driver)(nil)
+var _ fireplace.Fan = (*Driver)(nil)
+var _ fireplace.SplitFlow = (*Driver)(nil)
+
+// New returns a driver transmitting on tx, which must start low.
+func New(tx relay.Line, cfg config.Proflame) (*Driver, error) {
+	d := &Driver{tx: tx, cfg: cfg, frames: map[string][]bool{}, sem: semaphore.NewWeighted(1)}
+	for name, symbols := range cfg.Frames {
+		var bits []bool
+		for _, c := range strings.Join(strings.Fields(symbols), "") {
+			switch c {
+			case '0', '1':
+				bits = append(bits, c == '1')
+			default:
+				return nil, fmt.Errorf("proflame: frame %s: %q is not a 0 or 1 symbol", name, c)
+			}
+		}
+		d.frames[name] = bits
+	}
+	return d, nil
+}
+
+// On lights the fire at the level selected by the captured "on" frame, taken to be the
+// highest.
+func (d *Driver) On() error {
+	if d.CheckIgnition != nil {
+		if err := d.CheckIgnition(); err != nil {
+			return fmt.Errorf("%w: %v", fireplace.ErrLockout, err)
+		}
+	}
+	return d.send("on", func() { d.level = d.cfg.Levels })
+}
+
+// Off turns the fire off.
+func (d *Driver) Off() error {
+	return d.send("off", func() { d.level = 0 })
+}
+
+// FlameUp selects the next flame level; from off it lights the fire at level 1.
+func (d *Driver) FlameUp() error {
+	d.mu.Lock()
+	level := d.level + 1
+	d.mu.Unlock()
+	if level > d.cfg.Levels {
+		level = d.cfg.Levels
+	}
+	if level == 1 && d.CheckIgnition != nil {
+		if err := d.CheckIgnition(); err != nil {
+			return fmt.Errorf("%w: %v", fireplace.ErrLockout, err)
+		}
+	}
+	return d.send(fmt.Sprintf("flame_%d", level), func() { d.level = level })
+}
+
+// FlameDown selects the previous flame level, stopping at level 1.
+func (d *Driver) FlameDown() error {
+	d.mu.Lock()
+	level := d.level - 1
+	d.mu.Unlock()
+	if level < 1 {
+		level = 1
+	}
+	return d.send(fmt.Sprintf("flame_%d", level), func() { d.level = level })
+}
+
+// Aux toggles the auxiliary output.
+func (d *Driver) Aux() error {
+	d.mu.Lock()
+	on := !d.aux
+	d.mu.Unlock()
+	frame := "aux_off"
+	if on {
+		frame = "aux_on"
+	}
+	return d.send(frame, func() { d.aux = on })
+}
+
+// SetFan sets the blower speed, 0 for off.
+func (d *Driver) SetFan(speed int) error {
+	return d.send(fmt.Sprintf("fan_%d", speed), nil)
+}
+
+// SetSplitFlow opens or closes the split-flow valve.
+func (d *Driver) SetSplitFlow(on bool) error {
+	if on {
+		return d.send("split_flow_on", nil)
+	}
+	return d.send("split_flow_off", nil)
+}
+
+// Cancel stops repeating the frame being sent.
+func (d *Driver) Cancel() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.sending {
+		return false
+	}
+	d.cancelled = true
+	return true
+}
+
+// Drain waits for a transmission to finish and then refuses new ones with ErrBusy.
+func (d *Driver) Drain() {
+	d.sem.Acquire(context.Background(), 1)
+}
+
+// send transmits the named frame cfg.Repeats times and, unless cancelled, applies the
+// state change it selects.
+func (d *Driver) send(name string, apply func()) error {
+	bits, ok := d.frames[name]
+	if !ok {
+		return fireplace.ErrUnsupported
+	}
+	if !d.acquire() {
+		return fireplace.ErrBusy
+	}
+	defer d.sem.Release(1)
+	d.mu.Lock()
+	d.sending, d.cancelled = true, false
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.sending = false
+		d.mu.Unlock()
+	}()
+	for i := 0; i < d.cfg.Repeats; i++ {
+		d.mu.Lock()
+		cancelled := d.cancelled
+		d.mu.Unlock()
+		if cancelled {
+			return fireplace.ErrCancelled
+		}
+		if err := d.transmit(bits); err != nil {
+			return err
+		}
+		time.Sleep(d.cfg.Gap)
+	}
+	if apply != nil {
+		d.mu.Lock()
+		apply()
+		d.mu.Unlock()
+	}
+	return nil
+}
+
+// transmit keys the carrier for each 1 symbol. Symbols are a few hundred microseconds,
+// so the timing is kept by spinning on one OS thread rather than sleeping.
+func (d *Driver) transmit(bits []bool) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer d.tx.SetValue(0)
+	next := time.Now()
+	for _, b := range bits {
+		v := 0
+		if b {
+			v = 1
+		}
+		if err := d.tx.SetValue(v); err != nil {
+			return err
+		}
+		next = next.Add(d.cfg.Symbol)
+		for time.Now().Before(next) {
+		}
+	}
+	return nil
+}
+
+func (d *Driver) acquire() bool {
+	if d.Wait <= 0 {
+		return d.sem.TryAcquire(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.Wait)
+	defer cancel()
+	return d.sem.Acquire(ctx, 1) == nil
+}
@@ -0,0 +1,199 @@
+// Package quiet holds the fireplace to household rules during quiet hours, such as not
+// lighting the fire overnight: ignition is refused or, with policy cap, the flame is kept at
+// or below a level, turning it down when it is lit or set higher. Commands refused are
+// logged and published, and an override lifts the rules until the quiet hours end.
+package quiet
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fireplace"
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// source is what turning the flame down is recorded as.
+const source = "quiet"
+
+// checkEvery is how often the flame is checked against the cap.
+const checkEvery = 10 * time.Second
+
+// Hours applies the quiet-hours policy.
+type Hours struct {
+	cfg        config.QuietHours
+	start, end int // minutes after midnight
+	loc        *time.Location
+	power      *power.Tracker
+	runner     *actions.Runner
+	flame      *flame.Control // nil unless the driver sets the flame with one pulse
+
+	mu            sync.Mutex
+	overrideUntil time.Time // zero unless overridden
+	quiet         bool      // as last checked, to log the quiet hours starting and ending
+}
+
+// State is the policy and whether it applies now.
+type State struct {
+	Quiet         bool       `json:"quiet"` // within the quiet hours, overridden or not
+	Start         string     `json:"start"`
+	End           string     `json:"end"`
+	Policy        string     `json:"policy"`
+	MaxLevel      int        `json:"max_level,omitempty"` // with policy cap
+	OverrideUntil *time.Time `json:"override_until,omitempty"`
+}
+
+// Start applies cfg in timezone (empty for the OS zone) to the fireplace pw tracks, which
+// runner drives, turning the flame down with fc when not nil.
+func Start(cfg config.QuietHours, timezone string, pw *power.Tracker, runner *actions.Runner, fc *flame.Control) (*Hours, error) {
+	h := &Hours{cfg: cfg, loc: time.Local, power: pw, runner: runner, flame: fc}
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("quiet_hours: schedule.timezone: %v", err)
+		}
+		h.loc = loc
+	}
+	start, _ := time.Parse("15:04", cfg.Start)
+	end, _ := time.Parse("15:04", cfg.End)
+	h.start, h.end = start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	fault.Go("quiet", h.watch)
+	return h, nil
+}
+
+// Override lifts the policy (on) until the current quiet hours end, or the next ones if it
+// isn't quiet now, or for d when not 0; off clears the override.
+func (h *Hours) Override(on bool, d time.Duration) {
+	now := time.Now()
+	until := time.Time{}
+	if on {
+		until = h.endAfter(now)
+		if d > 0 {
+			until = now.Add(d)
+		}
+	}
+	h.mu.Lock()
+	h.overrideUntil = until
+	h.mu.Unlock()
+}
+
+// State returns the policy and whether it applies now.
+func (h *Hours) State() State {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	st := State{Quiet: h.within(now), Start: h.cfg.Start, End: h.cfg.End, Policy: h.cfg.Policy}
+	if h.cfg.Policy == "cap" {
+		st.MaxLevel = h.cfg.MaxLevel
+	}
+	if h.overridden(now) {
+		until := h.overrideUntil
+		st.OverrideUntil = &until
+	}
+	return st
+}
+
+// Guard refuses ignition (policy refuse) or flame up past the cap (policy cap) during the
+// quiet hours, unless overridden. Flame up from the pilot counts as ignition, as it lights
+// the burner again.
+func (h *Hours) Guard(op, from string) error {
+	if op != "on" && op != "flameup" {
+		return nil
+	}
+	now := time.Now()
+	h.mu.Lock()
+	applies := h.within(now) && !h.overridden(now)
+	h.mu.Unlock()
+	if !applies || from == source {
+		return nil
+	}
+	st := h.power.State()
+	var reason string
+	switch {
+	case h.cfg.Policy == "refuse" && (op == "on" || st.Power == "pilot"):
+		reason = "ignition refused during quiet hours"
+	case h.cfg.Policy == "cap" && op == "flameup" && st.Power == "on" && st.FlameLevel != nil && *st.FlameLevel >= h.cfg.MaxLevel:
+		reason = "flame capped at level " + strconv.Itoa(h.cfg.MaxLevel) + " during quiet hours"
+	default:
+		return nil
+	}
+	logging.Event(logging.Notice, "command blocked by quiet hours", "op", op, "source", from, "policy", h.cfg.Policy)
+	events.Publish(events.Command{Op: "quiet_hours", Result: "blocked", Source: from, Params: map[string]string{"op": op}})
+	return fmt.Errorf("%w: %s", fireplace.ErrLockout, reason)
+}
+
+// within reports whether now is within the quiet hours.
+func (h *Hours) within(now time.Time) bool {
+	now = now.In(h.loc)
+	m := now.Hour()*60 + now.Minute()
+	if h.start < h.end {
+		return m >= h.start && m < h.end
+	}
+	return m >= h.start || m < h.end
+}
+
+// overridden reports whether an override is in force, clearing one that has run out;
+// callers must hold mu.
+func (h *Hours) overridden(now time.Time) bool {
+	if !h.overrideUntil.IsZero() && !now.Before(h.overrideUntil) {
+		h.overrideUntil = time.Time{}
+	}
+	return !h.overrideUntil.IsZero()
+}
+
+// endAfter returns when the quiet hours next end after now: the end of the current ones,
+// or of the next ones if it isn't quiet now.
+func (h *Hours) endAfter(now time.Time) time.Time {
+	now = now.In(h.loc)
+	end := time.Date(now.Year(), now.Month(), now.Day(), h.end/60, h.end%60, 0, 0, h.loc)
+	if !end.After(now) {
+		end = time.Date(now.Year(), now.Month(), now.Day()+1, h.end/60, h.end%60, 0, 0, h.loc)
+	}
+	return end
+}
+
+func (h *Hours) watch() {
+	for {
+		h.check(time.Now())
+		time.Sleep(checkEvery)
+	}
+}
+
+// check logs the quiet hours starting and ending and, with policy cap, turns a flame above
+// the cap down to it; a failure is retried at the next check.
+func (h *Hours) check(now time.Time) {
+	h.mu.Lock()
+	quiet := h.within(now)
+	if quiet != h.quiet {
+		logging.Event(logging.Info, "quiet hours", "active", strconv.FormatBool(quiet), "policy", h.cfg.Policy)
+		h.quiet = quiet
+	}
+	applies := quiet && !h.overridden(now)
+	h.mu.Unlock()
+	if !applies || h.cfg.Policy != "cap" {
+		return
+	}
+	st := h.power.State()
+	level := h.cfg.MaxLevel
+	if max := h.power.Levels().Max; level > max {
+		level = max
+	}
+	if st.Power != "on" || st.FlameLevel == nil || *st.FlameLevel <= level {
+		return
+	}
+	var result string
+	if h.flame != nil {
+		result = h.flame.Set(level, source)
+	} else {
+		result = h.runner.SetFlame(h.power, level, source)
+	}
+	logging.Event(logging.Notice, "quiet hours: turning the flame down", "from", strconv.Itoa(*st.FlameLevel),
+		"to", strconv.Itoa(level), "result", result)
+}
@@ -0,0 +1,165 @@
+// Package ramp moves the flame to a level gradually, a level at a time spread over a period,
+// for a gentle warm-up in the morning or a wind-down in the evening. A ramp runs in the
+// background until it reaches its level, and stops early when cancelled, when one of its
+// steps fails, or when another source changes the fire.
+package ramp
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// source is what the ramp's steps are recorded as.
+const source = "ramp"
+
+// ErrUnlit is returned by Start when the fire isn't lit at a known level.
+var ErrUnlit = errors.New("ramp: the fire isn't lit at a known level")
+
+// Ramper runs one ramp at a time.
+type Ramper struct {
+	power  *power.Tracker
+	runner *actions.Runner
+	flame  *flame.Control // nil unless the driver sets the flame with one pulse
+
+	mu  sync.Mutex
+	job *job // nil unless ramping
+}
+
+// job is a ramp in progress.
+type job struct {
+	state State
+	stop  chan struct{}
+}
+
+// State is the ramp in progress.
+type State struct {
+	From    int       `json:"from"`
+	To      int       `json:"to"`
+	Level   int       `json:"level"` // reached so far
+	Source  string    `json:"source"`
+	Started time.Time `json:"started"`
+	Ends    time.Time `json:"ends"`
+}
+
+// New returns a Ramper for the fireplace pw tracks, which runner drives, setting the flame
+// with fc when not nil.
+func New(pw *power.Tracker, runner *actions.Runner, fc *flame.Control) *Ramper {
+	r := &Ramper{power: pw, runner: runner, flame: fc}
+	ch, _ := events.Subscribe()
+	fault.Go("ramp", func() { r.watch(ch) })
+	return r
+}
+
+// Start ramps the flame from its level to level to, from 1 to the fireplace's levels, over
+// d on behalf of from, in place of any ramp already running.
+func (r *Ramper) Start(to int, d time.Duration, from string) error {
+	st := r.power.State()
+	if st.Power != "on" || st.FlameLevel == nil {
+		return ErrUnlit
+	}
+	now := time.Now()
+	j := &job{state: State{From: *st.FlameLevel, To: to, Level: *st.FlameLevel, Source: from, Started: now,
+		Ends: now.Add(d)}, stop: make(chan struct{})}
+	r.mu.Lock()
+	if r.job != nil {
+		r.stop("replaced")
+	}
+	r.job = j
+	r.mu.Unlock()
+	logging.Event(logging.Info, "ramp started", "from", strconv.Itoa(j.state.From), "to", strconv.Itoa(to),
+		"over", d.String(), "source", from)
+	fault.Go("ramp", func() { r.run(j, d) })
+	return nil
+}
+
+// Stop ends the ramp in progress, if any, for reason, and reports whether there was one.
+func (r *Ramper) Stop(reason string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.job == nil {
+		return false
+	}
+	r.stop(reason)
+	return true
+}
+
+// stop ends the ramp in progress; callers must hold mu.
+func (r *Ramper) stop(reason string) {
+	close(r.job.stop)
+	logging.Event(logging.Info, "ramp stopped", "level", strconv.Itoa(r.job.state.Level), "reason", reason)
+	r.job = nil
+}
+
+// State returns the ramp in progress, or nil.
+func (r *Ramper) State() *State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.job == nil {
+		return nil
+	}
+	st := r.job.state
+	return &st
+}
+
+// run steps the flame a level at a time, evenly over d.
+func (r *Ramper) run(j *job, d time.Duration) {
+	step, n := 1, j.state.To-j.state.From
+	if n < 0 {
+		step, n = -1, -n
+	}
+	for i := 1; i <= n; i++ {
+		t := time.NewTimer(d / time.Duration(n))
+		select {
+		case <-j.stop:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		level := j.state.From + i*step
+		var result string
+		if r.flame != nil {
+			result = r.flame.Set(level, source)
+		} else {
+			result = r.runner.SetFlame(r.power, level, source)
+		}
+		r.mu.Lock()
+		if r.job != j {
+			r.mu.Unlock()
+			return
+		}
+		if result != "ok" {
+			r.stop("step " + result)
+			r.mu.Unlock()
+			return
+		}
+		j.state.Level = level
+		r.mu.Unlock()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.job == j {
+		r.stop("done")
+	}
+}
+
+// watch stops the ramp when another source changes the fire.
+func (r *Ramper) watch(ch <-chan events.Command) {
+	for c := range ch {
+		if c.Source == source || c.Result != "ok" {
+			continue
+		}
+		switch c.Op {
+		case "on", "off", "pilot", "flameup", "flamedown", "setflame", "calibrate":
+			r.Stop(c.Op + " from " + c.Source)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/spi"
+)
+
+// SX1276/77/78/79 registers and values used in LoRa mode.
+const (
+	regFifo          = 0x00
+	regOpMode        = 0x01
+	regFrfMsb        = 0x06
+	regPaConfig      = 0x09
+	regFifoAddrPtr   = 0x0D
+	regFifoTxBase    = 0x0E
+	regFifoRxBase    = 0x0F
+	regFifoRxCurrent = 0x10
+	regIrqFlags      = 0x12
+	regRxNbBytes     = 0x13
+	regPktRssi       = 0x1A
+	regModemConfig1  = 0x1D
+	regModemConfig2  = 0x1E
+	regPreambleMsb   = 0x20
+	regPreambleLsb   = 0x21
+	regPayloadLength = 0x22
+	regModemConfig3  = 0x26
+	regSyncWord      = 0x39
+	regVersion       = 0x42
+
+	modeLongRange  = 0x80
+	modeSleep      = 0x00
+	modeStandby    = 0x01
+	modeTx         = 0x03
+	modeRxContinue = 0x05
+
+	irqRxDone     = 0x40
+	irqCRCError   = 0x20
+	irqTxDone     = 0x08
+	loraPollEvery = 20 * time.Millisecond
+)
+
+// loraBandwidths maps signal bandwidths in Hz to RegModemConfig1 codes.
+var loraBandwidths = map[int]byte{
+	7800: 0, 10400: 1, 15600: 2, 20800: 3, 31250: 4, 41700: 5, 62500: 6, 125000: 7, 250000: 8, 500000: 9,
+}
+
+// sx127x is a Semtech SX127x LoRa transceiver (e.g. RFM95/96, Ra-01) on spidev, polled for
+// received packets rather than wired to an interrupt line.
+type sx127x struct {
+	dev *spi.Dev
+}
+
+func (r *sx127x) read(reg byte) (byte, error) {
+	rx, err := r.dev.Transfer([]byte{reg & 0x7F, 0})
+	if err != nil {
+		return 0, err
+	}
+	return rx[1], nil
+}
+
+func (r *sx127x) write(reg byte, values ...byte) error {
+	_, err := r.dev.Transfer(append([]byte{reg | 0x80}, values...))
+	return err
+}
+
+func (r *sx127x) setMode(mode byte) error {
+	return r.write(regOpMode, modeLongRange|mode)
+}
+
+func newSX127x(cfg config.LoRa) (*sx127x, error) {
+	bw, ok := loraBandwidths[cfg.Bandwidth]
+	if !ok {
+		return nil, fmt.Errorf("lora: unsupported bandwidth %d", cfg.Bandwidth)
+	}
+	if cfg.SpreadingFactor < 6 || cfg.SpreadingFactor > 12 {
+		return nil, fmt.Errorf("lora: spreading factor must be 6-12")
+	}
+	if cfg.CodingRate < 5 || cfg.CodingRate > 8 {
+		return nil, fmt.Errorf("lora: coding rate must be 5-8 (4/5 to 4/8)")
+	}
+	dev, err := spi.Open(cfg.Device, 1000000)
+	if err != nil {
+		return nil, err
+	}
+	r := &sx127x{dev: dev}
+	if v, err := r.read(regVersion); err != nil || v != 0x12 {
+		dev.Close()
+		if err == nil {
+			err = fmt.Errorf("unexpected version %#x, is an SX127x connected?", v)
+		}
+		return nil, fmt.Errorf("lora: %s: %v", cfg.Device, err)
+	}
+	// LoRa mode can only be selected while asleep
+	if err := r.write(regOpMode, modeSleep); err != nil {
+		return nil, err
+	}
+	if err := r.setMode(modeSleep); err != nil {
+		return nil, err
+	}
+	frf := uint64(cfg.Frequency) << 19 / 32000000
+	lowDataRate := byte(0)
+	if symbol := float64(int(1)<<uint(cfg.SpreadingFactor)) / float64(cfg.Bandwidth); symbol > 0.016 {
+		lowDataRate = 0x08
+	}
+	power := cfg.TxPower
+	if power < 2 {
+		power = 2
+	}
+	if power > 17 {
+		power = 17
+	}
+	for _, w := range [][]byte{
+		{regFrfMsb, byte(frf >> 16), byte(frf >> 8), byte(frf)},
+		{regPaConfig, 0x80 | byte(power-2)}, // PA_BOOST pin, as on RFM95 boards
+		{regFifoTxBase, 0},
+		{regFifoRxBase, 0},
+		{regModemConfig1, bw<<4 | byte(cfg.CodingRate-4)<<1},
+		{regModemConfig2, byte(cfg.SpreadingFactor)<<4 | 0x04}, // payload CRC on
+		{regModemConfig3, lowDataRate | 0x04},                  // AGC on
+		{regPreambleMsb, 0, 8},
+		{regSyncWord, byte(cfg.SyncWord)},
+	} {
+		if err := r.write(w[0], w[1:]...); err != nil {
+			return nil, fmt.Errorf("lora: %v", err)
+		}
+	}
+	return r, r.setMode(modeRxContinue)
+}
+
+// receive waits for a packet with a valid CRC and returns it with its RSSI.
+func (r *sx127x) receive() ([]byte, int, error) {
+	for {
+		flags, err := r.read(regIrqFlags)
+		if err != nil {
+			return nil, 0, err
+		}
+		if flags&irqRxDone == 0 {
+			time.Sleep(loraPollEvery)
+			continue
+		}
+		if err := r.write(regIrqFlags, 0xFF); err != nil {
+			return nil, 0, err
+		}
+		if flags&irqCRCError != 0 {
+			continue
+		}
+		n, err := r.read(regRxNbBytes)
+		if err != nil {
+			return nil, 0, err
+		}
+		addr, err := r.read(regFifoRxCurrent)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := r.write(regFifoAddrPtr, addr); err != nil {
+			return nil, 0, err
+		}
+		rx, err := r.dev.Transfer(append([]byte{regFifo}, make([]byte, n)...))
+		if err != nil {
+			return nil, 0, err
+		}
+		rssi, err := r.read(regPktRssi)
+		if err != nil {
+			return nil, 0, err
+		}
+		return rx[1:], int(rssi) - 157, nil
+	}
+}
+
+// transmit sends one packet and returns to continuous receive.
+func (r *sx127x) transmit(pkt []byte) error {
+	if err := r.setMode(modeStandby); err != nil {
+		return err
+	}
+	for _, w := range [][]byte{
+		{regFifoAddrPtr, 0},
+		append([]byte{regFifo}, pkt...),
+		{regPayloadLength, byte(len(pkt))},
+	} {
+		if err := r.write(w[0], w[1:]...); err != nil {
+			return err
+		}
+	}
+	if err := r.setMode(modeTx); err != nil {
+		return err
+	}
+	defer r.setMode(modeRxContinue)
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(loraPollEvery / 4) {
+		flags, err := r.read(regIrqFlags)
+		if err != nil {
+			return err
+		}
+		if flags&irqTxDone != 0 {
+			return r.write(regIrqFlags, irqTxDone)
+		}
+	}
+	return errors.New("lora: transmit timed out")
+}
+
+func (s *Server) listenLoRa(cfg config.LoRa) error {
+	radio, err := newSX127x(cfg)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			pkt, rssi, err := radio.receive()
+			if err != nil {
+				logging.Logf(logging.Err, "remotes: lora: %v", err)
+				return
+			}
+			// the radio is half duplex, so packets are handled one at a time
+			if ack := s.handle(pkt, "lora", fmt.Sprintf("rssi %d dBm", rssi)); ack != nil {
+				if err := radio.transmit(ack); err != nil {
+					logging.Logf(logging.Warning, "remotes: lora ack: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
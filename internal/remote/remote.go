@@ -0,0 +1,220 @@
+// Package remote accepts single authenticated packets from registered remotes: battery
+// ESP32/ESP8266 buttons over UDP, and long-range remotes or wall switches over LoRa. A
+// remote wakes, sends one packet, waits briefly for the ACK and goes back to sleep.
+//
+// A command packet is 24 bytes on every transport:
+//
+//	0      version (1)
+//	1-2    remote id, big-endian
+//	3-6    counter, big-endian; must increase with every new command
+//	7      command: 1 on, 2 off, 3 flame up, 4 flame down, 5 light toggle
+//	8-23   first 16 bytes of HMAC-SHA256(key, bytes 0-7)
+//
+// The ACK echoes bytes 0-6, replaces the command with a status (0 ok, 1 busy, 2 lockout,
+// 3 error, 4 unsupported command) and is authenticated the same way. It is sent once the
+// relay sequence has finished, so within about two seconds.
+//
+// A packet whose counter is not above the last accepted one is dropped as a replay, except
+// that a repeat of the last accepted packet (a retry after a lost ACK) gets the same ACK
+// again without repeating the command. Counters are persisted to state_file so that old
+// packets can't be replayed after a restart either.
+package remote
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+const (
+	version   = 1
+	packetLen = 24
+	macOffset = 8
+	macLen    = 16
+	cmdOffset = 7
+)
+
+// ACK statuses
+const (
+	statusOK = iota
+	statusBusy
+	statusLockout
+	statusError
+	statusUnsupported
+)
+
+type device struct {
+	name    string
+	key     []byte
+	last    uint32 // counter of the last accepted command
+	lastAck []byte
+}
+
+// Server authenticates packets from the registered remotes and runs their commands,
+// whichever transport they arrive on.
+type Server struct {
+	actions   *actions.Runner
+	stateFile string
+
+	mu      sync.Mutex
+	devices map[uint16]*device
+	saveMu  sync.Mutex // serialises writes of stateFile
+}
+
+// Start registers the configured remotes and starts the configured transports.
+func Start(cfg config.Remotes, runner *actions.Runner) error {
+	if len(cfg.Devices) == 0 {
+		return nil
+	}
+	s := &Server{actions: runner, stateFile: cfg.StateFile, devices: map[uint16]*device{}}
+	for _, d := range cfg.Devices {
+		key, err := hex.DecodeString(d.Key)
+		if err != nil || len(key) < 16 {
+			return fmt.Errorf("remote %s: key must be at least 16 bytes of hex", d.Name)
+		}
+		s.devices[d.ID] = &device{name: d.Name, key: key}
+	}
+	if s.stateFile == "" {
+		logging.Logf(logging.Warning, "remotes: no state_file, counters restart from zero and old packets could be replayed after a restart")
+	} else if err := s.loadCounters(); err != nil {
+		return err
+	}
+	if cfg.UDPAddress != "" {
+		if err := s.listenUDP(cfg.UDPAddress); err != nil {
+			return err
+		}
+	}
+	if cfg.LoRa != nil {
+		if err := s.listenLoRa(*cfg.LoRa); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle authenticates a packet received over source and runs its command, returning the
+// ACK or nil to stay silent.
+func (s *Server) handle(pkt []byte, source, from string) []byte {
+	if len(pkt) != packetLen || pkt[0] != version {
+		return nil
+	}
+	id := binary.BigEndian.Uint16(pkt[1:])
+	counter := binary.BigEndian.Uint32(pkt[3:])
+	s.mu.Lock()
+	d := s.devices[id]
+	s.mu.Unlock()
+	if d == nil {
+		logging.Event(logging.Warning, "remote: unknown id", "id", fmt.Sprint(id), "source", source, "from", from)
+		return nil
+	}
+	if !hmac.Equal(pkt[macOffset:], sign(d.key, pkt[:macOffset])) {
+		logging.Event(logging.Warning, "remote: bad signature", "remote", d.name, "source", source, "from", from)
+		return nil
+	}
+	s.mu.Lock()
+	switch {
+	case counter == d.last && d.lastAck != nil:
+		ack := d.lastAck
+		s.mu.Unlock()
+		return ack
+	case counter <= d.last:
+		s.mu.Unlock()
+		logging.Event(logging.Warning, "remote: replayed counter", "remote", d.name, "source", source, "from", from)
+		return nil
+	}
+	d.last, d.lastAck = counter, nil
+	s.mu.Unlock()
+	if err := s.saveCounters(); err != nil {
+		logging.Logf(logging.Err, "remotes: saving counters: %v", err)
+	}
+
+	status := s.run(pkt[cmdOffset], source)
+	ack := make([]byte, packetLen)
+	copy(ack, pkt[:cmdOffset])
+	ack[cmdOffset] = status
+	copy(ack[macOffset:], sign(d.key, ack[:macOffset]))
+	s.mu.Lock()
+	if d.last == counter {
+		d.lastAck = ack
+	}
+	s.mu.Unlock()
+	return ack
+}
+
+// commands maps packet command bytes to actions.
+var commands = map[byte]string{1: "on", 2: "off", 3: "flameup", 4: "flamedown", 5: "light_toggle"}
+
+func (s *Server) run(cmd byte, source string) byte {
+	action, ok := commands[cmd]
+	if !ok {
+		return statusUnsupported
+	}
+	switch s.actions.Run(action, source) {
+	case "ok":
+		return statusOK
+	case "busy":
+		return statusBusy
+	case "lockout":
+		return statusLockout
+	case "disabled":
+		return statusUnsupported
+	}
+	return statusError
+}
+
+func sign(key, msg []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(msg)
+	return m.Sum(nil)[:macLen]
+}
+
+func (s *Server) loadCounters() error {
+	data, err := ioutil.ReadFile(s.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	counters := map[uint16]uint32{}
+	if err := json.Unmarshal(data, &counters); err != nil {
+		return fmt.Errorf("remotes: %s: %v", s.stateFile, err)
+	}
+	for id, c := range counters {
+		if d := s.devices[id]; d != nil {
+			d.last = c
+		}
+	}
+	return nil
+}
+
+// saveCounters writes the last accepted counters, replacing the file atomically.
+func (s *Server) saveCounters() error {
+	if s.stateFile == "" {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	counters := map[uint16]uint32{}
+	for id, d := range s.devices {
+		counters[id] = d.last
+	}
+	s.mu.Unlock()
+	data, err := json.Marshal(counters)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(s.stateFile, data, 0600)
+}
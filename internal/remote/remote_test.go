@@ -0,0 +1,131 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/fireplace"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+// countingFire is a simulated fireplace that counts its ignitions.
+type countingFire struct {
+	fireplace.Simulated
+	ons int
+}
+
+func (f *countingFire) On() error {
+	f.ons++
+	return f.Simulated.On()
+}
+
+func newServer(stateFile string) (*Server, *countingFire) {
+	fire := &countingFire{}
+	s := &Server{actions: &actions.Runner{Fire: fire}, stateFile: stateFile,
+		devices: map[uint16]*device{7: {name: "hall", key: testKey}}}
+	return s, fire
+}
+
+// packet returns a signed command packet from remote id.
+func packet(key []byte, id uint16, counter uint32, cmd byte) []byte {
+	pkt := make([]byte, packetLen)
+	pkt[0] = version
+	binary.BigEndian.PutUint16(pkt[1:], id)
+	binary.BigEndian.PutUint32(pkt[3:], counter)
+	pkt[cmdOffset] = cmd
+	copy(pkt[macOffset:], sign(key, pkt[:macOffset]))
+	return pkt
+}
+
+func TestHandle(t *testing.T) {
+	s, fire := newServer("")
+	badMAC := packet(testKey, 7, 6, 1)
+	badMAC[packetLen-1] ^= 1
+	short := packet(testKey, 7, 6, 1)[:packetLen-1]
+	badVersion := packet(testKey, 7, 6, 1)
+	badVersion[0] = version + 1
+	tests := []struct {
+		name    string
+		pkt     []byte
+		ack     bool // whether an ACK is sent
+		repeat  bool // whether it is the ACK sent before
+		wantOns int  // ignitions so far
+	}{
+		{"first command", packet(testKey, 7, 5, 1), true, false, 1},
+		{"retry after a lost ACK", packet(testKey, 7, 5, 1), true, true, 1},
+		{"replayed lower counter", packet(testKey, 7, 4, 1), false, false, 1},
+		{"bad MAC", badMAC, false, false, 1},
+		{"wrong key", packet([]byte("fedcba9876543210"), 7, 6, 1), false, false, 1},
+		{"unknown remote", packet(testKey, 8, 6, 1), false, false, 1},
+		{"short packet", short, false, false, 1},
+		{"other version", badVersion, false, false, 1},
+		{"next command", packet(testKey, 7, 6, 1), true, false, 2},
+		{"older retry", packet(testKey, 7, 5, 1), false, false, 2},
+	}
+	var lastAck []byte
+	for _, tt := range tests {
+		ack := s.handle(tt.pkt, "udp", "test")
+		if (ack != nil) != tt.ack {
+			t.Fatalf("%s: got ACK %x, want one: %v", tt.name, ack, tt.ack)
+		}
+		if fire.ons != tt.wantOns {
+			t.Fatalf("%s: %d ignitions, want %d", tt.name, fire.ons, tt.wantOns)
+		}
+		if ack == nil {
+			continue
+		}
+		if !bytes.Equal(ack[:cmdOffset], tt.pkt[:cmdOffset]) || ack[cmdOffset] != statusOK ||
+			!bytes.Equal(ack[macOffset:], sign(testKey, ack[:macOffset])) {
+			t.Errorf("%s: bad ACK %x", tt.name, ack)
+		}
+		if tt.repeat && !bytes.Equal(ack, lastAck) {
+			t.Errorf("the retry got ACK %x, not the first's %x", ack, lastAck)
+		}
+		lastAck = ack
+	}
+}
+
+func TestHandleUnsupported(t *testing.T) {
+	s, _ := newServer("")
+	ack := s.handle(packet(testKey, 7, 1, 99), "udp", "test")
+	if ack == nil || ack[cmdOffset] != statusUnsupported {
+		t.Errorf("got ACK %x, want status %d", ack, statusUnsupported)
+	}
+}
+
+func TestCountersSurviveRestart(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "remotes.json")
+	s, _ := newServer(state)
+	if s.handle(packet(testKey, 7, 9, 1), "udp", "test") == nil {
+		t.Fatal("no ACK")
+	}
+
+	restarted, fire := newServer(state)
+	if err := restarted.loadCounters(); err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.devices[7].last; got != 9 {
+		t.Errorf("loaded counter %d, want 9", got)
+	}
+	// the ACK isn't kept, so a retry of the last packet is dropped as a replay
+	if ack := restarted.handle(packet(testKey, 7, 9, 1), "udp", "test"); ack != nil || fire.ons != 0 {
+		t.Errorf("a packet from before the restart was accepted: ACK %x", ack)
+	}
+	if restarted.handle(packet(testKey, 7, 10, 1), "udp", "test") == nil || fire.ons != 1 {
+		t.Error("the next counter was refused")
+	}
+}
+
+func TestLoadCountersWithoutFile(t *testing.T) {
+	s, _ := newServer(filepath.Join(t.TempDir(), "remotes.json"))
+	if err := s.loadCounters(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.devices[7].last; got != 0 {
+		t.Errorf("counter %d, want 0", got)
+	}
+}
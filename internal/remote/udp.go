@@ -0,0 +1,47 @@
+package remote
+
+import (
+	"net"
+
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// maxPendingUDP limits the UDP packets being handled at once.
+const maxPendingUDP = 4
+
+func (s *Server) listenUDP(address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	fault.Go("udp", func() { s.serveUDP(conn) })
+	return nil
+}
+
+func (s *Server) serveUDP(conn net.PacketConn) {
+	sem := make(chan struct{}, maxPendingUDP)
+	buf := make([]byte, 64)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			logging.Logf(logging.Err, "remotes: udp: %v", err)
+			return
+		}
+		if n != packetLen {
+			continue
+		}
+		pkt := append([]byte(nil), buf[:n]...)
+		select {
+		case sem <- struct{}{}:
+		default:
+			continue // a flood of packets; real remotes retry
+		}
+		go func() {
+			defer func() { <-sem }()
+			if ack := s.handle(pkt, "udp", addr.String()); ack != nil {
+				conn.WriteTo(ack, addr)
+			}
+		}()
+	}
+}
@@ -0,0 +1,186 @@
+// Package resync puts the tracked flame level back in step with the valve. The valve has no
+// feedback, so the level GoFire believes the flame is at drifts from the real one as flame
+// steps are cut short or the fire is turned by hand; a resync holds the flame down contact
+// for the whole travel time, which always takes the flame to its lowest level, and the level
+// is then known again. That takes as long as the travel time (12s or so), so a resync runs in
+// the background, one at a time, and can be run after every ignition as well as on request.
+package resync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/flame"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/pkg/gv60"
+)
+
+// ignition is the source of a resync run after ignition.
+const ignition = "ignition"
+
+// busyRetries and busyWait bound how long a resync waits for the relays, as right after the
+// ignition it follows, before it is given up. A resync after ignition waits busyWait first.
+const (
+	busyRetries = 5
+	busyWait    = 3 * time.Second
+)
+
+var (
+	// ErrUnlit is returned by Start when the fire isn't lit.
+	ErrUnlit = errors.New("resync: the fire isn't lit")
+	// ErrRunning is returned by Start while a resync is running.
+	ErrRunning = errors.New("resync: already running")
+)
+
+// Resyncer runs one resync at a time.
+type Resyncer struct {
+	power  *power.Tracker
+	runner *actions.Runner
+	flame  *flame.Control // nil unless the driver holds a flame contact for a given time
+
+	mu   sync.Mutex
+	job  *job   // nil unless resyncing
+	last *State // the last resync to finish, nil before one has
+}
+
+// job is a resync in progress.
+type job struct {
+	state  State
+	cancel context.CancelFunc
+}
+
+// State is a resync, running or finished.
+type State struct {
+	Running  bool       `json:"running"`
+	Source   string     `json:"source"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Result   string     `json:"result,omitempty"` // ok, cancelled, busy, ... once finished
+}
+
+// New returns a Resyncer for the fireplace pw tracks, which runner drives, holding the flame
+// down contact with fc when not nil and otherwise sending flame down commands. With afterOn,
+// the fire is resynced after every ignition.
+func New(pw *power.Tracker, runner *actions.Runner, fc *flame.Control, afterOn bool) *Resyncer {
+	r := &Resyncer{power: pw, runner: runner, flame: fc}
+	if afterOn {
+		ch, _ := events.Subscribe()
+		fault.Go("resync", func() { r.watch(ch) })
+	}
+	return r
+}
+
+// Start resyncs the flame in the background on behalf of from. With dryRun the resync is a
+// dry run too (see gv60.DryRun).
+func (r *Resyncer) Start(from string, dryRun bool) error {
+	if r.power.State().Power != "on" {
+		return ErrUnlit
+	}
+	return r.start(from, dryRun, 0)
+}
+
+// start starts a resync after delay; the fire is taken to be lit.
+func (r *Resyncer) start(from string, dryRun bool, delay time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.job != nil {
+		return ErrRunning
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if dryRun {
+		ctx = gv60.DryRun(ctx)
+	}
+	j := &job{state: State{Running: true, Source: from, Started: time.Now()}, cancel: cancel}
+	r.job = j
+	logging.Event(logging.Info, "resync started", "source", from)
+	fault.Go("resync", func() { r.run(ctx, j, delay) })
+	return nil
+}
+
+// Stop cuts the resync short, if one is running, and reports whether one was. The flame is
+// left wherever it got to, at a level that is still unknown.
+func (r *Resyncer) Stop() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.job == nil {
+		return false
+	}
+	r.job.cancel()
+	return true
+}
+
+// State returns the resync running, or else the last to finish; nil before there has been
+// one.
+func (r *Resyncer) State() *State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.job != nil {
+		st := r.job.state
+		return &st
+	}
+	if r.last == nil {
+		return nil
+	}
+	st := *r.last
+	return &st
+}
+
+// run drives the flame right down after delay, retrying while the relays are busy.
+func (r *Resyncer) run(ctx context.Context, j *job, delay time.Duration) {
+	result := "cancelled"
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+		result = r.resync(ctx, j.state.Source)
+	}
+	for attempt := 1; result == "busy" && attempt < busyRetries && ctx.Err() == nil; attempt++ {
+		select {
+		case <-ctx.Done():
+			continue
+		case <-time.After(busyWait):
+		}
+		result = r.resync(ctx, j.state.Source)
+	}
+	if result == "abandoned" || ctx.Err() != nil {
+		result = "cancelled"
+	}
+	r.mu.Lock()
+	j.cancel()
+	now := time.Now()
+	st := j.state
+	st.Running, st.Finished, st.Result = false, &now, result
+	r.job, r.last = nil, &st
+	r.mu.Unlock()
+	sev := logging.Notice
+	if result != "ok" {
+		sev = logging.Warning
+	}
+	logging.Event(sev, "resync finished", "result", result, "source", st.Source)
+}
+
+// resync drives the flame right down once and returns the result.
+func (r *Resyncer) resync(ctx context.Context, source string) string {
+	if r.flame != nil {
+		return r.flame.ResyncContext(ctx, source)
+	}
+	return r.runner.FlameBottomContext(ctx, r.power, source)
+}
+
+// watch resyncs the flame after every ignition, once the relays are likely free again and
+// the power tracker has seen the fire lit.
+func (r *Resyncer) watch(ch <-chan events.Command) {
+	for c := range ch {
+		if c.Op != r.runner.Op("on") || c.Result != "ok" {
+			continue
+		}
+		if err := r.start(ignition, c.Params["dry_run"] == "true", busyWait); err != nil {
+			logging.Logf(logging.Warning, "resync after ignition: %v", err)
+		}
+	}
+}
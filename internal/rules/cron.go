@@ -0,0 +1,156 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed five-field cron expression: minute, hour, day of month, month and
+// day of week, each the set of values it matches.
+type cronSpec struct {
+	minute, hour, dom, month, dow []bool
+	// anyDOM and anyDOW record a "*" day field: as in cron, when both day fields are
+	// restricted a day matching either runs.
+	anyDOM, anyDOW bool
+}
+
+var cronNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// parseCron parses an expression such as "0 18 * * mon-fri" or "30 22 * * *". Each field
+// is "*", a value, a range a-b, or a comma list of those, any with a /step; months and
+// days may be given by name, and day of week 7 is Sunday too.
+func parseCron(s string) (cronSpec, error) {
+	f := strings.Fields(s)
+	if len(f) != 5 {
+		return cronSpec{}, fmt.Errorf("%q is not five fields (minute hour day month weekday)", s)
+	}
+	var c cronSpec
+	var err error
+	if c.minute, err = cronField(f[0], 0, 59); err != nil {
+		return c, fmt.Errorf("minute: %v", err)
+	}
+	if c.hour, err = cronField(f[1], 0, 23); err != nil {
+		return c, fmt.Errorf("hour: %v", err)
+	}
+	if c.dom, err = cronField(f[2], 1, 31); err != nil {
+		return c, fmt.Errorf("day: %v", err)
+	}
+	if c.month, err = cronField(f[3], 1, 12); err != nil {
+		return c, fmt.Errorf("month: %v", err)
+	}
+	if c.dow, err = cronField(f[4], 0, 7); err != nil {
+		return c, fmt.Errorf("weekday: %v", err)
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.anyDOM = f[2] == "*"
+	c.anyDOW = f[4] == "*"
+	return c, nil
+}
+
+// cronField returns the values from min to max that field matches, indexed by value.
+func cronField(field string, min, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			step, part = n, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			r := strings.SplitN(part, "-", 2)
+			if lo, err = cronValue(r[0], min, max); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if len(r) == 2 {
+				if hi, err = cronValue(r[1], min, max); err != nil {
+					return nil, err
+				}
+				if hi < lo {
+					return nil, fmt.Errorf("range %q runs backwards", part)
+				}
+			} else if step > 1 {
+				hi = max // "5/15" means from 5 to the end, every 15
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func cronValue(s string, min, max int) (int, error) {
+	v, ok := cronNames[strings.ToLower(s)]
+	if !ok {
+		var err error
+		if v, err = strconv.Atoi(s); err != nil {
+			return 0, fmt.Errorf("%q is not a number", s)
+		}
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d is outside %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+func (c cronSpec) onDay(t time.Time) bool {
+	if !c.month[t.Month()] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[t.Weekday()]
+	switch {
+	case c.anyDOM && c.anyDOW:
+		return true
+	case c.anyDOM:
+		return dow
+	case c.anyDOW:
+		return dom
+	}
+	return dom || dow
+}
+
+// errNeverRuns is returned for expressions no date matches, such as "0 0 31 feb *".
+var errNeverRuns = errors.New("never runs")
+
+// next returns the first minute after after that c matches, in loc. Like a time trigger,
+// a time skipped by a DST change runs after the change and a repeated one runs once.
+func (c cronSpec) next(after time.Time, loc *time.Location) (time.Time, error) {
+	local := after.In(loc)
+	// Leap days recur within eight years, so any expression that can match does so by then.
+	for d := 0; d <= 8*366; d++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+d, 0, 0, 0, 0, loc)
+		if !c.onDay(day) {
+			continue
+		}
+		for h := 0; h < 24; h++ {
+			if !c.hour[h] {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if !c.minute[m] {
+					continue
+				}
+				run := time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, loc)
+				if run.After(after) {
+					return run, nil
+				}
+			}
+		}
+	}
+	return time.Time{}, errNeverRuns
+}
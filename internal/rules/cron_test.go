@@ -0,0 +1,141 @@
+package rules
+
+import (
+	"testing"
+	"time"
+)
+
+// london has a spring-forward day on 2026-03-29, when 01:00 GMT jumps to 02:00 BST, and a
+// fall-back day on 2026-10-25, when 02:00 BST goes back to 01:00 GMT.
+func london(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip(err)
+	}
+	return loc
+}
+
+func mustTime(t *testing.T, s string) time.Time {
+	v, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestCronNext(t *testing.T) {
+	loc := london(t)
+	tests := []struct {
+		name, cron, after, want string
+	}{
+		{"daily", "30 22 * * *", "2026-01-10T12:00:00Z", "2026-01-10T22:30:00Z"},
+		{"daily, just run", "30 22 * * *", "2026-01-10T22:30:00Z", "2026-01-11T22:30:00Z"},
+
+		// a time skipped by spring forward runs once the clocks have gone forward
+		{"skipped hour", "30 1 * * *", "2026-03-29T00:00:00Z", "2026-03-29T02:30:00+01:00"},
+		{"skipped hour, steps", "*/20 1 * * *", "2026-03-29T02:10:00+01:00", "2026-03-29T02:20:00+01:00"},
+		{"after spring forward", "30 1 * * *", "2026-03-29T02:30:00+01:00", "2026-03-30T01:30:00+01:00"},
+		// a time repeated by fall back runs once, in the repeat
+		{"repeated hour", "30 1 * * *", "2026-10-25T00:00:00+01:00", "2026-10-25T01:30:00Z"},
+		{"repeated hour, run", "30 1 * * *", "2026-10-25T01:30:00Z", "2026-10-26T01:30:00Z"},
+		{"repeated hour, steps", "*/15 1 * * *", "2026-10-25T01:45:00Z", "2026-10-26T01:00:00Z"},
+		{"after fall back", "0 3 * * *", "2026-10-25T00:00:00+01:00", "2026-10-25T03:00:00Z"},
+
+		{"every 15 minutes", "*/15 * * * *", "2026-01-10T10:07:00Z", "2026-01-10T10:15:00Z"},
+		{"every 15 minutes, on the hour", "*/15 * * * *", "2026-01-10T10:45:00Z", "2026-01-10T11:00:00Z"},
+		{"every 6 hours", "0 */6 * * *", "2026-01-10T07:00:00Z", "2026-01-10T12:00:00Z"},
+		{"stepped range", "10-40/15 9 * * *", "2026-01-10T09:25:00Z", "2026-01-10T09:40:00Z"},
+		{"stepped range, past its end", "10-40/15 9 * * *", "2026-01-10T09:40:00Z", "2026-01-11T09:10:00Z"},
+		{"step from a value", "5/20 9 * * *", "2026-01-10T09:30:00Z", "2026-01-10T09:45:00Z"},
+		{"list with a step", "0 7,18-22/2 * * *", "2026-01-10T18:30:00Z", "2026-01-10T20:00:00Z"},
+
+		// 2026-01-09 and 2026-01-16 are Fridays, 2026-01-13 a Tuesday
+		{"day of week", "0 9 * * fri", "2026-01-10T00:00:00Z", "2026-01-16T09:00:00Z"},
+		{"day of month", "0 9 13 * *", "2026-01-10T00:00:00Z", "2026-01-13T09:00:00Z"},
+		{"either day, weekday first", "0 9 13 * fri", "2026-01-05T00:00:00Z", "2026-01-09T09:00:00Z"},
+		{"either day, date first", "0 9 13 * fri", "2026-01-10T00:00:00Z", "2026-01-13T09:00:00Z"},
+		{"either day, weekday again", "0 9 13 * fri", "2026-01-13T09:00:00Z", "2026-01-16T09:00:00Z"},
+		{"Sunday as 7", "0 9 * * 7", "2026-01-10T00:00:00Z", "2026-01-11T09:00:00Z"},
+		{"month names", "0 9 1 mar,sep *", "2026-01-10T00:00:00Z", "2026-03-01T09:00:00Z"},
+		{"leap day", "0 0 29 feb *", "2026-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.cron)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.next(mustTime(t, tt.after), loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := mustTime(t, tt.want); !got.Equal(want) {
+				t.Errorf("next(%s) = %s, want %s", tt.after, got.In(loc), want.In(loc))
+			}
+		})
+	}
+}
+
+func TestCronNeverRuns(t *testing.T) {
+	c, err := parseCron("0 0 31 feb *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.UTC); err != errNeverRuns {
+		t.Errorf("got %v, want %v", err, errNeverRuns)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, s := range []string{
+		"0 9 * *", "0 9 * * * *", "60 9 * * *", "0 24 * * *", "0 9 0 * *", "0 9 * 13 *", "0 9 * * 8",
+		"*/0 * * * *", "0 9-5 * * *", "0 9 * * funday", "x 9 * * *",
+	} {
+		if _, err := parseCron(s); err == nil {
+			t.Errorf("parseCron(%q) took it", s)
+		}
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	loc := london(t)
+	rules := []Rule{
+		{Name: "morning", Trigger: Trigger{Type: "time", Cron: "0 7 * * *"}},
+		{Name: "saturday", Trigger: Trigger{Type: "time", At: "06:30", Days: []string{"sat"}}},
+		{Name: "noon", Trigger: Trigger{Type: "time", Cron: "0 12 * * *"}},
+		{Name: "night", Trigger: Trigger{Type: "time", Cron: "30 1 * * *"}},
+		{Name: "disabled", Disabled: true, Trigger: Trigger{Type: "time", Cron: "0 7 * * *"}},
+		{Name: "sensor", Trigger: Trigger{Type: "sensor", Sensor: "room"}},
+	}
+	tests := []struct {
+		name, from, to string
+		want           []string
+	}{
+		// 2026-01-10 is a Saturday
+		{"morning outage", "2026-01-10T05:00:00Z", "2026-01-10T08:00:00Z", []string{"morning", "saturday"}},
+		{"due at the start", "2026-01-10T07:00:00Z", "2026-01-10T07:30:00Z", []string{"morning"}},
+		{"due at the end", "2026-01-10T06:45:00Z", "2026-01-10T07:00:00Z", nil},
+		// each rule once, however many times it fell due
+		{"days down", "2026-01-07T00:00:00Z", "2026-01-10T13:00:00Z", []string{"morning", "saturday", "noon", "night"}},
+		{"over spring forward", "2026-03-29T00:00:00Z", "2026-03-29T02:45:00+01:00", []string{"night"}},
+		{"before the skipped time", "2026-03-29T00:00:00Z", "2026-03-29T02:15:00+01:00", nil},
+		{"over fall back", "2026-10-25T00:00:00+01:00", "2026-10-25T02:00:00Z", []string{"night"}},
+		{"first pass of the repeated hour", "2026-10-25T01:00:00+01:00", "2026-10-25T01:59:00+01:00", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range catchUp(rules, mustTime(t, tt.from), mustTime(t, tt.to), loc) {
+				got = append(got, r.Name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package rules
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/automation"
+	"github.com/barrylb/go-fire/internal/clock"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/sensor"
+)
+
+// source is what rule actions are recorded as.
+const source = "rules"
+
+// checkEvery is how often time and sensor triggers are evaluated.
+const checkEvery = 5 * time.Second
+
+// ErrNotFound is returned for an unknown rule ID.
+var ErrNotFound = errors.New("rules: no such rule")
+
+// Engine evaluates the rules and keeps them saved.
+type Engine struct {
+	cfg     config.Schedule
+	file    string
+	clock   clock.Clock
+	runner  *actions.Runner
+	sensors *sensor.Registry
+	hold    *automation.Hold
+	power   *power.Tracker
+
+	mu      sync.Mutex
+	rules   map[string]*Rule
+	inRange map[string]bool      // sensor trigger state by rule ID, so a rule fires on entering its range
+	next    map[string]time.Time // next run of each time trigger by rule ID
+	loc     *time.Location
+}
+
+// Start loads the rules saved in file and begins evaluating them against clk. Time triggers
+// wait until the clock is synchronised, or cfg.TimeSyncTimeout has passed; with a simulated
+// clock they run as it is advanced instead.
+func Start(cfg config.Schedule, file string, clk clock.Clock, runner *actions.Runner, sensors *sensor.Registry, hold *automation.Hold, pw *power.Tracker) (*Engine, error) {
+	e := &Engine{cfg: cfg, file: file, clock: clk, runner: runner, sensors: sensors, hold: hold, power: pw,
+		inRange: map[string]bool{}, next: map[string]time.Time{}, loc: time.Local}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("rules: schedule.timezone: %v", err)
+		}
+		e.loc = loc
+	}
+	var err error
+	if e.rules, err = load(file); err != nil {
+		return nil, err
+	}
+	ch, _ := events.Subscribe()
+	fault.Go("rules", func() { e.watchEvents(ch) })
+	if sim, ok := clk.(*clock.Sim); ok {
+		sim.OnAdvance(e.simulate)
+	}
+	fault.Go("rules", e.loop)
+	return e, nil
+}
+
+// load reads the rules saved in file; there are none until it is written.
+func load(file string) (map[string]*Rule, error) {
+	out := map[string]*Rule{}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []*Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("rules: %s: %v", file, err)
+	}
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("rules: %s: rule %s: %v", file, r.ID, err)
+		}
+		out[r.ID] = r
+	}
+	return out, nil
+}
+
+// Reload reads the rules file again, as when it has been edited by hand, and reports
+// whether any rule changed. A rule whose trigger is unchanged keeps its next run and
+// whether its sensor is in range, so reloading doesn't set it off again.
+func (e *Engine) Reload() (bool, error) {
+	rules, err := load(e.file)
+	if err != nil {
+		return false, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	changed := len(rules) != len(e.rules)
+	for id, r := range e.rules {
+		n := rules[id]
+		if n == nil || !reflect.DeepEqual(n, r) {
+			changed = true
+		}
+		if n == nil || !reflect.DeepEqual(n.Trigger, r.Trigger) {
+			delete(e.inRange, id)
+			delete(e.next, id)
+		}
+	}
+	e.rules = rules
+	return changed, nil
+}
+
+// List returns every rule, ordered by name.
+func (e *Engine) List() []Rule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]Rule, 0, len(e.rules))
+	for _, r := range e.rules {
+		rule := *r
+		if rule.Trigger.Type == "time" && !rule.Disabled {
+			n, ok := e.next[r.ID]
+			if !ok {
+				n = nextRun(r.Trigger, e.clock.Now(), e.loc)
+			}
+			rule.NextRun = &n
+		}
+		out = append(out, rule)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Put adds a rule, or replaces the one with the same ID, and returns it with its ID.
+func (e *Engine) Put(r Rule) (Rule, error) {
+	if err := r.Validate(); err != nil {
+		return r, err
+	}
+	r.NextRun = nil
+	if r.ID == "" {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return r, err
+		}
+		r.ID = hex.EncodeToString(b)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules[r.ID] = &r
+	delete(e.inRange, r.ID)
+	delete(e.next, r.ID)
+	return r, e.save()
+}
+
+// Delete removes a rule.
+func (e *Engine) Delete(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.rules[id] == nil {
+		return ErrNotFound
+	}
+	delete(e.rules, id)
+	delete(e.inRange, id)
+	delete(e.next, id)
+	return e.save()
+}
+
+// Hook fires the webhook-triggered rule id.
+func (e *Engine) Hook(id string) error {
+	e.mu.Lock()
+	r := e.rules[id]
+	e.mu.Unlock()
+	if r == nil || r.Trigger.Type != "webhook" {
+		return ErrNotFound
+	}
+	fault.Go("rules", func() { e.fire(*r, "webhook") })
+	return nil
+}
+
+// save writes the rules, replacing the file atomically; callers must hold mu.
+func (e *Engine) save() error {
+	rules := make([]*Rule, 0, len(e.rules))
+	for _, r := range e.rules {
+		rules = append(rules, r)
+	}
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(e.file, data, 0600)
+}
+
+// loop evaluates time and sensor triggers every few seconds. Time triggers start once the
+// clock can be trusted, first catching up on any missed.
+func (e *Engine) loop() {
+	start := time.Now()
+	_, simulated := e.clock.(*clock.Sim)
+	synced := simulated
+	for now := range time.Tick(checkEvery) {
+		if simulated {
+			e.checkSensors()
+			continue
+		}
+		if !synced {
+			if !clockSynced() && time.Since(start) < e.cfg.TimeSyncTimeout {
+				e.checkSensors()
+				continue
+			}
+			synced = true
+			if !clockSynced() {
+				logging.Logf(logging.Warning, "rules: clock still not synchronised after %v, running time triggers anyway", e.cfg.TimeSyncTimeout)
+			}
+			e.catchUpMissed(start)
+		}
+		for _, r := range e.due(now) {
+			r := r
+			fault.Go("rules", func() { e.fire(r, "time") })
+		}
+		e.checkSensors()
+	}
+}
+
+// simulate runs the time triggers due at a minute the simulated clock passes through, one
+// after another, so that a fast-forward replays the program in order.
+func (e *Engine) simulate(now time.Time) {
+	for _, r := range e.due(now) {
+		e.fire(r, "time")
+	}
+}
+
+// due returns the time triggers due at now and schedules their next runs.
+func (e *Engine) due(now time.Time) []Rule {
+	var due []Rule
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, r := range e.rules {
+		if r.Disabled || r.Trigger.Type != "time" {
+			continue
+		}
+		n, ok := e.next[id]
+		if !ok {
+			n = nextRun(r.Trigger, now, e.loc)
+			e.next[id] = n
+		}
+		if !now.Before(n) {
+			due = append(due, *r)
+			e.next[id] = nextRun(r.Trigger, now, e.loc)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Name < due[j].Name })
+	return due
+}
+
+// checkSensors fires the sensor triggers whose reading has moved into their range.
+func (e *Engine) checkSensors() {
+	var due []Rule
+	e.mu.Lock()
+	for _, r := range e.rules {
+		if r.Disabled || r.Trigger.Type != "sensor" {
+			continue
+		}
+		v, ok := e.reading(r.Trigger.Sensor, r.Trigger.Role)
+		if !ok {
+			continue
+		}
+		in := inRange(v, r.Trigger.Above, r.Trigger.Below)
+		if in && !e.inRange[r.ID] {
+			due = append(due, *r)
+		}
+		e.inRange[r.ID] = in
+	}
+	e.mu.Unlock()
+	for _, r := range due {
+		r := r
+		fault.Go("rules", func() { e.fire(r, "sensor") })
+	}
+}
+
+// catchUpMissed runs, per the catch_up policy, time triggers that fell due between start
+// and now while the clock wasn't trusted. The wall clock is now right, and the monotonic
+// clock says how long ago start was.
+func (e *Engine) catchUpMissed(start time.Time) {
+	if e.cfg.CatchUp != "latest" {
+		return
+	}
+	now := time.Now()
+	from := now.Add(-time.Since(start))
+	if w := now.Add(-e.cfg.CatchUpWindow); from.Before(w) {
+		from = w
+	}
+	for _, r := range catchUp(e.List(), from, now, e.loc) {
+		logging.Event(logging.Info, "rule catching up", "rule", r.Name, "at", r.Trigger.At+r.Trigger.Cron)
+		r := r
+		fault.Go("rules", func() { e.fire(r, "catch-up") })
+	}
+}
+
+// nextRun returns the first time after after that a time trigger is due, in loc. A time
+// skipped by a DST change runs once at the same offset from midnight after the change
+// (02:30 becomes 03:30), and a time repeated by one runs only once. Cron triggers run the
+// same way.
+func nextRun(t Trigger, after time.Time, loc *time.Location) time.Time {
+	if t.Cron != "" {
+		c, _ := parseCron(t.Cron)
+		if run, err := c.next(after, loc); err == nil {
+			return run
+		}
+		return after.Add(8 * 24 * time.Hour) // unreachable after Validate
+	}
+	at, _ := parseClock(t.At)
+	local := after.In(loc)
+	for d := 0; d <= 7; d++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+d, 0, 0, 0, 0, loc)
+		if !onDay(t, day.Weekday()) {
+			continue
+		}
+		run := time.Date(day.Year(), day.Month(), day.Day(), at/60, at%60, 0, 0, loc)
+		if run.After(after) {
+			return run
+		}
+	}
+	return after.Add(8 * 24 * time.Hour) // no days listed that exist; unreachable after Validate
+}
+
+func onDay(t Trigger, wd time.Weekday) bool {
+	if len(t.Days) == 0 {
+		return true
+	}
+	for _, d := range t.Days {
+		if weekdays[strings.ToLower(d)] == wd {
+			return true
+		}
+	}
+	return false
+}
+
+// watchEvents fires event triggers. Commands run by rules
+// don't trigger rules, so rules can't set each other off in a loop.
+func (e *Engine) watchEvents(ch <-chan events.Command) {
+	for c := range ch {
+		var due []Rule
+		e.mu.Lock()
+		if c.Source != source {
+			for _, r := range e.rules {
+				t := r.Trigger
+				if !r.Disabled && t.Type == "event" && (t.Op == "" || t.Op == c.Op) && (t.Result == "" || t.Result == c.Result) {
+					due = append(due, *r)
+				}
+			}
+		}
+		e.mu.Unlock()
+		for _, r := range due {
+			r := r
+			fault.Go("rules", func() { e.fire(r, "event") })
+		}
+	}
+}
+
+func (e *Engine) reading(name, role string) (float64, bool) {
+	var r sensor.Reading
+	var ok bool
+	if role != "" {
+		r, ok = e.sensors.ForRole(role)
+	} else {
+		r, ok = e.sensors.Latest(name)
+	}
+	return r.Value, ok
+}
+
+// fire runs a triggered rule's actions if automation isn't on hold and its conditions hold.
+func (e *Engine) fire(r Rule, why string) {
+	if e.hold != nil && e.hold.Paused() {
+		logging.Event(logging.Info, "rule skipped: automation on hold", "rule", r.Name, "trigger", why)
+		return
+	}
+	for _, c := range r.Conditions {
+		if !e.holds(c) {
+			logging.Event(logging.Debug, "rule skipped: condition not met", "rule", r.Name, "condition", c.Type)
+			return
+		}
+	}
+	var results []string
+	for _, a := range r.Actions {
+		results = append(results, a+"_"+e.runner.Run(a, source))
+	}
+	logging.Event(logging.Info, "rule ran", "rule", r.Name, "trigger", why, "results", strings.Join(results, ","))
+}
+
+func (e *Engine) holds(c Condition) bool {
+	switch c.Type {
+	case "time_window":
+		now := e.clock.Now().In(e.loc)
+		m := now.Hour()*60 + now.Minute()
+		after, _ := parseClock(c.After)
+		before, _ := parseClock(c.Before)
+		if after <= before {
+			return m >= after && m < before
+		}
+		return m >= after || m < before // spans midnight
+	case "sensor":
+		v, ok := e.reading(c.Sensor, c.Role)
+		return ok && inRange(v, c.Above, c.Below)
+	case "state":
+		return e.power.State().Power == c.Power
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+// Package rules runs declarative automations: when a trigger fires and every condition
+// holds, a rule runs its actions. Rules are managed over the API and saved to a file.
+//
+// Triggers:
+//   - time: at "HH:MM" in schedule.timezone, on the listed days (mon ... sun) or every day;
+//     or, instead of at and days, when the five-field cron expression matches, e.g.
+//     "0 18 * * mon-fri" (minute hour day month weekday, also in schedule.timezone)
+//   - sensor: when a sensor's reading, by name or role, moves into the above/below range
+//   - event: when a command finishes with the given op and result (either may be empty)
+//   - webhook: when POST /rules/hook?id= is called
+//
+// Conditions:
+//   - time_window: the time in schedule.timezone is between after and before, which may
+//     span midnight
+//   - sensor: a reading is inside the above/below range; presence is a sensor condition on
+//     role "presence" (e.g. above: 0)
+//   - state: the fireplace is "on", "off" or "pilot" (as last commanded, or as startup.state
+//     found it)
+//
+// Actions are the names accepted by package actions (on, off, flameup, light_toggle, ...),
+// run in order with source "rules". Nothing runs while automation is on hold.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezones work even without the OS zoneinfo
+
+	"github.com/barrylb/go-fire/internal/actions"
+)
+
+// Rule is one automation.
+type Rule struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	Disabled   bool        `json:"disabled,omitempty"`
+	Trigger    Trigger     `json:"trigger"`
+	Conditions []Condition `json:"conditions,omitempty"`
+	Actions    []string    `json:"actions"`
+	// NextRun is when a time trigger next fires, in the schedule's timezone; reported only.
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// Trigger starts a rule; which fields apply depends on Type.
+type Trigger struct {
+	Type   string   `json:"type"`             // time, sensor, event or webhook
+	At     string   `json:"at,omitempty"`     // time
+	Days   []string `json:"days,omitempty"`   // time
+	Cron   string   `json:"cron,omitempty"`   // time, instead of at and days
+	Sensor string   `json:"sensor,omitempty"` // sensor
+	Role   string   `json:"role,omitempty"`   // sensor, instead of a name
+	Above  *float64 `json:"above,omitempty"`  // sensor
+	Below  *float64 `json:"below,omitempty"`  // sensor
+	Op     string   `json:"op,omitempty"`     // event
+	Result string   `json:"result,omitempty"` // event
+}
+
+// Condition must hold for a triggered rule to run; which fields apply depends on Type.
+type Condition struct {
+	Type   string   `json:"type"`             // time_window, sensor or state
+	After  string   `json:"after,omitempty"`  // time_window
+	Before string   `json:"before,omitempty"` // time_window
+	Sensor string   `json:"sensor,omitempty"` // sensor
+	Role   string   `json:"role,omitempty"`   // sensor, instead of a name
+	Above  *float64 `json:"above,omitempty"`  // sensor
+	Below  *float64 `json:"below,omitempty"`  // sensor
+	Power  string   `json:"power,omitempty"`  // state: on, off or pilot
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Validate checks that the rule can be run.
+func (r Rule) Validate() error {
+	t := r.Trigger
+	switch t.Type {
+	case "time":
+		if t.Cron != "" {
+			if t.At != "" || len(t.Days) > 0 {
+				return errors.New("trigger cron replaces at and days; give one or the other")
+			}
+			c, err := parseCron(t.Cron)
+			if err == nil {
+				_, err = c.next(time.Now(), time.UTC)
+			}
+			if err != nil {
+				return fmt.Errorf("trigger cron: %v", err)
+			}
+			break
+		}
+		if _, err := parseClock(t.At); err != nil {
+			return fmt.Errorf("trigger at: %v", err)
+		}
+		for _, d := range t.Days {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				return fmt.Errorf("trigger days: unknown day %q", d)
+			}
+		}
+	case "sensor":
+		if err := checkRange(t.Sensor, t.Role, t.Above, t.Below); err != nil {
+			return fmt.Errorf("trigger: %v", err)
+		}
+	case "event", "webhook":
+	default:
+		return fmt.Errorf("unknown trigger type %q", t.Type)
+	}
+	for _, c := range r.Conditions {
+		switch c.Type {
+		case "time_window":
+			if _, err := parseClock(c.After); err != nil {
+				return fmt.Errorf("condition after: %v", err)
+			}
+			if _, err := parseClock(c.Before); err != nil {
+				return fmt.Errorf("condition before: %v", err)
+			}
+		case "sensor":
+			if err := checkRange(c.Sensor, c.Role, c.Above, c.Below); err != nil {
+				return fmt.Errorf("condition: %v", err)
+			}
+		case "state":
+			if c.Power != "on" && c.Power != "off" && c.Power != "pilot" {
+				return errors.New("condition power must be on, off or pilot")
+			}
+		default:
+			return fmt.Errorf("unknown condition type %q", c.Type)
+		}
+	}
+	if len(r.Actions) == 0 {
+		return errors.New("no actions")
+	}
+	for _, a := range r.Actions {
+		if !actions.Valid(a) {
+			return fmt.Errorf("unknown action %q", a)
+		}
+	}
+	return nil
+}
+
+func checkRange(sensor, role string, above, below *float64) error {
+	if (sensor == "") == (role == "") {
+		return errors.New("give one of sensor and role")
+	}
+	if above == nil && below == nil {
+		return errors.New("give above, below or both")
+	}
+	return nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// inRange reports whether v is above and below the given bounds, where set.
+func inRange(v float64, above, below *float64) bool {
+	return (above == nil || v > *above) && (below == nil || v < *below)
+}
@@ -0,0 +1,39 @@
+package rules
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// A Pi has no real-time clock, so until NTP has set the time after boot the clock may be
+// hours or years out and time triggers must not be trusted.
+const (
+	staUnsync       = 0x0040 // kernel clock status: not synchronised
+	timeError       = 5      // adjtimex state: clock not synchronised
+	timesyncdMarker = "/run/systemd/timesync/synchronized"
+)
+
+// clockSynced reports whether the system clock has been synchronised, by systemd-timesyncd
+// or by any NTP daemon that disciplines the kernel clock.
+func clockSynced() bool {
+	if _, err := os.Stat(timesyncdMarker); err == nil {
+		return true
+	}
+	var tx unix.Timex
+	state, err := unix.Adjtimex(&tx)
+	return err == nil && state != timeError && tx.Status&staUnsync == 0
+}
+
+// catchUp returns the time triggers that fell due in [from, to), each at most once, for
+// rules missed while the clock couldn't be trusted.
+func catchUp(rules []Rule, from, to time.Time, loc *time.Location) []Rule {
+	var due []Rule
+	for _, r := range rules {
+		if !r.Disabled && r.Trigger.Type == "time" && nextRun(r.Trigger, from.Add(-time.Nanosecond), loc).Before(to) {
+			due = append(due, r)
+		}
+	}
+	return due
+}
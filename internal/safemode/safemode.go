@@ -0,0 +1,113 @@
+// Package safemode spots a crash loop, GoFire restarting again and again in quick
+// succession, and latches a safe mode in which only the off command and diagnostics are
+// served, so that a bad configuration can't keep re-igniting the fire.
+package safemode
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// Guard records starts and knows whether safe mode is latched.
+type Guard struct {
+	cfg config.SafeMode
+	// Restart, if set, is called once safe mode is cleared to bring GoFire back up normally.
+	Restart func()
+
+	active bool // safe mode is in force in this process
+
+	mu    sync.Mutex
+	saved saved
+}
+
+type saved struct {
+	Starts  []time.Time `json:"starts"`  // recent starts, within the window
+	Latched bool        `json:"latched"` // safe mode, until cleared
+}
+
+// Check records this start, unless it is an in-place upgrade, and latches safe mode if
+// there have been cfg.Restarts starts within cfg.Window. A process that stays up for the
+// window forgets the earlier starts.
+func Check(cfg config.SafeMode, upgraded bool) (*Guard, error) {
+	g := &Guard{cfg: cfg}
+	data, err := ioutil.ReadFile(cfg.File)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &g.saved); err != nil {
+			logging.Logf(logging.Warning, "safemode: %s: %v", cfg.File, err)
+		}
+	}
+	now := time.Now()
+	if !upgraded {
+		var starts []time.Time
+		for _, t := range g.saved.Starts {
+			if now.Sub(t) < cfg.Window {
+				starts = append(starts, t)
+			}
+		}
+		g.saved.Starts = append(starts, now)
+		if len(g.saved.Starts) >= cfg.Restarts && !g.saved.Latched {
+			g.saved.Latched = true
+			logging.Event(logging.Err, "crash loop: starting in safe mode",
+				"starts", strconv.Itoa(len(g.saved.Starts)), "window", cfg.Window.String())
+		}
+		if err := g.save(); err != nil {
+			return nil, err
+		}
+	}
+	g.active = g.saved.Latched
+	if g.active {
+		logging.Event(logging.Warning, "safe mode: only off and diagnostics are served until an admin clears it")
+	}
+	go func() {
+		time.Sleep(cfg.Window)
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.saved.Starts = nil
+		if err := g.save(); err != nil {
+			logging.Logf(logging.Warning, "safemode: %v", err)
+		}
+	}()
+	return g, nil
+}
+
+// Active reports whether this process is in safe mode; a nil Guard never is.
+func (g *Guard) Active() bool {
+	return g != nil && g.active
+}
+
+// Clear unlatches safe mode and forgets the recent starts, then calls Restart. Safe mode
+// stays in force in this process, which was started without schedules and integrations.
+func (g *Guard) Clear() error {
+	g.mu.Lock()
+	g.saved = saved{}
+	err := g.save()
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	logging.Event(logging.Notice, "safe mode cleared")
+	if g.Restart != nil {
+		g.Restart()
+	}
+	return nil
+}
+
+// save writes the file, replacing it atomically; callers must hold mu.
+func (g *Guard) save() error {
+	data, err := json.Marshal(g.saved)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(g.cfg.File, data, 0600)
+}
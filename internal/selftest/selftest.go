@@ -0,0 +1,178 @@
+// Package selftest is the relay self-test: it checks that the GPIO line of each valve
+// contact is still held as an output and, when asked to, clicks each relay on its own,
+// reporting contact by contact. Lines that pass and relays that click point away from the
+// Pi and the relay board when the valve doesn't answer: to the wiring or the valve.
+package selftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/pkg/gv60"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+var (
+	// ErrRunning is returned while another self-test is running.
+	ErrRunning = errors.New("selftest: a self-test is already running")
+	// ErrNotOff is returned for a click while a fire isn't known to be off.
+	ErrNotOff = errors.New("selftest: the relays are only clicked while the fire is off")
+)
+
+// Valve is a valve driven by relays, to be tested.
+type Valve struct {
+	Name  string // the fireplace; empty for the main one
+	Fire  *gv60.Controller
+	Board string         // the relay board the contacts are on; empty for the GPIO chip
+	GPIOs []int          // the lines of contacts 1, 2, 3, ..., or the board's pins
+	Power *power.Tracker // whether the fire is off
+	// Guard, when not nil, is consulted with the op selftest before a click, as the fire's
+	// commands are; an error refuses it and is returned by Run.
+	Guard func(op, source string) error
+}
+
+// Report is the outcome of a self-test.
+type Report struct {
+	OK       bool      `json:"ok"`
+	Clicked  bool      `json:"clicked"` // the relays were clicked, not just the lines checked
+	Time     time.Time `json:"time"`
+	Contacts []Contact `json:"contacts"`
+}
+
+// Contact is the outcome for one contact.
+type Contact struct {
+	Name      string `json:"name"` // contact1, ...; den.contact1, ... for fireplace den
+	GPIO      int    `json:"gpio"`
+	Board     string `json:"board,omitempty"`   // the relay board GPIO is a pin of
+	Requested bool   `json:"requested"`         // its line is held as an output
+	Clicked   bool   `json:"clicked,omitempty"` // its relay was closed and opened again
+	Error     string `json:"error,omitempty"`
+}
+
+// Tester runs self-tests of the valves on a chip.
+type Tester struct {
+	chip   *relay.Chip
+	valves []Valve
+	pulse  time.Duration
+
+	mu      sync.Mutex
+	running bool
+	last    *Report
+}
+
+// New returns a tester of valves, whose clicks close each relay for pulse.
+func New(chip *relay.Chip, pulse time.Duration, valves ...Valve) *Tester {
+	return &Tester{chip: chip, valves: valves, pulse: pulse}
+}
+
+// Run checks every contact's line and, with click, clicks each relay in turn, valve by
+// valve, on behalf of source. A click is refused with ErrNotOff unless every fire is known
+// to be off, with the error of a valve's Guard, and with gv60.ErrBusy while a contact
+// sequence is running.
+func (t *Tester) Run(ctx context.Context, click bool, source string) (Report, error) {
+	t.mu.Lock()
+	if t.running {
+		t.mu.Unlock()
+		return Report{}, ErrRunning
+	}
+	t.running = true
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.running = false
+		t.mu.Unlock()
+	}()
+	params := map[string]string{"click": strconv.FormatBool(click)}
+	if click {
+		for _, v := range t.valves {
+			if v.Power.State().Power != "off" {
+				events.RecordContext(ctx, "selftest", "notoff", source, params)
+				return Report{}, ErrNotOff
+			}
+			if v.Guard != nil {
+				if err := v.Guard("selftest", source); err != nil {
+					return Report{}, err
+				}
+			}
+		}
+	}
+	r := Report{OK: true, Clicked: click, Time: time.Now(), Contacts: []Contact{}}
+	for _, v := range t.valves {
+		prefix := ""
+		if v.Name != "" {
+			prefix = v.Name + "."
+		}
+		contacts := make([]Contact, len(v.GPIOs))
+		board, berr := t.chip.Board(v.Board)
+		for i, gpio := range v.GPIOs {
+			contacts[i] = Contact{Name: prefix + "contact" + strconv.Itoa(i+1), GPIO: gpio, Board: v.Board, Requested: true}
+			err := berr
+			if err == nil {
+				err = board.CheckLine(gpio)
+			}
+			if err != nil {
+				contacts[i].Requested, contacts[i].Error = false, err.Error()
+			}
+		}
+		if click {
+			errs, err := v.Fire.Exercise(ctx, t.pulse)
+			if err != nil {
+				events.RecordContext(ctx, "selftest", resultOf(err), source, params)
+				return Report{}, err
+			}
+			for i, err := range errs {
+				switch {
+				case err != nil && contacts[i].Error == "":
+					contacts[i].Error = fmt.Sprintf("clicking: %v", err)
+				case err == nil:
+					// a dry run clicks nothing
+					contacts[i].Clicked = !v.Fire.DryRun
+				}
+			}
+			if v.Fire.DryRun {
+				r.Clicked = false
+			}
+		}
+		for _, c := range contacts {
+			if c.Error != "" {
+				r.OK = false
+				logging.Event(logging.Err, "relay self-test failed", "contact", c.Name, "gpio", strconv.Itoa(c.GPIO), "error", c.Error)
+			}
+		}
+		r.Contacts = append(r.Contacts, contacts...)
+	}
+	result := "ok"
+	if !r.OK {
+		result = "failed"
+	}
+	events.RecordContext(ctx, "selftest", result, source, params)
+	t.mu.Lock()
+	t.last = &r
+	t.mu.Unlock()
+	return r, nil
+}
+
+// Last returns the report of the last self-test to finish, or nil before the first.
+func (t *Tester) Last() *Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// resultOf is the recorded result of a self-test that failed with err.
+func resultOf(err error) string {
+	switch err {
+	case gv60.ErrBusy:
+		return "busy"
+	case gv60.ErrCancelled:
+		return "cancelled"
+	}
+	return "error"
+}
@@ -0,0 +1,189 @@
+// Package selfupdate replaces the GoFire binary with the latest signed release and then
+// restarts through the in-place upgrade, so a Pi can be kept up to date without SSH.
+//
+// A release carries, for each platform, the binary gofire-GOOS-GOARCH (gofire-linux-arm64,
+// gofire-linux-arm, ...) and beside it gofire-GOOS-GOARCH.sig, the base64 Ed25519 signature
+// of the binary made with the key whose public half is self_update.public_key:
+//
+//	openssl pkeyutl -sign -rawin -inkey release.pem -in gofire-linux-arm64 | base64 -w0
+package selfupdate
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// maxBinary bounds a downloaded binary.
+const maxBinary = 64 << 20
+
+// ErrBadSignature is returned for a release binary not signed with the configured key.
+var ErrBadSignature = errors.New("selfupdate: bad signature")
+
+// ErrNoAsset is returned when the latest release has no binary for this platform.
+var ErrNoAsset = errors.New("selfupdate: no release binary for this platform")
+
+// Updater installs new releases.
+type Updater struct {
+	cfg     config.SelfUpdate
+	key     ed25519.PublicKey
+	version string
+	restart func()
+	client  *http.Client
+
+	mu sync.Mutex // one update at a time
+}
+
+// Release is the latest release, as reported by Latest.
+type Release struct {
+	Tag     string `json:"tag"`
+	Current string `json:"current"`
+	Newer   bool   `json:"newer"` // Tag is not the running version
+	binary  string
+	sig     string
+}
+
+// New returns an updater for the running version; restart is called once the binary has
+// been replaced. With cfg.CheckEvery set, it updates on that schedule too.
+func New(cfg config.SelfUpdate, version string, restart func()) (*Updater, error) {
+	key, err := base64.StdEncoding.DecodeString(cfg.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("self_update.public_key must be a base64 Ed25519 public key")
+	}
+	u := &Updater{cfg: cfg, key: ed25519.PublicKey(key), version: version, restart: restart,
+		client: &http.Client{Timeout: 5 * time.Minute}}
+	if cfg.CheckEvery > 0 {
+		fault.Go("selfupdate", func() {
+			for range time.Tick(cfg.CheckEvery) {
+				if _, err := u.Update(); err != nil {
+					logging.Logf(logging.Warning, "selfupdate: %v", err)
+				}
+			}
+		})
+	}
+	return u, nil
+}
+
+// asset is the name of this platform's release binary.
+func asset() string {
+	return fmt.Sprintf("gofire-%s-%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// Latest looks up the latest release.
+func (u *Updater) Latest() (Release, error) {
+	resp, err := u.client.Get("https://api.github.com/repos/" + u.cfg.Repo + "/releases/latest")
+	if err != nil {
+		return Release{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Release{}, fmt.Errorf("selfupdate: %s: %s", u.cfg.Repo, resp.Status)
+	}
+	var body struct {
+		Tag    string `json:"tag_name"`
+		Assets []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Release{}, err
+	}
+	r := Release{Tag: body.Tag, Current: u.version, Newer: body.Tag != u.version}
+	for _, a := range body.Assets {
+		switch a.Name {
+		case asset():
+			r.binary = a.URL
+		case asset() + ".sig":
+			r.sig = a.URL
+		}
+	}
+	return r, nil
+}
+
+// Update installs the latest release if it isn't the running version, and restarts into
+// it. It returns the release either way.
+func (u *Updater) Update() (Release, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	r, err := u.Latest()
+	if err != nil || !r.Newer {
+		return r, err
+	}
+	if r.binary == "" || r.sig == "" {
+		return r, ErrNoAsset
+	}
+	bin, err := u.fetch(r.binary, maxBinary)
+	if err != nil {
+		return r, err
+	}
+	sigText, err := u.fetch(r.sig, 1024)
+	if err != nil {
+		return r, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigText)))
+	if err != nil || !ed25519.Verify(u.key, bin, sig) {
+		return r, ErrBadSignature
+	}
+	if err := install(bin); err != nil {
+		return r, err
+	}
+	logging.Event(logging.Notice, "self-update installed, restarting", "from", u.version, "to", r.Tag)
+	u.restart()
+	return r, nil
+}
+
+func (u *Updater) fetch(url string, limit int64) ([]byte, error) {
+	resp, err := u.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("selfupdate: %s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("selfupdate: %s: larger than %d bytes", url, limit)
+	}
+	return data, nil
+}
+
+// install replaces the running binary with bin, keeping the old one as .old for rollback.
+func install(bin []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	old, err := ioutil.ReadFile(exe)
+	if err == nil && !bytes.Equal(old, bin) {
+		err = fileutil.WriteAtomic(exe+".old", old, 0755)
+	}
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(exe, bin, 0755)
+}
@@ -0,0 +1,159 @@
+package sensor
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/spi"
+	"golang.org/x/sys/unix"
+)
+
+// adc is an analog-to-digital converter with several single-ended input channels.
+type adc interface {
+	ReadVolts(channel int) (float64, error)
+}
+
+// mcp3008 is a 10-bit, 8 channel SPI ADC read through spidev.
+type mcp3008 struct {
+	dev  *spi.Dev
+	vref float64
+}
+
+func newMCP3008(device string, vref float64) (*mcp3008, error) {
+	dev, err := spi.Open(device, 1000000)
+	if err != nil {
+		return nil, err
+	}
+	return &mcp3008{dev: dev, vref: vref}, nil
+}
+
+func (a *mcp3008) ReadVolts(channel int) (float64, error) {
+	if channel < 0 || channel > 7 {
+		return 0, fmt.Errorf("mcp3008: invalid channel %d", channel)
+	}
+	// start bit, single-ended mode + channel, then clock out the 10 bit result
+	rx, err := a.dev.Transfer([]byte{0x01, 0x80 | byte(channel)<<4, 0x00})
+	if err != nil {
+		return 0, fmt.Errorf("mcp3008: %v", err)
+	}
+	code := int(rx[1]&0x03)<<8 | int(rx[2])
+	return float64(code) * a.vref / 1023, nil
+}
+
+// I2C_SLAVE from linux/i2c-dev.h
+const i2cSlave = 0x0703
+
+// ads1115 is a 16-bit, 4 channel I2C ADC with a programmable gain amplifier.
+type ads1115 struct {
+	mu  sync.Mutex
+	f   *os.File
+	pga uint16
+	fs  float64
+}
+
+// ads1115Gains maps the supported full-scale ranges (volts) to PGA config bits.
+var ads1115Gains = map[float64]uint16{6.144: 0, 4.096: 1, 2.048: 2, 1.024: 3, 0.512: 4, 0.256: 5}
+
+func newADS1115(device string, address int, fullScale float64) (*ads1115, error) {
+	pga, ok := ads1115Gains[fullScale]
+	if !ok {
+		return nil, fmt.Errorf("ads1115: unsupported full scale %vV", fullScale)
+	}
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), i2cSlave, uintptr(address)); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s: select address %#x: %v", device, address, errno)
+	}
+	return &ads1115{f: f, pga: pga, fs: fullScale}, nil
+}
+
+func (a *ads1115) ReadVolts(channel int) (float64, error) {
+	if channel < 0 || channel > 3 {
+		return 0, fmt.Errorf("ads1115: invalid channel %d", channel)
+	}
+	// OS=start, MUX=AINx vs GND, PGA, single-shot, 128SPS, comparator disabled
+	cfg := uint16(1)<<15 | uint16(4+channel)<<12 | a.pga<<9 | 1<<8 | 4<<5 | 3
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write([]byte{0x01, byte(cfg >> 8), byte(cfg)}); err != nil {
+		return 0, fmt.Errorf("ads1115: %v", err)
+	}
+	buf := make([]byte, 2)
+	for i := 0; ; i++ {
+		time.Sleep(8 * time.Millisecond)
+		if _, err := a.f.Read(buf); err != nil {
+			return 0, fmt.Errorf("ads1115: %v", err)
+		}
+		if buf[0]&0x80 != 0 {
+			break
+		}
+		if i == 10 {
+			return 0, fmt.Errorf("ads1115: conversion timed out")
+		}
+	}
+	if _, err := a.f.Write([]byte{0x00}); err != nil {
+		return 0, fmt.Errorf("ads1115: %v", err)
+	}
+	if _, err := a.f.Read(buf); err != nil {
+		return 0, fmt.Errorf("ads1115: %v", err)
+	}
+	raw := int16(uint16(buf[0])<<8 | uint16(buf[1]))
+	// leave the pointer on the config register for the next conversion poll
+	if _, err := a.f.Write([]byte{0x01}); err != nil {
+		return 0, fmt.Errorf("ads1115: %v", err)
+	}
+	return float64(raw) * a.fs / 32768, nil
+}
+
+// analogSensor scales one ADC channel into a sensor reading: value = volts*scale + offset.
+type analogSensor struct {
+	name    string
+	unit    string
+	adc     adc
+	channel int
+	scale   float64
+	offset  float64
+}
+
+func (s *analogSensor) Name() string { return s.name }
+func (s *analogSensor) Unit() string { return s.unit }
+
+func (s *analogSensor) Read() (float64, error) {
+	v, err := s.adc.ReadVolts(s.channel)
+	if err != nil {
+		return 0, err
+	}
+	return v*s.scale + s.offset, nil
+}
+
+// setupAnalog opens each configured ADC once and registers a sensor per channel.
+func setupAnalog(r *Registry, cfgs []config.AnalogSensor) error {
+	adcs := map[string]adc{}
+	for _, c := range cfgs {
+		key := fmt.Sprintf("%s %s %#x", c.ADC, c.Device, c.Address)
+		a, ok := adcs[key]
+		if !ok {
+			var err error
+			switch c.ADC {
+			case "mcp3008":
+				a, err = newMCP3008(c.Device, c.VRef)
+			case "ads1115":
+				a, err = newADS1115(c.Device, c.Address, c.FullScale)
+			default:
+				err = fmt.Errorf("unknown adc %q", c.ADC)
+			}
+			if err != nil {
+				return fmt.Errorf("sensor %s: %v", c.Name, err)
+			}
+			adcs[key] = a
+		}
+		r.Add(&analogSensor{name: c.Name, unit: c.Unit, adc: a, channel: c.Channel, scale: c.Scale, offset: c.Offset}, c.Role)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package sensor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/hci"
+	"github.com/barrylb/go-fire/internal/logging"
+	"golang.org/x/sys/unix"
+)
+
+// Passive BLE scanning for battery thermometers that broadcast their readings in
+// advertisements, read straight from a raw HCI socket so no BlueZ daemon or hub is needed.
+//
+// Supported broadcast formats:
+//   - Xiaomi LYWSD03MMC running the ATC1441 or pvvx custom firmware (service data 0x181A);
+//     the stock firmware encrypts its MiBeacon payload and is not supported
+//   - SwitchBot Meter / Meter Plus / Outdoor Meter (service data 0xFD3D or 0x0D00)
+//   - Govee H5072/H5075/H5101/H5102 (manufacturer data, company id 0xEC88)
+
+const (
+	hciEvLEMeta    = 0x3E
+	hciLEAdvReport = 0x02
+	hciFilter      = 2 // HCI_FILTER socket option
+)
+
+// bleReading is the latest advertisement decoded from one thermometer.
+type bleReading struct {
+	temp     float64
+	humidity float64
+	time     time.Time
+}
+
+var bleMu sync.Mutex
+var bleReadings = map[string]bleReading{} // upper-case MAC address -> reading
+var bleSeen = map[string]time.Time{}      // upper-case MAC address -> last advertisement
+var bleScanning = map[int]bool{}          // adapters being scanned
+
+// bleSensor reports the temperature (or humidity) last advertised by a thermometer.
+type bleSensor struct {
+	address  string
+	name     string
+	humidity bool
+	maxAge   time.Duration
+}
+
+func (s *bleSensor) Name() string { return s.name }
+
+func (s *bleSensor) Unit() string {
+	if s.humidity {
+		return "%"
+	}
+	return "C"
+}
+
+func (s *bleSensor) Read() (float64, error) {
+	bleMu.Lock()
+	r, ok := bleReadings[s.address]
+	bleMu.Unlock()
+	if !ok || time.Since(r.time) > s.maxAge {
+		return 0, fmt.Errorf("no advertisement from %s within %v", s.address, s.maxAge)
+	}
+	if s.humidity {
+		return r.humidity, nil
+	}
+	return r.temp, nil
+}
+
+// setupBLE registers the configured thermometers and starts scanning on the adapter.
+func setupBLE(r *Registry, cfg config.BLE) error {
+	if len(cfg.Devices) == 0 {
+		return nil
+	}
+	if err := StartBLEScan(cfg.Adapter); err != nil {
+		return err
+	}
+	for _, d := range cfg.Devices {
+		addr := strings.ToUpper(d.Address)
+		r.Add(&bleSensor{address: addr, name: d.Name, maxAge: cfg.MaxAge}, d.Role)
+		r.Add(&bleSensor{address: addr, name: d.Name + "_humidity", humidity: true, maxAge: cfg.MaxAge}, "")
+	}
+	return nil
+}
+
+// StartBLEScan starts scanning for advertisements on hciN, unless it is being scanned already.
+func StartBLEScan(adapter int) error {
+	bleMu.Lock()
+	defer bleMu.Unlock()
+	if bleScanning[adapter] {
+		return nil
+	}
+	fd, err := openHCIScanner(adapter)
+	if err != nil {
+		return fmt.Errorf("ble: hci%d: %v", adapter, err)
+	}
+	bleScanning[adapter] = true
+	go scanBLE(fd)
+	return nil
+}
+
+// BLESeen returns when an advertisement from address (upper case) was last received; zero if
+// none has been.
+func BLESeen(address string) time.Time {
+	bleMu.Lock()
+	defer bleMu.Unlock()
+	return bleSeen[address]
+}
+
+// openHCIScanner opens a raw HCI socket filtered to LE meta events and enables passive scanning.
+func openHCIScanner(dev int) (int, error) {
+	fd, err := hci.Open(dev)
+	if err != nil {
+		return -1, err
+	}
+	// struct hci_filter { type_mask; event_mask[2]; opcode }
+	filter := make([]byte, 14)
+	binary.LittleEndian.PutUint32(filter[0:], 1<<hci.EventPkt)
+	binary.LittleEndian.PutUint32(filter[8:], 1<<(hciEvLEMeta-32))
+	if err := unix.SetsockoptString(fd, unix.SOL_HCI, hciFilter, string(filter)); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	// LE Set Scan Parameters: passive, 10ms interval and window, public address, accept all
+	if err := hci.Command(fd, hci.OGFLE, 0x000B, []byte{0x00, 0x10, 0x00, 0x10, 0x00, 0x00, 0x00}); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	// LE Set Scan Enable: enabled, duplicates not filtered (readings change under the same address)
+	if err := hci.Command(fd, hci.OGFLE, 0x000C, []byte{0x01, 0x00}); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// scanBLE decodes advertising reports until the socket fails.
+func scanBLE(fd int) {
+	buf := make([]byte, 260)
+	for {
+		n, err := unix.Read(fd, buf)
+		if err != nil {
+			logging.Logf(logging.Err, "ble: scan stopped: %v", err)
+			return
+		}
+		// event pkt, LE meta, plen, subevent, num reports; only single-report events are decoded
+		if n < 15 || buf[0] != hci.EventPkt || buf[1] != hciEvLEMeta || buf[3] != hciLEAdvReport || buf[4] != 1 {
+			continue
+		}
+		a := buf[7:13]
+		addr := fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", a[5], a[4], a[3], a[2], a[1], a[0])
+		now := time.Now()
+		bleMu.Lock()
+		bleSeen[addr] = now
+		bleMu.Unlock()
+		dataLen := int(buf[13])
+		if 14+dataLen > n {
+			continue
+		}
+		if r, ok := decodeThermometerAdv(buf[14 : 14+dataLen]); ok {
+			r.time = now
+			bleMu.Lock()
+			bleReadings[addr] = r
+			bleMu.Unlock()
+		}
+	}
+}
+
+// decodeThermometerAdv walks the AD structures of an advertisement looking for a known format.
+func decodeThermometerAdv(ad []byte) (bleReading, bool) {
+	for len(ad) > 1 {
+		l := int(ad[0])
+		if l == 0 || l+1 > len(ad) {
+			break
+		}
+		typ, data := ad[1], ad[2:l+1]
+		ad = ad[l+1:]
+		switch {
+		case typ == 0x16 && len(data) >= 2:
+			uuid := binary.LittleEndian.Uint16(data)
+			switch uuid {
+			case 0x181A:
+				if r, ok := decodeXiaomiCustom(data[2:]); ok {
+					return r, true
+				}
+			case 0xFD3D, 0x0D00:
+				if r, ok := decodeSwitchBot(data[2:]); ok {
+					return r, true
+				}
+			}
+		case typ == 0xFF && len(data) >= 6 && binary.LittleEndian.Uint16(data) == 0xEC88:
+			return decodeGovee(data[2:]), true
+		}
+	}
+	return bleReading{}, false
+}
+
+func decodeXiaomiCustom(d []byte) (bleReading, bool) {
+	switch len(d) {
+	case 13: // ATC1441: mac(6) temp(int16 BE, 0.1C) humidity(%) battery(%) mV(2) counter
+		return bleReading{
+			temp:     float64(int16(binary.BigEndian.Uint16(d[6:]))) / 10,
+			humidity: float64(d[8]),
+		}, true
+	case 15: // pvvx: mac(6) temp(int16 LE, 0.01C) humidity(uint16 LE, 0.01%) mV(2) battery(%) counter flags
+		return bleReading{
+			temp:     float64(int16(binary.LittleEndian.Uint16(d[6:]))) / 100,
+			humidity: float64(binary.LittleEndian.Uint16(d[8:])) / 100,
+		}, true
+	}
+	return bleReading{}, false
+}
+
+func decodeSwitchBot(d []byte) (bleReading, bool) {
+	// device type 'T' (Meter), 'i' (Meter Plus) or 'w' (Outdoor Meter)
+	if len(d) < 6 || (d[0] != 'T' && d[0] != 'i' && d[0] != 'w') {
+		return bleReading{}, false
+	}
+	t := float64(d[4]&0x7F) + float64(d[3]&0x0F)/10
+	if d[4]&0x80 == 0 {
+		t = -t
+	}
+	return bleReading{temp: t, humidity: float64(d[5] & 0x7F)}, true
+}
+
+func decodeGovee(d []byte) bleReading {
+	// 24 bit big-endian value packing temperature*10000 + humidity*10, sign in the top bit
+	v := int(d[1])<<16 | int(d[2])<<8 | int(d[3])
+	neg := v&0x800000 != 0
+	v &= 0x7FFFFF
+	t := float64(v/1000) / 10
+	if neg {
+		t = -t
+	}
+	return bleReading{temp: t, humidity: float64(v%1000) / 10}
+}
@@ -0,0 +1,47 @@
+package sensor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/barrylb/go-fire/internal/config"
+)
+
+// iioDevices is where the kernel publishes Industrial I/O devices such as the dht11 driver's.
+const iioDevices = "/sys/bus/iio/devices"
+
+// dhtSensor reads the temperature of a DHT11/DHT22 through the dht11 IIO driver, which
+// bit-bangs the sensor's single-wire protocol in the kernel where the timing can be met.
+type dhtSensor struct {
+	device string
+	name   string
+}
+
+func (s *dhtSensor) Name() string { return s.name }
+func (s *dhtSensor) Unit() string { return "C" }
+
+func (s *dhtSensor) Read() (float64, error) {
+	// reads fail now and then with EIO on a checksum error or a missed edge; the next poll retries
+	data, err := ioutil.ReadFile(filepath.Join(iioDevices, s.device, "in_temp_input"))
+	if err != nil {
+		return 0, err
+	}
+	milli, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", s.device, err)
+	}
+	return float64(milli) / 1000, nil
+}
+
+func setupDHT(r *Registry, cfgs []config.DHTSensor) {
+	for _, c := range cfgs {
+		name := c.Name
+		if name == "" {
+			name = c.Device
+		}
+		r.Add(&dhtSensor{device: c.Device, name: name}, c.Role)
+	}
+}
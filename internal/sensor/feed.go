@@ -0,0 +1,79 @@
+package sensor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+)
+
+// ErrNoFeed is returned by Push for a name that isn't a configured feed.
+var ErrNoFeed = errors.New("sensor: no such feed")
+
+// feedSensor reports the latest value pushed to it, over HTTP or MQTT, by something that
+// reads the sensor itself (a room thermostat, a Zigbee thermometer, another Pi).
+type feedSensor struct {
+	name   string
+	unit   string
+	maxAge time.Duration
+
+	mu     sync.Mutex
+	value  float64
+	pushed time.Time
+}
+
+func (s *feedSensor) Name() string { return s.name }
+func (s *feedSensor) Unit() string { return s.unit }
+
+func (s *feedSensor) Read() (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pushed.IsZero() {
+		return 0, fmt.Errorf("%s: nothing pushed yet", s.name)
+	}
+	if age := time.Since(s.pushed); age > s.maxAge {
+		return 0, fmt.Errorf("%s: last pushed %s ago", s.name, age.Round(time.Second))
+	}
+	return s.value, nil
+}
+
+func setupFeeds(r *Registry, cfgs []config.Feed) {
+	for _, c := range cfgs {
+		r.Add(&feedSensor{name: c.Name, unit: c.Unit, maxAge: c.MaxAge}, c.Role)
+	}
+}
+
+// Push records a value for the named feed, in the feed's unit; it is picked up at the next poll.
+func (r *Registry) Push(name string, v float64) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, s := range r.sensors {
+		if f, ok := s.(*feedSensor); ok && f.name == name {
+			f.mu.Lock()
+			f.value, f.pushed = v, time.Now()
+			f.mu.Unlock()
+			return nil
+		}
+	}
+	return ErrNoFeed
+}
+
+// ParseFeed reads a pushed value: a bare number, or a JSON object with a temperature field.
+func ParseFeed(payload []byte) (float64, error) {
+	text := strings.TrimSpace(string(payload))
+	if v, err := strconv.ParseFloat(text, 64); err == nil {
+		return v, nil
+	}
+	var body struct {
+		Temperature *float64 `json:"temperature"`
+	}
+	if err := json.Unmarshal([]byte(text), &body); err != nil || body.Temperature == nil {
+		return 0, fmt.Errorf("sensor: feed payload %q is neither a number nor {\"temperature\": ...}", text)
+	}
+	return *body.Temperature, nil
+}
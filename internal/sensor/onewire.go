@@ -0,0 +1,74 @@
+package sensor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/barrylb/go-fire/internal/config"
+)
+
+// oneWireDevices is where the w1-gpio/w1-therm kernel modules publish bus devices.
+const oneWireDevices = "/sys/bus/w1/devices"
+
+// ds18b20Sensor reads a DS18B20 temperature probe through the w1-therm driver.
+type ds18b20Sensor struct {
+	id   string
+	name string
+}
+
+func (s *ds18b20Sensor) Name() string { return s.name }
+func (s *ds18b20Sensor) Unit() string { return "C" }
+
+func (s *ds18b20Sensor) Read() (float64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(oneWireDevices, s.id, "w1_slave"))
+	if err != nil {
+		return 0, err
+	}
+	// two lines: "... crc=xx YES" then "... t=21625" (millidegrees)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "YES") {
+		return 0, fmt.Errorf("%s: bad crc", s.id)
+	}
+	i := strings.LastIndex(lines[1], "t=")
+	if i < 0 {
+		return 0, fmt.Errorf("%s: no temperature in %q", s.id, lines[1])
+	}
+	milli, err := strconv.Atoi(lines[1][i+2:])
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", s.id, err)
+	}
+	// 85000 is the power-on reset value, returned when a conversion did not complete
+	if milli == 85000 {
+		return 0, fmt.Errorf("%s: conversion not ready", s.id)
+	}
+	return float64(milli) / 1000, nil
+}
+
+// setupOneWire registers every DS18B20 on the bus, using the configured
+// name and role where the id is listed and the bare id otherwise.
+func setupOneWire(r *Registry, cfgs []config.OneWireSensor) error {
+	ids, err := filepath.Glob(filepath.Join(oneWireDevices, "28-*"))
+	if err != nil {
+		return err
+	}
+	found := map[string]bool{}
+	for _, p := range ids {
+		found[filepath.Base(p)] = true
+	}
+	for _, c := range cfgs {
+		if !found[c.ID] {
+			return fmt.Errorf("1-wire sensor %s (%s) not found on the bus", c.ID, c.Name)
+		}
+		delete(found, c.ID)
+		r.Add(&ds18b20Sensor{id: c.ID, name: c.Name}, c.Role)
+	}
+	for _, p := range ids {
+		if id := filepath.Base(p); found[id] {
+			r.Add(&ds18b20Sensor{id: id, name: id}, "")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Package sensor polls temperature and analog inputs (1-Wire, DHT, ADC, BLE thermometers,
+// weather, pushed feeds) and keeps their latest calibrated readings.
+package sensor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// Sensor is a single named input feeding the registry.
+type Sensor interface {
+	Name() string
+	Unit() string
+	Read() (float64, error)
+}
+
+// Reading is the latest result of polling one sensor.
+type Reading struct {
+	Value float64   `json:"value"` // calibrated and smoothed
+	Raw   float64   `json:"raw"`   // before calibration and smoothing
+	Unit  string    `json:"unit"`
+	Role  string    `json:"role,omitempty"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Registry holds the registered sensors and their latest readings.
+type Registry struct {
+	mu       sync.RWMutex
+	sensors  []Sensor
+	readings map[string]Reading
+	roles    map[string]string // sensor name -> role (room, flue, outdoor, ...)
+	filters  map[string]*filter
+	// unit ("C" or "F") used for every temperature reported, including calibration offsets
+	temperatureUnit string
+}
+
+// NewRegistry returns an empty registry reporting temperatures in unit ("C" or "F").
+func NewRegistry(temperatureUnit string) *Registry {
+	return &Registry{
+		readings:        map[string]Reading{},
+		roles:           map[string]string{},
+		filters:         map[string]*filter{},
+		temperatureUnit: temperatureUnit,
+	}
+}
+
+// Setup registers every sensor described in the configuration.
+func Setup(r *Registry, cfg config.Sensors) error {
+	if err := setupAnalog(r, cfg.Analog); err != nil {
+		return err
+	}
+	if err := setupOneWire(r, cfg.OneWire); err != nil {
+		return err
+	}
+	if err := setupBLE(r, cfg.BLE); err != nil {
+		return err
+	}
+	setupWeather(r, cfg.Weather)
+	setupDHT(r, cfg.DHT)
+	setupFeeds(r, cfg.Feeds)
+	return r.setCalibration(cfg.Calibration)
+}
+
+// Add registers a sensor for polling; role may be empty.
+func (r *Registry) Add(s Sensor, role string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sensors = append(r.sensors, s)
+	if role != "" {
+		r.roles[s.Name()] = role
+	}
+}
+
+// filter applies a calibration offset and optional smoothing to a sensor's readings
+// before they are used, so a badly placed or noisy sensor doesn't make consumers hunt.
+type filter struct {
+	offset  float64
+	mode    string // "", "average" (moving average) or "ema"
+	window  int
+	samples []float64
+	ema     float64
+	primed  bool
+}
+
+func (f *filter) apply(raw float64) float64 {
+	v := raw + f.offset
+	switch f.mode {
+	case "average":
+		f.samples = append(f.samples, v)
+		if len(f.samples) > f.window {
+			f.samples = f.samples[1:]
+		}
+		sum := 0.0
+		for _, s := range f.samples {
+			sum += s
+		}
+		return sum / float64(len(f.samples))
+	case "ema":
+		if !f.primed {
+			f.ema, f.primed = v, true
+			return v
+		}
+		f.ema += 2 / float64(f.window+1) * (v - f.ema)
+		return f.ema
+	}
+	return v
+}
+
+// setCalibration installs the configured calibration for each named sensor.
+func (r *Registry) setCalibration(cfgs map[string]config.Calibration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, c := range cfgs {
+		switch c.Smoothing {
+		case "", "average", "ema":
+		default:
+			return fmt.Errorf("sensor %s: unknown smoothing %q", name, c.Smoothing)
+		}
+		r.filters[name] = &filter{offset: c.Offset, mode: c.Smoothing, window: c.Window}
+	}
+	return nil
+}
+
+// Poll reads every registered sensor once per interval, forever.
+func (r *Registry) Poll(interval time.Duration) {
+	for {
+		r.mu.RLock()
+		list := r.sensors
+		r.mu.RUnlock()
+		for _, s := range list {
+			v, err := s.Read()
+			v, unit := r.toTemperatureUnit(v, s.Unit())
+			r.mu.RLock()
+			reading := Reading{Value: v, Raw: v, Unit: unit, Role: r.roles[s.Name()], Time: time.Now()}
+			r.mu.RUnlock()
+			if err != nil {
+				logging.Logf(logging.Warning, "sensor %s: %v", s.Name(), err)
+				reading.Error = err.Error()
+				reading.Value, reading.Raw = 0, 0
+			}
+			r.mu.Lock()
+			if f := r.filters[s.Name()]; f != nil && err == nil {
+				reading.Value = f.apply(v)
+			}
+			r.readings[s.Name()] = reading
+			r.mu.Unlock()
+		}
+		time.Sleep(interval)
+	}
+}
+
+// toTemperatureUnit converts a Celsius reading into the configured temperature unit;
+// readings in any other unit are returned unchanged.
+func (r *Registry) toTemperatureUnit(v float64, unit string) (float64, string) {
+	if unit == "C" && r.temperatureUnit == "F" {
+		return v*9/5 + 32, "F"
+	}
+	return v, unit
+}
+
+// Latest returns the most recent successful reading of the named sensor.
+func (r *Registry) Latest(name string) (Reading, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reading, ok := r.readings[name]
+	return reading, ok && reading.Error == ""
+}
+
+// ForRole returns the latest successful reading from the first sensor assigned the role,
+// so consumers such as safety rules can refer to "outdoor" rather than a bus address.
+func (r *Registry) ForRole(role string) (Reading, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, s := range r.sensors {
+		if r.roles[s.Name()] != role {
+			continue
+		}
+		if reading, ok := r.readings[s.Name()]; ok && reading.Error == "" {
+			return reading, true
+		}
+	}
+	return Reading{}, false
+}
+
+// Readings returns a copy of the latest reading of every sensor, keyed by name.
+func (r *Registry) Readings() map[string]Reading {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]Reading, len(r.readings))
+	for k, v := range r.readings {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,178 @@
+package sensor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+)
+
+// weather fetches the current outdoor temperature, and the highest forecast for the next
+// hours when asked to, from Open-Meteo or OpenWeatherMap. Responses are cached for the
+// refresh interval so the sensor poll loop doesn't hammer the API.
+type weather struct {
+	cfg config.Weather
+
+	mu      sync.Mutex
+	current float64
+	high    float64 // highest forecast over the next cfg.ForecastHours
+	fetched time.Time
+}
+
+// weatherSensor is the current temperature, or the forecast high.
+type weatherSensor struct {
+	w        *weather
+	name     string
+	forecast bool
+}
+
+func (s *weatherSensor) Name() string { return s.name }
+func (s *weatherSensor) Unit() string { return "C" }
+
+func (s *weatherSensor) Read() (float64, error) {
+	current, high, err := s.w.read()
+	if s.forecast {
+		return high, err
+	}
+	return current, err
+}
+
+func (w *weather) read() (current, high float64, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if time.Since(w.fetched) >= w.cfg.Refresh {
+		if w.cfg.Provider == "openweathermap" {
+			err = w.fetchOpenWeatherMap()
+		} else {
+			err = w.fetchOpenMeteo()
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		w.fetched = time.Now()
+	}
+	return w.current, w.high, nil
+}
+
+func (w *weather) fetchOpenMeteo() error {
+	u := fmt.Sprintf("https://api.open-meteo.com/v1/forecast?latitude=%f&longitude=%f&current_weather=true", w.cfg.Latitude, w.cfg.Longitude)
+	if w.cfg.ForecastHours > 0 {
+		u += "&hourly=temperature_2m&forecast_days=3&timeformat=unixtime"
+	}
+	var body struct {
+		CurrentWeather struct {
+			Temperature float64 `json:"temperature"`
+		} `json:"current_weather"`
+		Hourly struct {
+			Time        []int64   `json:"time"`
+			Temperature []float64 `json:"temperature_2m"`
+		} `json:"hourly"`
+	}
+	if err := getJSON("open-meteo", u, &body); err != nil {
+		return err
+	}
+	w.current = body.CurrentWeather.Temperature
+	if w.cfg.ForecastHours > 0 {
+		high, err := w.forecastHigh(body.Hourly.Time, body.Hourly.Temperature)
+		if err != nil {
+			return fmt.Errorf("open-meteo: %v", err)
+		}
+		w.high = high
+	}
+	return nil
+}
+
+func (w *weather) fetchOpenWeatherMap() error {
+	q := fmt.Sprintf("lat=%f&lon=%f&units=metric&appid=%s", w.cfg.Latitude, w.cfg.Longitude, url.QueryEscape(w.cfg.APIKey))
+	var current struct {
+		Main struct {
+			Temp float64 `json:"temp"`
+		} `json:"main"`
+	}
+	if err := getJSON("openweathermap", "https://api.openweathermap.org/data/2.5/weather?"+q, &current); err != nil {
+		return err
+	}
+	w.current = current.Main.Temp
+	if w.cfg.ForecastHours == 0 {
+		return nil
+	}
+	// in three-hour steps
+	var forecast struct {
+		List []struct {
+			Dt   int64 `json:"dt"`
+			Main struct {
+				Temp float64 `json:"temp"`
+			} `json:"main"`
+		} `json:"list"`
+	}
+	if err := getJSON("openweathermap", "https://api.openweathermap.org/data/2.5/forecast?"+q, &forecast); err != nil {
+		return err
+	}
+	var times []int64
+	var temps []float64
+	for _, f := range forecast.List {
+		times, temps = append(times, f.Dt), append(temps, f.Main.Temp)
+	}
+	high, err := w.forecastHigh(times, temps)
+	if err != nil {
+		return fmt.Errorf("openweathermap: %v", err)
+	}
+	w.high = high
+	return nil
+}
+
+// forecastHigh returns the highest of temps forecast from now for the next
+// cfg.ForecastHours, given the Unix time of each.
+func (w *weather) forecastHigh(times []int64, temps []float64) (float64, error) {
+	now := time.Now()
+	from, until := now.Add(-time.Hour).Unix(), now.Add(time.Duration(w.cfg.ForecastHours)*time.Hour).Unix()
+	high, found := 0.0, false
+	for i, t := range times {
+		if i >= len(temps) || t < from || t > until {
+			continue
+		}
+		if !found || temps[i] > high {
+			high, found = temps[i], true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("no forecast for the next %d hours", w.cfg.ForecastHours)
+	}
+	return high, nil
+}
+
+// getJSON decodes the JSON reply to a GET of u. Errors leave out u, which may carry an API
+// key.
+func getJSON(provider, u string, v interface{}) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return fmt.Errorf("%s: %v", provider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", provider, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: %v", provider, err)
+	}
+	return nil
+}
+
+func setupWeather(r *Registry, cfg *config.Weather) {
+	if cfg == nil {
+		return
+	}
+	w := &weather{cfg: *cfg}
+	r.Add(&weatherSensor{w: w, name: cfg.Name}, cfg.Role)
+	if cfg.ForecastHours > 0 {
+		r.Add(&weatherSensor{w: w, name: cfg.Name + "_forecast", forecast: true}, cfg.ForecastRole)
+	}
+}
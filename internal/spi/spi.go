@@ -0,0 +1,76 @@
+// Package spi does full-duplex transfers on a Linux spidev device.
+package spi
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// SPI_IOC_MESSAGE(1) and SPI_IOC_WR_MAX_SPEED_HZ from linux/spi/spidev.h
+const spiIocMessage1 = 0x40206b00
+const spiIocWrMaxSpeedHz = 0x40046b04
+
+// spiIocTransfer mirrors struct spi_ioc_transfer.
+type spiIocTransfer struct {
+	txBuf          uint64
+	rxBuf          uint64
+	length         uint32
+	speedHz        uint32
+	delayUsecs     uint16
+	bitsPerWord    uint8
+	csChange       uint8
+	txNbits        uint8
+	rxNbits        uint8
+	wordDelayUsecs uint8
+	pad            uint8
+}
+
+// Dev is an open spidev device, e.g. /dev/spidev0.0.
+type Dev struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open opens device with the given maximum clock speed.
+func Open(device string, speedHz uint32) (*Dev, error) {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), spiIocWrMaxSpeedHz, uintptr(unsafe.Pointer(&speedHz))); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s: set speed: %v", device, errno)
+	}
+	return &Dev{f: f}, nil
+}
+
+// Transfer clocks out tx while reading the same number of bytes, with chip select held
+// for the whole transfer.
+func (d *Dev) Transfer(tx []byte) ([]byte, error) {
+	rx := make([]byte, len(tx))
+	if len(tx) == 0 {
+		return rx, nil
+	}
+	xfer := spiIocTransfer{
+		txBuf:       uint64(uintptr(unsafe.Pointer(&tx[0]))),
+		rxBuf:       uint64(uintptr(unsafe.Pointer(&rx[0]))),
+		length:      uint32(len(tx)),
+		bitsPerWord: 8,
+	}
+	d.mu.Lock()
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, d.f.Fd(), spiIocMessage1, uintptr(unsafe.Pointer(&xfer)))
+	d.mu.Unlock()
+	if errno != 0 {
+		return nil, errno
+	}
+	return rx, nil
+}
+
+// Close closes the device.
+func (d *Dev) Close() error {
+	return d.f.Close()
+}
@@ -0,0 +1,72 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// Notify sends state (e.g. READY=1) to systemd's notification socket, as sd_notify does. It
+// reports false, and does nothing, when the service manager isn't listening.
+func Notify(state string) (bool, error) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return false, nil
+	}
+	if name[0] == '@' {
+		name = "\x00" + name[1:] // abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns WatchdogSec= of the service, or 0 when the watchdog isn't enabled
+// for this process: WATCHDOG_PID, when set, must be this process or, after an in-place
+// upgrade, the process it replaced.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if p := os.Getenv("WATCHDOG_PID"); p != "" {
+		pid, err := strconv.Atoi(p)
+		if err != nil || (pid != os.Getpid() && pid != os.Getppid()) {
+			return 0
+		}
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Watchdog pings systemd's watchdog at half of interval for as long as healthy returns nil,
+// so that systemd restarts the service once it stops answering. It never returns.
+func Watchdog(interval time.Duration, healthy func() error) {
+	tick := time.NewTicker(interval / 2)
+	defer tick.Stop()
+	failing := false
+	for range tick.C {
+		if err := healthy(); err != nil {
+			if !failing {
+				logging.Logf(logging.Err, "watchdog: not pinging systemd: %v", err)
+			}
+			failing = true
+			continue
+		}
+		if failing {
+			logging.Logf(logging.Notice, "watchdog: healthy again")
+			failing = false
+		}
+		if _, err := Notify("WATCHDOG=1"); err != nil {
+			logging.Logf(logging.Warning, "watchdog: %v", err)
+		}
+	}
+}
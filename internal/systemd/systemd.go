@@ -0,0 +1,47 @@
+// Package systemd picks up sockets passed by systemd socket activation (sd_listen_fds).
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// Listeners returns the sockets systemd passed to this process, with their names from
+// FileDescriptorName= (or "" when unnamed). It returns nil when the process was not socket
+// activated. The environment variables are cleared so child processes don't inherit them.
+func Listeners() ([]net.Listener, []string, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	var lns []net.Listener
+	var lnNames []string
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(listenFDsStart+i), "systemd")
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		lns = append(lns, ln)
+		lnNames = append(lnNames, name)
+	}
+	return lns, lnNames, nil
+}
@@ -0,0 +1,240 @@
+// Package thermostat holds the room at a target temperature by lighting the fire, stepping
+// its flame and turning it off, with hysteresis so that it doesn't cycle the valve. With an
+// outdoor boost it keeps the flame higher while it is freezing outside, and with modulate it
+// cycles the burner once even the lowest flame is too much.
+package thermostat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/automation"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/modulate"
+	"github.com/barrylb/go-fire/internal/power"
+	"github.com/barrylb/go-fire/internal/sensor"
+)
+
+// source is what thermostat commands are recorded as.
+const source = "thermostat"
+
+// Thermostat runs the control loop.
+type Thermostat struct {
+	cfg     config.Thermostat
+	runner  *actions.Runner
+	sensors *sensor.Registry
+	hold    *automation.Hold
+	power   *power.Tracker
+	mod     *modulate.Modulator // nil without modulate
+	kick    chan struct{}
+
+	mu       sync.Mutex
+	target   *float64
+	previous *float64 // the last target, kept while off for Resume
+	last     *Action
+}
+
+// Action is a command the thermostat sent.
+type Action struct {
+	Command     string    `json:"command"`
+	Result      string    `json:"result"`
+	Temperature float64   `json:"temperature"`
+	Time        time.Time `json:"time"`
+}
+
+// State is the thermostat's setpoint, the room temperature and its last command.
+type State struct {
+	Target      *float64 `json:"target"` // null while off
+	Temperature *float64 `json:"temperature,omitempty"`
+	Unit        string   `json:"unit,omitempty"`
+	Role        string   `json:"role"`
+	LastAction  *Action  `json:"last_action,omitempty"`
+	// Outdoor is the outdoor temperature with an outdoor boost, and Boost whether it is on.
+	Outdoor *float64 `json:"outdoor,omitempty"`
+	Boost   bool     `json:"boost,omitempty"`
+}
+
+// Start begins holding the room at target, or cfg.Target when target is nil; with neither
+// the thermostat does nothing until a target is set. mod, which cfg.Modulate needs, may be
+// nil.
+func Start(cfg config.Thermostat, target *float64, runner *actions.Runner, sensors *sensor.Registry, hold *automation.Hold,
+	pw *power.Tracker, mod *modulate.Modulator) *Thermostat {
+	if target == nil {
+		target = cfg.Target
+	}
+	if mod == nil {
+		cfg.Modulate = nil
+	}
+	t := &Thermostat{cfg: cfg, runner: runner, sensors: sensors, hold: hold, power: pw, mod: mod,
+		kick: make(chan struct{}, 1), target: target, previous: target}
+	fault.Go("thermostat", t.loop)
+	return t
+}
+
+// Set changes the target; nil turns the thermostat off, leaving the fire as it is.
+func (t *Thermostat) Set(target *float64) {
+	t.mu.Lock()
+	t.target = target
+	if target != nil {
+		t.previous = target
+	}
+	t.mu.Unlock()
+	t.wake()
+}
+
+// Resume turns the thermostat back on at the last target it had, or cfg.Target, returning
+// it; with neither it stays off and returns nil.
+func (t *Thermostat) Resume() *float64 {
+	t.mu.Lock()
+	if t.target == nil {
+		t.target = t.previous
+	}
+	target := t.target
+	t.mu.Unlock()
+	t.wake()
+	return target
+}
+
+func (t *Thermostat) wake() {
+	select {
+	case t.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Target returns the current target, nil while the thermostat is off.
+func (t *Thermostat) Target() *float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.target
+}
+
+// MaxTarget is the highest target Set should be given.
+func (t *Thermostat) MaxTarget() float64 { return t.cfg.MaxTarget }
+
+// State returns the current state.
+func (t *Thermostat) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := State{Target: t.target, Role: t.cfg.Role, LastAction: t.last}
+	if r, ok := t.sensors.ForRole(t.cfg.Role); ok {
+		v := r.Value
+		s.Temperature, s.Unit = &v, r.Unit
+	}
+	if o := t.cfg.Outdoor; o != nil {
+		if r, ok := t.sensors.ForRole(o.Role); ok {
+			v := r.Value
+			s.Outdoor = &v
+		}
+		s.Boost = t.floor() > 1
+	}
+	return s
+}
+
+// floor is the lowest flame level the thermostat steps down to: 1, or the outdoor boost's
+// min_level while a recent outdoor reading is below its threshold.
+func (t *Thermostat) floor() int {
+	o := t.cfg.Outdoor
+	if o == nil {
+		return 1
+	}
+	r, ok := t.sensors.ForRole(o.Role)
+	if !ok || time.Since(r.Time) > t.cfg.MaxAge || r.Value >= *o.Below {
+		return 1
+	}
+	return o.MinLevel
+}
+
+func (t *Thermostat) loop() {
+	tick := time.NewTicker(t.cfg.Interval)
+	defer tick.Stop()
+	for {
+		t.step()
+		select {
+		case <-tick.C:
+		case <-t.kick:
+		}
+	}
+}
+
+// step sends at most one command towards the target.
+func (t *Thermostat) step() {
+	target := t.Target()
+	if target == nil || (t.hold != nil && t.hold.Paused()) {
+		return
+	}
+	r, ok := t.sensors.ForRole(t.cfg.Role)
+	if !ok || time.Since(r.Time) > t.cfg.MaxAge {
+		logging.Logf(logging.Warning, "thermostat: no recent reading from a %s sensor", t.cfg.Role)
+		return
+	}
+	if t.mod != nil && t.mod.Active() {
+		// a modulation holds the fire until the room leaves the band around the target
+		if r.Value >= *target-t.cfg.StepBand && r.Value < *target+t.cfg.Hysteresis {
+			return
+		}
+		t.mod.Stop(fmt.Sprintf("thermostat at %v", r.Value))
+	}
+	floor := t.floor()
+	command := t.decide(r.Value, *target, t.power.State(), floor)
+	if command == "" {
+		return
+	}
+	var result string
+	if command == "modulate" {
+		result = "ok"
+		if m := t.cfg.Modulate; t.mod.Start(m.Duty, m.Period, 0, source) == modulate.ErrUnlit {
+			result = "unlit"
+		}
+	} else {
+		result = t.runner.Run(command, source)
+	}
+	logging.Event(logging.Info, "thermostat", "command", command, "result", result,
+		"temperature", fmt.Sprint(r.Value), "target", fmt.Sprint(*target), "floor", fmt.Sprint(floor))
+	t.mu.Lock()
+	t.last = &Action{Command: command, Result: result, Temperature: r.Value, Time: time.Now()}
+	t.mu.Unlock()
+}
+
+// decide returns the command that moves temp towards target, or "" to leave the fire be:
+// it is lit below the hysteresis band and turned off above it, and while it burns the flame
+// is stepped up when well below the target and down once above it, though not below floor,
+// to which it is stepped up while the room is no warmer than the target. Above the target at
+// the lowest flame, with modulate set, it is modulated: the burner cycled, until the room
+// falls well below the target or rises above the band.
+func (t *Thermostat) decide(temp, target float64, ps power.State, floor int) string {
+	max := t.power.Levels().Max
+	if floor > max {
+		floor = max
+	}
+	switch {
+	case temp >= target+t.cfg.Hysteresis:
+		// also when the state is unknown, so a fire of unknown state can't overheat the room;
+		// a pilot flame is left lit
+		if ps.Power != "off" && ps.Power != "pilot" {
+			return "off"
+		}
+	case ps.Power != "on":
+		if temp <= target-t.cfg.Hysteresis {
+			return "on"
+		}
+	case floor > 1 && ps.FlameLevel != nil && *ps.FlameLevel < floor && temp <= target:
+		return "flameup"
+	case temp > target:
+		if ps.FlameLevel == nil || *ps.FlameLevel > floor {
+			return "flamedown"
+		}
+		if t.cfg.Modulate != nil && floor == 1 {
+			return "modulate"
+		}
+	case temp < target-t.cfg.StepBand:
+		if ps.FlameLevel == nil || *ps.FlameLevel < max {
+			return "flameup"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+// Package tlscert loads a listener's certificate, first creating a self-signed one when
+// asked to, so that the API can be served over HTTPS without setting up a CA. Clients
+// have to trust the certificate themselves (or pin it); it is kept across restarts so
+// they only have to do so once.
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/logging"
+)
+
+// validity is how long a self-signed certificate lasts.
+const validity = 10 * 365 * 24 * time.Hour
+
+// Load returns the certificate in cfg.CertFile and cfg.KeyFile. With cfg.SelfSigned and
+// neither file present, it first generates a self-signed certificate and saves it there.
+func Load(cfg config.ListenerTLS) (tls.Certificate, error) {
+	if cfg.SelfSigned && missing(cfg.CertFile) && missing(cfg.KeyFile) {
+		if err := generate(cfg.CertFile, cfg.KeyFile); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+}
+
+func missing(path string) bool {
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
+}
+
+// generate writes a self-signed ECDSA P-256 certificate for this host's name, its .local
+// mDNS name, localhost and its addresses.
+func generate(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "gofire"
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host, Organization: []string{"GoFire"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true, // so a client can install it as its own trust anchor
+		DNSNames:              []string{host, host + ".local", "localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipn.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	logging.Event(logging.Notice, "self-signed certificate created", "cert_file", certFile,
+		"host", host, "expires", tmpl.NotAfter.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,180 @@
+// Package upgrade replaces the running binary without dropping requests: the old process
+// passes its listening socket and tracked state to the new one, and only lets go of the
+// relay lines once its in-flight operations have finished.
+//
+// The handover runs as follows:
+//
+//  1. The old process starts the new binary with a ready pipe as fd 3, a state pipe as
+//     fd 4 and its listeners from fd 5 on, naming them in the environment.
+//  2. The new process loads its configuration and writes to the ready pipe. If it exits
+//     first, the old process carries on serving.
+//  3. The old process stops accepting, waits for in-flight requests and relay sequences,
+//     writes its state to the state pipe and exits. Connections arriving meanwhile wait
+//     in the socket's backlog.
+//  4. The new process reads the state, waits for the old one to exit so the GPIO lines
+//     are free, and starts serving.
+package upgrade
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// envUpgrade marks a process started by Exec and holds the comma separated listener names.
+const envUpgrade = "GOFIRE_UPGRADE"
+
+const (
+	readyFD         = 3
+	stateFD         = 4
+	firstListenerFD = 5
+)
+
+// State is the tracked state carried across an upgrade.
+type State struct {
+	Light       int               `json:"light"`                 // light target brightness, 0 when off
+	Accessories map[string]string `json:"accessories,omitempty"` // on or off, by name
+	HoldUntil   time.Time         `json:"hold_until"`            // automation paused until then
+	HoldReason  string            `json:"hold_reason"`
+	Power       string            `json:"power"` // fireplace on, off or empty when unknown
+	FlameLevel  *float64          `json:"flame_level,omitempty"`
+	PowerSince  time.Time         `json:"power_since"`
+	Setpoint    *float64          `json:"setpoint,omitempty"` // thermostat target
+}
+
+// Inherited reports whether this process was started by an upgrade.
+func Inherited() bool {
+	_, ok := os.LookupEnv(envUpgrade)
+	return ok
+}
+
+// Listeners returns the listeners inherited from the old process, keyed by name.
+func Listeners() (map[string]net.Listener, error) {
+	lns := map[string]net.Listener{}
+	for i, name := range strings.Split(os.Getenv(envUpgrade), ",") {
+		f := os.NewFile(uintptr(firstListenerFD+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		lns[name] = ln
+	}
+	return lns, nil
+}
+
+// Takeover tells the old process it can stop, then returns its state once it has exited
+// and released the hardware. Without an upgrade it returns the zero State.
+func Takeover(timeout time.Duration) (State, error) {
+	var st State
+	if !Inherited() {
+		return st, nil
+	}
+	os.Unsetenv(envUpgrade)
+	ppid := os.Getppid()
+	ready := os.NewFile(readyFD, "ready")
+	_, err := ready.Write([]byte{1})
+	ready.Close()
+	if err != nil {
+		return st, err
+	}
+	// the old process writes its state once drained; EOF without state means it died
+	f := os.NewFile(stateFD, "state")
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return st, fmt.Errorf("upgrade: reading state: %v", err)
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &st); err != nil {
+			return st, fmt.Errorf("upgrade: bad state: %v", err)
+		}
+	}
+	// once the old process has exited we are reparented
+	for deadline := time.Now().Add(timeout); os.Getppid() == ppid; time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			return st, errors.New("upgrade: old process did not exit")
+		}
+	}
+	return st, nil
+}
+
+// Handover is an upgrade in progress in the old process.
+type Handover struct {
+	state *os.File
+}
+
+// Exec starts the binary at the path this process was run from, passing the named
+// listeners, and waits until it has loaded its configuration. The caller must then drain
+// and call Finish.
+func Exec(lns map[string]net.Listener, timeout time.Duration) (*Handover, error) {
+	var names []string
+	for name := range lns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range names {
+		tl, ok := lns[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("upgrade: listener %s cannot be passed on", name)
+		}
+		f, err := tl.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+	stateR, stateW, err := os.Pipe()
+	if err != nil {
+		readyW.Close()
+		return nil, err
+	}
+	p, err := os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Env:   append(os.Environ(), envUpgrade+"="+strings.Join(names, ",")),
+		Files: append([]*os.File{os.Stdin, os.Stdout, os.Stderr, readyW, stateR}, files...),
+	})
+	readyW.Close()
+	stateR.Close()
+	if err != nil {
+		stateW.Close()
+		return nil, err
+	}
+	// the ready pipe reaches EOF without a byte if the new process exits early
+	readyR.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := io.ReadFull(readyR, make([]byte, 1)); err != nil {
+		stateW.Close()
+		p.Kill()
+		p.Wait()
+		return nil, fmt.Errorf("upgrade: new process not ready: %v", err)
+	}
+	p.Release()
+	return &Handover{state: stateW}, nil
+}
+
+// Finish sends st to the new process. The old process should exit straight afterwards.
+func (h *Handover) Finish(st State) error {
+	defer h.state.Close()
+	return json.NewEncoder(h.state).Encode(st)
+}
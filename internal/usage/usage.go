@@ -0,0 +1,243 @@
+// Package usage keeps how long the fire has burned each day, and counters of it that can be
+// reset, on disk across restarts, so that gas use can be estimated by day, week or month.
+package usage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// checkEvery is how often the burn time is read; a burn crossing midnight may be counted up
+// to this late in the next day.
+const checkEvery = 10 * time.Second
+
+// saveEvery is how often changed usage is written, sparing the SD card.
+const saveEvery = time.Minute
+
+// dateLayout keys the days, in local time.
+const dateLayout = "2006-01-02"
+
+// Periods are what usage can be summed over.
+var Periods = map[string]bool{"day": true, "week": true, "month": true}
+
+// Meter adds up the burn time of a power tracker.
+type Meter struct {
+	cfg   config.Usage
+	power *power.Tracker
+
+	mu    sync.Mutex
+	data  saved
+	dirty bool
+}
+
+// saved is the usage file.
+type saved struct {
+	Days     map[string]float64  `json:"days"` // seconds burned, by date
+	Counters map[string]*counter `json:"counters,omitempty"`
+}
+
+type counter struct {
+	Since   time.Time `json:"since"`
+	Seconds float64   `json:"seconds"`
+}
+
+// Period is the usage of one day, week (from Monday) or month.
+type Period struct {
+	Start string   `json:"start"` // first day, e.g. 2026-10-01
+	Hours float64  `json:"hours"`
+	Cost  *float64 `json:"cost,omitempty"` // with usage.cost_per_hour
+}
+
+// Counter is the usage since a counter was last reset.
+type Counter struct {
+	Name  string    `json:"name"`
+	Since time.Time `json:"since"`
+	Hours float64   `json:"hours"`
+	Cost  *float64  `json:"cost,omitempty"`
+}
+
+// Open loads the usage saved in cfg.File and starts adding up the burn time of pw.
+func Open(cfg config.Usage, pw *power.Tracker) (*Meter, error) {
+	m := &Meter{cfg: cfg, power: pw, data: saved{Days: map[string]float64{}, Counters: map[string]*counter{}}}
+	data, err := ioutil.ReadFile(cfg.File)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &m.data); err != nil {
+			return nil, err
+		}
+		if m.data.Days == nil {
+			m.data.Days = map[string]float64{}
+		}
+		if m.data.Counters == nil {
+			m.data.Counters = map[string]*counter{}
+		}
+	}
+	fault.Go("usage", m.watch)
+	go func() {
+		for range time.Tick(saveEvery) {
+			if err := m.Save(); err != nil {
+				logging.Logf(logging.Warning, "usage: %v", err)
+			}
+		}
+	}()
+	return m, nil
+}
+
+// watch adds the burn time since the last check to the day and every counter.
+func (m *Meter) watch() {
+	last := m.power.BurnTime()
+	for now := range time.Tick(checkEvery) {
+		d := m.power.BurnTime()
+		burned := d - last
+		last = d
+		if burned <= 0 {
+			continue
+		}
+		m.mu.Lock()
+		m.data.Days[now.Format(dateLayout)] += burned.Seconds()
+		for _, c := range m.data.Counters {
+			c.Seconds += burned.Seconds()
+		}
+		m.dirty = true
+		m.mu.Unlock()
+	}
+}
+
+// CostPerHour returns usage.cost_per_hour; 0 when costs aren't estimated.
+func (m *Meter) CostPerHour() float64 {
+	return m.cfg.CostPerHour
+}
+
+// History returns the usage of each period ("day", "week" or "month") from the one holding
+// since to the current one, oldest first, including those without a burn. A zero since
+// starts at the first day recorded.
+func (m *Meter) History(period string, since time.Time) []Period {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sums := map[string]float64{}
+	first := ""
+	for day, secs := range m.data.Days {
+		t, err := time.ParseInLocation(dateLayout, day, time.Local)
+		if err != nil {
+			continue
+		}
+		start := periodStart(period, t).Format(dateLayout)
+		sums[start] += secs
+		if first == "" || day < first {
+			first = day
+		}
+	}
+	if since.IsZero() {
+		if first == "" {
+			since = time.Now()
+		} else {
+			since, _ = time.ParseInLocation(dateLayout, first, time.Local)
+		}
+	}
+	var out []Period
+	for t := periodStart(period, since); !t.After(time.Now()); t = nextPeriod(period, t) {
+		start := t.Format(dateLayout)
+		out = append(out, Period{Start: start, Hours: hours(sums[start]), Cost: m.cost(sums[start])})
+	}
+	return out
+}
+
+// Counters returns the counters, ordered by name.
+func (m *Meter) Counters() []Counter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Counter, 0, len(m.data.Counters))
+	for name, c := range m.data.Counters {
+		out = append(out, Counter{Name: name, Since: c.Since, Hours: hours(c.Seconds), Cost: m.cost(c.Seconds)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Reset sets the counter name back to zero, starting it if there is none.
+func (m *Meter) Reset(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.Counters[name] = &counter{Since: time.Now()}
+	m.dirty = true
+}
+
+// Remove deletes the counter name, reporting whether there was one.
+func (m *Meter) Remove(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data.Counters[name]; !ok {
+		return false
+	}
+	delete(m.data.Counters, name)
+	m.dirty = true
+	return true
+}
+
+// Save writes the usage if it has changed, replacing the file atomically.
+func (m *Meter) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirty {
+		return nil
+	}
+	data, err := json.Marshal(m.data)
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(m.cfg.File, data, 0600); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+// cost returns what secs of burning cost, or nil without a cost per hour.
+func (m *Meter) cost(secs float64) *float64 {
+	if m.cfg.CostPerHour == 0 {
+		return nil
+	}
+	c := math.Round(secs/3600*m.cfg.CostPerHour*100) / 100
+	return &c
+}
+
+// hours returns secs in hours, to the nearest 3.6 seconds.
+func hours(secs float64) float64 {
+	return math.Round(secs/3.6) / 1000
+}
+
+// periodStart returns the first day of the period holding t.
+func periodStart(period string, t time.Time) time.Time {
+	y, mo, d := t.Date()
+	switch period {
+	case "week":
+		return time.Date(y, mo, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, time.Local)
+	case "month":
+		return time.Date(y, mo, 1, 0, 0, 0, 0, time.Local)
+	}
+	return time.Date(y, mo, d, 0, 0, 0, 0, time.Local)
+}
+
+// nextPeriod returns the first day of the period after the one starting at t.
+func nextPeriod(period string, t time.Time) time.Time {
+	switch period {
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
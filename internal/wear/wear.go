@@ -0,0 +1,149 @@
+// Package wear counts relay actuations, keeps the counts on disk across restarts and warns
+// as a relay nears its rated number of cycles.
+package wear
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// saveEvery is how often changed counts are written; actuations are rare, and an SD card
+// shouldn't be written for each one.
+const saveEvery = time.Minute
+
+// Counter counts actuations of named relays.
+type Counter struct {
+	cfg config.RelayWear
+
+	mu     sync.Mutex
+	counts map[string]int64
+	dirty  bool
+}
+
+// Relay is one relay's count, as reported by Counts.
+type Relay struct {
+	Name       string  `json:"name"`
+	Actuations int64   `json:"actuations"`
+	Rated      int64   `json:"rated"`
+	Used       float64 `json:"used"` // fraction of Rated
+}
+
+// Open loads the counts saved in cfg.File and starts saving them as they change.
+func Open(cfg config.RelayWear) (*Counter, error) {
+	c := &Counter{cfg: cfg, counts: map[string]int64{}}
+	data, err := ioutil.ReadFile(cfg.File)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &c.counts); err != nil {
+			return nil, err
+		}
+	}
+	for name, n := range c.counts {
+		c.warn(name, n, true)
+	}
+	go func() {
+		for range time.Tick(saveEvery) {
+			if err := c.Save(); err != nil {
+				logging.Logf(logging.Warning, "wear: %v", err)
+			}
+		}
+	}()
+	return c, nil
+}
+
+// Wrap returns l counting an actuation each time it energises the relay, i.e. each time
+// it is set to 0 after being 1 (relay boards are active low, and lines start at 1).
+func (c *Counter) Wrap(name string, l relay.Line) relay.Line {
+	return &line{Line: l, c: c, name: name, value: 1}
+}
+
+type line struct {
+	relay.Line
+	c     *Counter
+	name  string
+	value int
+}
+
+func (l *line) SetValue(value int) error {
+	err := l.Line.SetValue(value)
+	if err == nil && value == 0 && l.value != 0 {
+		l.c.add(l.name)
+	}
+	if err == nil {
+		l.value = value
+	}
+	return err
+}
+
+func (c *Counter) add(name string) {
+	c.mu.Lock()
+	c.counts[name]++
+	n := c.counts[name]
+	c.dirty = true
+	c.mu.Unlock()
+	c.warn(name, n, false)
+}
+
+// warn logs when n reaches the warning threshold or the rated cycles; with always, it
+// logs if n is past either.
+func (c *Counter) warn(name string, n int64, always bool) {
+	warnAt := int64(c.cfg.WarnAt * float64(c.cfg.RatedCycles))
+	switch {
+	case n == c.cfg.RatedCycles || always && n > c.cfg.RatedCycles:
+		logging.Event(logging.Warning, "relay past its rated cycles, replace the relay board",
+			"relay", name, "actuations", strconv.FormatInt(n, 10), "rated", strconv.FormatInt(c.cfg.RatedCycles, 10))
+	case n == warnAt || always && n > warnAt:
+		logging.Event(logging.Warning, "relay nearing its rated cycles",
+			"relay", name, "actuations", strconv.FormatInt(n, 10), "rated", strconv.FormatInt(c.cfg.RatedCycles, 10))
+	}
+}
+
+// Count returns the actuations of the relay name.
+func (c *Counter) Count(name string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[name]
+}
+
+// Counts returns every relay's count, ordered by name.
+func (c *Counter) Counts() []Relay {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]Relay, 0, len(c.counts))
+	for name, n := range c.counts {
+		out = append(out, Relay{Name: name, Actuations: n, Rated: c.cfg.RatedCycles,
+			Used: float64(n) / float64(c.cfg.RatedCycles)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Save writes the counts if they have changed, replacing the file atomically.
+func (c *Counter) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.Marshal(c.counts)
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(c.cfg.File, data, 0600); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,332 @@
+// Package webhooks calls URLs on the main fireplace's events, so that they can be pushed to
+// a phone through a service such as ntfy or Pushover:
+//
+//   - on: the fire was lit
+//   - off: the fire was turned off
+//   - auto_off: the auto-off timer turned the fire off (rather than off)
+//   - ignition_failed: no flame was proven after ignition, so the fire was turned off
+//   - interlock_tripped: an interlock turned the fire off (rather than off)
+//
+// Each call is made in the background, and retried a few times if it fails.
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/events"
+	"github.com/barrylb/go-fire/internal/fault"
+	"github.com/barrylb/go-fire/internal/fileutil"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/power"
+)
+
+// Events are the events a hook can ask for, with their messages.
+var Events = map[string]string{
+	"on":                "The fire was lit",
+	"off":               "The fire was turned off",
+	"auto_off":          "The fire was turned off by the auto-off timer",
+	"ignition_failed":   "Ignition failed: no flame was proven, so the fire was turned off",
+	"interlock_tripped": "An interlock turned the fire off",
+}
+
+var (
+	// ErrNotFound is returned for a hook that doesn't exist.
+	ErrNotFound = errors.New("webhooks: no such hook")
+	// ErrConfigured is returned for a change to a hook from the configuration.
+	ErrConfigured = errors.New("webhooks: the hook is set in the configuration")
+)
+
+// attempts is how many times a call is made before it is given up.
+const attempts = 3
+
+var hookName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// Hook is a URL called on the events it asks for.
+type Hook struct {
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Events  []string          `json:"events,omitempty"` // every event when empty
+	Format  string            `json:"format,omitempty"` // json (the default), text or form
+	Headers map[string]string `json:"headers,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"` // further form fields
+	// Configured hooks are from the configuration, and can't be changed with the API.
+	Configured bool `json:"configured,omitempty"`
+}
+
+// Validate checks the hook, filling in its default format.
+func (h *Hook) Validate() error {
+	if !hookName.MatchString(h.Name) {
+		return fmt.Errorf("name must be 1 to 32 of a-z, 0-9, _ and -, not %q", h.Name)
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL, not %q", h.URL)
+	}
+	for _, e := range h.Events {
+		if _, ok := Events[e]; !ok {
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+	switch h.Format {
+	case "":
+		h.Format = "json"
+	case "json", "text", "form":
+	default:
+		return fmt.Errorf("format must be json, text or form, not %q", h.Format)
+	}
+	return nil
+}
+
+// wants reports whether h asks for event.
+func (h *Hook) wants(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the JSON body of a call.
+type Payload struct {
+	Event   string    `json:"event"`
+	Message string    `json:"message"`
+	Source  string    `json:"source"` // what sent the command, e.g. http or autooff
+	Time    time.Time `json:"time"`
+}
+
+// Notifier calls the hooks.
+type Notifier struct {
+	file   string
+	client *http.Client
+
+	mu    sync.Mutex
+	hooks map[string]Hook
+	power string // on, off or pilot, as the commands seen have left it
+}
+
+// Start calls the hooks of cfg, and those registered before and kept in cfg.File, on the
+// events of the fireplace pw tracks.
+func Start(cfg config.Webhooks, pw *power.Tracker) (*Notifier, error) {
+	n := &Notifier{file: cfg.File, client: &http.Client{Timeout: 10 * time.Second},
+		hooks: map[string]Hook{}, power: pw.State().Power}
+	if n.file != "" {
+		data, err := ioutil.ReadFile(n.file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			var saved []Hook
+			if err := json.Unmarshal(data, &saved); err != nil {
+				return nil, fmt.Errorf("webhooks: %s: %v", n.file, err)
+			}
+			for _, h := range saved {
+				n.hooks[h.Name] = h
+			}
+		}
+	}
+	for _, c := range cfg.Hooks {
+		h := Hook{Name: c.Name, URL: c.URL, Events: c.Events, Format: c.Format, Headers: c.Headers,
+			Fields: c.Fields, Configured: true}
+		if err := h.Validate(); err != nil {
+			return nil, fmt.Errorf("webhooks.hooks: %v", err)
+		}
+		if n.hooks[h.Name].Configured {
+			return nil, fmt.Errorf("webhooks.hooks: %s is named twice", h.Name)
+		}
+		n.hooks[h.Name] = h
+	}
+	ch, _ := events.Subscribe()
+	fault.Go("webhooks", func() { n.watch(ch) })
+	return n, nil
+}
+
+// List returns the hooks, ordered by name.
+func (n *Notifier) List() []Hook {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make([]Hook, 0, len(n.hooks))
+	for _, h := range n.hooks {
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Put registers h, or replaces the registered hook of its name.
+func (n *Notifier) Put(h Hook) error {
+	h.Configured = false
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.hooks[h.Name].Configured {
+		return ErrConfigured
+	}
+	n.hooks[h.Name] = h
+	return n.save()
+}
+
+// Delete removes the registered hook name.
+func (n *Notifier) Delete(name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	h, ok := n.hooks[name]
+	switch {
+	case !ok:
+		return ErrNotFound
+	case h.Configured:
+		return ErrConfigured
+	}
+	delete(n.hooks, name)
+	return n.save()
+}
+
+// Test calls the hook name with a test event, whatever events it asks for, and returns
+// the outcome.
+func (n *Notifier) Test(name string) error {
+	n.mu.Lock()
+	h, ok := n.hooks[name]
+	n.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	return n.call(h, Payload{Event: "test", Message: "A test of the webhook " + name, Source: "test", Time: time.Now()})
+}
+
+// save writes the registered hooks to the file, if any; callers must hold mu.
+func (n *Notifier) save() error {
+	if n.file == "" {
+		return nil
+	}
+	saved := []Hook{}
+	for _, h := range n.hooks {
+		if !h.Configured {
+			saved = append(saved, h)
+		}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(n.file, data, 0600)
+}
+
+func (n *Notifier) watch(ch <-chan events.Command) {
+	for c := range ch {
+		if event := n.event(c); event != "" {
+			n.notify(Payload{Event: event, Message: Events[event], Source: c.Source, Time: c.Time})
+		}
+	}
+}
+
+// event returns the event c is, if any, following the fire's state: lighting a fire
+// that is already lit, or turning off one that is already off, is no event.
+func (n *Notifier) event(c events.Command) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if c.Op == "on" && c.Result == "ignition_failed" {
+		return "ignition_failed"
+	}
+	if c.Result != "ok" {
+		return ""
+	}
+	was := n.power
+	switch {
+	case c.Op == "on" || (c.Op == "flameup" && was == "pilot"):
+		n.power = "on"
+		if was != "on" {
+			return "on"
+		}
+	case c.Op == "pilot":
+		n.power = "pilot"
+	case c.Op == "off":
+		n.power = "off"
+		switch {
+		case c.Source == "interlock":
+			return "interlock_tripped"
+		case was == "off":
+		case c.Source == "autooff":
+			return "auto_off"
+		default:
+			return "off"
+		}
+	}
+	return ""
+}
+
+// notify calls, in the background, every hook asking for p's event.
+func (n *Notifier) notify(p Payload) {
+	for _, h := range n.List() {
+		if !h.wants(p.Event) {
+			continue
+		}
+		h := h
+		fault.Go("webhooks", func() {
+			var err error
+			for attempt := 1; attempt <= attempts; attempt++ {
+				if err = n.call(h, p); err == nil {
+					return
+				}
+				time.Sleep(time.Duration(attempt) * 5 * time.Second)
+			}
+			logging.Event(logging.Warning, "webhook failed", "hook", h.Name, "event", p.Event, "error", err.Error())
+		})
+	}
+}
+
+// call makes one call of h with p.
+func (n *Notifier) call(h Hook, p Payload) error {
+	var body []byte
+	var contentType string
+	switch h.Format {
+	case "text":
+		body, contentType = []byte(p.Message), "text/plain; charset=utf-8"
+	case "form":
+		form := url.Values{"message": {p.Message}, "event": {p.Event}}
+		for k, v := range h.Fields {
+			form.Set(k, v)
+		}
+		body, contentType = []byte(form.Encode()), "application/x-www-form-urlencoded"
+	default:
+		body, _ = json.Marshal(p)
+		contentType = "application/json"
+	}
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "gofire")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return errors.New(strings.TrimSpace(resp.Status))
+	}
+	return nil
+}
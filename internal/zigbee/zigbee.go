@@ -0,0 +1,46 @@
+// Package zigbee turns zigbee2mqtt button and remote events (IKEA, Aqara, ...) into
+// fireplace actions, purely through configuration.
+package zigbee
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/barrylb/go-fire/internal/actions"
+	"github.com/barrylb/go-fire/internal/config"
+	"github.com/barrylb/go-fire/internal/logging"
+	"github.com/barrylb/go-fire/internal/mqtt"
+)
+
+// Start subscribes to each configured button's topic. client may be nil only if no buttons
+// are configured.
+func Start(buttons []config.ZigbeeButton, client *mqtt.Client, runner *actions.Runner) error {
+	if len(buttons) == 0 {
+		return nil
+	}
+	if client == nil {
+		return fmt.Errorf("zigbee_buttons need mqtt.broker")
+	}
+	for _, b := range buttons {
+		for event, action := range b.Actions {
+			if !actions.Valid(action) {
+				return fmt.Errorf("zigbee button %s: %s: unknown action %q (one of %s)", b.Topic, event, action, strings.Join(actions.Names(), ", "))
+			}
+		}
+		b := b
+		client.Subscribe(b.Topic, func(topic string, payload []byte) {
+			var msg map[string]interface{}
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				logging.Logf(logging.Debug, "zigbee: %s: %v", topic, err)
+				return
+			}
+			// zigbee2mqtt publishes the whole device state; the field is "" when it's not a press
+			event, _ := msg[b.ActionField].(string)
+			if action, ok := b.Actions[event]; ok {
+				runner.Run(action, "zigbee")
+			}
+		})
+	}
+	return nil
+}
@@ -0,0 +1,560 @@
+// Package gv60 sequences the wall-switch contacts of a Mertik Maxitrol GV60 gas valve, or
+// of another valve or wiring described by a Profile.
+//
+// Mertik Maxitrol GV60 documentation:
+// http://www.ortalglobal.com/wp-content/uploads/2018/08/External-Source-Operation-Wall-Switch-Wiring-Diagram.pdf
+//
+// Each relay channel should be wired to the corresponding contact number on the valve.
+// Contacts are closed by writing 0 to the relay line and opened by writing 1.
+//
+// A Controller is safe for concurrent use, and is the only thing that should write its relay
+// lines: one goroutine of its own, the engine, writes them, running the contact sequences
+// sent on its command bus one at a time, so commands from any number of goroutines (an HTTP
+// server, a schedule, MQTT, HomeKit) can't close contacts over each other. A command arriving
+// while a sequence runs waits up to Wait for it and then fails with ErrBusy; each command
+// returns once its sequence is over, with its outcome, and is cut short with every contact
+// opened once its context is done. Submit sends a step without waiting for it, returning a
+// Future of its outcome. Outside GoFire:
+//
+//	chip, _ := relay.OpenChip("gpiochip0")
+//	ch1, _ := chip.Channel(26, false)
+//	ch2, _ := chip.Channel(20, false)
+//	ch3, _ := chip.Channel(21, false)
+//	fire := gv60.New(ch1, ch2, ch3)
+//	fire.Wait = 20 * time.Second
+//	fire.Levels, fire.Travel = 6, 12*time.Second
+//	err := fire.On(ctx)
+//	err = fire.FlameUp(ctx, 2)
+//	err = fire.SetLevel(ctx, 4)
+//
+// The relay lines are any RelayDriver, so a program with its own relay driver (an I2C board,
+// a networked relay) passes its own lines rather than a chip's.
+package gv60
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+var (
+	// ErrBusy is returned when another contact sequence is already running.
+	ErrBusy = errors.New("gv60: operation in progress")
+	// ErrLockout is returned when ignition was refused by CheckIgnition.
+	ErrLockout = errors.New("gv60: ignition locked out")
+	// ErrCancelled is returned when Cancel, or the context given to a command, cut a
+	// sequence short.
+	ErrCancelled = errors.New("gv60: cancelled")
+	// ErrUnsupported is returned for an operation the valve profile doesn't define.
+	ErrUnsupported = errors.New("gv60: not supported by this valve")
+)
+
+// RelayDriver drives the relay on one contact: SetValue(0) closes the contact and
+// SetValue(1) opens it. Every relay.Line is one.
+type RelayDriver interface {
+	SetValue(value int) error
+}
+
+// Step closes the listed contacts (1-based relay channels) for Hold, then opens them.
+type Step struct {
+	Close []int
+	Hold  time.Duration
+}
+
+// Profile defines the contact sequences of one valve model or wall-switch wiring.
+type Profile struct {
+	On, Off, FlameUp, FlameDown Step
+	// Aux switches an auxiliary output (fan, split flow, ...); nil when there is none.
+	Aux *Step
+	// AuxOn and AuxOff light and put out the second burner of a dual-burner valve; nil
+	// for single-burner valves.
+	AuxOn, AuxOff *Step
+	// Pilot turns the burner down to the pilot flame (standby), leaving the pilot lit; nil
+	// when the valve can't.
+	Pilot *Step
+	// MinGap is the least time the valve needs between the end of one sequence and the
+	// start of the next; a sequence started sooner waits for it.
+	MinGap time.Duration
+}
+
+// Profiles are the built-in profiles by name. Other valves and wirings are described in
+// the configuration.
+var Profiles = map[string]Profile{
+	// contacts 1, 2 and 3 of the GV60 wall-switch connector
+	"gv60": {
+		On:        Step{Close: []int{1, 3}, Hold: 1 * time.Second},
+		Off:       Step{Close: []int{1, 2, 3}, Hold: 1 * time.Second},
+		FlameUp:   Step{Close: []int{1}, Hold: 2 * time.Second}, // up to 12 seconds from min to full flame
+		FlameDown: Step{Close: []int{3}, Hold: 2 * time.Second}, // up to 12 seconds from full to min flame
+		// flame down held past the minimum turns the valve on to the pilot
+		Pilot: &Step{Close: []int{3}, Hold: 15 * time.Second},
+	},
+	// the GV60 with a dual-burner module on a fourth contact: the second burner is lit by
+	// pulsing contact 4 and put out by pulsing it with contact 2, as off is on with 2 added
+	"gv60_dual": {
+		On:        Step{Close: []int{1, 3}, Hold: 1 * time.Second},
+		Off:       Step{Close: []int{1, 2, 3}, Hold: 1 * time.Second},
+		FlameUp:   Step{Close: []int{1}, Hold: 2 * time.Second},
+		FlameDown: Step{Close: []int{3}, Hold: 2 * time.Second},
+		AuxOn:     &Step{Close: []int{4}, Hold: 1 * time.Second},
+		AuxOff:    &Step{Close: []int{2, 4}, Hold: 1 * time.Second},
+		Pilot:     &Step{Close: []int{3}, Hold: 15 * time.Second},
+	},
+}
+
+// Controller runs one contact sequence at a time on the valve's relay channels.
+type Controller struct {
+	lines   []RelayDriver // lines[i] drives contact i+1; written by the engine alone
+	profile *sharedProfile
+	sem     *semaphore.Weighted // held from a request's sending until it is over
+	bus     chan request
+	run     *running
+
+	// CheckIgnition, if set, is consulted before ignition; an error refuses it and is
+	// returned wrapped in ErrLockout.
+	CheckIgnition func() error
+
+	// Wait is how long a command waits for a running sequence to finish before failing
+	// with ErrBusy; zero fails at once.
+	Wait time.Duration
+
+	// Levels is how many flame levels SetLevel sets, and Travel how long the valve takes to
+	// move the flame from lowest to highest; SetLevel fails while either is zero.
+	Levels int
+	Travel time.Duration
+
+	// DryRun makes every sequence a dry run (see DryRun).
+	DryRun bool
+	// Logf, if set, is told the contacts each dry run would have closed.
+	Logf func(format string, args ...interface{})
+}
+
+type dryRunKey struct{}
+
+// DryRun returns ctx for sequences run as a dry run when given to a command: each waits for the relays, holds and can be cancelled as usual, but leaves
+// every relay line alone, telling Logf the contacts it would have closed instead.
+func DryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// IsDryRun reports whether ctx is for dry runs.
+func IsDryRun(ctx context.Context) bool {
+	return ctx.Value(dryRunKey{}) != nil
+}
+
+// sharedProfile is the profile, which SetProfile may replace.
+type sharedProfile struct {
+	mu sync.Mutex
+	p  Profile
+}
+
+// running is the state of the sequence running, or of the last to run.
+type running struct {
+	mu      sync.Mutex
+	cancel  chan struct{} // closed by Cancel; nil when no sequence is running
+	due     time.Time     // when the running sequence should end
+	lastEnd time.Time
+}
+
+// stuckAfter is how long past its end a sequence may run before Check reports it stuck.
+const stuckAfter = 10 * time.Second
+
+// New returns a GV60 controller for relay lines wired to contacts 1, 2 and 3.
+func New(ch1, ch2, ch3 RelayDriver) *Controller {
+	c, _ := NewProfile(Profiles["gv60"], ch1, ch2, ch3)
+	return c
+}
+
+// NewProfile returns a controller running profile's sequences on lines, which are wired
+// to contacts 1, 2, 3 and so on.
+func NewProfile(profile Profile, lines ...RelayDriver) (*Controller, error) {
+	if err := profile.Check(len(lines)); err != nil {
+		return nil, err
+	}
+	c := &Controller{lines: lines, profile: &sharedProfile{p: profile}, sem: semaphore.NewWeighted(1),
+		bus: make(chan request, 1), run: &running{}}
+	go c.engine()
+	return c, nil
+}
+
+// Check reports why p can't run on n relay lines, if it can't.
+func (p Profile) Check(n int) error {
+	steps := []Step{p.On, p.Off, p.FlameUp, p.FlameDown}
+	for _, st := range []*Step{p.Aux, p.AuxOn, p.AuxOff, p.Pilot} {
+		if st != nil {
+			steps = append(steps, *st)
+		}
+	}
+	for _, st := range steps {
+		if st.Hold <= 0 {
+			return errors.New("gv60: every step needs a hold time")
+		}
+		for _, c := range st.Close {
+			if c < 1 || c > n {
+				return fmt.Errorf("gv60: contact %d has no relay line", c)
+			}
+		}
+	}
+	return nil
+}
+
+// Profile returns the profile c runs.
+func (c *Controller) Profile() Profile {
+	c.profile.mu.Lock()
+	defer c.profile.mu.Unlock()
+	return c.profile.p
+}
+
+// SetProfile replaces the profile c runs from the next sequence on, as when the pulse
+// timings are changed in the configuration; a sequence already running keeps to the old.
+func (c *Controller) SetProfile(p Profile) error {
+	if err := p.Check(len(c.lines)); err != nil {
+		return err
+	}
+	c.profile.mu.Lock()
+	c.profile.p = p
+	c.profile.mu.Unlock()
+	return nil
+}
+
+// Off turns the fire off.
+func (c *Controller) Off(ctx context.Context) error {
+	return c.Submit(ctx, c.Profile().Off).Wait()
+}
+
+// On lights the fire, unless CheckIgnition refuses.
+func (c *Controller) On(ctx context.Context) error {
+	if c.CheckIgnition != nil {
+		if err := c.CheckIgnition(); err != nil {
+			return fmt.Errorf("%w: %v", ErrLockout, err)
+		}
+	}
+	return c.Submit(ctx, c.Profile().On).Wait()
+}
+
+// FlameUp raises the flame by steps steps, one sequence after another, stopping at the
+// first that fails.
+func (c *Controller) FlameUp(ctx context.Context, steps int) error {
+	return c.flameSteps(ctx, c.Profile().FlameUp, steps)
+}
+
+// FlameDown lowers the flame by steps steps, as FlameUp raises it.
+func (c *Controller) FlameDown(ctx context.Context, steps int) error {
+	return c.flameSteps(ctx, c.Profile().FlameDown, steps)
+}
+
+func (c *Controller) flameSteps(ctx context.Context, st Step, n int) error {
+	for ; n > 0; n-- {
+		if err := c.Submit(ctx, st).Wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Aux pulses the auxiliary output, or returns ErrUnsupported if the profile has none.
+func (c *Controller) Aux(ctx context.Context) error {
+	st := c.Profile().Aux
+	if st == nil {
+		return ErrUnsupported
+	}
+	return c.Submit(ctx, *st).Wait()
+}
+
+// AuxOn lights the second burner, or returns ErrUnsupported if the profile has none.
+func (c *Controller) AuxOn(ctx context.Context) error {
+	st := c.Profile().AuxOn
+	if st == nil {
+		return ErrUnsupported
+	}
+	return c.Submit(ctx, *st).Wait()
+}
+
+// AuxOff puts out the second burner, or returns ErrUnsupported if the profile has none.
+func (c *Controller) AuxOff(ctx context.Context) error {
+	st := c.Profile().AuxOff
+	if st == nil {
+		return ErrUnsupported
+	}
+	return c.Submit(ctx, *st).Wait()
+}
+
+// FlameFor holds the flame up (up) or flame down contacts for d rather than the profile's
+// hold time, moving the flame by as much as d allows.
+func (c *Controller) FlameFor(ctx context.Context, up bool, d time.Duration) error {
+	p := c.Profile()
+	st := p.FlameDown
+	if up {
+		st = p.FlameUp
+	}
+	return c.Submit(ctx, Step{Close: st.Close, Hold: d}).Wait()
+}
+
+// SetLevel sets a lit fire's flame to level, from 1 (lowest) to Levels (highest); level 0
+// turns the fire down to its pilot. The valve says nothing of where the flame is, so it is
+// first driven right down, for the whole Travel time, and then up for level's share of it:
+// up to twice the travel time, with other commands free to run between the two.
+func (c *Controller) SetLevel(ctx context.Context, level int) error {
+	levels, travel := c.Levels, c.Travel
+	if levels < 1 || level < 0 || level > levels || travel <= 0 {
+		return fmt.Errorf("gv60: can't set level %d of %d", level, levels)
+	}
+	if level == 0 {
+		return c.Pilot(ctx)
+	}
+	if err := c.FlameFor(ctx, false, travel); err != nil || level == 1 {
+		return err
+	}
+	// level 1 is the bottom of the travel and Levels the top
+	return c.FlameFor(ctx, true, time.Duration(float64(travel)*float64(level-1)/float64(levels-1)))
+}
+
+// Pilot turns the fire down to the pilot flame, or returns ErrUnsupported if the profile
+// has no pilot sequence.
+func (c *Controller) Pilot(ctx context.Context) error {
+	st := c.Profile().Pilot
+	if st == nil {
+		return ErrUnsupported
+	}
+	return c.Submit(ctx, *st).Wait()
+}
+
+// Future is the outcome of a command sent on a controller's bus, delivered once its
+// sequence is over.
+type Future struct {
+	done chan struct{}
+	err  error
+	errs []error // each line's write error, for an exercise
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// resolve delivers the outcome; it is called once.
+func (f *Future) resolve(errs []error, err error) *Future {
+	f.errs, f.err = errs, err
+	close(f.done)
+	return f
+}
+
+// Done is closed once the command's sequence is over.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits for the command's sequence to be over and returns its outcome, as the
+// controller's methods do.
+func (f *Future) Wait() error {
+	<-f.done
+	return f.err
+}
+
+// request is a command on the bus: a step to run, or the relays to exercise, with what the
+// sender took for it and the future the engine delivers its outcome to.
+type request struct {
+	ctx      context.Context
+	step     Step
+	exercise bool          // close each contact on its own for step.Hold instead
+	cancel   chan struct{} // closed by Cancel
+	gap      time.Duration // left of the profile's MinGap since the last sequence
+	future   *Future
+}
+
+// Submit sends st on the command bus and returns at once, the contacts being closed for
+// st.Hold and opened again by the engine, unless another sequence is running. The future
+// fails with ErrBusy, after waiting up to Wait, as the other commands do; Cancel, or ctx being
+// done, cuts the wait and the hold short. The controller's methods are Submit waited on.
+func (c *Controller) Submit(ctx context.Context, st Step) *Future {
+	for _, n := range st.Close {
+		if n < 1 || n > len(c.lines) {
+			return newFuture().resolve(nil, fmt.Errorf("gv60: contact %d has no relay line", n))
+		}
+	}
+	return c.submit(ctx, request{step: st}, st.Hold)
+}
+
+// submit takes the relays for req, which holds them for d, and sends it to the engine.
+func (c *Controller) submit(ctx context.Context, req request, d time.Duration) *Future {
+	req.ctx, req.future = ctx, newFuture()
+	if ctx.Err() != nil {
+		return req.future.resolve(nil, ErrCancelled)
+	}
+	if !c.acquire(ctx) {
+		if ctx.Err() != nil {
+			return req.future.resolve(nil, ErrCancelled)
+		}
+		return req.future.resolve(nil, ErrBusy)
+	}
+	req.cancel = make(chan struct{})
+	c.run.mu.Lock()
+	c.run.cancel = req.cancel
+	req.gap = c.Profile().MinGap - time.Since(c.run.lastEnd)
+	c.run.due = time.Now().Add(d)
+	if req.gap > 0 {
+		c.run.due = c.run.due.Add(req.gap)
+	}
+	c.run.mu.Unlock()
+	c.bus <- req
+	return req.future
+}
+
+// engine is the one goroutine that writes the relay lines: it runs the requests on the bus
+// one after the other, the semaphore being held by each from its sending until it is over.
+func (c *Controller) engine() {
+	for req := range c.bus {
+		var errs []error
+		var err error
+		switch {
+		case req.gap > 0 && !hold(req.gap, req.cancel, req.ctx.Done()) || req.ctx.Err() != nil:
+			err = ErrCancelled
+		case req.exercise:
+			errs, err = c.exercise(req)
+		default:
+			err = c.sequence(req)
+		}
+		c.run.mu.Lock()
+		c.run.cancel = nil
+		c.run.lastEnd = time.Now()
+		c.run.mu.Unlock()
+		c.sem.Release(1)
+		req.future.resolve(errs, err)
+	}
+}
+
+// sequence closes the step's contacts, holds them and opens every contact again.
+func (c *Controller) sequence(req request) error {
+	st := req.step
+	if c.DryRun || IsDryRun(req.ctx) {
+		if c.Logf != nil {
+			c.Logf("gv60: dry run: contacts %v closed for %v", st.Close, st.Hold)
+		}
+		if !hold(st.Hold, req.cancel, req.ctx.Done()) {
+			return ErrCancelled
+		}
+		return nil
+	}
+	for i, l := range c.lines {
+		v := 1
+		for _, n := range st.Close {
+			if n == i+1 {
+				v = 0
+			}
+		}
+		l.SetValue(v)
+	}
+	var err error
+	if !hold(st.Hold, req.cancel, req.ctx.Done()) {
+		err = ErrCancelled
+	}
+	for _, l := range c.lines {
+		l.SetValue(1)
+	}
+	return err
+}
+
+// Exercise closes each contact on its own for d and opens it again, one after the other
+// with d between them, as a self-test of the relays. It returns the error writing each
+// contact's line, nil where both writes took, after waiting for the relays like a sequence;
+// Cancel, or ctx being done, cuts it short, opening every contact. A dry run writes nothing.
+//
+// A contact closed on its own may move the valve, as flame up or down does, so it is
+// for a fire that is off.
+func (c *Controller) Exercise(ctx context.Context, d time.Duration) ([]error, error) {
+	f := c.submit(ctx, request{step: Step{Hold: d}, exercise: true}, time.Duration(2*len(c.lines))*d)
+	err := f.Wait()
+	return f.errs, err
+}
+
+// exercise runs an Exercise on the engine.
+func (c *Controller) exercise(req request) ([]error, error) {
+	d := req.step.Hold
+	errs := make([]error, len(c.lines))
+	for i, l := range c.lines {
+		if i > 0 && !hold(d, req.cancel, req.ctx.Done()) {
+			return errs, ErrCancelled
+		}
+		if c.DryRun || IsDryRun(req.ctx) {
+			if c.Logf != nil {
+				c.Logf("gv60: dry run: contact %d closed for %v", i+1, d)
+			}
+			if !hold(d, req.cancel, req.ctx.Done()) {
+				return errs, ErrCancelled
+			}
+			continue
+		}
+		errs[i] = l.SetValue(0)
+		held := hold(d, req.cancel, req.ctx.Done())
+		if err := l.SetValue(1); err != nil && errs[i] == nil {
+			errs[i] = err
+		}
+		if !held {
+			for _, l := range c.lines {
+				l.SetValue(1)
+			}
+			return errs, ErrCancelled
+		}
+	}
+	return errs, nil
+}
+
+// hold waits for d, returning false if cancel or done is closed first.
+func hold(d time.Duration, cancel chan struct{}, done <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-cancel:
+		return false
+	case <-done:
+		return false
+	}
+}
+
+// Cancel cuts short the running contact sequence, if any, opening every contact at once.
+// It reports whether a sequence was running.
+func (c *Controller) Cancel() bool {
+	c.run.mu.Lock()
+	defer c.run.mu.Unlock()
+	if c.run.cancel == nil {
+		return false
+	}
+	close(c.run.cancel)
+	c.run.cancel = nil
+	return true
+}
+
+// Check reports an error when the running contact sequence has gone well past the end of
+// its hold, as when it is wedged and every other command would fail with ErrBusy.
+func (c *Controller) Check() error {
+	c.run.mu.Lock()
+	defer c.run.mu.Unlock()
+	if c.run.cancel == nil {
+		return nil
+	}
+	if late := time.Since(c.run.due); late > stuckAfter {
+		return fmt.Errorf("gv60: a contact sequence is %v past its end", late.Round(time.Second))
+	}
+	return nil
+}
+
+// acquire takes the semaphore, waiting up to c.Wait for it unless ctx is done first.
+func (c *Controller) acquire(ctx context.Context) bool {
+	if c.Wait <= 0 {
+		return c.sem.TryAcquire(1)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.Wait)
+	defer cancel()
+	return c.sem.Acquire(ctx, 1) == nil
+}
+
+// Drain waits for a running contact sequence to finish and then refuses new ones with
+// ErrBusy, leaving every contact open so the relay lines can be handed to another process.
+func (c *Controller) Drain() {
+	c.sem.Acquire(context.Background(), 1)
+}
@@ -0,0 +1,225 @@
+package gv60
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/barrylb/go-fire/pkg/relay"
+)
+
+// mockValve returns a controller running p, its holds shortened to 1ms, on mock relay
+// channels 26, 20 and 21 (those of the Waveshare board), and the transitions those channels
+// log.
+func mockValve(t *testing.T, p Profile) (*Controller, func() []string) {
+	var mu sync.Mutex
+	var log []string
+	chip := relay.OpenMock(func(format string, args ...interface{}) {
+		mu.Lock()
+		log = append(log, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	})
+	var lines []RelayDriver
+	for _, gpio := range []int{26, 20, 21} {
+		l, err := chip.Channel(gpio, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, l)
+	}
+	short := func(st Step) Step { return Step{Close: st.Close, Hold: time.Millisecond} }
+	p.On, p.Off, p.FlameUp, p.FlameDown = short(p.On), short(p.Off), short(p.FlameUp), short(p.FlameDown)
+	if p.Pilot != nil {
+		st := short(*p.Pilot)
+		p.Pilot = &st
+	}
+	c, err := NewProfile(p, lines...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), log...)
+	}
+}
+
+func TestSequences(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(c *Controller, ctx context.Context) error
+		want []string
+	}{
+		{"on", (*Controller).On, []string{
+			"mock gpio: line 26 closed", "mock gpio: line 21 closed",
+			"mock gpio: line 26 open", "mock gpio: line 21 open",
+		}},
+		{"off", (*Controller).Off, []string{
+			"mock gpio: line 26 closed", "mock gpio: line 20 closed", "mock gpio: line 21 closed",
+			"mock gpio: line 26 open", "mock gpio: line 20 open", "mock gpio: line 21 open",
+		}},
+		{"flameup", func(c *Controller, ctx context.Context) error { return c.FlameUp(ctx, 2) }, []string{
+			"mock gpio: line 26 closed", "mock gpio: line 26 open",
+			"mock gpio: line 26 closed", "mock gpio: line 26 open",
+		}},
+		{"flamedown", func(c *Controller, ctx context.Context) error { return c.FlameDown(ctx, 1) }, []string{
+			"mock gpio: line 21 closed", "mock gpio: line 21 open",
+		}},
+		{"pilot", (*Controller).Pilot, []string{
+			"mock gpio: line 21 closed", "mock gpio: line 21 open",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, log := mockValve(t, Profiles["gv60"])
+			if err := tt.run(c, context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := log(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("relay transitions:\ngot  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefusals(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name  string
+		setup func(c *Controller)
+		run   func(c *Controller) error
+		want  error
+	}{
+		{"aux without one", nil,
+			func(c *Controller) error { return c.Aux(context.Background()) }, ErrUnsupported},
+		{"second burner without one", nil,
+			func(c *Controller) error { return c.AuxOn(context.Background()) }, ErrUnsupported},
+		{"ignition locked out", func(c *Controller) { c.CheckIgnition = func() error { return errors.New("flame failure") } },
+			func(c *Controller) error { return c.On(context.Background()) }, ErrLockout},
+		{"context done", nil,
+			func(c *Controller) error { return c.Off(cancelled) }, ErrCancelled},
+		{"drained", func(c *Controller) { c.Drain() },
+			func(c *Controller) error { return c.Off(context.Background()) }, ErrBusy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, log := mockValve(t, Profiles["gv60"])
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+			if err := tt.run(c); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+			if got := log(); len(got) > 0 {
+				t.Errorf("relays moved: %q", got)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	c, log := mockValve(t, Profiles["gv60"])
+	var dry []string
+	c.Logf = func(format string, args ...interface{}) { dry = append(dry, fmt.Sprintf(format, args...)) }
+	if err := c.On(DryRun(context.Background())); err != nil {
+		t.Fatal(err)
+	}
+	if got := log(); len(got) > 0 {
+		t.Errorf("a dry run moved the relays: %q", got)
+	}
+	if len(dry) != 1 {
+		t.Errorf("dry run logged %q, want one line", dry)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	const travel = 100 * time.Millisecond
+	tests := []struct {
+		level, levels int
+		up            time.Duration // how long the flame up contact is held after the drive down
+		wantErr       bool
+	}{
+		{level: 6, levels: 6, up: travel},
+		{level: 4, levels: 5, up: travel * 3 / 4},
+		{level: 1, levels: 6},
+		{level: 1, levels: 1},
+		{level: 7, levels: 6, wantErr: true},
+		{level: 2, levels: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d of %d", tt.level, tt.levels), func(t *testing.T) {
+			c, _ := mockValve(t, Profiles["gv60"])
+			c.Levels, c.Travel = tt.levels, travel
+			start := time.Now()
+			err := c.SetLevel(context.Background(), tt.level)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the drive down takes the whole travel time, then the drive up its share
+			if took, want := time.Since(start), travel+tt.up; took < want || took > want+travel/2 {
+				t.Errorf("took %v, want about %v", took, want)
+			}
+		})
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	c, log := mockValve(t, Profiles["gv60"])
+	long := Step{Close: []int{1}, Hold: time.Hour}
+	f := c.Submit(context.Background(), long)
+	select {
+	case <-f.Done():
+		t.Fatalf("the future was delivered while the sequence ran: %v", f.Wait())
+	case <-time.After(10 * time.Millisecond):
+	}
+	if err := c.Off(context.Background()); !errors.Is(err, ErrBusy) {
+		t.Errorf("a command during the sequence: got %v, want %v", err, ErrBusy)
+	}
+	if !c.Cancel() {
+		t.Error("Cancel found no sequence running")
+	}
+	if err := f.Wait(); !errors.Is(err, ErrCancelled) {
+		t.Errorf("cancelled sequence: got %v, want %v", err, ErrCancelled)
+	}
+	want := []string{"mock gpio: line 26 closed", "mock gpio: line 26 open"}
+	if got := log(); !reflect.DeepEqual(got, want) {
+		t.Errorf("relay transitions:\ngot  %q\nwant %q", got, want)
+	}
+	if err := c.Submit(context.Background(), Step{Close: []int{4}, Hold: time.Millisecond}).Wait(); err == nil {
+		t.Error("a step on a contact without a relay line ran")
+	}
+}
+
+func TestSubmitWaits(t *testing.T) {
+	c, log := mockValve(t, Profiles["gv60"])
+	c.Wait = time.Second
+	var futures []*Future
+	for _, n := range []int{1, 2, 3} {
+		futures = append(futures, c.Submit(context.Background(), Step{Close: []int{n}, Hold: 5 * time.Millisecond}))
+	}
+	for _, f := range futures {
+		if err := f.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// each sequence opens its contact before the next closes one
+	want := []string{
+		"mock gpio: line 26 closed", "mock gpio: line 26 open",
+		"mock gpio: line 20 closed", "mock gpio: line 20 open",
+		"mock gpio: line 21 closed", "mock gpio: line 21 open",
+	}
+	if got := log(); !reflect.DeepEqual(got, want) {
+		t.Errorf("relay transitions:\ngot  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package relay
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// I2C_SLAVE from linux/i2c-dev.h
+const i2cSlave = 0x0703
+
+// MCP23017 registers, with IOCON.BANK 0 (the default): each A register is followed by its
+// B one, written together.
+const (
+	mcpIODIRA = 0x00
+	mcpOLATA  = 0x14
+)
+
+// Expanders are the I2C port expanders OpenExpander drives, with how many pins each has.
+var Expanders = map[string]int{"mcp23017": 16, "pcf8574": 8}
+
+// expander is a relay board behind an I2C port expander, each pin driving one relay.
+type expander struct {
+	name       string // device and address, for errors
+	model      string
+	pins       int
+	activeHigh bool
+
+	mu  sync.Mutex
+	f   *os.File
+	out uint16 // the pins' levels as last written
+}
+
+// OpenExpander opens the relay board behind the I2C port expander model (mcp23017 or pcf8574)
+// at address on device, e.g. /dev/i2c-1, and opens every relay. The board's channels are the
+// expander's pins: 0 to 15 (GPA0 to GPA7, then GPB0 to GPB7) on an MCP23017, 0 to 7 (P0 to
+// P7) on a PCF8574. With activeHigh a high pin energises its relay; the channels keep to it
+// whatever Channel is given.
+func OpenExpander(model, device string, address int, activeHigh bool) (*Chip, error) {
+	pins, ok := Expanders[model]
+	if !ok {
+		return nil, fmt.Errorf("unknown I2C expander %q", model)
+	}
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), i2cSlave, uintptr(address)); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s: select address %#x: %v", device, address, errno)
+	}
+	x := &expander{name: fmt.Sprintf("%s %s %#x", model, device, address), model: model, pins: pins,
+		activeHigh: activeHigh, f: f}
+	if !activeHigh {
+		x.out = 1<<uint(pins) - 1
+	}
+	// the open levels are latched before the MCP23017's pins become outputs, so that no
+	// relay clicks
+	err = x.write()
+	if err == nil && model == "mcp23017" {
+		_, err = f.Write([]byte{mcpIODIRA, 0, 0})
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", x.name, err)
+	}
+	return &Chip{x: x}, nil
+}
+
+// write sets the pins to out; callers must hold mu, bar OpenExpander.
+func (x *expander) write() error {
+	var err error
+	switch x.model {
+	case "mcp23017":
+		_, err = x.f.Write([]byte{mcpOLATA, byte(x.out), byte(x.out >> 8)})
+	case "pcf8574":
+		_, err = x.f.Write([]byte{byte(x.out)})
+	}
+	return err
+}
+
+// set drives pin to close its relay for value 0 and open it for 1.
+func (x *expander) set(pin, value int) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	prev := x.out
+	if (value == 0) == x.activeHigh {
+		x.out |= 1 << uint(pin)
+	} else {
+		x.out &^= 1 << uint(pin)
+	}
+	if err := x.write(); err != nil {
+		x.out = prev
+		return fmt.Errorf("%s: pin %d: %v", x.name, pin, err)
+	}
+	return nil
+}
+
+// check reports an error when the expander doesn't answer, or an MCP23017's pins are no
+// longer outputs, as after the board lost power and reset.
+func (x *expander) check() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	buf := make([]byte, 2)
+	switch x.model {
+	case "mcp23017":
+		if _, err := x.f.Write([]byte{mcpIODIRA}); err != nil {
+			return fmt.Errorf("%s: %v", x.name, err)
+		}
+		if _, err := x.f.Read(buf); err != nil {
+			return fmt.Errorf("%s: %v", x.name, err)
+		}
+		if buf[0] != 0 || buf[1] != 0 {
+			return fmt.Errorf("%s: the pins are no longer outputs", x.name)
+		}
+	case "pcf8574":
+		if _, err := x.f.Read(buf[:1]); err != nil {
+			return fmt.Errorf("%s: %v", x.name, err)
+		}
+	}
+	return nil
+}
+
+func (x *expander) close() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.f.Close()
+}
+
+// expanderLine is a relay channel on an expander's pin.
+type expanderLine struct {
+	x   *expander
+	pin int
+}
+
+func (l expanderLine) SetValue(value int) error {
+	return l.x.set(l.pin, value)
+}
+
+func (l expanderLine) Close() error {
+	return nil
+}
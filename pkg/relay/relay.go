@@ -0,0 +1,332 @@
+// Package relay drives relay board channels from GPIO lines, or from the pins of an I2C port
+// expander (MCP23017 or PCF8574) on the 8 and 16 channel boards that have one.
+//
+// The Waveshare RPi Relay Board (https://www.waveshare.com/wiki/RPi_Relay_Board) is
+// active-low: writing 0 energises the relay and closes its contact, 1 opens it again.
+// Channels from Chip.Channel keep those values on active-high boards too.
+//
+// Expander boards from OpenExpander are attached to the GPIO chip by name, so that whatever
+// holds the chip can find them with Board, and are checked and closed with it.
+//
+// A mock chip from OpenMock drives no hardware and logs the relay transitions instead, so
+// GoFire runs on a laptop or in CI.
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// Line drives one output, such as a relay channel.
+type Line interface {
+	SetValue(value int) error
+}
+
+// Chip hands out output lines from a GPIO character device, or relay channels from an
+// expander board.
+type Chip struct {
+	c    *gpiocdev.Chip                           // nil for a mock chip or an expander board
+	x    *expander                                // nil unless an expander board
+	logf func(format string, args ...interface{}) // a mock chip's log
+
+	mu       sync.Mutex
+	channels []channel        // opened by Close
+	boards   map[string]*Chip // attached with Attach
+}
+
+// channel is a relay channel handed out by Channel.
+type channel struct {
+	offset int
+	line   interface {
+		SetValue(value int) error
+		Close() error
+	}
+}
+
+// OpenChip opens the named GPIO chip, e.g. "gpiochip0" or "/dev/gpiochip4", or the chip
+// with that label, e.g. "pinctrl-rp1" for a Pi 5's header whichever gpiochip it is. When
+// the process may not open it, the error names the group that may.
+func OpenChip(name string) (*Chip, error) {
+	if !strings.HasPrefix(name, "/dev/") && !strings.HasPrefix(name, "gpiochip") {
+		return openLabel(name)
+	}
+	c, err := gpiocdev.NewChip(name)
+	if errors.Is(err, os.ErrPermission) {
+		return nil, permissionError(name, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Chip{c: c}, nil
+}
+
+// openLabel opens the chip labelled label. The error lists the chips there are.
+func openLabel(label string) (*Chip, error) {
+	var found []string
+	for _, name := range gpiocdev.Chips() {
+		c, err := gpiocdev.NewChip(name)
+		if errors.Is(err, os.ErrPermission) {
+			return nil, permissionError(name, err)
+		}
+		if err != nil {
+			continue
+		}
+		if c.Label == label {
+			return &Chip{c: c}, nil
+		}
+		found = append(found, c.Name+" ("+c.Label+")")
+		c.Close()
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no GPIO chip labelled %q: no chips found", label)
+	}
+	return nil, fmt.Errorf("no GPIO chip labelled %q; there are %s", label, strings.Join(found, ", "))
+}
+
+// OpenMock returns a chip whose lines drive nothing: relay channels log each change with
+// logf, and inputs read inactive.
+func OpenMock(logf func(format string, args ...interface{})) *Chip {
+	return &Chip{logf: logf}
+}
+
+// Attach adds board, as from OpenExpander or OpenMock, under name; it is then checked and
+// closed with c.
+func (c *Chip) Attach(name string, board *Chip) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.boards == nil {
+		c.boards = map[string]*Chip{}
+	}
+	c.boards[name] = board
+}
+
+// Board returns the board attached as name, or c itself for an empty name.
+func (c *Chip) Board(name string) (*Chip, error) {
+	if name == "" {
+		return c, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.boards[name]
+	if !ok {
+		return nil, fmt.Errorf("no relay board %q", name)
+	}
+	return b, nil
+}
+
+// permissionError explains how to let an unprivileged user open the chip.
+func permissionError(name string, err error) error {
+	path := name
+	if !strings.HasPrefix(path, "/dev/") {
+		path = "/dev/" + name
+	}
+	group := "gpio"
+	var st syscall.Stat_t
+	if syscall.Stat(path, &st) == nil {
+		if g, gerr := user.LookupGroupId(strconv.Itoa(int(st.Gid))); gerr == nil {
+			group = g.Name
+		}
+	}
+	if group == "root" {
+		return fmt.Errorf("%v: only root may open %s; add a udev rule giving a gpio group access, e.g. "+
+			`SUBSYSTEM=="gpio", KERNEL=="gpiochip*", GROUP="gpio", MODE="0660"`, err, path)
+	}
+	return fmt.Errorf("%v: %s is open to group %s; add the user to it (usermod -aG %s USER) and log in again",
+		err, path, group, group)
+}
+
+// Output requests the line at offset as an output, initially set to value.
+func (c *Chip) Output(offset, value int) (Line, error) {
+	if c.x != nil {
+		return nil, fmt.Errorf("%s: an expander board has relay channels only", c.x.name)
+	}
+	if c.c == nil {
+		return &mockLine{offset: offset, value: value}, nil
+	}
+	return c.c.RequestLine(offset, gpiocdev.AsOutput(value))
+}
+
+// Channel requests the line at offset as a relay channel, initially open. Writing 0 closes
+// the contact and 1 opens it whatever the board: on an active-high board the line is
+// requested inverted. On an expander board offset is the pin.
+func (c *Chip) Channel(offset int, activeHigh bool) (Line, error) {
+	var l channel
+	if c.x != nil {
+		if offset < 0 || offset >= c.x.pins {
+			return nil, fmt.Errorf("%s: pin %d: there are pins 0 to %d", c.x.name, offset, c.x.pins-1)
+		}
+		l = channel{offset, expanderLine{c.x, offset}}
+	} else if c.c == nil {
+		l = channel{offset, &mockLine{offset: offset, value: 1, logf: c.logf}}
+	} else {
+		opts := []gpiocdev.LineReqOption{gpiocdev.AsOutput(1)}
+		if activeHigh {
+			opts = append(opts, gpiocdev.AsActiveLow)
+		}
+		gl, err := c.c.RequestLine(offset, opts...)
+		if err != nil {
+			return nil, err
+		}
+		l = channel{offset, gl}
+	}
+	c.mu.Lock()
+	c.channels = append(c.channels, l)
+	c.mu.Unlock()
+	return l.line, nil
+}
+
+// InputLine reads one input, such as a contact wired to another system.
+type InputLine interface {
+	Value() (int, error)
+}
+
+// Input requests the line at offset as an input with the pull-up enabled; with activeLow,
+// a line pulled to ground reads 1.
+func (c *Chip) Input(offset int, activeLow bool) (InputLine, error) {
+	if c.x != nil {
+		return nil, fmt.Errorf("%s: an expander board has relay channels only", c.x.name)
+	}
+	if c.c == nil {
+		return &mockLine{offset: offset}, nil
+	}
+	opts := []gpiocdev.LineReqOption{gpiocdev.AsInput, gpiocdev.WithPullUp}
+	if activeLow {
+		opts = append(opts, gpiocdev.AsActiveLow)
+	}
+	return c.c.RequestLine(offset, opts...)
+}
+
+// Check reports an error when the chip can no longer be queried or a relay channel is no
+// longer requested as an output, as after the device went away or the line was released,
+// and likewise for each attached board. A mock chip always passes.
+func (c *Chip) Check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.x != nil:
+		if err := c.x.check(); err != nil {
+			return err
+		}
+	case c.c != nil:
+		if _, err := c.c.LineInfo(0); err != nil {
+			return fmt.Errorf("GPIO chip %s: %v", c.c.Name, err)
+		}
+		for _, ch := range c.channels {
+			if err := c.checkLine(ch.offset); err != nil {
+				return err
+			}
+		}
+	}
+	for name, b := range c.boards {
+		if err := b.Check(); err != nil {
+			return fmt.Errorf("relay board %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// CheckLine is Check for the relay channel at offset alone: it reports an error when the
+// line can't be queried or isn't requested as an output. A mock chip always passes.
+func (c *Chip) CheckLine(offset int) error {
+	if c.c == nil && c.x == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checkLine(offset)
+}
+
+// checkLine is CheckLine; callers must hold mu.
+func (c *Chip) checkLine(offset int) error {
+	if c.x != nil {
+		for _, ch := range c.channels {
+			if ch.offset == offset {
+				return c.x.check()
+			}
+		}
+		return fmt.Errorf("pin %d isn't a relay channel", offset)
+	}
+	info, err := c.c.LineInfo(offset)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", offset, err)
+	}
+	if !info.Used || info.Config.Direction != gpiocdev.LineDirectionOutput {
+		return fmt.Errorf("line %d is no longer requested as an output", offset)
+	}
+	return nil
+}
+
+// Close opens every relay channel (sets it to 1) and releases it, then releases the chip,
+// so that no contact is left closed once the process has gone, and closes the attached
+// boards likewise. It returns the first error but always gets as far as it can.
+func (c *Chip) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var first error
+	for _, ch := range c.channels {
+		if err := ch.line.SetValue(1); err != nil && first == nil {
+			first = fmt.Errorf("opening line %d: %v", ch.offset, err)
+		}
+		if err := ch.line.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	c.channels = nil
+	for name, b := range c.boards {
+		if err := b.Close(); err != nil && first == nil {
+			first = fmt.Errorf("relay board %s: %v", name, err)
+		}
+	}
+	var err error
+	switch {
+	case c.x != nil:
+		err = c.x.close()
+	case c.c != nil:
+		err = c.c.Close()
+	}
+	if err != nil && first == nil {
+		first = err
+	}
+	return first
+}
+
+// mockLine is a line of a mock chip. Relay channels log their changes; other outputs, such
+// as a software-PWM light, change too often to log.
+type mockLine struct {
+	offset int
+	logf   func(format string, args ...interface{}) // nil unless a relay channel
+
+	mu    sync.Mutex
+	value int
+}
+
+func (l *mockLine) SetValue(value int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.logf != nil && value != l.value {
+		state := "open"
+		if value == 0 {
+			state = "closed"
+		}
+		l.logf("mock gpio: line %d %s", l.offset, state)
+	}
+	l.value = value
+	return nil
+}
+
+// Value reads an input, which is always inactive.
+func (l *mockLine) Value() (int, error) {
+	return 0, nil
+}
+
+func (l *mockLine) Close() error {
+	return nil
+}